@@ -0,0 +1,893 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ocowchun/soup/evaluator"
+	"github.com/ocowchun/soup/lexer"
+	"github.com/ocowchun/soup/parser"
+)
+
+// `soup dap` is a debug adapter speaking the Debug Adapter Protocol on stdin and stdout, so editors
+// can debug soup programs. A launch request runs a file, like `soup file` does, and the program
+// stops at breakpoints set on the lines of it or of the files it loads, steps over, into and out of
+// procedure calls, and pauses. While it is stopped the variables of every frame can be inspected and
+// expressions evaluated in them. An uncaught error stops the program in the frame that raised it,
+// like -post-mortem, before it exits.
+//
+// Frames and steps only cover the program and the files breakpoints are set in, code from elsewhere,
+// like the prelude, runs as if it were a builtin.
+
+// dapThreadID is the thread of the program, soup runs one
+const dapThreadID = 1
+
+// dapValueLimits keeps the value of a variable on one line, its elements are shown by expanding it
+var dapValueLimits = evaluator.PrintLimits{MaxDepth: 3, MaxLength: 10}
+
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type dapResponse struct {
+	Seq        int    `json:"seq"`
+	Type       string `json:"type"`
+	RequestSeq int    `json:"request_seq"`
+	Success    bool   `json:"success"`
+	Command    string `json:"command"`
+	Message    string `json:"message,omitempty"`
+	Body       any    `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq   int    `json:"seq"`
+	Type  string `json:"type"`
+	Event string `json:"event"`
+	Body  any    `json:"body,omitempty"`
+}
+
+type dapSource struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+type dapStackFrame struct {
+	ID     int        `json:"id"`
+	Name   string     `json:"name"`
+	Source *dapSource `json:"source,omitempty"`
+	Line   int        `json:"line"`
+	Column int        `json:"column"`
+}
+
+type dapScope struct {
+	Name               string `json:"name"`
+	PresentationHint   string `json:"presentationHint,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+type dapVariable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	Type               string `json:"type"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+type dapLaunchArguments struct {
+	Program     string   `json:"program"`
+	Args        []string `json:"args"`
+	Cwd         string   `json:"cwd"`
+	StopOnEntry bool     `json:"stopOnEntry"`
+	NoDebug     bool     `json:"noDebug"`
+}
+
+// dapFrame is a frame of a stopped program, env is nil for the outer frames of an uncaught error,
+// whose environments are gone.
+type dapFrame struct {
+	name   string
+	file   string
+	line   int
+	column int
+	env    *evaluator.Environment
+}
+
+// A dapCommand is sent to the stopped program, either to resume it, with "continue", "next",
+// "stepIn" or "stepOut", or to run something on its goroutine, like evaluating an expression.
+type dapCommand struct {
+	resume string
+	run    func()
+}
+
+type dapServer struct {
+	in *bufio.Reader
+
+	// writeMu guards out and seq, messages are written by the server and the program's goroutine
+	writeMu sync.Mutex
+	out     io.Writer
+	seq     int
+
+	// mu guards the fields below, which the program's goroutine reads while it runs
+	mu sync.Mutex
+	// breakpoints holds the lines with a breakpoint of each file, by absolute path
+	breakpoints map[string]map[int]bool
+	// files tells which file the expressions of the programs in programs are from, lines which lines
+	// of each file have an expression starting on them
+	files       map[parser.Expression]string
+	programs    map[string]*parser.Program
+	lines       map[string]map[int]bool
+	stopOnError bool
+	launch      *dapLaunchArguments
+	configured  bool
+	started     bool
+	// stopped holds the frames of the stopped program, nil while it runs
+	stopped []dapFrame
+	// references are what the variablesReference handed out while stopped expand to
+	references []func() []dapVariable
+	global     *evaluator.Environment
+
+	// commands is received from by the program while it is stopped
+	commands chan dapCommand
+	pause    atomic.Bool
+	// hook is the program's, only its goroutine uses it
+	hook *dapHook
+}
+
+func runDAP(arguments []string) int {
+	if len(arguments) > 0 {
+		fmt.Fprintln(os.Stderr, "usage: soup dap\n\nstarts a debug adapter on stdin and stdout")
+		return 2
+	}
+	s := &dapServer{
+		in:          bufio.NewReader(os.Stdin),
+		out:         os.Stdout,
+		breakpoints: make(map[string]map[int]bool),
+		files:       make(map[parser.Expression]string),
+		programs:    make(map[string]*parser.Program),
+		lines:       make(map[string]map[int]bool),
+		stopOnError: true,
+		commands:    make(chan dapCommand),
+	}
+	return s.serve()
+}
+
+// serve handles requests until the client disconnects.
+func (s *dapServer) serve() int {
+	for {
+		body, err := readFrame(s.in)
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintln(os.Stderr, "soup dap:", err)
+			}
+			return 1
+		}
+		var req dapRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			fmt.Fprintln(os.Stderr, "soup dap: invalid message:", err)
+			return 1
+		}
+		if req.Type != "request" {
+			continue
+		}
+
+		result, err := s.handle(&req)
+		resp := dapResponse{Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: err == nil, Body: result}
+		if err != nil {
+			resp.Message = err.Error()
+		}
+		s.send(&resp)
+
+		switch req.Command {
+		case "initialize":
+			// the client sends the breakpoints and configurationDone once it's told it can
+			s.event("initialized", nil)
+		case "disconnect":
+			return 0
+		}
+		s.start()
+	}
+}
+
+func (s *dapServer) send(msg any) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.seq++
+	switch msg := msg.(type) {
+	case *dapResponse:
+		msg.Seq = s.seq
+	case *dapEvent:
+		msg.Seq = s.seq
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "soup dap:", err)
+		return
+	}
+	writeFrame(s.out, body)
+}
+
+func (s *dapServer) event(name string, body any) {
+	s.send(&dapEvent{Type: "event", Event: name, Body: body})
+}
+
+func (s *dapServer) handle(req *dapRequest) (any, error) {
+	switch req.Command {
+	case "initialize":
+		return map[string]any{
+			"supportsConfigurationDoneRequest": true,
+			"supportsEvaluateForHovers":        true,
+			"exceptionBreakpointFilters": []map[string]any{
+				{"filter": "uncaught", "label": "Uncaught errors", "default": true},
+			},
+		}, nil
+	case "launch":
+		var args dapLaunchArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, fmt.Errorf("invalid launch arguments: %w", err)
+		}
+		return nil, s.handleLaunch(&args)
+	case "setBreakpoints":
+		var args struct {
+			Source      dapSource `json:"source"`
+			Breakpoints []struct {
+				Line int `json:"line"`
+			} `json:"breakpoints"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, fmt.Errorf("invalid breakpoints: %w", err)
+		}
+		lines := make([]int, len(args.Breakpoints))
+		for i, bp := range args.Breakpoints {
+			lines[i] = bp.Line
+		}
+		return map[string]any{"breakpoints": s.setBreakpoints(args.Source.Path, lines)}, nil
+	case "setExceptionBreakpoints":
+		var args struct {
+			Filters []string `json:"filters"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, fmt.Errorf("invalid exception breakpoints: %w", err)
+		}
+		s.mu.Lock()
+		s.stopOnError = slices.Contains(args.Filters, "uncaught")
+		s.mu.Unlock()
+		return nil, nil
+	case "configurationDone":
+		s.mu.Lock()
+		s.configured = true
+		s.mu.Unlock()
+		return nil, nil
+	case "threads":
+		return map[string]any{"threads": []map[string]any{{"id": dapThreadID, "name": "main"}}}, nil
+	case "stackTrace":
+		var args struct {
+			StartFrame int `json:"startFrame"`
+			Levels     int `json:"levels"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, fmt.Errorf("invalid stack trace arguments: %w", err)
+		}
+		return s.stackTrace(args.StartFrame, args.Levels)
+	case "scopes":
+		var args struct {
+			FrameID int `json:"frameId"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, fmt.Errorf("invalid scopes arguments: %w", err)
+		}
+		return s.scopes(args.FrameID)
+	case "variables":
+		var args struct {
+			VariablesReference int `json:"variablesReference"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, fmt.Errorf("invalid variables arguments: %w", err)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if args.VariablesReference < 1 || args.VariablesReference > len(s.references) {
+			return nil, fmt.Errorf("unknown variables reference %d", args.VariablesReference)
+		}
+		return map[string]any{"variables": s.references[args.VariablesReference-1]()}, nil
+	case "evaluate":
+		var args struct {
+			Expression string `json:"expression"`
+			FrameID    int    `json:"frameId"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, fmt.Errorf("invalid evaluate arguments: %w", err)
+		}
+		return s.evaluate(args.Expression, args.FrameID)
+	case "continue", "next", "stepIn", "stepOut":
+		if err := s.resume(req.Command); err != nil {
+			return nil, err
+		}
+		if req.Command == "continue" {
+			return map[string]any{"allThreadsContinued": true}, nil
+		}
+		return nil, nil
+	case "pause":
+		s.pause.Store(true)
+		return nil, nil
+	case "disconnect":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unsupported request %s", req.Command)
+}
+
+func (s *dapServer) handleLaunch(args *dapLaunchArguments) error {
+	if args.Program == "" {
+		return errors.New("launch expects the program to run")
+	}
+	if args.Cwd != "" {
+		if err := os.Chdir(args.Cwd); err != nil {
+			return err
+		}
+	}
+	program, err := filepath.Abs(args.Program)
+	if err != nil {
+		return err
+	}
+	args.Program = program
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.launch != nil {
+		return errors.New("the program has already been launched")
+	}
+	// a program that doesn't parse fails to launch rather than running into the error
+	if err := s.addFile(program); err != nil {
+		var parsingError *parser.ParsingError
+		if errors.As(err, &parsingError) {
+			return fmt.Errorf("%s:%d: %s", program, parsingError.Token.Line, parsingError.Message)
+		}
+		return err
+	}
+	s.launch = args
+	return nil
+}
+
+// addFile parses the file at path, unless it's unchanged since the last time, so its expressions can
+// be told apart from other files'. mu must be held.
+func (s *dapServer) addFile(path string) error {
+	program, err := evaluator.ParseFile(path)
+	if err != nil {
+		return err
+	}
+	if s.programs[path] == program {
+		return nil
+	}
+	s.programs[path] = program
+	lines := make(map[int]bool)
+	s.lines[path] = lines
+	for _, exp := range program.Expressions {
+		s.addExpressions(reflect.ValueOf(exp), path, lines)
+	}
+	return nil
+}
+
+// expressionStart returns the token exp starts at, as near as the parser tells: the token of a call
+// is one of its last operand's, so a call starts at its operator.
+func expressionStart(exp parser.Expression) lexer.Token {
+	for {
+		call, ok := exp.(*parser.CallExpression)
+		if !ok {
+			return exp.Token()
+		}
+		exp = call.Operator
+	}
+}
+
+var expressionType = reflect.TypeOf((*parser.Expression)(nil)).Elem()
+
+// addExpressions records the file of every expression in the tree at v.
+func (s *dapServer) addExpressions(v reflect.Value, path string, lines map[int]bool) {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			return
+		}
+		if v.Kind() == reflect.Pointer && v.Type().Implements(expressionType) && v.CanInterface() {
+			exp := v.Interface().(parser.Expression)
+			// the literals every program shares are from no file in particular
+			if exp == parser.Void || exp == parser.TrueLiteral || exp == parser.FalseLiteral {
+				return
+			}
+			s.files[exp] = path
+			lines[expressionStart(exp).Line] = true
+		}
+		s.addExpressions(v.Elem(), path, lines)
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			s.addExpressions(v.Index(i), path, lines)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			s.addExpressions(v.Field(i), path, lines)
+		}
+	}
+}
+
+func (s *dapServer) setBreakpoints(path string, lines []int) []map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path, err := filepath.Abs(path)
+	if err == nil {
+		err = s.addFile(path)
+	}
+
+	set := make(map[int]bool, len(lines))
+	breakpoints := make([]map[string]any, len(lines))
+	for i, line := range lines {
+		bp := map[string]any{"line": line, "verified": false}
+		switch {
+		case err != nil:
+			bp["message"] = err.Error()
+		case !s.lines[path][line]:
+			bp["message"] = "no expression starts on this line"
+		default:
+			bp["verified"] = true
+			set[line] = true
+		}
+		breakpoints[i] = bp
+	}
+	if err == nil {
+		s.breakpoints[path] = set
+	}
+	return breakpoints
+}
+
+// start runs the program once it's launched and the client is done setting breakpoints.
+func (s *dapServer) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started || s.launch == nil || !s.configured {
+		return
+	}
+	s.started = true
+	go s.run(s.launch)
+}
+
+func (s *dapServer) run(launch *dapLaunchArguments) {
+	ev := evaluator.New(
+		// stdin is the protocol's
+		evaluator.WithStdin(strings.NewReader("")),
+		evaluator.WithStdout(dapOutput{s: s, category: "stdout"}),
+		evaluator.WithStderr(dapOutput{s: s, category: "stderr"}),
+		// a breakpoint right after a display shows what it printed
+		evaluator.WithUnbufferedOutput(),
+		evaluator.WithSearchPath(evaluator.SearchPathFromEnv()),
+		evaluator.WithCommandLine(append([]string{launch.Program}, launch.Args...)),
+	)
+	s.mu.Lock()
+	s.global = ev.GlobalEnvironment()
+	s.mu.Unlock()
+	h := &dapHook{s: s, ev: ev, entry: launch.StopOnEntry}
+	s.hook = h
+	if !launch.NoDebug {
+		ev.SetHook(h)
+	}
+
+	_, err := ev.LoadFile(launch.Program)
+	if err != nil {
+		var b strings.Builder
+		writeError(&b, err, "")
+		s.event("output", map[string]any{"category": "stderr", "output": b.String()})
+		s.mu.Lock()
+		stopOnError := s.stopOnError
+		s.mu.Unlock()
+		if stopOnError && !launch.NoDebug {
+			h.postMortem(err, launch.Program)
+		}
+	}
+	if hookErr := ev.RunExitHooks(); hookErr != nil {
+		var b strings.Builder
+		writeError(&b, hookErr, "")
+		s.event("output", map[string]any{"category": "stderr", "output": b.String()})
+	}
+
+	code := 0
+	if err != nil {
+		code = exitCode(err)
+	}
+	s.event("exited", map[string]any{"exitCode": code})
+	s.event("terminated", nil)
+}
+
+// dapOutput sends what the program writes to the client.
+type dapOutput struct {
+	s        *dapServer
+	category string
+}
+
+func (w dapOutput) Write(p []byte) (int, error) {
+	w.s.event("output", map[string]any{"category": w.category, "output": string(p)})
+	return len(p), nil
+}
+
+// stop reports the program stopped at frames, innermost first, and waits for the client to resume it.
+// It's called on the program's goroutine.
+func (s *dapServer) stop(reason string, text string, frames []dapFrame) string {
+	s.mu.Lock()
+	s.stopped = frames
+	s.references = nil
+	s.mu.Unlock()
+
+	body := map[string]any{"reason": reason, "threadId": dapThreadID, "allThreadsStopped": true}
+	if text != "" {
+		body["text"] = text
+	}
+	s.event("stopped", body)
+	for cmd := range s.commands {
+		if cmd.run != nil {
+			cmd.run()
+			continue
+		}
+		return cmd.resume
+	}
+	return "continue"
+}
+
+// resume sends how to resume to the stopped program, references handed out while it was stopped
+// become invalid.
+func (s *dapServer) resume(how string) error {
+	s.mu.Lock()
+	if s.stopped == nil {
+		s.mu.Unlock()
+		return errors.New("the program isn't stopped")
+	}
+	s.stopped = nil
+	s.references = nil
+	s.mu.Unlock()
+	s.commands <- dapCommand{resume: how}
+	return nil
+}
+
+func (s *dapServer) stackTrace(start int, levels int) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped == nil {
+		return nil, errors.New("the program isn't stopped")
+	}
+	frames := make([]dapStackFrame, 0, len(s.stopped))
+	for i, frame := range s.stopped {
+		if i < start || levels > 0 && i >= start+levels {
+			continue
+		}
+		f := dapStackFrame{ID: i + 1, Name: frame.name, Line: frame.line, Column: frame.column}
+		if frame.file != "" {
+			f.Source = &dapSource{Name: filepath.Base(frame.file), Path: frame.file}
+		}
+		frames = append(frames, f)
+	}
+	return map[string]any{"stackFrames": frames, "totalFrames": len(s.stopped)}, nil
+}
+
+// frame returns the frame with the id the client was given, mu must be held.
+func (s *dapServer) frame(id int) (*dapFrame, error) {
+	if s.stopped == nil {
+		return nil, errors.New("the program isn't stopped")
+	}
+	if id < 1 || id > len(s.stopped) {
+		return nil, fmt.Errorf("unknown frame %d", id)
+	}
+	return &s.stopped[id-1], nil
+}
+
+// scopes lists the environments of a frame: the procedure call's, those it was made in, and last the
+// global environment. The builtins are left out.
+func (s *dapServer) scopes(frameID int) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	frame, err := s.frame(frameID)
+	if err != nil {
+		return nil, err
+	}
+	scopes := make([]dapScope, 0)
+	for env := frame.env; env != nil && env != s.global; env = env.Enclosing() {
+		scope := dapScope{Name: "Closure", VariablesReference: s.envReference(env)}
+		if len(scopes) == 0 {
+			scope.Name = "Locals"
+			scope.PresentationHint = "locals"
+		}
+		scopes = append(scopes, scope)
+	}
+	scopes = append(scopes, dapScope{Name: "Globals", VariablesReference: s.envReference(s.global)})
+	return map[string]any{"scopes": scopes}, nil
+}
+
+// envReference hands out a reference to the variables of env, mu must be held.
+func (s *dapServer) envReference(env *evaluator.Environment) int {
+	s.references = append(s.references, func() []dapVariable {
+		names := env.LocalNames()
+		variables := make([]dapVariable, 0, len(names))
+		for _, name := range names {
+			val, _ := env.Get(name)
+			variables = append(variables, s.variable(name, val))
+		}
+		return variables
+	})
+	return len(s.references)
+}
+
+// variable describes val, lists, vectors and pairs get a reference to their elements. mu must be held.
+func (s *dapServer) variable(name string, val *evaluator.ReturnValue) dapVariable {
+	v := dapVariable{Name: name, Value: val.LimitedPrettyString(math.MaxInt, dapValueLimits), Type: val.Type.String()}
+	var elements []*evaluator.ReturnValue
+	switch val.Type {
+	case evaluator.ConsType:
+		if list, ok := val.ListElements(); ok {
+			elements = list
+			break
+		}
+		// an improper or circular list is expanded pair by pair
+		pair := val.Cons()
+		s.references = append(s.references, func() []dapVariable {
+			return []dapVariable{s.variable("car", pair.Car), s.variable("cdr", pair.Cdr)}
+		})
+		v.VariablesReference = len(s.references)
+		return v
+	case evaluator.VectorType:
+		elements = val.Vector().Elements
+	}
+	if len(elements) > 0 {
+		s.references = append(s.references, func() []dapVariable {
+			variables := make([]dapVariable, len(elements))
+			for i, element := range elements {
+				variables[i] = s.variable(strconv.Itoa(i), element)
+			}
+			return variables
+		})
+		v.VariablesReference = len(s.references)
+	}
+	return v
+}
+
+// evaluate evaluates src in the environment of a frame, or the global one without a frame, on the
+// stopped program's goroutine.
+func (s *dapServer) evaluate(src string, frameID int) (any, error) {
+	s.mu.Lock()
+	env := s.global
+	if frameID != 0 {
+		frame, err := s.frame(frameID)
+		if err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		if frame.env != nil {
+			env = frame.env
+		}
+	} else if s.stopped == nil {
+		s.mu.Unlock()
+		return nil, errors.New("the program isn't stopped")
+	}
+	s.mu.Unlock()
+
+	var ret *evaluator.ReturnValue
+	var err error
+	done := make(chan struct{})
+	s.commands <- dapCommand{run: func() {
+		defer close(done)
+		var program *parser.Program
+		program, err = parseSource(s.hook.ev, src)
+		if err != nil {
+			return
+		}
+		ret, err = s.evalWithoutStopping(program, env)
+	}}
+	<-done
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := s.variable("", ret)
+	return map[string]any{"result": v.Value, "type": v.Type, "variablesReference": v.VariablesReference}, nil
+}
+
+// evalWithoutStopping evaluates program for the client, its breakpoints are ignored. It's called on
+// the program's goroutine.
+func (s *dapServer) evalWithoutStopping(program *parser.Program, env *evaluator.Environment) (*evaluator.ReturnValue, error) {
+	s.hook.evaluating = true
+	defer func() {
+		s.hook.evaluating = false
+	}()
+	return s.hook.ev.EvalIn(program, env)
+}
+
+// dapHook follows the evaluation of the program to stop it at breakpoints and steps. It keeps the
+// frames of the procedure calls being evaluated, a frame starts where an expression from a file is
+// evaluated in an environment other than the current frame's, and stays while the expressions being
+// evaluated when it started are.
+type dapHook struct {
+	s  *dapServer
+	ev *evaluator.Evaluator
+	// active is how many expressions are being evaluated, calls the calls among them
+	active int
+	calls  []*parser.CallExpression
+	frames []*dapHookFrame
+	// step is how the program was last resumed, stepDepth how many frames there were then
+	step      string
+	stepDepth int
+	entry     bool
+	// evaluating is set while an expression the client sent is evaluated
+	evaluating bool
+}
+
+type dapHookFrame struct {
+	name string
+	// scopes are the environments of the frame, the procedure call's and one for every let being
+	// evaluated in it, innermost last
+	scopes []dapHookScope
+	// file, line and column are where the last expression started in the frame is
+	file   string
+	line   int
+	column int
+}
+
+type dapHookScope struct {
+	env *evaluator.Environment
+	// start is the value of active when the scope was entered
+	start int
+}
+
+func (f *dapHookFrame) env() *evaluator.Environment {
+	return f.scopes[len(f.scopes)-1].env
+}
+
+func (h *dapHook) Before(exp parser.Expression, environment *evaluator.Environment) {
+	if h.evaluating {
+		return
+	}
+	h.active++
+	defer func() {
+		if call, ok := exp.(*parser.CallExpression); ok {
+			h.calls = append(h.calls, call)
+		}
+	}()
+
+	h.s.mu.Lock()
+	file, ok := h.s.files[exp]
+	h.s.mu.Unlock()
+	tok := expressionStart(exp)
+	if !ok || tok.Line == 0 {
+		return
+	}
+
+	var frame *dapHookFrame
+	if len(h.frames) > 0 {
+		frame = h.frames[len(h.frames)-1]
+	}
+	switch {
+	case frame != nil && frame.env() == environment:
+	case frame != nil && h.inLet():
+		// a let is a lambda called where it's written, its body is part of the frame it's in
+		frame.scopes = append(frame.scopes, dapHookScope{env: environment, start: h.active})
+	default:
+		frame = &dapHookFrame{name: h.callName(), scopes: []dapHookScope{{env: environment, start: h.active}}}
+		h.frames = append(h.frames, frame)
+	}
+
+	// the program stops at the first expression of a line, not at every one on it
+	if frame.file == file && frame.line == tok.Line {
+		return
+	}
+	frame.file, frame.line, frame.column = file, tok.Line, tok.Column
+	if reason := h.stopReason(file, tok.Line); reason != "" {
+		h.step = h.s.stop(reason, "", h.snapshot())
+		h.stepDepth = len(h.frames)
+	}
+}
+
+func (h *dapHook) After(exp parser.Expression, environment *evaluator.Environment, result *evaluator.ReturnValue, err error) {
+	if h.evaluating {
+		return
+	}
+	if n := len(h.calls); n > 0 && h.calls[n-1] == exp {
+		h.calls = h.calls[:n-1]
+	}
+	h.active--
+	for len(h.frames) > 0 {
+		frame := h.frames[len(h.frames)-1]
+		for len(frame.scopes) > 0 && frame.scopes[len(frame.scopes)-1].start > h.active {
+			frame.scopes = frame.scopes[:len(frame.scopes)-1]
+		}
+		if len(frame.scopes) > 0 {
+			break
+		}
+		h.frames = h.frames[:len(h.frames)-1]
+	}
+}
+
+// inLet reports whether the innermost call is of a lambda written as its operator, which is what a
+// let is parsed to.
+func (h *dapHook) inLet() bool {
+	if len(h.calls) == 0 {
+		return false
+	}
+	_, ok := h.calls[len(h.calls)-1].Operator.(*parser.LambdaExpression)
+	return ok
+}
+
+// callName names a frame after the procedure the innermost call calls.
+func (h *dapHook) callName() string {
+	if len(h.calls) == 0 {
+		return "main"
+	}
+	if id, ok := h.calls[len(h.calls)-1].Operator.(*parser.IdentifierExpression); ok {
+		return id.Value
+	}
+	return "lambda"
+}
+
+// stopReason returns why the program stops at line of file, or "" if it doesn't.
+func (h *dapHook) stopReason(file string, line int) string {
+	if h.entry {
+		h.entry = false
+		return "entry"
+	}
+	if h.s.pause.Swap(false) {
+		return "pause"
+	}
+	h.s.mu.Lock()
+	breakpoint := h.s.breakpoints[file][line]
+	h.s.mu.Unlock()
+	if breakpoint {
+		return "breakpoint"
+	}
+	switch {
+	case h.step == "stepIn",
+		h.step == "next" && len(h.frames) <= h.stepDepth,
+		h.step == "stepOut" && len(h.frames) < h.stepDepth:
+		return "step"
+	}
+	return ""
+}
+
+// snapshot returns the frames being evaluated, innermost first.
+func (h *dapHook) snapshot() []dapFrame {
+	frames := make([]dapFrame, len(h.frames))
+	for i, frame := range h.frames {
+		frames[len(frames)-1-i] = dapFrame{name: frame.name, file: frame.file, line: frame.line, column: frame.column, env: frame.env()}
+	}
+	return frames
+}
+
+// postMortem stops the program at the frames of an uncaught error. Only the innermost frame, the one
+// that raised it, still has its environment.
+func (h *dapHook) postMortem(err error, program string) {
+	var runtimeError *evaluator.RuntimeError
+	if !errors.As(err, &runtimeError) || runtimeError.Environment() == nil {
+		return
+	}
+	file := runtimeError.File()
+	if file == "" {
+		file = program
+	}
+	// the frames read like the stack trace printError prints
+	frames := make([]dapFrame, 0, len(runtimeError.StackTrace())+1)
+	for _, element := range runtimeError.StackTrace() {
+		frames = append(frames, dapFrame{name: element.IdentifierName(), file: file, line: element.LineNumber()})
+	}
+	frames = append(frames, dapFrame{name: "main", file: file, line: runtimeError.LineNumber()})
+	frames[0].env = runtimeError.Environment()
+	if tok := runtimeError.Token(); tok.Line == frames[0].line {
+		frames[0].column = tok.Column
+	}
+	h.s.stop("exception", err.Error(), frames)
+}
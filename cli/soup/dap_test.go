@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ocowchun/soup/parser"
+)
+
+// dapMessage is a response or an event the debug adapter sent.
+type dapMessage struct {
+	Seq        int             `json:"seq"`
+	Type       string          `json:"type"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Command    string          `json:"command"`
+	Message    string          `json:"message"`
+	Event      string          `json:"event"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// dapClient talks to a debug adapter through in-memory pipes.
+type dapClient struct {
+	t        *testing.T
+	in       *io.PipeWriter
+	messages chan dapMessage
+	status   chan int
+	seq      int
+	lastSeq  int
+	// events are the events read while waiting for a response
+	events []dapMessage
+}
+
+func newDAPClient(t *testing.T) *dapClient {
+	inReader, in := io.Pipe()
+	out, outWriter := io.Pipe()
+	s := &dapServer{
+		in:          bufio.NewReader(inReader),
+		out:         outWriter,
+		breakpoints: make(map[string]map[int]bool),
+		files:       make(map[parser.Expression]string),
+		programs:    make(map[string]*parser.Program),
+		lines:       make(map[string]map[int]bool),
+		stopOnError: true,
+		commands:    make(chan dapCommand),
+	}
+	c := &dapClient{t: t, in: in, messages: make(chan dapMessage, 100), status: make(chan int, 1)}
+	go func() {
+		c.status <- s.serve()
+	}()
+	go func() {
+		reader := bufio.NewReader(out)
+		for {
+			body, err := readFrame(reader)
+			if err != nil {
+				close(c.messages)
+				return
+			}
+			var msg dapMessage
+			if err := json.Unmarshal(body, &msg); err != nil {
+				t.Errorf("invalid message %s: %v", body, err)
+			}
+			c.messages <- msg
+		}
+	}()
+	t.Cleanup(func() {
+		in.Close()
+		outWriter.Close()
+	})
+	return c
+}
+
+// next returns the next message the adapter sends, whose seq must follow the last one's.
+func (c *dapClient) next() dapMessage {
+	c.t.Helper()
+	select {
+	case msg, ok := <-c.messages:
+		if !ok {
+			c.t.Fatal("the debug adapter stopped writing")
+		}
+		if msg.Seq != c.lastSeq+1 {
+			c.t.Fatalf("expected seq %d, got %+v", c.lastSeq+1, msg)
+		}
+		c.lastSeq = msg.Seq
+		return msg
+	case <-time.After(10 * time.Second):
+		c.t.Fatal("timed out waiting for the debug adapter")
+	}
+	return dapMessage{}
+}
+
+// request sends a request and returns its response, the events sent before it are kept for event.
+func (c *dapClient) request(command string, arguments any) dapMessage {
+	c.t.Helper()
+	c.seq++
+	req := map[string]any{"seq": c.seq, "type": "request", "command": command}
+	if arguments != nil {
+		req["arguments"] = arguments
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		c.t.Fatalf("unexpected error: %v", err)
+	}
+	writeFrame(c.in, body)
+	for {
+		msg := c.next()
+		if msg.Type == "event" {
+			c.events = append(c.events, msg)
+			continue
+		}
+		if msg.Type != "response" || msg.RequestSeq != c.seq || msg.Command != command {
+			c.t.Fatalf("expected the response to %d %s, got %+v", c.seq, command, msg)
+		}
+		return msg
+	}
+}
+
+// body sends a request that must succeed and decodes the body of its response into result.
+func (c *dapClient) body(command string, arguments any, result any) {
+	c.t.Helper()
+	resp := c.request(command, arguments)
+	if !resp.Success {
+		c.t.Fatalf("%s failed: %s", command, resp.Message)
+	}
+	if result != nil {
+		if err := json.Unmarshal(resp.Body, result); err != nil {
+			c.t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+// event returns the next event with the name, skipping the others, and decodes its body into result.
+func (c *dapClient) event(name string, result any) {
+	c.t.Helper()
+	for {
+		var msg dapMessage
+		if len(c.events) > 0 {
+			msg, c.events = c.events[0], c.events[1:]
+		} else {
+			msg = c.next()
+		}
+		if msg.Type != "event" {
+			c.t.Fatalf("expected the %s event, got %+v", name, msg)
+		}
+		if msg.Event != name {
+			continue
+		}
+		if result != nil {
+			if err := json.Unmarshal(msg.Body, result); err != nil {
+				c.t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		return
+	}
+}
+
+// output skips to the exited event and returns what the program printed on the way.
+func (c *dapClient) output() (string, int) {
+	c.t.Helper()
+	var b strings.Builder
+	for {
+		var msg dapMessage
+		if len(c.events) > 0 {
+			msg, c.events = c.events[0], c.events[1:]
+		} else {
+			msg = c.next()
+		}
+		var body struct {
+			Category string `json:"category"`
+			Output   string `json:"output"`
+			ExitCode int    `json:"exitCode"`
+		}
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			c.t.Fatalf("unexpected error: %v", err)
+		}
+		switch msg.Event {
+		case "output":
+			b.WriteString(body.Output)
+		case "exited":
+			return b.String(), body.ExitCode
+		default:
+			c.t.Fatalf("expected output until the program exited, got %+v", msg)
+		}
+	}
+}
+
+func (c *dapClient) disconnect() {
+	c.t.Helper()
+	c.body("disconnect", nil, nil)
+	select {
+	case status := <-c.status:
+		if status != 0 {
+			c.t.Fatalf("expected exit status 0 after disconnect, got %d", status)
+		}
+	case <-time.After(10 * time.Second):
+		c.t.Fatal("timed out waiting for the debug adapter to exit")
+	}
+}
+
+type dapStopped struct {
+	Reason   string `json:"reason"`
+	ThreadID int    `json:"threadId"`
+	Text     string `json:"text"`
+}
+
+type dapStackTrace struct {
+	StackFrames []dapStackFrame `json:"stackFrames"`
+	TotalFrames int             `json:"totalFrames"`
+}
+
+// launch writes src to a file, launches it with breakpoints on lines and returns its path.
+func (c *dapClient) launch(src string, lines ...int) string {
+	c.t.Helper()
+	path := filepath.Join(c.t.TempDir(), "program.soup")
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		c.t.Fatalf("unexpected error: %v", err)
+	}
+
+	var capabilities map[string]any
+	c.body("initialize", map[string]any{"adapterID": "soup"}, &capabilities)
+	if capabilities["supportsConfigurationDoneRequest"] != true {
+		c.t.Fatalf("unexpected capabilities %v", capabilities)
+	}
+	c.event("initialized", nil)
+	c.body("launch", map[string]any{"program": path}, nil)
+
+	breakpoints := make([]map[string]any, len(lines))
+	for i, line := range lines {
+		breakpoints[i] = map[string]any{"line": line}
+	}
+	var set struct {
+		Breakpoints []struct {
+			Line     int    `json:"line"`
+			Verified bool   `json:"verified"`
+			Message  string `json:"message"`
+		} `json:"breakpoints"`
+	}
+	c.body("setBreakpoints", map[string]any{"source": map[string]any{"path": path}, "breakpoints": breakpoints}, &set)
+	for i, bp := range set.Breakpoints {
+		if bp.Line != lines[i] || !bp.Verified {
+			c.t.Fatalf("expected a breakpoint on line %d, got %+v", lines[i], bp)
+		}
+	}
+	return path
+}
+
+func TestDAP_Breakpoints(t *testing.T) {
+	c := newDAPClient(t)
+	path := c.launch("(define (square x)\n  (* x x))\n(define y (square 3))\n(display y)\n\n(newline)\n", 2)
+
+	// an empty line can't hold a breakpoint
+	var set struct {
+		Breakpoints []struct {
+			Verified bool   `json:"verified"`
+			Message  string `json:"message"`
+		} `json:"breakpoints"`
+	}
+	c.body("setBreakpoints", map[string]any{"source": map[string]any{"path": path}, "breakpoints": []map[string]any{{"line": 2}, {"line": 5}}}, &set)
+	if len(set.Breakpoints) != 2 || !set.Breakpoints[0].Verified || set.Breakpoints[1].Verified || set.Breakpoints[1].Message != "no expression starts on this line" {
+		t.Fatalf("unexpected breakpoints %+v", set.Breakpoints)
+	}
+	if resp := c.request("stackTrace", map[string]any{"threadId": dapThreadID}); resp.Success || resp.Message != "the program isn't stopped" {
+		t.Fatalf("expected the stack trace of a running program to fail, got %+v", resp)
+	}
+
+	c.body("configurationDone", nil, nil)
+	var stopped dapStopped
+	c.event("stopped", &stopped)
+	if stopped.Reason != "breakpoint" || stopped.ThreadID != dapThreadID {
+		t.Fatalf("unexpected stop %+v", stopped)
+	}
+
+	var threads struct {
+		Threads []struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+		} `json:"threads"`
+	}
+	c.body("threads", nil, &threads)
+	if len(threads.Threads) != 1 || threads.Threads[0].ID != dapThreadID {
+		t.Fatalf("unexpected threads %+v", threads)
+	}
+
+	var trace dapStackTrace
+	c.body("stackTrace", map[string]any{"threadId": dapThreadID}, &trace)
+	if trace.TotalFrames != 2 || len(trace.StackFrames) != 2 {
+		t.Fatalf("expected two frames, got %+v", trace)
+	}
+	for i, expected := range []dapStackFrame{{ID: 1, Name: "square", Line: 2, Column: 4}, {ID: 2, Name: "main", Line: 3, Column: 2}} {
+		frame := trace.StackFrames[i]
+		if frame.Source == nil || frame.Source.Path != path {
+			t.Fatalf("expected frame %d in %s, got %+v", i, path, frame)
+		}
+		frame.Source = nil
+		if frame != expected {
+			t.Fatalf("expected frame %+v, got %+v", expected, frame)
+		}
+	}
+
+	var scopes struct {
+		Scopes []dapScope `json:"scopes"`
+	}
+	c.body("scopes", map[string]any{"frameId": 1}, &scopes)
+	if len(scopes.Scopes) != 2 || scopes.Scopes[0].Name != "Locals" || scopes.Scopes[1].Name != "Globals" {
+		t.Fatalf("unexpected scopes %+v", scopes)
+	}
+	var variables struct {
+		Variables []dapVariable `json:"variables"`
+	}
+	c.body("variables", map[string]any{"variablesReference": scopes.Scopes[0].VariablesReference}, &variables)
+	if len(variables.Variables) != 1 || variables.Variables[0] != (dapVariable{Name: "x", Value: "3", Type: "Number"}) {
+		t.Fatalf("unexpected locals %+v", variables)
+	}
+
+	var result struct {
+		Result string `json:"result"`
+	}
+	c.body("evaluate", map[string]any{"expression": "(list x (+ x 1))", "frameId": 1}, &result)
+	if result.Result != "(3 4)" {
+		t.Fatalf("expected (3 4), got %q", result.Result)
+	}
+
+	// next steps over the rest of square to the next line of main
+	c.body("next", map[string]any{"threadId": dapThreadID}, nil)
+	c.event("stopped", &stopped)
+	if stopped.Reason != "step" {
+		t.Fatalf("expected a step, got %+v", stopped)
+	}
+	c.body("stackTrace", map[string]any{"threadId": dapThreadID}, &trace)
+	if len(trace.StackFrames) != 1 || trace.StackFrames[0].Name != "main" || trace.StackFrames[0].Line != 4 {
+		t.Fatalf("expected main at line 4, got %+v", trace)
+	}
+	// the references handed out at the breakpoint are gone
+	if resp := c.request("variables", map[string]any{"variablesReference": scopes.Scopes[0].VariablesReference}); resp.Success {
+		t.Fatalf("expected a stale reference to fail, got %+v", resp)
+	}
+
+	c.body("continue", map[string]any{"threadId": dapThreadID}, nil)
+	if output, code := c.output(); output != "9\n" || code != 0 {
+		t.Fatalf("expected 9 and exit code 0, got %q and %d", output, code)
+	}
+	c.event("terminated", nil)
+	if resp := c.request("continue", map[string]any{"threadId": dapThreadID}); resp.Success {
+		t.Fatalf("expected continue after the program exited to fail, got %+v", resp)
+	}
+	c.disconnect()
+}
+
+func TestDAP_UncaughtError(t *testing.T) {
+	c := newDAPClient(t)
+	c.launch("(define (first x)\n  (car x))\n(first 1)\n")
+	c.body("configurationDone", nil, nil)
+
+	var stopped dapStopped
+	c.event("stopped", &stopped)
+	if stopped.Reason != "exception" || !strings.Contains(stopped.Text, "car") {
+		t.Fatalf("expected to stop at the error, got %+v", stopped)
+	}
+	var trace dapStackTrace
+	c.body("stackTrace", map[string]any{"threadId": dapThreadID}, &trace)
+	if len(trace.StackFrames) != 2 || trace.StackFrames[0].Line != 2 || trace.StackFrames[1].Name != "main" || trace.StackFrames[1].Line != 3 {
+		t.Fatalf("unexpected frames %+v", trace)
+	}
+	// the frame that raised the error can still be inspected
+	var result struct {
+		Result string `json:"result"`
+	}
+	c.body("evaluate", map[string]any{"expression": "x", "frameId": 1}, &result)
+	if result.Result != "1" {
+		t.Fatalf("expected 1, got %q", result.Result)
+	}
+
+	c.body("continue", map[string]any{"threadId": dapThreadID}, nil)
+	if _, code := c.output(); code != 70 {
+		t.Fatalf("expected exit code 70, got %d", code)
+	}
+	c.disconnect()
+}
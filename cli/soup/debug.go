@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/ocowchun/soup/lexer"
+	"github.com/ocowchun/soup/parser"
+)
+
+// The tokens and ast subcommands dump what the lexer and the parser make of a file:
+//
+//	soup tokens [-json] file
+//	soup ast [-json] file
+//
+// A file named - is read from stdin.
+
+var debugCommands = map[string]func(src []byte, asJSON bool) error{
+	"tokens": dumpTokens,
+	"ast":    dumpAST,
+}
+
+func runDebugCommand(name string, arguments []string) int {
+	fs := flag.NewFlagSet("soup "+name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: soup %s [-json] file\n\nflags:\n", name)
+		fs.PrintDefaults()
+	}
+	asJSON := fs.Bool("json", false, "print JSON instead of text")
+	fs.Parse(arguments)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	var src []byte
+	var err error
+	if fileName := fs.Arg(0); fileName == "-" {
+		src, err = io.ReadAll(os.Stdin)
+	} else {
+		src, err = os.ReadFile(fileName)
+	}
+	if err != nil {
+		fmt.Println("error:", err)
+		return exitIOError
+	}
+
+	if err := debugCommands[name](src, *asJSON); err != nil {
+		printError(err, string(src))
+		return exitCode(err)
+	}
+	return 0
+}
+
+type tokenDump struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+}
+
+func dumpTokens(src []byte, asJSON bool) error {
+	l := lexer.New(bytes.NewReader(src))
+	tokens := make([]tokenDump, 0)
+	for {
+		tok := l.NextToken()
+		tokens = append(tokens, tokenDump{Line: tok.Line, Column: tok.Column, Type: tok.TokenType.String(), Content: tok.Content})
+		if tok.TokenType == lexer.TokenTypeEOF {
+			break
+		}
+	}
+
+	if asJSON {
+		return printJSON(tokens)
+	}
+	for _, tok := range tokens {
+		fmt.Printf("%d:%d\t%s\t%s\n", tok.Line, tok.Column, tok.Type, strconv.Quote(tok.Content))
+	}
+	return nil
+}
+
+func dumpAST(src []byte, asJSON bool) error {
+	program, err := parser.New(lexer.New(bytes.NewReader(src))).Parse()
+	if err != nil {
+		return err
+	}
+
+	nodes := make([]any, len(program.Expressions))
+	for i, exp := range program.Expressions {
+		nodes[i] = astValue(reflect.ValueOf(exp))
+	}
+	if asJSON {
+		return printJSON(nodes)
+	}
+	var b strings.Builder
+	for _, node := range nodes {
+		writeASTText(&b, node, "")
+		b.WriteString("\n")
+	}
+	fmt.Print(b.String())
+	return nil
+}
+
+func printJSON(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// marshalJSON is json.Marshal without escaping <, > and &, which are common in soup code.
+func marshalJSON(v any) ([]byte, error) {
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
+
+// astNode is a parser node with its fields in declaration order. Tokens are left out except for the
+// line of the node's first one, and the content of a node that only holds a token, e.g. a number.
+type astNode struct {
+	name   string
+	line   int
+	fields []astField
+}
+
+type astField struct {
+	name  string
+	value any
+}
+
+func (n *astNode) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `{"node":%q`, n.name)
+	if n.line > 0 {
+		fmt.Fprintf(&b, `,"line":%d`, n.line)
+	}
+	for _, field := range n.fields {
+		value, err := marshalJSON(field.value)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&b, `,%q:%s`, field.name, value)
+	}
+	b.WriteString("}")
+	return b.Bytes(), nil
+}
+
+// astNodeNames names the nodes of unexported types after the values the parser exports them as.
+var astNodeNames = map[string]string{
+	"voidExpression": "Void",
+	"booleanLiteral": "Boolean",
+}
+
+var (
+	tokenType = reflect.TypeOf(lexer.Token{})
+	cacheType = reflect.TypeOf(parser.LiteralCache{})
+)
+
+// astValue converts a node reached from an Expression to an *astNode, a slice, or a string, number or
+// boolean, by walking its fields.
+func astValue(v reflect.Value) any {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		return astValue(v.Elem())
+	case reflect.Slice:
+		elements := make([]any, v.Len())
+		for i := range elements {
+			elements[i] = astValue(v.Index(i))
+		}
+		return elements
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Struct:
+		t := v.Type()
+		name := t.Name()
+		if exported, ok := astNodeNames[name]; ok {
+			name = exported
+		}
+		node := &astNode{name: name}
+		var first *lexer.Token
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).Type == tokenType {
+				if first == nil {
+					tok := v.Field(i).Interface().(lexer.Token)
+					first = &tok
+					node.line = tok.Line
+				}
+				continue
+			}
+			if t.Field(i).Type == cacheType {
+				// what an evaluator cached, not part of the program
+				continue
+			}
+			node.fields = append(node.fields, astField{name: t.Field(i).Name, value: astValue(v.Field(i))})
+		}
+		if len(node.fields) == 0 && first != nil {
+			node.fields = append(node.fields, astField{name: "Value", value: first.Content})
+		}
+		return node
+	default:
+		return fmt.Sprintf("<%s>", v.Type())
+	}
+}
+
+// writeASTText writes value as an indented tree, for example
+//
+//	DefineExpression (line 1)
+//	  Name: "x"
+//	  Value: NumberLiteral (line 1)
+func writeASTText(b *strings.Builder, value any, indent string) {
+	switch v := value.(type) {
+	case *astNode:
+		b.WriteString(v.name)
+		if v.line > 0 {
+			fmt.Fprintf(b, " (line %d)", v.line)
+		}
+		for _, field := range v.fields {
+			fmt.Fprintf(b, "\n%s  %s:", indent, field.name)
+			if elements, ok := field.value.([]any); !ok || len(elements) == 0 {
+				b.WriteString(" ")
+			}
+			writeASTText(b, field.value, indent+"  ")
+		}
+	case []any:
+		if len(v) == 0 {
+			b.WriteString("[]")
+			return
+		}
+		for _, elem := range v {
+			fmt.Fprintf(b, "\n%s  - ", indent)
+			writeASTText(b, elem, indent+"    ")
+		}
+	case string:
+		b.WriteString(strconv.Quote(v))
+	case nil:
+		b.WriteString("nil")
+	default:
+		fmt.Fprint(b, v)
+	}
+}
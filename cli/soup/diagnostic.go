@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ocowchun/soup/lexer"
+	"golang.org/x/term"
+)
+
+const colorCaret = "\033[1;31m"
+
+// stderrColor is whether errors are printed in color, only when stderr is a terminal
+var stderrColor = colorEnabled && term.IsTerminal(int(os.Stderr.Fd()))
+
+// errorSource returns the text a token of an error was read from: the file it names, or src, the
+// REPL entry or -e expression, when it names none.
+func errorSource(file string, src string) string {
+	if file == "" {
+		return src
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+	return string(content)
+}
+
+// tokenWidth returns how many bytes tok spans in line starting at start, 0 if the token isn't there,
+// e.g. line was edited since it was read.
+func tokenWidth(line string, start int, tok lexer.Token) int {
+	switch {
+	case start > len(line):
+		return 0
+	case tok.TokenType == lexer.TokenTypeEOF || tok.TokenType == lexer.TokenTypeInvalid:
+		// nothing to compare, the position is all there is
+		return 1
+	case tok.TokenType == lexer.TokenTypeString:
+		if start == len(line) || line[start] != '"' {
+			return 0
+		}
+		// up to the closing quote, or the end of the line for a multi-line string
+		for i := start + 1; i < len(line); i++ {
+			if line[i] == '\\' {
+				i++
+			} else if line[i] == '"' {
+				return i - start + 1
+			}
+		}
+		return len(line) - start
+	case tok.Content != "" && strings.HasPrefix(line[start:], tok.Content):
+		return len(tok.Content)
+	}
+	return 0
+}
+
+// sourceExcerpt returns the line of src tok is on with a caret under the token,
+//
+//	4 | (car '())
+//	  |  ^^^
+//
+// and "" when src doesn't hold the token at its position.
+func sourceExcerpt(src string, tok lexer.Token) string {
+	lines := strings.Split(src, "\n")
+	if tok.Line < 1 || tok.Line > len(lines) || tok.Column < 1 {
+		return ""
+	}
+	line := strings.TrimSuffix(lines[tok.Line-1], "\r")
+	start := tok.Column - 1
+	width := tokenWidth(line, start, tok)
+	if width == 0 {
+		return ""
+	}
+
+	// keep the tabs before the token so the caret lines up however they are displayed
+	var pad strings.Builder
+	for _, r := range line[:start] {
+		if r == '\t' {
+			pad.WriteByte('\t')
+		} else {
+			pad.WriteByte(' ')
+		}
+	}
+	caret := strings.Repeat("^", max(1, utf8.RuneCountInString(line[start:min(start+width, len(line))])))
+	if stderrColor {
+		caret = colorCaret + caret + colorReset
+	}
+
+	number := fmt.Sprint(tok.Line)
+	gutter := strings.Repeat(" ", len(number))
+	return fmt.Sprintf(" %s | %s\n %s | %s%s\n", number, line, gutter, pad.String(), caret)
+}
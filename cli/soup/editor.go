@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+type key int
+
+const (
+	keyNone key = iota
+	keyText
+	keyEnter
+	keyBackspace
+	keyDelete
+	keyLeft
+	keyRight
+	keyUp
+	keyDown
+	keyHome
+	keyEnd
+	keyWordLeft
+	keyWordRight
+	keyKillToEnd
+	keyKillToStart
+	keyDeleteWord
+	keyInterrupt
+	keySearch
+	keyCancel
+	keyEscape
+	keyPaste
+	keyEOF
+)
+
+// Terminals in bracketed paste mode wrap pasted text in these, so it isn't taken for keys.
+const (
+	bracketedPasteOn  = "\033[?2004h"
+	bracketedPasteOff = "\033[?2004l"
+	pasteStart        = "\033[200~"
+	pasteEnd          = "\033[201~"
+)
+
+// csiKeys maps the parameters and final byte of the escape sequences terminals send for special keys.
+var csiKeys = map[string]key{
+	"A":    keyUp,
+	"B":    keyDown,
+	"C":    keyRight,
+	"D":    keyLeft,
+	"H":    keyHome,
+	"F":    keyEnd,
+	"1~":   keyHome,
+	"7~":   keyHome,
+	"4~":   keyEnd,
+	"8~":   keyEnd,
+	"3~":   keyDelete,
+	"1;5C": keyWordRight,
+	"1;3C": keyWordRight,
+	"1;5D": keyWordLeft,
+	"1;3D": keyWordLeft,
+}
+
+var controlKeys = map[byte]key{
+	'\r': keyEnter,
+	'\n': keyEnter,
+	127:  keyBackspace,
+	8:    keyBackspace,   // Ctrl+H
+	1:    keyHome,        // Ctrl+A
+	5:    keyEnd,         // Ctrl+E
+	2:    keyLeft,        // Ctrl+B
+	6:    keyRight,       // Ctrl+F
+	16:   keyUp,          // Ctrl+P
+	14:   keyDown,        // Ctrl+N
+	11:   keyKillToEnd,   // Ctrl+K
+	21:   keyKillToStart, // Ctrl+U
+	23:   keyDeleteWord,  // Ctrl+W
+	3:    keyInterrupt,   // Ctrl+C
+	4:    keyEOF,         // Ctrl+D
+	18:   keySearch,      // Ctrl+R
+	7:    keyCancel,      // Ctrl+G
+}
+
+// pastedText decodes pasted bytes, line breaks become '\n' and other control characters are dropped.
+func pastedText(pasted []byte) []rune {
+	pasted = bytes.ReplaceAll(pasted, []byte("\r\n"), []byte("\n"))
+	pasted = bytes.ReplaceAll(pasted, []byte("\r"), []byte("\n"))
+	text := make([]rune, 0, len(pasted))
+	for _, r := range string(pasted) {
+		if r >= ' ' || r == '\n' || r == '\t' {
+			text = append(text, r)
+		}
+	}
+	return text
+}
+
+// readKey decodes the key at the start of input, a single read can hold several keys when typing fast
+// or pasting. It returns the key, the runes typed for keyText, and the number of bytes used, which is
+// 0 when input ends in the middle of a key and the rest of it has to be read first.
+func readKey(input []byte) (key, []rune, int) {
+	c := input[0]
+	if c == 27 {
+		if len(input) == 1 {
+			// Esc on its own is only known once the next key shows it doesn't start a sequence
+			return keyNone, nil, 0
+		}
+		if bytes.HasPrefix(input, []byte(pasteStart)) {
+			end := bytes.Index(input, []byte(pasteEnd))
+			if end < 0 {
+				return keyNone, nil, 0
+			}
+			return keyPaste, pastedText(input[len(pasteStart):end]), end + len(pasteEnd)
+		}
+		switch input[1] {
+		case '[', 'O':
+			end := 2
+			for end < len(input) && input[end] >= 0x30 && input[end] <= 0x3f {
+				end++
+			}
+			if end == len(input) {
+				return keyNone, nil, 0
+			}
+			return csiKeys[string(input[2:end+1])], nil, end + 1
+		case 'b':
+			return keyWordLeft, nil, 2
+		case 'f':
+			return keyWordRight, nil, 2
+		case 127:
+			return keyDeleteWord, nil, 2
+		}
+		return keyEscape, nil, 1
+	}
+	if k, ok := controlKeys[c]; ok {
+		return k, nil, 1
+	}
+	if c < ' ' && c != '\t' {
+		return keyNone, nil, 1
+	}
+
+	text := make([]rune, 0)
+	size := 0
+	for size < len(input) && (input[size] >= ' ' || input[size] == '\t') && input[size] != 127 {
+		if !utf8.FullRune(input[size:]) {
+			break
+		}
+		r, n := utf8.DecodeRune(input[size:])
+		text = append(text, r)
+		size += n
+	}
+	return keyText, text, size
+}
+
+// lineEditor holds the entry being typed at the prompt. An entry can span several rows, those after
+// the first are shown after continuationPrompt.
+type lineEditor struct {
+	buf    []rune
+	cursor int
+	prompt string
+	// row is the row the cursor was left on by the last render, counted from the prompt's row
+	row int
+}
+
+func (e *lineEditor) String() string {
+	return string(e.buf)
+}
+
+func (e *lineEditor) set(s string) {
+	e.buf = []rune(s)
+	e.cursor = len(e.buf)
+}
+
+// reset starts a new entry, the cursor must be at the start of an empty row.
+func (e *lineEditor) reset() {
+	e.buf = nil
+	e.cursor = 0
+	e.row = 0
+}
+
+func (e *lineEditor) insert(text []rune) {
+	buf := make([]rune, 0, len(e.buf)+len(text))
+	buf = append(buf, e.buf[:e.cursor]...)
+	buf = append(buf, text...)
+	e.buf = append(buf, e.buf[e.cursor:]...)
+	e.cursor += len(text)
+}
+
+// paste inserts pasted text as is, newlines included. It reports whether the text ended in a newline,
+// then the entry is submitted like Enter.
+func (e *lineEditor) paste(text []rune) bool {
+	pasted := string(text)
+	e.insert([]rune(strings.TrimRight(pasted, "\n")))
+	return strings.HasSuffix(pasted, "\n")
+}
+
+// deleteRange removes buf[from:to] and leaves the cursor at from.
+func (e *lineEditor) deleteRange(from, to int) {
+	e.buf = append(e.buf[:from], e.buf[to:]...)
+	e.cursor = from
+}
+
+func (e *lineEditor) backspace() {
+	if e.cursor > 0 {
+		e.deleteRange(e.cursor-1, e.cursor)
+	}
+}
+
+func (e *lineEditor) deleteForward() {
+	if e.cursor < len(e.buf) {
+		e.deleteRange(e.cursor, e.cursor+1)
+	}
+}
+
+func (e *lineEditor) left() {
+	if e.cursor > 0 {
+		e.cursor--
+	}
+}
+
+func (e *lineEditor) right() {
+	if e.cursor < len(e.buf) {
+		e.cursor++
+	}
+}
+
+// rowStart and rowEnd are the bounds of the row the cursor is on.
+func (e *lineEditor) rowStart() int {
+	i := e.cursor
+	for i > 0 && e.buf[i-1] != '\n' {
+		i--
+	}
+	return i
+}
+
+func (e *lineEditor) rowEnd() int {
+	i := e.cursor
+	for i < len(e.buf) && e.buf[i] != '\n' {
+		i++
+	}
+	return i
+}
+
+func isWordRune(r rune) bool {
+	return !strings.ContainsRune(" \t\n()'\"", r)
+}
+
+func (e *lineEditor) wordStart() int {
+	i := e.cursor
+	for i > 0 && !isWordRune(e.buf[i-1]) {
+		i--
+	}
+	for i > 0 && isWordRune(e.buf[i-1]) {
+		i--
+	}
+	return i
+}
+
+func (e *lineEditor) wordEnd() int {
+	i := e.cursor
+	for i < len(e.buf) && !isWordRune(e.buf[i]) {
+		i++
+	}
+	for i < len(e.buf) && isWordRune(e.buf[i]) {
+		i++
+	}
+	return i
+}
+
+// edit applies an editing key, it reports false for keys that aren't editing keys.
+func (e *lineEditor) edit(k key, text []rune) bool {
+	switch k {
+	case keyText:
+		e.insert(text)
+	case keyBackspace:
+		e.backspace()
+	case keyDelete:
+		e.deleteForward()
+	case keyLeft:
+		e.left()
+	case keyRight:
+		e.right()
+	case keyHome:
+		e.cursor = e.rowStart()
+	case keyEnd:
+		e.cursor = e.rowEnd()
+	case keyWordLeft:
+		e.cursor = e.wordStart()
+	case keyWordRight:
+		e.cursor = e.wordEnd()
+	case keyKillToEnd:
+		e.deleteRange(e.cursor, e.rowEnd())
+	case keyKillToStart:
+		e.deleteRange(e.rowStart(), e.cursor)
+	case keyDeleteWord:
+		e.deleteRange(e.wordStart(), e.cursor)
+	default:
+		return false
+	}
+	return true
+}
+
+// clear erases the entry from the screen and leaves the cursor at the start of the prompt's row.
+func (e *lineEditor) clear() {
+	if e.row > 0 {
+		fmt.Printf("\033[%dA", e.row)
+	}
+	fmt.Print("\r\033[J")
+	e.row = 0
+}
+
+func (e *lineEditor) render() {
+	e.draw(len(string(e.buf[:e.cursor])))
+}
+
+// draw redraws the entry, highlighting the paren matching a closing paren just before the byte
+// offset match.
+func (e *lineEditor) draw(match int) {
+	e.clear()
+
+	var b strings.Builder
+	b.WriteString(e.prompt)
+	text := string(e.buf)
+	highlighted := highlight(text, match)
+	b.WriteString(strings.ReplaceAll(highlighted, "\n", "\r\n"+continuationPrompt))
+
+	// move the cursor from the end of the entry back to its place
+	row, column := 0, len(e.prompt)
+	for _, r := range e.buf[:e.cursor] {
+		if r == '\n' {
+			row++
+			column = len(continuationPrompt)
+		} else {
+			column++
+		}
+	}
+	if up := strings.Count(text, "\n") - row; up > 0 {
+		fmt.Fprintf(&b, "\033[%dA", up)
+	}
+	b.WriteString("\r")
+	if column > 0 {
+		fmt.Fprintf(&b, "\033[%dC", column)
+	}
+	e.row = row
+	fmt.Print(b.String())
+}
+
+// finish redraws the entry without a paren highlight and moves the cursor below it, so the next
+// output starts on a fresh row.
+func (e *lineEditor) finish() {
+	e.cursor = len(e.buf)
+	e.draw(-1)
+	fmt.Print("\r\n")
+	e.row = 0
+}
@@ -0,0 +1,164 @@
+package main
+
+import "testing"
+
+func TestReadKey(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedKey  key
+		expectedText string
+		expectedSize int
+	}{
+		{"a", keyText, "a", 1},
+		{"abc", keyText, "abc", 3},
+		{"é(", keyText, "é(", 3},
+		{"ab\r", keyText, "ab", 2},
+		{"\t", keyText, "\t", 1},
+		{"\r", keyEnter, "", 1},
+		{"\n", keyEnter, "", 1},
+		{"\x7f", keyBackspace, "", 1},
+		{"\x08", keyBackspace, "", 1},
+		{"\x01", keyHome, "", 1},
+		{"\x05", keyEnd, "", 1},
+		{"\x0b", keyKillToEnd, "", 1},
+		{"\x15", keyKillToStart, "", 1},
+		{"\x17", keyDeleteWord, "", 1},
+		{"\x03", keyInterrupt, "", 1},
+		{"\x04", keyEOF, "", 1},
+		{"\x12", keySearch, "", 1},
+		{"\x07", keyCancel, "", 1},
+		{"\x1b[A", keyUp, "", 3},
+		{"\x1b[B", keyDown, "", 3},
+		{"\x1b[C", keyRight, "", 3},
+		{"\x1b[D", keyLeft, "", 3},
+		{"\x1bOH", keyHome, "", 3},
+		{"\x1b[4~", keyEnd, "", 4},
+		{"\x1b[3~x", keyDelete, "", 4},
+		{"\x1b[1;5C", keyWordRight, "", 6},
+		{"\x1b[1;3D", keyWordLeft, "", 6},
+		{"\x1b[9~", keyNone, "", 4},
+		{"\x1bb", keyWordLeft, "", 2},
+		{"\x1bf", keyWordRight, "", 2},
+		{"\x1b\x7f", keyDeleteWord, "", 2},
+		{"\x1bx", keyEscape, "", 1},
+		{"\x1b[200~(+ 1\r\n2)\x1b[201~", keyPaste, "(+ 1\n2)", 20},
+		// the rest of a key that's split across reads has to be read first
+		{"\x1b", keyNone, "", 0},
+		{"\x1b[1;5", keyNone, "", 0},
+		{"\x1b[200~(+ 1", keyNone, "", 0},
+		{"\xc3", keyText, "", 0},
+		{"\x1a", keyNone, "", 1},
+	}
+
+	for _, tt := range tests {
+		k, text, size := readKey([]byte(tt.input))
+		if k != tt.expectedKey || string(text) != tt.expectedText || size != tt.expectedSize {
+			t.Fatalf("input %q, expected %v %q %d, got %v %q %d", tt.input, tt.expectedKey, tt.expectedText, tt.expectedSize, k, string(text), size)
+		}
+	}
+}
+
+// typeKeys applies the keys in input to an editor holding initial with the cursor at its end, the way
+// the REPL reads them.
+func typeKeys(t *testing.T, initial string, input string) *lineEditor {
+	t.Helper()
+	editor := &lineEditor{}
+	editor.set(initial)
+	for chunk := []byte(input); len(chunk) > 0; {
+		k, text, size := readKey(chunk)
+		if size == 0 {
+			t.Fatalf("input %q ends in the middle of a key", input)
+		}
+		chunk = chunk[size:]
+		if k == keyPaste {
+			editor.paste(text)
+			continue
+		}
+		editor.edit(k, text)
+	}
+	return editor
+}
+
+func TestLineEditor(t *testing.T) {
+	tests := []struct {
+		initial        string
+		input          string
+		expectedBuffer string
+		expectedCursor int
+	}{
+		{"", "(car x)", "(car x)", 7},
+		{"", "(cdr x)\x1b[D\x7fy", "(cdr y)", 6},
+		// backspace and delete stop at the ends of the entry
+		{"ab", "\x7f\x7f\x7f", "", 0},
+		{"ab", "\x1b[3~", "ab", 2},
+		{"ab", "\x01\x1b[3~", "b", 0},
+		{"ab", "\x1b[D\x1b[D\x1b[D\x1b[C", "ab", 1},
+		{"", "é\x1b[Dx", "xé", 1},
+		// home and end go to the ends of the row the cursor is on
+		{"(define x\n  1)", "\x01;", "(define x\n;  1)", 11},
+		{"(define x\n  1)", "\x1b[A\x01\x05", "(define x\n  1)", 14},
+		{"(define x\n  1)", "\x01\x1b[D\x01", "(define x\n  1)", 0},
+		// words stop at spaces, parens and quotes
+		{"(map car items)", "\x17", "(map car ", 9},
+		{"(map car items)  ", "\x17", "(map car ", 9},
+		{"(map car items)", "\x1bb\x1bb\x1b\x7f", "(car items)", 1},
+		{"(map car items)", "\x01\x1b[1;5C\x1b[1;5C", "(map car items)", 8},
+		{"(map car items)", "\x1b[1;3D\x1b[1;3D\x0b", "(map ", 5},
+		{"(map car items)", "\x1b[1;3D\x15", "items)", 0},
+		{"(a\n(b c", "\x15", "(a\n", 3},
+		// a paste is inserted as is, an Esc on its own does nothing
+		{"(list )", "\x1b[D\x1b[200~1\r2\x1b[201~\x1bx", "(list 1\n2x)", 10},
+	}
+
+	for _, tt := range tests {
+		editor := typeKeys(t, tt.initial, tt.input)
+		if editor.String() != tt.expectedBuffer || editor.cursor != tt.expectedCursor {
+			t.Fatalf("%q after %q, expected %q with the cursor at %d, got %q at %d", tt.initial, tt.input, tt.expectedBuffer, tt.expectedCursor, editor.String(), editor.cursor)
+		}
+	}
+}
+
+func TestLineEditor_NotEditing(t *testing.T) {
+	editor := &lineEditor{}
+	editor.set("(+ 1 2)")
+	for _, k := range []key{keyEnter, keyUp, keyDown, keyInterrupt, keyEOF, keySearch, keyCancel, keyEscape, keyNone} {
+		if editor.edit(k, nil) {
+			t.Fatalf("expected key %v not to be an editing key", k)
+		}
+	}
+	if editor.String() != "(+ 1 2)" || editor.cursor != 7 {
+		t.Fatalf("expected the entry to be unchanged, got %q at %d", editor.String(), editor.cursor)
+	}
+}
+
+func TestLineEditor_Paste(t *testing.T) {
+	tests := []struct {
+		initial        string
+		input          string
+		expectedBuffer string
+		expectedCursor int
+		expectedSubmit bool
+	}{
+		// the newlines of a paste are part of the entry, unlike Enter they don't submit it
+		{"", "(define (square x)\r\n  (* x x))", "(define (square x)\n  (* x x))", 29, false},
+		{"", "(display 1)\n(display 2)", "(display 1)\n(display 2)", 23, false},
+		{"(list ", "1\n\t2", "(list 1\n\t2", 10, false},
+		{"", "\"a\x1b[Db\"", "\"a[Db\"", 6, false},
+		// text copied with its final newline is submitted, as if Enter followed
+		{"", "(+ 1 2)\n", "(+ 1 2)", 7, true},
+		{"", "(+ 1\n2)\r\n\n", "(+ 1\n2)", 7, true},
+	}
+
+	for _, tt := range tests {
+		editor := &lineEditor{}
+		editor.set(tt.initial)
+		k, text, size := readKey([]byte(pasteStart + tt.input + pasteEnd))
+		if k != keyPaste || size != len(pasteStart)+len(tt.input)+len(pasteEnd) {
+			t.Fatalf("%q, expected a paste of %d bytes, got %v of %d", tt.input, len(pasteStart)+len(tt.input)+len(pasteEnd), k, size)
+		}
+		submit := editor.paste(text)
+		if editor.String() != tt.expectedBuffer || editor.cursor != tt.expectedCursor || submit != tt.expectedSubmit {
+			t.Fatalf("%q, expected %q with the cursor at %d and submit %v, got %q at %d and %v", tt.input, tt.expectedBuffer, tt.expectedCursor, tt.expectedSubmit, editor.String(), editor.cursor, submit)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime/debug"
+
+	"github.com/ocowchun/soup/evaluator"
+)
+
+// quiet drops the banners and the "Result:" prefix, so only the program's own output is printed
+var quiet bool
+
+// preloadFiles are loaded by the REPL before its first prompt
+var preloadFiles []string
+
+// limits are the resource limits of every file, -e expression and REPL entry
+var limits evaluator.Limits
+
+// tracer is set by --trace and --trace-file, nil when calls aren't traced
+var tracer evaluator.Tracer
+
+// outputFormat is how the results of -e expressions and files are printed, "text" or "json"
+var outputFormat = "text"
+
+// capabilities are what the builtins may reach, --no-net and --sandbox take some of them away
+var capabilities = evaluator.AllCapabilities
+
+// postMortem starts a REPL in the failing frame when running the files fails
+var postMortem bool
+
+// unbuffered writes display output as it's printed rather than a line at a time
+var unbuffered bool
+
+// optimize rewrites the programs before running them, see evaluator.WithOptimizer
+var optimize bool
+
+// shadowing lets parameters and let bindings be named after builtins such as not and +
+var shadowing bool
+
+// lang is the dialect given with --lang, "racket" for evaluator.WithRacket and "mit" for
+// evaluator.WithMITCompat, "" by default
+var lang string
+
+// version is set when building a release with -ldflags "-X main.version=v1.2.3"
+var version = ""
+
+func versionString() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "devel"
+}
+
+const usageHeader = `usage: soup [flags] [file ...] [-- arg ...]
+       soup tokens|ast [-json] file
+       soup lsp|dap
+
+Without files soup starts a REPL, otherwise it runs the files in order in one environment.
+The arguments after the files, or after --, are returned by (command-line).
+tokens and ast print what the lexer and the parser make of a file, lsp starts a language server
+and dap a debug adapter.
+
+flags:
+`
+
+// parseFlags sets the options from the flags in arguments and returns the arguments after them.
+// Unknown flags print the usage and exit with status 2, --help prints it and exits with status 0.
+func parseFlags(arguments []string) []string {
+	fs := flag.NewFlagSet("soup", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usageHeader)
+		fs.PrintDefaults()
+	}
+
+	addEval := func(src string) error {
+		evalSources = append(evalSources, src)
+		return nil
+	}
+	fs.Func("e", "evaluate the expressions and print the result, can be given multiple times", addEval)
+	fs.Func("eval", "same as -e", addEval)
+	fs.Func("path", "add a directory to the load/import search path, can be given multiple times; searched before $"+evaluator.SearchPathEnv, func(dir string) error {
+		searchPath = append(searchPath, dir)
+		return nil
+	})
+	fs.Func("load", "load a file before starting the REPL, can be given multiple times", func(fileName string) error {
+		preloadFiles = append(preloadFiles, fileName)
+		return nil
+	})
+	fs.IntVar(&printLimits.MaxDepth, "print-depth", printLimits.MaxDepth, "how deeply nested REPL results are printed, 0 for no limit")
+	fs.IntVar(&printLimits.MaxLength, "print-length", printLimits.MaxLength, "how many elements of a list in a REPL result are printed, 0 for no limit")
+	fs.DurationVar(&limits.Timeout, "timeout", 0, "stop a run taking longer than this, e.g. 5s")
+	fs.Uint64Var(&limits.MaxSteps, "max-steps", 0, "stop a run after evaluating this many expressions")
+	fs.IntVar(&limits.MaxDepth, "max-depth", 0, fmt.Sprintf("stop a run whose procedure calls nest deeper than this, %d when it's 0", evaluator.DefaultMaxDepth))
+	fs.Uint64Var(&limits.MaxCells, "max-cells", 0, "stop a run after allocating about this many cells of memory, a cell being a variable, list element or 16 bytes of a string")
+	fs.BoolFunc("trace", "log every procedure call and return to stderr", func(string) error {
+		tracer = &writerTracer{w: os.Stderr}
+		return nil
+	})
+	fs.Func("trace-file", "log every procedure call and return to a file", func(fileName string) error {
+		file, err := os.Create(fileName)
+		if err != nil {
+			return err
+		}
+		// writes aren't buffered, so the file is complete even when soup exits on an error
+		tracer = &writerTracer{w: file}
+		return nil
+	})
+	fs.BoolVar(&unbuffered, "unbuffered", false, "write the output of display right away instead of a line at a time, e.g. to interleave it with --trace")
+	fs.BoolVar(&shadowing, "shadow-builtins", false, "let parameters and let bindings be named after builtins such as not and +")
+	fs.Func("lang", "the dialect to accept: racket for brackets as parens, require, provide, add1 and the like, mit for 1+, -1+ and errors reported the way MIT Scheme does", func(name string) error {
+		if name != "racket" && name != "mit" {
+			return fmt.Errorf("unknown language %q, expected racket or mit", name)
+		}
+		lang = name
+		return nil
+	})
+	fs.BoolFunc("no-net", "deny the network to the programs, http-get and http-post raise a permission-denied condition", func(string) error {
+		capabilities &^= evaluator.CapabilityNetwork
+		return nil
+	})
+	fs.BoolFunc("sandbox", "deny the network and the file system to the programs, like --no-net but for load, import and save-image as well; the files given to run still run", func(string) error {
+		capabilities = evaluator.NoCapabilities
+		return nil
+	})
+	fs.BoolVar(&optimize, "optimize", false, "fold constant arithmetic and inline simple lets before evaluating")
+	fs.BoolVar(&postMortem, "post-mortem", false, "when running the files fails, start a REPL in the frame that raised the error")
+	fs.BoolVar(&timing, "time", false, "report the time, evaluation steps and peak heap of running the files on stderr")
+	fs.BoolVar(&quiet, "quiet", false, "don't print banners or the \"Result:\" prefix")
+	fs.Func("output", "print the results of -e and files as text (the default) or json", func(format string) error {
+		if format != "text" && format != "json" {
+			return fmt.Errorf("unknown output format %q, expected text or json", format)
+		}
+		outputFormat = format
+		return nil
+	})
+	showVersion := fs.Bool("version", false, "print the version and exit")
+	fs.Parse(arguments)
+	if outputFormat == "json" {
+		// only the results, so the output can be parsed
+		quiet = true
+	}
+
+	if *showVersion {
+		fmt.Println("soup", versionString())
+		os.Exit(0)
+	}
+
+	args := fs.Args()
+	if n := len(arguments) - len(args); n > 0 && arguments[n-1] == "--" {
+		// flag drops the "--" ending the flags, splitArgs still needs it
+		args = append([]string{"--"}, args...)
+	}
+	return args
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ocowchun/soup/lexer"
+)
+
+const (
+	colorReset   = "\033[0m"
+	colorString  = "\033[32m"
+	colorNumber  = "\033[36m"
+	colorKeyword = "\033[35m"
+	colorComment = "\033[90m"
+	// matchHighlight marks the paren matching the one just typed
+	matchHighlight = "\033[1;7m"
+)
+
+// colorEnabled follows https://no-color.org
+var colorEnabled = os.Getenv("NO_COLOR") == ""
+
+type colorSpan struct {
+	start, end int
+	color      string
+}
+
+// scanLine finds the spans to color in line, and maps the index of every closing paren to the
+// index of the opening paren it matches.
+func scanLine(line string) ([]colorSpan, map[int]int) {
+	spans := make([]colorSpan, 0)
+	matches := make(map[int]int)
+	opens := make([]int, 0)
+	isDelimiter := func(c byte) bool {
+		return c == ' ' || c == '\t' || c == '\n' || c == '(' || c == ')' || c == '"' || c == ';'
+	}
+
+	for i := 0; i < len(line); {
+		switch c := line[i]; {
+		case c == '(':
+			opens = append(opens, i)
+			i++
+		case c == ')':
+			if len(opens) > 0 {
+				matches[i] = opens[len(opens)-1]
+				opens = opens[:len(opens)-1]
+			}
+			i++
+		case c == ';':
+			end := strings.IndexByte(line[i:], '\n')
+			if end < 0 {
+				end = len(line) - i
+			}
+			spans = append(spans, colorSpan{i, i + end, colorComment})
+			i += end
+		case c == '"':
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			end = min(end+1, len(line))
+			spans = append(spans, colorSpan{i, end, colorString})
+			i = end
+		case strings.HasPrefix(line[i:], `#\`):
+			// a character, which may be a paren or a quote itself
+			end := i + 2
+			if end < len(line) {
+				_, size := utf8.DecodeRuneInString(line[end:])
+				end += size
+			}
+			for end < len(line) && !isDelimiter(line[end]) {
+				end++
+			}
+			spans = append(spans, colorSpan{i, end, colorString})
+			i = end
+		case isDelimiter(c) || c == '\'':
+			i++
+		default:
+			end := i
+			for end < len(line) && !isDelimiter(line[end]) {
+				end++
+			}
+			word := line[i:end]
+			if _, err := lexer.ParseNumber(word); err == nil || word == "#t" || word == "#f" {
+				spans = append(spans, colorSpan{i, end, colorNumber})
+			} else if tokenType, ok := lexer.LookupKeyword(word); ok && tokenType != lexer.TokenTypeTrue && tokenType != lexer.TokenTypeFalse {
+				spans = append(spans, colorSpan{i, end, colorKeyword})
+			}
+			i = end
+		}
+	}
+	return spans, matches
+}
+
+// highlight colors line for display. When the cursor follows a closing paren, its matching opening
+// paren is highlighted until the next keystroke redraws the line.
+func highlight(line string, cursor int) string {
+	if !colorEnabled {
+		return line
+	}
+
+	spans, matches := scanLine(line)
+	match := -1
+	if cursor > 0 && cursor <= len(line) && line[cursor-1] == ')' {
+		if open, ok := matches[cursor-1]; ok {
+			match = open
+		}
+	}
+
+	var b strings.Builder
+	next := 0
+	for _, span := range spans {
+		writeHighlightedParens(&b, line[next:span.start], next, match)
+		b.WriteString(span.color)
+		b.WriteString(line[span.start:span.end])
+		b.WriteString(colorReset)
+		next = span.end
+	}
+	writeHighlightedParens(&b, line[next:], next, match)
+	return b.String()
+}
+
+func writeHighlightedParens(b *strings.Builder, text string, offset int, match int) {
+	if match < offset || match >= offset+len(text) {
+		b.WriteString(text)
+		return
+	}
+	b.WriteString(text[:match-offset])
+	b.WriteString(matchHighlight)
+	b.WriteByte(text[match-offset])
+	b.WriteString(colorReset)
+	b.WriteString(text[match-offset+1:])
+}
@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestHighlight(t *testing.T) {
+	defer func(enabled bool) { colorEnabled = enabled }(colorEnabled)
+	colorEnabled = true
+
+	str := func(s string) string { return colorString + s + colorReset }
+	num := func(s string) string { return colorNumber + s + colorReset }
+	kw := func(s string) string { return colorKeyword + s + colorReset }
+	comment := func(s string) string { return colorComment + s + colorReset }
+	tests := []struct {
+		line           string
+		cursor         int
+		expectedOutput string
+	}{
+		{"(car x)", -1, "(car x)"},
+		{`(display "hi (there)")`, -1, `(display ` + str(`"hi (there)"`) + `)`},
+		{`"a \" b"`, -1, str(`"a \" b"`)},
+		{`"open`, -1, str(`"open`)},
+		{"(+ 1 -2.5 1/3)", -1, "(+ " + num("1") + " " + num("-2.5") + " " + num("1/3") + ")"},
+		{"(if #t #f)", -1, "(" + kw("if") + " " + num("#t") + " " + num("#f") + ")"},
+		{"(define (f x) (lambda (y) x))", -1, "(" + kw("define") + " (f x) (" + kw("lambda") + " (y) x))"},
+		{"(list #\\( #\\a #\\space)", -1, "(list " + str(`#\(`) + " " + str(`#\a`) + " " + str(`#\space`) + ")"},
+		{"x ; the (rest)\n(if", -1, "x " + comment("; the (rest)") + "\n(" + kw("if")},
+		{"'(a 1)", -1, "'(a " + num("1") + ")"},
+		// the paren matching one just before the cursor is highlighted
+		{"(+ (f 1) 2)", 8, "(+ " + matchHighlight + "(" + colorReset + "f " + num("1") + ") " + num("2") + ")"},
+		{"(+ (f 1) 2)", 11, matchHighlight + "(" + colorReset + "+ (f " + num("1") + ") " + num("2") + ")"},
+		{"(+ (f 1) 2)", 7, "(+ (f " + num("1") + ") " + num("2") + ")"},
+		{"(f \")\")", 6, "(f " + str(`")"`) + ")"},
+		{"(list #\\( x)", 12, matchHighlight + "(" + colorReset + "list " + str(`#\(`) + " x)"},
+		{"x)", 2, "x)"},
+	}
+
+	for _, tt := range tests {
+		if output := highlight(tt.line, tt.cursor); output != tt.expectedOutput {
+			t.Fatalf("%q at %d, expected %q, got %q", tt.line, tt.cursor, tt.expectedOutput, output)
+		}
+	}
+
+	colorEnabled = false
+	if output := highlight(`(if "a" 1)`, -1); output != `(if "a" 1)` {
+		t.Fatalf("expected no color with NO_COLOR, got %q", output)
+	}
+}
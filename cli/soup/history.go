@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// historySearch is the state of a Ctrl+R reverse incremental search over the REPL history.
+type historySearch struct {
+	query string
+	// match is the index in history of the entry shown, -1 when nothing matches the query
+	match int
+	// original is the line being typed when the search started, restored if it's cancelled
+	original string
+}
+
+// searchHistory returns the index of the most recent entry at or before from that contains query,
+// or -1 when there isn't one.
+func searchHistory(history []string, query string, from int) int {
+	for i := min(from, len(history)-1); i >= 0; i-- {
+		if strings.Contains(history[i], query) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *historySearch) render(history []string) {
+	fmt.Print("\033[2K\r")
+	if s.match < 0 && s.query != "" {
+		fmt.Printf("(failed reverse-i-search)`%s': ", s.query)
+		return
+	}
+	fmt.Printf("(reverse-i-search)`%s': ", s.query)
+	if s.match >= 0 {
+		// a multi-line entry is shown on one row
+		fmt.Print(strings.ReplaceAll(highlight(history[s.match], -1), "\n", " "))
+	}
+}
+
+// current is the line the search would leave in the prompt if it were accepted now.
+func (s *historySearch) current(history []string) string {
+	if s.match < 0 {
+		return s.original
+	}
+	return history[s.match]
+}
+
+func (s *historySearch) setQuery(history []string, query string) {
+	s.query = query
+	s.match = searchHistory(history, query, len(history)-1)
+}
+
+// older moves to the next older entry matching the query, as pressing Ctrl+R again does.
+func (s *historySearch) older(history []string) {
+	if s.match <= 0 {
+		return
+	}
+	if match := searchHistory(history, s.query, s.match-1); match >= 0 {
+		s.match = match
+	}
+}
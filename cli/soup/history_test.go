@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+func TestSearchHistory(t *testing.T) {
+	history := []string{"(define x 1)", "(car lst)", "(define (f y)\n  (* y y))", "(f x)"}
+	tests := []struct {
+		query         string
+		from          int
+		expectedMatch int
+	}{
+		{"define", 3, 2},
+		{"define", 1, 0},
+		{"define", 0, 0},
+		{"(", 3, 3},
+		{"", 3, 3},
+		{"* y", 3, 2},
+		{"x", 2, 0},
+		{"cdr", 3, -1},
+		{"(f x)", 2, -1},
+		// from past the end starts at the latest entry
+		{"car", 10, 1},
+	}
+
+	for _, tt := range tests {
+		if match := searchHistory(history, tt.query, tt.from); match != tt.expectedMatch {
+			t.Fatalf("%q from %d, expected %d, got %d", tt.query, tt.from, tt.expectedMatch, match)
+		}
+	}
+	if match := searchHistory(nil, "x", 0); match != -1 {
+		t.Fatalf("expected no match in an empty history, got %d", match)
+	}
+}
+
+func TestHistorySearch(t *testing.T) {
+	history := []string{"(define a 1)", "(display a)", "(define b 2)", "(+ a b)", "(define c 3)"}
+	search := &historySearch{match: -1, original: "(typed"}
+
+	// typing the query finds the latest match, each Ctrl+R an older one
+	search.setQuery(history, "def")
+	expected := []int{4, 2, 0, 0}
+	for i, match := range expected {
+		if i > 0 {
+			search.older(history)
+		}
+		if search.match != match {
+			t.Fatalf("after %d Ctrl+R, expected entry %d, got %d", i, match, search.match)
+		}
+	}
+	if search.current(history) != "(define a 1)" {
+		t.Fatalf("expected the oldest match, got %q", search.current(history))
+	}
+
+	// a longer query starts over from the latest entry
+	search.setQuery(history, "define b")
+	if search.match != 2 || search.current(history) != "(define b 2)" {
+		t.Fatalf("expected (define b 2), got %d", search.match)
+	}
+	search.older(history)
+	if search.match != 2 {
+		t.Fatalf("expected Ctrl+R to stay on the only match, got %d", search.match)
+	}
+
+	// without a match, accepting the search leaves the line that was being typed
+	search.setQuery(history, "lambda")
+	if search.match != -1 || search.current(history) != "(typed" {
+		t.Fatalf("expected no match and the original line, got %d %q", search.match, search.current(history))
+	}
+	search.older(history)
+	if search.match != -1 {
+		t.Fatalf("expected Ctrl+R without a match to find none, got %d", search.match)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/ocowchun/soup/lexer"
+)
+
+const (
+	prompt             = "soup> "
+	continuationPrompt = "  ... "
+	// postMortemPrompt is shown by the REPL --post-mortem starts in the frame that failed
+	postMortemPrompt = "debug> "
+)
+
+// inputComplete reports whether src can be submitted: every opening paren, or bracket with --lang
+// racket, is closed and no string is left open. Anything else, including stray closing parens, is
+// left for the parser to report.
+func inputComplete(src string) bool {
+	l := lexer.New(strings.NewReader(src))
+	if lang == "racket" {
+		l.AcceptBrackets()
+	}
+	depth := 0
+	for {
+		tok := l.NextToken()
+		switch tok.TokenType {
+		case lexer.TokenTypeEOF:
+			return depth <= 0
+		case lexer.TokenTypeInvalid:
+			return !strings.HasPrefix(tok.Content, "unterminated string")
+		case lexer.TokenTypeLeftParen:
+			depth++
+		case lexer.TokenTypeRightParen:
+			depth--
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+func TestInputComplete(t *testing.T) {
+	tests := []struct {
+		src      string
+		complete bool
+	}{
+		{"", true},
+		{"42", true},
+		{"(+ 1 2)", true},
+		{"(+ 1", false},
+		{"(define (f x)\n  (* x x)", false},
+		{"(define (f x)\n  (* x x))", true},
+		{"(list '(1 2)", false},
+		{"#(1 2", false},
+		// parens in strings don't count, a string left open needs another line
+		{`(display "(")`, true},
+		{`(display ")"`, false},
+		{`(display "a`, false},
+		{`(display "a\"`, false},
+		{"\"two\nlines\"", true},
+		// nor do character literals
+		{`(list #\()`, true},
+		{`(list #\)`, false},
+		{`(list #\( #\)`, false},
+		{`(eq? #\" #\")`, true},
+		// nor comments
+		{"; (", true},
+		{"(+ 1 ; )", false},
+		{"(+ 1 ; )\n2)", true},
+		// stray closers are left for the parser to report
+		{")", true},
+		{"())", true},
+		{"(a))(b", true},
+	}
+
+	for _, tt := range tests {
+		if complete := inputComplete(tt.src); complete != tt.complete {
+			t.Fatalf("%q, expected complete %v, got %v", tt.src, tt.complete, complete)
+		}
+	}
+}
+
+func TestInputComplete_Racket(t *testing.T) {
+	defer func(previous string) { lang = previous }(lang)
+	tests := []struct {
+		src      string
+		complete bool
+	}{
+		{"(let ([x 1]", false},
+		{"(let ([x 1]\n      [y 2])", false},
+		{"(let ([x 1]\n      [y 2])\n  (+ x y))", true},
+		{"[", false},
+		{`(list #\[)`, true},
+		// a bracket closed by a paren is left for the parser to report
+		{"(let ([x 1)", true},
+	}
+
+	lang = "racket"
+	for _, tt := range tests {
+		if complete := inputComplete(tt.src); complete != tt.complete {
+			t.Fatalf("%q, expected complete %v, got %v", tt.src, tt.complete, complete)
+		}
+	}
+
+	// elsewhere brackets are part of identifiers
+	lang = ""
+	if !inputComplete("(f [x)") {
+		t.Fatalf("expected brackets not to count without --lang racket")
+	}
+}
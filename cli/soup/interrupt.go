@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+
+	"github.com/ocowchun/soup/evaluator"
+)
+
+// errInterrupted is the cause of an evaluation canceled by Ctrl+C
+var errInterrupted = errors.New("interrupted")
+
+// interruptible returns a context canceled with errInterrupted when the process gets SIGINT, and a
+// func releasing it once the evaluation is over. Only the first SIGINT is caught, so a second Ctrl+C
+// still kills an evaluation blocked on a builtin that doesn't check for cancellation, such as read.
+func interruptible() (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+			signal.Stop(signals)
+			cancel(errInterrupted)
+		case <-done:
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		close(done)
+		cancel(nil)
+	}
+}
+
+// isInterrupted reports whether err is the cancellation of an interruptible context.
+func isInterrupted(err error) bool {
+	return errors.Is(err, evaluator.ErrCanceled)
+}
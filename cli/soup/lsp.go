@@ -0,0 +1,526 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/ocowchun/soup/evaluator"
+	"github.com/ocowchun/soup/lexer"
+	"github.com/ocowchun/soup/parser"
+)
+
+// `soup lsp` is a language server speaking the Language Server Protocol on stdin and stdout. It
+// reports the syntax errors of open documents, shows the signature and docstring of a procedure on
+// hover, jumps to the defines of a name and completes names from the document and the builtins.
+// Documents are only read, never evaluated, so definitions are found by looking at their tokens and
+// still are when the document doesn't parse.
+
+// numbers the protocol defines, see https://microsoft.github.io/language-server-protocol/specification
+const (
+	lspSyncFull            = 1
+	lspSeverityError       = 1
+	lspCompletionFunction  = 3
+	lspCompletionVariable  = 6
+	lspErrorMethodNotFound = -32601
+	lspErrorInvalidParams  = -32602
+)
+
+// lspMaxCompletions is how many names a completion returns, the client asks again as more is typed
+const lspMaxCompletions = 200
+
+type lspMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  any              `json:"result,omitempty"`
+	Error   *lspError        `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspCompletionItem struct {
+	Label  string `json:"label"`
+	Kind   int    `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type lspTextDocumentPosition struct {
+	TextDocument struct {
+		URI string `json:"uri"`
+	} `json:"textDocument"`
+	Position lspPosition `json:"position"`
+}
+
+type lspServer struct {
+	in  *bufio.Reader
+	out io.Writer
+	// documents holds the text of the open documents by URI
+	documents map[string]string
+	// builtins has the builtins and the prelude, to describe and complete the names they bind
+	builtins *evaluator.Environment
+	shutdown bool
+}
+
+func runLSP(arguments []string) int {
+	if len(arguments) > 0 {
+		fmt.Fprintln(os.Stderr, "usage: soup lsp\n\nstarts a language server on stdin and stdout")
+		return 2
+	}
+	s := &lspServer{
+		in:        bufio.NewReader(os.Stdin),
+		out:       os.Stdout,
+		documents: make(map[string]string),
+		builtins:  evaluator.New(evaluator.WithSandbox(evaluator.NoCapabilities)).GlobalEnvironment(),
+	}
+	return s.serve()
+}
+
+// serve handles messages until the client exits, and returns the exit status the protocol asks for.
+func (s *lspServer) serve() int {
+	for {
+		msg, err := s.read()
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintln(os.Stderr, "soup lsp:", err)
+			}
+			return 1
+		}
+		if msg.Method == "exit" {
+			if s.shutdown {
+				return 0
+			}
+			return 1
+		}
+
+		result, rpcErr := s.handle(msg)
+		// notifications have no id and get no response
+		if msg.ID != nil {
+			s.write(lspMessage{JSONRPC: "2.0", ID: msg.ID, Result: result, Error: rpcErr})
+		}
+	}
+}
+
+// readFrame reads the body of a message framed with a Content-Length header, the framing the
+// language server and the debug adapter protocols share.
+func readFrame(in *bufio.Reader) ([]byte, error) {
+	header, err := textproto.NewReader(in).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length header: %w", err)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(in, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func writeFrame(out io.Writer, body []byte) {
+	fmt.Fprintf(out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (s *lspServer) read() (*lspMessage, error) {
+	body, err := readFrame(s.in)
+	if err != nil {
+		return nil, err
+	}
+	var msg lspMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+	return &msg, nil
+}
+
+func (s *lspServer) write(msg lspMessage) {
+	if msg.Result == nil && msg.Error == nil && msg.ID != nil {
+		// a response without a result means null, which omitempty would drop
+		msg.Result = json.RawMessage("null")
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "soup lsp:", err)
+		return
+	}
+	writeFrame(s.out, body)
+}
+
+func (s *lspServer) notify(method string, params any) {
+	body, err := json.Marshal(params)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "soup lsp:", err)
+		return
+	}
+	s.write(lspMessage{JSONRPC: "2.0", Method: method, Params: body})
+}
+
+func (s *lspServer) handle(msg *lspMessage) (any, *lspError) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":   lspSyncFull,
+				"hoverProvider":      true,
+				"definitionProvider": true,
+				"completionProvider": map[string]any{},
+			},
+			"serverInfo": map[string]any{"name": "soup", "version": versionString()},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params struct {
+			TextDocument struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{Code: lspErrorInvalidParams, Message: err.Error()}
+		}
+		s.update(params.TextDocument.URI, params.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		var params struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{Code: lspErrorInvalidParams, Message: err.Error()}
+		}
+		// with full sync, the last change holds the whole document
+		if n := len(params.ContentChanges); n > 0 {
+			s.update(params.TextDocument.URI, params.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var params lspTextDocumentPosition
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{Code: lspErrorInvalidParams, Message: err.Error()}
+		}
+		delete(s.documents, params.TextDocument.URI)
+		s.notify("textDocument/publishDiagnostics", map[string]any{"uri": params.TextDocument.URI, "diagnostics": []lspDiagnostic{}})
+		return nil, nil
+	case "textDocument/hover", "textDocument/definition", "textDocument/completion":
+		var params lspTextDocumentPosition
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{Code: lspErrorInvalidParams, Message: err.Error()}
+		}
+		doc := newLSPDocument(params.TextDocument.URI, s.documents[params.TextDocument.URI])
+		switch msg.Method {
+		case "textDocument/hover":
+			return s.hover(doc, params.Position), nil
+		case "textDocument/definition":
+			return s.definition(doc, params.Position), nil
+		default:
+			return s.completion(doc, params.Position), nil
+		}
+	}
+
+	if msg.ID == nil {
+		// notifications the server doesn't handle, like initialized or $/cancelRequest, are ignored
+		return nil, nil
+	}
+	return nil, &lspError{Code: lspErrorMethodNotFound, Message: "unsupported method " + msg.Method}
+}
+
+// update stores the new text of a document and reports its syntax errors.
+func (s *lspServer) update(uri string, text string) {
+	s.documents[uri] = text
+	doc := newLSPDocument(uri, text)
+	_, errs := parser.New(lexer.New(strings.NewReader(text))).ParseAll()
+	diagnostics := make([]lspDiagnostic, len(errs))
+	for i, err := range errs {
+		diagnostics[i] = lspDiagnostic{
+			Range:    doc.tokenRange(err.Token),
+			Severity: lspSeverityError,
+			Source:   "soup",
+			Message:  err.Message,
+		}
+	}
+	s.notify("textDocument/publishDiagnostics", map[string]any{"uri": uri, "diagnostics": diagnostics})
+}
+
+func (s *lspServer) hover(doc *lspDocument, pos lspPosition) any {
+	tok, ok := doc.nameAt(pos)
+	if !ok {
+		return nil
+	}
+
+	var contents string
+	if defs := doc.definitions(tok.Content); len(defs) > 0 {
+		contents = defs[0].describe()
+	} else if val, ok := s.builtins.Get(tok.Content); ok {
+		switch val.Type {
+		case evaluator.ProcedureType:
+			proc := val.Procedure()
+			params := append([]string{tok.Content}, proc.Parameters...)
+			if proc.OptionalTailParameter != "" {
+				params = append(params, ".", proc.OptionalTailParameter)
+			}
+			contents = "```scheme\n(" + strings.Join(params, " ") + ")\n```\n\nprelude procedure"
+		case evaluator.BuiltinFunctionType:
+			contents = "```scheme\n" + tok.Content + "\n```\n\nbuiltin procedure"
+		default:
+			contents = "```scheme\n" + tok.Content + "\n```\n\nbuiltin " + val.Write()
+		}
+	} else {
+		return nil
+	}
+	tokRange := doc.tokenRange(tok)
+	return map[string]any{
+		"contents": map[string]any{"kind": "markdown", "value": contents},
+		"range":    tokRange,
+	}
+}
+
+func (s *lspServer) definition(doc *lspDocument, pos lspPosition) any {
+	tok, ok := doc.nameAt(pos)
+	if !ok {
+		return nil
+	}
+	defs := doc.definitions(tok.Content)
+	locations := make([]lspLocation, len(defs))
+	for i, def := range defs {
+		locations[i] = lspLocation{URI: doc.uri, Range: doc.tokenRange(def.name)}
+	}
+	return locations
+}
+
+func (s *lspServer) completion(doc *lspDocument, pos lspPosition) any {
+	prefix := doc.prefixAt(pos)
+	items := []lspCompletionItem{}
+	seen := make(map[string]bool)
+	add := func(item lspCompletionItem) {
+		if !seen[item.Label] && strings.HasPrefix(item.Label, prefix) && len(items) < lspMaxCompletions {
+			seen[item.Label] = true
+			items = append(items, item)
+		}
+	}
+
+	defs := doc.definitions("")
+	sort.Slice(defs, func(i, j int) bool { return defs[i].name.Content < defs[j].name.Content })
+	for _, def := range defs {
+		item := lspCompletionItem{Label: def.name.Content, Kind: lspCompletionVariable}
+		if def.procedure {
+			item.Kind = lspCompletionFunction
+			item.Detail = def.signature()
+		}
+		add(item)
+	}
+	for _, name := range s.builtins.Names() {
+		val, _ := s.builtins.Get(name)
+		kind := lspCompletionVariable
+		if val.Type == evaluator.ProcedureType || val.Type == evaluator.BuiltinFunctionType {
+			kind = lspCompletionFunction
+		}
+		add(lspCompletionItem{Label: name, Kind: kind, Detail: "builtin"})
+	}
+	return map[string]any{"isIncomplete": len(items) == lspMaxCompletions, "items": items}
+}
+
+// lspDocument is an open document with its tokens.
+type lspDocument struct {
+	uri    string
+	lines  []string
+	tokens []lexer.Token
+}
+
+func newLSPDocument(uri string, text string) *lspDocument {
+	doc := &lspDocument{uri: uri, lines: strings.Split(text, "\n")}
+	l := lexer.New(strings.NewReader(text))
+	for {
+		tok := l.NextToken()
+		if tok.TokenType == lexer.TokenTypeEOF || tok.TokenType == lexer.TokenTypeInvalid {
+			break
+		}
+		doc.tokens = append(doc.tokens, tok)
+	}
+	return doc
+}
+
+// character converts a 1-based line and byte column to the UTF-16 offset LSP positions use.
+func (doc *lspDocument) character(line int, column int) int {
+	if line < 1 || line > len(doc.lines) {
+		return 0
+	}
+	text := doc.lines[line-1]
+	column = min(max(column-1, 0), len(text))
+	n := 0
+	for _, r := range text[:column] {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+// column converts an LSP position to the 1-based byte column of its line.
+func (doc *lspDocument) column(pos lspPosition) int {
+	if pos.Line < 0 || pos.Line >= len(doc.lines) {
+		return 0
+	}
+	text := doc.lines[pos.Line]
+	n := 0
+	for i, r := range text {
+		if n >= pos.Character {
+			return i + 1
+		}
+		n += utf16.RuneLen(r)
+	}
+	return len(text) + 1
+}
+
+// tokenRange is the range tok spans, the rest of its line for a string spanning several.
+func (doc *lspDocument) tokenRange(tok lexer.Token) lspRange {
+	start := lspPosition{Line: max(tok.Line-1, 0), Character: doc.character(tok.Line, tok.Column)}
+	width := len(tok.Content)
+	if tok.TokenType == lexer.TokenTypeString {
+		// the content is unquoted, the source has the quotes and maybe escapes
+		width = tokenWidth(doc.lineText(tok.Line), tok.Column-1, tok)
+	}
+	end := lspPosition{Line: start.Line, Character: doc.character(tok.Line, tok.Column+max(width, 1))}
+	return lspRange{Start: start, End: end}
+}
+
+func (doc *lspDocument) lineText(line int) string {
+	if line < 1 || line > len(doc.lines) {
+		return ""
+	}
+	return doc.lines[line-1]
+}
+
+// isName reports whether tok can name a variable, as opposed to parens, literals and syntax.
+func isName(tok lexer.Token) bool {
+	switch tok.TokenType {
+	case lexer.TokenTypeLeftParen, lexer.TokenTypeRightParen, lexer.TokenTypeString, lexer.TokenTypeNumber,
+		lexer.TokenTypeQuote, lexer.TokenTypeDot, lexer.TokenTypeTrue, lexer.TokenTypeFalse, lexer.TokenTypeVectorPrefix, lexer.TokenTypeChar:
+		return false
+	}
+	return tok.Content != ""
+}
+
+// nameAt returns the name token under pos.
+func (doc *lspDocument) nameAt(pos lspPosition) (lexer.Token, bool) {
+	column := doc.column(pos)
+	for _, tok := range doc.tokens {
+		if tok.Line == pos.Line+1 && tok.Column <= column && column < tok.Column+len(tok.Content) && isName(tok) {
+			return tok, true
+		}
+	}
+	return lexer.Token{}, false
+}
+
+// prefixAt returns the part of the name being typed before pos.
+func (doc *lspDocument) prefixAt(pos lspPosition) string {
+	if pos.Line < 0 || pos.Line >= len(doc.lines) {
+		return ""
+	}
+	text := doc.lines[pos.Line][:doc.column(pos)-1]
+	start := len(text)
+	for start > 0 {
+		r, size := utf8.DecodeLastRuneInString(text[:start])
+		if strings.ContainsRune("()'\"; \t", r) {
+			break
+		}
+		start -= size
+	}
+	return text[start:]
+}
+
+// lspDefinition is a define found in a document.
+type lspDefinition struct {
+	name lexer.Token
+	// procedure is true for (define (name params...) ...), params are then the parameter list
+	procedure bool
+	params    []string
+	// doc is the docstring, a string that starts a procedure's body and isn't all of it
+	doc string
+}
+
+func (def lspDefinition) signature() string {
+	return "(" + strings.Join(append([]string{def.name.Content}, def.params...), " ") + ")"
+}
+
+func (def lspDefinition) describe() string {
+	code := def.name.Content
+	if def.procedure {
+		code = def.signature()
+	}
+	contents := "```scheme\n" + code + "\n```"
+	if def.doc != "" {
+		contents += "\n\n" + def.doc
+	}
+	return contents
+}
+
+// definitions returns the defines of name in the document, all of them when name is "".
+func (doc *lspDocument) definitions(name string) []lspDefinition {
+	var defs []lspDefinition
+	tokens := doc.tokens
+	for i := 0; i+1 < len(tokens); i++ {
+		if tokens[i].TokenType != lexer.TokenTypeDefine {
+			continue
+		}
+		j := i + 1
+		def := lspDefinition{name: tokens[j]}
+		if tokens[j].TokenType == lexer.TokenTypeLeftParen {
+			if j+1 >= len(tokens) {
+				break
+			}
+			j++
+			def.name = tokens[j]
+			def.procedure = true
+			for j++; j < len(tokens) && tokens[j].TokenType != lexer.TokenTypeRightParen; j++ {
+				def.params = append(def.params, tokens[j].Content)
+			}
+			if j+2 < len(tokens) && tokens[j+1].TokenType == lexer.TokenTypeString && tokens[j+2].TokenType != lexer.TokenTypeRightParen {
+				def.doc = tokens[j+1].Content
+			}
+		}
+		if isName(def.name) && (name == "" || def.name.Content == name) {
+			defs = append(defs, def)
+		}
+	}
+	return defs
+}
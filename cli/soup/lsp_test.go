@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ocowchun/soup/evaluator"
+)
+
+// lspSession sends messages to a language server and returns its exit status and the messages it
+// wrote back once it read them all.
+func lspSession(t *testing.T, messages ...map[string]any) (int, []lspMessage) {
+	var in bytes.Buffer
+	for _, msg := range messages {
+		msg["jsonrpc"] = "2.0"
+		body, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		writeFrame(&in, body)
+	}
+
+	var out bytes.Buffer
+	s := &lspServer{
+		in:        bufio.NewReader(&in),
+		out:       &out,
+		documents: make(map[string]string),
+		builtins:  evaluator.New(evaluator.WithSandbox(evaluator.NoCapabilities)).GlobalEnvironment(),
+	}
+	status := s.serve()
+
+	var replies []lspMessage
+	reader := bufio.NewReader(&out)
+	for {
+		body, err := readFrame(reader)
+		if err == io.EOF {
+			return status, replies
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var msg lspMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Fatalf("invalid message %s: %v", body, err)
+		}
+		replies = append(replies, msg)
+	}
+}
+
+func TestLSP_Framing(t *testing.T) {
+	var buf bytes.Buffer
+	writeFrame(&buf, []byte(`{"id":1}`))
+	if buf.String() != "Content-Length: 8\r\n\r\n{\"id\":1}" {
+		t.Fatalf("unexpected frame %q", buf.String())
+	}
+	// a second frame follows right after the body of the first
+	buf.WriteString("Content-Length: 2\r\nContent-Type: application/json\r\n\r\n{}")
+	reader := bufio.NewReader(&buf)
+	for _, expected := range []string{`{"id":1}`, `{}`} {
+		body, err := readFrame(reader)
+		if err != nil || string(body) != expected {
+			t.Fatalf("expected %s, got %s, %v", expected, body, err)
+		}
+	}
+	if _, err := readFrame(reader); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+
+	for _, frame := range []string{"Content-Type: text/plain\r\n\r\n{}", "Content-Length: two\r\n\r\n{}"} {
+		_, err := readFrame(bufio.NewReader(strings.NewReader(frame)))
+		if err == nil || !strings.HasPrefix(err.Error(), "invalid Content-Length header") {
+			t.Fatalf("%q, expected an invalid Content-Length header, got %v", frame, err)
+		}
+	}
+	if _, err := readFrame(bufio.NewReader(strings.NewReader("Content-Length: 10\r\n\r\n{}"))); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected a short body to be an error, got %v", err)
+	}
+}
+
+func TestLSP_Session(t *testing.T) {
+	uri := "file:///tmp/square.soup"
+	text := "(define (square x)\n  \"squares x\"\n  (* x x))\n(square 2)\n(sq"
+	position := func(id int, method string, line int, character int) map[string]any {
+		return map[string]any{"id": id, "method": method, "params": map[string]any{
+			"textDocument": map[string]any{"uri": uri},
+			"position":     map[string]any{"line": line, "character": character},
+		}}
+	}
+	status, replies := lspSession(t,
+		map[string]any{"id": 1, "method": "initialize", "params": map[string]any{}},
+		map[string]any{"method": "initialized", "params": map[string]any{}},
+		map[string]any{"method": "textDocument/didOpen", "params": map[string]any{
+			"textDocument": map[string]any{"uri": uri, "languageId": "scheme", "version": 1, "text": text},
+		}},
+		position(2, "textDocument/hover", 3, 2),
+		position(3, "textDocument/completion", 4, 3),
+		map[string]any{"id": 4, "method": "textDocument/formatting", "params": map[string]any{}},
+		map[string]any{"id": 5, "method": "shutdown"},
+		map[string]any{"method": "exit"},
+	)
+	if status != 0 {
+		t.Fatalf("expected exit status 0 after shutdown, got %d", status)
+	}
+	if len(replies) != 6 {
+		t.Fatalf("expected 6 messages, got %d: %+v", len(replies), replies)
+	}
+
+	var initialize struct {
+		Capabilities struct {
+			TextDocumentSync int  `json:"textDocumentSync"`
+			HoverProvider    bool `json:"hoverProvider"`
+		} `json:"capabilities"`
+	}
+	decodeResult(t, replies[0], 1, &initialize)
+	if initialize.Capabilities.TextDocumentSync != lspSyncFull || !initialize.Capabilities.HoverProvider {
+		t.Fatalf("unexpected capabilities %+v", initialize)
+	}
+
+	// opening the document publishes its syntax errors
+	if replies[1].Method != "textDocument/publishDiagnostics" {
+		t.Fatalf("expected diagnostics, got %+v", replies[1])
+	}
+	var diagnostics struct {
+		URI         string          `json:"uri"`
+		Diagnostics []lspDiagnostic `json:"diagnostics"`
+	}
+	if err := json.Unmarshal(replies[1].Params, &diagnostics); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diagnostics.URI != uri || len(diagnostics.Diagnostics) != 1 {
+		t.Fatalf("expected one diagnostic for %s, got %+v", uri, diagnostics)
+	}
+	if d := diagnostics.Diagnostics[0]; d.Message != "unclosed '(' opened at 5:1" || d.Severity != lspSeverityError || d.Range.Start.Line != 4 {
+		t.Fatalf("unexpected diagnostic %+v", d)
+	}
+
+	var hover struct {
+		Contents struct {
+			Kind  string `json:"kind"`
+			Value string `json:"value"`
+		} `json:"contents"`
+		Range lspRange `json:"range"`
+	}
+	decodeResult(t, replies[2], 2, &hover)
+	if hover.Contents.Value != "```scheme\n(square x)\n```\n\nsquares x" {
+		t.Fatalf("unexpected hover %q", hover.Contents.Value)
+	}
+	if hover.Range != (lspRange{Start: lspPosition{Line: 3, Character: 1}, End: lspPosition{Line: 3, Character: 7}}) {
+		t.Fatalf("unexpected hover range %+v", hover.Range)
+	}
+
+	var completion struct {
+		Items []lspCompletionItem `json:"items"`
+	}
+	decodeResult(t, replies[3], 3, &completion)
+	labels := make([]string, len(completion.Items))
+	for i, item := range completion.Items {
+		labels[i] = item.Label
+	}
+	if len(labels) == 0 || labels[0] != "square" || completion.Items[0].Detail != "(square x)" || completion.Items[0].Kind != lspCompletionFunction {
+		t.Fatalf("expected the document's square first, got %+v", completion.Items)
+	}
+	if !strings.Contains(strings.Join(labels, " "), "sqrt") {
+		t.Fatalf("expected the builtin sqrt, got %v", labels)
+	}
+
+	if replies[4].Error == nil || replies[4].Error.Code != lspErrorMethodNotFound {
+		t.Fatalf("expected an unsupported method error, got %+v", replies[4])
+	}
+	if string(*replies[5].ID) != "5" || replies[5].Error != nil {
+		t.Fatalf("unexpected shutdown response %+v", replies[5])
+	}
+}
+
+// decodeResult decodes the result of the response msg, which must answer the request id.
+func decodeResult(t *testing.T, msg lspMessage, id int, result any) {
+	t.Helper()
+	if msg.ID == nil || string(*msg.ID) != strings.TrimSpace(string(mustMarshal(t, id))) || msg.Error != nil {
+		t.Fatalf("expected a result for request %d, got %+v", id, msg)
+	}
+	if err := json.Unmarshal(mustMarshal(t, msg.Result), result); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func mustMarshal(t *testing.T, v any) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return data
+}
@@ -3,160 +3,371 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/ocowchun/soup/evaluator"
 	"github.com/ocowchun/soup/lexer"
 	"github.com/ocowchun/soup/parser"
-	"golang.org/x/term"
 )
 
+var searchPath []string
+
+// commandLine is what the command-line builtin returns
+var commandLine []string
+
+// evalSources are the -e expressions, evaluated in order instead of running a file or the REPL
+var evalSources []string
+
 func main() {
-	fmt.Println("welcome to soup")
+	if len(os.Args) > 1 && os.Args[1] == "lsp" {
+		os.Exit(runLSP(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "dap" {
+		os.Exit(runDAP(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && debugCommands[os.Args[1]] != nil {
+		os.Exit(runDebugCommand(os.Args[1], os.Args[2:]))
+	}
+
+	args := parseFlags(os.Args[1:])
+	searchPath = append(searchPath, evaluator.SearchPathFromEnv()...)
 
-	if len(os.Args) == 1 {
-		fmt.Println("repl")
-		err := repl()
+	files, scriptArgs := splitArgs(args)
+	if len(files) > 0 {
+		// the last file is the script being run, the ones before it are loaded for it
+		commandLine = append([]string{files[len(files)-1]}, scriptArgs...)
+	} else {
+		commandLine = append([]string{"soup"}, scriptArgs...)
+	}
+
+	if len(evalSources) > 0 {
+		// no banner, so the output can be piped
+		ev := newEvaluator()
+		src, err := runEval(ev, files, evalSources)
 		if err != nil {
-			printError(err)
+			printError(err, src)
+		}
+		runExitHooks(ev)
+		if err != nil {
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	if !quiet {
+		fmt.Println("welcome to soup")
+	}
+
+	if len(files) == 0 {
+		if !quiet {
+			fmt.Println("repl")
+		}
+		ev := newEvaluator()
+		for _, fileName := range preloadFiles {
+			// a broken file is reported like an entry would be, the session starts with the ones that loaded
+			if err := loadInterruptible(ev, fileName); err != nil {
+				printError(err, "")
+			}
+		}
+		err := repl(ev, ev.GlobalEnvironment(), prompt)
+		runExitHooks(ev)
+		if err != nil {
+			printError(err, "")
 			//fmt.Println("error:", err)
-			os.Exit(65)
+			os.Exit(exitCode(err))
 		}
 
-	} else if len(os.Args) == 2 {
-		f := os.Args[1]
-		fmt.Println("file", f)
-		err := runFile(f)
+	} else {
+		ev := newEvaluator()
+		err := runFiles(ev, files)
 		if err != nil {
-			printError(err)
+			printError(err, "")
+			if postMortem {
+				startPostMortem(ev, err)
+			}
 			//}
-			os.Exit(65)
+			runExitHooks(ev)
+			os.Exit(exitCode(err))
 		}
+		runExitHooks(ev)
+	}
 
-	} else {
-		fmt.Println("unsupport args")
+}
+
+// exit statuses follow sysexits(3)
+const (
+	exitParseError   = 65 // EX_DATAERR
+	exitRuntimeError = 70 // EX_SOFTWARE
+	exitIOError      = 74 // EX_IOERR
+	// exitResourceLimit is what timeout(1) exits with
+	exitResourceLimit = 124
+	// exitInterrupted is what a shell reports for a process killed by SIGINT
+	exitInterrupted = 130
+)
+
+// exitCode tells lexing and parsing errors apart from failing file operations, exceeded resource
+// limits, interruptions and other runtime errors.
+func exitCode(err error) int {
+	var parsingError *parser.ParsingError
+	if errors.As(err, &parsingError) {
+		return exitParseError
+	}
+	if isInterrupted(err) {
+		return exitInterrupted
+	}
+	var condition *evaluator.Condition
+	if errors.As(err, &condition) && condition.Kind == evaluator.FileErrorCondition {
+		return exitIOError
 	}
+	if errors.As(err, &condition) && condition.Kind == evaluator.ResourceLimitCondition {
+		return exitResourceLimit
+	}
+	return exitRuntimeError
+}
+
+// maxTraceFrames is how many frames of a stack trace printError shows
+const maxTraceFrames = 20
 
+// printError reports err on stderr with the source line it was raised at. src is the entry or -e
+// expression being evaluated, errors raised while loading a file are shown with that file's line.
+func printError(err error, src string) {
+	writeError(os.Stderr, err, src)
 }
 
-func printError(err error) {
+// writeError writes the report printError prints to w.
+func writeError(w io.Writer, err error, src string) {
 	var parsingError *parser.ParsingError
 	if errors.As(err, &parsingError) {
-		fmt.Printf("Parsing error at line %d, got token: `%s` type: %s, error: %s\n",
+		fmt.Fprintf(w, "Parsing error at line %d, got token: `%s` type: %s, error: %s\n",
 			parsingError.Token.Line, parsingError.Token.Content, parsingError.Token.TokenType,
 			parsingError.Message)
+		printExcerpt(w, parsingError.File, errorSource(parsingError.File, src), parsingError.Token)
 		return
 	}
 
 	var runtimeError *evaluator.RuntimeError
+	if errors.As(err, &runtimeError) && lang == "mit" {
+		// the way the MIT REPL reports an error, without the excerpt and the stack trace
+		fmt.Fprintf(w, ";%s\n;To continue, call RESTART with an option number:\n; (RESTART 1) => Return to read-eval-print level 1.\n", err.Error())
+		return
+	}
 	if errors.As(err, &runtimeError) {
-
-		fmt.Println(err.Error())
-		for _, e := range runtimeError.StackTrace() {
-			fmt.Printf("\t at %s (line %d)\n", e.IdentifierName(), e.LineNumber())
+		if isInterrupted(err) {
+			// the trace shows what was running when Ctrl+C was pressed
+			fmt.Fprintln(w, "interrupted")
+		} else {
+			fmt.Fprintln(w, err.Error())
+		}
+		// the error can be in a procedure written in another file or an earlier entry than src
+		if text := runtimeError.Source(); text != "" {
+			src = text
+		}
+		printExcerpt(w, runtimeError.File(), errorSource(runtimeError.File(), src), runtimeError.Token())
+		stackTrace := runtimeError.StackTrace()
+		for i, e := range stackTrace {
+			// deep recursion leaves thousands of frames, keep the innermost and outermost ones
+			if skipped := len(stackTrace) - maxTraceFrames; skipped > 0 && i == maxTraceFrames/2 {
+				fmt.Fprintf(w, "\t ... %d more frames\n", skipped)
+			}
+			if skipped := len(stackTrace) - maxTraceFrames; skipped > 0 && i >= maxTraceFrames/2 && i < maxTraceFrames/2+skipped {
+				continue
+			}
+			fmt.Fprintf(w, "\t at %s (line %d)\n", e.IdentifierName(), e.LineNumber())
 		}
 
-		fmt.Printf("\t at main (line %d)\n", runtimeError.LineNumber())
+		fmt.Fprintf(w, "\t at main (line %d)\n", runtimeError.LineNumber())
+		return
+	}
+
+	fmt.Fprintln(w, "error:", err)
+}
+
+// printExcerpt writes where tok is in file, or the entry when file is "", and the source line under it.
+func printExcerpt(w io.Writer, file string, src string, tok lexer.Token) {
+	excerpt := sourceExcerpt(src, tok)
+	if excerpt == "" {
 		return
 	}
+	if file != "" {
+		fmt.Fprintf(w, " --> %s:%d:%d\n", file, tok.Line, tok.Column)
+	}
+	fmt.Fprint(w, excerpt)
+}
 
-	fmt.Println("panic:", err)
+// runExitHooks calls the thunks the session registered with on-exit, reporting any that fail.
+func runExitHooks(ev *evaluator.Evaluator) {
+	if err := ev.RunExitHooks(); err != nil {
+		printError(err, "")
+	}
 }
 
-func repl() error {
+func newEvaluator() *evaluator.Evaluator {
+	opts := []evaluator.Option{
+		evaluator.WithSearchPath(searchPath),
+		evaluator.WithCommandLine(commandLine),
+		evaluator.WithLimits(limits),
+		evaluator.WithTracer(tracer),
+		evaluator.WithSandbox(capabilities),
+	}
+	if unbuffered {
+		opts = append(opts, evaluator.WithUnbufferedOutput())
+	}
+	if optimize {
+		opts = append(opts, evaluator.WithOptimizer())
+	}
+	if shadowing {
+		opts = append(opts, evaluator.WithShadowing())
+	}
+	switch lang {
+	case "racket":
+		opts = append(opts, evaluator.WithRacket())
+	case "mit":
+		opts = append(opts, evaluator.WithMITCompat())
+	}
+	return evaluator.New(opts...)
+}
+
+var sourceExtensions = []string{".soup", ".scm", ".ss", ".sld", ".rkt"}
+
+// splitArgs separates the files to run from the arguments passed to the script. Everything after
+// "--" is an argument, without one the files are the first argument and those following it that look
+// like source files, e.g. `soup lib.scm main.scm a b` runs two files with the arguments a and b.
+func splitArgs(args []string) ([]string, []string) {
+	if i := slices.Index(args, "--"); i >= 0 {
+		return args[:i], args[i+1:]
+	}
+	if len(args) == 0 {
+		return args, nil
+	}
 
-	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+	n := 1
+	for n < len(args) && slices.Contains(sourceExtensions, strings.ToLower(filepath.Ext(args[n]))) {
+		n++
+	}
+	return args[:n], args[n:]
+}
+
+// parseSource parses src with the syntax the flags select, expanding the macros ev's earlier programs
+// defined.
+func parseSource(ev *evaluator.Evaluator, src string) (*parser.Program, error) {
+	p := parser.New(lexer.New(strings.NewReader(src)))
+	p.UseMacros(ev.Macros())
+	if shadowing {
+		p.AllowShadowing()
+	}
+	if lang == "racket" {
+		p.Racket()
+	}
+	program, err := p.Parse()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	defer term.Restore(int(os.Stdin.Fd()), oldState)
+	program.Source = src
+	return program, nil
+}
 
-	buf := make([]byte, 3)
-	fmt.Print("soup> ")
-	lines := make([]string, 0)
-	currentLine := ""
-	lineIndex := 0
-	for {
-		n, err := os.Stdin.Read(buf)
+// runEval loads files into ev, then evaluates each source in the same environment and prints its result as
+// data, e.g. (1 2) or "str". It returns the source that failed along with the error.
+func runEval(ev *evaluator.Evaluator, files []string, sources []string) (string, error) {
+	timer := startTimer(ev)
+	defer timer.report()
+	ctx, stop := interruptible()
+	defer stop()
+	for _, fileName := range files {
+		if _, err := ev.LoadFileContext(ctx, fileName); err != nil {
+			return "", err
+		}
+	}
+	for _, src := range sources {
+		program, err := parseSource(ev, src)
 		if err != nil {
-			break
+			return src, err
 		}
-
-		switch {
-		case n == 3 && buf[0] == 27 && buf[1] == 91 && buf[2] == 68:
-			// Left arrow
-			fmt.Print("\033[1D")
-		case n == 3 && buf[0] == 27 && buf[1] == 91 && buf[2] == 67:
-			// Right arrow
-			fmt.Print("\033[1C")
-		case n == 3 && buf[0] == 27 && buf[1] == 91 && buf[2] == 65:
-			// Up arrow
-			//fmt.Print("\033[A")
-
-			fmt.Print("\033[2K\r")
-			fmt.Print("soup> ")
-			if lineIndex >= 0 {
-				currentLine = lines[lineIndex]
-				fmt.Print(currentLine)
-				lineIndex--
+		result, err := ev.EvalContext(ctx, program)
+		if err != nil {
+			return src, err
+		}
+		if result != nil && !(result.Type == evaluator.ConstantType && result.Data == evaluator.VoidConst) {
+			if err := printOutput(result); err != nil {
+				return src, err
 			}
-		case n == 3 && buf[0] == 27 && buf[1] == 91 && buf[2] == 66:
-			// Down arrow
-			//fmt.Print("\033[B")
-			// clean the line
-			fmt.Print("\033[2K\r")
-			fmt.Print("soup> ")
-
-		case n == 1 && buf[0] == 127:
-			// Backspace
-			fmt.Print("\033[1D \033[1D")
-		case buf[0] == 3: // Ctrl+C
-			return nil
-		case n == 1 && (buf[0] == '\r' || buf[0] == '\n'):
-			lines = append(lines, currentLine)
-			fmt.Print("\033[2K\r")
-			fmt.Print(currentLine)
-			fmt.Print("\n\r") // Move to next line or handle input
-
-			currentLine = ""
-			lineIndex = len(lines) - 1
-			fmt.Print("soup> ")
-			//continue
-			// Enter/Return pressed
-		default:
-			currentLine += string(buf[:n])
-			fmt.Print(string(buf[:n]))
 		}
 	}
-	return nil
+	return "", nil
 }
 
-func runFile(fileName string) error {
-	file, err := os.Open(fileName)
-	if err != nil {
-		return err
+// loadInterruptible loads the file at path into ev, Ctrl+C interrupts it.
+func loadInterruptible(ev *evaluator.Evaluator, path string) error {
+	ctx, stop := interruptible()
+	defer stop()
+	_, err := ev.LoadFileContext(ctx, path)
+	return err
+}
+
+// runFiles evaluates the files in order in one environment, so later files see the definitions of
+// earlier ones, and prints the result of the last.
+func runFiles(ev *evaluator.Evaluator, fileNames []string) error {
+	timer := startTimer(ev)
+	defer timer.report()
+	ctx, stop := interruptible()
+	defer stop()
+	var result *evaluator.ReturnValue
+	for _, fileName := range fileNames {
+		if !quiet {
+			fmt.Println("file", fileName)
+		}
+		var err error
+		result, err = ev.LoadFileContext(ctx, fileName)
+		if err != nil {
+			return err
+		}
 	}
-	defer file.Close()
+	return printReturnValue(result)
+}
 
-	l := lexer.New(file)
+// startPostMortem runs a REPL in the environment of the frame err was raised in, so its variables can
+// be inspected. When stdin isn't a terminal the entries are read from it like repl does, so a script
+// can inspect the frame too.
+func startPostMortem(ev *evaluator.Evaluator, err error) {
+	var runtimeError *evaluator.RuntimeError
+	if !errors.As(err, &runtimeError) || runtimeError.Environment() == nil {
+		fmt.Fprintln(os.Stderr, "post-mortem: the error wasn't raised while evaluating, there is no frame to inspect")
+		return
+	}
 
-	p := parser.New(l)
+	fmt.Fprintln(os.Stderr, "post-mortem: evaluating in the frame that failed, Ctrl+C to exit")
+	if err := repl(ev, runtimeError.Environment(), postMortemPrompt); err != nil {
+		printError(err, "")
+	}
+}
 
-	program, err := p.Parse()
-	if err != nil {
-		return err
+func printReturnValue(ret *evaluator.ReturnValue) error {
+	if !quiet {
+		fmt.Printf("Result: %s\n", ret.String())
+		return nil
+	}
+	// only the value, written as data like -e does
+	if !(ret.Type == evaluator.ConstantType && ret.Data == evaluator.VoidConst) {
+		return printOutput(ret)
 	}
+	return nil
+}
 
-	ev := evaluator.New(os.Stdin)
-	result, err := ev.Eval(program)
+// printOutput prints a result on a line in the -output format.
+func printOutput(ret *evaluator.ReturnValue) error {
+	if outputFormat != "json" {
+		fmt.Println(ret.Write())
+		return nil
+	}
+	// called directly, json.Marshal would wrap the error in one naming the Go type
+	data, err := ret.MarshalJSON()
 	if err != nil {
 		return err
 	}
-	printReturnValue(result)
-
+	fmt.Println(string(data))
 	return nil
 }
-
-func printReturnValue(ret *evaluator.ReturnValue) {
-	fmt.Printf("Result: %s\n", ret.String())
-}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ocowchun/soup/evaluator"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		args          []string
+		expectedFiles []string
+		expectedArgs  []string
+	}{
+		{nil, nil, nil},
+		{[]string{"main.scm"}, []string{"main.scm"}, nil},
+		{[]string{"lib.scm", "main.scm", "a", "b"}, []string{"lib.scm", "main.scm"}, []string{"a", "b"}},
+		{[]string{"lib.SCM", "Main.Rkt", "a"}, []string{"lib.SCM", "Main.Rkt"}, []string{"a"}},
+		// the first argument is the script whatever its name, the next ones only if they look like source
+		{[]string{"script", "a"}, []string{"script"}, []string{"a"}},
+		{[]string{"main.scm", "a", "b.scm"}, []string{"main.scm"}, []string{"a", "b.scm"}},
+		{[]string{"main.scm", "--", "b.scm"}, []string{"main.scm"}, []string{"b.scm"}},
+		{[]string{"lib.scm", "main.scm", "--"}, []string{"lib.scm", "main.scm"}, nil},
+		{[]string{"--", "a"}, nil, []string{"a"}},
+	}
+
+	for _, tt := range tests {
+		files, args := splitArgs(tt.args)
+		if !slices.Equal(files, tt.expectedFiles) || !slices.Equal(args, tt.expectedArgs) {
+			t.Fatalf("%q, expected files %q and arguments %q, got %q and %q", tt.args, tt.expectedFiles, tt.expectedArgs, files, args)
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	dir := t.TempDir()
+	broken := filepath.Join(dir, "broken.scm")
+	if err := os.WriteFile(broken, []byte("(define x\n  (+ 1"), 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	eval := func(src string, limits evaluator.Limits, ctx context.Context) error {
+		ev := evaluator.New(evaluator.WithLimits(limits))
+		program, err := parseSource(ev, src)
+		if err != nil {
+			return err
+		}
+		_, err = ev.EvalContext(ctx, program)
+		return err
+	}
+	load := func(path string) error {
+		_, err := evaluator.New().LoadFile(path)
+		return err
+	}
+	interrupted, cancel := context.WithCancelCause(context.Background())
+	cancel(errInterrupted)
+
+	tests := []struct {
+		name         string
+		err          error
+		expectedCode int
+	}{
+		{"parse error", eval("(+ 1 2", evaluator.Limits{}, context.Background()), exitParseError},
+		{"parse error in a file", load(broken), exitParseError},
+		{"missing file", load(filepath.Join(dir, "missing.scm")), exitIOError},
+		{"file error", eval(`(load "`+filepath.Join(dir, "missing.scm")+`")`, evaluator.Limits{}, context.Background()), exitIOError},
+		{"resource limit", eval("(define (loop) (loop)) (loop)", evaluator.Limits{MaxSteps: 1000}, context.Background()), exitResourceLimit},
+		{"interrupt", eval("(define (loop) (loop)) (loop)", evaluator.Limits{}, interrupted), exitInterrupted},
+		{"runtime error", eval("(car '())", evaluator.Limits{}, context.Background()), exitRuntimeError},
+		{"raise", eval("(raise 'oops)", evaluator.Limits{}, context.Background()), exitRuntimeError},
+	}
+
+	for _, tt := range tests {
+		if tt.err == nil {
+			t.Fatalf("%s, expected an error", tt.name)
+		}
+		if code := exitCode(tt.err); code != tt.expectedCode {
+			t.Fatalf("%s, expected exit status %d for %v, got %d", tt.name, tt.expectedCode, tt.err, code)
+		}
+	}
+
+	// the script run from the command line isn't loaded by a load form
+	err := load(filepath.Join(dir, "missing.scm"))
+	if !strings.HasPrefix(err.Error(), "could not find "+filepath.Join(dir, "missing.scm")) {
+		t.Fatalf("expected a missing script to be reported without naming load, got %v", err)
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	defer func(color bool) { stderrColor = color }(stderrColor)
+	stderrColor = false
+
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "lib.scm")
+	if err := os.WriteFile(lib, []byte(";; lists\n(define (second items)\n  (car (cdr items)))\n"), 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ev := evaluator.New(evaluator.WithSandbox(evaluator.NoCapabilities))
+	if _, err := ev.LoadFile(lib); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the entries are evaluated in order in one session, like in the REPL
+	tests := []struct {
+		src            string
+		expectedOutput string
+	}{
+		{"(+ 1\n  (* 2", "Parsing error at line 2, got token: `(` type: LeftParen, error: unclosed '(' opened at 2:3\n" +
+			" 2 |   (* 2\n" +
+			"   |   ^\n"},
+		{"(car (list))\n)", "Parsing error at line 2, got token: `)` type: RightParen, error: unexpected token: RightParen\n" +
+			" 2 | )\n" +
+			"   | ^\n"},
+		{"(define x 1)\n(car x)", "'car' expected cons or list value, got Number\n" +
+			" 2 | (car x)\n" +
+			"   |  ^^^\n" +
+			"\t at main (line 2)\n"},
+		{"\t(vector-ref (vector) x)", "'vector-ref' index 1 is out of range for a vector of length 0\n" +
+			" 1 | \t(vector-ref (vector) x)\n" +
+			"   | \t ^^^^^^^^^^\n" +
+			"\t at main (line 1)\n"},
+		{"(define (f v)\n  (vector-ref v 3))", ""},
+		// the excerpt is from the entry that defined f, not the one calling it
+		{"(f (vector 1))", "'vector-ref' index 3 is out of range for a vector of length 1\n" +
+			" 2 |   (vector-ref v 3))\n" +
+			"   |    ^^^^^^^^^^\n" +
+			"\t at f (line 2)\n" +
+			"\t at main (line 1)\n"},
+		// and from the file that defined second
+		{"(second '(1))", "cannot call 'car' on an empty list\n" +
+			" --> " + lib + ":3:4\n" +
+			" 3 |   (car (cdr items)))\n" +
+			"   |    ^^^\n" +
+			"\t at second (line 3)\n" +
+			"\t at main (line 1)\n"},
+	}
+
+	for _, tt := range tests {
+		program, err := parseSource(ev, tt.src)
+		if err == nil {
+			_, err = ev.Eval(program)
+		}
+		var out bytes.Buffer
+		if err != nil {
+			writeError(&out, err, tt.src)
+		}
+		if out.String() != tt.expectedOutput {
+			t.Fatalf("%q, expected\n%s\ngot\n%s", tt.src, tt.expectedOutput, out.String())
+		}
+	}
+
+	var out bytes.Buffer
+	writeError(&out, errors.New("can't read from the terminal"), "")
+	if out.String() != "error: can't read from the terminal\n" {
+		t.Fatalf("unexpected report %q", out.String())
+	}
+}
+
+func TestStartPostMortem_NotATerminal(t *testing.T) {
+	dir := t.TempDir()
+	file := func(name string, content string) *os.File {
+		f, err := os.OpenFile(filepath.Join(dir, name), os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := f.WriteString(content); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return f
+	}
+	read := func(f *os.File) string {
+		content, err := os.ReadFile(f.Name())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return string(content)
+	}
+	defer func(stdin, stdout, stderr *os.File) { os.Stdin, os.Stdout, os.Stderr = stdin, stdout, stderr }(os.Stdin, os.Stdout, os.Stderr)
+
+	ev := evaluator.New(evaluator.WithSandbox(evaluator.NoCapabilities))
+	program, err := parseSource(ev, "(define (f x)\n  (car x))\n(f 5)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = ev.Eval(program)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	// the entries piped in are evaluated in the frame of f
+	os.Stdin = file("stdin", "x\n(+ x 1)\n")
+	os.Stdout = file("stdout", "")
+	os.Stderr = file("stderr", "")
+	startPostMortem(ev, err)
+	if output := read(os.Stdout); output != "5\n6\n" {
+		t.Fatalf("expected the results of the entries, got %q", output)
+	}
+	if output := read(os.Stderr); output != "post-mortem: evaluating in the frame that failed, Ctrl+C to exit\n" {
+		t.Fatalf("expected the post-mortem notice on stderr, got %q", output)
+	}
+
+	os.Stdout = file("stdout-no-frame", "")
+	os.Stderr = file("stderr-no-frame", "")
+	startPostMortem(ev, errors.New("can't read the file"))
+	if output := read(os.Stdout); output != "" {
+		t.Fatalf("expected nothing on stdout, got %q", output)
+	}
+	if output := read(os.Stderr); !strings.Contains(output, "there is no frame to inspect") {
+		t.Fatalf("expected the missing frame reported on stderr, got %q", output)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ocowchun/soup/evaluator"
+	"golang.org/x/term"
+)
+
+// printLimits bounds the results printed by the REPL, :print-full prints the last one in full.
+var printLimits = evaluator.PrintLimits{MaxDepth: 10, MaxLength: 100}
+
+const printWidth = 80
+
+func printResult(result *evaluator.ReturnValue, limits evaluator.PrintLimits) {
+	if result == nil || (result.Type == evaluator.ConstantType && result.Data == evaluator.VoidConst) {
+		return
+	}
+	fmt.Println(result.LimitedPrettyString(printWidth, limits))
+}
+
+// evalEntry evaluates an entry in env and returns its result, nil if it failed or Ctrl+C interrupted it.
+// Errors are printed, ev keeps the state built by earlier entries either way.
+func evalEntry(ev *evaluator.Evaluator, env *evaluator.Environment, entry string) *evaluator.ReturnValue {
+	program, err := parseSource(ev, entry)
+	if err != nil {
+		printError(err, entry)
+		return nil
+	}
+	ctx, stop := interruptible()
+	defer stop()
+	result, err := ev.EvalInContext(ctx, program, env)
+	if err != nil {
+		printError(err, entry)
+		return nil
+	}
+	return result
+}
+
+// runCommand runs a REPL command such as :print-full, entries starting with a colon aren't evaluated.
+func runCommand(command string, last *evaluator.ReturnValue) {
+	switch command {
+	case ":print-full":
+		if last == nil {
+			fmt.Println("no result to print")
+			return
+		}
+		printResult(last, evaluator.PrintLimits{})
+	default:
+		fmt.Printf("unknown command %s, commands are: :print-full\n", command)
+	}
+}
+
+// repl reads entries from the terminal and evaluates them in env, prompting with promptText. When
+// stdin isn't a terminal, like when it's piped, the entries are read without prompt or line editing.
+func repl(ev *evaluator.Evaluator, env *evaluator.Environment, promptText string) error {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return plainREPL(ev, env, os.Stdin)
+	}
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("can't read from the terminal: %w", err)
+	}
+	defer term.Restore(fd, oldState)
+	fmt.Print(bracketedPasteOn)
+	defer fmt.Print(bracketedPasteOff)
+
+	history := make([]string, 0)
+	// historyIndex is the entry shown by Up/Down, len(history) is the entry being typed, kept in draft
+	historyIndex := 0
+	draft := ""
+	editor := &lineEditor{prompt: promptText}
+	// search is set while a Ctrl+R history search is in progress
+	var search *historySearch
+	// last is the result of the last entry that had one
+	var last *evaluator.ReturnValue
+
+	editor.render()
+	input := make([]byte, 256)
+	// partial is the start of a key split across reads
+	partial := make([]byte, 0)
+	for {
+		n, err := os.Stdin.Read(input)
+		if err != nil {
+			break
+		}
+
+		for chunk := append(partial, input[:n]...); len(chunk) > 0; {
+			k, text, size := readKey(chunk)
+			if size == 0 {
+				partial = append(partial[:0:0], chunk...)
+				break
+			}
+			partial = partial[:0]
+			chunk = chunk[size:]
+
+			if search != nil {
+				switch k {
+				case keySearch:
+					search.older(history)
+					search.render(history)
+					continue
+				case keyBackspace:
+					if query := []rune(search.query); len(query) > 0 {
+						search.setQuery(history, string(query[:len(query)-1]))
+					}
+					search.render(history)
+					continue
+				case keyCancel, keyInterrupt:
+					editor.set(search.original)
+					search = nil
+					editor.render()
+					continue
+				case keyText:
+					search.setQuery(history, search.query+string(text))
+					search.render(history)
+					continue
+				}
+
+				// any other key accepts the match and is then handled as usual, so Enter submits it
+				editor.set(search.current(history))
+				search = nil
+				editor.render()
+				if k == keyEscape {
+					// Esc only leaves the search
+					continue
+				}
+			}
+
+			if k == keyPaste {
+				if !editor.paste(text) {
+					editor.render()
+					continue
+				}
+				k = keyEnter
+			}
+
+			if k == keyEOF && len(editor.buf) > 0 {
+				// Ctrl+D only ends the session on an empty entry, otherwise it deletes like Delete
+				k = keyDelete
+			}
+
+			switch k {
+			case keyInterrupt, keyEOF:
+				editor.finish()
+				return nil
+			case keySearch:
+				editor.clear()
+				search = &historySearch{match: -1, original: editor.String()}
+				search.render(history)
+			case keyUp:
+				if historyIndex > 0 {
+					if historyIndex == len(history) {
+						draft = editor.String()
+					}
+					historyIndex--
+					editor.set(history[historyIndex])
+					editor.render()
+				}
+			case keyDown:
+				if historyIndex < len(history) {
+					historyIndex++
+					if historyIndex == len(history) {
+						editor.set(draft)
+					} else {
+						editor.set(history[historyIndex])
+					}
+					editor.render()
+				}
+			case keyEnter:
+				entry := editor.String()
+				if !inputComplete(entry) {
+					// keep typing the entry on a continuation row
+					editor.insert([]rune{'\n'})
+					editor.render()
+					continue
+				}
+
+				editor.finish()
+				if strings.TrimSpace(entry) != "" {
+					// a multi-line entry is recalled as a whole
+					history = append(history, entry)
+				}
+				historyIndex = len(history)
+				draft = ""
+
+				// evaluate in cooked mode, so output gets its usual line endings and read can use stdin
+				fmt.Print(bracketedPasteOff)
+				term.Restore(fd, oldState)
+				if command := strings.TrimSpace(entry); strings.HasPrefix(command, ":") {
+					runCommand(command, last)
+				} else if result := evalEntry(ev, env, entry); result != nil {
+					last = result
+					printResult(result, printLimits)
+				}
+				if _, err := term.MakeRaw(fd); err != nil {
+					return err
+				}
+				fmt.Print(bracketedPasteOn)
+				editor.reset()
+				editor.render()
+			default:
+				if editor.edit(k, text) {
+					editor.render()
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// plainREPL evaluates the entries read from in and prints their results, an entry goes on over the
+// lines that follow until its parentheses are balanced. It reads a byte at a time, so what comes
+// after an entry is left on in for the entry to read.
+func plainREPL(ev *evaluator.Evaluator, env *evaluator.Environment, in io.Reader) error {
+	var entry strings.Builder
+	// last is the result of the last entry that had one
+	var last *evaluator.ReturnValue
+	b := make([]byte, 1)
+	for {
+		n, err := in.Read(b)
+		if n == 1 {
+			entry.WriteByte(b[0])
+		}
+		// an entry ends at a newline once it's complete, or at the end of the input
+		if err == nil && (n == 0 || b[0] != '\n' || !inputComplete(entry.String())) {
+			continue
+		}
+
+		text := entry.String()
+		entry.Reset()
+		if command := strings.TrimSpace(text); strings.HasPrefix(command, ":") {
+			runCommand(command, last)
+		} else if command != "" {
+			if result := evalEntry(ev, env, text); result != nil {
+				last = result
+				printResult(result, printLimits)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime/metrics"
+	"time"
+
+	"github.com/ocowchun/soup/evaluator"
+)
+
+// timing reports how long running the files took, set by --time
+var timing bool
+
+const heapMetric = "/memory/classes/heap/objects:bytes"
+
+// runTimer measures a run for --time. The heap is sampled while the program runs, the peak is the
+// largest sample so short-lived spikes between samples can be missed.
+type runTimer struct {
+	ev    *evaluator.Evaluator
+	start time.Time
+	// startSteps leaves the prelude out of the steps reported
+	startSteps uint64
+	peak       uint64
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// startTimer returns nil when --time isn't set, a nil timer reports nothing.
+func startTimer(ev *evaluator.Evaluator) *runTimer {
+	if !timing {
+		return nil
+	}
+
+	t := &runTimer{ev: ev, start: time.Now(), startSteps: ev.Steps(), stop: make(chan struct{}), done: make(chan struct{})}
+	t.sample()
+	go func() {
+		defer close(t.done)
+		ticker := time.NewTicker(5 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.sample()
+			case <-t.stop:
+				t.sample()
+				return
+			}
+		}
+	}()
+	return t
+}
+
+func (t *runTimer) sample() {
+	sample := []metrics.Sample{{Name: heapMetric}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() == metrics.KindUint64 {
+		t.peak = max(t.peak, sample[0].Value.Uint64())
+	}
+}
+
+// report prints the statistics to stderr, so they don't mix with the program's output.
+func (t *runTimer) report() {
+	if t == nil {
+		return
+	}
+	elapsed := time.Since(t.start)
+	close(t.stop)
+	<-t.done
+	fmt.Fprintf(os.Stderr, "time: %s, steps: %d, peak heap: %.1f MiB\n", elapsed.Round(time.Microsecond), t.ev.Steps()-t.startSteps, float64(t.peak)/(1<<20))
+}
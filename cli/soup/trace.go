@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/ocowchun/soup/evaluator"
+)
+
+// traceLimits keeps a traced call on one line, however big its arguments
+var traceLimits = evaluator.PrintLimits{MaxDepth: 3, MaxLength: 10}
+
+// writerTracer writes a line for every call and return, indented by depth:
+//
+//	[1] > (fact 2)
+//	  [2] > (fact 1)
+//	  [2] < 1
+//	[1] < 2
+type writerTracer struct {
+	w io.Writer
+}
+
+func (t *writerTracer) prefix(depth int) string {
+	return fmt.Sprintf("%s[%d]", strings.Repeat("  ", depth-1), depth)
+}
+
+func (t *writerTracer) Enter(call evaluator.TraceCall) {
+	parts := []string{call.Name}
+	for _, arg := range call.Args {
+		parts = append(parts, arg.LimitedPrettyString(math.MaxInt, traceLimits))
+	}
+	fmt.Fprintf(t.w, "%s > (%s)\n", t.prefix(call.Depth), strings.Join(parts, " "))
+}
+
+func (t *writerTracer) Exit(call evaluator.TraceCall, result *evaluator.ReturnValue, err error) {
+	if err != nil {
+		fmt.Fprintf(t.w, "%s < %s failed: %s\n", t.prefix(call.Depth), call.Name, err)
+		return
+	}
+	fmt.Fprintf(t.w, "%s < %s\n", t.prefix(call.Depth), result.LimitedPrettyString(math.MaxInt, traceLimits))
+}
@@ -0,0 +1,195 @@
+//go:build js && wasm
+
+// Command wasm runs soup in a browser. Build it with
+//
+//	GOOS=js GOARCH=wasm go build -o soup.wasm ./cli/wasm
+//	cp "$(go env GOROOT)/lib/wasm/wasm_exec.js" .
+//
+// and load soup.wasm with wasm_exec.js, the page then has a soup object:
+//
+//	soup.setOutput((text, stream) => ...) // stream is "stdout" or "stderr"
+//	soup.eval("(define (square x) (* x x)) (square 4)") // {value: "16"} or {error: "..."}
+//	soup.reset() // start over with a fresh environment
+//
+// Every eval shares one environment, like the entries of a REPL. Soup code calls JavaScript with
+// js-call, e.g. (js-call "Math.max" 1 2) or (js-call "document.querySelector" "#out").
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"syscall/js"
+
+	"github.com/ocowchun/soup/evaluator"
+	"github.com/ocowchun/soup/lexer"
+	"github.com/ocowchun/soup/parser"
+)
+
+// output is the function soup.setOutput was given, undefined until it is called
+var output = js.Undefined()
+
+// outputWriter hands what is written to the output function, tagged with the stream it's for.
+type outputWriter struct {
+	stream string
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	if output.Type() == js.TypeFunction {
+		output.Invoke(string(p), w.stream)
+	}
+	return len(p), nil
+}
+
+var ev *evaluator.Evaluator
+
+func newEvaluator() *evaluator.Evaluator {
+	e := evaluator.New(
+		evaluator.WithStdout(outputWriter{stream: "stdout"}),
+		evaluator.WithStderr(outputWriter{stream: "stderr"}),
+		// there is no terminal to read from
+		evaluator.WithStdin(strings.NewReader("")),
+	)
+	if err := e.Register("js-call", jsCall); err != nil {
+		panic(err)
+	}
+	return e
+}
+
+func main() {
+	ev = newEvaluator()
+	soup := js.Global().Get("Object").New()
+	soup.Set("eval", js.FuncOf(func(this js.Value, args []js.Value) any {
+		if len(args) != 1 || args[0].Type() != js.TypeString {
+			return result("", errors.New("soup.eval expects the source to evaluate"))
+		}
+		return result(eval(args[0].String()))
+	}))
+	soup.Set("setOutput", js.FuncOf(func(this js.Value, args []js.Value) any {
+		if len(args) == 1 {
+			output = args[0]
+		}
+		return nil
+	}))
+	soup.Set("reset", js.FuncOf(func(this js.Value, args []js.Value) any {
+		ev = newEvaluator()
+		return nil
+	}))
+	js.Global().Set("soup", soup)
+
+	// the functions above are called until the page goes away
+	select {}
+}
+
+func eval(src string) (string, error) {
+	p := parser.New(lexer.New(strings.NewReader(src)))
+	p.UseMacros(ev.Macros())
+	program, err := p.Parse()
+	if err != nil {
+		return "", err
+	}
+	ret, err := ev.Eval(program)
+	if err != nil {
+		return "", err
+	}
+	if ret == nil || (ret.Type == evaluator.ConstantType && ret.Data == evaluator.VoidConst) {
+		return "", nil
+	}
+	return ret.Write(), nil
+}
+
+// result is what soup.eval returns, {value: "..."} or {error: "..."}.
+func result(value string, err error) any {
+	if err != nil {
+		var parsingError *parser.ParsingError
+		if errors.As(err, &parsingError) {
+			return map[string]any{"error": fmt.Sprintf("line %d: %s", parsingError.Token.Line, parsingError.Message)}
+		}
+		return map[string]any{"error": err.Error()}
+	}
+	return map[string]any{"value": value}
+}
+
+// jsCall calls the JavaScript function at path, a dotted path from the global object like
+// "console.log", with args. Objects and arrays it returns come back as they would from JSON.
+func jsCall(path string, args ...any) (ret *evaluator.ReturnValue, err error) {
+	defer func() {
+		// a JavaScript exception panics with a js.Error
+		if r := recover(); r != nil {
+			if jsErr, ok := r.(js.Error); ok {
+				err = jsErr
+				return
+			}
+			panic(r)
+		}
+	}()
+
+	this := js.Global()
+	fn := this
+	for _, name := range strings.Split(path, ".") {
+		this = fn
+		fn = fn.Get(name)
+		if fn.IsUndefined() {
+			return nil, fmt.Errorf("%s is undefined", path)
+		}
+	}
+	if fn.Type() != js.TypeFunction {
+		return nil, fmt.Errorf("%s is not a function", path)
+	}
+
+	jsArgs := make([]any, len(args))
+	for i, arg := range args {
+		v, err := toJS(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+		jsArgs[i] = v
+	}
+	return fromJS(fn.Call("bind", this).Invoke(jsArgs...))
+}
+
+// toJS checks that v, a value ToGo returned, is one js.ValueOf takes.
+func toJS(v any) (any, error) {
+	switch v := v.(type) {
+	case nil, bool, int64, float64, string:
+		return v, nil
+	case []any:
+		values := make([]any, len(v))
+		for i, element := range v {
+			value, err := toJS(element)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+		}
+		return values, nil
+	case *evaluator.ReturnValue:
+		return nil, fmt.Errorf("can't pass a %s to JavaScript", v.Type)
+	}
+	return nil, fmt.Errorf("can't pass a %T to JavaScript", v)
+}
+
+func fromJS(v js.Value) (*evaluator.ReturnValue, error) {
+	switch v.Type() {
+	case js.TypeUndefined, js.TypeNull:
+		return evaluator.FromGo(nil)
+	case js.TypeBoolean:
+		return evaluator.FromGo(v.Bool())
+	case js.TypeString:
+		return evaluator.FromGo(v.String())
+	case js.TypeNumber:
+		// JavaScript has no integers, a whole number that fits one is most likely meant as one
+		if f := v.Float(); f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+			return evaluator.FromGo(int64(f))
+		}
+		return evaluator.FromGo(v.Float())
+	case js.TypeObject:
+		var ret evaluator.ReturnValue
+		if err := ret.UnmarshalJSON([]byte(js.Global().Get("JSON").Call("stringify", v).String())); err != nil {
+			return nil, err
+		}
+		return &ret, nil
+	}
+	return nil, fmt.Errorf("can't convert a JavaScript %s", v.Type())
+}
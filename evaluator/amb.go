@@ -0,0 +1,308 @@
+package evaluator
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/ocowchun/soup/parser"
+)
+
+// amb backtracks with success and failure continuations, like the amb evaluator of SICP: once a
+// program uses amb, its top-level expressions are evaluated with ambEval, which passes the value of an
+// expression to a success continuation along with a failure continuation resuming the latest choice
+// that has alternatives left. A failing require calls the failure continuation rather than evaluating
+// anything again, so the expressions evaluated before a choice aren't repeated, and a set! undoes
+// itself when the search backtracks past it.
+//
+// Go doesn't eliminate tail calls, so a failure isn't made by calling the failure continuation where
+// the search fails, deep in the success continuations of the choices made so far: it's returned to
+// evalTopLevel as an ambRetry, which calls it on a fresh stack.
+//
+// Forms ambEval doesn't handle, like guard or parameterize, and procedures called by builtins, like
+// the procedure map calls, are evaluated as usual. An amb in them picks its first choice that
+// doesn't fail while being evaluated, the search can't come back to it later.
+// https://sarabander.github.io/sicp/html/4_002e3.xhtml
+
+var (
+	errAmbFailure = errors.New("amb: no more choices")
+	errTryAgain   = errors.New("try-again")
+)
+
+// ambFail resumes the search at the latest choice with alternatives left.
+type ambFail func() (*ReturnValue, error)
+
+// ambSucceed continues the evaluation with the value of an expression, fail backtracks from it.
+type ambSucceed func(val *ReturnValue, fail ambFail) (*ReturnValue, error)
+
+// ambRetry is returned when the search fails, evalTopLevel continues it by calling resume.
+type ambRetry struct {
+	resume ambFail
+}
+
+func (r *ambRetry) Error() string {
+	return errAmbFailure.Error()
+}
+
+func (r *ambRetry) Unwrap() error {
+	return errAmbFailure
+}
+
+// ambRun is the search of a top-level expression.
+type ambRun struct {
+	expression parser.Expression
+	// chose is set once an amb made a choice, only then can try-again continue the search
+	chose bool
+	// next resumes the search after the last value was found
+	next ambFail
+}
+
+// evalTopLevel evaluates a top-level expression, optimized first when the optimizer is enabled.
+// Once an expression used amb, the top-level expressions are searches, see ambEval, and (try-again)
+// finds the next value of the last one that made a choice.
+func (e *Evaluator) evalTopLevel(exp parser.Expression, environment *Environment) (*ReturnValue, error) {
+	if e.optimizer {
+		exp = e.optimize(exp, environment)
+	}
+	if e.ambRun != nil {
+		return e.eval(exp, environment)
+	}
+	if !e.usesAmb && !containsAmb(exp) {
+		ret, err := e.eval(exp, environment)
+		switch {
+		case errors.Is(err, errTryAgain):
+			return nil, conditionErrorf(GenericCondition, "there is no current problem, try-again needs a previous amb expression")
+		case errors.Is(err, errAmbFailure):
+			return nil, conditionErrorf(GenericCondition, "there are no more values of %s", exp.String())
+		}
+		return ret, err
+	}
+	e.usesAmb = true
+
+	run := &ambRun{expression: exp}
+	resume := func() (*ReturnValue, error) {
+		return e.ambEval(exp, environment, func(val *ReturnValue, fail ambFail) (*ReturnValue, error) {
+			run.next = fail
+			return val, nil
+		}, func() (*ReturnValue, error) {
+			return nil, conditionErrorf(GenericCondition, "there are no more values of %s", run.expression.String())
+		})
+	}
+	for {
+		e.ambRun = run
+		ret, err := resume()
+		e.ambRun = nil
+
+		var retry *ambRetry
+		switch {
+		case err == nil:
+			if run.chose {
+				e.lastAmbRun = run
+			}
+			return ret, nil
+		case errors.As(err, &retry):
+			resume = retry.resume
+		case errors.Is(err, errTryAgain) && run.expression == exp:
+			if e.lastAmbRun == nil {
+				return nil, conditionErrorf(GenericCondition, "there is no current problem, try-again needs a previous amb expression")
+			}
+			run = e.lastAmbRun
+			e.lastAmbRun = nil
+			resume = run.next
+		default:
+			return nil, err
+		}
+	}
+}
+
+// containsAmb reports whether exp has an amb expression in it.
+func containsAmb(exp parser.Expression) bool {
+	if _, ok := exp.(*parser.AmbExpression); ok {
+		return true
+	}
+	found := false
+	mapSubexpressions(exp, func(sub parser.Expression) parser.Expression {
+		found = found || containsAmb(sub)
+		return sub
+	})
+	return found
+}
+
+// ambEval evaluates exp in environment, calling succeed with its value, or fail when it has none.
+func (e *Evaluator) ambEval(exp parser.Expression, environment *Environment, succeed ambSucceed, fail ambFail) (*ReturnValue, error) {
+	switch exp := exp.(type) {
+	case *parser.AmbExpression:
+		if err := e.step(); err != nil {
+			return nil, err
+		}
+		e.ambRun.chose = true
+		var try func(i int) (*ReturnValue, error)
+		try = func(i int) (*ReturnValue, error) {
+			if i == len(exp.Choices) {
+				return nil, &ambRetry{resume: fail}
+			}
+			return e.ambEval(exp.Choices[i], environment, succeed, func() (*ReturnValue, error) {
+				return try(i + 1)
+			})
+		}
+		return try(0)
+	case *parser.IfExpression:
+		if err := e.step(); err != nil {
+			return nil, err
+		}
+		return e.ambEval(exp.Predicate, environment, func(cond *ReturnValue, fail ambFail) (*ReturnValue, error) {
+			if cond.Type != ConstantType || cond.Data != FalseValue {
+				return e.ambEval(exp.Consequent, environment, succeed, fail)
+			}
+			if exp.Alternative == nil {
+				return succeed(Void, fail)
+			}
+			return e.ambEval(exp.Alternative, environment, succeed, fail)
+		}, fail)
+	case *parser.BeginExpression:
+		if err := e.step(); err != nil {
+			return nil, err
+		}
+		return e.ambEvalSequence(exp.Expressions, environment, succeed, fail)
+	case *parser.DefineExpression:
+		if err := e.step(); err != nil {
+			return nil, err
+		}
+		return e.ambEval(exp.Value, environment, func(val *ReturnValue, fail ambFail) (*ReturnValue, error) {
+			nameProcedure(val, exp.Name)
+			environment.Put(exp.Name, val)
+			return succeed(val, fail)
+		}, fail)
+	case *parser.SetExpression:
+		if err := e.step(); err != nil {
+			return nil, err
+		}
+		return e.ambEval(exp.Value, environment, func(val *ReturnValue, fail ambFail) (*ReturnValue, error) {
+			old, err := e.assign(exp, environment, val)
+			if err != nil {
+				return nil, runtimeErrorAt(err, exp.Token(), environment)
+			}
+			return succeed(old, func() (*ReturnValue, error) {
+				if _, err := e.assign(exp, environment, old); err != nil {
+					return nil, err
+				}
+				return fail()
+			})
+		}, fail)
+	case *parser.CallExpression:
+		if err := e.step(); err != nil {
+			return nil, err
+		}
+		return e.ambEval(exp.Operator, environment, func(operator *ReturnValue, fail ambFail) (*ReturnValue, error) {
+			return e.ambEvalOperands(exp, operator, nil, environment, succeed, fail)
+		}, fail)
+	}
+
+	// exp is evaluated as usual, an amb failing in it fails the search here
+	val, err := e.eval(exp, environment)
+	if errors.Is(err, errAmbFailure) {
+		return nil, &ambRetry{resume: fail}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return succeed(val, fail)
+}
+
+// ambEvalSequence evaluates expressions in order, calling succeed with the value of the last one.
+func (e *Evaluator) ambEvalSequence(expressions []parser.Expression, environment *Environment, succeed ambSucceed, fail ambFail) (*ReturnValue, error) {
+	if len(expressions) == 0 {
+		return succeed(Void, fail)
+	}
+	if len(expressions) == 1 {
+		return e.ambEval(expressions[0], environment, succeed, fail)
+	}
+	return e.ambEval(expressions[0], environment, func(_ *ReturnValue, fail ambFail) (*ReturnValue, error) {
+		return e.ambEvalSequence(expressions[1:], environment, succeed, fail)
+	}, fail)
+}
+
+// ambEvalOperands evaluates the operands of exp after those in operands, then calls operator with them.
+func (e *Evaluator) ambEvalOperands(exp *parser.CallExpression, operator *ReturnValue, operands []*ReturnValue, environment *Environment, succeed ambSucceed, fail ambFail) (*ReturnValue, error) {
+	if len(operands) == len(exp.Operands) {
+		return e.ambApply(exp, operator, operands, environment, succeed, fail)
+	}
+	return e.ambEval(exp.Operands[len(operands)], environment, func(operand *ReturnValue, fail ambFail) (*ReturnValue, error) {
+		// or and and are builtins that don't evaluate the operands after the one deciding them
+		isFalse := operand.Type == ConstantType && operand.Data == FalseValue
+		if operator.Type == BuiltinFunctionType && exp.Operator.String() == "or" && !isFalse {
+			return succeed(operand, fail)
+		}
+		if operator.Type == BuiltinFunctionType && exp.Operator.String() == "and" && isFalse {
+			return succeed(False, fail)
+		}
+		// each choice of an operand continues with operands of its own
+		return e.ambEvalOperands(exp, operator, append(slices.Clip(operands), operand), environment, succeed, fail)
+	}, fail)
+}
+
+// ambApply calls operator with operands, the body of a procedure is evaluated with ambEval.
+func (e *Evaluator) ambApply(exp *parser.CallExpression, operator *ReturnValue, operands []*ReturnValue, environment *Environment, succeed ambSucceed, fail ambFail) (*ReturnValue, error) {
+	if operator.Type != ProcedureType {
+		ret, err := e.applyProcedure(operator, operands, environment)
+		if errors.Is(err, errAmbFailure) {
+			return nil, &ambRetry{resume: fail}
+		}
+		if err != nil {
+			return nil, newRuntimeError(err, exp.Operator.Token(), procedureName{operator: exp.Operator}, environment)
+		}
+		return succeed(ret, fail)
+	}
+
+	procedure := operator.Procedure()
+	if procedure.CaneTakeArbitraryParameters() {
+		if len(procedure.Parameters) > len(operands) {
+			return nil, runtimeErrorAt(arityError(procedure, len(operands)), exp.Operator.Token(), environment)
+		}
+	} else if len(procedure.Parameters) != len(operands) {
+		return nil, runtimeErrorAt(arityError(procedure, len(operands)), exp.Operator.Token(), environment)
+	}
+	newEnv := newCallEnvironment(procedure.Env)
+	for i, param := range procedure.Parameters {
+		newEnv.Put(param, operands[i])
+	}
+	if procedure.CaneTakeArbitraryParameters() {
+		newEnv.Put(procedure.OptionalTailParameter, makeList(operands[len(procedure.Parameters):]))
+	}
+	return e.ambEvalSequence(procedure.Body, newEnv, succeed, fail)
+}
+
+func addAmbBuiltins(env *Environment) {
+	addBuiltinToEnv(env, "require", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'require' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+
+			if parameters[0].Type == ConstantType && parameters[0].Data == FalseValue {
+				return nil, errAmbFailure
+			}
+			return Void, nil
+		},
+	})
+
+	addBuiltinToEnv(env, "try-again", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 0 {
+				return nil, conditionErrorf(WrongArityCondition, "'try-again' has been called with %d arguments; it requires exactly 0 argument", len(parameters))
+			}
+			return nil, errTryAgain
+		},
+	})
+}
+
+// evalAmbExpression evaluates an amb outside a search, as in a procedure a builtin calls: it's the
+// first choice that doesn't fail.
+func (e *Evaluator) evalAmbExpression(exp *parser.AmbExpression, environment *Environment) (*ReturnValue, error) {
+	for _, choice := range exp.Choices {
+		val, err := e.eval(choice, environment)
+		if !errors.Is(err, errAmbFailure) {
+			return val, err
+		}
+	}
+	return nil, errAmbFailure
+}
@@ -0,0 +1,59 @@
+package evaluator
+
+// With WithAllocationArena the values arithmetic, number literals and cons make most often are
+// carved out of chunks that are allocated arenaChunkSize values at a time, rather than allocated
+// one by one, and so are the numbers they hold: a batch evaluation makes a fraction of the
+// allocations and the collector has far fewer objects to track. The arena starts a new chunk on every run, the chunks of the last one are
+// freed by the collector once none of their values are referenced. A value that outlives its run
+// keeps its whole chunk alive, which is the price of the mode.
+const arenaChunkSize = 256
+
+type valueArena struct {
+	chunk []ReturnValue
+	// numbers holds the numbers of the values from newNumber, which point to them: boxing a Number
+	// in Data would allocate it on its own
+	numbers []Number
+}
+
+func (a *valueArena) newValue(t ValueType, data any) *ReturnValue {
+	if len(a.chunk) == 0 {
+		a.chunk = make([]ReturnValue, arenaChunkSize)
+	}
+	rv := &a.chunk[0]
+	a.chunk = a.chunk[1:]
+	rv.Type = t
+	rv.Data = data
+	return rv
+}
+
+func (a *valueArena) newNumber(n Number) *ReturnValue {
+	if len(a.numbers) == 0 {
+		a.numbers = make([]Number, arenaChunkSize)
+	}
+	number := &a.numbers[0]
+	a.numbers = a.numbers[1:]
+	*number = n
+	return a.newValue(NumberType, number)
+}
+
+// reset drops the current chunks, so the values of the next run don't share them with this one's.
+func (a *valueArena) reset() {
+	a.chunk = nil
+	a.numbers = nil
+}
+
+// newValue returns a new value, from the arena when the evaluator has one.
+func (e *Evaluator) newValue(t ValueType, data any) *ReturnValue {
+	if e.arena != nil {
+		return e.arena.newValue(t, data)
+	}
+	return &ReturnValue{Type: t, Data: data}
+}
+
+// newNumber returns a new number value, see newValue. The Data of one from the arena is a *Number.
+func (e *Evaluator) newNumber(n Number) *ReturnValue {
+	if e.arena != nil {
+		return e.arena.newNumber(n)
+	}
+	return &ReturnValue{Type: NumberType, Data: n}
+}
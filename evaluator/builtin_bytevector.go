@@ -0,0 +1,194 @@
+package evaluator
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// A bytevector is a fixed-length sequence of bytes, the integers 0 to 255, indexed from 0 like a
+// vector. utf8->string and string->utf8 convert between strings and their UTF-8 encoding.
+
+// bytevectorArgument returns the bytevector parameters[i] of the builtin name.
+func bytevectorArgument(parameters []*ReturnValue, i int, name string) (*BytevectorValue, error) {
+	if parameters[i].Type != BytevectorType {
+		return nil, conditionErrorf(WrongTypeCondition, "'%s' expected a bytevector, got %s", name, parameters[i].Type)
+	}
+	return parameters[i].Bytevector(), nil
+}
+
+// byteArgument returns the byte parameters[i] of the builtin name.
+func byteArgument(parameters []*ReturnValue, i int, name string) (byte, error) {
+	val := parameters[i]
+	if val.Type == NumberType {
+		n := val.Number()
+		if n.isInt64() && n.Int64() >= 0 && n.Int64() <= 255 {
+			return byte(n.Int64()), nil
+		}
+	}
+	return 0, conditionErrorf(WrongTypeCondition, "'%s' expected a byte, got %s", name, val.String())
+}
+
+// bytevectorIndexArgument returns the index parameters[i] of the builtin name into bytevector.
+func bytevectorIndexArgument(parameters []*ReturnValue, i int, name string, bytevector *BytevectorValue) (int, error) {
+	k, err := indexArgument(parameters, i, name)
+	if err != nil {
+		return 0, err
+	}
+	if k >= len(bytevector.Bytes) {
+		return 0, conditionErrorf(IndexOutOfRangeCondition, "'%s' index %d is out of range for a bytevector of length %d", name, k, len(bytevector.Bytes))
+	}
+	return k, nil
+}
+
+func addBytevectorBuiltins(env *Environment) {
+	addBuiltinToEnv(env, "bytevector?", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'bytevector?' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+			return boolValue(parameters[0].Type == BytevectorType), nil
+		},
+	})
+
+	// (make-bytevector k [byte]) returns a bytevector of k bytes, each of them byte or 0
+	addBuiltinToEnv(env, "make-bytevector", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 && len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'make-bytevector' has been called with %d arguments; it requires 1 or 2 arguments", len(parameters))
+			}
+			k, err := indexArgument(parameters, 0, "make-bytevector")
+			if err != nil {
+				return nil, err
+			}
+			var fill byte
+			if len(parameters) == 2 {
+				if fill, err = byteArgument(parameters, 1, "make-bytevector"); err != nil {
+					return nil, err
+				}
+			}
+			// the cells are counted before the bytes are made, see make-vector
+			if err := evaluator.allocate(k / cellBytes); err != nil {
+				return nil, err
+			}
+			bytes := make([]byte, k)
+			for i := range bytes {
+				bytes[i] = fill
+			}
+			return &ReturnValue{Type: BytevectorType, Data: &BytevectorValue{Bytes: bytes}}, nil
+		},
+	})
+
+	addBuiltinToEnv(env, "bytevector", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			bytes := make([]byte, len(parameters))
+			for i := range parameters {
+				octet, err := byteArgument(parameters, i, "bytevector")
+				if err != nil {
+					return nil, err
+				}
+				bytes[i] = octet
+			}
+			return &ReturnValue{Type: BytevectorType, Data: &BytevectorValue{Bytes: bytes}}, nil
+		},
+	})
+
+	addBuiltinToEnv(env, "bytevector-length", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'bytevector-length' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+			bytevector, err := bytevectorArgument(parameters, 0, "bytevector-length")
+			if err != nil {
+				return nil, err
+			}
+			return evaluator.newNumber(MakeInt64Number(int64(len(bytevector.Bytes)))), nil
+		},
+	})
+
+	addBuiltinToEnv(env, "bytevector-u8-ref", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'bytevector-u8-ref' has been called with %d arguments; it requires exactly 2 arguments", len(parameters))
+			}
+			bytevector, err := bytevectorArgument(parameters, 0, "bytevector-u8-ref")
+			if err != nil {
+				return nil, err
+			}
+			k, err := bytevectorIndexArgument(parameters, 1, "bytevector-u8-ref", bytevector)
+			if err != nil {
+				return nil, err
+			}
+			return evaluator.newNumber(MakeInt64Number(int64(bytevector.Bytes[k]))), nil
+		},
+	})
+
+	addBuiltinToEnv(env, "bytevector-u8-set!", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 3 {
+				return nil, conditionErrorf(WrongArityCondition, "'bytevector-u8-set!' has been called with %d arguments; it requires exactly 3 arguments", len(parameters))
+			}
+			bytevector, err := bytevectorArgument(parameters, 0, "bytevector-u8-set!")
+			if err != nil {
+				return nil, err
+			}
+			k, err := bytevectorIndexArgument(parameters, 1, "bytevector-u8-set!", bytevector)
+			if err != nil {
+				return nil, err
+			}
+			octet, err := byteArgument(parameters, 2, "bytevector-u8-set!")
+			if err != nil {
+				return nil, err
+			}
+			bytevector.Bytes[k] = octet
+			return Void, nil
+		},
+	})
+
+	// (utf8->string bytevector [start [end]]) decodes the bytes from start up to end, a byte that
+	// isn't part of a valid UTF-8 sequence decodes as U+FFFD
+	addBuiltinToEnv(env, "utf8->string", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) < 1 || len(parameters) > 3 {
+				return nil, conditionErrorf(WrongArityCondition, "'utf8->string' has been called with %d arguments; it requires 1 to 3 arguments", len(parameters))
+			}
+			bytevector, err := bytevectorArgument(parameters, 0, "utf8->string")
+			if err != nil {
+				return nil, err
+			}
+			start, end := 0, len(bytevector.Bytes)
+			if len(parameters) > 1 {
+				if start, err = indexArgument(parameters, 1, "utf8->string"); err != nil {
+					return nil, err
+				}
+			}
+			if len(parameters) > 2 {
+				if end, err = indexArgument(parameters, 2, "utf8->string"); err != nil {
+					return nil, err
+				}
+			}
+			if end > len(bytevector.Bytes) || start > end {
+				return nil, conditionErrorf(IndexOutOfRangeCondition, "'utf8->string' range %d to %d is out of range for a bytevector of length %d", start, end, len(bytevector.Bytes))
+			}
+			s := string(bytevector.Bytes[start:end])
+			if !utf8.ValidString(s) {
+				s = strings.ToValidUTF8(s, string(utf8.RuneError))
+			}
+			return newString(s), nil
+		},
+	})
+
+	// (string->utf8 s [start [end]]) encodes the characters of s from start up to end
+	addBuiltinToEnv(env, "string->utf8", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) < 1 || len(parameters) > 3 {
+				return nil, conditionErrorf(WrongArityCondition, "'string->utf8' has been called with %d arguments; it requires 1 to 3 arguments", len(parameters))
+			}
+			s, start, end, err := rangeArguments(parameters, 1, "string->utf8")
+			if err != nil {
+				return nil, err
+			}
+			bytes := []byte(runeSubstring(s, start, end))
+			return &ReturnValue{Type: BytevectorType, Data: &BytevectorValue{Bytes: bytes}}, nil
+		},
+	})
+}
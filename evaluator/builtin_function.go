@@ -2,11 +2,11 @@ package evaluator
 
 import (
 	"bufio"
-	"errors"
 	"fmt"
 	"io"
 	"math"
-	"math/rand"
+	"math/big"
+	"strings"
 
 	"github.com/ocowchun/soup/lexer"
 )
@@ -17,13 +17,9 @@ func getCar(val *ReturnValue) (*ReturnValue, error) {
 		cons := val.Cons()
 		return cons.Car, nil
 	case ListType:
-		list := val.List()
-		if len(list.Elements) == 0 {
-			return nil, fmt.Errorf("cannot call 'car' on an empty list")
-		}
-		return list.Elements[0], nil
+		return nil, conditionErrorf(WrongTypeCondition, "cannot call 'car' on an empty list")
 	default:
-		return nil, fmt.Errorf("'car' expected cons or list value, got %s", val.Type)
+		return nil, conditionErrorf(WrongTypeCondition, "'car' expected cons or list value, got %s", val.Type)
 	}
 }
 func getCdr(val *ReturnValue) (*ReturnValue, error) {
@@ -32,14 +28,9 @@ func getCdr(val *ReturnValue) (*ReturnValue, error) {
 		cons := val.Cons()
 		return cons.Cdr, nil
 	case ListType:
-		list := val.List()
-		if len(list.Elements) == 0 {
-			return nil, fmt.Errorf("cannot call 'cdr' on an empty list")
-		}
-		newList := &ListValue{Elements: list.Elements[1:]}
-		return &ReturnValue{Type: ListType, Data: newList}, nil
+		return nil, conditionErrorf(WrongTypeCondition, "cannot call 'cdr' on an empty list")
 	default:
-		return nil, fmt.Errorf("'cdr' expected cons or list value, got %s", val.Type)
+		return nil, conditionErrorf(WrongTypeCondition, "'cdr' expected cons or list value, got %s", val.Type)
 	}
 }
 
@@ -54,7 +45,7 @@ func ConProcedureFactory(operations []ConOperation) *BuiltinFunction {
 	return &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
 			if len(parameters) != 1 {
-				return nil, fmt.Errorf("expected 1 argument, got %d", len(parameters))
+				return nil, conditionErrorf(WrongArityCondition, "expected 1 argument, got %d", len(parameters))
 			}
 
 			val := parameters[0]
@@ -80,99 +71,181 @@ func ConProcedureFactory(operations []ConOperation) *BuiltinFunction {
 	}
 }
 
+// cons returns a new pair of car and cdr.
+func cons(car *ReturnValue, cdr *ReturnValue) *ReturnValue {
+	return &ReturnValue{Type: ConsType, Data: &ConsValue{Car: car, Cdr: cdr}}
+}
+
 func isPair(val *ReturnValue) bool {
-	switch val.Type {
-	case ConsType:
-		return true
-	case ListType:
-		list := val.List()
-		if len(list.Elements) > 0 {
-			return true
-		}
-		return false
-	default:
-		return false
-	}
+	return val.Type == ConsType
+}
+
+// addMemberBuiltin adds name, (name obj list) returns the first pair of list whose car is same as
+// obj, #f if there's none: memq compares with eq?, memv with eqv? and member with equal?.
+func addMemberBuiltin(env *Environment, name string, same func(a *ReturnValue, b *ReturnValue) bool) {
+	addBuiltinToEnv(env, name, &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'%s' has been called with %d arguments; it requires exactly 2 arguments", name, len(parameters))
+			}
+			obj := parameters[0]
+			val := parameters[1]
+
+			for ; val.Type == ConsType; val = val.Cons().Cdr {
+				if same(val.Cons().Car, obj) {
+					return val, nil
+				}
+			}
+			if val.Type != ListType {
+				return nil, conditionErrorf(WrongTypeCondition, "'%s' expected a list, got %s", name, parameters[1].Type)
+			}
+
+			return False, nil
+		},
+	})
+}
+
+// addAssocBuiltin adds name, (name key alist) returns the first pair of the association list alist
+// whose car is same as key, #f if there's none: assq compares with eq?, assv with eqv? and assoc
+// with equal?.
+func addAssocBuiltin(env *Environment, name string, same func(a *ReturnValue, b *ReturnValue) bool) {
+	addBuiltinToEnv(env, name, &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'%s' has been called with %d arguments; it requires exactly 2 arguments", name, len(parameters))
+			}
+			key := parameters[0]
+			val := parameters[1]
+
+			for ; val.Type == ConsType; val = val.Cons().Cdr {
+				item := val.Cons().Car
+				if item.Type != ConsType {
+					return nil, conditionErrorf(WrongTypeCondition, "'%s' expected a list of pairs, got %s", name, item)
+				}
+				if same(item.Cons().Car, key) {
+					return item, nil
+				}
+			}
+			if val.Type != ListType {
+				return nil, conditionErrorf(WrongTypeCondition, "'%s' expected a list, got %s", name, parameters[1].Type)
+			}
+
+			return False, nil
+		},
+	})
 }
 
-func compareNumber(parameters []*ReturnValue, op string, evaluator *Evaluator, environment *Environment) (int, error) {
+// compareNumber reports whether holds is true of the comparison of the two numbers parameters of the
+// builtin op, see compareNumbers. Nothing holds of a NaN, which isn't ordered, even (= +nan.0 +nan.0)
+// is false.
+func compareNumber(parameters []*ReturnValue, op string, holds func(cmp int) bool) (*ReturnValue, error) {
 	if len(parameters) != 2 {
-		return 0, fmt.Errorf("'%s' has been called with %d arguments; it requires exactly 1 argument", op, len(parameters))
+		return nil, conditionErrorf(WrongArityCondition, "'%s' has been called with %d arguments; it requires exactly 1 argument", op, len(parameters))
 	}
 
 	left := parameters[0]
 	if left.Type != NumberType {
-		return 0, fmt.Errorf("!expected number value, got %s", left.Type)
+		return nil, conditionErrorf(WrongTypeCondition, "!expected number value, got %s", left.Type)
 	}
-	leftVal := left.Number().Float64()
 
 	right := parameters[1]
 	if right.Type != NumberType {
-		return 0, fmt.Errorf("expected number value, got %s", right.Type)
+		return nil, conditionErrorf(WrongTypeCondition, "expected number value, got %s", right.Type)
 	}
-	rightVal := right.Number().Float64()
-
-	if leftVal > rightVal {
-		return 1, nil
-	} else if leftVal < rightVal {
-		return -1, nil
-	} else {
-		return 0, nil
+
+	if math.IsNaN(left.Number().Float64()) || math.IsNaN(right.Number().Float64()) {
+		return False, nil
 	}
+	return boolValue(holds(compareNumbers(left.Number(), right.Number()))), nil
 }
 
+// force returns the value of the promise val, evaluated the first time it's forced. A promise of
+// delay-force takes over the promise its expression evaluates to and goes on with it, in a loop
+// rather than recursively, so a chain of delay-forces such as a lazily filtered stream is forced in
+// constant space.
 func force(val *ReturnValue, evaluator *Evaluator) (*ReturnValue, error) {
 	if val.Type != PromiseType {
-		return nil, fmt.Errorf("expected promise type, got %s", val.Type)
-	}
-	promise := val.Promise()
-	if promise.EvaluatedValue != nil {
-		return promise.EvaluatedValue, nil
+		return nil, conditionErrorf(WrongTypeCondition, "expected promise type, got %s", val.Type)
 	}
+	promise := val.Promise().resolve()
+	for promise.EvaluatedValue == nil {
+		var evaluatedValue *ReturnValue
+		var err error
+		if promise.Thunk != nil {
+			evaluatedValue, err = promise.Thunk()
+		} else {
+			evaluatedValue, err = evaluator.eval(promise.Expression, promise.Env)
+		}
+		if err != nil {
+			return nil, err
+		}
+		// the promise was forced while it was evaluated, the value it got then is kept
+		if promise.EvaluatedValue != nil {
+			break
+		}
+		if !promise.delayForce {
+			promise.EvaluatedValue = evaluatedValue
+			promise.Thunk = nil
+			promise.Env = nil
+			break
+		}
 
-	evaluatedValue, err := evaluator.eval(promise.Expression, promise.Env)
-	if err != nil {
-		return nil, err
+		if evaluatedValue.Type != PromiseType {
+			return nil, conditionErrorf(WrongTypeCondition, "'delay-force' expected a promise, got %s", evaluatedValue.Type)
+		}
+		next := evaluatedValue.Promise().resolve()
+		if next == promise {
+			return nil, conditionErrorf(GenericCondition, "'delay-force' evaluated to its own promise")
+		}
+		promise.Expression, promise.Env, promise.Thunk = next.Expression, next.Env, next.Thunk
+		promise.delayForce, promise.EvaluatedValue = next.delayForce, next.EvaluatedValue
+		*next = PromiseValue{forward: promise}
 	}
-	promise.EvaluatedValue = evaluatedValue
-
-	return evaluatedValue, nil
 
+	return promise.EvaluatedValue, nil
 }
 func isNull(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
 	if len(parameters) != 1 {
-		return nil, fmt.Errorf("'null?' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+		return nil, conditionErrorf(WrongArityCondition, "'null?' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
 	}
 
-	val := parameters[0]
-	if val.Type != ListType {
-		return &ReturnValue{Type: ConstantType, Data: FalseValue}, nil
-	}
+	return boolValue(parameters[0].Type == ListType), nil
+}
 
-	if len(val.List().Elements) == 0 {
-		return &ReturnValue{Type: ConstantType, Data: TrueValue}, nil
-	} else {
-		return &ReturnValue{Type: ConstantType, Data: FalseValue}, nil
+// mitErrorMessage formats the arguments of error the way MIT Scheme reports them: a string message is
+// displayed, the irritants are written after it separated by spaces.
+func mitErrorMessage(parameters []*ReturnValue) string {
+	var b strings.Builder
+	for i, val := range parameters {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		if i == 0 && val.Type == StringType {
+			b.WriteString(val.StringValue())
+		} else {
+			b.WriteString(val.Display(1))
+		}
 	}
+	return b.String()
 }
 
-func initGlobalEnvironment(stdin io.Reader) *Environment {
+func initGlobalEnvironment() *Environment {
 	env := newEnvironment()
 	// Add built-in functions to the environment
 
 	//env["the-empty-stream"]
-	env.Put("the-empty-stream", &ReturnValue{Type: ListType, Data: &ListValue{Elements: make([]*ReturnValue, 0)}})
+	env.Put("the-empty-stream", EmptyList)
 
 	addBuiltinToEnv(env, "+", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
-			res := float64(0)
+			res := MakeInt64Number(0)
 			for _, val := range parameters {
 				if val.Type != NumberType {
-					return nil, fmt.Errorf("all arguments to '+' must be numbers, got %s", val.Type)
+					return nil, conditionErrorf(WrongTypeCondition, "all arguments to '+' must be numbers, got %s", val.Type)
 				}
-				res += val.Number().Float64()
+				res = addNumbers(res, val.Number())
 			}
-			return &ReturnValue{Type: NumberType, Data: MakeFloat64Number(res)}, nil
+			return evaluator.newNumber(res), nil
 		},
 	})
 
@@ -180,217 +253,210 @@ func initGlobalEnvironment(stdin io.Reader) *Environment {
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
 
 			if len(parameters) == 0 {
-				return nil, fmt.Errorf("'-' requires at least one argument")
+				return nil, conditionErrorf(WrongArityCondition, "'-' requires at least one argument")
 			}
 			if len(parameters) == 1 {
 				val := parameters[0]
 				if val.Type != NumberType {
-					return nil, fmt.Errorf("all arguments to '-' must be numbers, got %s", val.Type)
+					return nil, conditionErrorf(WrongTypeCondition, "all arguments to '-' must be numbers, got %s", val.Type)
 				}
-				num := val.Number()
-				if num.isInt64() && num.Int64() != math.MinInt64 {
-					i := num.Int64() * -1
-					return &ReturnValue{Type: NumberType, Data: MakeInt64Number(i)}, nil
+				if num := val.Number(); num.isExact() {
+					return evaluator.newNumber(subtractNumbers(MakeInt64Number(0), num)), nil
 				}
 
-				return &ReturnValue{Type: NumberType, Data: MakeFloat64Number(-val.Number().Float64())}, nil
+				return evaluator.newNumber(MakeFloat64Number(-val.Number().Float64())), nil
 			}
 
-			res := float64(0)
+			var res Number
 			for i, val := range parameters {
 				if val.Type != NumberType {
-					return nil, fmt.Errorf("all arguments to '-' must be numbers, got %s", val.Type)
+					return nil, conditionErrorf(WrongTypeCondition, "all arguments to '-' must be numbers, got %s", val.Type)
 				}
 
 				if i == 0 {
-					res = val.Number().Float64()
+					res = val.Number()
 				} else {
-					res -= val.Number().Float64()
+					res = subtractNumbers(res, val.Number())
 				}
 			}
 
-			return &ReturnValue{Type: NumberType, Data: MakeFloat64Number(res)}, nil
+			return evaluator.newNumber(res), nil
 		},
 	})
 
 	addBuiltinToEnv(env, "*", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
-			res := float64(1)
+			res := MakeInt64Number(1)
 
 			if len(parameters) == 0 {
-				return nil, fmt.Errorf("'*' requires at least one argument")
+				return nil, conditionErrorf(WrongArityCondition, "'*' requires at least one argument")
 			}
 
 			for _, parameter := range parameters {
 				if parameter.Type != NumberType {
-					return nil, fmt.Errorf("all arguments to '*' must be numbers, got %s", parameter.Type)
+					return nil, conditionErrorf(WrongTypeCondition, "all arguments to '*' must be numbers, got %s", parameter.Type)
 				}
-				res *= parameter.Number().Float64()
+				res = multiplyNumbers(res, parameter.Number())
 			}
 
-			return &ReturnValue{Type: NumberType, Data: MakeFloat64Number(res)}, nil
+			return evaluator.newNumber(res), nil
 		},
 	})
 
 	addBuiltinToEnv(env, "/", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
-			res := float64(0)
-
 			if len(parameters) == 0 {
-				return nil, fmt.Errorf("'/' requires at least one argument")
+				return nil, conditionErrorf(WrongArityCondition, "'/' requires at least one argument")
 			}
 
+			var res Number
 			for i, parameter := range parameters {
 				if parameter.Type != NumberType {
-					return nil, fmt.Errorf("all arguments to '/' must be numbers, got %s", parameter.Type)
+					return nil, conditionErrorf(WrongTypeCondition, "all arguments to '/' must be numbers, got %s", parameter.Type)
 				}
 				if i == 0 {
-					res = parameter.Number().Float64()
-				} else {
-					res /= parameter.Number().Float64()
+					res = parameter.Number()
+					continue
+				}
+				var err error
+				if res, err = divideNumbers(res, parameter.Number()); err != nil {
+					return nil, err
 				}
 			}
 
-			return &ReturnValue{Type: NumberType, Data: MakeFloat64Number(res)}, nil
+			return evaluator.newNumber(res), nil
 		},
 	})
 
 	addBuiltinToEnv(env, "remainder", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
 			if len(parameters) != 2 {
-				return nil, fmt.Errorf("'remainder' has been called with %d arguments; it requires exactly 2 argument", len(parameters))
+				return nil, conditionErrorf(WrongArityCondition, "'remainder' has been called with %d arguments; it requires exactly 2 argument", len(parameters))
 			}
 
 			a := parameters[0]
 			if a.Type != NumberType {
-				return nil, fmt.Errorf("expected number value, got %s", a.Type)
+				return nil, conditionErrorf(WrongTypeCondition, "expected number value, got %s", a.Type)
 			}
 			b := parameters[1]
 			if b.Type != NumberType {
-				return nil, fmt.Errorf("expected number value, got %s", b.Type)
+				return nil, conditionErrorf(WrongTypeCondition, "expected number value, got %s", b.Type)
 			}
 
 			if a.Number().isInt64() && b.Number().isInt64() {
+				if b.Number().Int64() == 0 {
+					return nil, conditionErrorf(DivisionByZeroCondition, "'remainder' division by zero")
+				}
 				data := a.Number().Int64() % b.Number().Int64()
-				return &ReturnValue{Type: NumberType, Data: MakeInt64Number(data)}, nil
+				return evaluator.newNumber(MakeInt64Number(data)), nil
+			}
+			if a.Number().isExactInteger() && b.Number().isExactInteger() {
+				if b.Number().isInt64() && b.Number().Int64() == 0 {
+					return nil, conditionErrorf(DivisionByZeroCondition, "'remainder' division by zero")
+				}
+				data := new(big.Int).Rem(a.Number().bigInteger(), b.Number().bigInteger())
+				return evaluator.newNumber(makeIntegerNumber(data)), nil
 			}
 			data := math.Mod(a.Number().Float64(), b.Number().Float64())
-			return &ReturnValue{Type: NumberType, Data: MakeFloat64Number(data)}, nil
+			return evaluator.newNumber(MakeFloat64Number(data)), nil
 		},
 	})
 
 	addBuiltinToEnv(env, "sqrt", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
 			if len(parameters) != 1 {
-				return nil, fmt.Errorf("'sqrt' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+				return nil, conditionErrorf(WrongArityCondition, "'sqrt' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
 			}
 
 			a := parameters[0]
 			if a.Type != NumberType {
-				return nil, fmt.Errorf("expected number value, got %s", a.Type)
+				return nil, conditionErrorf(WrongTypeCondition, "expected number value, got %s", a.Type)
+			}
+			if root, ok := exactSqrt(a.Number()); ok {
+				return evaluator.newNumber(root), nil
 			}
 			res := math.Sqrt(a.Number().Float64())
 
-			return &ReturnValue{Type: NumberType, Data: MakeFloat64Number(res)}, nil
+			return evaluator.newNumber(MakeFloat64Number(res)), nil
 		},
 	})
 
 	addBuiltinToEnv(env, "abs", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
 			if len(parameters) != 1 {
-				return nil, fmt.Errorf("'abs' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+				return nil, conditionErrorf(WrongArityCondition, "'abs' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
 			}
 
 			a := parameters[0]
 			if a.Type != NumberType {
-				return nil, fmt.Errorf("expected number value, got %s", a.Type)
+				return nil, conditionErrorf(WrongTypeCondition, "expected number value, got %s", a.Type)
 			}
 
-			if a.Number().isInt64() && a.Number().Int64() != math.MinInt64 {
-				res := a.Number().Int64()
-				if res < 0 {
-					res *= -1
+			if n := a.Number(); n.isExact() {
+				if compareNumbers(n, MakeInt64Number(0)) < 0 {
+					return evaluator.newNumber(subtractNumbers(MakeInt64Number(0), n)), nil
 				}
-				return &ReturnValue{Type: NumberType, Data: MakeInt64Number(res)}, nil
+				return a, nil
 			}
 
 			res := math.Abs(a.Number().Float64())
 
-			return &ReturnValue{Type: NumberType, Data: MakeFloat64Number(res)}, nil
+			return evaluator.newNumber(MakeFloat64Number(res)), nil
 		},
 	})
 
 	addBuiltinToEnv(env, "number?", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
 			if len(parameters) != 1 {
-				return nil, fmt.Errorf("'number?' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+				return nil, conditionErrorf(WrongArityCondition, "'number?' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
 			}
 
 			val := parameters[0]
-			if val.Type == NumberType {
-				return &ReturnValue{Type: ConstantType, Data: TrueValue}, nil
-			} else {
-				return &ReturnValue{Type: ConstantType, Data: FalseValue}, nil
-			}
+			return boolValue(val.Type == NumberType), nil
 		},
 	})
 
 	addBuiltinToEnv(env, "string?", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
 			if len(parameters) != 1 {
-				return nil, fmt.Errorf("'string?' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+				return nil, conditionErrorf(WrongArityCondition, "'string?' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
 			}
 
 			val := parameters[0]
-			if val.Type == StringType {
-				return &ReturnValue{Type: ConstantType, Data: TrueValue}, nil
-			} else {
-				return &ReturnValue{Type: ConstantType, Data: FalseValue}, nil
-			}
+			return boolValue(val.Type == StringType), nil
 		},
 	})
 
 	addBuiltinToEnv(env, "symbol?", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
 			if len(parameters) != 1 {
-				return nil, fmt.Errorf("'symbol?' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+				return nil, conditionErrorf(WrongArityCondition, "'symbol?' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
 			}
 
 			val := parameters[0]
-			if val.Type == SymbolType {
-				return &ReturnValue{Type: ConstantType, Data: TrueValue}, nil
-			} else {
-				return &ReturnValue{Type: ConstantType, Data: FalseValue}, nil
-			}
+			return boolValue(val.Type == SymbolType), nil
 		},
 	})
 
 	addBuiltinToEnv(env, "pair?", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
 			if len(parameters) != 1 {
-				return nil, fmt.Errorf("'pair?' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+				return nil, conditionErrorf(WrongArityCondition, "'pair?' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
 			}
 
 			val := parameters[0]
-			if isPair(val) {
-				return &ReturnValue{Type: ConstantType, Data: TrueValue}, nil
-			} else {
-				return &ReturnValue{Type: ConstantType, Data: FalseValue}, nil
-			}
+			return boolValue(isPair(val)), nil
 		},
 	})
 
 	addBuiltinToEnv(env, "list?", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
 			if len(parameters) != 1 {
-				return nil, fmt.Errorf("'pair?' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+				return nil, conditionErrorf(WrongArityCondition, "'pair?' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
 			}
 
 			val := parameters[0]
-			if val.Type == ListType {
-				return &ReturnValue{Type: ConstantType, Data: TrueValue}, nil
-			}
-			return &ReturnValue{Type: ConstantType, Data: FalseValue}, nil
+			return boolValue(isList(val)), nil
 		},
 	})
 
@@ -400,138 +466,71 @@ func initGlobalEnvironment(stdin io.Reader) *Environment {
 	addBuiltinToEnv(env, "eq?", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
 			if len(parameters) != 2 {
-				return nil, fmt.Errorf("'eq?' has been called with %d arguments; it requires exactly 2 argument", len(parameters))
-			}
-
-			val1 := parameters[0]
-			val2 := parameters[1]
-
-			if val1 == val2 {
-				return &ReturnValue{Type: ConstantType, Data: TrueValue}, nil
+				return nil, conditionErrorf(WrongArityCondition, "'eq?' has been called with %d arguments; it requires exactly 2 argument", len(parameters))
 			}
 
-			if val1.Type == val2.Type {
-				switch val1.Type {
-				case ConstantType:
-					if val1.Constant() == val2.Constant() {
-						return &ReturnValue{Type: ConstantType, Data: TrueValue}, nil
-					}
-				case NumberType:
-					if val1.Number() == val2.Number() {
-						return &ReturnValue{Type: ConstantType, Data: TrueValue}, nil
-					}
-				case StringType:
+			return boolValue(eq(parameters[0], parameters[1])), nil
+		},
+	})
 
-					if val1.String() == val2.String() {
-						return &ReturnValue{Type: ConstantType, Data: TrueValue}, nil
-					}
-				case SymbolType:
-					if val1.Symbol() == val2.Symbol() {
-						return &ReturnValue{Type: ConstantType, Data: TrueValue}, nil
-					}
-				case ListType:
-					if len(val1.List().Elements) == 0 && len(val2.List().Elements) == 0 {
-						return &ReturnValue{Type: ConstantType, Data: TrueValue}, nil
-					}
-				}
+	addBuiltinToEnv(env, "eqv?", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'eqv?' has been called with %d arguments; it requires exactly 2 arguments", len(parameters))
 			}
 
-			return &ReturnValue{Type: ConstantType, Data: FalseValue}, nil
+			return boolValue(eqv(parameters[0], parameters[1])), nil
 		},
 	})
 
 	addBuiltinToEnv(env, "equal?", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
 			if len(parameters) != 2 {
-				return nil, fmt.Errorf("'equal?' has been called with %d arguments; it requires exactly 2 argument", len(parameters))
+				return nil, conditionErrorf(WrongArityCondition, "'equal?' has been called with %d arguments; it requires exactly 2 argument", len(parameters))
 			}
 
 			val1 := parameters[0]
 			val2 := parameters[1]
-			if equal(val1, val2) {
-				return &ReturnValue{Type: ConstantType, Data: TrueValue}, nil
-			} else {
-				return &ReturnValue{Type: ConstantType, Data: FalseValue}, nil
-			}
+			return boolValue(equal(val1, val2)), nil
 		},
 	})
 
 	addBuiltinToEnv(env, ">", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
-			cmp, err := compareNumber(parameters, ">", evaluator, environment)
-			if err != nil {
-				return nil, err
-			}
-			if cmp > 0 {
-				return &ReturnValue{Type: ConstantType, Data: TrueValue}, nil
-			} else {
-				return &ReturnValue{Type: ConstantType, Data: FalseValue}, nil
-			}
+			return compareNumber(parameters, ">", func(cmp int) bool { return cmp > 0 })
 		},
 	})
 
 	addBuiltinToEnv(env, ">=", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
-			cmp, err := compareNumber(parameters, ">=", evaluator, environment)
-			if err != nil {
-				return nil, err
-			}
-			if cmp >= 0 {
-				return &ReturnValue{Type: ConstantType, Data: TrueValue}, nil
-			} else {
-				return &ReturnValue{Type: ConstantType, Data: FalseValue}, nil
-			}
+			return compareNumber(parameters, ">=", func(cmp int) bool { return cmp >= 0 })
 		},
 	})
 
 	addBuiltinToEnv(env, "<", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
-			cmp, err := compareNumber(parameters, "<", evaluator, environment)
-			if err != nil {
-				return nil, err
-			}
-			if cmp < 0 {
-				return &ReturnValue{Type: ConstantType, Data: TrueValue}, nil
-			} else {
-				return &ReturnValue{Type: ConstantType, Data: FalseValue}, nil
-			}
+			return compareNumber(parameters, "<", func(cmp int) bool { return cmp < 0 })
 		},
 	})
 
 	addBuiltinToEnv(env, "<=", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
-			cmp, err := compareNumber(parameters, "<=", evaluator, environment)
-			if err != nil {
-				return nil, err
-			}
-			if cmp <= 0 {
-				return &ReturnValue{Type: ConstantType, Data: TrueValue}, nil
-			} else {
-				return &ReturnValue{Type: ConstantType, Data: FalseValue}, nil
-			}
+			return compareNumber(parameters, "<=", func(cmp int) bool { return cmp <= 0 })
 		},
 	})
 
 	addBuiltinToEnv(env, "=", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
-			cmp, err := compareNumber(parameters, "=", evaluator, environment)
-			if err != nil {
-				return nil, err
-			}
-			if cmp == 0 {
-				return &ReturnValue{Type: ConstantType, Data: TrueValue}, nil
-			} else {
-				return &ReturnValue{Type: ConstantType, Data: FalseValue}, nil
-			}
+			return compareNumber(parameters, "=", func(cmp int) bool { return cmp == 0 })
 		},
 	})
 
 	addBuiltinToEnv(env, "and", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
-			res := &ReturnValue{Type: ConstantType, Data: TrueValue}
+			res := True
 			for _, parameter := range parameters {
 				if parameter.Type == ConstantType && parameter.Constant() == FalseValue {
-					return &ReturnValue{Type: ConstantType, Data: FalseValue}, nil
+					return False, nil
 				}
 				res = parameter
 			}
@@ -546,21 +545,18 @@ func initGlobalEnvironment(stdin io.Reader) *Environment {
 					return parameter, nil
 				}
 			}
-			return &ReturnValue{Type: ConstantType, Data: FalseValue}, nil
+			return False, nil
 		},
 	})
 
 	addBuiltinToEnv(env, "not", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
 			if len(parameters) != 1 {
-				return nil, fmt.Errorf("'cons' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+				return nil, conditionErrorf(WrongArityCondition, "'cons' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
 			}
 
 			val := parameters[0]
-			if val.Type == ConstantType && val.Constant() == FalseValue {
-				return &ReturnValue{Type: ConstantType, Data: TrueValue}, nil
-			}
-			return &ReturnValue{Type: ConstantType, Data: FalseValue}, nil
+			return boolValue(val.Type == ConstantType && val.Constant() == FalseValue), nil
 		},
 	})
 
@@ -569,66 +565,54 @@ func initGlobalEnvironment(stdin io.Reader) *Environment {
 	addBuiltinToEnv(env, "cons", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
 			if len(parameters) != 2 {
-				return nil, fmt.Errorf("'cons' has been called with %d arguments; it requires exactly 2 arguments", len(parameters))
-			}
-			car := parameters[0]
-			cdr := parameters[1]
-			if cdr.Type == ListType {
-				cdrList := cdr.List()
-				list := &ListValue{Elements: []*ReturnValue{car}}
-				if len(cdrList.Elements) == 0 {
-					return &ReturnValue{Type: ListType, Data: list}, nil
-				}
-
-				list.Elements = append(list.Elements, cdrList.Elements...)
-				return &ReturnValue{Type: ListType, Data: list}, nil
+				return nil, conditionErrorf(WrongArityCondition, "'cons' has been called with %d arguments; it requires exactly 2 arguments", len(parameters))
 			}
-
-			cons := &ConsValue{
-				Car: car,
-				Cdr: cdr,
-			}
-			return &ReturnValue{Type: ConsType, Data: cons}, nil
+			return evaluator.newValue(ConsType, &ConsValue{Car: parameters[0], Cdr: parameters[1]}), nil
 		},
 	})
 
 	addBuiltinToEnv(env, "list", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
-			list := &ListValue{Elements: parameters}
-			return &ReturnValue{Type: ListType, Data: list}, nil
+			return evaluator.newList(parameters)
 		},
 	})
 
 	addBuiltinToEnv(env, "length", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
 			if len(parameters) != 1 {
-				return nil, fmt.Errorf("'length' has been called with %d arguments; it requires exactly 1 arguments", len(parameters))
+				return nil, conditionErrorf(WrongArityCondition, "'length' has been called with %d arguments; it requires exactly 1 arguments", len(parameters))
 			}
 
 			parameter := parameters[0]
-			if parameter.Type != ListType {
-				return nil, fmt.Errorf("expected list value, got %s", parameter.Type)
+			elements, ok := listElements(parameter)
+			if !ok {
+				return nil, conditionErrorf(WrongTypeCondition, "expected list value, got %s", parameter.Type)
 			}
 
-			return &ReturnValue{Type: NumberType, Data: MakeInt64Number(int64(len(parameter.List().Elements)))}, nil
+			return &ReturnValue{Type: NumberType, Data: MakeInt64Number(int64(len(elements)))}, nil
 		},
 	})
 
 	addBuiltinToEnv(env, "append", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
 			if len(parameters) < 2 {
-				return nil, fmt.Errorf("`append` has been called with %d arguments; it requires at lesat 2 argument", len(parameters))
+				return nil, conditionErrorf(WrongArityCondition, "`append` has been called with %d arguments; it requires at lesat 2 argument", len(parameters))
 			}
+			// the pairs of every list but the last are copied, the result ends in the last argument
+			// itself, which needn't be a list
 			elements := make([]*ReturnValue, 0)
-			for _, parameter := range parameters {
-				if parameter.Type != ListType {
-					return nil, fmt.Errorf("expected list value, got %s", parameter.Type)
+			last := len(parameters) - 1
+			for _, parameter := range parameters[:last] {
+				list, ok := listElements(parameter)
+				if !ok {
+					return nil, conditionErrorf(WrongTypeCondition, "expected list value, got %s", parameter.Type)
 				}
-
-				elements = append(elements, parameter.List().Elements...)
+				elements = append(elements, list...)
 			}
-			list := &ListValue{Elements: elements}
-			return &ReturnValue{Type: ListType, Data: list}, nil
+			if err := evaluator.allocate(len(elements)); err != nil {
+				return nil, err
+			}
+			return makeDottedList(elements, parameters[last]), nil
 		},
 	})
 
@@ -648,59 +632,52 @@ func initGlobalEnvironment(stdin io.Reader) *Environment {
 	addBuiltinToEnv(env, "set-car!", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
 			if len(parameters) != 2 {
-				return nil, fmt.Errorf("'set-car!' has been called with %d arguments; it requires exactly 2 argument", len(parameters))
+				return nil, conditionErrorf(WrongArityCondition, "'set-car!' has been called with %d arguments; it requires exactly 2 argument", len(parameters))
 			}
 
 			carVal := parameters[1]
 
 			container := parameters[0]
+			if isQuotedLiteral(container) {
+				return nil, conditionErrorf(WrongTypeCondition, "'set-car!' cannot modify a quoted literal: %s", container.String())
+			}
 			switch container.Type {
 			case ConsType:
 				cons := container.Cons()
 				cons.Car = carVal
 			case ListType:
-				list := container.List()
-				if len(list.Elements) == 0 {
-					return nil, errors.New("cannot set-car! on an empty list")
-				}
-				list.Elements[0] = carVal
+				return nil, conditionErrorf(WrongTypeCondition, "cannot set-car! on an empty list")
 			default:
-				return nil, fmt.Errorf("first argument to 'set-car!' must be a cons cell or a non-empty list, got %T", container)
+				return nil, conditionErrorf(WrongTypeCondition, "first argument to 'set-car!' must be a cons cell or a non-empty list, got %T", container)
 			}
 
-			return &ReturnValue{Type: ConstantType, Data: VoidConst}, nil
+			return Void, nil
 		},
 	})
 
 	addBuiltinToEnv(env, "set-cdr!", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
 			if len(parameters) != 2 {
-				return nil, fmt.Errorf("'set-cdr!' has been called with %d arguments; it requires exactly 2 argument", len(parameters))
+				return nil, conditionErrorf(WrongArityCondition, "'set-cdr!' has been called with %d arguments; it requires exactly 2 argument", len(parameters))
 			}
 
 			cdrVal := parameters[1]
 
 			container := parameters[0]
+			if isQuotedLiteral(container) {
+				return nil, conditionErrorf(WrongTypeCondition, "'set-cdr!' cannot modify a quoted literal: %s", container.String())
+			}
 			switch container.Type {
 			case ConsType:
 				cons := container.Cons()
 				cons.Cdr = cdrVal
 			case ListType:
-				list := container.List()
-				if len(list.Elements) == 0 {
-					return nil, errors.New("cannot set-cdr! on an empty list")
-				}
-				cons := &ConsValue{
-					Car: list.Elements[0],
-					Cdr: cdrVal,
-				}
-				container.Type = ConsType
-				container.Data = cons
+				return nil, conditionErrorf(WrongTypeCondition, "cannot set-cdr! on an empty list")
 			default:
-				return nil, fmt.Errorf("first argument to 'set-cdr!' must be a cons cell or a non-empty list, got %T", container)
+				return nil, conditionErrorf(WrongTypeCondition, "first argument to 'set-cdr!' must be a cons cell or a non-empty list, got %T", container)
 			}
 
-			return &ReturnValue{Type: ConstantType, Data: VoidConst}, nil
+			return Void, nil
 		},
 	})
 
@@ -708,12 +685,12 @@ func initGlobalEnvironment(stdin io.Reader) *Environment {
 	addBuiltinToEnv(env, "stream-cdr", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
 			if len(parameters) != 1 {
-				return nil, fmt.Errorf("'stream-cdr' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+				return nil, conditionErrorf(WrongArityCondition, "'stream-cdr' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
 			}
 
 			val := parameters[0]
 			if val.Type != ConsType {
-				return nil, fmt.Errorf("first argument to 'stream-cdr' must be a cons , got %T", val.Type)
+				return nil, conditionErrorf(WrongTypeCondition, "first argument to 'stream-cdr' must be a cons , got %T", val.Type)
 			}
 
 			return force(val.Cons().Cdr, evaluator)
@@ -731,47 +708,65 @@ func initGlobalEnvironment(stdin io.Reader) *Environment {
 	addBuiltinToEnv(env, "display", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
 			if len(parameters) != 1 {
-				return nil, fmt.Errorf("'display' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+				return nil, conditionErrorf(WrongArityCondition, "'display' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
 			}
 
 			val := parameters[0]
 
 			if val.Type == StringType {
-				fmt.Print(val.StringValue())
+				fmt.Fprint(evaluator.stdout, val.StringValue())
+			} else if val.Type == CharType {
+				fmt.Fprint(evaluator.stdout, string(val.Char()))
 			} else {
-				fmt.Print(val.String())
+				fmt.Fprint(evaluator.stdout, val.String())
+			}
+
+			return Void, nil
+		},
+	})
+
+	// (pp value) prints value like write, indenting nested lists that don't fit on one line
+	addBuiltinToEnv(env, "pp", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'pp' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
 			}
 
-			return &ReturnValue{Type: ConstantType, Data: VoidConst}, nil
+			fmt.Fprintln(evaluator.stdout, parameters[0].PrettyString(defaultPrettyWidth))
+			evaluator.flushOutput()
+
+			return Void, nil
 		},
 	})
 
 	addBuiltinToEnv(env, "newline", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
 			if len(parameters) != 0 {
-				return nil, fmt.Errorf("'newline' has been called with %d arguments; it requires exactly 0 argument", len(parameters))
+				return nil, conditionErrorf(WrongArityCondition, "'newline' has been called with %d arguments; it requires exactly 0 argument", len(parameters))
 			}
 
-			fmt.Println()
+			fmt.Fprintln(evaluator.stdout)
+			evaluator.flushOutput()
 
-			return &ReturnValue{Type: ConstantType, Data: VoidConst}, nil
+			return Void, nil
 		},
 	})
 
 	addBuiltinToEnv(env, "print", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
 			if len(parameters) == 0 {
-				return nil, fmt.Errorf("'print' has been called with %d arguments; it requires at least 1 argument", len(parameters))
+				return nil, conditionErrorf(WrongArityCondition, "'print' has been called with %d arguments; it requires at least 1 argument", len(parameters))
 			}
 			for i, val := range parameters {
 				if i > 0 {
-					fmt.Print(" ")
+					fmt.Fprint(evaluator.stdout, " ")
 				}
-				fmt.Print(val.String())
+				fmt.Fprint(evaluator.stdout, val.String())
 			}
-			fmt.Println()
+			fmt.Fprintln(evaluator.stdout)
+			evaluator.flushOutput()
 
-			return &ReturnValue{Type: ConstantType, Data: VoidConst}, nil
+			return Void, nil
 		},
 	})
 
@@ -779,25 +774,26 @@ func initGlobalEnvironment(stdin io.Reader) *Environment {
 	addBuiltinToEnv(env, "apply", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
 			if len(parameters) < 2 {
-				return nil, fmt.Errorf("'apply' has been called with %d arguments; it requires at least 2 arguments", len(parameters))
+				return nil, conditionErrorf(WrongArityCondition, "'apply' has been called with %d arguments; it requires at least 2 arguments", len(parameters))
 			}
 			// TODO: actually I don't know the point of 3rd and later arguments, current implementation simply skip those arguments
 
 			proc := parameters[0]
 			list := parameters[1]
-			if list.Type != ListType {
-				return nil, fmt.Errorf("'apply' expect second argument to be list but got %s", list.Type)
+			operands, ok := listElements(list)
+			if !ok {
+				return nil, conditionErrorf(WrongTypeCondition, "'apply' expect second argument to be list but got %s", list.Type)
 			}
 
 			switch proc.Type {
 			case BuiltinFunctionType:
 				fn := proc.BuiltinFunction()
-				return evaluator.evalBuiltinFunction(fn, list.List().Elements, environment)
+				return evaluator.evalBuiltinFunction(fn, operands, environment)
 			case ProcedureType:
 				fn := proc.Procedure()
-				return evaluator.evalProcedure(fn, list.List().Elements, environment)
+				return evaluator.evalProcedure(fn, operands, environment)
 			default:
-				return nil, fmt.Errorf("'apply' expect first argument to be procedure/builtinFunction but got %s", list.Type)
+				return nil, conditionErrorf(WrongTypeCondition, "'apply' expect first argument to be procedure/builtinFunction but got %s", list.Type)
 			}
 		},
 	})
@@ -806,7 +802,7 @@ func initGlobalEnvironment(stdin io.Reader) *Environment {
 	addBuiltinToEnv(env, "map", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
 			if len(parameters) < 2 {
-				return nil, fmt.Errorf("'assoc' has been called with %d arguments; it requires at least 2 arguments", len(parameters))
+				return nil, conditionErrorf(WrongArityCondition, "'assoc' has been called with %d arguments; it requires at least 2 arguments", len(parameters))
 			}
 
 			proc := parameters[0]
@@ -814,23 +810,22 @@ func initGlobalEnvironment(stdin io.Reader) *Environment {
 			operandsList := make([][]*ReturnValue, 0)
 			for i := 1; i < len(parameters); i++ {
 				val := parameters[i]
-				if val.Type == ListType {
-					list := val.List()
+				if elements, ok := listElements(val); ok {
 					if i == 1 {
-						for _, element := range list.Elements {
+						for _, element := range elements {
 							operandsList = append(operandsList, []*ReturnValue{element})
 						}
 						continue
 					}
-					if len(list.Elements) != len(operandsList) {
-						return nil, fmt.Errorf("all lists must have same size")
+					if len(elements) != len(operandsList) {
+						return nil, conditionErrorf(WrongTypeCondition, "all lists must have same size")
 					}
 
-					for j, element := range list.Elements {
+					for j, element := range elements {
 						operandsList[j] = append(operandsList[j], element)
 					}
 				} else {
-					return nil, fmt.Errorf("expect parameter to be list but got %s", val.Type)
+					return nil, conditionErrorf(WrongTypeCondition, "expect parameter to be list but got %s", val.Type)
 				}
 			}
 
@@ -855,174 +850,301 @@ func initGlobalEnvironment(stdin io.Reader) *Environment {
 					res = append(res, ret)
 				}
 			default:
-				return nil, fmt.Errorf("unknown procedure type %s", proc.Type)
+				return nil, conditionErrorf(WrongTypeCondition, "unknown procedure type %s", proc.Type)
 			}
 
-			return &ReturnValue{Type: ListType, Data: &ListValue{Elements: res}}, nil
+			return evaluator.newList(res)
 		},
 	})
-	addBuiltinToEnv(env, "assoc", &BuiltinFunction{
+	addMemberBuiltin(env, "memq", eq)
+	addMemberBuiltin(env, "memv", eqv)
+	addMemberBuiltin(env, "member", equal)
+	addAssocBuiltin(env, "assq", eq)
+	addAssocBuiltin(env, "assv", eqv)
+	addAssocBuiltin(env, "assoc", equal)
+
+	addBuiltinToEnv(env, "error", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
-			if len(parameters) != 2 {
-				return nil, fmt.Errorf("'assoc' has been called with %d arguments; it requires exactly 2 arguments", len(parameters))
+			if len(parameters) < 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'error' has been called with %d arguments; it requires at least 1 argument", len(parameters))
 			}
-			key := parameters[0]
-			val := parameters[1]
 
-			if val.Type == ListType {
-				list := val.List()
-				for _, item := range list.Elements {
-					switch item.Type {
-					case ConsType:
-						pair := item.Cons()
-						if equal(pair.Car, key) {
-							return item, nil
-						}
-					case ListType:
-						pairList := item.List()
-						if len(pairList.Elements) == 0 {
-							return nil, fmt.Errorf("non-pair found in list")
-						}
-						if equal(pairList.Elements[0], key) {
-							return item, nil
-						}
-					default:
-						return nil, fmt.Errorf("non-pair found in list")
-					}
+			// the condition is the error object handlers see, with the message and irritants
+			condition := &Condition{Kind: GenericCondition, reason: parameters[0], irritants: append([]*ReturnValue(nil), parameters[1:]...)}
+			if evaluator.mit {
+				condition.Message = mitErrorMessage(parameters)
+			} else if len(parameters) == 1 {
+				condition.Message = fmt.Sprintf("failed to evaluate: %s", parameters[0].String())
+			} else {
+				condition.Message = fmt.Sprintf("failed to evaluate: %s, %s", parameters[0].String(), parameters[1].String())
+			}
+			return nil, condition
+		},
+	})
+
+	// https://groups.csail.mit.edu/mac/ftpdir/scheme-7.4/doc-html/scheme_5.html#SEC53
+	addBuiltinToEnv(env, "random", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			// TODO: implement random-state
+			if len(parameters) != 1 && len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'random' has been called with %d arguments; it requires 1 or 2 arguments", len(parameters))
+			}
+
+			for _, val := range parameters {
+				if val.Type != NumberType {
+					return nil, conditionErrorf(WrongTypeCondition, "expected number type, got %s", val.Type)
 				}
-			} else if val.Type == ConsType {
-				currentCons := val.Cons()
-				for {
-					switch currentCons.Car.Type {
-					case ConsType:
-						cons := currentCons.Car.Cons()
-						if equal(cons.Car, key) {
-							return currentCons.Car, nil
-						}
-					case ListType:
-						pairList := currentCons.Car.List()
-						if len(pairList.Elements) == 0 {
-							return nil, fmt.Errorf("non-pair found in list")
-						}
-						if equal(pairList.Elements[0], key) {
-							return currentCons.Car, nil
-						}
-					default:
-						return nil, fmt.Errorf("non-pair found in list, type is %s", currentCons.Car.Type)
+			}
+
+			if len(parameters) == 1 {
+				val := parameters[0]
+				if val.Number().isInt64() {
+					if val.Number().Int64() <= 0 {
+						return nil, conditionErrorf(WrongTypeCondition, "'random' expected a positive number, got %s", val.String())
 					}
-					if currentCons.Cdr.Type == ConsType {
-						currentCons = currentCons.Cdr.Cons()
-					} else {
-						break
+					res := evaluator.random.Int63n(val.Number().Int64())
+					return &ReturnValue{Type: NumberType, Data: MakeInt64Number(res)}, nil
+				}
+
+				res := evaluator.random.Float64() * val.Number().Float64()
+				return &ReturnValue{Type: NumberType, Data: MakeFloat64Number(res)}, nil
+			}
+
+			// (random low high) picks from [low, high)
+			low := parameters[0].Number()
+			high := parameters[1].Number()
+			if compareNumbers(low, high) >= 0 {
+				return nil, conditionErrorf(WrongTypeCondition, "'random' expected low to be less than high, got %s and %s", low.String(), high.String())
+			}
+			if low.isInt64() && high.isInt64() {
+				// high - low can be beyond an int64 but never beyond a uint64
+				span := uint64(high.Int64()) - uint64(low.Int64())
+				var offset uint64
+				if span <= math.MaxInt64 {
+					offset = uint64(evaluator.random.Int63n(int64(span)))
+				} else {
+					// more than half of the uint64s are below span
+					offset = evaluator.random.Uint64()
+					for offset >= span {
+						offset = evaluator.random.Uint64()
 					}
 				}
-			} else {
-				return nil, fmt.Errorf("expected list value, got %s", val.Type)
+				res := int64(uint64(low.Int64()) + offset)
+				return &ReturnValue{Type: NumberType, Data: MakeInt64Number(res)}, nil
 			}
 
-			return &ReturnValue{Type: ConstantType, Data: FalseValue}, nil
+			res := low.Float64() + evaluator.random.Float64()*(high.Float64()-low.Float64())
+			return &ReturnValue{Type: NumberType, Data: MakeFloat64Number(res)}, nil
 		},
 	})
 
-	addBuiltinToEnv(env, "error", &BuiltinFunction{
+	addBuiltinToEnv(env, "random-choice", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
-			if len(parameters) < 1 {
-				return nil, fmt.Errorf("'error' has been called with %d arguments; it requires at least 1 argument", len(parameters))
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'random-choice' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
 			}
 
 			val := parameters[0]
-			if len(parameters) == 1 {
-				return nil, fmt.Errorf("failed to evaluate: %s", val.String())
-
+			elements, ok := listElements(val)
+			if !ok {
+				return nil, conditionErrorf(WrongTypeCondition, "expected list value, got %s", val.Type)
 			}
-			val2 := parameters[1]
-			return nil, fmt.Errorf("failed to evaluate: %s, %s", val.String(), val2.String())
+			if len(elements) == 0 {
+				return nil, conditionErrorf(WrongTypeCondition, "cannot call 'random-choice' on an empty list")
+			}
+
+			return elements[evaluator.random.Intn(len(elements))], nil
 		},
 	})
 
-	r := rand.New(rand.NewSource(9527))
-	// https://groups.csail.mit.edu/mac/ftpdir/scheme-7.4/doc-html/scheme_5.html#SEC53
-	addBuiltinToEnv(env, "random", &BuiltinFunction{
+	addBuiltinToEnv(env, "shuffle", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
-			// TODO: implement random-state
 			if len(parameters) != 1 {
-				return nil, fmt.Errorf("'random' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+				return nil, conditionErrorf(WrongArityCondition, "'shuffle' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
 			}
 
 			val := parameters[0]
-			if val.Type != NumberType {
-				return nil, fmt.Errorf("expected number type, got %s", val.Type)
-			}
-
-			if val.Number().isInt64() {
-				res := r.Int63n(val.Number().Int64())
-				return &ReturnValue{Type: NumberType, Data: MakeInt64Number(res)}, nil
+			// shuffle the elements into new pairs, the argument list must stay untouched
+			elements, ok := listElements(val)
+			if !ok {
+				return nil, conditionErrorf(WrongTypeCondition, "expected list value, got %s", val.Type)
 			}
+			evaluator.random.Shuffle(len(elements), func(i, j int) {
+				elements[i], elements[j] = elements[j], elements[i]
+			})
 
-			res := r.Float64() * val.Number().Float64()
-			return &ReturnValue{Type: NumberType, Data: MakeFloat64Number(res)}, nil
+			return evaluator.newList(elements)
 		},
 	})
 
 	addBuiltinToEnv(env, "force", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
 			if len(parameters) != 1 {
-				return nil, fmt.Errorf("'force' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+				return nil, conditionErrorf(WrongArityCondition, "'force' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
 			}
 
 			return force(parameters[0], evaluator)
 		},
 	})
 
+	// (make-promise obj) returns a promise of obj already forced, obj itself if it's a promise
+	addBuiltinToEnv(env, "make-promise", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'make-promise' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+
+			if parameters[0].Type == PromiseType {
+				return parameters[0], nil
+			}
+			return &ReturnValue{Type: PromiseType, Data: &PromiseValue{EvaluatedValue: parameters[0]}}, nil
+		},
+	})
+
+	addBuiltinToEnv(env, "promise?", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'promise?' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+
+			return boolValue(parameters[0].Type == PromiseType), nil
+		},
+	})
+
 	//https: //docs.scheme.org/schintro/schintro_115.html#SEC135
 	addBuiltinToEnv(env, "read", &BuiltinFunction{
 		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
 			if len(parameters) != 0 {
-				return nil, fmt.Errorf("'read' has been called with %d arguments; it requires exactly 0 argument", len(parameters))
+				return nil, conditionErrorf(WrongArityCondition, "'read' has been called with %d arguments; it requires exactly 0 argument", len(parameters))
 			}
 
-			reader := bufio.NewReader(stdin)
+			// a prompt displayed before read is shown before waiting for input
+			evaluator.flushOutput()
+			reader := bufio.NewReader(evaluator.stdin)
 			return read(reader)
 		},
 	})
 
+	addBuiltinToEnv(env, "read-from-string", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'read-from-string' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+			if parameters[0].Type != StringType {
+				return nil, conditionErrorf(WrongTypeCondition, "'read-from-string' expected a string, got %s", parameters[0].Type)
+			}
+
+			datum, err := read(strings.NewReader(parameters[0].StringValue()))
+			if err != nil {
+				return nil, conditionErrorf(GenericCondition, "'read-from-string' failed to read %s: %s", parameters[0].String(), err)
+			}
+			return datum, nil
+		},
+	})
+
+	addBuiltinToEnv(env, "write-to-string", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'write-to-string' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+
+			return newString(parameters[0].Write()), nil
+		},
+	})
+
+	// (builtin 'name) returns the original builtin even if name has been shadowed
+	addBuiltinToEnv(env, "builtin", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'builtin' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+
+			var name string
+			switch parameters[0].Type {
+			case SymbolType:
+				name = parameters[0].Symbol()
+			case StringType:
+				name = parameters[0].StringValue()
+			default:
+				return nil, conditionErrorf(WrongTypeCondition, "'builtin' expected a symbol, got %s", parameters[0].Type)
+			}
+
+			val, ok := evaluator.baseEnv.Get(name)
+			if !ok {
+				return nil, conditionErrorf(GenericCondition, "'builtin' unknown builtin `%s`", name)
+			}
+			return val, nil
+		},
+	})
+
+	addStreamBuiltins(env)
+	addNumberBuiltins(env)
+	addStringBuiltins(env)
+	addVectorBuiltins(env)
+	addBytevectorBuiltins(env)
+	addHashTableBuiltins(env)
+	addConditionBuiltins(env)
+	addExceptionBuiltins(env)
+	addEvalBuiltins(env)
+	addParameterBuiltins(env)
+	addHTTPBuiltins(env)
+	addLoadBuiltins(env)
+	addAmbBuiltins(env)
+	addPictureBuiltins(env)
+	addGenericBuiltins(env)
+	addRuntimeBuiltins(env)
+	addGeneratorBuiltins(env)
+	addImageBuiltins(env)
+	addGoObjectBuiltins(env)
+
 	// Add more built-in functions as needed
+
+	// these only read their parameters, see BuiltinFunction.borrowsParameters
+	for _, name := range []string{
+		"+", "-", "*", "/", "remainder", "sqrt", "abs",
+		"number?", "string?", "symbol?", "pair?", "list?", "null?",
+		"eq?", "eqv?", "equal?", ">", ">=", "<", "<=", "=", "not", "cons", "list", "length", "append",
+		"memq", "memv", "member", "assq", "assv", "assoc",
+		"car", "cdr", "caar", "cadr", "cddr", "cdar", "caddr", "caadr", "cdadr", "cdddr", "cadddr",
+		"char?", "char->integer", "integer->char", "string-ref", "string-append",
+		"vector?", "vector-length", "vector-ref", "vector-set!",
+		"bytevector?", "bytevector-length", "bytevector-u8-ref", "bytevector-u8-set!",
+		"hash-table?", "hash-table-set!", "hash-table-ref", "hash-table-delete!", "hash-table-count",
+	} {
+		builtin, _ := env.lookup(name)
+		builtin.BuiltinFunction().borrowsParameters = true
+	}
+
 	return env
 }
 
-func readList(l *lexer.Lexer) (*ListValue, error) {
-	list := &ListValue{Elements: make([]*ReturnValue, 0)}
+func readList(l *lexer.Lexer) (*ReturnValue, error) {
+	elements := make([]*ReturnValue, 0)
 	for {
 		tok := l.NextToken()
-		if tok.TokenType == lexer.TokenTypeRightParen {
-			return list, nil
-		} else if tok.TokenType == lexer.TokenTypeLeftParen {
-			subList, err := readList(l)
+		switch tok.TokenType {
+		case lexer.TokenTypeRightParen:
+			return makeList(elements), nil
+		case lexer.TokenTypeEOF:
+			return nil, fmt.Errorf("unexpected end of input while reading a list")
+		case lexer.TokenTypeDot:
+			if len(elements) == 0 {
+				return nil, fmt.Errorf("unexpected '.' at line %d", tok.Line)
+			}
+			tail, err := doRead(l)
 			if err != nil {
 				return nil, err
 			}
-			list.Elements = append(list.Elements, &ReturnValue{Type: ListType, Data: subList})
-		} else if tok.TokenType == lexer.TokenTypeNumber {
-			num, err := MakeNumber(tok.Content)
-			if err != nil {
-				return nil, err
+			if closing := l.NextToken(); closing.TokenType != lexer.TokenTypeRightParen {
+				return nil, fmt.Errorf("expected ')' after dotted pair tail at line %d", closing.Line)
 			}
-			list.Elements = append(list.Elements, num)
-		} else if tok.TokenType == lexer.TokenTypeEOF || tok.TokenType == lexer.TokenTypeInvalid {
-			panic("unreachable")
-		} else if tok.TokenType == lexer.TokenTypeQuote {
-			// how to handle this case?
-			head := &ReturnValue{Type: SymbolType, Data: "quote"}
-			inner, err := doRead(l)
+			return makeDottedList(elements, tail), nil
+		default:
+			element, err := readDatum(tok, l)
 			if err != nil {
 				return nil, err
 			}
-
-			element := &ReturnValue{Type: ListType, Data: &ListValue{Elements: []*ReturnValue{head, inner}}}
-			list.Elements = append(list.Elements, element)
-		} else {
-			sym := &ReturnValue{Type: SymbolType, Data: tok.Content}
-			list.Elements = append(list.Elements, sym)
+			elements = append(elements, element)
 		}
 	}
 }
@@ -1033,26 +1155,55 @@ func read(reader io.Reader) (*ReturnValue, error) {
 }
 
 func doRead(l *lexer.Lexer) (*ReturnValue, error) {
-	firstToken := l.NextToken()
-	if firstToken.TokenType == lexer.TokenTypeRightParen {
+	return readDatum(l.NextToken(), l)
+}
+
+// readDatum reads the datum starting with tok.
+func readDatum(tok lexer.Token, l *lexer.Lexer) (*ReturnValue, error) {
+	switch tok.TokenType {
+	case lexer.TokenTypeRightParen:
 		return nil, fmt.Errorf("unexpected ')'")
-	} else if firstToken.TokenType == lexer.TokenTypeLeftParen {
+	case lexer.TokenTypeDot:
+		return nil, fmt.Errorf("unexpected '.'")
+	case lexer.TokenTypeEOF:
+		return nil, fmt.Errorf("unexpected end of input")
+	case lexer.TokenTypeInvalid:
+		return nil, fmt.Errorf("%s", tok.Content)
+	case lexer.TokenTypeLeftParen:
+		return readList(l)
+	case lexer.TokenTypeVectorPrefix:
+		l.NextToken()
 		list, err := readList(l)
 		if err != nil {
 			return nil, err
 		}
-		return &ReturnValue{Type: ListType, Data: list}, nil
-	} else if firstToken.TokenType == lexer.TokenTypeNumber {
-		return MakeNumber(firstToken.Content)
-	} else if firstToken.TokenType == lexer.TokenTypeQuote {
+		elements, ok := listElements(list)
+		if !ok {
+			return nil, fmt.Errorf("unexpected '.' in a vector at line %d", tok.Line)
+		}
+		return &ReturnValue{Type: VectorType, Data: &VectorValue{Elements: elements}}, nil
+	case lexer.TokenTypeNumber:
+		return MakeNumber(tok.Content)
+	case lexer.TokenTypeString:
+		return newString(tok.Content), nil
+	case lexer.TokenTypeChar:
+		c, err := lexer.ParseChar(tok.Content)
+		if err != nil {
+			return nil, err
+		}
+		return &ReturnValue{Type: CharType, Data: c}, nil
+	case lexer.TokenTypeTrue:
+		return True, nil
+	case lexer.TokenTypeFalse:
+		return False, nil
+	case lexer.TokenTypeQuote:
 		head := &ReturnValue{Type: SymbolType, Data: "quote"}
 		tail, err := doRead(l)
 		if err != nil {
 			return nil, err
 		}
-		list := &ListValue{Elements: []*ReturnValue{head, tail}}
-		return &ReturnValue{Type: ListType, Data: list}, nil
-	} else {
-		return &ReturnValue{Type: SymbolType, Data: firstToken.Content}, nil
+		return makeList([]*ReturnValue{head, tail}), nil
+	default:
+		return &ReturnValue{Type: SymbolType, Data: tok.Content}, nil
 	}
 }
@@ -0,0 +1,258 @@
+package evaluator
+
+import (
+	"errors"
+	"runtime"
+	"slices"
+)
+
+// A generator runs its procedure on a goroutine that takes turns with the consumer: next hands control
+// to the goroutine and blocks until the procedure yields or returns, so only one of them touches the
+// evaluator at a time. Each turn the procedure sees the handlers and parameters of the caller of next
+// below those it installed itself. Once a generator that hasn't finished is garbage, the evaluator
+// closes it on the next call to next or the next top-level run: its procedure is resumed with an
+// error unwinding it that no handler can catch, and its goroutine exits.
+//
+//	(define g (make-generator (lambda (yield) (yield 1) (yield 2))))
+//	(next g)     ; 1
+//	(next g)     ; 2
+//	(next g 'end) ; end
+
+// errGeneratorClosed unwinds the procedure of a generator being closed.
+var errGeneratorClosed = errors.New("generator closed")
+
+type generatorResult struct {
+	value *ReturnValue
+	err   error
+	done  bool
+}
+
+// GeneratorValue is what generator values hold, the state its goroutine uses is apart from it so
+// it can become garbage while the goroutine waits.
+type GeneratorValue struct {
+	state *generatorState
+}
+
+type generatorState struct {
+	proc        *ReturnValue
+	environment *Environment
+	started     bool
+	done        bool
+	// running is true while the generator's goroutine has control, yield is only valid then
+	running bool
+	// closing is set once the generator is garbage, yield then unwinds the procedure
+	closing bool
+	resume  chan struct{}
+	results chan generatorResult
+	// operands is the generator's own operand stack, and depth and segmentDepth how many calls are
+	// nested on its goroutine, swapped into the evaluator while it runs
+	operands     []*ReturnValue
+	depth        int
+	segmentDepth int
+	// handlers and parameterizations are those the procedure installed, the consumer's are kept
+	// below them in the evaluator's handlerBase and parameterBase
+	handlers          []*handler
+	parameterizations []parameterization
+}
+
+func (rv *ReturnValue) Generator() *GeneratorValue {
+	if rv.Type != GeneratorType {
+		panic("not a generator")
+	}
+	if g, ok := rv.Data.(*GeneratorValue); ok {
+		return g
+	}
+	panic("invalid generator")
+}
+
+func (g *GeneratorValue) start(evaluator *Evaluator) {
+	s := g.state
+	s.started = true
+	yield := &ReturnValue{Type: BuiltinFunctionType, Data: &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'yield' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+			if !s.running {
+				return nil, conditionErrorf(GenericCondition, "'yield' can only be called while its generator is running")
+			}
+			if s.closing {
+				return nil, errGeneratorClosed
+			}
+
+			s.results <- generatorResult{value: parameters[0]}
+			<-s.resume
+			if s.closing {
+				return nil, errGeneratorClosed
+			}
+			return Void, nil
+		},
+	}}
+
+	go func() {
+		<-s.resume
+		_, err := evaluator.applyProcedure(s.proc, []*ReturnValue{yield}, s.environment)
+		s.results <- generatorResult{err: err, done: true}
+	}()
+	// the goroutine only refers to s, so g can become garbage while it waits
+	runtime.AddCleanup(g, evaluator.dropGenerator, s)
+}
+
+// next runs the generator until it yields, ok is false once the procedure has returned.
+func (g *GeneratorValue) next(evaluator *Evaluator) (value *ReturnValue, ok bool, err error) {
+	evaluator.closeDroppedGenerators()
+	s := g.state
+	if s.done {
+		return nil, false, nil
+	}
+	if !s.started {
+		g.start(evaluator)
+	}
+
+	result := s.turn(evaluator)
+	if result.err != nil || result.done {
+		s.done = true
+		return nil, false, result.err
+	}
+	return result.value, true, nil
+}
+
+// turn hands control to the goroutine of s until the procedure yields or returns, with the
+// operands and depth of the generator swapped into the evaluator and the handlers and parameters of
+// the consumer below the generator's own.
+func (s *generatorState) turn(evaluator *Evaluator) generatorResult {
+	consumerOperands, consumerDepth, consumerSegmentDepth := evaluator.operands, evaluator.depth, evaluator.segmentDepth
+	consumerHandlers, consumerHandlerBase := evaluator.handlers, evaluator.handlerBase
+	consumerParameterizations, consumerParameterBase := evaluator.parameterizations, evaluator.parameterBase
+	evaluator.operands, evaluator.depth, evaluator.segmentDepth = s.operands, s.depth, s.segmentDepth
+	evaluator.handlers, evaluator.handlerBase = s.handlers, slices.Concat(consumerHandlerBase, consumerHandlers)
+	evaluator.parameterizations, evaluator.parameterBase = s.parameterizations, slices.Concat(consumerParameterBase, consumerParameterizations)
+
+	s.running = true
+	s.resume <- struct{}{}
+	result := <-s.results
+	s.running = false
+
+	s.operands, s.depth, s.segmentDepth = evaluator.operands, evaluator.depth, evaluator.segmentDepth
+	s.handlers, s.parameterizations = evaluator.handlers, evaluator.parameterizations
+	evaluator.operands, evaluator.depth, evaluator.segmentDepth = consumerOperands, consumerDepth, consumerSegmentDepth
+	evaluator.handlers, evaluator.handlerBase = consumerHandlers, consumerHandlerBase
+	evaluator.parameterizations, evaluator.parameterBase = consumerParameterizations, consumerParameterBase
+	return result
+}
+
+// dropGenerator is the cleanup of a generator that became garbage, it runs on a goroutine of its own
+// while the evaluator may be running, so it only queues s for closeDroppedGenerators.
+func (e *Evaluator) dropGenerator(s *generatorState) {
+	e.droppedGenerators.Lock()
+	defer e.droppedGenerators.Unlock()
+	e.droppedGenerators.states = append(e.droppedGenerators.states, s)
+}
+
+// closeDroppedGenerators unwinds the procedures of the generators that became garbage before they
+// finished, which ends their goroutines.
+func (e *Evaluator) closeDroppedGenerators() {
+	e.droppedGenerators.Lock()
+	states := e.droppedGenerators.states
+	e.droppedGenerators.states = nil
+	e.droppedGenerators.Unlock()
+	for _, s := range states {
+		if s.done {
+			continue
+		}
+		s.closing = true
+		s.done = true
+		for result := s.turn(e); !result.done; result = s.turn(e) {
+		}
+	}
+}
+
+func addGeneratorBuiltins(env *Environment) {
+	addBuiltinToEnv(env, "make-generator", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'make-generator' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+			proc := parameters[0]
+			if proc.Type != ProcedureType && proc.Type != BuiltinFunctionType {
+				return nil, conditionErrorf(WrongTypeCondition, "'make-generator' expected a procedure, got %s", proc.Type)
+			}
+
+			g := &GeneratorValue{state: &generatorState{
+				proc:        proc,
+				environment: environment,
+				resume:      make(chan struct{}),
+				results:     make(chan generatorResult),
+			}}
+			return &ReturnValue{Type: GeneratorType, Data: g}, nil
+		},
+	})
+
+	// (next g) raises an index-out-of-range condition once g is exhausted, (next g default) returns default instead
+	addBuiltinToEnv(env, "next", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 && len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'next' has been called with %d arguments; it requires 1 or 2 arguments", len(parameters))
+			}
+			if parameters[0].Type != GeneratorType {
+				return nil, conditionErrorf(WrongTypeCondition, "'next' expected a generator, got %s", parameters[0].Type)
+			}
+
+			value, ok, err := parameters[0].Generator().next(evaluator)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				if len(parameters) == 2 {
+					return parameters[1], nil
+				}
+				return nil, conditionErrorf(IndexOutOfRangeCondition, "'next' called on an exhausted generator")
+			}
+			return value, nil
+		},
+	})
+
+	addBuiltinToEnv(env, "generator?", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'generator?' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+			return boolValue(parameters[0].Type == GeneratorType), nil
+		},
+	})
+
+	// (generator->list g) collects every remaining value, (generator->list g n) at most n of them
+	addBuiltinToEnv(env, "generator->list", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 && len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'generator->list' has been called with %d arguments; it requires 1 or 2 arguments", len(parameters))
+			}
+			if parameters[0].Type != GeneratorType {
+				return nil, conditionErrorf(WrongTypeCondition, "'generator->list' expected a generator, got %s", parameters[0].Type)
+			}
+			limit := int64(-1)
+			if len(parameters) == 2 {
+				n := parameters[1]
+				if n.Type != NumberType || !n.Number().isInt64() || n.Number().Int64() < 0 {
+					return nil, conditionErrorf(WrongTypeCondition, "'generator->list' expected a non-negative integer count, got %s", n.String())
+				}
+				limit = n.Number().Int64()
+			}
+
+			g := parameters[0].Generator()
+			elements := make([]*ReturnValue, 0)
+			for limit != 0 {
+				value, ok, err := g.next(evaluator)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					break
+				}
+				elements = append(elements, value)
+				limit--
+			}
+			return evaluator.newList(elements)
+		},
+	})
+}
@@ -0,0 +1,99 @@
+package evaluator
+
+// Data-directed programming support for the generic arithmetic of SICP 2.4 and 2.5,
+// https://sarabander.github.io/sicp/html/2_002e4.xhtml#g_t2_002e4_002e3
+// apply-generic is defined in prelude.scm on top of these.
+
+// schemeNumberTag is the type tag of plain soup numbers, they are used untagged as in exercise 2.78.
+const schemeNumberTag = "scheme-number"
+
+// operationTable maps (op type) keys, compared as written data, to the items put into it.
+type operationTable map[string]*ReturnValue
+
+func operationKey(op *ReturnValue, typ *ReturnValue) string {
+	return op.Write() + " " + typ.Write()
+}
+
+func addOperationTableBuiltins(env *Environment, putName string, getName string, table func(*Evaluator) operationTable) {
+	addBuiltinToEnv(env, putName, &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 3 {
+				return nil, conditionErrorf(WrongArityCondition, "'%s' has been called with %d arguments; it requires exactly 3 arguments", putName, len(parameters))
+			}
+
+			table(evaluator)[operationKey(parameters[0], parameters[1])] = parameters[2]
+			return &ReturnValue{Type: SymbolType, Data: "ok"}, nil
+		},
+	})
+
+	// get returns false when nothing has been put under the key
+	addBuiltinToEnv(env, getName, &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'%s' has been called with %d arguments; it requires exactly 2 arguments", getName, len(parameters))
+			}
+
+			if item, ok := table(evaluator)[operationKey(parameters[0], parameters[1])]; ok {
+				return item, nil
+			}
+			return False, nil
+		},
+	})
+}
+
+func addGenericBuiltins(env *Environment) {
+	addOperationTableBuiltins(env, "put", "get", func(e *Evaluator) operationTable {
+		return e.operations
+	})
+	addOperationTableBuiltins(env, "put-coercion", "get-coercion", func(e *Evaluator) operationTable {
+		return e.coercions
+	})
+
+	addBuiltinToEnv(env, "attach-tag", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'attach-tag' has been called with %d arguments; it requires exactly 2 arguments", len(parameters))
+			}
+
+			tag, contents := parameters[0], parameters[1]
+			if contents.Type == NumberType && tag.Type == SymbolType && tag.Symbol() == schemeNumberTag {
+				return contents, nil
+			}
+			return cons(tag, contents), nil
+		},
+	})
+
+	addBuiltinToEnv(env, "type-tag", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'type-tag' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+
+			datum := parameters[0]
+			if datum.Type == NumberType {
+				return &ReturnValue{Type: SymbolType, Data: schemeNumberTag}, nil
+			}
+			if datum.Type == ConsType {
+				return getCar(datum)
+			}
+			return nil, conditionErrorf(WrongTypeCondition, "'type-tag' bad tagged datum %s", datum.String())
+		},
+	})
+
+	addBuiltinToEnv(env, "contents", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'contents' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+
+			datum := parameters[0]
+			if datum.Type == NumberType {
+				return datum, nil
+			}
+			if datum.Type == ConsType {
+				return getCdr(datum)
+			}
+			return nil, conditionErrorf(WrongTypeCondition, "'contents' bad tagged datum %s", datum.String())
+		},
+	})
+}
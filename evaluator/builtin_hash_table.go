@@ -0,0 +1,272 @@
+package evaluator
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/ocowchun/soup/lexer"
+)
+
+// A hash table maps keys to values, comparing keys with equal? or eqv?, whichever it was made with:
+//
+//	(define t (make-hash-table))       ; keyed by equal?
+//	(hash-table-set! t '(1 2) 'a)
+//	(hash-table-ref t (list 1 2))      ; a
+//	(hash-table-ref t 'missing 0)      ; 0
+//
+// The keys are hashed to a string that's the same for every key the table's comparison finds equal,
+// the keys of a bucket are then compared with it. A key modified while it's in an equal? table is
+// hashed as it was, so it's only found again once it's back to that. A key that contains itself
+// can't be hashed by equal?, an equal? table refuses it.
+
+type hashTableEntry struct {
+	key   *ReturnValue
+	value *ReturnValue
+}
+
+// hashKey returns the hash of key, which is the same for keys that are equal? when equal is set,
+// eqv? otherwise. It reports false when key contains itself and equal is set.
+func hashKey(key *ReturnValue, equal bool) (string, bool) {
+	var b strings.Builder
+	if !writeHashKey(&b, key, equal, make(map[any]bool)) {
+		return "", false
+	}
+	return b.String(), true
+}
+
+// writeHashKey writes the hash of key to b, visiting holds the pairs and vectors key is inside of.
+func writeHashKey(b *strings.Builder, key *ReturnValue, equal bool, visiting map[any]bool) bool {
+	switch key.Type {
+	case NumberType:
+		n := key.Number()
+		if n.isExact() {
+			b.WriteString(n.String())
+			return true
+		}
+		// 0. and -0. share a bucket, which one a float is equal to is up to the comparison
+		b.WriteString(strconv.FormatFloat(math.Abs(n.Float64()), 'g', -1, 64))
+		b.WriteString("f")
+	case SymbolType:
+		b.WriteString("'")
+		b.WriteString(key.Symbol())
+	case ConstantType:
+		b.WriteString(key.Constant().String())
+	case CharType:
+		b.WriteString(lexer.CharString(key.Char()))
+	case StringType:
+		if !equal {
+			// a string is only eqv? to itself
+			fmt.Fprintf(b, "%p", stringObject(key))
+			return true
+		}
+		b.WriteString(strconv.Quote(key.StringValue()))
+	case ListType:
+		b.WriteString("()")
+	case ConsType:
+		if !equal {
+			fmt.Fprintf(b, "%p", key.Cons())
+			return true
+		}
+		pair := key.Cons()
+		if visiting[pair] {
+			return false
+		}
+		visiting[pair] = true
+		b.WriteString("(")
+		if !writeHashKey(b, pair.Car, equal, visiting) {
+			return false
+		}
+		b.WriteString(" . ")
+		if !writeHashKey(b, pair.Cdr, equal, visiting) {
+			return false
+		}
+		b.WriteString(")")
+		delete(visiting, pair)
+	case VectorType:
+		if !equal {
+			fmt.Fprintf(b, "%p", key.Vector())
+			return true
+		}
+		vector := key.Vector()
+		if visiting[vector] {
+			return false
+		}
+		visiting[vector] = true
+		b.WriteString("#(")
+		for _, element := range vector.Elements {
+			if !writeHashKey(b, element, equal, visiting) {
+				return false
+			}
+			b.WriteString(" ")
+		}
+		b.WriteString(")")
+		delete(visiting, vector)
+	case BytevectorType:
+		if !equal {
+			fmt.Fprintf(b, "%p", key.Bytevector())
+			return true
+		}
+		fmt.Fprintf(b, "#u8%x", key.Bytevector().Bytes)
+	default:
+		// the other values share a bucket for their type
+		b.WriteString(key.Type.String())
+	}
+	return true
+}
+
+// lookup returns the entry of key, nil if there's none, and the hash of key, for the builtin name.
+func (t *HashTableValue) lookup(key *ReturnValue, name string) (*hashTableEntry, string, error) {
+	hash, ok := hashKey(key, t.equal)
+	if !ok {
+		return nil, "", conditionErrorf(WrongTypeCondition, "'%s' can't hash a key that contains itself", name)
+	}
+	for _, entry := range t.buckets[hash] {
+		if t.equal && equal(entry.key, key) || !t.equal && eqv(entry.key, key) {
+			return entry, hash, nil
+		}
+	}
+	return nil, hash, nil
+}
+
+func (t *HashTableValue) delete(key *ReturnValue, name string) error {
+	entry, hash, err := t.lookup(key, name)
+	if entry == nil {
+		return err
+	}
+	bucket := t.buckets[hash]
+	for i := range bucket {
+		if bucket[i] == entry {
+			bucket = append(bucket[:i], bucket[i+1:]...)
+			break
+		}
+	}
+	if len(bucket) == 0 {
+		delete(t.buckets, hash)
+	} else {
+		t.buckets[hash] = bucket
+	}
+	t.count--
+	return nil
+}
+
+// hashTableArgument returns the hash table parameters[i] of the builtin name.
+func hashTableArgument(parameters []*ReturnValue, i int, name string) (*HashTableValue, error) {
+	if parameters[i].Type != HashTableType {
+		return nil, conditionErrorf(WrongTypeCondition, "'%s' expected a hash table, got %s", name, parameters[i].Type)
+	}
+	return parameters[i].HashTable(), nil
+}
+
+func addHashTableBuiltins(env *Environment) {
+	// (make-hash-table [equal?|eqv?]) returns an empty table keyed by equal? unless eqv? is given
+	addBuiltinToEnv(env, "make-hash-table", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) > 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'make-hash-table' has been called with %d arguments; it requires 0 or 1 arguments", len(parameters))
+			}
+			table := &HashTableValue{equal: true, buckets: make(map[string][]*hashTableEntry)}
+			if len(parameters) == 1 {
+				equalFn, _ := evaluator.baseEnv.Get("equal?")
+				eqvFn, _ := evaluator.baseEnv.Get("eqv?")
+				switch {
+				case parameters[0].Type == BuiltinFunctionType && parameters[0].Data == equalFn.Data:
+				case parameters[0].Type == BuiltinFunctionType && parameters[0].Data == eqvFn.Data:
+					table.equal = false
+				default:
+					return nil, conditionErrorf(WrongTypeCondition, "'make-hash-table' expected equal? or eqv?, got %s", parameters[0])
+				}
+			}
+			return &ReturnValue{Type: HashTableType, Data: table}, nil
+		},
+	})
+
+	addBuiltinToEnv(env, "hash-table?", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'hash-table?' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+			return boolValue(parameters[0].Type == HashTableType), nil
+		},
+	})
+
+	addBuiltinToEnv(env, "hash-table-set!", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 3 {
+				return nil, conditionErrorf(WrongArityCondition, "'hash-table-set!' has been called with %d arguments; it requires exactly 3 arguments", len(parameters))
+			}
+			table, err := hashTableArgument(parameters, 0, "hash-table-set!")
+			if err != nil {
+				return nil, err
+			}
+			entry, hash, err := table.lookup(parameters[1], "hash-table-set!")
+			if err != nil {
+				return nil, err
+			}
+			if entry != nil {
+				entry.value = parameters[2]
+				return Void, nil
+			}
+			if err := evaluator.allocate(1); err != nil {
+				return nil, err
+			}
+			table.buckets[hash] = append(table.buckets[hash], &hashTableEntry{key: parameters[1], value: parameters[2]})
+			table.count++
+			return Void, nil
+		},
+	})
+
+	// (hash-table-ref table key [default]) returns the value of key, default when there's none
+	addBuiltinToEnv(env, "hash-table-ref", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 2 && len(parameters) != 3 {
+				return nil, conditionErrorf(WrongArityCondition, "'hash-table-ref' has been called with %d arguments; it requires 2 or 3 arguments", len(parameters))
+			}
+			table, err := hashTableArgument(parameters, 0, "hash-table-ref")
+			if err != nil {
+				return nil, err
+			}
+			entry, _, err := table.lookup(parameters[1], "hash-table-ref")
+			if err != nil {
+				return nil, err
+			}
+			if entry != nil {
+				return entry.value, nil
+			}
+			if len(parameters) == 3 {
+				return parameters[2], nil
+			}
+			return nil, conditionErrorf(GenericCondition, "'hash-table-ref' found no value for the key %s", parameters[1])
+		},
+	})
+
+	addBuiltinToEnv(env, "hash-table-delete!", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'hash-table-delete!' has been called with %d arguments; it requires exactly 2 arguments", len(parameters))
+			}
+			table, err := hashTableArgument(parameters, 0, "hash-table-delete!")
+			if err != nil {
+				return nil, err
+			}
+			if err := table.delete(parameters[1], "hash-table-delete!"); err != nil {
+				return nil, err
+			}
+			return Void, nil
+		},
+	})
+
+	addBuiltinToEnv(env, "hash-table-count", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'hash-table-count' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+			table, err := hashTableArgument(parameters, 0, "hash-table-count")
+			if err != nil {
+				return nil, err
+			}
+			return evaluator.newNumber(MakeInt64Number(int64(table.count))), nil
+		},
+	})
+}
@@ -0,0 +1,160 @@
+package evaluator
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// MaxHTTPBodySize is the largest response body http-get and http-post read, 10MB. A larger one is an
+// error rather than a body cut short.
+const MaxHTTPBodySize = 10 << 20
+
+// httpHeaders converts an association list like (("accept" . "text/plain")) to request headers.
+func httpHeaders(val *ReturnValue, procedureName string) (http.Header, error) {
+	items, ok := listElements(val)
+	if !ok {
+		return nil, conditionErrorf(WrongTypeCondition, "'%s' expected headers to be an association list, got %s", procedureName, val.Type)
+	}
+
+	header := http.Header{}
+	for _, item := range items {
+		if item.Type != ConsType {
+			return nil, conditionErrorf(WrongTypeCondition, "'%s' expected header to be a pair, got %s", procedureName, item.String())
+		}
+		pair := item.Cons()
+		name, err := httpHeaderText(pair.Car, procedureName)
+		if err != nil {
+			return nil, err
+		}
+		value, err := httpHeaderText(pair.Cdr, procedureName)
+		if err != nil {
+			return nil, err
+		}
+		header.Add(name, value)
+	}
+	return header, nil
+}
+
+func httpHeaderText(val *ReturnValue, procedureName string) (string, error) {
+	switch val.Type {
+	case StringType:
+		return val.StringValue(), nil
+	case SymbolType:
+		return val.Symbol(), nil
+	default:
+		return "", conditionErrorf(WrongTypeCondition, "'%s' expected header name and value to be string or symbol, got %s", procedureName, val.Type)
+	}
+}
+
+// httpResponse converts resp to ((status . 200) (headers ("content-type" . "text/plain") ...) (body . "..."))
+func httpResponse(resp *http.Response) (*ReturnValue, error) {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MaxHTTPBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > MaxHTTPBodySize {
+		return nil, fmt.Errorf("the body is larger than %d bytes", MaxHTTPBodySize)
+	}
+
+	names := make([]string, 0, len(resp.Header))
+	for name := range resp.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	headers := make([]*ReturnValue, 0, len(names))
+	for _, name := range names {
+		key := newString(strings.ToLower(name))
+		value := newString(strings.Join(resp.Header.Values(name), ", "))
+		headers = append(headers, cons(key, value))
+	}
+
+	status := &ReturnValue{Type: NumberType, Data: MakeInt64Number(int64(resp.StatusCode))}
+	elements := []*ReturnValue{
+		cons(&ReturnValue{Type: SymbolType, Data: "status"}, status),
+		cons(&ReturnValue{Type: SymbolType, Data: "headers"}, makeList(headers)),
+		cons(&ReturnValue{Type: SymbolType, Data: "body"}, newString(string(body))),
+	}
+	return makeList(elements), nil
+}
+
+func doHTTPRequest(method string, url *ReturnValue, body *ReturnValue, headers *ReturnValue, procedureName string) (*ReturnValue, error) {
+	if url.Type != StringType {
+		return nil, conditionErrorf(WrongTypeCondition, "'%s' expected url to be a string, got %s", procedureName, url.Type)
+	}
+
+	var bodyReader io.Reader
+	if body != nil {
+		if body.Type != StringType {
+			return nil, conditionErrorf(WrongTypeCondition, "'%s' expected body to be a string, got %s", procedureName, body.Type)
+		}
+		bodyReader = strings.NewReader(body.StringValue())
+	}
+
+	req, err := http.NewRequest(method, url.StringValue(), bodyReader)
+	if err != nil {
+		return nil, conditionErrorf(GenericCondition, "'%s' failed to create request: %s", procedureName, err)
+	}
+	if headers != nil {
+		req.Header, err = httpHeaders(headers, procedureName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, conditionErrorf(GenericCondition, "'%s' request failed: %s", procedureName, err)
+	}
+	defer resp.Body.Close()
+
+	ret, err := httpResponse(resp)
+	if err != nil {
+		return nil, conditionErrorf(GenericCondition, "'%s' failed to read response: %s", procedureName, err)
+	}
+	return ret, nil
+}
+
+func addHTTPBuiltins(env *Environment) {
+	// (http-get url) or (http-get url headers)
+	addBuiltinToEnv(env, "http-get", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 && len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'http-get' has been called with %d arguments; it requires 1 or 2 arguments", len(parameters))
+			}
+			if err := evaluator.requireCapability(CapabilityNetwork, "http-get"); err != nil {
+				return nil, err
+			}
+
+			var headers *ReturnValue
+			if len(parameters) == 2 {
+				headers = parameters[1]
+			}
+			return doHTTPRequest(http.MethodGet, parameters[0], nil, headers, "http-get")
+		},
+	})
+
+	// (http-post url body) or (http-post url body headers)
+	addBuiltinToEnv(env, "http-post", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 2 && len(parameters) != 3 {
+				return nil, conditionErrorf(WrongArityCondition, "'http-post' has been called with %d arguments; it requires 2 or 3 arguments", len(parameters))
+			}
+			if err := evaluator.requireCapability(CapabilityNetwork, "http-post"); err != nil {
+				return nil, err
+			}
+
+			var headers *ReturnValue
+			if len(parameters) == 3 {
+				headers = parameters[2]
+			}
+			return doHTTPRequest(http.MethodPost, parameters[0], parameters[1], headers, "http-post")
+		},
+	})
+}
@@ -0,0 +1,512 @@
+package evaluator
+
+import (
+	"math"
+	"math/big"
+)
+
+// Numbers are exact integers, int64 or *big.Int beyond it, exact rationals, *big.Rat, or inexact
+// floats, float64.
+// Arithmetic on exact numbers is exact, so (+ 1 2) is the integer 3 and (/ 1 3) the rational 1/3,
+// and it's inexact as soon as one of its operands is, so (+ 1 2.) is 3.: the float is contagious.
+// A rational is only ever a fraction, one that's an integer is an integer, and an integer is a
+// *big.Int only when it doesn't fit in an int64: the sum of two large int64s is exact, not a float.
+
+// addNumbers returns x + y, exact when both are.
+func addNumbers(x Number, y Number) Number {
+	if x.isInt64() && y.isInt64() {
+		a, b := x.Int64(), y.Int64()
+		if sum := a + b; (sum > a) == (b > 0) {
+			return MakeInt64Number(sum)
+		}
+	}
+	if x.isExact() && y.isExact() {
+		return makeRationalNumber(new(big.Rat).Add(x.rat(), y.rat()))
+	}
+	return MakeFloat64Number(x.Float64() + y.Float64())
+}
+
+// subtractNumbers returns x - y, exact when both are.
+func subtractNumbers(x Number, y Number) Number {
+	if x.isInt64() && y.isInt64() {
+		a, b := x.Int64(), y.Int64()
+		if difference := a - b; (difference < a) == (b > 0) {
+			return MakeInt64Number(difference)
+		}
+	}
+	if x.isExact() && y.isExact() {
+		return makeRationalNumber(new(big.Rat).Sub(x.rat(), y.rat()))
+	}
+	return MakeFloat64Number(x.Float64() - y.Float64())
+}
+
+// multiplyNumbers returns x * y, exact when both are.
+func multiplyNumbers(x Number, y Number) Number {
+	if x.isInt64() && y.isInt64() {
+		a, b := x.Int64(), y.Int64()
+		if a == 0 || b == 0 {
+			return MakeInt64Number(0)
+		}
+		product := a * b
+		overflows := (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64)
+		if !overflows && product/b == a {
+			return MakeInt64Number(product)
+		}
+	}
+	if x.isExact() && y.isExact() {
+		return makeRationalNumber(new(big.Rat).Mul(x.rat(), y.rat()))
+	}
+	return MakeFloat64Number(x.Float64() * y.Float64())
+}
+
+// divideNumbers returns x / y, exact when both are. An exact y of 0 is an error, an inexact
+// division by zero is an infinity or a NaN.
+func divideNumbers(x Number, y Number) (Number, error) {
+	if x.isExact() && y.isExact() {
+		if y.isInt64() && y.Int64() == 0 {
+			return Number{}, conditionErrorf(DivisionByZeroCondition, "'/' division by zero")
+		}
+		if x.isInt64() && y.isInt64() {
+			a, b := x.Int64(), y.Int64()
+			if a%b == 0 && !(a == math.MinInt64 && b == -1) {
+				return MakeInt64Number(a / b), nil
+			}
+		}
+		return makeRationalNumber(new(big.Rat).Quo(x.rat(), y.rat())), nil
+	}
+	return MakeFloat64Number(x.Float64() / y.Float64()), nil
+}
+
+// compareNumbers returns -1, 0 or 1 as x is less than, equal to or greater than y. Two exact
+// numbers are compared exactly, floats can't hold all of them.
+func compareNumbers(x Number, y Number) int {
+	if x.isInt64() && y.isInt64() {
+		a, b := x.Int64(), y.Int64()
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	}
+	if x.isExact() && y.isExact() {
+		return x.rat().Cmp(y.rat())
+	}
+	a, b := x.Float64(), y.Float64()
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// exactNumber returns the exact number equal to n, or false when there's none: for an infinity or a
+// NaN. The exact value of a float is the fraction it holds, 0.1 is 3602879701896397/36028797018963968.
+func exactNumber(n Number) (Number, bool) {
+	if n.isExact() {
+		return n, true
+	}
+	f := n.Float64()
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return Number{}, false
+	}
+	return makeRationalNumber(new(big.Rat).SetFloat64(f)), true
+}
+
+// exactSqrt returns the exact square root of n, false unless n is the square of an exact number, such
+// as 16 or 4/9.
+func exactSqrt(n Number) (Number, bool) {
+	if !n.isExact() || compareNumbers(n, MakeInt64Number(0)) < 0 {
+		return Number{}, false
+	}
+	// a float holds the root of an integer below 2^52 exactly
+	if n.isInt64() && n.Int64() < 1<<52 {
+		root := int64(math.Sqrt(float64(n.Int64())))
+		return MakeInt64Number(root), root*root == n.Int64()
+	}
+	r := n.rat()
+	num, denom := new(big.Int).Sqrt(r.Num()), new(big.Int).Sqrt(r.Denom())
+	root := new(big.Rat).SetFrac(num, denom)
+	if new(big.Rat).Mul(root, root).Cmp(r) != 0 {
+		return Number{}, false
+	}
+	return makeRationalNumber(root), true
+}
+
+// integerArgument returns the integer parameters[i] of the builtin name, exact or an inexact float
+// without a fraction.
+func integerArgument(parameters []*ReturnValue, i int, name string) (Number, error) {
+	val := parameters[i]
+	if val.Type != NumberType {
+		return Number{}, conditionErrorf(WrongTypeCondition, "'%s' expected an integer, got %s", name, val.Type)
+	}
+	n := val.Number()
+	if !isInteger(n) {
+		return Number{}, conditionErrorf(WrongTypeCondition, "'%s' expected an integer, got %s", name, n)
+	}
+	return n, nil
+}
+
+// quotientNumbers returns x / y truncated toward zero, exact when both are.
+func quotientNumbers(x Number, y Number) (Number, error) {
+	if y.Float64() == 0 {
+		return Number{}, conditionErrorf(DivisionByZeroCondition, "'quotient' division by zero")
+	}
+	if x.isInt64() && y.isInt64() && !(x.Int64() == math.MinInt64 && y.Int64() == -1) {
+		return MakeInt64Number(x.Int64() / y.Int64()), nil
+	}
+	if x.isExactInteger() && y.isExactInteger() {
+		return makeIntegerNumber(new(big.Int).Quo(x.bigInteger(), y.bigInteger())), nil
+	}
+	return MakeFloat64Number(math.Trunc(x.Float64() / y.Float64())), nil
+}
+
+// moduloNumbers returns x modulo y, which has the sign of y where the remainder has the sign of x:
+// (modulo -7 2) is 1 and (remainder -7 2) is -1.
+func moduloNumbers(x Number, y Number) (Number, error) {
+	if y.Float64() == 0 {
+		return Number{}, conditionErrorf(DivisionByZeroCondition, "'modulo' division by zero")
+	}
+	if x.isInt64() && y.isInt64() {
+		a, b := x.Int64(), y.Int64()
+		if b == -1 {
+			return MakeInt64Number(0), nil
+		}
+		m := a % b
+		if m != 0 && (m < 0) != (b < 0) {
+			m += b
+		}
+		return MakeInt64Number(m), nil
+	}
+	if x.isExactInteger() && y.isExactInteger() {
+		m := new(big.Int).Rem(x.bigInteger(), y.bigInteger())
+		if m.Sign() != 0 && m.Sign() != y.bigInteger().Sign() {
+			m.Add(m, y.bigInteger())
+		}
+		return makeIntegerNumber(m), nil
+	}
+	a, b := x.Float64(), y.Float64()
+	m := math.Mod(a, b)
+	if m != 0 && (m < 0) != (b < 0) {
+		m += b
+	}
+	return MakeFloat64Number(m), nil
+}
+
+// gcdNumbers returns the non-negative greatest common divisor of the integers x and y, inexact
+// when either is.
+func gcdNumbers(x Number, y Number) Number {
+	if x.isInt64() && y.isInt64() {
+		a, b := x.Int64(), y.Int64()
+		for b != 0 {
+			a, b = b, a%b
+		}
+		if a != math.MinInt64 {
+			return MakeInt64Number(max(a, -a))
+		}
+	}
+	if x.isExactInteger() && y.isExactInteger() {
+		return makeIntegerNumber(new(big.Int).GCD(nil, nil, x.bigInteger(), y.bigInteger()))
+	}
+	a, b := math.Abs(x.Float64()), math.Abs(y.Float64())
+	for b != 0 {
+		a, b = b, math.Mod(a, b)
+	}
+	return MakeFloat64Number(a)
+}
+
+// lcmNumbers returns the non-negative least common multiple of the integers x and y, inexact when
+// either is.
+func lcmNumbers(x Number, y Number) Number {
+	divisor := gcdNumbers(x, y)
+	if divisor.Float64() == 0 {
+		// x and y are 0
+		return divisor
+	}
+	// x is a multiple of divisor, the quotient is exact
+	quotient, _ := quotientNumbers(x, divisor)
+	res := multiplyNumbers(quotient, y)
+	if res.Float64() < 0 {
+		res = subtractNumbers(MakeInt64Number(0), res)
+	}
+	return res
+}
+
+// roundingBuiltin returns the builtin name, which rounds a float to an integer with round and an
+// exact rational with roundRational. An exact integer is returned as is.
+func roundingBuiltin(name string, round func(float64) float64, roundRational func(*big.Rat) *big.Int) *BuiltinFunction {
+	return &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			n, err := numberArgument(parameters, name)
+			if err != nil {
+				return nil, err
+			}
+			if n.isExactInteger() {
+				return parameters[0], nil
+			}
+			if n.isRational() {
+				return evaluator.newNumber(makeRationalNumber(new(big.Rat).SetInt(roundRational(n.rat())))), nil
+			}
+
+			return evaluator.newNumber(MakeFloat64Number(round(n.Float64()))), nil
+		},
+	}
+}
+
+// floorRational returns the largest integer not greater than r. The denominator of r is positive,
+// so the Euclidean division of big.Int is the floor.
+func floorRational(r *big.Rat) *big.Int {
+	return new(big.Int).Div(r.Num(), r.Denom())
+}
+
+func ceilingRational(r *big.Rat) *big.Int {
+	floor := floorRational(r)
+	if !r.IsInt() {
+		floor.Add(floor, big.NewInt(1))
+	}
+	return floor
+}
+
+func truncateRational(r *big.Rat) *big.Int {
+	return new(big.Int).Quo(r.Num(), r.Denom())
+}
+
+// roundRational returns the integer closest to r, the even one when r is halfway between two.
+func roundRational(r *big.Rat) *big.Int {
+	shifted := new(big.Rat).Add(r, big.NewRat(1, 2))
+	res := floorRational(shifted)
+	if shifted.IsInt() && res.Bit(0) == 1 {
+		res.Sub(res, big.NewInt(1))
+	}
+	return res
+}
+
+// isInteger reports whether n is an integer, exact or an inexact float without a fraction.
+func isInteger(n Number) bool {
+	if n.isExact() {
+		return n.isExactInteger()
+	}
+	f := n.Float64()
+	return f == math.Trunc(f) && !math.IsInf(f, 0)
+}
+
+// signBuiltin returns the builtin name, which tests a number with test.
+func signBuiltin(name string, test func(Number) bool) *BuiltinFunction {
+	return &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			n, err := numberArgument(parameters, name)
+			if err != nil {
+				return nil, err
+			}
+
+			return boolValue(test(n)), nil
+		},
+	}
+}
+
+// numberTypeBuiltin returns the builtin name, which reports whether its argument is a number test
+// holds for. Unlike the tests of signBuiltin it takes any value.
+func numberTypeBuiltin(name string, test func(Number) bool) *BuiltinFunction {
+	return &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'%s' has been called with %d arguments; it requires exactly 1 argument", name, len(parameters))
+			}
+
+			return boolValue(parameters[0].Type == NumberType && test(parameters[0].Number())), nil
+		},
+	}
+}
+
+// numberArgument returns the number parameters[0] of the builtin name, which takes only it.
+func numberArgument(parameters []*ReturnValue, name string) (Number, error) {
+	if len(parameters) != 1 {
+		return Number{}, conditionErrorf(WrongArityCondition, "'%s' has been called with %d arguments; it requires exactly 1 argument", name, len(parameters))
+	}
+	if parameters[0].Type != NumberType {
+		return Number{}, conditionErrorf(WrongTypeCondition, "'%s' expected a number, got %s", name, parameters[0].Type)
+	}
+	return parameters[0].Number(), nil
+}
+
+func addNumberBuiltins(env *Environment) {
+	addBuiltinToEnv(env, "exact?", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			n, err := numberArgument(parameters, "exact?")
+			if err != nil {
+				return nil, err
+			}
+
+			return boolValue(n.isExact()), nil
+		},
+	})
+
+	addBuiltinToEnv(env, "inexact?", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			n, err := numberArgument(parameters, "inexact?")
+			if err != nil {
+				return nil, err
+			}
+
+			return boolValue(!n.isExact()), nil
+		},
+	})
+
+	// exact and inexact are the R7RS names of inexact->exact and exact->inexact
+	for _, name := range []string{"exact->inexact", "inexact"} {
+		addBuiltinToEnv(env, name, &BuiltinFunction{
+			Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+				n, err := numberArgument(parameters, name)
+				if err != nil {
+					return nil, err
+				}
+				if !n.isExact() {
+					return parameters[0], nil
+				}
+
+				return evaluator.newNumber(MakeFloat64Number(n.Float64())), nil
+			},
+		})
+	}
+
+	for _, name := range []string{"inexact->exact", "exact"} {
+		addBuiltinToEnv(env, name, &BuiltinFunction{
+			Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+				n, err := numberArgument(parameters, name)
+				if err != nil {
+					return nil, err
+				}
+				if n.isExact() {
+					return parameters[0], nil
+				}
+				exact, ok := exactNumber(n)
+				if !ok {
+					return nil, conditionErrorf(WrongTypeCondition, "'%s' expected a number with an exact value, got %s", name, n)
+				}
+
+				return evaluator.newNumber(exact), nil
+			},
+		})
+	}
+
+	for name, divide := range map[string]func(Number, Number) (Number, error){"quotient": quotientNumbers, "modulo": moduloNumbers} {
+		addBuiltinToEnv(env, name, &BuiltinFunction{
+			Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+				if len(parameters) != 2 {
+					return nil, conditionErrorf(WrongArityCondition, "'%s' has been called with %d arguments; it requires exactly 2 arguments", name, len(parameters))
+				}
+				x, err := integerArgument(parameters, 0, name)
+				if err != nil {
+					return nil, err
+				}
+				y, err := integerArgument(parameters, 1, name)
+				if err != nil {
+					return nil, err
+				}
+				res, err := divide(x, y)
+				if err != nil {
+					return nil, err
+				}
+
+				return evaluator.newNumber(res), nil
+			},
+		})
+	}
+
+	addBuiltinToEnv(env, "floor", roundingBuiltin("floor", math.Floor, floorRational))
+	addBuiltinToEnv(env, "ceiling", roundingBuiltin("ceiling", math.Ceil, ceilingRational))
+	addBuiltinToEnv(env, "truncate", roundingBuiltin("truncate", math.Trunc, truncateRational))
+	// round goes to the even integer from halfway, (round 2.5) is 2. and (round 7/2) is 4
+	addBuiltinToEnv(env, "round", roundingBuiltin("round", math.RoundToEven, roundRational))
+
+	// (gcd n ...) is 0 without arguments
+	addBuiltinToEnv(env, "gcd", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			res := MakeInt64Number(0)
+			for i := range parameters {
+				n, err := integerArgument(parameters, i, "gcd")
+				if err != nil {
+					return nil, err
+				}
+				res = gcdNumbers(res, n)
+			}
+
+			return evaluator.newNumber(res), nil
+		},
+	})
+
+	// (lcm n ...) is 1 without arguments, and 0 when one of them is
+	addBuiltinToEnv(env, "lcm", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			res := MakeInt64Number(1)
+			for i := range parameters {
+				n, err := integerArgument(parameters, i, "lcm")
+				if err != nil {
+					return nil, err
+				}
+				res = lcmNumbers(res, n)
+			}
+
+			return evaluator.newNumber(res), nil
+		},
+	})
+
+	addBuiltinToEnv(env, "zero?", signBuiltin("zero?", func(n Number) bool { return n.Float64() == 0 }))
+	addBuiltinToEnv(env, "positive?", signBuiltin("positive?", func(n Number) bool { return compareNumbers(n, MakeInt64Number(0)) > 0 }))
+	addBuiltinToEnv(env, "negative?", signBuiltin("negative?", func(n Number) bool { return compareNumbers(n, MakeInt64Number(0)) < 0 }))
+
+	for name, remainder := range map[string]int64{"even?": 0, "odd?": 1} {
+		addBuiltinToEnv(env, name, &BuiltinFunction{
+			Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+				if len(parameters) != 1 {
+					return nil, conditionErrorf(WrongArityCondition, "'%s' has been called with %d arguments; it requires exactly 1 argument", name, len(parameters))
+				}
+				n, err := integerArgument(parameters, 0, name)
+				if err != nil {
+					return nil, err
+				}
+				m, _ := moduloNumbers(n, MakeInt64Number(2))
+
+				return boolValue(m.Float64() == float64(remainder)), nil
+			},
+		})
+	}
+
+	// every number is real, and every number but an infinity or a NaN is rational
+	addBuiltinToEnv(env, "integer?", numberTypeBuiltin("integer?", isInteger))
+	addBuiltinToEnv(env, "rational?", numberTypeBuiltin("rational?", func(n Number) bool {
+		f := n.Float64()
+		return !math.IsInf(f, 0) && !math.IsNaN(f)
+	}))
+	addBuiltinToEnv(env, "real?", numberTypeBuiltin("real?", func(n Number) bool { return true }))
+	addBuiltinToEnv(env, "exact-integer?", numberTypeBuiltin("exact-integer?", Number.isExactInteger))
+
+	// the numerator and the denominator of a float are the ones of its exact value, inexact
+	for name, part := range map[string]func(*big.Rat) *big.Int{"numerator": (*big.Rat).Num, "denominator": (*big.Rat).Denom} {
+		addBuiltinToEnv(env, name, &BuiltinFunction{
+			Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+				n, err := numberArgument(parameters, name)
+				if err != nil {
+					return nil, err
+				}
+				var r *big.Rat
+				if f := n.Float64(); n.isExact() {
+					r = n.rat()
+				} else if !math.IsInf(f, 0) && !math.IsNaN(f) {
+					r = new(big.Rat).SetFloat64(f)
+				} else {
+					return nil, conditionErrorf(WrongTypeCondition, "'%s' expected a rational number, got %s", name, n)
+				}
+				res := makeRationalNumber(new(big.Rat).SetInt(part(r)))
+				if !n.isExact() {
+					res = MakeFloat64Number(res.Float64())
+				}
+
+				return evaluator.newNumber(res), nil
+			},
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package evaluator
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The picture language painters live in prelude.scm, this file implements the primitives they draw with.
+// A vector is (x . y) in the unit square with the origin at the bottom left.
+
+const defaultPictureSize = 400
+
+type segment struct {
+	x1, y1, x2, y2 float64
+}
+
+// canvas collects the segments drawn by the painter that paint is running.
+type canvas struct {
+	segments []segment
+}
+
+func vectCoordinates(v *ReturnValue, procedureName string) (float64, float64, error) {
+	var x, y *ReturnValue
+	switch {
+	case v.Type == ConsType:
+		x, y = v.Cons().Car, v.Cons().Cdr
+		// a list (x y) as well as a pair (x . y)
+		if y.Type == ConsType && y.Cons().Cdr.Type == ListType {
+			y = y.Cons().Car
+		}
+	default:
+		return 0, 0, conditionErrorf(WrongTypeCondition, "'%s' expected a vector, got %s", procedureName, v.String())
+	}
+	if x.Type != NumberType || y.Type != NumberType {
+		return 0, 0, conditionErrorf(WrongTypeCondition, "'%s' expected a vector of numbers, got %s", procedureName, v.String())
+	}
+	return x.Number().Float64(), y.Number().Float64(), nil
+}
+
+func formatCoordinate(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func writeSVG(w io.Writer, segments []segment, width, height int) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n", width, height, width, height)
+	fmt.Fprintf(bw, "<rect width=\"%d\" height=\"%d\" fill=\"white\"/>\n", width, height)
+	for _, s := range segments {
+		fmt.Fprintf(bw, "<line x1=\"%s\" y1=\"%s\" x2=\"%s\" y2=\"%s\" stroke=\"black\"/>\n",
+			formatCoordinate(s.x1*float64(width)), formatCoordinate((1-s.y1)*float64(height)),
+			formatCoordinate(s.x2*float64(width)), formatCoordinate((1-s.y2)*float64(height)))
+	}
+	bw.WriteString("</svg>\n")
+	return bw.Flush()
+}
+
+func writePNG(w io.Writer, segments []segment, width, height int) error {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+
+	toPixel := func(x, y float64) (float64, float64) {
+		return x * float64(width-1), (1 - y) * float64(height-1)
+	}
+	for _, s := range segments {
+		x1, y1 := toPixel(s.x1, s.y1)
+		x2, y2 := toPixel(s.x2, s.y2)
+		steps := math.Max(math.Abs(x2-x1), math.Abs(y2-y1))
+		for i := 0.0; i <= steps; i++ {
+			t := 0.0
+			if steps > 0 {
+				t = i / steps
+			}
+			img.Set(int(math.Round(x1+(x2-x1)*t)), int(math.Round(y1+(y2-y1)*t)), color.Black)
+		}
+	}
+	return png.Encode(w, img)
+}
+
+func addPictureBuiltins(env *Environment) {
+	addBuiltinToEnv(env, "draw-line", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'draw-line' has been called with %d arguments; it requires exactly 2 arguments", len(parameters))
+			}
+			if evaluator.canvas == nil {
+				return nil, conditionErrorf(GenericCondition, "'draw-line' can only be called by a painter run by paint")
+			}
+
+			x1, y1, err := vectCoordinates(parameters[0], "draw-line")
+			if err != nil {
+				return nil, err
+			}
+			x2, y2, err := vectCoordinates(parameters[1], "draw-line")
+			if err != nil {
+				return nil, err
+			}
+			evaluator.canvas.segments = append(evaluator.canvas.segments, segment{x1, y1, x2, y2})
+			return Void, nil
+		},
+	})
+
+	// (paint painter "file.svg") or (paint painter "file.png" width height), the format follows the extension
+	addBuiltinToEnv(env, "paint", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 2 && len(parameters) != 4 {
+				return nil, conditionErrorf(WrongArityCondition, "'paint' has been called with %d arguments; it requires 2 or 4 arguments", len(parameters))
+			}
+			if parameters[1].Type != StringType {
+				return nil, conditionErrorf(WrongTypeCondition, "'paint' expected a file name string, got %s", parameters[1].Type)
+			}
+			width, height := defaultPictureSize, defaultPictureSize
+			if len(parameters) == 4 {
+				for _, size := range parameters[2:] {
+					if size.Type != NumberType || !size.Number().isInt64() || size.Number().Int64() <= 0 {
+						return nil, conditionErrorf(WrongTypeCondition, "'paint' expected a positive integer size, got %s", size.String())
+					}
+				}
+				width, height = int(parameters[2].Number().Int64()), int(parameters[3].Number().Int64())
+			}
+
+			fileName := parameters[1].StringValue()
+			var write func(io.Writer, []segment, int, int) error
+			switch strings.ToLower(filepath.Ext(fileName)) {
+			case ".svg":
+				write = writeSVG
+			case ".png":
+				write = writePNG
+			default:
+				return nil, conditionErrorf(GenericCondition, "'paint' can only write .svg or .png files, got %s", fileName)
+			}
+			if err := evaluator.requireCapability(CapabilityFileSystem, "paint"); err != nil {
+				return nil, err
+			}
+
+			// the unit frame, built like make-frame in prelude.scm
+			zero := &ReturnValue{Type: NumberType, Data: MakeInt64Number(0)}
+			one := &ReturnValue{Type: NumberType, Data: MakeInt64Number(1)}
+			frame := makeList([]*ReturnValue{cons(zero, zero), cons(one, zero), cons(zero, one)})
+
+			previous := evaluator.canvas
+			evaluator.canvas = &canvas{}
+			defer func() {
+				evaluator.canvas = previous
+			}()
+			if _, err := evaluator.applyProcedure(parameters[0], []*ReturnValue{frame}, environment); err != nil {
+				return nil, err
+			}
+
+			file, err := os.Create(fileName)
+			if err != nil {
+				return nil, conditionErrorf(FileErrorCondition, "'paint' failed to create %s: %s", fileName, err)
+			}
+			defer file.Close()
+			if err := write(file, evaluator.canvas.segments, width, height); err != nil {
+				return nil, conditionErrorf(FileErrorCondition, "'paint' failed to write %s: %s", fileName, err)
+			}
+			return Void, nil
+		},
+	})
+}
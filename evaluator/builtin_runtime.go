@@ -0,0 +1,121 @@
+package evaluator
+
+import (
+	"errors"
+	"runtime"
+)
+
+type runtimeStat struct {
+	name  string
+	value uint64
+}
+
+// runtimeStats converts stats to an alist such as ((heap-alloc . 1024) (heap-objects . 12)).
+func runtimeStats(stats []runtimeStat) *ReturnValue {
+	elements := make([]*ReturnValue, len(stats))
+	for i, stat := range stats {
+		elements[i] = cons(
+			&ReturnValue{Type: SymbolType, Data: stat.name},
+			&ReturnValue{Type: NumberType, Data: MakeInt64Number(int64(stat.value))},
+		)
+	}
+	return makeList(elements)
+}
+
+func addRuntimeBuiltins(env *Environment) {
+	// (memory-usage) reports the Go heap in bytes, see runtime.MemStats for the meaning of each figure
+	addBuiltinToEnv(env, "memory-usage", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 0 {
+				return nil, conditionErrorf(WrongArityCondition, "'memory-usage' has been called with %d arguments; it requires exactly 0 argument", len(parameters))
+			}
+
+			var m runtime.MemStats
+			runtime.ReadMemStats(&m)
+			return runtimeStats([]runtimeStat{
+				{"heap-alloc", m.HeapAlloc},
+				{"heap-in-use", m.HeapInuse},
+				{"heap-objects", m.HeapObjects},
+				{"total-alloc", m.TotalAlloc},
+				{"mallocs", m.Mallocs},
+				{"frees", m.Frees},
+				{"sys", m.Sys},
+			}), nil
+		},
+	})
+
+	addBuiltinToEnv(env, "gc-stats", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 0 {
+				return nil, conditionErrorf(WrongArityCondition, "'gc-stats' has been called with %d arguments; it requires exactly 0 argument", len(parameters))
+			}
+
+			var m runtime.MemStats
+			runtime.ReadMemStats(&m)
+			lastPause := uint64(0)
+			if m.NumGC > 0 {
+				lastPause = m.PauseNs[(m.NumGC+255)%256]
+			}
+			return runtimeStats([]runtimeStat{
+				{"gc-cycles", uint64(m.NumGC)},
+				{"forced-gc-cycles", uint64(m.NumForcedGC)},
+				{"pause-total-ns", m.PauseTotalNs},
+				{"last-pause-ns", lastPause},
+				{"next-gc-heap-size", m.NextGC},
+			}), nil
+		},
+	})
+
+	// (on-exit thunk) registers thunk to be called when the session ends, see RunExitHooks
+	addBuiltinToEnv(env, "on-exit", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'on-exit' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+			thunk := parameters[0]
+			if thunk.Type != ProcedureType && thunk.Type != BuiltinFunctionType {
+				return nil, conditionErrorf(WrongTypeCondition, "'on-exit' expected a procedure, got %s", thunk.Type)
+			}
+
+			evaluator.exitHooks = append(evaluator.exitHooks, thunk)
+			return Void, nil
+		},
+	})
+
+	addBuiltinToEnv(env, "command-line", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 0 {
+				return nil, conditionErrorf(WrongArityCondition, "'command-line' has been called with %d arguments; it requires exactly 0 argument", len(parameters))
+			}
+
+			elements := make([]*ReturnValue, len(evaluator.commandLine))
+			for i, arg := range evaluator.commandLine {
+				elements[i] = newString(arg)
+			}
+			return evaluator.newList(elements)
+		},
+	})
+}
+
+// SetCommandLine sets the list command-line returns, args[0] names the program and the rest are the
+// arguments given to it.
+func (e *Evaluator) SetCommandLine(args []string) {
+	e.commandLine = append([]string{}, args...)
+}
+
+// RunExitHooks calls the thunks registered with on-exit, the last registered first, and forgets them.
+// Every thunk is called even when one fails, the errors are joined.
+func (e *Evaluator) RunExitHooks() error {
+	hooks := e.exitHooks
+	e.exitHooks = nil
+	e.startRun()
+	defer e.flushOutput()
+
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if _, err := e.applyProcedure(hooks[i], []*ReturnValue{}, e.globalEnv); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
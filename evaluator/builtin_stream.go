@@ -0,0 +1,237 @@
+package evaluator
+
+// streams are built from cons-stream: a cons whose cdr is a promise.
+// https://mitp-content-server.mit.edu/books/content/sectbyfn/books_pres_0/6515/sicp.zip/full-text/book/book-Z-H-24.html
+
+func isEmptyStream(val *ReturnValue) bool {
+	return val.Type == ListType
+}
+
+func streamCar(val *ReturnValue, procedureName string) (*ReturnValue, error) {
+	if val.Type != ConsType {
+		return nil, conditionErrorf(WrongTypeCondition, "'%s' expected a stream, got %s", procedureName, val.Type)
+	}
+	return val.Cons().Car, nil
+}
+
+func streamCdr(val *ReturnValue, procedureName string, evaluator *Evaluator) (*ReturnValue, error) {
+	if val.Type != ConsType {
+		return nil, conditionErrorf(WrongTypeCondition, "'%s' expected a stream, got %s", procedureName, val.Type)
+	}
+	return force(val.Cons().Cdr, evaluator)
+}
+
+// makeStream builds a stream whose cdr is computed by thunk when forced.
+func makeStream(car *ReturnValue, thunk func() (*ReturnValue, error)) *ReturnValue {
+	cons := &ConsValue{
+		Car: car,
+		Cdr: &ReturnValue{Type: PromiseType, Data: &PromiseValue{Thunk: thunk}},
+	}
+	return &ReturnValue{Type: ConsType, Data: cons}
+}
+
+func streamMap(proc *ReturnValue, streams []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+	cars := make([]*ReturnValue, len(streams))
+	for i, s := range streams {
+		if isEmptyStream(s) {
+			return s, nil
+		}
+		car, err := streamCar(s, "stream-map")
+		if err != nil {
+			return nil, err
+		}
+		cars[i] = car
+	}
+
+	car, err := evaluator.applyProcedure(proc, cars, environment)
+	if err != nil {
+		return nil, err
+	}
+
+	return makeStream(car, func() (*ReturnValue, error) {
+		cdrs := make([]*ReturnValue, len(streams))
+		for i, s := range streams {
+			cdr, err := streamCdr(s, "stream-map", evaluator)
+			if err != nil {
+				return nil, err
+			}
+			cdrs[i] = cdr
+		}
+		return streamMap(proc, cdrs, evaluator, environment)
+	}), nil
+}
+
+func streamFilter(pred *ReturnValue, stream *ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+	// skip the rejected elements iteratively, so a long run of them doesn't grow the stack
+	for !isEmptyStream(stream) {
+		car, err := streamCar(stream, "stream-filter")
+		if err != nil {
+			return nil, err
+		}
+		ok, err := evaluator.applyProcedure(pred, []*ReturnValue{car}, environment)
+		if err != nil {
+			return nil, err
+		}
+
+		if !(ok.Type == ConstantType && ok.Data == FalseValue) {
+			rest := stream
+			return makeStream(car, func() (*ReturnValue, error) {
+				cdr, err := streamCdr(rest, "stream-filter", evaluator)
+				if err != nil {
+					return nil, err
+				}
+				return streamFilter(pred, cdr, evaluator, environment)
+			}), nil
+		}
+
+		stream, err = streamCdr(stream, "stream-filter", evaluator)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stream, nil
+}
+
+func streamEnumerateInterval(low Number, high Number) *ReturnValue {
+	if compareNumbers(low, high) > 0 {
+		return EmptyList
+	}
+
+	car := &ReturnValue{Type: NumberType, Data: low}
+	return makeStream(car, func() (*ReturnValue, error) {
+		return streamEnumerateInterval(addNumbers(low, MakeInt64Number(1)), high), nil
+	})
+}
+
+func addStreamBuiltins(env *Environment) {
+	addBuiltinToEnv(env, "stream-map", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) < 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'stream-map' has been called with %d arguments; it requires at least 2 arguments", len(parameters))
+			}
+
+			return streamMap(parameters[0], parameters[1:], evaluator, environment)
+		},
+	})
+
+	addBuiltinToEnv(env, "stream-filter", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'stream-filter' has been called with %d arguments; it requires exactly 2 arguments", len(parameters))
+			}
+
+			return streamFilter(parameters[0], parameters[1], evaluator, environment)
+		},
+	})
+
+	addBuiltinToEnv(env, "stream-ref", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'stream-ref' has been called with %d arguments; it requires exactly 2 arguments", len(parameters))
+			}
+
+			n := parameters[1]
+			if n.Type != NumberType || !n.Number().isInt64() || n.Number().Int64() < 0 {
+				return nil, conditionErrorf(WrongTypeCondition, "'stream-ref' expected a non-negative integer index, got %s", n.String())
+			}
+
+			stream := parameters[0]
+			var err error
+			for i := n.Number().Int64(); i > 0; i-- {
+				if isEmptyStream(stream) {
+					return nil, conditionErrorf(IndexOutOfRangeCondition, "'stream-ref' index %d is out of range", n.Number().Int64())
+				}
+				stream, err = streamCdr(stream, "stream-ref", evaluator)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if isEmptyStream(stream) {
+				return nil, conditionErrorf(IndexOutOfRangeCondition, "'stream-ref' index %d is out of range", n.Number().Int64())
+			}
+			return streamCar(stream, "stream-ref")
+		},
+	})
+
+	addBuiltinToEnv(env, "stream-for-each", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'stream-for-each' has been called with %d arguments; it requires exactly 2 arguments", len(parameters))
+			}
+
+			proc := parameters[0]
+			stream := parameters[1]
+			for !isEmptyStream(stream) {
+				car, err := streamCar(stream, "stream-for-each")
+				if err != nil {
+					return nil, err
+				}
+				_, err = evaluator.applyProcedure(proc, []*ReturnValue{car}, environment)
+				if err != nil {
+					return nil, err
+				}
+				stream, err = streamCdr(stream, "stream-for-each", evaluator)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			return &ReturnValue{Type: SymbolType, Data: "done"}, nil
+		},
+	})
+
+	addBuiltinToEnv(env, "stream-enumerate-interval", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'stream-enumerate-interval' has been called with %d arguments; it requires exactly 2 arguments", len(parameters))
+			}
+
+			low := parameters[0]
+			high := parameters[1]
+			if low.Type != NumberType || high.Type != NumberType {
+				return nil, conditionErrorf(WrongTypeCondition, "'stream-enumerate-interval' expected number values, got %s and %s", low.Type, high.Type)
+			}
+
+			return streamEnumerateInterval(low.Number(), high.Number()), nil
+		},
+	})
+
+	// (stream->list s) converts the whole stream, (stream->list s n) only the first n elements
+	addBuiltinToEnv(env, "stream->list", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 && len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'stream->list' has been called with %d arguments; it requires 1 or 2 arguments", len(parameters))
+			}
+
+			limit := int64(-1)
+			if len(parameters) == 2 {
+				n := parameters[1]
+				if n.Type != NumberType || !n.Number().isInt64() || n.Number().Int64() < 0 {
+					return nil, conditionErrorf(WrongTypeCondition, "'stream->list' expected a non-negative integer count, got %s", n.String())
+				}
+				limit = n.Number().Int64()
+			}
+
+			elements := make([]*ReturnValue, 0)
+			stream := parameters[0]
+			for !isEmptyStream(stream) && limit != 0 {
+				car, err := streamCar(stream, "stream->list")
+				if err != nil {
+					return nil, err
+				}
+				elements = append(elements, car)
+				limit--
+				if limit == 0 {
+					break
+				}
+
+				stream, err = streamCdr(stream, "stream->list", evaluator)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			return evaluator.newList(elements)
+		},
+	})
+}
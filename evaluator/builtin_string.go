@@ -0,0 +1,520 @@
+package evaluator
+
+import (
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// Strings are Go strings holding UTF-8, their lengths and indices count code points rather than
+// bytes, so (string-length "héllo") is 5 and substring never splits a character. Comparisons are in
+// code point order, which is the byte order of UTF-8. A character is a code point, string-ref and
+// string->list return them.
+//
+// Strings other than literals are mutable: string-set! and string-fill! copy the text with the
+// characters replaced and store it in the buffer the string's values share, so every reference to
+// the string sees the change. That makes a string-set! as slow as a string-copy, which is fine for the strings scripts
+// modify. The strings the other builtins return are new values, a string-copy shares no storage
+// with the original.
+
+// stringLength is the number of code points of s.
+func stringLength(s string) int {
+	return utf8.RuneCountInString(s)
+}
+
+// runeOffset is the byte offset of the k-th code point of s, len(s) when k is its length.
+func runeOffset(s string, k int) int {
+	offset := 0
+	for ; k > 0 && offset < len(s); k-- {
+		_, size := utf8.DecodeRuneInString(s[offset:])
+		offset += size
+	}
+	return offset
+}
+
+// runeSubstring returns the code points of s from start up to end, the caller has checked that
+// 0 <= start <= end <= stringLength(s).
+func runeSubstring(s string, start int, end int) string {
+	from := runeOffset(s, start)
+	return s[from : from+runeOffset(s[from:], end-start)]
+}
+
+// stringArgument returns the string parameters[i] of the builtin name.
+func stringArgument(parameters []*ReturnValue, i int, name string) (string, error) {
+	if parameters[i].Type != StringType {
+		return "", conditionErrorf(WrongTypeCondition, "'%s' expected a string, got %s", name, parameters[i].Type)
+	}
+	return parameters[i].StringValue(), nil
+}
+
+// charArgument returns the character parameters[i] of the builtin name.
+func charArgument(parameters []*ReturnValue, i int, name string) (rune, error) {
+	if parameters[i].Type != CharType {
+		return 0, conditionErrorf(WrongTypeCondition, "'%s' expected a character, got %s", name, parameters[i].Type)
+	}
+	return parameters[i].Char(), nil
+}
+
+// rangeArguments returns the string parameters[0] of the builtin name and the range of it given by
+// the optional start and end parameters from parameters[from] on, the whole string without them.
+func rangeArguments(parameters []*ReturnValue, from int, name string) (string, int, int, error) {
+	s, err := stringArgument(parameters, 0, name)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	length := stringLength(s)
+	start, end := 0, length
+	if len(parameters) > from {
+		if start, err = indexArgument(parameters, from, name); err != nil {
+			return "", 0, 0, err
+		}
+	}
+	if len(parameters) > from+1 {
+		if end, err = indexArgument(parameters, from+1, name); err != nil {
+			return "", 0, 0, err
+		}
+	}
+	if end > length || start > end {
+		return "", 0, 0, conditionErrorf(IndexOutOfRangeCondition, "'%s' range %d to %d is out of range for a string of length %d", name, start, end, length)
+	}
+	return s, start, end, nil
+}
+
+// mutableStringArgument returns the string parameters[i] of the builtin name, which modifies it.
+func mutableStringArgument(parameters []*ReturnValue, i int, name string) (string, error) {
+	s, err := stringArgument(parameters, i, name)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := parameters[i].Data.(literalString); ok {
+		return "", conditionErrorf(WrongTypeCondition, "'%s' cannot modify a string literal: %s", name, parameters[i].String())
+	}
+	return s, nil
+}
+
+// setString replaces the text of the string val with s, counting the cells s needs beyond the ones
+// of the old text.
+func setString(val *ReturnValue, s string, evaluator *Evaluator) error {
+	if grown := len(s)/cellBytes - len(val.StringValue())/cellBytes; grown > 0 {
+		if err := evaluator.allocate(grown); err != nil {
+			return err
+		}
+	}
+	if str, ok := val.Data.(*mutableString); ok {
+		str.text = s
+	} else {
+		val.Data = &mutableString{text: s}
+	}
+	return nil
+}
+
+// indexArgument returns the exact non-negative integer parameters[i] of the builtin name.
+func indexArgument(parameters []*ReturnValue, i int, name string) (int, error) {
+	val := parameters[i]
+	if val.Type == NumberType {
+		n := val.Number()
+		if n.isInt64() && n.Int64() >= 0 && n.Int64() <= math.MaxInt32 {
+			return int(n.Int64()), nil
+		}
+	}
+	return 0, conditionErrorf(WrongTypeCondition, "'%s' expected a non-negative integer index, got %s", name, val.String())
+}
+
+// addStringComparison adds the builtin name, which reports whether every string it's given is in
+// the order holds for it and the next one, e.g. (string<? "a" "b" "c").
+func addStringComparison(env *Environment, name string, holds func(cmp int) bool) {
+	addBuiltinToEnv(env, name, &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) < 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'%s' has been called with %d arguments; it requires at least 1 argument", name, len(parameters))
+			}
+			strs := make([]string, len(parameters))
+			for i := range parameters {
+				s, err := stringArgument(parameters, i, name)
+				if err != nil {
+					return nil, err
+				}
+				strs[i] = s
+			}
+			for i := 1; i < len(strs); i++ {
+				if !holds(strings.Compare(strs[i-1], strs[i])) {
+					return False, nil
+				}
+			}
+			return True, nil
+		},
+	})
+}
+
+func addStringBuiltins(env *Environment) {
+	addBuiltinToEnv(env, "string-length", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'string-length' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+			s, err := stringArgument(parameters, 0, "string-length")
+			if err != nil {
+				return nil, err
+			}
+			return evaluator.newNumber(MakeInt64Number(int64(stringLength(s)))), nil
+		},
+	})
+
+	addBuiltinToEnv(env, "substring", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 2 && len(parameters) != 3 {
+				return nil, conditionErrorf(WrongArityCondition, "'substring' has been called with %d arguments; it requires 2 or 3 arguments", len(parameters))
+			}
+			s, start, end, err := rangeArguments(parameters, 1, "substring")
+			if err != nil {
+				return nil, err
+			}
+			return newString(runeSubstring(s, start, end)), nil
+		},
+	})
+
+	// (string-copy s [start [end]]) is substring with the start optional too
+	addBuiltinToEnv(env, "string-copy", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) < 1 || len(parameters) > 3 {
+				return nil, conditionErrorf(WrongArityCondition, "'string-copy' has been called with %d arguments; it requires 1 to 3 arguments", len(parameters))
+			}
+			s, start, end, err := rangeArguments(parameters, 1, "string-copy")
+			if err != nil {
+				return nil, err
+			}
+			return newString(runeSubstring(s, start, end)), nil
+		},
+	})
+
+	addBuiltinToEnv(env, "string-ref", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'string-ref' has been called with %d arguments; it requires exactly 2 arguments", len(parameters))
+			}
+			s, err := stringArgument(parameters, 0, "string-ref")
+			if err != nil {
+				return nil, err
+			}
+			k, err := indexArgument(parameters, 1, "string-ref")
+			if err != nil {
+				return nil, err
+			}
+			offset := runeOffset(s, k)
+			if offset == len(s) {
+				return nil, conditionErrorf(IndexOutOfRangeCondition, "'string-ref' index %d is out of range for a string of length %d", k, stringLength(s))
+			}
+			c, _ := utf8.DecodeRuneInString(s[offset:])
+			return &ReturnValue{Type: CharType, Data: c}, nil
+		},
+	})
+
+	addBuiltinToEnv(env, "string-set!", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 3 {
+				return nil, conditionErrorf(WrongArityCondition, "'string-set!' has been called with %d arguments; it requires exactly 3 arguments", len(parameters))
+			}
+			s, err := mutableStringArgument(parameters, 0, "string-set!")
+			if err != nil {
+				return nil, err
+			}
+			k, err := indexArgument(parameters, 1, "string-set!")
+			if err != nil {
+				return nil, err
+			}
+			c, err := charArgument(parameters, 2, "string-set!")
+			if err != nil {
+				return nil, err
+			}
+			offset := runeOffset(s, k)
+			if offset == len(s) {
+				return nil, conditionErrorf(IndexOutOfRangeCondition, "'string-set!' index %d is out of range for a string of length %d", k, stringLength(s))
+			}
+			_, size := utf8.DecodeRuneInString(s[offset:])
+			if err := setString(parameters[0], s[:offset]+string(c)+s[offset+size:], evaluator); err != nil {
+				return nil, err
+			}
+			return Void, nil
+		},
+	})
+
+	// (string-fill! s char [start [end]]) sets the characters of s from start up to end to char
+	addBuiltinToEnv(env, "string-fill!", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) < 2 || len(parameters) > 4 {
+				return nil, conditionErrorf(WrongArityCondition, "'string-fill!' has been called with %d arguments; it requires 2 to 4 arguments", len(parameters))
+			}
+			if _, err := mutableStringArgument(parameters, 0, "string-fill!"); err != nil {
+				return nil, err
+			}
+			c, err := charArgument(parameters, 1, "string-fill!")
+			if err != nil {
+				return nil, err
+			}
+			s, start, end, err := rangeArguments(parameters, 2, "string-fill!")
+			if err != nil {
+				return nil, err
+			}
+			from, to := runeOffset(s, start), runeOffset(s, end)
+			if err := setString(parameters[0], s[:from]+strings.Repeat(string(c), end-start)+s[to:], evaluator); err != nil {
+				return nil, err
+			}
+			return Void, nil
+		},
+	})
+
+	addBuiltinToEnv(env, "string-append", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			var b strings.Builder
+			for i := range parameters {
+				s, err := stringArgument(parameters, i, "string-append")
+				if err != nil {
+					return nil, err
+				}
+				b.WriteString(s)
+			}
+			return newString(b.String()), nil
+		},
+	})
+
+	// (make-string k [char]) returns a string of k characters, each of them char or a space
+	addBuiltinToEnv(env, "make-string", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 && len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'make-string' has been called with %d arguments; it requires 1 or 2 arguments", len(parameters))
+			}
+			k, err := indexArgument(parameters, 0, "make-string")
+			if err != nil {
+				return nil, err
+			}
+			c := ' '
+			if len(parameters) == 2 {
+				if c, err = charArgument(parameters, 1, "make-string"); err != nil {
+					return nil, err
+				}
+			}
+			// the cells are counted before the string is made, see make-vector
+			if err := evaluator.allocate(k * utf8.RuneLen(c) / cellBytes); err != nil {
+				return nil, err
+			}
+			return newString(strings.Repeat(string(c), k)), nil
+		},
+	})
+
+	// (string->list s [start [end]]) returns the characters of s from start up to end
+	addBuiltinToEnv(env, "string->list", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) < 1 || len(parameters) > 3 {
+				return nil, conditionErrorf(WrongArityCondition, "'string->list' has been called with %d arguments; it requires 1 to 3 arguments", len(parameters))
+			}
+			s, start, end, err := rangeArguments(parameters, 1, "string->list")
+			if err != nil {
+				return nil, err
+			}
+			elements := make([]*ReturnValue, 0, end-start)
+			for _, c := range runeSubstring(s, start, end) {
+				elements = append(elements, &ReturnValue{Type: CharType, Data: c})
+			}
+			return evaluator.newList(elements)
+		},
+	})
+
+	addBuiltinToEnv(env, "list->string", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'list->string' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+			elements, ok := listElements(parameters[0])
+			if !ok {
+				return nil, conditionErrorf(WrongTypeCondition, "'list->string' expected a list, got %s", parameters[0].Type)
+			}
+			var b strings.Builder
+			for _, element := range elements {
+				if element.Type != CharType {
+					return nil, conditionErrorf(WrongTypeCondition, "'list->string' expected a list of characters, got %s", element)
+				}
+				b.WriteRune(element.Char())
+			}
+			return newString(b.String()), nil
+		},
+	})
+
+	addBuiltinToEnv(env, "char?", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'char?' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+			return boolValue(parameters[0].Type == CharType), nil
+		},
+	})
+
+	addBuiltinToEnv(env, "char->integer", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'char->integer' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+			c, err := charArgument(parameters, 0, "char->integer")
+			if err != nil {
+				return nil, err
+			}
+			return evaluator.newNumber(MakeInt64Number(int64(c))), nil
+		},
+	})
+
+	addBuiltinToEnv(env, "integer->char", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'integer->char' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+			n, err := indexArgument(parameters, 0, "integer->char")
+			if err != nil {
+				return nil, err
+			}
+			if !utf8.ValidRune(rune(n)) {
+				return nil, conditionErrorf(WrongTypeCondition, "'integer->char' expected a Unicode code point, got %d", n)
+			}
+			return &ReturnValue{Type: CharType, Data: rune(n)}, nil
+		},
+	})
+
+	addStringComparison(env, "string=?", func(cmp int) bool { return cmp == 0 })
+	addStringComparison(env, "string<?", func(cmp int) bool { return cmp < 0 })
+	addStringComparison(env, "string>?", func(cmp int) bool { return cmp > 0 })
+	addStringComparison(env, "string<=?", func(cmp int) bool { return cmp <= 0 })
+	addStringComparison(env, "string>=?", func(cmp int) bool { return cmp >= 0 })
+
+	// (string-contains s pattern) returns the index of the first occurrence of pattern in s, #f if
+	// there's none
+	addBuiltinToEnv(env, "string-contains", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'string-contains' has been called with %d arguments; it requires exactly 2 arguments", len(parameters))
+			}
+			s, err := stringArgument(parameters, 0, "string-contains")
+			if err != nil {
+				return nil, err
+			}
+			pattern, err := stringArgument(parameters, 1, "string-contains")
+			if err != nil {
+				return nil, err
+			}
+			offset := strings.Index(s, pattern)
+			if offset < 0 {
+				return False, nil
+			}
+			return evaluator.newNumber(MakeInt64Number(int64(stringLength(s[:offset])))), nil
+		},
+	})
+
+	// (string-index s char-or-pred) returns the index of the first character of s that's char or
+	// satisfies pred, #f if there's none
+	addBuiltinToEnv(env, "string-index", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'string-index' has been called with %d arguments; it requires exactly 2 arguments", len(parameters))
+			}
+			s, err := stringArgument(parameters, 0, "string-index")
+			if err != nil {
+				return nil, err
+			}
+			switch parameters[1].Type {
+			case CharType, ProcedureType, BuiltinFunctionType:
+			default:
+				return nil, conditionErrorf(WrongTypeCondition, "'string-index' expected a character or a procedure, got %s", parameters[1].Type)
+			}
+			k := 0
+			for _, c := range s {
+				if parameters[1].Type == CharType {
+					if c == parameters[1].Char() {
+						return evaluator.newNumber(MakeInt64Number(int64(k))), nil
+					}
+				} else {
+					ok, err := evaluator.applyProcedure(parameters[1], []*ReturnValue{{Type: CharType, Data: c}}, environment)
+					if err != nil {
+						return nil, err
+					}
+					if !(ok.Type == ConstantType && ok.Data == FalseValue) {
+						return evaluator.newNumber(MakeInt64Number(int64(k))), nil
+					}
+				}
+				k++
+			}
+			return False, nil
+		},
+	})
+
+	// (string-split s [delimiter]) returns the list of the parts of s between occurrences of the
+	// string or character delimiter, the words of s separated by white space without one
+	addBuiltinToEnv(env, "string-split", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 && len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'string-split' has been called with %d arguments; it requires 1 or 2 arguments", len(parameters))
+			}
+			s, err := stringArgument(parameters, 0, "string-split")
+			if err != nil {
+				return nil, err
+			}
+			var parts []string
+			switch {
+			case len(parameters) == 1:
+				parts = strings.Fields(s)
+			case parameters[1].Type == CharType:
+				parts = strings.Split(s, string(parameters[1].Char()))
+			case parameters[1].Type == StringType && parameters[1].StringValue() != "":
+				parts = strings.Split(s, parameters[1].StringValue())
+			default:
+				return nil, conditionErrorf(WrongTypeCondition, "'string-split' expected a character or a non-empty string, got %s", parameters[1])
+			}
+			elements := make([]*ReturnValue, len(parts))
+			for i, part := range parts {
+				elements[i] = newString(part)
+			}
+			return evaluator.newList(elements)
+		},
+	})
+
+	// (string-join list [delimiter]) returns the strings of list joined by delimiter or a space
+	addBuiltinToEnv(env, "string-join", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 && len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'string-join' has been called with %d arguments; it requires 1 or 2 arguments", len(parameters))
+			}
+			elements, ok := listElements(parameters[0])
+			if !ok {
+				return nil, conditionErrorf(WrongTypeCondition, "'string-join' expected a list, got %s", parameters[0].Type)
+			}
+			delimiter := " "
+			if len(parameters) == 2 {
+				var err error
+				if delimiter, err = stringArgument(parameters, 1, "string-join"); err != nil {
+					return nil, err
+				}
+			}
+			var b strings.Builder
+			for i, element := range elements {
+				if element.Type != StringType {
+					return nil, conditionErrorf(WrongTypeCondition, "'string-join' expected a list of strings, got %s", element)
+				}
+				if i > 0 {
+					b.WriteString(delimiter)
+				}
+				b.WriteString(element.StringValue())
+			}
+			return newString(b.String()), nil
+		},
+	})
+
+	// (string-trim s) returns s without the white space at its start and end
+	addBuiltinToEnv(env, "string-trim", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'string-trim' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+			s, err := stringArgument(parameters, 0, "string-trim")
+			if err != nil {
+				return nil, err
+			}
+			return newString(strings.TrimSpace(s)), nil
+		},
+	})
+}
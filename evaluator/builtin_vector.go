@@ -0,0 +1,155 @@
+package evaluator
+
+// A vector is a fixed-length sequence of values indexed from 0, unlike a list its elements are
+// reached in constant time and vector-set! updates them in place.
+
+// vectorArgument returns the vector parameters[i] of the builtin name.
+func vectorArgument(parameters []*ReturnValue, i int, name string) (*VectorValue, error) {
+	if parameters[i].Type != VectorType {
+		return nil, conditionErrorf(WrongTypeCondition, "'%s' expected a vector, got %s", name, parameters[i].Type)
+	}
+	return parameters[i].Vector(), nil
+}
+
+// vectorIndexArgument returns the index parameters[i] of the builtin name into vector.
+func vectorIndexArgument(parameters []*ReturnValue, i int, name string, vector *VectorValue) (int, error) {
+	k, err := indexArgument(parameters, i, name)
+	if err != nil {
+		return 0, err
+	}
+	if k >= len(vector.Elements) {
+		return 0, conditionErrorf(IndexOutOfRangeCondition, "'%s' index %d is out of range for a vector of length %d", name, k, len(vector.Elements))
+	}
+	return k, nil
+}
+
+func addVectorBuiltins(env *Environment) {
+	addBuiltinToEnv(env, "vector?", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'vector?' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+			return boolValue(parameters[0].Type == VectorType), nil
+		},
+	})
+
+	// (make-vector k [fill]) returns a vector of k elements, each of them fill or 0
+	addBuiltinToEnv(env, "make-vector", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 && len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'make-vector' has been called with %d arguments; it requires 1 or 2 arguments", len(parameters))
+			}
+			k, err := indexArgument(parameters, 0, "make-vector")
+			if err != nil {
+				return nil, err
+			}
+			fill := evaluator.newNumber(MakeInt64Number(0))
+			if len(parameters) == 2 {
+				fill = parameters[1]
+			}
+			// the cells are counted before the elements are made, so a vector too large for the
+			// limits isn't made at all
+			if err := evaluator.allocate(k); err != nil {
+				return nil, err
+			}
+			elements := make([]*ReturnValue, k)
+			for i := range elements {
+				elements[i] = fill
+			}
+			return &ReturnValue{Type: VectorType, Data: &VectorValue{Elements: elements}}, nil
+		},
+	})
+
+	addBuiltinToEnv(env, "vector", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			elements := make([]*ReturnValue, len(parameters))
+			copy(elements, parameters)
+			return &ReturnValue{Type: VectorType, Data: &VectorValue{Elements: elements}}, nil
+		},
+	})
+
+	addBuiltinToEnv(env, "vector-length", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'vector-length' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+			vector, err := vectorArgument(parameters, 0, "vector-length")
+			if err != nil {
+				return nil, err
+			}
+			return evaluator.newNumber(MakeInt64Number(int64(len(vector.Elements)))), nil
+		},
+	})
+
+	addBuiltinToEnv(env, "vector-ref", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'vector-ref' has been called with %d arguments; it requires exactly 2 arguments", len(parameters))
+			}
+			vector, err := vectorArgument(parameters, 0, "vector-ref")
+			if err != nil {
+				return nil, err
+			}
+			k, err := vectorIndexArgument(parameters, 1, "vector-ref", vector)
+			if err != nil {
+				return nil, err
+			}
+			return vector.Elements[k], nil
+		},
+	})
+
+	addBuiltinToEnv(env, "vector-set!", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 3 {
+				return nil, conditionErrorf(WrongArityCondition, "'vector-set!' has been called with %d arguments; it requires exactly 3 arguments", len(parameters))
+			}
+			vector, err := vectorArgument(parameters, 0, "vector-set!")
+			if err != nil {
+				return nil, err
+			}
+			if vector.literal {
+				return nil, conditionErrorf(WrongTypeCondition, "'vector-set!' cannot modify a vector literal: %s", parameters[0].String())
+			}
+			k, err := vectorIndexArgument(parameters, 1, "vector-set!", vector)
+			if err != nil {
+				return nil, err
+			}
+			vector.Elements[k] = parameters[2]
+			return Void, nil
+		},
+	})
+
+	// (vector-fill! vector fill [start [end]]) sets the elements of vector from start up to end
+	addBuiltinToEnv(env, "vector-fill!", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) < 2 || len(parameters) > 4 {
+				return nil, conditionErrorf(WrongArityCondition, "'vector-fill!' has been called with %d arguments; it requires 2 to 4 arguments", len(parameters))
+			}
+			vector, err := vectorArgument(parameters, 0, "vector-fill!")
+			if err != nil {
+				return nil, err
+			}
+			if vector.literal {
+				return nil, conditionErrorf(WrongTypeCondition, "'vector-fill!' cannot modify a vector literal: %s", parameters[0].String())
+			}
+			start, end := 0, len(vector.Elements)
+			if len(parameters) > 2 {
+				if start, err = indexArgument(parameters, 2, "vector-fill!"); err != nil {
+					return nil, err
+				}
+			}
+			if len(parameters) > 3 {
+				if end, err = indexArgument(parameters, 3, "vector-fill!"); err != nil {
+					return nil, err
+				}
+			}
+			if end > len(vector.Elements) || start > end {
+				return nil, conditionErrorf(IndexOutOfRangeCondition, "'vector-fill!' range %d to %d is out of range for a vector of length %d", start, end, len(vector.Elements))
+			}
+			for i := start; i < end; i++ {
+				vector.Elements[i] = parameters[1]
+			}
+			return Void, nil
+		},
+	})
+}
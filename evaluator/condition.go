@@ -0,0 +1,197 @@
+package evaluator
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ConditionKind classifies the errors raised by builtins, so handlers can tell them apart without
+// looking at the message.
+type ConditionKind uint8
+
+const (
+	// GenericCondition is raised by `error` and anything that doesn't fit a more specific kind
+	GenericCondition ConditionKind = iota
+	WrongTypeCondition
+	WrongArityCondition
+	DivisionByZeroCondition
+	IndexOutOfRangeCondition
+	FileErrorCondition
+	// PermissionDeniedCondition is raised when a builtin needs a capability the sandbox doesn't grant
+	PermissionDeniedCondition
+	// ResourceLimitCondition is raised when a program exceeds one of the evaluator's Limits
+	ResourceLimitCondition
+	// UndefinedVariableCondition is raised when a variable that isn't bound is referenced or set!
+	UndefinedVariableCondition
+	// CanceledCondition is raised when the context of EvalContext is done
+	CanceledCondition
+	// AssertionCondition is raised when the expression of an assert is #f
+	AssertionCondition
+)
+
+// The errors a Condition matches with errors.Is, so embedders can tell failures apart without
+// looking at the condition's kind or message:
+//
+//	if errors.Is(err, evaluator.ErrLimitExceeded) { ... }
+var (
+	ErrUndefinedVariable = errors.New("undefined variable")
+	ErrWrongArity        = errors.New("wrong number of arguments")
+	ErrWrongType         = errors.New("wrong type")
+	ErrLimitExceeded     = errors.New("resource limit exceeded")
+	ErrCanceled          = errors.New("evaluation canceled")
+)
+
+var kindErrors = map[ConditionKind]error{
+	UndefinedVariableCondition: ErrUndefinedVariable,
+	WrongArityCondition:        ErrWrongArity,
+	WrongTypeCondition:         ErrWrongType,
+	ResourceLimitCondition:     ErrLimitExceeded,
+	CanceledCondition:          ErrCanceled,
+}
+
+func (k ConditionKind) String() string {
+	switch k {
+	case GenericCondition:
+		return "error"
+	case WrongTypeCondition:
+		return "wrong-type"
+	case WrongArityCondition:
+		return "wrong-arity"
+	case DivisionByZeroCondition:
+		return "division-by-zero"
+	case IndexOutOfRangeCondition:
+		return "index-out-of-range"
+	case FileErrorCondition:
+		return "file-error"
+	case PermissionDeniedCondition:
+		return "permission-denied"
+	case ResourceLimitCondition:
+		return "resource-limit"
+	case UndefinedVariableCondition:
+		return "undefined-variable"
+	case CanceledCondition:
+		return "canceled"
+	case AssertionCondition:
+		return "assertion"
+	default:
+		return "unknown"
+	}
+}
+
+// Condition is both the Go error returned by builtins and the value soup code sees once the error is caught.
+type Condition struct {
+	Kind    ConditionKind
+	Message string
+	// reason and irritants are what `error` was called with, Message formats them; reason is nil for
+	// the conditions of builtins
+	reason    *ReturnValue
+	irritants []*ReturnValue
+	// uncatchable is set on the conditions handlers aren't called with, see runLimitErrorf
+	uncatchable bool
+}
+
+func (c *Condition) Error() string {
+	return c.Message
+}
+
+// Is reports whether target is the Err variable of c's kind.
+func (c *Condition) Is(target error) bool {
+	return target != nil && kindErrors[c.Kind] == target
+}
+
+func conditionErrorf(kind ConditionKind, format string, a ...any) error {
+	return &Condition{Kind: kind, Message: fmt.Sprintf(format, a...)}
+}
+
+// conditionKindOf returns the kind of condition carried by err, errors that don't carry a condition are generic.
+func conditionKindOf(err error) ConditionKind {
+	var condition *Condition
+	if errors.As(err, &condition) {
+		return condition.Kind
+	}
+	return GenericCondition
+}
+
+// objectMessage returns the message of the error object c, see error-object-message.
+func (c *Condition) objectMessage() *ReturnValue {
+	if c.reason != nil {
+		return c.reason
+	}
+	return newString(c.Message)
+}
+
+func addConditionPredicate(env *Environment, name string, kind ConditionKind) {
+	addBuiltinToEnv(env, name, &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'%s' has been called with %d arguments; it requires exactly 1 argument", name, len(parameters))
+			}
+
+			val := parameters[0]
+			return boolValue(val.Type == ConditionType && val.Condition().Kind == kind), nil
+		},
+	})
+}
+
+func addConditionBuiltins(env *Environment) {
+	addBuiltinToEnv(env, "condition?", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'condition?' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+
+			return boolValue(parameters[0].Type == ConditionType), nil
+		},
+	})
+
+	// every condition is an error object: the ones raised by builtins have their message and no
+	// irritants
+	addBuiltinToEnv(env, "error-object?", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'error-object?' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+
+			return boolValue(parameters[0].Type == ConditionType), nil
+		},
+	})
+
+	addBuiltinToEnv(env, "error-object-message", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'error-object-message' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+			if parameters[0].Type != ConditionType {
+				return nil, conditionErrorf(WrongTypeCondition, "'error-object-message' expected an error object, got %s", parameters[0].Type)
+			}
+
+			return parameters[0].Condition().objectMessage(), nil
+		},
+	})
+
+	addBuiltinToEnv(env, "error-object-irritants", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'error-object-irritants' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+			if parameters[0].Type != ConditionType {
+				return nil, conditionErrorf(WrongTypeCondition, "'error-object-irritants' expected an error object, got %s", parameters[0].Type)
+			}
+
+			// a copy, the list may be mutated
+			elements := append([]*ReturnValue{}, parameters[0].Condition().irritants...)
+			return evaluator.newList(elements)
+		},
+	})
+
+	addConditionPredicate(env, "wrong-type-error?", WrongTypeCondition)
+	addConditionPredicate(env, "wrong-arity-error?", WrongArityCondition)
+	addConditionPredicate(env, "division-by-zero-error?", DivisionByZeroCondition)
+	addConditionPredicate(env, "index-out-of-range-error?", IndexOutOfRangeCondition)
+	addConditionPredicate(env, "file-error?", FileErrorCondition)
+	addConditionPredicate(env, "permission-denied-error?", PermissionDeniedCondition)
+	addConditionPredicate(env, "resource-limit-error?", ResourceLimitCondition)
+	addConditionPredicate(env, "undefined-variable-error?", UndefinedVariableCondition)
+	addConditionPredicate(env, "canceled-error?", CanceledCondition)
+	addConditionPredicate(env, "assertion-error?", AssertionCondition)
+}
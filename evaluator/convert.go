@@ -0,0 +1,282 @@
+package evaluator
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+var returnValueType = reflect.TypeOf((*ReturnValue)(nil))
+
+// FromGo converts a Go value to a soup value: bools, strings, integers and floats to the matching
+// atoms, slices and arrays to lists, maps to association lists ordered by key, pointers to structs
+// to Go objects, see RegisterType, nil to void, and a *ReturnValue to itself. A struct is copied
+// into a new Go object, other pointers and interfaces are followed, other types are an error.
+func FromGo(v any) (*ReturnValue, error) {
+	if v == nil {
+		return Void, nil
+	}
+	return fromGoValue(reflect.ValueOf(v))
+}
+
+// convertible reports whether values of t can be converted from and to soup values.
+func convertible(t reflect.Type) bool {
+	if t == returnValueType {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	case reflect.Slice:
+		return convertible(t.Elem())
+	case reflect.Map:
+		return convertible(t.Key()) && convertible(t.Elem())
+	case reflect.Interface:
+		return t.NumMethod() == 0
+	case reflect.Struct:
+		return true
+	case reflect.Pointer:
+		return t.Elem().Kind() == reflect.Struct
+	}
+	return false
+}
+
+// describeGoType names the soup values that convert to t, for error messages.
+func describeGoType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.String:
+		return "a string"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "an integer"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a non-negative integer"
+	case reflect.Slice:
+		return "a list"
+	case reflect.Map:
+		return "an association list"
+	case reflect.Struct, reflect.Pointer:
+		return "a " + t.String()
+	}
+	return "a value"
+}
+
+// toGoValue converts val to a Go value of type t, the error describes val when it doesn't fit.
+func toGoValue(val *ReturnValue, t reflect.Type) (reflect.Value, error) {
+	mismatch := func() (reflect.Value, error) {
+		return reflect.Value{}, errors.New(val.Type.String())
+	}
+	if t == returnValueType {
+		return reflect.ValueOf(val), nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if val.Type != ConstantType || val.Data == VoidConst {
+			return mismatch()
+		}
+		return reflect.ValueOf(val.Data == TrueValue).Convert(t), nil
+	case reflect.String:
+		if val.Type != StringType {
+			return mismatch()
+		}
+		return reflect.ValueOf(val.StringValue()).Convert(t), nil
+	case reflect.Float32, reflect.Float64:
+		if val.Type != NumberType {
+			return mismatch()
+		}
+		return reflect.ValueOf(val.Number().Float64()).Convert(t), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if val.Type != NumberType || !val.Number().isInt64() {
+			return mismatch()
+		}
+		n := reflect.New(t).Elem()
+		if n.OverflowInt(val.Number().Int64()) {
+			return reflect.Value{}, fmt.Errorf("%s, which overflows %v", val.Number(), t)
+		}
+		n.SetInt(val.Number().Int64())
+		return n, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if val.Type != NumberType || !val.Number().isInt64() {
+			return mismatch()
+		}
+		n := reflect.New(t).Elem()
+		if i := val.Number().Int64(); i < 0 || n.OverflowUint(uint64(i)) {
+			return reflect.Value{}, fmt.Errorf("%s, which overflows %v", val.Number(), t)
+		}
+		n.SetUint(uint64(val.Number().Int64()))
+		return n, nil
+	case reflect.Slice:
+		elements, ok := listElements(val)
+		if !ok {
+			return mismatch()
+		}
+		slice := reflect.MakeSlice(t, len(elements), len(elements))
+		for i, element := range elements {
+			v, err := toGoValue(element, t.Elem())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("a list holding %s", err)
+			}
+			slice.Index(i).Set(v)
+		}
+		return slice, nil
+	case reflect.Map:
+		entries, ok := listElements(val)
+		if !ok {
+			return mismatch()
+		}
+		m := reflect.MakeMapWithSize(t, len(entries))
+		for _, entry := range entries {
+			if !isPair(entry) {
+				return reflect.Value{}, fmt.Errorf("an association list holding %s", entry.Type)
+			}
+			car, _ := getCar(entry)
+			cdr, _ := getCdr(entry)
+			k, err := toGoValue(car, t.Key())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("an association list with a %s key", err)
+			}
+			v, err := toGoValue(cdr, t.Elem())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("an association list with a %s value", err)
+			}
+			m.SetMapIndex(k, v)
+		}
+		return m, nil
+	case reflect.Struct, reflect.Pointer:
+		if val.Type != GoObjectType {
+			return mismatch()
+		}
+		ptr := val.GoObject().value
+		if t.Kind() == reflect.Struct {
+			ptr = ptr.Elem()
+		}
+		if ptr.Type() != t {
+			return reflect.Value{}, errors.New(ptr.Type().String())
+		}
+		return ptr, nil
+	case reflect.Interface:
+		if v := val.ToGo(); v != nil {
+			return reflect.ValueOf(v), nil
+		}
+		return reflect.Zero(t), nil
+	}
+	return mismatch()
+}
+
+// ToGo converts rv to the Go value closest to it: numbers become int64 or float64, strings and
+// symbols strings, booleans bools, lists []any, Go objects the pointer they hold and void nil.
+// Anything else, like a procedure or a pair that isn't a list, stays a *ReturnValue.
+func (rv *ReturnValue) ToGo() any {
+	if rv == nil {
+		return nil
+	}
+	switch rv.Type {
+	case NumberType:
+		n := rv.Number()
+		if n.isInt64() {
+			return n.Int64()
+		}
+		return n.Float64()
+	case StringType:
+		return rv.StringValue()
+	case SymbolType:
+		return rv.Symbol()
+	case ConstantType:
+		switch rv.Data {
+		case TrueValue:
+			return true
+		case FalseValue:
+			return false
+		}
+		return nil
+	case ListType:
+		return []any{}
+	case ConsType:
+		elements, ok := listElements(rv)
+		if !ok {
+			return rv
+		}
+		values := make([]any, len(elements))
+		for i, element := range elements {
+			values[i] = element.ToGo()
+		}
+		return values
+	case GoObjectType:
+		return rv.GoObject().Value()
+	}
+	return rv
+}
+
+// fromGoValue converts a Go value of one of the types convertible accepts to a soup value.
+func fromGoValue(v reflect.Value) (*ReturnValue, error) {
+	if v.Type() == returnValueType {
+		if v.IsNil() {
+			return Void, nil
+		}
+		return v.Interface().(*ReturnValue), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return boolValue(v.Bool()), nil
+	case reflect.String:
+		return newString(v.String()), nil
+	case reflect.Float32, reflect.Float64:
+		return &ReturnValue{Type: NumberType, Data: MakeFloat64Number(v.Float())}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &ReturnValue{Type: NumberType, Data: MakeInt64Number(v.Int())}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() > 1<<63-1 {
+			return nil, fmt.Errorf("%d overflows int64", v.Uint())
+		}
+		return &ReturnValue{Type: NumberType, Data: MakeInt64Number(int64(v.Uint()))}, nil
+	case reflect.Slice, reflect.Array:
+		elements := make([]*ReturnValue, v.Len())
+		for i := range elements {
+			element, err := fromGoValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = element
+		}
+		return makeList(elements), nil
+	case reflect.Map:
+		entries := make([]*ReturnValue, 0, v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			key, err := fromGoValue(iter.Key())
+			if err != nil {
+				return nil, err
+			}
+			value, err := fromGoValue(iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, cons(key, value))
+		}
+		// map iteration order is random, sort so the same map always converts to the same list
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Write() < entries[j].Write()
+		})
+		return makeList(entries), nil
+	case reflect.Struct:
+		ptr := reflect.New(v.Type())
+		ptr.Elem().Set(v)
+		return makeGoObject(ptr), nil
+	case reflect.Interface, reflect.Pointer:
+		if v.Kind() == reflect.Pointer && v.Type().Elem().Kind() == reflect.Struct && !v.IsNil() {
+			return makeGoObject(v), nil
+		}
+		if v.IsNil() {
+			return Void, nil
+		}
+		return fromGoValue(v.Elem())
+	}
+	return nil, fmt.Errorf("unsupported type %v", v.Type())
+}
@@ -1,10 +1,24 @@
 package evaluator
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+)
+
+// smallEnvironmentSize is how many bindings a procedure call's environment holds before it needs a map,
+// enough for the parameters of most procedures.
+const smallEnvironmentSize = 4
 
 type Environment struct {
 	enclosing *Environment
-	store     map[string]*ReturnValue
+	// store holds the bindings, except in a procedure call's environment while it has no more than
+	// smallEnvironmentSize of them: those are in names and values, so most calls don't make a map
+	store  map[string]*ReturnValue
+	names  [smallEnvironmentSize]string
+	values [smallEnvironmentSize]*ReturnValue
+	size   int
+	// readOnly environments reject set!, it's used for the builtins
+	readOnly bool
 }
 
 func newEnvironment() *Environment {
@@ -13,30 +27,120 @@ func newEnvironment() *Environment {
 	}
 }
 
+// newCallEnvironment returns the environment of a procedure call, its bindings stay out of a map
+// until there are more than smallEnvironmentSize of them.
+func newCallEnvironment(enclosing *Environment) *Environment {
+	return &Environment{enclosing: enclosing}
+}
+
 func (env *Environment) Put(key string, value *ReturnValue) {
+	if env.store != nil {
+		env.store[key] = value
+		return
+	}
+	for i := 0; i < env.size; i++ {
+		if env.names[i] == key {
+			env.values[i] = value
+			return
+		}
+	}
+	if env.size < smallEnvironmentSize {
+		env.names[env.size] = key
+		env.values[env.size] = value
+		env.size++
+		return
+	}
+
+	env.store = make(map[string]*ReturnValue, env.size+1)
+	for i := 0; i < env.size; i++ {
+		env.store[env.names[i]] = env.values[i]
+		env.names[i], env.values[i] = "", nil
+	}
+	env.size = 0
 	env.store[key] = value
 }
 
+// lookup returns the value bound to key in env itself.
+func (env *Environment) lookup(key string) (*ReturnValue, bool) {
+	if env.store != nil {
+		val, ok := env.store[key]
+		return val, ok
+	}
+	for i := 0; i < env.size; i++ {
+		if env.names[i] == key {
+			return env.values[i], true
+		}
+	}
+	return nil, false
+}
+
 func (env *Environment) Get(key string) (*ReturnValue, bool) {
-	val, ok := env.store[key]
-	if !ok && env.enclosing != nil {
-		return env.enclosing.Get(key)
+	for e := env; e != nil; e = e.enclosing {
+		if val, ok := e.lookup(key); ok {
+			return val, true
+		}
 	}
-	return val, ok
+	return nil, false
+}
+
+// Names returns the names bound in env and the environments enclosing it, sorted.
+func (env *Environment) Names() []string {
+	seen := make(map[string]bool)
+	for e := env; e != nil; e = e.enclosing {
+		for _, name := range e.localNames() {
+			seen[name] = true
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LocalNames returns the names bound in env itself, not in the environments enclosing it, sorted:
+// the parameters and local defines of a procedure call, or the top-level defines of the global
+// environment.
+func (env *Environment) LocalNames() []string {
+	names := env.localNames()
+	sort.Strings(names)
+	return names
+}
+
+// localNames returns the names bound in env itself, unsorted.
+func (env *Environment) localNames() []string {
+	if env.store == nil {
+		return append([]string(nil), env.names[:env.size]...)
+	}
+	names := make([]string, 0, len(env.store))
+	for name := range env.store {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Enclosing returns the environment env was made in, nil for the builtins at the root.
+func (env *Environment) Enclosing() *Environment {
+	return env.enclosing
 }
 
 // Update updates the value of an existing key in the environment and returns the old value.
-// If the key does not exist in the current environment, it recursively
-// checks the enclosing environment. If the key is not found in any
+// If the key does not exist in the current environment, it
+// checks the enclosing environments. If the key is not found in any
 // environment, it returns an error.
 func (env *Environment) Update(key string, value *ReturnValue) (*ReturnValue, error) {
-	oldVal, ok := env.store[key]
-	if ok {
-		env.store[key] = value
+	for e := env; e != nil; e = e.enclosing {
+		oldVal, ok := e.lookup(key)
+		if !ok {
+			continue
+		}
+		if e.readOnly {
+			return nil, fmt.Errorf("can't set! builtin `%s`, use define to shadow it", key)
+		}
+		e.Put(key, value)
 		return oldVal, nil
-	} else if env.enclosing != nil {
-		return env.enclosing.Update(key, value)
 	}
 
-	return nil, fmt.Errorf("can't find key %s to update", key)
+	return nil, conditionErrorf(UndefinedVariableCondition, "can't find key %s to update", key)
 }
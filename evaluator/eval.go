@@ -0,0 +1,174 @@
+package evaluator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ocowchun/soup/lexer"
+	"github.com/ocowchun/soup/parser"
+)
+
+// eval evaluates data as code, such as what read returns, in an environment that's a value as well:
+// (the-environment) is the one it's called in and (interaction-environment) the global one. The
+// datum is given to the parser as tokens: its pairs become parens and its symbols the tokens they're
+// spelled with, while the values in it, and the values it quotes, are passed through as they are. So
+// (eval (list + 1 2)) calls the procedure itself, and (eval (list 'quote l)) returns l rather than a
+// copy.
+
+// evalDatum evaluates datum as a program in environment and returns the value of its last form.
+func (e *Evaluator) evalDatum(datum *ReturnValue, environment *Environment) (*ReturnValue, error) {
+	tokens, err := datumTokens(datum, nil)
+	if err != nil {
+		return nil, conditionErrorf(WrongTypeCondition, "'eval' expected a form: %s", err)
+	}
+	p := e.syntax.newParser(strings.NewReader(""))
+	p.Feed(tokens)
+	program, err := p.Parse()
+	if err != nil {
+		return nil, conditionErrorf(GenericCondition, "'eval' failed to parse %s: %s", datum.Write(), err)
+	}
+
+	ret := Void
+	for _, exp := range program.Expressions {
+		if ret, err = e.evalTopLevel(exp, environment); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// datumTokens appends the tokens the parser reads datum from to out.
+func datumTokens(datum *ReturnValue, out []lexer.Token) ([]lexer.Token, error) {
+	switch datum.Type {
+	case SymbolType:
+		return append(out, symbolToken(datum.Symbol())), nil
+	case ListType:
+		return append(out, datumToken("(", lexer.TokenTypeLeftParen), datumToken(")", lexer.TokenTypeRightParen)), nil
+	case ConsType:
+		elements, tail := listParts(datum)
+		if tail == nil {
+			return nil, fmt.Errorf("a circular list can't be part of a form")
+		}
+		if tail.Type == ListType && len(elements) == 2 && elements[0].Type == SymbolType && elements[0].Symbol() == "quote" {
+			// the quoted value itself
+			token := datumToken("", lexer.TokenTypeDatum)
+			token.Datum = elements[1]
+			return append(out, token), nil
+		}
+		out = append(out, datumToken("(", lexer.TokenTypeLeftParen))
+		for _, element := range elements {
+			var err error
+			if out, err = datumTokens(element, out); err != nil {
+				return nil, err
+			}
+		}
+		if tail.Type != ListType {
+			var err error
+			if out, err = datumTokens(tail, append(out, datumToken(".", lexer.TokenTypeDot))); err != nil {
+				return nil, err
+			}
+		}
+		return append(out, datumToken(")", lexer.TokenTypeRightParen)), nil
+	}
+	// numbers, strings and the other values evaluate to themselves
+	token := datumToken("", lexer.TokenTypeDatum)
+	token.Datum = datum
+	return append(out, token), nil
+}
+
+// datumToken returns a token of a form built as data, which has no position of its own.
+func datumToken(content string, tokenType lexer.TokenType) lexer.Token {
+	return lexer.Token{Content: content, Line: 1, Column: 1, TokenType: tokenType}
+}
+
+// symbolToken returns the token of the symbol name, the keyword or identifier it's spelled as.
+func symbolToken(name string) lexer.Token {
+	l := lexer.New(strings.NewReader(name))
+	token := l.NextToken()
+	switch token.TokenType {
+	case lexer.TokenTypeNumber, lexer.TokenTypeString, lexer.TokenTypeChar, lexer.TokenTypeInvalid, lexer.TokenTypeEOF,
+		lexer.TokenTypeLeftParen, lexer.TokenTypeRightParen, lexer.TokenTypeQuote, lexer.TokenTypeDot, lexer.TokenTypeVectorPrefix:
+		// a symbol that can't be spelled without bars, like |1| or |a b|, is an identifier all the same
+		return datumToken(name, lexer.TokenTypeIdentifier)
+	}
+	if l.NextToken().TokenType != lexer.TokenTypeEOF {
+		return datumToken(name, lexer.TokenTypeIdentifier)
+	}
+	token.Line, token.Column = 1, 1
+	return token
+}
+
+func (rv *ReturnValue) Environment() *Environment {
+	if rv.Type != EnvironmentType {
+		panic("not an environment")
+	}
+	if environment, ok := rv.Data.(*Environment); ok {
+		return environment
+	}
+	panic("invalid environment")
+}
+
+func addEvalBuiltins(env *Environment) {
+	// (eval expr [environment]) evaluates expr in environment, the global environment by default
+	addBuiltinToEnv(env, "eval", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 && len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'eval' has been called with %d arguments; it requires 1 or 2 arguments", len(parameters))
+			}
+			target := evaluator.globalEnv
+			if len(parameters) == 2 {
+				if parameters[1].Type != EnvironmentType {
+					return nil, conditionErrorf(WrongTypeCondition, "'eval' expected an environment, got %s", parameters[1].Type)
+				}
+				target = parameters[1].Environment()
+			}
+
+			return evaluator.evalDatum(parameters[0], target)
+		},
+	})
+
+	addBuiltinToEnv(env, "the-environment", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 0 {
+				return nil, conditionErrorf(WrongArityCondition, "'the-environment' has been called with %d arguments; it requires exactly 0 arguments", len(parameters))
+			}
+
+			return &ReturnValue{Type: EnvironmentType, Data: environment}, nil
+		},
+	})
+
+	addBuiltinToEnv(env, "interaction-environment", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 0 {
+				return nil, conditionErrorf(WrongArityCondition, "'interaction-environment' has been called with %d arguments; it requires exactly 0 arguments", len(parameters))
+			}
+
+			return &ReturnValue{Type: EnvironmentType, Data: evaluator.globalEnv}, nil
+		},
+	})
+
+	addBuiltinToEnv(env, "environment?", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'environment?' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+
+			return boolValue(parameters[0].Type == EnvironmentType), nil
+		},
+	})
+}
+
+// callsTheEnvironment reports whether exp calls the-environment, whose value depends on the bindings
+// around the call; the optimizer doesn't inline the lets around such a call.
+func callsTheEnvironment(exp parser.Expression) bool {
+	found := false
+	var visit func(parser.Expression) parser.Expression
+	visit = func(exp parser.Expression) parser.Expression {
+		if identifier, ok := exp.(*parser.IdentifierExpression); ok && identifier.Value == "the-environment" {
+			found = true
+		}
+		return mapSubexpressions(exp, visit)
+	}
+	visit(exp)
+	return found
+}
@@ -1,35 +1,258 @@
 package evaluator
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
+	"math/rand"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/ocowchun/soup/lexer"
 	"github.com/ocowchun/soup/parser"
 )
 
 type Evaluator struct {
-	globalEnv      *Environment
-	procedureNames []string
+	// baseEnv holds the builtins and the prelude, it encloses globalEnv and the environment of every library
+	baseEnv   *Environment
+	globalEnv *Environment
+	// operands is a stack of the arguments of the calls being made, a call takes its arguments from
+	// the top of it instead of allocating a slice
+	operands []*ReturnValue
+	// depth is how many procedure calls are nested, segmentDepth how many of them on the current
+	// stack segment, see stack.go
+	depth        int
+	segmentDepth int
+	capabilities Capability
+	// loadingFiles holds the absolute paths of the files being loaded, innermost last
+	loadingFiles []string
+	// source is where the code being evaluated was written, nil when it's not known
+	source *source
+	// searchPath is where load and import look for files after the directory of the current program
+	searchPath []string
+	// libraries are keyed by their formatted name, e.g. "(soup math)"
+	libraries map[string]*Library
+	// ambRun is the search of the top-level expression being evaluated, lastAmbRun the last one that
+	// made a choice, try-again resumes it. usesAmb is set once a program used amb, see amb.go.
+	ambRun     *ambRun
+	lastAmbRun *ambRun
+	usesAmb    bool
+	// canvas collects what draw-line draws while paint runs a painter
+	canvas *canvas
+	// operations and coercions back put/get and put-coercion/get-coercion
+	operations operationTable
+	coercions  operationTable
+	// commandLine is what command-line returns, the program's name followed by its arguments
+	commandLine []string
+	// steps counts the expressions evaluated, including the prelude
+	steps  uint64
+	limits Limits
+	// cells counts the cells allocated, see Cells
+	cells uint64
+	// maxSteps, maxCells and deadline are the limits of the current run as step and cell counts and
+	// a time, zero when unlimited
+	maxSteps uint64
+	maxCells uint64
+	deadline time.Time
+	// outOfCells is set once the run exceeded maxCells, the handlers get handlerCells more
+	outOfCells bool
+	// ctx is the context of the EvalContext in progress, nil otherwise
+	ctx    context.Context
+	tracer Tracer
+	hook   Hook
+	// traceDepth is how many traced calls are in progress
+	traceDepth int
+	// exitHooks are the thunks given to on-exit, in the order they were registered
+	exitHooks []*ReturnValue
+	// stdin is what read reads, stdout is where display and the other printing builtins write,
+	// stderr where diagnostics go
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	// stdoutBuffer is stdout when output is buffered, it's flushed by newline, read and at the end
+	// of a run; unbufferedOutput is set by WithUnbufferedOutput
+	stdoutBuffer     *bufio.Writer
+	unbufferedOutput bool
+	// arena allocates values when WithAllocationArena is given, it's nil otherwise
+	arena *valueArena
+	// optimizer is set by WithOptimizer, syntax by WithShadowing and WithRacket
+	optimizer bool
+	syntax    syntaxOptions
+	// required holds the paths of the files required so far, see WithRacket
+	required map[string]bool
+	// mit is set by WithMITCompat
+	mit bool
+	// macros holds the macros defined by the programs evaluated so far, see Macros
+	macros *parser.Macros
+	// handlers are the exception handlers installed, innermost last, see exception.go
+	handlers []*handler
+	// parameterizations are the bindings of the parameterize expressions being evaluated, innermost
+	// last, see parameter.go
+	parameterizations []parameterization
+	// handlerBase and parameterBase are the handlers and parameterizations of the consumer while a
+	// generator runs, below the generator's own, see builtin_generator.go
+	handlerBase   []*handler
+	parameterBase []parameterization
+	// droppedGenerators holds the generators that became garbage before they finished, for
+	// closeDroppedGenerators
+	droppedGenerators struct {
+		sync.Mutex
+		states []*generatorState
+	}
+	// transformers holds the procedures the transformers of define-macro evaluated to, see macro.go
+	transformers map[parser.Expression]*ReturnValue
+	// random backs random and the builtins that pick or shuffle elements
+	random *rand.Rand
+}
+
+// New creates an evaluator with the builtins and the procedures defined in prelude.scm, configured by
+// opts:
+//
+//	e := evaluator.New(evaluator.WithStdout(&buf), evaluator.WithSandbox(evaluator.NoCapabilities))
+func New(opts ...Option) *Evaluator {
+	o := newOptions(opts)
+	e := newEvaluator(initGlobalEnvironment(), o)
+	if o.prelude {
+		e.loadPrelude()
+	}
+	if o.racket {
+		e.loadEmbedded(racketProgram)
+	}
+	if o.mit {
+		e.loadEmbedded(mitProgram)
+	}
+	// user code shadows builtins with define, set! must not replace the originals `builtin` returns
+	e.baseEnv.readOnly = true
+	// set after the prelude, so it isn't traced
+	e.tracer = o.tracer
+	e.hook = o.hook
+	return e
+}
+
+// newEvaluator creates an evaluator on top of baseEnv, which holds the builtins.
+func newEvaluator(baseEnv *Environment, o options) *Evaluator {
+	e := &Evaluator{
+		baseEnv:          baseEnv,
+		globalEnv:        &Environment{enclosing: baseEnv, store: make(map[string]*ReturnValue)},
+		capabilities:     o.capabilities,
+		libraries:        make(map[string]*Library),
+		operations:       make(operationTable),
+		coercions:        make(operationTable),
+		searchPath:       o.searchPath,
+		commandLine:      o.commandLine,
+		limits:           o.limits,
+		stdin:            o.stdin,
+		stderr:           o.stderr,
+		unbufferedOutput: o.unbufferedOutput,
+		random:           rand.New(rand.NewSource(o.randomSeed)),
+		optimizer:        o.optimize,
+		required:         make(map[string]bool),
+		mit:              o.mit,
+		macros:           parser.NewMacros(),
+		transformers:     make(map[parser.Expression]*ReturnValue),
+	}
+	e.macros.SetExpander(e.expandMacro)
+	e.syntax = syntaxOptions{shadowing: o.shadowing, racket: o.racket, macros: e.macros}
+	e.setStdout(o.stdout)
+	if o.arena {
+		e.arena = &valueArena{}
+	}
+	return e
 }
 
-func New(stdin io.Reader) *Evaluator {
-	env := initGlobalEnvironment(stdin)
-	return &Evaluator{globalEnv: env, procedureNames: []string{}}
+// SetOutput sets where the printing builtins like display write, and where diagnostics go. Both
+// default to the process's stdout and stderr.
+func (e *Evaluator) SetOutput(stdout io.Writer, stderr io.Writer) {
+	e.flushOutput()
+	e.setStdout(stdout)
+	e.stderr = stderr
 }
 
-func (e *Evaluator) currentProcedureName() string {
-	return e.procedureNames[len(e.procedureNames)-1]
+func (e *Evaluator) setStdout(stdout io.Writer) {
+	e.stdout = stdout
+	e.stdoutBuffer = nil
+	if !e.unbufferedOutput {
+		e.stdoutBuffer = bufio.NewWriter(stdout)
+		e.stdout = e.stdoutBuffer
+	}
+}
+
+// flushOutput writes what the printing builtins buffered to stdout.
+func (e *Evaluator) flushOutput() {
+	if e.stdoutBuffer != nil {
+		e.stdoutBuffer.Flush()
+	}
 }
 
-func (e *Evaluator) pushProcedureName(newProcedureName string) {
-	e.procedureNames = append(e.procedureNames, newProcedureName)
+// GlobalEnvironment returns the environment Eval and LoadFile evaluate programs in.
+func (e *Evaluator) GlobalEnvironment() *Environment {
+	return e.globalEnv
 }
 
-func (e *Evaluator) popProcedureName() string {
-	res := e.procedureNames[len(e.procedureNames)-1]
-	e.procedureNames = e.procedureNames[:len(e.procedureNames)-1]
-	return res
+// Steps returns how many expressions the evaluator has evaluated so far, a measure of work that,
+// unlike time, doesn't depend on the machine.
+func (e *Evaluator) Steps() uint64 {
+	return e.steps
+}
+
+// eq reports whether a and b are the same object for eq?: what eqv? reports, except that numbers
+// are the same object when their representations are equal.
+func eq(a *ReturnValue, b *ReturnValue) bool {
+	if a == b {
+		return true
+	}
+	if a.Type == NumberType && b.Type == NumberType {
+		return a.Number() == b.Number()
+	}
+	return eqv(a, b)
+}
+
+// eqv reports whether a and b are the same object for eqv?: numbers of the same exactness with the
+// same value, floats of the same sign, constants, symbols and characters by value, the empty list,
+// and otherwise the same pair, string or procedure.
+func eqv(a *ReturnValue, b *ReturnValue) bool {
+	if a == b {
+		return true
+	}
+	if a.Type != b.Type {
+		return false
+	}
+	switch a.Type {
+	case NumberType:
+		x, y := a.Number(), b.Number()
+		if x.isExact() || y.isExact() {
+			return x.isExact() && y.isExact() && compareNumbers(x, y) == 0
+		}
+		// -0.0 isn't eqv? to 0.0, while a NaN is eqv? to itself
+		return math.Float64bits(x.Float64()) == math.Float64bits(y.Float64())
+	case ConstantType:
+		return a.Constant() == b.Constant()
+	case SymbolType:
+		return a.Symbol() == b.Symbol()
+	case CharType:
+		return a.Char() == b.Char()
+	case ListType:
+		return true
+	case ConsType:
+		return a.Cons() == b.Cons()
+	case StringType:
+		// a string is only eqv? to itself
+		return stringObject(a) == stringObject(b)
+	case ProcedureType:
+		return a.Procedure() == b.Procedure()
+	case BuiltinFunctionType:
+		return a.BuiltinFunction() == b.BuiltinFunction()
+	default:
+		return a.Data == b.Data
+	}
 }
 
 func equal(a *ReturnValue, b *ReturnValue) bool {
@@ -41,7 +264,11 @@ func equal(a *ReturnValue, b *ReturnValue) bool {
 		if b.Type != NumberType {
 			return false
 		}
-		return a.Number() == b.Number()
+		x, y := a.Number(), b.Number()
+		if x.isExact() && y.isExact() {
+			return compareNumbers(x, y) == 0
+		}
+		return x == y
 	case StringType:
 		if b.Type != StringType {
 			return false
@@ -52,33 +279,45 @@ func equal(a *ReturnValue, b *ReturnValue) bool {
 			return false
 		}
 		return a.String() == b.String()
+	case CharType:
+		if b.Type != CharType {
+			return false
+		}
+		return a.Char() == b.Char()
 	case ListType:
-		if b.Type != ListType {
+		return b.Type == ListType
+	case ConsType:
+		// the cdrs are compared in a loop, so a long list doesn't nest a call per element
+		for a.Type == ConsType && b.Type == ConsType {
+			if !equal(a.Cons().Car, b.Cons().Car) {
+				return false
+			}
+			a, b = a.Cons().Cdr, b.Cons().Cdr
+			if a == b {
+				return true
+			}
+		}
+		return equal(a, b)
+	case VectorType:
+		if b.Type != VectorType {
 			return false
 		}
-		aList := a.List()
-		bList := b.List()
-		if len(aList.Elements) != len(bList.Elements) {
+		aVector := a.Vector()
+		bVector := b.Vector()
+		if len(aVector.Elements) != len(bVector.Elements) {
 			return false
 		}
-		for i := range aList.Elements {
-			if !equal(aList.Elements[i], bList.Elements[i]) {
+		for i := range aVector.Elements {
+			if !equal(aVector.Elements[i], bVector.Elements[i]) {
 				return false
 			}
 		}
 		return true
-	case ConstantType:
-		if b.Type != ConstantType {
+	case BytevectorType:
+		if b.Type != BytevectorType {
 			return false
 		}
-		return a.Constant() == b.Constant()
-	case ConsType:
-		if b.Type != ConsType {
-			return false
-		}
-		aCons := a.Cons()
-		bCons := b.Cons()
-		return equal(aCons.Car, bCons.Car) && equal(aCons.Cdr, bCons.Cdr)
+		return bytes.Equal(a.Bytevector().Bytes, b.Bytevector().Bytes)
 	default:
 		return false
 	}
@@ -90,19 +329,16 @@ func addBuiltinToEnv(env *Environment, name string, fn *BuiltinFunction) {
 
 type Number struct {
 	// bad idea, try to improve it later
+	// an int64, a *big.Int out of the range of int64, a *big.Rat that isn't an integer, or a float64
 	data any
 }
 
 func MakeNumber(content string) (*ReturnValue, error) {
-	if data, err := strconv.ParseInt(content, 10, 64); err == nil {
-		return &ReturnValue{Type: NumberType, Data: Number{data: data}}, nil
-	}
-
-	f, err := strconv.ParseFloat(content, 64)
+	data, err := lexer.ParseNumber(content)
 	if err != nil {
 		panic(err)
 	}
-	return &ReturnValue{Type: NumberType, Data: Number{data: f}}, nil
+	return &ReturnValue{Type: NumberType, Data: Number{data: data}}, nil
 }
 
 func MakeFloat64Number(data float64) Number {
@@ -113,6 +349,62 @@ func MakeInt64Number(data int64) Number {
 	return Number{data: data}
 }
 
+// makeRationalNumber returns the exact number r, an integer when it's one.
+func makeRationalNumber(r *big.Rat) Number {
+	if !r.IsInt() {
+		return Number{data: r}
+	}
+	return makeIntegerNumber(r.Num())
+}
+
+// makeIntegerNumber returns the exact integer i, an int64 when it fits one.
+func makeIntegerNumber(i *big.Int) Number {
+	if i.IsInt64() {
+		return MakeInt64Number(i.Int64())
+	}
+	return Number{data: new(big.Int).Set(i)}
+}
+
+// isBigInteger reports whether n is an exact integer out of the range of int64.
+func (n Number) isBigInteger() bool {
+	_, ok := n.data.(*big.Int)
+	return ok
+}
+
+// isExactInteger reports whether n is an exact integer, whatever its size.
+func (n Number) isExactInteger() bool {
+	return n.isInt64() || n.isBigInteger()
+}
+
+// bigInteger returns the exact integer n as a *big.Int, which the caller mustn't modify.
+func (n Number) bigInteger() *big.Int {
+	if i, ok := n.data.(*big.Int); ok {
+		return i
+	}
+	return big.NewInt(n.Int64())
+}
+
+// isRational reports whether n is an exact rational that isn't an integer, such as 1/3.
+func (n Number) isRational() bool {
+	_, ok := n.data.(*big.Rat)
+	return ok
+}
+
+func (n Number) isExact() bool {
+	return n.isInt64() || n.isBigInteger() || n.isRational()
+}
+
+// rat returns the exact number n as a *big.Rat, which the caller mustn't modify.
+func (n Number) rat() *big.Rat {
+	switch num := n.data.(type) {
+	case *big.Rat:
+		return num
+	case *big.Int:
+		return new(big.Rat).SetInt(num)
+	}
+	return new(big.Rat).SetInt64(n.Int64())
+}
+
 func (n Number) isInt64() bool {
 	_, ok := n.data.(int64)
 	return ok
@@ -127,56 +419,176 @@ func (n Number) Int64() int64 {
 }
 
 func (n Number) Float64() float64 {
-	num, ok := n.data.(int64)
-	if ok {
+	switch num := n.data.(type) {
+	case int64:
 		return float64(num)
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(num).Float64()
+		return f
+	case *big.Rat:
+		f, _ := num.Float64()
+		return f
 	}
 	return n.data.(float64)
 }
 
 func (n Number) String() string {
-	if num, ok := n.data.(int64); ok {
+	switch num := n.data.(type) {
+	case int64:
 		return fmt.Sprintf("%v", num)
+	case *big.Int:
+		return num.String()
+	case *big.Rat:
+		return num.String()
+	}
+	return formatFloat(n.data.(float64))
+}
+
+// formatFloat writes f the way Scheme prints an inexact number, with the fewest digits that read back
+// as f and an exponent below 1e-7 or from 1e21 on, e.g. 0.1, 1e21 and 1.5e-10. A float without a
+// fraction ends with a point, 3. rather than 3, which would be exact. The infinities and NaN are
+// written +inf.0, -inf.0 and +nan.0.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "+nan.0"
+	case math.IsInf(f, 1):
+		return "+inf.0"
+	case math.IsInf(f, -1):
+		return "-inf.0"
+	}
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-7 || abs >= 1e21) {
+		mantissa, exponent, _ := strings.Cut(strconv.FormatFloat(f, 'e', -1, 64), "e")
+		exp, _ := strconv.Atoi(exponent)
+		return mantissa + "e" + strconv.Itoa(exp)
+	}
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += "."
 	}
-	return fmt.Sprintf("%v", n.data.(float64))
+	return s
 }
 
 func (e *Evaluator) Eval(program *parser.Program) (*ReturnValue, error) {
+	return e.EvalIn(program, e.globalEnv)
+}
+
+// EvalIn evaluates program in environment instead of the global environment, for example in the
+// environment a RuntimeError was raised in.
+func (e *Evaluator) EvalIn(program *parser.Program, environment *Environment) (*ReturnValue, error) {
 	var ret *ReturnValue
 	var err error
-	e.procedureNames = append(e.procedureNames, "main")
+	// an error can leave operands behind, drop them so the evaluator can keep going after it
+	operands := len(e.operands)
+	defer e.releaseOperands(operands)
+	e.startRun()
+	defer e.flushOutput()
+	if program.Source != "" {
+		defer e.setSource(&source{text: program.Source})()
+	}
 	for _, exp := range program.Expressions {
-		ret, err = e.eval(exp, e.globalEnv)
+		ret, err = e.evalTopLevel(exp, environment)
 		if err != nil {
+			locateRuntimeError(err, e.source)
 			return nil, err
 		}
 	}
 	return ret, nil
 }
 
+// EvalContext evaluates program like Eval does, raising a canceled condition between expressions
+// once ctx is done. Like a timeout, it doesn't interrupt a builtin blocked on input or the network.
+func (e *Evaluator) EvalContext(ctx context.Context, program *parser.Program) (*ReturnValue, error) {
+	return e.EvalInContext(ctx, program, e.globalEnv)
+}
+
+// EvalInContext is EvalIn with the cancellation of EvalContext. Definitions made before ctx is done
+// are kept, so the environment can be used again.
+func (e *Evaluator) EvalInContext(ctx context.Context, program *parser.Program, environment *Environment) (*ReturnValue, error) {
+	defer e.setContext(ctx)()
+	return e.EvalIn(program, environment)
+}
+
+// setSource makes src where the code being evaluated was written and returns a func restoring the
+// previous one.
+func (e *Evaluator) setSource(src *source) func() {
+	prev := e.source
+	e.source = src
+	return func() {
+		e.source = prev
+	}
+}
+
+// setContext makes ctx the context eval checks and returns a func restoring the previous one.
+func (e *Evaluator) setContext(ctx context.Context) func() {
+	prev := e.ctx
+	e.ctx = ctx
+	return func() {
+		e.ctx = prev
+	}
+}
+
 func (e *Evaluator) eval(expression parser.Expression, environment *Environment) (*ReturnValue, error) {
+	if err := e.step(); err != nil {
+		return nil, err
+	}
+	if e.hook != nil {
+		return e.hookExpression(expression, environment)
+	}
+	return e.evalExpression(expression, environment)
+}
+
+// step counts the evaluation of an expression, checking the limits.
+func (e *Evaluator) step() error {
+	e.steps++
+	if e.limits != (Limits{}) || e.ctx != nil {
+		return e.checkLimits()
+	}
+	return nil
+}
+
+func (e *Evaluator) evalExpression(expression parser.Expression, environment *Environment) (*ReturnValue, error) {
 	switch expression {
 	case parser.TrueLiteral:
-		return &ReturnValue{Type: ConstantType, Data: TrueValue}, nil
+		return True, nil
 	case parser.FalseLiteral:
-		return &ReturnValue{Type: ConstantType, Data: FalseValue}, nil
+		return False, nil
 	case parser.Void:
-		return &ReturnValue{Type: ConstantType, Data: VoidConst}, nil
+		return Void, nil
 	}
 
 	switch exp := expression.(type) {
 	case *parser.NumberLiteral:
+		switch v := exp.Value.(type) {
+		case int64:
+			return e.newNumber(MakeInt64Number(v)), nil
+		case float64:
+			return e.newNumber(MakeFloat64Number(v)), nil
+		case *big.Int:
+			return e.newNumber(makeIntegerNumber(v)), nil
+		case *big.Rat:
+			return e.newNumber(makeRationalNumber(v)), nil
+		}
+		// a literal built without the parser
 		return MakeNumber(exp.NumToken.Content)
 	case *parser.StringLiteral:
-		return &ReturnValue{Type: StringType, Data: exp.Value}, nil
+		return e.evalQuotedExpression(exp)
+	case *parser.CharLiteral:
+		return &ReturnValue{Type: CharType, Data: exp.Value}, nil
+	case *parser.DatumExpression:
+		return exp.Value.(*ReturnValue), nil
 	case *parser.SymbolExpression:
-		return &ReturnValue{Type: SymbolType, Data: exp.Value}, nil
+		return e.evalQuotedExpression(exp)
 	case *parser.DefineExpression:
 		return e.evalDefineExpression(exp, environment)
 	case *parser.IdentifierExpression:
 		val, ok := environment.Get(exp.Value)
 		if !ok {
-			return nil, fmt.Errorf("undefined identifier: `%s` on line %d", exp.Value, exp.Token().Line)
+			val, ok = e.lookupIntroduced(exp.Value)
+		}
+		if !ok {
+			name, _ := parser.IntroducedName(exp.Value)
+			return nil, conditionErrorf(UndefinedVariableCondition, "undefined identifier: `%s` on line %d", name, exp.Token().Line)
 		}
 		return val, nil
 	case *parser.CallExpression:
@@ -184,20 +596,18 @@ func (e *Evaluator) eval(expression parser.Expression, environment *Environment)
 	case *parser.LambdaExpression:
 		return e.evalLambdaExpression(exp, environment)
 	case *parser.PrimitiveProcedureExpression:
+		// primitive procedures are looked up like identifiers, so user definitions can shadow them
 		fn, ok := environment.Get(exp.String())
 		if !ok {
 			panic("undefined primitive identifier: `" + exp.String() + "`")
 		}
-		if fn.Type != BuiltinFunctionType {
-			return nil, fmt.Errorf("identifier `%s` is not a builtin function", exp.String())
-		}
 		return fn, nil
 	case *parser.IfExpression:
 		return e.evalIfExpression(exp, environment)
 	case *parser.SetExpression:
 		return e.evalSetExpression(exp, environment)
 	case *parser.ListExpression:
-		return e.evalListExpression(exp, environment)
+		return e.evalQuotedExpression(exp)
 	case *parser.BeginExpression:
 		return e.evalBeginExpression(exp, environment)
 	case *parser.DelayExpression:
@@ -205,34 +615,37 @@ func (e *Evaluator) eval(expression parser.Expression, environment *Environment)
 	case *parser.StreamExpression:
 		return e.evalStreamExpression(exp, environment)
 	case *parser.NestedSymbolExpression:
-		return e.evalNestedSymbolExpression(exp, environment)
+		return e.evalQuotedExpression(exp)
+	case *parser.VectorExpression:
+		return e.evalQuotedExpression(exp)
+	case *parser.DefineLibraryExpression:
+		return e.evalDefineLibraryExpression(exp)
+	case *parser.ImportExpression:
+		return e.evalImportExpression(exp, environment)
+	case *parser.RequireExpression:
+		return e.evalRequireExpression(exp, environment)
+	case *parser.ProvideExpression:
+		// every definition of a file is visible to the files requiring it
+		return Void, nil
+	case *parser.AmbExpression:
+		return e.evalAmbExpression(exp, environment)
+	case *parser.DefineSyntaxExpression:
+		e.macros.Define(exp.Name, exp.Rules)
+		return Void, nil
+	case *parser.GuardExpression:
+		return e.evalGuardExpression(exp, environment)
+	case *parser.ParameterizeExpression:
+		return e.evalParameterizeExpression(exp, environment)
+	case *parser.AssertExpression:
+		return e.evalAssertExpression(exp, environment)
+	case *parser.DefineMacroExpression:
+		e.macros.DefineTransformer(exp.Name, exp.Transformer)
+		return Void, nil
 	default:
 		return nil, fmt.Errorf("unsupported expression type: %T", exp)
 	}
 }
 
-func (e *Evaluator) evalNestedSymbolExpression(exp *parser.NestedSymbolExpression, environment *Environment) (*ReturnValue, error) {
-	quote := &ReturnValue{Type: SymbolType, Data: "quote"}
-
-	val, err := e.eval(exp.Value, environment)
-	if err != nil {
-		return nil, err
-	}
-
-	cdr := &ReturnValue{
-		Type: ConsType,
-		Data: &ConsValue{
-			Car: val,
-			Cdr: &ReturnValue{Type: ListType, Data: &ListValue{Elements: make([]*ReturnValue, 0)}},
-		},
-	}
-	cons := &ConsValue{
-		Car: quote,
-		Cdr: cdr,
-	}
-	return &ReturnValue{Type: ConsType, Data: cons}, nil
-}
-
 func (e *Evaluator) evalStreamExpression(exp *parser.StreamExpression, environment *Environment) (*ReturnValue, error) {
 	carVal, err := e.eval(exp.CarExpression, environment)
 	if err != nil {
@@ -254,38 +667,80 @@ func (e *Evaluator) evalStreamExpression(exp *parser.StreamExpression, environme
 
 func (e *Evaluator) evalDelayExpression(exp *parser.DelayExpression, environment *Environment) (*ReturnValue, error) {
 	//https://groups.csail.mit.edu/mac/ftpdir/scheme-7.4/doc-html/scheme_11.html#IDX1327
-	promise := &PromiseValue{Expression: exp.Expression, Env: environment, EvaluatedValue: nil}
+	promise := &PromiseValue{Expression: exp.Expression, Env: environment, EvaluatedValue: nil, delayForce: exp.Force}
 	return &ReturnValue{
 		Type: PromiseType,
 		Data: promise,
 	}, nil
 }
 
-func (e *Evaluator) evalBeginExpression(exp *parser.BeginExpression, environment *Environment) (*ReturnValue, error) {
-	for i, subExp := range exp.Expressions {
-		val, err := e.eval(subExp, environment)
+// evalAssertExpression raises an assertion condition when exp's expression is #f. The operands of
+// a call are evaluated here rather than by the call, so the condition can report their values.
+func (e *Evaluator) evalAssertExpression(exp *parser.AssertExpression, environment *Environment) (*ReturnValue, error) {
+	call, ok := exp.Expression.(*parser.CallExpression)
+	if name := callName(call); !ok || name == "and" || name == "or" {
+		val, err := e.eval(exp.Expression, environment)
 		if err != nil {
 			return nil, err
 		}
+		if val.Type == ConstantType && val.Data == FalseValue {
+			return nil, runtimeErrorAt(conditionErrorf(AssertionCondition, "assertion failed on line %d: %s is #f", exp.Token().Line, exp.Expression), exp.Token(), environment)
+		}
+		return Void, nil
+	}
 
-		if i == len(exp.Expressions)-1 {
-			return val, nil
+	operator, err := e.eval(call.Operator, environment)
+	if err != nil {
+		return nil, runtimeErrorAt(err, call.Operator.Token(), environment)
+	}
+	operands := make([]*ReturnValue, len(call.Operands))
+	for i, op := range call.Operands {
+		if operands[i], err = e.eval(op, environment); err != nil {
+			return nil, runtimeErrorAt(err, op.Token(), environment)
 		}
 	}
-	panic("unreachable")
+	val, err := e.applyProcedure(operator, operands, environment)
+	if err != nil {
+		return nil, runtimeErrorAt(err, call.Operator.Token(), environment)
+	}
+	if val.Type == ConstantType && val.Data == FalseValue {
+		var evaluated strings.Builder
+		evaluated.WriteString("(" + call.Operator.String())
+		for _, operand := range operands {
+			evaluated.WriteString(" " + operand.String())
+		}
+		evaluated.WriteString(")")
+		return nil, runtimeErrorAt(conditionErrorf(AssertionCondition, "assertion failed on line %d: %s, evaluated as %s", exp.Token().Line, call, evaluated.String()), exp.Token(), environment)
+	}
+	return Void, nil
 }
 
-func (e *Evaluator) evalListExpression(exp *parser.ListExpression, environment *Environment) (*ReturnValue, error) {
-	elements := make([]*ReturnValue, len(exp.Elements))
-	for i, element := range exp.Elements {
-		val, err := e.eval(element, environment)
+// callName returns the name of the operator of call, "" if it's not a name.
+func callName(call *parser.CallExpression) string {
+	if call == nil {
+		return ""
+	}
+	switch operator := call.Operator.(type) {
+	case *parser.PrimitiveProcedureExpression:
+		return operator.Value
+	case *parser.IdentifierExpression:
+		return operator.Value
+	}
+	return ""
+}
+
+func (e *Evaluator) evalBeginExpression(exp *parser.BeginExpression, environment *Environment) (*ReturnValue, error) {
+	for i, subExp := range exp.Expressions {
+		val, err := e.eval(subExp, environment)
 		if err != nil {
 			return nil, err
 		}
-		elements[i] = val
+
+		if i == len(exp.Expressions)-1 {
+			return val, nil
+		}
 	}
-	list := &ListValue{Elements: elements}
-	return &ReturnValue{Type: ListType, Data: list}, nil
+	panic("unreachable")
 }
 
 func (e *Evaluator) evalSetExpression(exp *parser.SetExpression, environment *Environment) (*ReturnValue, error) {
@@ -293,14 +748,45 @@ func (e *Evaluator) evalSetExpression(exp *parser.SetExpression, environment *En
 	if err != nil {
 		return nil, err
 	}
+	return e.assign(exp, environment, val)
+}
 
+// assign sets the variable exp sets to val and returns its old value.
+func (e *Evaluator) assign(exp *parser.SetExpression, environment *Environment, val *ReturnValue) (*ReturnValue, error) {
+	if original, introduced := parser.IntroducedName(exp.Name); introduced {
+		if _, bound := environment.Get(exp.Name); !bound {
+			// an identifier a macro introduced and didn't bind is the global variable
+			return e.globalEnv.Update(original, val)
+		}
+	}
 	return environment.Update(exp.Name, val)
 }
 
+// lookupIntroduced looks up an identifier a macro expansion introduced and didn't bind, which
+// refers to the global binding of its original name, see parser.IntroducedName.
+func (e *Evaluator) lookupIntroduced(name string) (*ReturnValue, bool) {
+	for {
+		original, ok := parser.IntroducedName(name)
+		if !ok {
+			return nil, false
+		}
+		if val, ok := e.globalEnv.Get(original); ok {
+			return val, true
+		}
+		name = original
+	}
+}
+
+// Macros returns the macros defined by the programs e evaluated. A parser using them, see
+// parser.UseMacros, expands them in the next program, e.g. the next entry of a REPL.
+func (e *Evaluator) Macros() *parser.Macros {
+	return e.macros
+}
+
 func (e *Evaluator) evalIfExpression(exp *parser.IfExpression, environment *Environment) (*ReturnValue, error) {
 	cond, err := e.eval(exp.Predicate, environment)
 	if err != nil {
-		return nil, newRuntimeError(err, exp.Predicate.Token(), e.currentProcedureName())
+		return nil, runtimeErrorAt(err, exp.Predicate.Token(), environment)
 	}
 
 	// In Scheme, any value except #f counts as true in conditionals.
@@ -309,17 +795,17 @@ func (e *Evaluator) evalIfExpression(exp *parser.IfExpression, environment *Envi
 		if exp.Alternative != nil {
 			ret, err := e.eval(exp.Alternative, environment)
 			if err != nil {
-				return nil, newRuntimeError(err, exp.Alternative.Token(), e.currentProcedureName())
+				return nil, runtimeErrorAt(err, exp.Alternative.Token(), environment)
 				//return nil, err
 			}
 			return ret, nil
 		} else {
-			return &ReturnValue{Type: ConstantType, Data: VoidConst}, nil
+			return Void, nil
 		}
 	} else {
 		ret, err := e.eval(exp.Consequent, environment)
 		if err != nil {
-			return nil, newRuntimeError(err, exp.Consequent.Token(), e.currentProcedureName())
+			return nil, runtimeErrorAt(err, exp.Consequent.Token(), environment)
 		}
 		return ret, nil
 	}
@@ -336,6 +822,9 @@ func (e *Evaluator) evalLambdaExpression(exp *parser.LambdaExpression, environme
 		OptionalTailParameter: exp.OptionalTailParameter,
 		Body:                  exp.Body,
 		Env:                   environment,
+		line:                  exp.Token().Line,
+		column:                exp.Token().Column,
+		source:                e.source,
 	}
 	return &ReturnValue{Type: ProcedureType, Data: proc}, nil
 }
@@ -345,89 +834,191 @@ func (e *Evaluator) evalDefineExpression(exp *parser.DefineExpression, environme
 	if err != nil {
 		return nil, err
 	}
+	nameProcedure(val, exp.Name)
 	environment.Put(exp.Name, val)
 	return val, nil
 }
 
 func (e *Evaluator) evalCallExpression(exp *parser.CallExpression, environment *Environment) (*ReturnValue, error) {
+	ret, _, err := e.evalCall(exp, environment, false)
+	return ret, err
+}
+
+// evalCall evaluates the call exp. When tail is set, a call of a procedure isn't made but returned,
+// for the caller to make in place of the procedure call exp is the body of.
+func (e *Evaluator) evalCall(exp *parser.CallExpression, environment *Environment, tail bool) (*ReturnValue, *tailCall, error) {
 	operator := exp.Operator
 
 	val, err := e.eval(operator, environment)
 	if err != nil {
-		return nil, newRuntimeError(err, operator.Token(), e.currentProcedureName())
+		return nil, nil, runtimeErrorAt(err, operator.Token(), environment)
 	}
 
 	isOrFn := val.Type == BuiltinFunctionType && operator.String() == "or"
 	isAndFn := val.Type == BuiltinFunctionType && operator.String() == "and"
 
-	operands := make([]*ReturnValue, len(exp.Operands))
-	for i, op := range exp.Operands {
+	// the operands are pushed on the operand stack; only a procedure, which binds them, or a builtin
+	// that borrows its parameters gets them there, any other builtin gets a copy it can keep
+	base := len(e.operands)
+	for _, op := range exp.Operands {
 		operand, err := e.eval(op, environment)
 		if err != nil {
-			return nil, newRuntimeError(err, operator.Token(), e.currentProcedureName())
+			e.releaseOperands(base)
+			// an operand that fails by itself, like an undefined identifier, is where the error is
+			token := operator.Token()
+			var runtimeError *RuntimeError
+			if !errors.As(err, &runtimeError) {
+				token = op.Token()
+			}
+			return nil, nil, runtimeErrorAt(err, token, environment)
 		}
 		// Workaround to support (or 1 bad-exp), to not eval bad-exp
 		if isOrFn && !(operand.Type == ConstantType && operand.Data == FalseValue) {
-			return operand, nil
+			e.releaseOperands(base)
+			return operand, nil, nil
 		}
 
 		// Workaround to support (and #f bad-exp), to not eval bad-exp
 		if isAndFn && (operand.Type == ConstantType && operand.Data == FalseValue) {
-			return &ReturnValue{Type: ConstantType, Data: FalseValue}, nil
+			e.releaseOperands(base)
+			return False, nil, nil
 		}
 
-		operands[i] = operand
+		e.operands = append(e.operands, operand)
 	}
+	operands := e.operands[base:]
+	defer e.releaseOperands(base)
 
 	switch val.Type {
 	case BuiltinFunctionType:
-		e.pushProcedureName(operator.String())
-
 		fn := val.BuiltinFunction()
+		if !fn.borrowsParameters {
+			operands = slices.Clone(operands)
+		}
 		ret, err := e.evalBuiltinFunction(fn, operands, environment)
 		if err != nil {
-			fmt.Println("error", operator.String(), err)
-			return nil, newRuntimeError(err, operator.Token(), e.popProcedureName())
+			return nil, nil, newRuntimeError(err, operator.Token(), procedureName{operator: operator}, environment)
 		}
-
-		e.popProcedureName()
-		return ret, nil
+		return ret, nil, nil
 
 	case ProcedureType:
-		e.pushProcedureName(operator.String())
 		fn := val.Procedure()
+		if tail {
+			return nil, &tailCall{operator: operator, procedure: fn, operands: slices.Clone(operands), environment: environment}, nil
+		}
 		ret, err := e.evalProcedure(fn, operands, environment)
 		if err != nil {
-			return nil, newRuntimeError(err, operator.Token(), e.popProcedureName())
+			return nil, nil, newRuntimeError(err, operator.Token(), procedureName{name: fn.Name, operator: operator, procedure: fn}, environment)
 		}
-		e.popProcedureName()
-		return ret, nil
+		return ret, nil, nil
 	default:
-		err = fmt.Errorf("unsupported operator type: %s(%s)", val.Type, val.String())
-		return nil, newRuntimeError(err, operator.Token(), e.currentProcedureName())
+		err = conditionErrorf(WrongTypeCondition, "unsupported operator type: %s(%s)", val.Type, val.String())
+		token := operator.Token()
+		if token.Line == 0 {
+			// #t and #f are shared by the whole program and have no position
+			token = exp.Token()
+		}
+		return nil, nil, runtimeErrorAt(err, token, environment)
 	}
 }
 
+// releaseOperands pops the operands pushed since the operand stack was base long.
+func (e *Evaluator) releaseOperands(base int) {
+	clear(e.operands[base:])
+	e.operands = e.operands[:base]
+}
+
 func (e *Evaluator) evalBuiltinFunction(builtinFn *BuiltinFunction, operands []*ReturnValue, environment *Environment) (*ReturnValue, error) {
 	ret, err := builtinFn.Fn(operands, e, environment)
 	if err != nil {
 		return nil, err
 	}
+	if ret != nil {
+		if err := e.allocate(valueCells(ret)); err != nil {
+			return nil, err
+		}
+	}
 	return ret, nil
 }
 
+// applyProcedure calls proc, which can be either a builtin function or a procedure, with operands.
+func (e *Evaluator) applyProcedure(proc *ReturnValue, operands []*ReturnValue, environment *Environment) (*ReturnValue, error) {
+	switch proc.Type {
+	case BuiltinFunctionType:
+		return e.evalBuiltinFunction(proc.BuiltinFunction(), operands, environment)
+	case ProcedureType:
+		return e.evalProcedure(proc.Procedure(), operands, environment)
+	default:
+		return nil, conditionErrorf(WrongTypeCondition, "expected procedure/builtinFunction but got %s", proc.Type)
+	}
+}
+
 func (e *Evaluator) evalProcedure(procedure *ProcedureValue, operands []*ReturnValue, environment *Environment) (*ReturnValue, error) {
+	if e.segmentDepth >= stackSegmentDepth {
+		return e.evalOnNewSegment(procedure, operands, environment)
+	}
+	e.depth++
+	e.segmentDepth++
+	defer func() {
+		e.depth--
+		e.segmentDepth--
+	}()
+	if e.tracer != nil {
+		return e.traceProcedure(procedure, operands, environment)
+	}
+	ret, call, err := e.evalProcedureBody(procedure, operands, environment)
+	for err == nil && call != nil {
+		ret, call, err = e.evalTailCall(call)
+	}
+	return ret, err
+}
+
+// arityError is the wrong-arity condition of calling procedure with got arguments, it names the
+// procedure and the line it was defined on.
+func arityError(procedure *ProcedureValue, got int) error {
+	name := procedure.Name
+	if name == "" {
+		name = "lambda"
+	}
+	required := "exactly " + countArguments(len(procedure.Parameters))
+	if procedure.CaneTakeArbitraryParameters() {
+		required = "at least " + countArguments(len(procedure.Parameters))
+	}
+	defined := ""
+	if procedure.line > 0 {
+		defined = fmt.Sprintf(" (defined on line %d)", procedure.line)
+	}
+	return conditionErrorf(WrongArityCondition, "'%s'%s has been called with %s; it requires %s", name, defined, countArguments(got), required)
+}
+
+// countArguments spells n arguments, e.g. "1 argument".
+func countArguments(n int) string {
+	if n == 1 {
+		return "1 argument"
+	}
+	return fmt.Sprintf("%d arguments", n)
+}
+
+// evalProcedureBody calls procedure with operands, a call in tail position of its body is returned
+// rather than made unless calls are traced or hooked, which see every call.
+func (e *Evaluator) evalProcedureBody(procedure *ProcedureValue, operands []*ReturnValue, environment *Environment) (*ReturnValue, *tailCall, error) {
 	if procedure.CaneTakeArbitraryParameters() {
 		if len(procedure.Parameters) > len(operands) {
-			return nil, fmt.Errorf("expected at least %d arguments, got %d", len(procedure.Parameters), len(operands))
+			return nil, nil, arityError(procedure, len(operands))
 		}
 	} else if len(procedure.Parameters) != len(operands) {
-		return nil, fmt.Errorf("expected %d arguments, got %d", len(procedure.Parameters), len(operands))
+		return nil, nil, arityError(procedure, len(operands))
+	}
+
+	// a cell for the frame and one per variable
+	if err := e.allocate(len(operands) + 1); err != nil {
+		return nil, nil, err
 	}
+	// the lambdas made in the body were written where the procedure was
+	defer e.setSource(procedure.source)()
 
 	// Create a new environment for the procedure call
-	newEnv := newEnvironment()
-	newEnv.enclosing = procedure.Env
+	newEnv := newCallEnvironment(procedure.Env)
 
 	// Evaluate arguments and bind them to parameters in the new environment
 	for i, param := range procedure.Parameters {
@@ -435,12 +1026,7 @@ func (e *Evaluator) evalProcedure(procedure *ProcedureValue, operands []*ReturnV
 	}
 
 	if procedure.CaneTakeArbitraryParameters() {
-		tailArgs := ListValue{Elements: make([]*ReturnValue, 0)}
-		for i := len(procedure.Parameters); i < len(operands); i++ {
-			tailArgs.Elements = append(tailArgs.Elements, operands[i])
-		}
-
-		newEnv.Put(procedure.OptionalTailParameter, &ReturnValue{Type: ListType, Data: &tailArgs})
+		newEnv.Put(procedure.OptionalTailParameter, makeList(operands[len(procedure.Parameters):]))
 	}
 
 	// declare inner variables first, implement it this way to support below script
@@ -449,11 +1035,15 @@ func (e *Evaluator) evalProcedure(procedure *ProcedureValue, operands []*ReturnV
 	//  (define y (integral dy y0 dt))
 	//  (define dy (stream-map f y))
 	//  y)
-	innerDefines := map[string]*ReturnValue{}
+	var innerDefines []innerDefine
 	for _, expr := range procedure.Body {
 		if d, ok := expr.(*parser.DefineExpression); ok {
+			if placeholderFor(innerDefines, d.Name) != nil {
+				continue
+			}
+			// not Void, the placeholder is set to the value once it's evaluated
 			initValue := &ReturnValue{Type: ConstantType, Data: VoidConst}
-			innerDefines[d.Name] = initValue
+			innerDefines = append(innerDefines, innerDefine{name: d.Name, placeholder: initValue})
 			newEnv.Put(d.Name, initValue)
 		}
 	}
@@ -461,22 +1051,42 @@ func (e *Evaluator) evalProcedure(procedure *ProcedureValue, operands []*ReturnV
 	// Evaluate the body of the procedure in the new environment
 	var result *ReturnValue
 	var err error
-	for _, expr := range procedure.Body {
+	for i, expr := range procedure.Body {
 		if d, ok := expr.(*parser.DefineExpression); ok {
 			// define inner variables
 			result, err = e.eval(d.Value, newEnv)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
-			innerDefines[d.Name].Type = result.Type
-			innerDefines[d.Name].Data = result.Data
+			nameProcedure(result, d.Name)
+			placeholder := placeholderFor(innerDefines, d.Name)
+			placeholder.Type = result.Type
+			placeholder.Data = result.Data
+		} else if i == len(procedure.Body)-1 && e.tracer == nil && e.hook == nil {
+			return e.evalTail(expr, newEnv)
 		} else {
 			result, err = e.eval(expr, newEnv)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 		}
 	}
 
-	return result, nil
+	return result, nil, nil
+}
+
+// innerDefine is the placeholder bound to a define of a procedure body before the body is evaluated,
+// a body defining the same name twice has one placeholder for both.
+type innerDefine struct {
+	name        string
+	placeholder *ReturnValue
+}
+
+func placeholderFor(defines []innerDefine, name string) *ReturnValue {
+	for _, d := range defines {
+		if d.name == name {
+			return d.placeholder
+		}
+	}
+	return nil
 }
@@ -1,8 +1,24 @@
 package evaluator
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ocowchun/soup/lexer"
 	"github.com/ocowchun/soup/parser"
@@ -36,6 +52,9 @@ func TestEvaluator_Builtin_Append(t *testing.T) {
 		{`(append '(1 2) '(3 4))`, `'(1 2 3 4)`},
 		{`(append '(1 2) '(3 4) '(5 6))`, `'(1 2 3 4 5 6)`},
 		{`(append '((1 2) (3 4)) '((5 6) (7 8)))`, `'((1 2) (3 4) (5 6) (7 8))`},
+		{`(append '(1) 2)`, `'(1 . 2)`},
+		{`(append '() '(1))`, `'(1)`},
+		{`(define a (list 1)) (define b (list 2 3)) (define c (append a b)) (list (eq? (cdr c) b) (eq? c a))`, `'(#t #f)`},
 	}
 
 	for _, tt := range tests {
@@ -81,8 +100,9 @@ func TestEvaluator_Builtin_MathOperation(t *testing.T) {
 		{"(* 2 3)", `6`},
 		{"(/ 1)", `1`},
 		{"(/ 2)", `2`},
-		{"(/ 5 10)", `0.5`},
-		{"(/ 2 3)", `0.6666666666666666`},
+		{"(/ 5 10)", `1/2`},
+		{"(/ 2 3)", `2/3`},
+		{"(/ 2. 3)", `0.6666666666666666`},
 		{"(remainder 2 3)", `2`},
 		{"(remainder 12 3)", `0`},
 		{"(remainder 5 3)", `2`},
@@ -99,157 +119,208 @@ func TestEvaluator_Builtin_MathOperation(t *testing.T) {
 	}
 }
 
-func TestEvaluator_Builtin_EqAndCompare(t *testing.T) {
+func TestEvaluator_FloatPrinting(t *testing.T) {
 	tests := []struct {
 		input          string
 		expectedOutput string
 	}{
-		{"(number? 1)", `#t`},
-		{"(number? 'a)", `#f`},
-		{"(symbol? 1)", `#f`},
-		{"(symbol? 'a)", `#t`},
-		{"(string? 1)", `#f`},
-		{"(string? 'a)", `#f`},
-		{"(string? \"foo\")", `#t`},
-		{"(pair? 'a)", `#f`},
-		{"(pair? '(1 2 3))", `#t`},
-		{"(pair? '())", `#f`},
-		{"(pair? (cons 1 2))", `#t`},
-		{"(list? '(1 2 3))", `#t`},
-		{"(list? '())", `#t`},
-		{"(list? (cons 1 2))", `#f`},
-		{"(eq? #t #t)", `#t`},
-		{"(eq? #t #f)", `#f`},
-		{"(eq? #f #t)", `#f`},
-		{"(eq? #f #f)", `#t`},
-		{"(eq? 'a 'a)", `#t`},
-		{"(eq? 1 1)", `#t`},
-		{"(eq? 1 2)", `#f`},
-		{"(eq? '(1 2) '(1 2))", `#f`},
-		{"(eq? '(1) '(1))", `#f`},
-		{"(eq? '() '())", `#t`},
-		{"(equal? 'a 'a)", `#t`},
-		{"(equal? 1 1)", `#t`},
-		{"(equal? 1 2)", `#f`},
-		{"(equal? '(1 2) '(1 2))", `#t`},
-		{"(> 200 10)", `#t`},
-		{"(> 10 10)", `#f`},
-		{"(> 1 2)", `#f`},
-		{"(>= 200 10)", `#t`},
-		{"(>= 10 10)", `#t`},
-		{"(>= 1 2)", `#f`},
-		{"(< 200 10)", `#f`},
-		{"(< 10 10)", `#f`},
-		{"(< 1 2)", `#t`},
-		{"(<= 200 10)", `#f`},
-		{"(<= 10 10)", `#t`},
-		{"(<= 1 2)", `#t`},
-		{"(= 10 10)", `#t`},
-		{"(= 1 2)", `#f`},
-		{"(and 10 12)", `12`},
-		{"(and 10 #f 20)", `#f`},
-		{"(and #f undefined-proc)", `#f`},
-		{"(or 10 12)", `10`},
-		{"(or 10 #f 20)", `10`},
-		{"(or #f #f)", `#f`},
-		{"(or 1 undefined-proc)", `1`},
-		{"(null? 1)", `#f`},
-		{"(null? #f)", `#f`},
-		{"(null? '(1))", `#f`},
-		{"(null? '())", `#t`},
+		{"(/ 1. 3)", "0.3333333333333333"},
+		{"0.1", "0.1"},
+		{"(* 1.5 0.0001)", "0.00015000000000000001"},
+		{"0.00001", "0.00001"},
+		{"123456789.5", "123456789.5"},
+		{"1e21", "1e21"},
+		{"(* 1.5 1e300)", "1.5e300"},
+		{"-2.5e-10", "-2.5e-10"},
+		{"1E3", "1000."},
+		{"(* 2 1.5)", "3."},
+		{"-0.0", "-0."},
 	}
-
 	for _, tt := range tests {
 		ret := testEval(tt.input, t)
 		if ret.String() != tt.expectedOutput {
 			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
 		}
+		// the written number reads back as the same value
+		if back := testEval(ret.String(), t); back.Number().Float64() != ret.Number().Float64() {
+			t.Fatalf("input %s, %s reads back as %s", tt.input, ret.String(), back.String())
+		}
 	}
 }
 
-func TestEvaluator_Builtin_ConOperation(t *testing.T) {
+func TestEvaluator_Exactness(t *testing.T) {
 	tests := []struct {
 		input          string
 		expectedOutput string
 	}{
-		{"(cons 1 2)", `'(1 . 2)`},
-		{"(cons 1 '())", `'(1)`},
-		{"(cons '(1) '(2 3 4))", `'((1) 2 3 4)`},
-		{"(cons \"1\" '(2 3 4))", `'("1" 2 3 4)`},
-		{"(cons '(1 2) 3)", `'((1 2) . 3)`},
-		{"(car (cons 1 2))", `1`},
-		{"(cdr (cons 1 2))", `2`},
-		{"(car '(1 2 3))", `1`},
-		{"(cdr '(1 2 3))", `'(2 3)`},
-		{"(car '((1 2) (3 4)))", `'(1 2)`},
-		{"(cdr '((1 2) (3 4)))", `'((3 4))`},
-		{"(caar '((1 2)))", `1`},
-		{"(cadr '(1 2 3))", `2`},
-		{"(cdar '((1 2) (3 4)))", `'(2)`},
-		{"(caddr '((1 2) (3 4) (5 6)))", `'(5 6)`},
-		{"(cddr '((1 2) (3 4) (5 6)))", `'((5 6))`},
-		{"(cdddr '((1 2) (3 4) (5 6) (7 8)))", `'((7 8))`},
-		{"(caadr '(1 (2 3)))", `2`},
-		{"(cdadr '(1 (2 3)))", `'(3)`},
-		{"(cadddr '((1 2) (3 4) (5 6) (7 8)))", `'(7 8)`},
-		{`(define l (list 1 2 3)) (set-car! l 4) l`, `'(4 2 3)`},
-		{`(define l (list 1 2 3)) (set-cdr! l 4) l`, `'(1 . 4)`},
-		{`(cons 'quote (cons 'a '()))`, `''a`},
+		{"(exact? (+ 1 2))", "#t"},
+		{"(exact? (* 2 3 4))", "#t"},
+		{"(exact? (- 10 3 2))", "#t"},
+		{"(exact? (/ 6 3))", "#t"},
+		{"(inexact? (/ 1. 2))", "#t"},
+		{"(inexact? (+ 1 2.))", "#t"},
+		{"(+ 1 2.)", "3."},
+		{"(* 2 0.5)", "1."},
+		{"(list (exact? 1) (exact? 1.) (inexact? 1) (inexact? 1.5))", "'(#t #f #f #t)"},
+		{"(list (sqrt 16) (exact? (sqrt 16)) (exact? (sqrt 16.)) (exact? (sqrt 2)))", "'(4 #t #f #f)"},
+		{"(exact->inexact 3)", "3."},
+		{"(exact->inexact 2.5)", "2.5"},
+		{"(inexact->exact 4.)", "4"},
+		{"(inexact->exact 4)", "4"},
+		{"(inexact->exact 0.5)", "1/2"},
+		{"(list (exact 2.) (inexact 2))", "'(2 2.)"},
+		{"(+ 9223372036854775807 1)", "9223372036854775808"},
+		{"(exact? (- -9223372036854775807 1))", "#t"},
+		{"(* 4611686018427387904 2)", "9223372036854775808"},
+		{"(exact? (* -1 -9223372036854775808))", "#t"},
+		{"(= 9007199254740993 9007199254740992)", "#f"},
+		{"(< 9007199254740992 9007199254740993)", "#t"},
+		{"(/ 1. 0)", "+inf.0"},
+		{"(list (- 0.) (- 2) (exact? (- -9223372036854775808)))", "'(-0. -2 #t)"},
+		// at the ends of int64, exact integers stay exact past them and come back inside
+		{"(list 9223372036854775807 -9223372036854775808 (exact? 9223372036854775807) (exact? -9223372036854775808))", "'(9223372036854775807 -9223372036854775808 #t #t)"},
+		{"(- -9223372036854775808 1)", "-9223372036854775809"},
+		{"(* 9223372036854775807 9223372036854775807)", "85070591730234615847396907784232501249"},
+		{"(/ -9223372036854775808 -1)", "9223372036854775808"},
+		{"(abs -9223372036854775808)", "9223372036854775808"},
+		{"(- (+ 9223372036854775807 1) 1)", "9223372036854775807"},
+		{"(list (exact-integer? (+ 9223372036854775807 1)) (integer? (+ 9223372036854775807 1)) (exact-integer? (- (+ 9223372036854775807 1) 1)))", "'(#t #t #t)"},
+		{"(list (quotient (+ 9223372036854775807 1) 2) (remainder (- -9223372036854775808 1) 10) (modulo (- -9223372036854775808 1) 10))", "'(4611686018427387904 -9 1)"},
+		{"(/ (+ 9223372036854775807 1) 3)", "9223372036854775808/3"},
+		{"99999999999999999999", "99999999999999999999"},
+		{"(exact? 99999999999999999999)", "#t"},
+		{"(+ 9223372036854775807 1.)", "9223372036854776000."},
+		{"(list (inexact (+ 9223372036854775807 1)) (inexact? (inexact 9223372036854775807)))", "'(9223372036854776000. #t)"},
+		{"(list (exact 9223372036854775808.) (exact 1e19) (exact -1e19))", "'(9223372036854775808 10000000000000000000 -10000000000000000000)"},
+		{"(list (< 9223372036854775807 (+ 9223372036854775807 1)) (> -9223372036854775808 (- -9223372036854775808 1)))", "'(#t #t)"},
+		{"(list (= (+ 9223372036854775807 1) 9223372036854775808.) (eqv? (+ 9223372036854775807 1) 9223372036854775808.) (eqv? (+ 9223372036854775807 1) (* 4611686018427387904 2)))", "'(#t #f #t)"},
 	}
-
 	for _, tt := range tests {
 		ret := testEval(tt.input, t)
 		if ret.String() != tt.expectedOutput {
 			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
 		}
 	}
+
+	errorTests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"(/ 1 0)", "'/' division by zero"},
+		{"(exact (/ 1. 0))", "'exact' expected a number with an exact value, got +inf.0"},
+		{"(exact (/ 0. 0))", "'exact' expected a number with an exact value, got +nan.0"},
+		{"(quotient (+ 9223372036854775807 1) 0)", "'quotient' division by zero"},
+		{"(/ (+ 9223372036854775807 1) 0)", "'/' division by zero"},
+		{"(exact? 'a)", "'exact?' expected a number, got Symbol"},
+		{"(exact->inexact)", "'exact->inexact' has been called with 0 arguments; it requires exactly 1 argument"},
+		{`(substring "abc" 1.)`, "'substring' expected a non-negative integer index, got 1."},
+	}
+	for _, tt := range errorTests {
+		err := testEvalError(tt.input, t)
+		if !strings.Contains(err.Error(), tt.expectedMessage) {
+			t.Fatalf("input %s, expected %q, got %q", tt.input, tt.expectedMessage, err)
+		}
+	}
 }
 
-func TestEvaluator_Builtin_Assoc(t *testing.T) {
+func TestEvaluator_Rationals(t *testing.T) {
 	tests := []struct {
 		input          string
 		expectedOutput string
 	}{
-		{"(assoc 1 (list '(3 2) '(2 1) '(1 9) ))", `'(1 9)`},
-		{"(assoc 5 (list '(3 2) '(2 1) '(1 9) ))", `#f`},
-		{"(assoc 1 (cons (cons 1 2) (cons (cons 2 3) '())))", `'(1 . 2)`},
-		{"(assoc 5 (cons (cons 1 2) (cons (cons 2 3) '())))", `#f`},
+		{"(list 1/3 -7/2 +6/3 10/4)", "'(1/3 -7/2 2 5/2)"},
+		{"(list (/ 1 3) (/ 6 4) (/ -6 4) (/ 6 -4) (/ 2))", "'(1/3 3/2 -3/2 -3/2 2)"},
+		{"(list (+ 1/2 1/3) (- 1/2 1/3) (* 2/3 3/4) (/ 1/2 1/4))", "'(5/6 1/6 1/2 2)"},
+		{"(list (+ 1/2 1/2) (* 1/3 3) (- 1/2 1/2))", "'(1 1 0)"},
+		{"(exact? (+ 1/2 1/2))", "#t"},
+		{"(list (+ 1/2 0.5) (* 1/4 2.))", "'(1. 0.5)"},
+		{"(list (< 1/3 1/2) (= 1/2 2/4) (= 1/2 0.5) (> -1/2 -1) (<= 1/3 0.3))", "'(#t #t #t #t #f)"},
+		{"(list (eqv? 1/2 2/4) (eqv? 1/2 0.5) (equal? '(1/2) (list (/ 1 2))))", "'(#t #f #t)"},
+		{"(list (exact? 1/3) (inexact? 1/3) (integer? 1/3) (rational? 1/3) (exact-integer? 4/2))", "'(#t #f #f #t #t)"},
+		{"(list (inexact->exact 0.5) (inexact->exact -0.25) (exact->inexact 1/4) (exact 1.5))", "'(1/2 -1/4 0.25 3/2)"},
+		{"(list (floor 7/2) (floor -7/2) (ceiling 7/2) (ceiling -7/2) (truncate -7/2))", "'(3 -4 4 -3 -3)"},
+		{"(list (round 7/2) (round 5/2) (round -5/2) (round 7/3))", "'(4 2 -2 2)"},
+		{"(list (abs -1/2) (- 1/2) (sqrt 1/4) (sqrt 4/9))", "'(1/2 -1/2 1/2 2/3)"},
+		{"(list (numerator 6/4) (denominator 6/4) (numerator -3) (denominator -3))", "'(3 2 -3 1)"},
+		{"(list (numerator 0.75) (denominator 0.75))", "'(3. 4.)"},
+		{"(list (positive? 1/2) (negative? -1/2) (zero? (- 1/3 1/3)))", "'(#t #t #t)"},
+		{"'(1/2 #e1.5 #i1/4)", "'(1/2 3/2 0.25)"},
+		{`(read-from-string "-7/2")`, "-7/2"},
+		{"(/ 1/2 0.)", "+inf.0"},
 	}
-
 	for _, tt := range tests {
 		ret := testEval(tt.input, t)
 		if ret.String() != tt.expectedOutput {
 			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
 		}
 	}
+
+	errorTests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"(/ 1/2 0)", "'/' division by zero"},
+		{"(quotient 1/2 1)", "'quotient' expected an integer, got 1/2"},
+		{"(even? 1/2)", "'even?' expected an integer, got 1/2"},
+		{"(numerator (/ 0. 0))", "'numerator' expected a rational number, got +nan.0"},
+		{`(substring "abc" 1/2)`, "'substring' expected a non-negative integer index, got 1/2"},
+	}
+	for _, tt := range errorTests {
+		err := testEvalError(tt.input, t)
+		if !strings.Contains(err.Error(), tt.expectedMessage) {
+			t.Fatalf("input %s, expected %q, got %q", tt.input, tt.expectedMessage, err)
+		}
+	}
 }
 
-func TestEvaluator_Builtin_Random(t *testing.T) {
+func TestEvaluator_SpecialFloats(t *testing.T) {
 	tests := []struct {
 		input          string
 		expectedOutput string
 	}{
-		{"(random 100)", `44`},
-		{"(random 100.0)", `15.44158638468602`},
+		{"(list +inf.0 -inf.0 +nan.0 -nan.0 #i+inf.0)", "'(+inf.0 -inf.0 +nan.0 +nan.0 +inf.0)"},
+		{"(list (/ 1 0.) (/ -1 0.) (/ 0 0.) (- +inf.0 +inf.0))", "'(+inf.0 -inf.0 +nan.0 +nan.0)"},
+		{"(list (< +nan.0 1) (> +nan.0 1) (= +nan.0 +nan.0) (<= -inf.0 +nan.0))", "'(#f #f #f #f)"},
+		{"(list (< -inf.0 -1e308) (> +inf.0 9223372036854775807) (= +inf.0 (* 2 +inf.0)))", "'(#t #t #t)"},
+		{"(list (eqv? +nan.0 +nan.0) (equal? '(+inf.0) (list (/ 1. 0))) (eqv? +inf.0 -inf.0))", "'(#t #t #f)"},
+		{"(list (real? +nan.0) (rational? +inf.0) (integer? -inf.0) (inexact? +nan.0))", "'(#t #f #f #t)"},
+		{"(list (zero? +nan.0) (positive? +inf.0) (negative? -inf.0))", "'(#f #t #t)"},
+		{"(guard (e ((division-by-zero-error? e) (error-object-message e))) (/ 1 0))", `"'/' division by zero"`},
+		{`(read-from-string "-inf.0")`, "-inf.0"},
 	}
-
 	for _, tt := range tests {
 		ret := testEval(tt.input, t)
 		if ret.String() != tt.expectedOutput {
 			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
 		}
 	}
+
+	errorTests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"(exact +nan.0)", "'exact' expected a number with an exact value, got +nan.0"},
+		{"(inexact->exact -inf.0)", "'inexact->exact' expected a number with an exact value, got -inf.0"},
+	}
+	for _, tt := range errorTests {
+		err := testEvalError(tt.input, t)
+		if !strings.Contains(err.Error(), tt.expectedMessage) {
+			t.Fatalf("input %s, expected %q, got %q", tt.input, tt.expectedMessage, err)
+		}
+	}
 }
 
-func TestEvaluator_Procedure(t *testing.T) {
+func TestEvaluator_PrefixedNumbers(t *testing.T) {
 	tests := []struct {
 		input          string
 		expectedOutput string
 	}{
-		{"(define (add a b) (+ a b)) (add 1 2)", `3`},
-		{"(define (increment a) (+ a 1)) (increment 1)", `2`},
+		{"(list #x1F #b1010 #o17 #d10 #x-ff)", "'(31 10 15 10 -255)"},
+		{"(list (exact? #e2.) (exact? #i3) #i3 #e1e2)", "'(#t #f 3. 100)"},
+		{"(+ #x10 #b1)", "17"},
+		{"'(#x10 #i#b11)", "'(16 3.)"},
+		{`(read-from-string "#o777")`, "511"},
 	}
-
 	for _, tt := range tests {
 		ret := testEval(tt.input, t)
 		if ret.String() != tt.expectedOutput {
@@ -258,122 +329,3976 @@ func TestEvaluator_Procedure(t *testing.T) {
 	}
 }
 
-func TestEvaluator_DelayAndForce(t *testing.T) {
+func TestEvaluator_IntegerDivision(t *testing.T) {
 	tests := []struct {
 		input          string
 		expectedOutput string
 	}{
-		{"(delay (+ 1 2))", `<promise>`},
-		{"(force (delay (+ 1 2)))", `3`},
+		{"(list (quotient 17 5) (quotient -17 5) (quotient 17 -5))", "'(3 -3 -3)"},
+		{"(list (modulo 13 4) (modulo -13 4) (modulo 13 -4) (modulo -13 -4))", "'(1 3 -3 -1)"},
+		{"(list (remainder 13 4) (remainder -13 4) (remainder 13 -4) (remainder -13 -4))", "'(1 -1 1 -1)"},
+		{"(list (quotient 17. 5) (modulo -7 2.))", "'(3. 1.)"},
+		{"(modulo -9223372036854775808 -1)", "0"},
+		{"(quotient -9223372036854775808 -1)", "9223372036854775808"},
+		{"(list (floor 2.5) (floor -2.5) (ceiling 2.5) (ceiling -2.5) (truncate 2.7) (truncate -2.7))", "'(2. -3. 3. -2. 2. -2.)"},
+		{"(list (round 2.5) (round 3.5) (round -2.5) (round 2.6))", "'(2. 4. -2. 3.)"},
+		{"(list (floor 7) (ceiling 7) (truncate 7) (round 7))", "'(7 7 7 7)"},
+		{"(exact? (round 7))", "#t"},
+		{"(list (gcd) (gcd 12) (gcd -12) (gcd 12 18) (gcd 12 -18 8) (gcd 0 5))", "'(0 12 12 6 2 5)"},
+		{"(list (lcm) (lcm 4 6) (lcm -4 6) (lcm 3 4 6) (lcm 0 5) (lcm 0 0))", "'(1 12 12 12 0 0)"},
+		{"(list (gcd 12. 18) (lcm 4. 6))", "'(6. 12.)"},
 	}
-
 	for _, tt := range tests {
 		ret := testEval(tt.input, t)
 		if ret.String() != tt.expectedOutput {
 			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
 		}
 	}
+
+	errorTests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"(quotient 1 0)", "'quotient' division by zero"},
+		{"(modulo 1 0.)", "'modulo' division by zero"},
+		{"(quotient 1.5 1)", "'quotient' expected an integer, got 1.5"},
+		{"(modulo 'a 1)", "'modulo' expected an integer, got Symbol"},
+		{"(gcd 2 0.5)", "'gcd' expected an integer, got 0.5"},
+		{"(quotient 1)", "'quotient' has been called with 1 arguments; it requires exactly 2 arguments"},
+		{"(floor 'a)", "'floor' expected a number, got Symbol"},
+		{"(round)", "'round' has been called with 0 arguments; it requires exactly 1 argument"},
+	}
+	for _, tt := range errorTests {
+		err := testEvalError(tt.input, t)
+		if !strings.Contains(err.Error(), tt.expectedMessage) {
+			t.Fatalf("input %s, expected %q, got %q", tt.input, tt.expectedMessage, err)
+		}
+	}
 }
 
-func TestEvaluator_Stream(t *testing.T) {
+func TestEvaluator_NumericPredicates(t *testing.T) {
 	tests := []struct {
 		input          string
 		expectedOutput string
 	}{
-		{"(cons-stream 1 2)", `'(1 . <promise>)`},
-		{"(car (cons-stream 1 2))", `1`},
-		{"(cdr (cons-stream 1 2))", `<promise>`},
-		{"(stream-car (cons-stream 1 2))", `1`},
-		{"(stream-cdr (cons-stream 1 2))", `2`},
-		{"(stream-null? (cons-stream 1 2))", `#f`},
-		{"(stream-null? '())", `#t`},
+		{"(list (zero? 0) (zero? 0.) (zero? -0.) (zero? 1) (zero? -0.5))", "'(#t #t #t #f #f)"},
+		{"(list (positive? 2) (positive? 0) (positive? -1) (positive? 0.5))", "'(#t #f #f #t)"},
+		{"(list (negative? -2) (negative? 0) (negative? 1) (negative? -0.5))", "'(#t #f #f #t)"},
+		{"(list (even? 0) (even? 4) (even? -2) (even? 3) (even? 4.))", "'(#t #t #t #f #t)"},
+		{"(list (odd? 1) (odd? -3) (odd? 4) (odd? 3.))", "'(#t #t #f #t)"},
+		{"(list (integer? 2) (integer? 2.) (integer? 2.5) (integer? (/ 1. 0)) (integer? 'a))", "'(#t #t #f #f #f)"},
+		{"(list (rational? 1) (rational? 1.5) (rational? (/ 1. 0)) (rational? (/ 0. 0)) (rational? \"1\"))", "'(#t #t #f #f #f)"},
+		{"(list (real? 1) (real? 1.5) (real? 'a))", "'(#t #t #f)"},
+		{"(list (exact-integer? 2) (exact-integer? 2.) (exact-integer? '(2)))", "'(#t #f #f)"},
 	}
-
 	for _, tt := range tests {
 		ret := testEval(tt.input, t)
 		if ret.String() != tt.expectedOutput {
 			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
 		}
 	}
+
+	errorTests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"(zero? 'a)", "'zero?' expected a number, got Symbol"},
+		{"(positive?)", "'positive?' has been called with 0 arguments; it requires exactly 1 argument"},
+		{"(even? 1.5)", "'even?' expected an integer, got 1.5"},
+		{"(odd? 1 2)", "'odd?' has been called with 2 arguments; it requires exactly 1 argument"},
+		{"(integer? 1 2)", "'integer?' has been called with 2 arguments; it requires exactly 1 argument"},
+	}
+	for _, tt := range errorTests {
+		err := testEvalError(tt.input, t)
+		if !strings.Contains(err.Error(), tt.expectedMessage) {
+			t.Fatalf("input %s, expected %q, got %q", tt.input, tt.expectedMessage, err)
+		}
+	}
 }
 
-func TestEvaluator_Apply(t *testing.T) {
+func TestEvaluator_Strings(t *testing.T) {
 	tests := []struct {
 		input          string
 		expectedOutput string
 	}{
-		{"(apply + '(1 2 3))", `6`},
+		{`(string-length "hello")`, "5"},
+		{`(string-length "héllo, 世界")`, "9"},
+		{`(string-length "")`, "0"},
+		{`(substring "héllo, 世界" 1 4)`, `"éll"`},
+		{`(substring "héllo, 世界" 7)`, `"世界"`},
+		{`(substring "abc" 3 3)`, `""`},
+		{`(substring "abc" 0 (- (string-length "abc") 1))`, `"ab"`},
+		{`(string=? "é" "é" "é")`, "#t"},
+		{`(string=? "a" "b")`, "#f"},
+		{`(string<? "a" "b" "c")`, "#t"},
+		{`(string<? "a" "c" "b")`, "#f"},
+		{`(string<? "z" "é")`, "#t"},
+		{`(string>? "b" "a")`, "#t"},
+		{`(string<=? "a" "a" "b")`, "#t"},
+		{`(string>=? "a" "b")`, "#f"},
+		{`(define (s) "same") (eq? (s) (s))`, "#t"},
+		{`(string-ref "héllo" 1)`, `#\é`},
+		{`(string-append "héllo" ", " "世界")`, `"héllo, 世界"`},
+		{`(string-append)`, `""`},
+		{`(string-copy "héllo")`, `"héllo"`},
+		{`(string-copy "héllo" 1)`, `"éllo"`},
+		{`(string-copy "héllo" 1 3)`, `"él"`},
+		{`(make-string 3 #\λ)`, `"λλλ"`},
+		{`(make-string 2)`, `"  "`},
+		{`(make-string 0 #\a)`, `""`},
+		{`(string->list "héllo")`, `'(#\h #\é #\l #\l #\o)`},
+		{`(string->list "héllo" 3)`, `'(#\l #\o)`},
+		{`(string->list "abc" 1 2)`, `'(#\b)`},
+		{`(string->list "")`, `'()`},
+		{`(list->string (list #\a #\space #\é))`, `"a é"`},
+		{`(list->string '())`, `""`},
+		{`(list->string (string->list "世界"))`, `"世界"`},
+		{`(string-contains "héllo, world" "o")`, "4"},
+		{`(string-contains "héllo" "")`, "0"},
+		{`(string-contains "héllo" "x")`, "#f"},
+		{`(string-index "héllo" #\l)`, "2"},
+		{`(string-index "ab1c" (lambda (c) (eqv? c #\1)))`, "2"},
+		{`(string-index "abc" char?)`, "0"},
+		{`(string-index "" #\a)`, "#f"},
+		{`(string-split "a,b,,c" #\,)`, `'("a" "b" "" "c")`},
+		{`(string-split "a::b" "::")`, `'("a" "b")`},
+		{`(string-split "  one two\tthree\n")`, `'("one" "two" "three")`},
+		{`(string-split "")`, `'()`},
+		{`(string-join (list "a" "b" "c"))`, `"a b c"`},
+		{`(string-join (string-split "a,b" #\,) ", ")`, `"a, b"`},
+		{`(string-join '())`, `""`},
+		{`(string-trim "  héllo \n")`, `"héllo"`},
+		{`(string-trim "")`, `""`},
+		{`(define s (make-string 3 #\a)) (string-set! s 1 #\λ) s`, `"aλa"`},
+		{`(define s (string-copy "héllo")) (define t s) (string-set! t 0 #\j) (list s (eq? s t))`, `'("jéllo" #t)`},
+		{`(define s (string-copy "abc")) (define t (string-copy s)) (string-set! t 0 #\x) (list s t (eq? s t) (equal? s "abc"))`, `'("abc" "xbc" #f #t)`},
+		{`(define s (string-append "ab" "cd")) (string-fill! s #\é) s`, `"éééé"`},
+		{`(define s (string-copy "abcd")) (string-fill! s #\- 1 3) s`, `"a--d"`},
+		{`(define (f) (let ((s (string-copy "ab"))) (string-set! s 0 #\x) s)) (f) (list (f) "ab")`, `'("xb" "ab")`},
+		{`(define v (vector (string-copy "ab"))) (string-set! (vector-ref v 0) 1 #\z) v`, `'#("az")`},
+		{`(define (f) (define t (string-copy "abc")) (define s t) (string-set! t 0 #\x) (list s (eq? s t) (eqv? s t))) (f)`, `'("xbc" #t #t)`},
+		{`(define (f) (define t (make-string 2 #\a)) (define s t) (string-fill! s #\b) t) (f)`, `"bb"`},
+		{`(eq? (string-copy "a") (string-copy "a"))`, "#f"},
 	}
-
 	for _, tt := range tests {
 		ret := testEval(tt.input, t)
 		if ret.String() != tt.expectedOutput {
 			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
 		}
 	}
+
+	errorTests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{`(substring "héllo" 2 6)`, "'substring' range 2 to 6 is out of range for a string of length 5"},
+		{`(substring "abc" 2 1)`, "'substring' range 2 to 1 is out of range for a string of length 3"},
+		{`(substring "abc" -1)`, "'substring' expected a non-negative integer index, got -1"},
+		{`(string-length 'abc)`, "'string-length' expected a string, got Symbol"},
+		{`(string<? "a" 1)`, "'string<?' expected a string, got Number"},
+		{`(string-ref "héllo" 5)`, "'string-ref' index 5 is out of range for a string of length 5"},
+		{`(string-ref "abc" 1.5)`, "'string-ref' expected a non-negative integer index, got 1.5"},
+		{`(string-append "a" 'b)`, "'string-append' expected a string, got Symbol"},
+		{`(string-copy "abc" 2 4)`, "'string-copy' range 2 to 4 is out of range for a string of length 3"},
+		{`(string-copy)`, "'string-copy' has been called with 0 arguments; it requires 1 to 3 arguments"},
+		{`(make-string 2 "a")`, "'make-string' expected a character, got String"},
+		{`(make-string -1)`, "'make-string' expected a non-negative integer index, got -1"},
+		{`(string->list "abc" 4)`, "'string->list' range 4 to 3 is out of range for a string of length 3"},
+		{`(list->string "abc")`, "'list->string' expected a list, got String"},
+		{`(list->string '(#\a b))`, "'list->string' expected a list of characters, got 'b"},
+		{`(string-contains "abc" #\a)`, "'string-contains' expected a string, got Char"},
+		{`(string-index "" "a")`, "'string-index' expected a character or a procedure, got String"},
+		{`(string-split "abc" "")`, "'string-split' expected a character or a non-empty string, got \"\""},
+		{`(string-join (list "a" 1))`, "'string-join' expected a list of strings, got 1"},
+		{`(string-join "a")`, "'string-join' expected a list, got String"},
+		{`(string-trim 'a)`, "'string-trim' expected a string, got Symbol"},
+		{`(string-set! "abc" 0 #\x)`, "'string-set!' cannot modify a string literal: \"abc\""},
+		{`(string-fill! (car '("ab")) #\x)`, "'string-fill!' cannot modify a string literal: \"ab\""},
+		{`(string-set! (make-string 2) 2 #\x)`, "'string-set!' index 2 is out of range for a string of length 2"},
+		{`(string-set! (make-string 2) 0 "x")`, "'string-set!' expected a character, got String"},
+		{`(string-fill! (make-string 2) #\x 1 3)`, "'string-fill!' range 1 to 3 is out of range for a string of length 2"},
+	}
+	for _, tt := range errorTests {
+		if err := testEvalError(tt.input, t); err.Error() != tt.expectedMessage {
+			t.Fatalf("input %s, expected the error %q, got %q", tt.input, tt.expectedMessage, err.Error())
+		}
+	}
 }
 
-func TestEvaluator_Length(t *testing.T) {
+func TestEvaluator_Chars(t *testing.T) {
 	tests := []struct {
 		input          string
 		expectedOutput string
 	}{
-		{"(length '(1 2 3))", `3`},
+		{`#\a`, `#\a`},
+		{`#\x41`, `#\A`},
+		{`'(#\( #\space #\newline)`, `'(#\( #\space #\newline)`},
+		{`#(#\a "a")`, `'#(#\a "a")`},
+		{`(char? #\a)`, "#t"},
+		{`(char? "a")`, "#f"},
+		{`(char->integer #\λ)`, "955"},
+		{`(integer->char 97)`, `#\a`},
+		{`(eqv? #\a (string-ref "abc" 0))`, "#t"},
+		{`(eqv? #\a #\b)`, "#f"},
+		{`(equal? (list #\a) '(#\a))`, "#t"},
+		{`(write-to-string (list #\a #\space))`, `"(#\\a #\\space)"`},
+		{`(read-from-string "#\\space")`, `#\space`},
+		{`(define t (make-hash-table eqv?)) (hash-table-set! t #\a 1) (hash-table-ref t (string-ref "a" 0))`, "1"},
+		{`(define-syntax first-char (syntax-rules () ((_ c) (if (char? c) c #\?)))) (list (first-char #\z) (first-char 1))`, `'(#\z #\?)`},
+	}
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+
+	var out strings.Builder
+	e := New(WithStdin(strings.NewReader("")), WithStdout(&out))
+	program, _ := parser.New(lexer.New(strings.NewReader(`(display #\a) (display (list #\b #\space))`))).Parse()
+	if _, err := e.Eval(program); err != nil {
+		t.Fatalf("unexpected error: %v", err)
 	}
+	if out.String() != `a'(#\b #\space)` {
+		t.Fatalf("expected display to write the character itself, got %q", out.String())
+	}
+
+	errorTests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{`(char->integer "a")`, "'char->integer' expected a character, got String"},
+		{`(integer->char 55296)`, "'integer->char' expected a Unicode code point, got 55296"},
+		{`(integer->char -1)`, "'integer->char' expected a non-negative integer index, got -1"},
+	}
+	for _, tt := range errorTests {
+		if err := testEvalError(tt.input, t); err.Error() != tt.expectedMessage {
+			t.Fatalf("input %s, expected the error %q, got %q", tt.input, tt.expectedMessage, err.Error())
+		}
+	}
+}
 
+func TestEvaluator_Vectors(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{"(vector 1 'a \"b\" (list 2))", `'#(1 a "b" (2))`},
+		{"(list (vector) (make-vector 2) (make-vector 3 'x))", "'(#() #(0 0) #(x x x))"},
+		{"(define v (vector 1 2 3)) (vector-set! v 0 'a) v", "'#(a 2 3)"},
+		{"(define v (make-vector 3 0)) (vector-set! v 2 (vector-ref v 0)) (list (vector-ref v 2) (vector-length v))", "'(0 3)"},
+		{"(define v (make-vector 4 0)) (vector-fill! v 'z) v", "'#(z z z z)"},
+		{"(define v (make-vector 4 0)) (vector-fill! v 'z 1 3) v", "'#(0 z z 0)"},
+		{"(list (vector? (vector)) (vector? (list)) (vector? \"abc\"))", "'(#t #f #f)"},
+		{"(list (equal? (vector 1 (vector 2)) (vector 1 (vector 2))) (eqv? (vector) (vector)) (equal? (vector 1) (list 1)))", "'(#t #f #f)"},
+		{"(define v (vector 1)) (eqv? v v)", "#t"},
+		{"(write-to-string (vector 'a \"b\" 1.5))", `"#(a \"b\" 1.5)"`},
+		{"(define (f) (vector 1 2)) (define a (f)) (vector-set! a 0 9) (f)", "'#(1 2)"},
+		{"(guard (e ((index-out-of-range-error? e) 'out)) (vector-ref (vector 1 2) 2))", "'out"},
+	}
 	for _, tt := range tests {
 		ret := testEval(tt.input, t)
 		if ret.String() != tt.expectedOutput {
 			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
 		}
 	}
+
+	errorTests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"(vector-ref (vector 1 2) 2)", "'vector-ref' index 2 is out of range for a vector of length 2"},
+		{"(vector-ref (list 1 2) 0)", "'vector-ref' expected a vector, got Cons"},
+		{"(vector-set! (vector 1) -1 0)", "'vector-set!' expected a non-negative integer index, got -1"},
+		{"(vector-ref (vector 1) 1/2)", "'vector-ref' expected a non-negative integer index, got 1/2"},
+		{"(make-vector 'a)", "'make-vector' expected a non-negative integer index, got 'a"},
+		{"(vector-fill! (vector 1 2) 0 2 1)", "'vector-fill!' range 2 to 1 is out of range for a vector of length 2"},
+		{"(vector-length)", "'vector-length' has been called with 0 arguments; it requires exactly 1 argument"},
+	}
+	for _, tt := range errorTests {
+		err := testEvalError(tt.input, t)
+		if !strings.Contains(err.Error(), tt.expectedMessage) {
+			t.Fatalf("input %s, expected %q, got %q", tt.input, tt.expectedMessage, err)
+		}
+	}
 }
 
-func TestEvaluator_Read(t *testing.T) {
+func TestEvaluator_Bytevectors(t *testing.T) {
 	tests := []struct {
-		stdinInput     string
+		input          string
 		expectedOutput string
 	}{
-		{"(1 2 3)", `'(1 2 3)`},
-		{"1", `1`},
-		{"foo", `'foo`},
-		{"(1 2 3 (4 5 6))", `'(1 2 3 (4 5 6))`},
-		{"'a", `''a`},
-		{`'(123)`, `''(123)`},
-		{`(append '(a b c) '(d e f))`, `'(append '(a b c) '(d e f))`},
+		{`(bytevector 1 2 255)`, "#u8(1 2 255)"},
+		{`(bytevector)`, "#u8()"},
+		{`(make-bytevector 3)`, "#u8(0 0 0)"},
+		{`(make-bytevector 2 7)`, "#u8(7 7)"},
+		{`(list (bytevector 1) (vector (bytevector)))`, "'(#u8(1) #(#u8()))"},
+		{`(bytevector? (bytevector))`, "#t"},
+		{`(bytevector? (vector))`, "#f"},
+		{`(bytevector-length (make-bytevector 5))`, "5"},
+		{`(bytevector-u8-ref (bytevector 1 2 3) 2)`, "3"},
+		{`(define b (make-bytevector 2)) (bytevector-u8-set! b 1 200) b`, "#u8(0 200)"},
+		{`(define b (bytevector 1)) (define c b) (bytevector-u8-set! c 0 9) b`, "#u8(9)"},
+		{`(equal? (bytevector 1 2) (bytevector 1 2))`, "#t"},
+		{`(equal? (bytevector 1 2) (bytevector 1))`, "#f"},
+		{`(eqv? (bytevector 1) (bytevector 1))`, "#f"},
+		{`(define b (bytevector)) (eqv? b b)`, "#t"},
+		{`(string->utf8 "héllo")`, "#u8(104 195 169 108 108 111)"},
+		{`(string->utf8 "héllo" 1 2)`, "#u8(195 169)"},
+		{`(utf8->string (bytevector 104 195 169))`, `"hé"`},
+		{`(utf8->string (string->utf8 "héllo, 世界") 8)`, `"世界"`},
+		{`(utf8->string (bytevector 104 255 105))`, "\"h\ufffdi\""},
+		{`(utf8->string (bytevector 97 98 99) 1 2)`, `"b"`},
+		{`(define t (make-hash-table)) (hash-table-set! t (bytevector 1 2) 'a) (hash-table-ref t (bytevector 1 2))`, "'a"},
+		{`(define t (make-hash-table eqv?)) (hash-table-set! t (bytevector 1 2) 'a) (hash-table-ref t (bytevector 1 2) 'none)`, "'none"},
+	}
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+
+	errorTests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{`(bytevector 1 256)`, "'bytevector' expected a byte, got 256"},
+		{`(bytevector 1.0)`, "'bytevector' expected a byte, got 1."},
+		{`(make-bytevector 2 -1)`, "'make-bytevector' expected a byte, got -1"},
+		{`(make-bytevector -1)`, "'make-bytevector' expected a non-negative integer index, got -1"},
+		{`(bytevector-u8-ref (bytevector 1) 1)`, "'bytevector-u8-ref' index 1 is out of range for a bytevector of length 1"},
+		{`(bytevector-u8-set! (bytevector 1) 0 'a)`, "'bytevector-u8-set!' expected a byte, got 'a"},
+		{`(bytevector-length (vector))`, "'bytevector-length' expected a bytevector, got Vector"},
+		{`(utf8->string (bytevector 1) 0 2)`, "'utf8->string' range 0 to 2 is out of range for a bytevector of length 1"},
+		{`(utf8->string "a")`, "'utf8->string' expected a bytevector, got String"},
+		{`(string->utf8 (bytevector))`, "'string->utf8' expected a string, got Bytevector"},
 	}
+	for _, tt := range errorTests {
+		if err := testEvalError(tt.input, t); err.Error() != tt.expectedMessage {
+			t.Fatalf("input %s, expected the error %q, got %q", tt.input, tt.expectedMessage, err.Error())
+		}
+	}
+}
 
+func TestEvaluator_VectorLiterals(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{"#(1 a \"b\" (c d) #(2))", `'#(1 a "b" (c d) #(2))`},
+		{"(list '#(1 a) #() (vector? #(1)) (vector? '#(1)))", "'(#(1 a) #() #t #t)"},
+		{"(let ((x 1)) #(x (+ x 1)))", "'#(x (+ x 1))"},
+		{"(list (vector-ref #(1 2 3) 2) (vector-length '#(a b)) (equal? #(1 (2)) (vector 1 (list 2))))", "'(3 2 #t)"},
+		{"(define (f) #(1 2)) (eqv? (f) (f))", "#t"},
+		{"(car '(#(1) 2))", "'#(1)"},
+		{`(read-from-string "#(1 #(a) \"b\")")`, `'#(1 #(a) "b")`},
+		{`(define v (read-from-string "#(1 2)")) (vector-set! v 0 'a) v`, "'#(a 2)"},
+		{"(define-syntax pair-vector (syntax-rules () ((_ a b) #(a b)))) (pair-vector 1 2)", "'#(1 2)"},
+		{"(define-syntax vector-sum (syntax-rules () ((_ #(a b)) (+ a b)) ((_ x) 'other))) (list (vector-sum #(1 2)) (vector-sum (1 2)))", "'(3 other)"},
+	}
 	for _, tt := range tests {
-		l := lexer.New(strings.NewReader("(read)"))
-		p := parser.New(l)
-		program, err := p.Parse()
-		if err != nil {
-			t.Fatalf("unexpected error: %v", err)
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
 		}
+	}
 
-		evaluator := New(strings.NewReader(tt.stdinInput))
-		ret, err := evaluator.Eval(program)
-		if err != nil {
-			t.Fatalf("stdinInput %s unexpected error: %v", tt.stdinInput, err)
+	errorTests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"(vector-set! #(1 2) 0 'a)", "'vector-set!' cannot modify a vector literal: '#(1 2)"},
+		{"(vector-fill! '#(1 2) 0)", "'vector-fill!' cannot modify a vector literal: '#(1 2)"},
+		{`(read-from-string "#(1 . 2)")`, "unexpected '.' in a vector"},
+	}
+	for _, tt := range errorTests {
+		err := testEvalError(tt.input, t)
+		if !strings.Contains(err.Error(), tt.expectedMessage) {
+			t.Fatalf("input %s, expected %q, got %q", tt.input, tt.expectedMessage, err)
 		}
+	}
+}
+
+func TestEvaluator_HashTables(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{"(define t (make-hash-table)) (hash-table-set! t 'a 1) (hash-table-set! t 'b 2) (list (hash-table-ref t 'a) (hash-table-ref t 'b) (hash-table-count t))", "'(1 2 2)"},
+		{"(define t (make-hash-table)) (hash-table-set! t 'a 1) (hash-table-set! t 'a 2) (list (hash-table-ref t 'a) (hash-table-count t))", "'(2 1)"},
+		{"(define t (make-hash-table)) (list (hash-table-ref t 'missing 0) (hash-table-ref t 'missing '()))", "'(0 ())"},
+		{"(define t (make-hash-table)) (hash-table-set! t '(1 \"a\" #(b)) 'x) (hash-table-ref t (list 1 (substring \"ab\" 0 1) (vector 'b)))", "'x"},
+		{"(define t (make-hash-table equal?)) (hash-table-set! t (cons 1 2) 'p) (hash-table-ref t (cons 1 2) #f)", "'p"},
+		{"(define t (make-hash-table eqv?)) (define k (list 1)) (hash-table-set! t k 'k) (list (hash-table-ref t k) (hash-table-ref t (list 1) #f))", "'(k #f)"},
+		{"(define t (make-hash-table eqv?)) (hash-table-set! t 1/2 'half) (hash-table-set! t 2. 'two) (list (hash-table-ref t (/ 1 2)) (hash-table-ref t 2 #f) (hash-table-ref t 2.))", "'(half #f two)"},
+		{"(define t (make-hash-table)) (hash-table-set! t 0. 'zero) (list (hash-table-ref t 0. #f) (hash-table-ref t 0 #f))", "'(zero #f)"},
+		{"(define t (make-hash-table)) (hash-table-set! t 'a 1) (hash-table-set! t 'b 2) (hash-table-delete! t 'a) (hash-table-delete! t 'c) (list (hash-table-ref t 'a #f) (hash-table-count t))", "'(#f 1)"},
+		{"(define t (make-hash-table)) (hash-table-set! t car 'car) (hash-table-set! t cdr 'cdr) (list (hash-table-ref t car) (hash-table-ref t cdr))", "'(car cdr)"},
+		{"(list (hash-table? (make-hash-table)) (hash-table? '()) (make-hash-table))", "'(#t #f <hash-table>)"},
+		{"(define t (make-hash-table)) (define x (list 1)) (hash-table-set! t (list x x) 'shared) (hash-table-ref t '((1) (1)))", "'shared"},
+		{"(define t (make-hash-table eqv?)) (define l (list 1)) (set-cdr! l l) (hash-table-set! t l 'cycle) (hash-table-ref t l)", "'cycle"},
+		{"(define t (make-hash-table)) (guard (e (#t (error-object-message e))) (hash-table-ref t 'k))", `"'hash-table-ref' found no value for the key 'k"`},
+	}
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
 		if ret.String() != tt.expectedOutput {
-			t.Fatalf("input %s, expected %s, got %s", tt.stdinInput, tt.expectedOutput, ret.String())
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+
+	errorTests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"(make-hash-table eq?)", "'make-hash-table' expected equal? or eqv?, got <builtin function>"},
+		{"(define l (list 1 2)) (set-cdr! (cdr l) l) (hash-table-set! (make-hash-table) l 'a)", "'hash-table-set!' can't hash a key that contains itself"},
+		{"(define p (list 1)) (set-car! p p) (hash-table-ref (make-hash-table) p 0)", "'hash-table-ref' can't hash a key that contains itself"},
+		{"(define v (vector 1)) (vector-set! v 0 (list v)) (hash-table-delete! (make-hash-table) v)", "'hash-table-delete!' can't hash a key that contains itself"},
+		{"(hash-table-ref '() 'a)", "'hash-table-ref' expected a hash table, got List"},
+		{"(hash-table-set! (make-hash-table) 'a)", "'hash-table-set!' has been called with 2 arguments; it requires exactly 3 arguments"},
+		{"(hash-table-count)", "'hash-table-count' has been called with 0 arguments; it requires exactly 1 argument"},
+	}
+	for _, tt := range errorTests {
+		err := testEvalError(tt.input, t)
+		if !strings.Contains(err.Error(), tt.expectedMessage) {
+			t.Fatalf("input %s, expected %q, got %q", tt.input, tt.expectedMessage, err)
 		}
 	}
 }
 
-func testEval(input string, t *testing.T) *ReturnValue {
-	l := lexer.New(strings.NewReader(input))
-	p := parser.New(l)
-	program, err := p.Parse()
+func TestEvaluator_CachedLiterals(t *testing.T) {
+	program, err := parser.New(lexer.New(strings.NewReader(`"short" "short" '(a b) #(1 2)`))).Parse()
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
+	evalAll := func(e *Evaluator) []*ReturnValue {
+		values := make([]*ReturnValue, len(program.Expressions))
+		for i, exp := range program.Expressions {
+			if values[i], err = e.eval(exp, e.globalEnv); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		return values
+	}
+	e := New(WithStdin(strings.NewReader("")))
+	values, again := evalAll(e), evalAll(e)
+	for i := range values {
+		if values[i] != again[i] {
+			t.Fatalf("expected %s to evaluate to one value every time", program.Expressions[i])
+		}
+	}
+	if values[0] == values[1] {
+		t.Fatal("expected each string literal to have a value of its own")
+	}
+	// the values are cached on the expressions, not by the evaluator
+	if other := evalAll(New(WithStdin(strings.NewReader("")))); other[2] != values[2] {
+		t.Fatal("expected the quoted list to be cached on its expression")
+	}
+}
 
-	evaluator := New(strings.NewReader(""))
-	result, err := evaluator.Eval(program)
-	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
+func TestEvaluator_Equivalence(t *testing.T) {
+	// each row defines a and b, expectedOutput is what eq?, eqv? and equal? report for them
+	tests := []struct {
+		definitions    string
+		expectedOutput string
+	}{
+		{"(define a (cons 1 2)) (define b a)", `'(#t #t #t)`},
+		{"(define a (cons 1 2)) (define b (cons 1 2))", `'(#f #f #t)`},
+		{"(define a (list 1 2)) (define b (cdr (cons 0 a)))", `'(#t #t #t)`},
+		{"(define a '(1 2)) (define b '(1 2))", `'(#f #f #t)`},
+		{"(define a (vector 1 2)) (define b a)", `'(#t #t #t)`},
+		{"(define a (vector 1 2)) (define b (vector 1 2))", `'(#f #f #t)`},
+		{"(define a (string-copy \"ab\")) (define b a)", `'(#t #t #t)`},
+		{"(define a (string-copy \"ab\")) (define b (string-copy \"ab\"))", `'(#f #f #t)`},
+		{"(define a \"ab\") (define b \"ab\")", `'(#f #f #t)`},
+		{"(define (a) 1) (define b a)", `'(#t #t #t)`},
+		{"(define (a) 1) (define (b) 1)", `'(#f #f #f)`},
+		{"(define a car) (define b car)", `'(#t #t #t)`},
+		{"(define a car) (define b cdr)", `'(#f #f #f)`},
+		{"(define a 'x) (define b 'x)", `'(#t #t #t)`},
+		{"(define a '()) (define b (list))", `'(#t #t #t)`},
+		{"(define a 2) (define b 2)", `'(#t #t #t)`},
+		{"(define a 2) (define b 2.)", `'(#f #f #f)`},
 	}
-	return result
+
+	for _, tt := range tests {
+		input := tt.definitions + " (list (eq? a b) (eqv? a b) (equal? a b))"
+		ret := testEval(input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", input, tt.expectedOutput, ret.String())
+		}
+	}
+}
+
+func TestEvaluator_Builtin_EqAndCompare(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{"(number? 1)", `#t`},
+		{"(number? 'a)", `#f`},
+		{"(symbol? 1)", `#f`},
+		{"(symbol? 'a)", `#t`},
+		{"(string? 1)", `#f`},
+		{"(string? 'a)", `#f`},
+		{"(string? \"foo\")", `#t`},
+		{"(pair? 'a)", `#f`},
+		{"(pair? '(1 2 3))", `#t`},
+		{"(pair? '())", `#f`},
+		{"(pair? (cons 1 2))", `#t`},
+		{"(list? '(1 2 3))", `#t`},
+		{"(list? '())", `#t`},
+		{"(list? (cons 1 2))", `#f`},
+		{"(eq? #t #t)", `#t`},
+		{"(eq? #t #f)", `#f`},
+		{"(eq? #f #t)", `#f`},
+		{"(eq? #f #f)", `#t`},
+		{"(eq? 'a 'a)", `#t`},
+		{"(eq? 1 1)", `#t`},
+		{"(eq? 1 2)", `#f`},
+		{"(eq? '(1 2) '(1 2))", `#f`},
+		{"(eq? '(1) '(1))", `#f`},
+		{"(eq? '() '())", `#t`},
+		{"(equal? 'a 'a)", `#t`},
+		{"(equal? 1 1)", `#t`},
+		{"(equal? 1 2)", `#f`},
+		{"(equal? '(1 2) '(1 2))", `#t`},
+		{"(eqv? 'a 'a)", `#t`},
+		{"(eqv? 1 1)", `#t`},
+		{"(eqv? 1 1.0)", `#f`},
+		{"(eqv? 2.5 2.5)", `#t`},
+		{"(eqv? 0.0 -0.0)", `#f`},
+		{"(eqv? '() '())", `#t`},
+		{"(eqv? '(1) '(1))", `#f`},
+		{"(define l (list 1 2)) (list (eqv? l l) (eqv? (cdr l) (cdr l)) (eqv? (cdr l) (list 2)))", `'(#t #t #f)`},
+		{"(define p (cons 1 2)) (list (eqv? p p) (eqv? p (cons 1 2)))", `'(#t #f)`},
+		{`(define s "abc") (list (eqv? s s) (eqv? (substring s 1) (substring s 1)))`, `'(#t #f)`},
+		{"(list (eqv? car car) (eqv? (lambda (x) x) (lambda (x) x)))", `'(#t #f)`},
+		{"(eqv? #f '())", `#f`},
+		{"(> 200 10)", `#t`},
+		{"(> 10 10)", `#f`},
+		{"(> 1 2)", `#f`},
+		{"(>= 200 10)", `#t`},
+		{"(>= 10 10)", `#t`},
+		{"(>= 1 2)", `#f`},
+		{"(< 200 10)", `#f`},
+		{"(< 10 10)", `#f`},
+		{"(< 1 2)", `#t`},
+		{"(<= 200 10)", `#f`},
+		{"(<= 10 10)", `#t`},
+		{"(<= 1 2)", `#t`},
+		{"(= 10 10)", `#t`},
+		{"(= 1 2)", `#f`},
+		{"(and 10 12)", `12`},
+		{"(and 10 #f 20)", `#f`},
+		{"(and #f undefined-proc)", `#f`},
+		{"(or 10 12)", `10`},
+		{"(or 10 #f 20)", `10`},
+		{"(or #f #f)", `#f`},
+		{"(or 1 undefined-proc)", `1`},
+		{"(null? 1)", `#f`},
+		{"(null? #f)", `#f`},
+		{"(null? '(1))", `#f`},
+		{"(null? '())", `#t`},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+}
+
+func TestEvaluator_Builtin_ConOperation(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{"(cons 1 2)", `'(1 . 2)`},
+		{"(cons 1 '())", `'(1)`},
+		{"(cons '(1) '(2 3 4))", `'((1) 2 3 4)`},
+		{"(cons \"1\" '(2 3 4))", `'("1" 2 3 4)`},
+		{"(cons '(1 2) 3)", `'((1 2) . 3)`},
+		{"(car (cons 1 2))", `1`},
+		{"(cdr (cons 1 2))", `2`},
+		{"(car '(1 2 3))", `1`},
+		{"(cdr '(1 2 3))", `'(2 3)`},
+		{"(car '((1 2) (3 4)))", `'(1 2)`},
+		{"(cdr '((1 2) (3 4)))", `'((3 4))`},
+		{"(caar '((1 2)))", `1`},
+		{"(cadr '(1 2 3))", `2`},
+		{"(cdar '((1 2) (3 4)))", `'(2)`},
+		{"(caddr '((1 2) (3 4) (5 6)))", `'(5 6)`},
+		{"(cddr '((1 2) (3 4) (5 6)))", `'((5 6))`},
+		{"(cdddr '((1 2) (3 4) (5 6) (7 8)))", `'((7 8))`},
+		{"(caadr '(1 (2 3)))", `2`},
+		{"(cdadr '(1 (2 3)))", `'(3)`},
+		{"(cadddr '((1 2) (3 4) (5 6) (7 8)))", `'(7 8)`},
+		{`(define l (list 1 2 3)) (set-car! l 4) l`, `'(4 2 3)`},
+		{`(define l (list 1 2 3)) (set-cdr! l 4) l`, `'(1 . 4)`},
+		{`(cons 'quote (cons 'a '()))`, `''a`},
+		{`(define a (cons 1 (cons 2 '()))) (define b (cons 0 a)) (define c (cons 5 a)) (list a b c (cons 7 (cdr b)) (cons 9 (cddr b)))`, `'((1 2) (0 1 2) (5 1 2) (7 1 2) (9 2))`},
+		{`(define a (cons 1 '())) (define b (cons 0 a)) (set-car! a 3) b`, `'(0 3)`},
+		{`(define (build n acc) (if (= n 0) acc (build (- n 1) (cons n acc)))) (length (build 20000 '()))`, `20000`},
+		// lists share their pairs
+		{`(define l (list 1 2 3)) (define m (cdr l)) (set-cdr! m '()) l`, `'(1 2)`},
+		{`(define l (list 1 2 3)) (eq? (cdr l) (cdr l))`, `#t`},
+		{`(define l (list 1 2)) (define m (cons 0 l)) (set-car! (cdr m) 5) l`, `'(5 2)`},
+		{`(cons 1 (cons 2 3))`, `'(1 2 . 3)`},
+		{`(cons 1 (list 2 (cons 3 4)))`, `'(1 2 (3 . 4))`},
+		{`(list (pair? (cons 1 2)) (list? (cons 1 2)) (list? (cons 1 '())) (null? (cdr (list 1))))`, `'(#t #f #t #t)`},
+		{`(define l (list 1 2)) (set-cdr! (cdr l) l) (list (pair? l) (list? l))`, `'(#t #f)`},
+		{`(define l (list 1 2)) (set-cdr! (cdr l) l) l`, `'(1 2 1 ...)`},
+		{`(equal? (cons 1 (cons 2 3)) (cons 1 (cons 2 3)))`, `#t`},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+
+	errorTests := []struct {
+		input         string
+		expectedError string
+	}{
+		{`(length (cons 1 2))`, "expected list value, got Cons"},
+		{`(define l (list 1 2)) (set-cdr! (cdr l) l) (length l)`, "expected list value, got Cons"},
+		{`(car '())`, "cannot call 'car' on an empty list"},
+		{`(set-cdr! '() 1)`, "cannot set-cdr! on an empty list"},
+	}
+	for _, tt := range errorTests {
+		err := testEvalError(tt.input, t)
+		if err.Error() != tt.expectedError {
+			t.Fatalf("input %s, expected error %q, got %q", tt.input, tt.expectedError, err.Error())
+		}
+	}
+}
+
+func TestEvaluator_Builtin_Assoc(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{"(assoc 1 (list '(3 2) '(2 1) '(1 9) ))", `'(1 9)`},
+		{"(assoc 5 (list '(3 2) '(2 1) '(1 9) ))", `#f`},
+		{"(assoc 1 (cons (cons 1 2) (cons (cons 2 3) '())))", `'(1 . 2)`},
+		{"(assoc 5 (cons (cons 1 2) (cons (cons 2 3) '())))", `#f`},
+		{"(assoc (list 'a) '(((a)) ((b))))", `'((a))`},
+		{"(assq 'b '((a 1) (b 2)))", `'(b 2)`},
+		{"(assq (list 'a) '(((a)) ((b))))", `#f`},
+		{"(define k (list 'a)) (assq k (list (list (list 'a) 1) (list k 2)))", `'((a) 2)`},
+		{"(assv 2 '((1 one) (2 two)))", `'(2 two)`},
+		{"(assv 1/2 (list (cons 0.5 'float) (cons (/ 2 4) 'half)))", `'(1/2 . half)`},
+		{"(assv \"a\" (list (cons (string-copy \"a\") 1)))", `#f`},
+		{"(assq 'x '())", `#f`},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+
+	errorTests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"(assq 'c '((a . 1) b))", "'assq' expected a list of pairs, got 'b"},
+		{"(assoc 'a (cons '(b . 1) 2))", "'assoc' expected a list, got Cons"},
+		{"(assv 1 2)", "'assv' expected a list, got Number"},
+		{"(assq 'a)", "'assq' has been called with 1 arguments; it requires exactly 2 arguments"},
+	}
+	for _, tt := range errorTests {
+		err := testEvalError(tt.input, t)
+		if err.Error() != tt.expectedError {
+			t.Fatalf("input %s, expected error %q, got %q", tt.input, tt.expectedError, err.Error())
+		}
+	}
+}
+
+func TestEvaluator_Builtin_Member(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{"(memq 'c '(a b c d))", `'(c d)`},
+		{"(memq 'e '(a b c d))", `#f`},
+		{"(memq (list 'a) '(b (a) c))", `#f`},
+		{"(define p (list 'a)) (memq p (list 'b p 'c))", `'((a) c)`},
+		{"(define v (vector 1)) (define h (make-hash-table)) (list (memq v (list v)) (memq h (list 1 h)) (memq car (list cdr car)))", `'((#(1)) (<hash-table>) (<builtin function>))`},
+		{"(define (f) 1) (define g f) (assq g (list (cons f 'f)))", `'(<procedure> . f)`},
+		{"(memv 101 '(100 101 102))", `'(101 102)`},
+		{"(memv 1. '(1 1.))", `'(1.)`},
+		{"(member (list 'a) '(b (a) c))", `'((a) c)`},
+		{"(member \"b\" (list \"a\" (string-copy \"b\")))", `'("b")`},
+		{"(memv #\\b (string->list \"abc\"))", `'(#\b #\c)`},
+		{"(memq 'a '())", `#f`},
+		// the tail returned is the list's own
+		{"(define l (list 1 2 3)) (set-car! (memv 2 l) 5) l", `'(1 5 3)`},
+		{"(define l (list 1 2 3)) (eq? (member 3 l) (cddr l))", `#t`},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+
+	errorTests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"(memq 'z (cons 'a 'b))", "'memq' expected a list, got Cons"},
+		{"(member 1 2)", "'member' expected a list, got Number"},
+		{"(memv 1 '(1) 2)", "'memv' has been called with 3 arguments; it requires exactly 2 arguments"},
+	}
+	for _, tt := range errorTests {
+		err := testEvalError(tt.input, t)
+		if err.Error() != tt.expectedError {
+			t.Fatalf("input %s, expected error %q, got %q", tt.input, tt.expectedError, err.Error())
+		}
+	}
+}
+
+func TestEvaluator_Builtin_Random(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{"(random 100)", `44`},
+		{"(random 100.0)", `15.44158638468602`},
+		{"(random 10 20)", `14`},
+		{"(random 1.5 2.5)", `1.6544158638468602`},
+		{"(random -3 3)", `-3`},
+		{"(let ((n (random -9223372036854775807 9223372036854775807))) (and (>= n -9223372036854775807) (< n 9223372036854775807)))", `#t`},
+		{"(let ((n (random -9223372036854775808 9223372036854775807))) (and (>= n -9223372036854775808) (< n 9223372036854775807)))", `#t`},
+		{"(random 9007199254740992 9007199254740993)", `9007199254740992`},
+		{"(random-choice '(a b c d))", `'c`},
+		{"(shuffle '(1 2 3 4 5))", `'(5 2 3 4 1)`},
+		{"(define l '(1 2 3)) (shuffle l) l", `'(1 2 3)`},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+
+	errorTests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"(random 3 3)", "'random' expected low to be less than high, got 3 and 3"},
+		{"(random 9007199254740993 9007199254740992)", "'random' expected low to be less than high, got 9007199254740993 and 9007199254740992"},
+		{"(random 0)", "'random' expected a positive number, got 0"},
+	}
+	for _, tt := range errorTests {
+		err := testEvalError(tt.input, t)
+		if err.Error() != tt.expectedError {
+			t.Fatalf("input %s, expected error %q, got %q", tt.input, tt.expectedError, err.Error())
+		}
+	}
+}
+
+func TestEvaluator_Procedure(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{"(define (add a b) (+ a b)) (add 1 2)", `3`},
+		{"(define (increment a) (+ a 1)) (increment 1)", `2`},
+		{"(define (six a b c d e f) (set! e (* e 10)) (define g (+ a b)) (list a b c d e f g)) (six 1 2 3 4 5 6)", `'(1 2 3 4 50 6 3)`},
+		{"(define (f x) (define y 1) (define y (+ y x)) y) (f 5)", `6`},
+		{"(define (g . xs) xs) (list (g) (g 1 2))", `'(() (1 2))`},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+}
+
+func TestEvaluator_LetStar(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{"(let* ((a 1) (b (+ a 1)) (c (* b 10))) (list a b c))", `'(1 2 20)`},
+		// a later binding shadows an earlier one of the same name
+		{"(let* ((x 1) (x (+ x 1))) x)", `2`},
+		{"(define x 10) (let* ((x 1) (y x)) y)", `1`},
+		{"(let* () (define z 3) z)", `3`},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+}
+
+func TestEvaluator_Letrec(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{`(letrec ((even? (lambda (n) (if (= n 0) #t (odd? (- n 1)))))
+		           (odd? (lambda (n) (if (= n 0) #f (even? (- n 1))))))
+		   (list (even? 10) (odd? 7) (even? 3)))`, `'(#t #t #f)`},
+		{"(letrec* ((a 1) (b (+ a 1))) (list a b))", `'(1 2)`},
+		{"(letrec ((x 1)) (define y 2) (+ x y))", `3`},
+		// the bindings are local, the x outside is left alone
+		{"(define x 10) (letrec ((x 1)) x) x", `10`},
+		{"(define (f) (letrec ((loop (lambda (i acc) (if (= i 0) acc (loop (- i 1) (+ acc i)))))) (loop 4 0))) (f)", `10`},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+}
+
+func TestEvaluator_Macros(t *testing.T) {
+	swap := "(define-syntax swap! (syntax-rules () ((_ a b) (let ((tmp a)) (set! a b) (set! b tmp)))))"
+	while := "(define-syntax while (syntax-rules () ((_ c body ...) (letrec ((loop (lambda () (if c (begin body ... (loop)) #f)))) (loop)))))"
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{swap + "(define x 1) (define y 2) (swap! x y) (list x y)", `'(2 1)`},
+		// the tmp of the expansion doesn't capture the one swapped
+		{swap + "(define tmp 1) (define y 2) (swap! tmp y) (list tmp y)", `'(2 1)`},
+		{while + "(define i 0) (define sum 0) (while (< i 5) (set! sum (+ sum i)) (set! i (+ i 1))) sum", `10`},
+		// nor does the loop of the expansion capture the program's
+		{while + "(define loop 'mine) (define i 0) (while (< i 2) (set! i (+ i 1))) loop", `'mine`},
+		{"(define-syntax my-or (syntax-rules () ((_) #f) ((_ e) e) ((_ e r ...) (let ((t e)) (if t t (my-or r ...))))))" +
+			"(define t 5) (my-or #f t)", `5`},
+		// the car of the template is the global one, not the one bound where the macro is used
+		{"(define-syntax first (syntax-rules () ((_ l) (car l)))) (let ((car cdr)) (first '(1 2)))", `1`},
+		{"(define counter 0) (define-syntax inc! (syntax-rules () ((_) (set! counter (+ counter 1)))))" +
+			"(let ((counter 100)) (inc!)) counter", `1`},
+		{"(define-syntax my-cond (syntax-rules (else) ((_ (else e)) e) ((_ (c e) clause ...) (if c e (my-cond clause ...)))))" +
+			"(my-cond (#f 1) ((= 1 2) 2) (else 3))", `3`},
+		{"(define-syntax define-getter (syntax-rules () ((_ name v) (begin (define hidden v) (define (name) hidden)))))" +
+			"(define hidden 'outer) (define-getter get 42) (list (get) hidden)", `'(42 outer)`},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+
+	err := testEvalError("(define-syntax m (syntax-rules () ((_) undefined-thing))) (m)", t)
+	if !errors.Is(err, ErrUndefinedVariable) || !strings.Contains(err.Error(), "`undefined-thing`") {
+		t.Fatalf("expected undefined-thing to be reported by its name, got %v", err)
+	}
+}
+
+func TestEvaluator_LetSyntax(t *testing.T) {
+	outer := "(define-syntax m (syntax-rules () ((_) 'outer)))"
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{"(let-syntax ((double (syntax-rules () ((_ x) (* 2 x))))) (double 21))", `42`},
+		// the macro of the body shadows the one around it there only
+		{outer + "(list (let-syntax ((m (syntax-rules () ((_) 'inner)))) (m)) (m))", `'(inner outer)`},
+		// m in the template of let-syntax is the macro around it, in letrec-syntax it's the one made
+		{outer + "(let-syntax ((m (syntax-rules () ((_) (list 'inner (m)))))) (m))", `'(inner outer)`},
+		{outer + "(letrec-syntax ((m (syntax-rules () ((_) 'done) ((_ x) (list x (m)))))) (m 1))", `'(1 done)`},
+		{"(letrec-syntax ((my-or (syntax-rules () ((_) #f) ((_ e) e) ((_ e r ...) (let ((t e)) (if t t (my-or r ...)))))))" +
+			" (define t 5) (my-or #f t))", `5`},
+		{"(letrec-syntax ((ev? (syntax-rules () ((_) #t) ((_ x . r) (od? . r)))) (od? (syntax-rules () ((_) #f) ((_ x . r) (ev? . r)))))" +
+			" (list (ev? 1 2) (od? 1 2)))", `'(#t #f)`},
+		// a template of let-syntax naming a macro it makes refers to the variable
+		{"(define (twice x) (list x x)) (let-syntax ((twice (syntax-rules () ((_ x) (twice (+ x 1)))))) (twice 1))", `'(2 2)`},
+		{"(define x 'global) (let-syntax ((get (syntax-rules () ((_) x)))) (let ((x 'local)) (get)))", `'global`},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+
+	// the macros of a let-syntax are gone after its body
+	err := testEvalError("(let-syntax ((m (syntax-rules () ((_) 1)))) (m)) (m)", t)
+	if !errors.Is(err, ErrUndefinedVariable) {
+		t.Fatalf("expected m to be undefined after the let-syntax, got %v", err)
+	}
+}
+
+func TestEvaluator_MacrosAcrossPrograms(t *testing.T) {
+	e := New(WithStdin(strings.NewReader("")))
+	for _, src := range []string{
+		"(define-syntax unless (syntax-rules () ((_ c body ...) (if c #f (begin body ...)))))",
+		"(unless #f 'ran)",
+	} {
+		p := parser.New(lexer.New(strings.NewReader(src)))
+		p.UseMacros(e.Macros())
+		program, err := p.Parse()
+		if err != nil {
+			t.Fatalf("parse %s: %v", src, err)
+		}
+		ret, err := e.Eval(program)
+		if err != nil {
+			t.Fatalf("eval %s: %v", src, err)
+		}
+		if src == "(unless #f 'ran)" && ret.String() != "'ran" {
+			t.Fatalf("expected 'ran, got %s", ret)
+		}
+	}
+}
+
+func TestEvaluator_Assert(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{"(define (square x) (* x x)) (assert (= (square 2) 4))", `<void>`},
+		{"(assert 0)", `<void>`},
+		{"(guard (e ((assertion-error? e) 'caught)) (assert (> 1 2)))", `'caught`},
+		// the operands of the call asserted are evaluated once
+		{"(define count 0) (guard (e (#t count)) (assert (= (begin (set! count (+ count 1)) count) 2)))", `1`},
+		// and and or don't evaluate all of their operands
+		{"(assert (or #t (car '())))", `<void>`},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+
+	errorTests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"(define (square x) (* x x))\n(assert (equal? (list (square 2) 'x \"s\") (list 5)))",
+			`assertion failed on line 2: (equal? (list (square 2) 'x "s") (list 5)), evaluated as (equal? '(4 x "s") '(5))`},
+		{"(define ok #f) (assert ok)", "assertion failed on line 1: ok is #f"},
+		{"(assert (and #t #f))", "assertion failed on line 1: (and #t #f) is #f"},
+		{"(assert (car '()))", "cannot call 'car' on an empty list"},
+	}
+	for _, tt := range errorTests {
+		err := testEvalError(tt.input, t)
+		if !strings.Contains(err.Error(), tt.expectedMessage) {
+			t.Fatalf("input %s, expected %q, got %q", tt.input, tt.expectedMessage, err)
+		}
+	}
+
+	// the optimizer doesn't fold the call asserted
+	program, err := parser.New(lexer.New(strings.NewReader("(assert (= (+ 1 1) 3))"))).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = New(WithOptimizer()).Eval(program)
+	if expected := "assertion failed on line 1: (= (+ 1 1) 3), evaluated as (= 2 3)"; err == nil || !strings.Contains(err.Error(), expected) {
+		t.Fatalf("expected %q with the optimizer, got %v", expected, err)
+	}
+}
+
+func TestEvaluator_CondClauses(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{"(cond ((and #t (list 1 2)) => cdr) (else 'none))", `'(2)`},
+		{"(cond ((and #f (list 1 2)) => cdr) (else 'none))", `'none`},
+		{"(cond (#f 1) ((+ 1 2)) (else 0))", `3`},
+		{"(cond ((> 1 2)) (else 'else))", `'else`},
+		{"(define count 0) (cond ((begin (set! count (+ count 1)) count) => (lambda (n) (* n 10)))) ", `10`},
+		// the value bound by a clause doesn't hide the variables of the clauses after it
+		{"(define x 5) (cond (#f => car) ((> x 3) x))", `5`},
+		{"(cond ((+ 1 1) => (lambda (a) (cond ((* a 2) => (lambda (b) (list a b))))))) ", `'(2 4)`},
+		{"(guard (e ((and (symbol? e) (list e 1)) => cdr)) (raise 'x))", `'(1)`},
+		{"(guard (e ((and (eq? e 'b) (list e)))) (raise 'b))", `'(b)`},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+}
+
+func TestEvaluator_Exceptions(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{"(guard (e (#t (list 'caught e))) (raise 'boom))", `'(caught boom)`},
+		{`(guard (e ((symbol? e) 'symbol) ((string? e) 'string)) (raise "oops"))`, `'string`},
+		{"(guard (e ((string? e) 'string) (else (list 'else e))) (raise 1))", `'(else 1)`},
+		{"(guard (e (#t 'caught)) 'fine)", `'fine`},
+		// the errors of builtins are raised as conditions
+		{"(guard (e ((wrong-type-error? e) 'wrong-type)) (car 5))", `'wrong-type`},
+		{"(guard (e ((undefined-variable-error? e) 'undefined)) (+ 1 nowhere))", `'undefined`},
+		{"(define (f) (car '())) (guard (e ((condition? e) 'condition)) (+ 1 (f)))", `'condition`},
+		{"(with-exception-handler (lambda (e) (* e 2)) (lambda () (+ 1 (raise-continuable 20))))", `41`},
+		// a guard whose clauses don't match leaves the exception to the handlers outside of it
+		{"(with-exception-handler (lambda (e) 10) (lambda () (guard (e ((string? e) 'no)) (+ 1 (raise-continuable 'x)))))", `11`},
+		{"(guard (e (#t e)) (guard (e ((string? e) 'inner)) (raise 'outer)))", `'outer`},
+		{"(guard (e ((pair? e) e)) (with-exception-handler (lambda (e) (raise (list 'wrapped e))) (lambda () (raise 'inner))))", `'(wrapped inner)`},
+		// a handler returning from raise raises a secondary exception
+		{"(guard (e ((condition? e) 'secondary)) (with-exception-handler (lambda (e) 'ignored) (lambda () (raise 'inner))))", `'secondary`},
+		// a handler runs with the handlers outside of it installed
+		{"(define seen '()) (guard (e (#t (cons e seen))) (with-exception-handler (lambda (e) (set! seen (cons 'outer seen)) (raise 'again))" +
+			" (lambda () (with-exception-handler (lambda (e) (set! seen (cons 'inner seen)) (raise 'next)) (lambda () (raise 'first))))))", `'(again outer inner)`},
+		{"(define log '()) (guard (e (#t (set! log (cons 'guard log)) log)) (with-exception-handler (lambda (e) (set! log (cons 'handler log)) 1) (lambda () (/ 1 (car '())))))",
+			`'(guard handler)`},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+
+	errorTests := []struct {
+		input           string
+		expectedMessage string
+		expectedError   error
+	}{
+		{"(raise 'boom)", "uncaught exception: 'boom", nil},
+		{"(guard (e ((string? e) 'no)) (raise 'unhandled))", "uncaught exception: 'unhandled", nil},
+		{"(guard (e ((string? e) 'no)) (car 5))", "'car' expected cons or list value, got Number", ErrWrongType},
+		{"(raise-continuable 1)", "uncaught exception: 1", nil},
+		{"(with-exception-handler (lambda (e) 0) (lambda () (car '())))", "a handler returned from the non-continuable exception", nil},
+		{"(with-exception-handler 1 (lambda () 1))", "'with-exception-handler' expected a procedure, got Number", ErrWrongType},
+	}
+	for _, tt := range errorTests {
+		err := testEvalError(tt.input, t)
+		if !strings.Contains(err.Error(), tt.expectedMessage) {
+			t.Fatalf("input %s, expected %q, got %v", tt.input, tt.expectedMessage, err)
+		}
+		if tt.expectedError != nil && !errors.Is(err, tt.expectedError) {
+			t.Fatalf("input %s, expected %v, got %v", tt.input, tt.expectedError, err)
+		}
+	}
+
+	// the errors of the limits can't be handled
+	e := New(WithLimits(Limits{MaxSteps: 1000}))
+	program, err := parser.New(lexer.New(strings.NewReader("(define (loop) (loop)) (guard (e (#t 'caught)) (loop))"))).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := e.Eval(program); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("expected the step limit to be exceeded, got %v", err)
+	}
+}
+
+func TestEvaluator_ErrorObjects(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{`(guard (e ((error-object? e) (error-object-message e))) (error "bad thing:" 42 'x))`, `"bad thing:"`},
+		{`(guard (e ((error-object? e) (error-object-irritants e))) (error "bad thing:" 42 'x))`, `'(42 x)`},
+		{`(guard (e ((error-object? e) (error-object-irritants e))) (error "bad thing"))`, `'()`},
+		{`(guard (e (#t (error-object-message e))) (error 'my-proc "failed"))`, `'my-proc`},
+		// the conditions of builtins are error objects without irritants
+		{`(guard (e ((error-object? e) (list (error-object-message e) (error-object-irritants e)))) (car 1))`,
+			`'("'car' expected cons or list value, got Number" ())`},
+		{`(error-object? 'x)`, `#f`},
+		{`(error-object? "bad thing")`, `#f`},
+		{`(define e (guard (e (#t e)) (error "bad" 1))) (set-car! (error-object-irritants e) 2) (error-object-irritants e)`, `'(1)`},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+
+	errorTests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"(error-object-message 'x)", "'error-object-message' expected an error object, got Symbol"},
+		{"(error-object-irritants)", "'error-object-irritants' has been called with 0 arguments; it requires exactly 1 argument"},
+		// an uncaught error object reports its message and irritants
+		{`(error "bad thing:" 42)`, `failed to evaluate: "bad thing:", 42`},
+	}
+	for _, tt := range errorTests {
+		err := testEvalError(tt.input, t)
+		if err.Error() != tt.expectedMessage {
+			t.Fatalf("input %s, expected %q, got %q", tt.input, tt.expectedMessage, err)
+		}
+	}
+}
+
+func TestEvaluator_Eval(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{"(eval '(+ 1 2) (the-environment))", "3"},
+		{"(eval (list '* 6 7))", "42"},
+		{"(define x 1) (eval 'x (interaction-environment))", "1"},
+		{"(define (make-env x) (the-environment)) (eval 'x (make-env 10))", "10"},
+		// a definition in an environment stays in it
+		{"(define (make-env x) (the-environment)) (define env (make-env 10)) (eval '(define y 5) env) (list (eval '(list x y) env) (environment? env))",
+			"'((10 5) #t)"},
+		{"(define (make-env) (the-environment)) (eval '(define z 5) (make-env)) (guard (e (#t 'undefined)) z)", "'undefined"},
+		{"(eval ''(a \"b\" #t))", "'(a \"b\" #t)"},
+		{"(let ((z 3)) (eval 'z (the-environment)))", "3"},
+		{"(eval '(begin (define (square x) (* x x)) (square 4)))", "16"},
+		{"(environment? 'x)", "#f"},
+		// the values in a form are used as they are
+		{"(eval (list + 1 2))", "3"},
+		{"(eval (list car ''(1)))", "1"},
+		{"(define (twice f x) (f (f x))) (eval (list twice (lambda (n) (* n 3)) 2))", "18"},
+		{"(eval (list 'quote (vector 1 \"a\" #\\b)))", "'#(1 \"a\" #\\b)"},
+		{"(let ((l (list 1))) (eq? l (eval (list 'quote l))))", "#t"},
+		{"(let ((s (string-copy \"abc\"))) (eq? s (eval s)))", "#t"},
+		{"(define p (cons 1 2)) (eq? p ((eval (list 'lambda '() (list 'quote p)))))", "#t"},
+		{"(eval (list 'if #f 1 (list 'quote 'b)))", "'b"},
+		{"(eval (cons '+ (cons 1 '(2))))", "3"},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+
+	errorTests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"(eval 1 2)", "'eval' expected an environment, got Number"},
+		{"(eval)", "'eval' has been called with 0 arguments; it requires 1 or 2 arguments"},
+		{"(define l (list 'a)) (set-cdr! l l) (eval l)", "'eval' expected a form: a circular list can't be part of a form"},
+		{"(eval '(if))", "'eval' failed to parse (if)"},
+		{"(eval 'nowhere)", "undefined identifier: `nowhere`"},
+	}
+	for _, tt := range errorTests {
+		err := testEvalError(tt.input, t)
+		if !strings.Contains(err.Error(), tt.expectedMessage) {
+			t.Fatalf("input %s, expected %q, got %q", tt.input, tt.expectedMessage, err)
+		}
+	}
+
+	// the optimizer doesn't inline the let around the-environment
+	program, err := parser.New(lexer.New(strings.NewReader("(let ((z 3)) (eval 'z (the-environment)))"))).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ret, err := New(WithOptimizer()).Eval(program)
+	if err != nil || ret.String() != "3" {
+		t.Fatalf("expected 3 with the optimizer, got %v, %v", ret, err)
+	}
+}
+
+func TestEvaluator_Parameters(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{"(define indent (make-parameter 0)) (define (show) (indent)) (list (parameterize ((indent 2)) (show)) (show))", "'(2 0)"},
+		{"(define p (make-parameter 10 (lambda (x) (* x 2)))) (list (p) (parameterize ((p 3)) (p)) (p))", "'(20 6 20)"},
+		{"(define p (make-parameter 1)) (define q (make-parameter 2)) (parameterize ((p 10)) (parameterize ((p 20) (q 30)) (list (p) (q))))", "'(20 30)"},
+		// the bindings are restored when the body raises, the clauses of guard see the parameters as
+		// the guard does
+		{"(define p (make-parameter 'outside)) (guard (e ((eq? (p) 'outside) (list e (p)))) (parameterize ((p 'inside)) (raise 'x)))", "'(x outside)"},
+		{"(define p (make-parameter 0)) (guard (e (#t 'caught)) (parameterize ((p 1)) (car '()))) (p)", "0"},
+		// a handler is called where the object is raised
+		{"(define p (make-parameter 0)) (with-exception-handler (lambda (e) (p)) (lambda () (parameterize ((p 1)) (raise-continuable 'x))))", "1"},
+		{"(define p (make-parameter 0)) (list (parameter? p) (parameter? car) (parameter? 0))", "'(#t #f #f)"},
+		{"(define p (make-parameter 0)) (parameterize () (p))", "0"},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+
+	errorTests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"(parameterize ((car 1)) 1)", "'parameterize' expected a parameter, got BuiltinFunction"},
+		{"(define p (make-parameter 0)) (p 1)", "a parameter has been called with 1 arguments; it requires exactly 0 arguments"},
+		{"(make-parameter 0 1)", "'make-parameter' expected a procedure as converter, got Number"},
+		{"(make-parameter)", "'make-parameter' has been called with 0 arguments; it requires 1 or 2 arguments"},
+		{"(define p (make-parameter 0 (lambda (x) (+ x 1)))) (parameterize ((p 'a)) 1)", "all arguments to '+' must be numbers"},
+	}
+	for _, tt := range errorTests {
+		err := testEvalError(tt.input, t)
+		if !strings.Contains(err.Error(), tt.expectedMessage) {
+			t.Fatalf("input %s, expected %q, got %q", tt.input, tt.expectedMessage, err)
+		}
+	}
+}
+
+func TestEvaluator_DefineMacro(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{"(define-macro (my-unless c . body) (list 'if c #f (cons 'begin body))) (my-unless (= 1 2) 'a 'b)", `'b`},
+		{"(define-macro (my-unless c . body) (list 'if c #f (cons 'begin body))) (my-unless #t (car '()))", `#f`},
+		// the operands are data, read from the source of the use
+		{`(define-macro (parts . xs) (list 'quote xs)) (parts 1 "s" #t (a . b))`, `'(1 "s" #t (a . b))`},
+		{"(define-macro (quoted? x) (if (pair? x) (eq? (car x) 'quote) #f)) (list (quoted? 'y) (quoted? y))", `'(#t #f)`},
+		{"(define-macro (inc! v) (list 'set! v (list '+ v 1))) (define n 1) (inc! n) (inc! n) n", `3`},
+		{"(define-macro square-of (lambda (x) (list '* x x))) (square-of (+ 1 2))", `9`},
+		// unlike syntax-rules, the tmp of the expansion captures the one swapped
+		{"(define-macro (swap! a b) (list 'let (list (list 'tmp a)) (list 'set! a b) (list 'set! b 'tmp)))" +
+			"(define x 1) (define y 2) (swap! x y) (list x y)", `'(2 1)`},
+		{"(define-macro (my-cond . clauses) (if (null? clauses) #f (list 'if (car (car clauses)) (car (cdr (car clauses))) (cons 'my-cond (cdr clauses)))))" +
+			"(my-cond (#f 1) ((= 1 2) 2) (#t 3))", `3`},
+	}
+
+	for _, tt := range tests {
+		e := New(WithStdin(strings.NewReader("")))
+		p := parser.New(lexer.New(strings.NewReader(tt.input)))
+		p.UseMacros(e.Macros())
+		program, err := p.Parse()
+		if err != nil {
+			t.Fatalf("input %s, unexpected error: %v", tt.input, err)
+		}
+		ret, err := e.Eval(program)
+		if err != nil {
+			t.Fatalf("input %s, unexpected error: %v", tt.input, err)
+		}
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+
+	// a transformer can use what the programs evaluated before define, and errors at expansion
+	e := New(WithStdin(strings.NewReader("")))
+	for _, tt := range []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"(define (wrap x) (list 'list x x)) (define-macro (dup x) (wrap x))", ""},
+		{"(dup 7)", ""},
+		{"(define-macro (proc) car) (proc)", "expanding proc: <builtin function> can't be part of a form"},
+		{"(define-macro five 5) (five)", "expanding five: the transformer of five is 5 rather than a procedure"},
+	} {
+		p := parser.New(lexer.New(strings.NewReader(tt.input)))
+		p.UseMacros(e.Macros())
+		program, err := p.Parse()
+		if tt.expectedMessage != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.expectedMessage) {
+				t.Fatalf("input %s, expected %q, got %v", tt.input, tt.expectedMessage, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("input %s, unexpected error: %v", tt.input, err)
+		}
+		ret, err := e.Eval(program)
+		if err != nil {
+			t.Fatalf("input %s, unexpected error: %v", tt.input, err)
+		}
+		if tt.input == "(dup 7)" && ret.String() != "'(7 7)" {
+			t.Fatalf("expected '(7 7), got %s", ret)
+		}
+	}
+}
+
+func TestEvaluator_DelayAndForce(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{"(delay (+ 1 2))", `<promise>`},
+		{"(force (delay (+ 1 2)))", `3`},
+		{"(force (delay-force (delay (+ 1 2))))", `3`},
+		{"(force (make-promise 3))", `3`},
+		{"(define p (delay 1)) (eq? p (make-promise p))", `#t`},
+		{"(list (promise? (delay 1)) (promise? (make-promise 1)) (promise? 1))", `'(#t #t #f)`},
+		// a promise forced while it's evaluated keeps the first value it gets
+		{"(define count 0) (define x 5) (define p (delay (begin (set! count (+ count 1)) (if (> count x) count (force p))))) (force p) (set! x 10) (force p)",
+			`6`},
+		// forcing a chain of delay-forces doesn't nest calls of force
+		{"(define (loop n) (delay-force (if (= n 0) (make-promise 'done) (loop (- n 1))))) (force (loop 100000))", `'done`},
+		{`(define (stream-from n) (delay (cons n (stream-from (+ n 1)))))
+(define (stream-filter keep? s)
+  (delay-force
+    (let ((pair (force s)))
+      (if (keep? (car pair))
+          (delay (cons (car pair) (stream-filter keep? (cdr pair))))
+          (stream-filter keep? (cdr pair))))))
+(car (force (stream-filter (lambda (n) (= n 20000)) (stream-from 0))))`, `20000`},
+		// a promise taken over by delay-force shares its value
+		{"(define count 0) (define inner (delay (begin (set! count (+ count 1)) count))) (define outer (delay-force inner)) (list (force outer) (force inner) count)",
+			`'(1 1 1)`},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+
+	for _, tt := range []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"(force (delay-force 1))", "'delay-force' expected a promise, got Number"},
+		{"(define p (delay-force p)) (force p)", "'delay-force' evaluated to its own promise"},
+		{"(make-promise)", "'make-promise' has been called with 0 arguments; it requires exactly 1 argument"},
+	} {
+		if err := testEvalError(tt.input, t); !strings.Contains(err.Error(), tt.expectedMessage) {
+			t.Fatalf("input %s, expected %q, got %q", tt.input, tt.expectedMessage, err)
+		}
+	}
+}
+
+func TestEvaluator_Stream(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{"(cons-stream 1 2)", `'(1 . <promise>)`},
+		{"(car (cons-stream 1 2))", `1`},
+		{"(cdr (cons-stream 1 2))", `<promise>`},
+		{"(stream-car (cons-stream 1 2))", `1`},
+		{"(stream-cdr (cons-stream 1 2))", `2`},
+		{"(stream-null? (cons-stream 1 2))", `#f`},
+		{"(stream-null? '())", `#t`},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+}
+
+func TestEvaluator_StreamLibrary(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{"(stream->list (stream-enumerate-interval 1 5))", `'(1 2 3 4 5)`},
+		{"(stream->list (stream-enumerate-interval 1 5) 2)", `'(1 2)`},
+		{"(stream->list (stream-enumerate-interval 3 1))", `'()`},
+		{"(stream-ref (stream-enumerate-interval 10 20) 3)", `13`},
+		{"(stream->list (stream-map (lambda (x) (* x x)) (stream-enumerate-interval 1 4)))", `'(1 4 9 16)`},
+		{"(stream->list (stream-map + (stream-enumerate-interval 1 3) (stream-enumerate-interval 10 20)))", `'(11 13 15)`},
+		{"(stream->list (stream-filter (lambda (x) (= (remainder x 2) 0)) (stream-enumerate-interval 1 10)))", `'(2 4 6 8 10)`},
+		{`(define (integers-from n) (cons-stream n (integers-from (+ n 1))))
+		  (stream->list (stream-filter (lambda (x) (= (remainder x 7) 0)) (integers-from 1)) 3)`, `'(7 14 21)`},
+		{`(define ones (cons-stream 1 ones))
+		  (define integers (cons-stream 1 (stream-map + ones integers)))
+		  (stream-ref integers 99)`, `100`},
+		{`(define sum 0) (stream-for-each (lambda (x) (set! sum (+ sum x))) (stream-enumerate-interval 1 4))`, `'done`},
+		{`(define sum 0) (stream-for-each (lambda (x) (set! sum (+ sum x))) (stream-enumerate-interval 1 4)) sum`, `10`},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+}
+
+func TestEvaluator_Apply(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{"(apply + '(1 2 3))", `6`},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+}
+
+func TestEvaluator_Length(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{"(length '(1 2 3))", `3`},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+}
+
+func TestEvaluator_Prelude(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{"(filter (lambda (x) (> x 2)) '(1 2 3 4))", `'(3 4)`},
+		{"(fold-left - 0 '(1 2 3))", `-6`},
+		{"(fold-right - 0 '(1 2 3))", `2`},
+		{"(fold cons '() '(1 2 3))", `'(3 2 1)`},
+		{"(reduce + 0 '(1 2 3 4))", `10`},
+		{"(reduce + 0 '())", `0`},
+		{"(accumulate-n + 0 '((1 2 3) (4 5 6)))", `'(5 7 9)`},
+		{"(reverse '(1 2 3))", `'(3 2 1)`},
+		{"(define sum 0) (for-each (lambda (x y) (set! sum (+ sum (* x y)))) '(1 2) '(3 4)) sum", `11`},
+		{"(list-ref '(a b c) 2)", `'c`},
+		{"(list-tail '(a b c) 1)", `'(b c)`},
+		{"(last-pair '(1 2 3))", `'(3)`},
+		{"(enumerate-interval 2 5)", `'(2 3 4 5)`},
+		{"(flatmap (lambda (x) (list x x)) '(1 2))", `'(1 1 2 2)`},
+		{"(define (filter p s) 'mine) (filter 1 2)", `'mine`},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+}
+
+// The prelude's list procedures don't nest a call per element, they handle lists longer than the
+// depth limit.
+func TestEvaluator_PreludeLongLists(t *testing.T) {
+	long := fmt.Sprint(3 * stackSegmentDepth)
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{"(length (enumerate-interval 1 " + long + "))", long},
+		{"(length (filter even? (enumerate-interval 1 " + long + ")))", fmt.Sprint(3 * stackSegmentDepth / 2)},
+		{"(car (filter (lambda (x) (> x 1)) (enumerate-interval 1 " + long + ")))", `2`},
+		{"(fold-right + 0 (enumerate-interval 1 " + long + "))", fmt.Sprint(3 * stackSegmentDepth * (3*stackSegmentDepth + 1) / 2)},
+		{"(car (fold-right cons '() (enumerate-interval 1 " + long + ")))", `1`},
+		{"(length (flatmap (lambda (x) (list x x)) (enumerate-interval 1 " + long + ")))", fmt.Sprint(6 * stackSegmentDepth)},
+	}
+
+	for _, tt := range tests {
+		program, err := parser.New(lexer.New(strings.NewReader(tt.input))).Parse()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		e := New(WithStdin(strings.NewReader("")))
+		e.SetLimits(Limits{MaxDepth: 2 * stackSegmentDepth})
+		ret, err := e.Eval(program)
+		if err != nil || ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %v, %v", tt.input, tt.expectedOutput, ret, err)
+		}
+	}
+}
+
+func TestEvaluator_WithoutPrelude(t *testing.T) {
+	l := lexer.New(strings.NewReader("(filter (lambda (x) #t) '(1))"))
+	p := parser.New(l)
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	evaluator := New(WithStdin(strings.NewReader("")), WithoutPrelude())
+	_, err = evaluator.Eval(program)
+	if err == nil || !strings.Contains(err.Error(), "undefined identifier: `filter`") {
+		t.Fatalf("expected undefined identifier error, got %v", err)
+	}
+}
+
+func TestEvaluator_Read(t *testing.T) {
+	tests := []struct {
+		stdinInput     string
+		expectedOutput string
+	}{
+		{"(1 2 3)", `'(1 2 3)`},
+		{"1", `1`},
+		{"foo", `'foo`},
+		{"(1 2 3 (4 5 6))", `'(1 2 3 (4 5 6))`},
+		{"'a", `''a`},
+		{`'(123)`, `''(123)`},
+		{`(append '(a b c) '(d e f))`, `'(append '(a b c) '(d e f))`},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(strings.NewReader("(read)"))
+		p := parser.New(l)
+		program, err := p.Parse()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		evaluator := New(WithStdin(strings.NewReader(tt.stdinInput)))
+		ret, err := evaluator.Eval(program)
+		if err != nil {
+			t.Fatalf("stdinInput %s unexpected error: %v", tt.stdinInput, err)
+		}
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.stdinInput, tt.expectedOutput, ret.String())
+		}
+	}
+}
+
+func TestEvaluator_ShadowBuiltins(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{`(define (car x) 'mine) (car '(1 2))`, `'mine`},
+		{`(define (car x) 'mine) ((builtin 'car) '(1 2))`, `1`},
+		{`(define (+ a b) (- a b)) (+ 5 3)`, `2`},
+		{`(define (+ a b) (- a b)) ((builtin '+) 5 3)`, `8`},
+		{`(define (f) (define (map p l) 'local) (map car '((1)))) (f) (map car '((1) (2)))`, `'(1 2)`},
+		{`(define (square x) 0) ((builtin 'square) 3)`, `9`},
+		{`(define map 1) (set! map 2) map`, `2`},
+		{`(define-library (lib) (export car) (begin (define (car x) 'lib-car))) (car '(1))`, `1`},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+
+	// a new evaluator doesn't see the definitions of another one
+	testEval(`(define (cdr x) 'mine)`, t)
+	if ret := testEval(`(cdr '(1 2))`, t); ret.String() != `'(2)` {
+		t.Fatalf("expected '(2), got %s", ret.String())
+	}
+
+	testEvalError(`(set! car 1)`, t)
+	testEvalError(`(builtin 'no-such-builtin)`, t)
+}
+
+func TestEvaluator_Amb(t *testing.T) {
+	multipleDwelling := `
+(define (member x items)
+  (cond ((null? items) false)
+        ((equal? x (car items)) items)
+        (else (member x (cdr items)))))
+(define (distinct? items)
+  (cond ((null? items) true)
+        ((null? (cdr items)) true)
+        ((member (car items) (cdr items)) false)
+        (else (distinct? (cdr items)))))
+(define (multiple-dwelling)
+  (let ((baker (amb 1 2 3 4 5)) (cooper (amb 1 2 3 4 5))
+        (fletcher (amb 1 2 3 4 5)) (miller (amb 1 2 3 4 5))
+        (smith (amb 1 2 3 4 5)))
+    (require (distinct? (list baker cooper fletcher miller smith)))
+    (require (not (= baker 5)))
+    (require (not (= cooper 1)))
+    (require (not (= fletcher 5)))
+    (require (not (= fletcher 1)))
+    (require (> miller cooper))
+    (require (not (= (abs (- smith fletcher)) 1)))
+    (require (not (= (abs (- fletcher cooper)) 1)))
+    (list (list 'baker baker) (list 'cooper cooper)
+          (list 'fletcher fletcher) (list 'miller miller)
+          (list 'smith smith))))
+(multiple-dwelling)`
+	anElementOf := `
+(define (an-element-of items)
+  (require (not (null? items)))
+  (amb (car items) (an-element-of (cdr items))))
+`
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{multipleDwelling, `'((baker 3) (cooper 2) (fletcher 4) (miller 5) (smith 1))`},
+		{`(amb 1 (car '()))`, `1`},
+		{`(let ((x (amb 1 2 3))) (require (> x 1)) x)`, `2`},
+		{anElementOf + `(let ((x (an-element-of '(1 2 3 4)))) (require (> x 2)) x)`, `3`},
+		{`(list (amb 1 2) (amb 'a 'b))`, `'(1 a)`},
+		{`(list (amb 1 2) (amb 'a 'b)) (try-again)`, `'(1 b)`},
+		{`(list (amb 1 2) (amb 'a 'b)) (try-again) (try-again)`, `'(2 a)`},
+		{anElementOf + `(an-element-of '(1 2)) (define x 'between) (try-again)`, `2`},
+		// the expressions before a choice aren't evaluated again when the search backtracks
+		{`(define count 0) (let ((x (begin (set! count (+ count 1)) (amb 1 2 3)))) (require (= x 3)) count)`, `1`},
+		{`(define log '()) (let ((x (amb 1 2 3))) (set! log (cons x log)) (require (> x 2)) log)`, `'(3)`},
+		// nested ambs are tried depth first
+		{`(let ((x (amb (amb 1 2) (amb 3 4)))) (require (even? x)) x)`, `2`},
+		{`(let ((x (amb (amb 1 2) (amb 3 4)))) (require (even? x)) x) (try-again)`, `4`},
+		{`(let ((x (amb 1 (amb 2 3)))) (require (> x 1)) (list x (amb 'a))) (try-again)`, `'(3 a)`},
+		{`(list (amb 1 2) (amb 'a 'b)) (try-again) (try-again) (try-again)`, `'(2 b)`},
+		// an amb in a procedure a builtin calls picks its first choice that doesn't fail
+		{`(map (lambda (x) (amb (begin (require (> x 1)) x) 'small)) '(1 2))`, `'(small 2)`},
+		{`(define (deep n) (if (= n 0) (amb 0 1) (+ 1 (deep (- n 1))))) (let ((x (deep 1000))) (require (odd? x)) x)`, `1001`},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+
+	errorTests := []string{
+		`(amb)`,
+		`(require (= 1 2))`,
+		`(try-again)`,
+		`(amb 1 2) (try-again) (try-again)`,
+		`(list (amb 1 2) (amb 'a 'b)) (try-again) (try-again) (try-again) (try-again)`,
+		`(let ((x (amb 1 2))) (require (> x 5)) x)`,
+		`(guard (e (#t 'caught)) (require #f))`,
+	}
+	for _, input := range errorTests {
+		testEvalError(input, t)
+	}
+}
+
+func TestEvaluator_Picture(t *testing.T) {
+	dir := t.TempDir()
+	svgPath := filepath.Join(dir, "picture.svg")
+	pngPath := filepath.Join(dir, "picture.png")
+	painter := `
+(define diagonal (segments->painter (list (make-segment (make-vect 0 0) (make-vect 1 1)))))
+(define edge (segments->painter (list (make-segment (make-vect 0 0) (make-vect 0 1)))))
+`
+	testEval(painter+fmt.Sprintf(`(paint (beside diagonal (flip-horiz edge)) %q 10 20)`, svgPath), t)
+	content, err := os.ReadFile(svgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<svg xmlns="http://www.w3.org/2000/svg" width="10" height="20" viewBox="0 0 10 20">
+<rect width="10" height="20" fill="white"/>
+<line x1="0" y1="20" x2="5" y2="0" stroke="black"/>
+<line x1="10" y1="20" x2="10" y2="0" stroke="black"/>
+</svg>
+`
+	if string(content) != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, content)
+	}
+
+	testEval(painter+fmt.Sprintf(`(paint (below diagonal (rotate90 edge)) %q 8 8)`, pngPath), t)
+	file, err := os.Open(pngPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	img, err := png.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 8 || img.Bounds().Dy() != 8 {
+		t.Fatalf("expected an 8x8 image, got %v", img.Bounds())
+	}
+	if r, _, _, _ := img.At(0, 7).RGBA(); r != 0 {
+		t.Fatalf("expected the bottom left pixel to be drawn")
+	}
+
+	testEvalError(painter+`(diagonal (make-frame (make-vect 0 0) (make-vect 1 0) (make-vect 0 1)))`, t)
+	testEvalError(painter+fmt.Sprintf(`(paint diagonal %q)`, filepath.Join(dir, "picture.gif")), t)
+}
+
+func TestEvaluator_GenericArithmetic(t *testing.T) {
+	packages := `
+(define (gcd a b) (if (= b 0) a (gcd b (remainder a b))))
+(define (install-scheme-number-package)
+  (define (tag x) (attach-tag 'scheme-number x))
+  (put 'add '(scheme-number scheme-number) (lambda (x y) (tag (+ x y))))
+  (put 'make 'scheme-number (lambda (x) (tag x)))
+  'done)
+(define (install-rational-package)
+  (define (numer x) (car x))
+  (define (denom x) (cdr x))
+  (define (make-rat n d) (let ((g (gcd n d))) (cons (/ n g) (/ d g))))
+  (define (add-rat x y)
+    (make-rat (+ (* (numer x) (denom y)) (* (numer y) (denom x))) (* (denom x) (denom y))))
+  (define (tag x) (attach-tag 'rational x))
+  (put 'add '(rational rational) (lambda (x y) (tag (add-rat x y))))
+  (put 'make 'rational (lambda (n d) (tag (make-rat n d))))
+  'done)
+(install-scheme-number-package)
+(install-rational-package)
+(define (add x y) (apply-generic 'add x y))
+(define (make-rational n d) ((get 'make 'rational) n d))
+`
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{`(add 1 2)`, `3`},
+		{`(add (make-rational 1 2) (make-rational 1 3))`, `'(rational 5 . 6)`},
+		{`(type-tag (make-rational 1 2))`, `'rational`},
+		{`(type-tag 3.5)`, `'scheme-number`},
+		{`(contents (attach-tag 'scheme-number 3))`, `3`},
+		{`(contents (attach-tag 'polar '(1 2)))`, `'(1 2)`},
+		{`(get 'sub '(rational rational))`, `#f`},
+		{`(put-coercion 'scheme-number 'rational (lambda (n) (make-rational n 1))) (add ((get-coercion 'scheme-number 'rational) 2) (make-rational 1 2))`, `'(rational 5 . 2)`},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(packages+tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+
+	testEvalError(packages+`(add 1 (make-rational 1 2))`, t)
+	testEvalError(`(type-tag 'untagged)`, t)
+}
+
+func TestEvaluator_RuntimeStats(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{`(map car (memory-usage))`, `'(heap-alloc heap-in-use heap-objects total-alloc mallocs frees sys)`},
+		{`(map car (gc-stats))`, `'(gc-cycles forced-gc-cycles pause-total-ns last-pause-ns next-gc-heap-size)`},
+		{`(> (cdr (car (memory-usage))) 0)`, `#t`},
+		{`(define before (cdr (car (gc-stats)))) (define (loop n) (if (> n 0) (begin (list n n n) (loop (- n 1))))) (loop 1000) (>= (cdr (car (gc-stats))) before)`, `#t`},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+}
+
+func TestEvaluator_CommandLine(t *testing.T) {
+	program, err := parser.New(lexer.New(strings.NewReader(`(command-line)`))).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	e := New(WithStdin(strings.NewReader("")))
+	ret, err := e.Eval(program)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ret.String() != `'()` {
+		t.Fatalf("expected an empty command line, got %s", ret.String())
+	}
+
+	e.SetCommandLine([]string{"script.scm", "a", "b c"})
+	ret, err = e.Eval(program)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := `'("script.scm" "a" "b c")`; ret.String() != expected {
+		t.Fatalf("expected %s, got %s", expected, ret.String())
+	}
+}
+
+func TestEvaluator_RunExitHooks(t *testing.T) {
+	program, err := parser.New(lexer.New(strings.NewReader(`
+(define log '())
+(on-exit (lambda () (set! log (cons 'first log))))
+(on-exit (lambda () (car '())))
+(on-exit (lambda () (set! log (cons 'last log))))
+`))).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	e := New(WithStdin(strings.NewReader("")))
+	if _, err := e.Eval(program); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := e.RunExitHooks(); conditionKindOf(err) != WrongTypeCondition {
+		t.Fatalf("expected the failing hook's wrong-type condition, got %v", err)
+	}
+	if err := e.RunExitHooks(); err != nil {
+		t.Fatalf("expected the hooks to run once, got %v", err)
+	}
+
+	program, _ = parser.New(lexer.New(strings.NewReader(`log`))).Parse()
+	ret, err := e.Eval(program)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ret.String() != `'(first last)` {
+		t.Fatalf("expected the hooks to run last registered first, got %s", ret.String())
+	}
+}
+
+func TestEvaluator_Register(t *testing.T) {
+	e := New(WithStdin(strings.NewReader("")))
+	registrations := map[string]any{
+		"shout": func(s string) string { return strings.ToUpper(s) + "!" },
+		"sum": func(base float64, ns ...int) float64 {
+			for _, n := range ns {
+				base += float64(n)
+			}
+			return base
+		},
+		"byte-of": func(n uint8) uint8 { return n },
+		"evens": func(ns []int64) []int64 {
+			evens := make([]int64, 0)
+			for _, n := range ns {
+				if n%2 == 0 {
+					evens = append(evens, n)
+				}
+			}
+			return evens
+		},
+		"describe": func(v any) string { return fmt.Sprintf("%T", v) },
+		"checked": func(ok bool) (*ReturnValue, error) {
+			if !ok {
+				return nil, errors.New("not ok")
+			}
+			return &ReturnValue{Type: SymbolType, Data: "fine"}, nil
+		},
+	}
+	for name, fn := range registrations {
+		if err := e.Register(name, fn); err != nil {
+			t.Fatalf("unexpected error registering %s: %v", name, err)
+		}
+	}
+	for _, fn := range []any{42, func(c chan int) {}, func() (int, int) { return 0, 0 }} {
+		if err := e.Register("bad", fn); err == nil {
+			t.Fatalf("expected registering %T to fail", fn)
+		}
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`(shout "hi")`, `"HI!"`},
+		{`(sum 0.5)`, `0.5`},
+		{`(sum 0.5 1 2)`, `3.5`},
+		{`(evens '(1 2 3 4))`, `'(2 4)`},
+		{`(list (describe 1) (describe 1.5) (describe '(a)) (describe #t))`, `'("int64" "float64" "[]interface {}" "bool")`},
+		{`(checked #t)`, `'fine`},
+	}
+	for _, tt := range tests {
+		program, err := parser.New(lexer.New(strings.NewReader(tt.input))).Parse()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ret, err := e.Eval(program)
+		if err != nil {
+			t.Fatalf("input %s, unexpected error: %v", tt.input, err)
+		}
+		if ret.String() != tt.expected {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expected, ret.String())
+		}
+	}
+
+	errorTests := []struct {
+		input    string
+		expected ConditionKind
+	}{
+		{`(shout)`, WrongArityCondition},
+		{`(shout 1)`, WrongTypeCondition},
+		{`(sum)`, WrongArityCondition},
+		{`(sum 1 1.5)`, WrongTypeCondition},
+		{`(byte-of 256)`, WrongTypeCondition},
+		{`(byte-of -1)`, WrongTypeCondition},
+		{`(evens '(1 "2"))`, WrongTypeCondition},
+		{`(checked #f)`, GenericCondition},
+	}
+	for _, tt := range errorTests {
+		program, err := parser.New(lexer.New(strings.NewReader(tt.input))).Parse()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := e.Eval(program); err == nil || conditionKindOf(err) != tt.expected {
+			t.Fatalf("input %s, expected a %s condition, got %v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestFromGoToGo(t *testing.T) {
+	type celsius float64
+	n := 7
+	tests := []struct {
+		value    any
+		expected string
+		back     any
+	}{
+		{nil, "<void>", nil},
+		{true, "#t", true},
+		{"str", `"str"`, "str"},
+		{uint16(3), "3", int64(3)},
+		{celsius(21.5), "21.5", 21.5},
+		{&n, "7", int64(7)},
+		{[]any{1, "a", []string{"b"}}, `'(1 "a" ("b"))`, []any{int64(1), "a", []any{"b"}}},
+		{map[string]int{"b": 2, "a": 1}, `'(("a" . 1) ("b" . 2))`, nil},
+	}
+	for _, tt := range tests {
+		rv, err := FromGo(tt.value)
+		if err != nil {
+			t.Fatalf("FromGo(%v), unexpected error: %v", tt.value, err)
+		}
+		if rv.String() != tt.expected {
+			t.Fatalf("FromGo(%v), expected %s, got %s", tt.value, tt.expected, rv.String())
+		}
+		if tt.back != nil && !reflect.DeepEqual(rv.ToGo(), tt.back) {
+			t.Fatalf("FromGo(%v).ToGo(), expected %#v, got %#v", tt.value, tt.back, rv.ToGo())
+		}
+	}
+	if _, err := FromGo(make(chan int)); err == nil {
+		t.Fatalf("expected converting a channel to fail")
+	}
+	if _, err := FromGo(uint64(1 << 63)); err == nil {
+		t.Fatalf("expected converting an uint64 beyond int64 to fail")
+	}
+
+	e := New(WithStdin(strings.NewReader("")))
+	if err := e.Register("lookup", func(m map[string]int, key string) int { return m[key] }); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	program, _ := parser.New(lexer.New(strings.NewReader(`(lookup (list (cons "a" 1) (cons "b" 2)) "b")`))).Parse()
+	ret, err := e.Eval(program)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ret.String() != "2" {
+		t.Fatalf("expected 2, got %s", ret.String())
+	}
+}
+
+type testPerson struct {
+	FirstName string
+	Age       int
+	Tags      []string
+	secret    string
+}
+
+func (p *testPerson) Greet(greeting string) string {
+	return greeting + ", " + p.FirstName
+}
+
+func (p *testPerson) Birthday() {
+	p.Age++
+}
+
+func TestEvaluator_RegisterType(t *testing.T) {
+	e := New(WithStdin(strings.NewReader("")))
+	if err := e.RegisterType("person", testPerson{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := e.Register("make-person", func(name string, age int) *testPerson { return &testPerson{FirstName: name, Age: age} }); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := e.RegisterType("number", 1); err == nil {
+		t.Fatalf("expected registering a non-struct type to fail")
+	}
+
+	ada := &testPerson{FirstName: "Ada", Age: 36, secret: "s"}
+	p, err := FromGo(ada)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	e.GlobalEnvironment().Put("ada", p)
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`(person-first-name ada)`, `"Ada"`},
+		{`(send ada 'greet "hi")`, `"hi, Ada"`},
+		{`(send ada "Greet" "hello")`, `"hello, Ada"`},
+		{`(begin (send ada 'birthday) (person-age ada))`, "37"},
+		{`(begin (set-person-tags! ada (list "math")) (person-tags ada))`, `'("math")`},
+		{`(person-first-name (make-person "Grace" 45))`, `"Grace"`},
+		{`(list (person? ada) (person? 1))`, "'(#t #f)"},
+	}
+	for _, tt := range tests {
+		program, _ := parser.New(lexer.New(strings.NewReader(tt.input))).Parse()
+		ret, err := e.Eval(program)
+		if err != nil {
+			t.Fatalf("%s, unexpected error: %v", tt.input, err)
+		}
+		if ret.String() != tt.expected {
+			t.Fatalf("%s, expected %s, got %s", tt.input, tt.expected, ret.String())
+		}
+	}
+	if ada.Age != 37 || len(ada.Tags) != 1 {
+		t.Fatalf("expected soup to update the Go struct, got %+v", ada)
+	}
+
+	errorTests := []struct {
+		input string
+		kind  ConditionKind
+	}{
+		{`(person-age 1)`, WrongTypeCondition},
+		{`(set-person-age! ada "old")`, WrongTypeCondition},
+		{`(send ada 'greet)`, WrongArityCondition},
+		{`(send ada 'fly)`, GenericCondition},
+	}
+	for _, tt := range errorTests {
+		program, _ := parser.New(lexer.New(strings.NewReader(tt.input))).Parse()
+		_, err := e.Eval(program)
+		if err == nil || conditionKindOf(err) != tt.kind {
+			t.Fatalf("%s, expected a %s condition, got %v", tt.input, tt.kind, err)
+		}
+	}
+
+	if _, ok := e.GlobalEnvironment().Get("person-secret"); ok {
+		t.Fatalf("expected unexported fields not to be bound")
+	}
+	if names := []string{lispName("Name"), lispName("FirstName"), lispName("HTTPServer"), lispName("ID")}; strings.Join(names, " ") != "name first-name http-server id" {
+		t.Fatalf("unexpected soup names %v", names)
+	}
+}
+
+func TestReturnValue_JSON(t *testing.T) {
+	e := New(WithStdin(strings.NewReader("")))
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`(list 1 2.0 -0.5 "a\"b" 'sym #t #f)`, `[1,2.0,-0.5,"a\"b","sym",true,false]`},
+		{`'()`, `[]`},
+		{`(list (cons 'name "soup") (cons "tags" (vector 'a 'b)))`, `{"name":"soup","tags":["a","b"]}`},
+		{`(list (list "a" 1) (list "b" 2))`, `[["a",1],["b",2]]`},
+		{`(let ((t (make-hash-table))) (hash-table-set! t "b" (list 1)) (hash-table-set! t 'a (list (cons 'c #t))) t)`, `{"a":{"c":true},"b":[1]}`},
+		{`(make-hash-table eqv?)`, `{}`},
+		{`(newline)`, `null`},
+		{`(vector 1 (vector "a") (list))`, `[1,["a"],[]]`},
+		{`(list #\a #\space #\")`, `["a"," ","\""]`},
+	}
+	for _, tt := range tests {
+		program, _ := parser.New(lexer.New(strings.NewReader(tt.input))).Parse()
+		ret, err := e.Eval(program)
+		if err != nil {
+			t.Fatalf("%s, unexpected error: %v", tt.input, err)
+		}
+		data, err := json.Marshal(ret)
+		if err != nil {
+			t.Fatalf("%s, unexpected error: %v", tt.input, err)
+		}
+		if string(data) != tt.expected {
+			t.Fatalf("%s, expected %s, got %s", tt.input, tt.expected, data)
+		}
+	}
+
+	for _, input := range []string{
+		`(lambda (x) x)`, `(cons 1 2)`, `(list (cons 'a 1) (cons 'a 2))`, `(list (/ 0.0 0))`, `-inf.0`, `(bytevector 1)`,
+		`(list (cons "a" 1) (cons "b" (list 2)))`,
+		`(let ((t (make-hash-table))) (hash-table-set! t 1 'one) t)`,
+		`(let ((t (make-hash-table))) (hash-table-set! t "a" 1) (hash-table-set! t 'a 2) t)`,
+	} {
+		program, _ := parser.New(lexer.New(strings.NewReader(input))).Parse()
+		ret, err := e.Eval(program)
+		if err != nil {
+			t.Fatalf("%s, unexpected error: %v", input, err)
+		}
+		if _, err := json.Marshal(ret); err == nil {
+			t.Fatalf("%s, expected marshaling to fail", input)
+		}
+	}
+
+	var rv ReturnValue
+	if err := json.Unmarshal([]byte(`{"b": [1, 2.5, 1e3, null], "a": {"ok": true}}`), &rv); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rv.Type != HashTableType {
+		t.Fatalf("expected an object to be read as a hash table, got %s", rv.String())
+	}
+	entry, _, _ := rv.HashTable().lookup(newString("b"), "test")
+	if expected := `'(1 2.5 1000. <void>)`; entry == nil || entry.value.String() != expected {
+		t.Fatalf("expected b to be %s, got %v", expected, entry)
+	}
+	b, _ := listElements(entry.value)
+	if n := b[2]; n.Number().isInt64() {
+		t.Fatalf("expected 1e3 to be read as a float")
+	}
+	data, _ := json.Marshal(&rv)
+	if expected := `{"a":{"ok":true},"b":[1,2.5,1000.0,null]}`; string(data) != expected {
+		t.Fatalf("expected %s, got %s", expected, data)
+	}
+}
+
+func TestEvaluator_SetOutput(t *testing.T) {
+	program, err := parser.New(lexer.New(strings.NewReader(`(display "a") (display '(1 "b")) (newline) (print 1 'x) (pp '(1 2)) (car '())`))).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var stdout, stderr strings.Builder
+	e := New(WithStdin(strings.NewReader("")))
+	e.SetOutput(&stdout, &stderr)
+	if _, err := e.Eval(program); err == nil {
+		t.Fatalf("expected car to fail")
+	}
+	if expected := "a'(1 \"b\")\n1 'x\n(1 2)\n"; stdout.String() != expected {
+		t.Fatalf("expected stdout %q, got %q", expected, stdout.String())
+	}
+	// the failing call is reported by the error Eval returns, not on stderr
+	if stderr.String() != "" {
+		t.Fatalf("expected nothing on stderr, got %q", stderr.String())
+	}
+}
+
+// countingWriter counts the writes made to it.
+type countingWriter struct {
+	strings.Builder
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Builder.Write(p)
+}
+
+// promptReader records what was written to stdout when read was called.
+type promptReader struct {
+	stdout   *countingWriter
+	prompted string
+}
+
+func (r *promptReader) Read(p []byte) (int, error) {
+	r.prompted = r.stdout.String()
+	return copy(p, "42"), io.EOF
+}
+
+func TestEvaluator_AllocationArena(t *testing.T) {
+	e := New(WithStdin(strings.NewReader("")), WithAllocationArena())
+	runs := []struct {
+		src      string
+		expected string
+	}{
+		{`(define (build n) (if (= n 0) '() (cons n (build (- n 1))))) (define xs (build 600)) (define total (apply + xs)) total`, "180300"},
+		{`(define ys (cons 1.5 2)) (* total 2)`, "360600"},
+		// the values defined by the earlier runs are still intact
+		{`(list (length xs) (car xs) (car (reverse xs)) ys total)`, "'(600 600 1 (1.5 . 2) 180300)"},
+		{`(list (eqv? total 180300) (= (car ys) 1.5) (exact->inexact (/ total 600)) (- total))`, `'(#t #t 300.5 -180300)`},
+	}
+	for _, run := range runs {
+		program, err := parser.New(lexer.New(strings.NewReader(run.src))).Parse()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ret, err := e.Eval(program)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ret.String() != run.expected {
+			t.Fatalf("expected %s, got %s", run.expected, ret.String())
+		}
+	}
+}
+
+func TestEvaluator_Optimizer(t *testing.T) {
+	tests := []struct {
+		input     string
+		optimized string
+		expected  string
+	}{
+		{"(+ 1 (* 2 3) (- 10 4))", "13", "13"},
+		{"(/ 1 4)", "1/4", "1/4"},
+		{"(/ 1. 4)", "0.25", "0.25"},
+		{"(if (< 1 2) 'yes 'no)", "'yes", "'yes"},
+		{"(if #f 1)", "", "<void>"},
+		{"(let ((x 2) (y 3)) (* x y))", "6", "6"},
+		{"(let ((x 2) (y (list 1))) (cons x y))", "((lambda (y) (cons 2 y)) (list 1))", "'(2 1)"},
+		{"(let ((x 1)) (let ((x 2)) x))", "2", "2"},
+		{"(begin 1 \"two\" (lambda (x) x) (display 3) 4)", "(begin (display 3) 4)", "4"},
+		{"(begin 1 2)", "2", "2"},
+		// a division by zero is left to raise its error when it's evaluated
+		{"(if #f (remainder 1 0) 5)", "5", "5"},
+		{"(lambda () (remainder 1 0))", "(lambda () (remainder 1 0))", "<procedure>"},
+		// a name that's bound in the expression isn't the builtin
+		{"(let ((remainder +)) (remainder 5 2))", "((lambda (remainder) (remainder 5 2)) +)", "7"},
+		{"(begin (define (* a b) a) (* 5 2))", "(begin (define (* a b) a) (* 5 2))", "5"},
+		{"(let ((x 1)) (begin (set! x 2) x))", "((lambda (x) (begin (set! x 2) x)) 1)", "2"},
+	}
+	for _, tt := range tests {
+		program, err := parser.New(lexer.New(strings.NewReader(tt.input))).Parse()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		e := New(WithStdin(strings.NewReader("")), WithStdout(io.Discard), WithOptimizer())
+		optimized := e.optimize(program.Expressions[0], e.globalEnv)
+		if optimized.String() != tt.optimized {
+			t.Fatalf("input %s, expected it optimized to %s, got %s", tt.input, tt.optimized, optimized.String())
+		}
+		ret, err := e.Eval(program)
+		if err != nil {
+			t.Fatalf("input %s, unexpected error: %v", tt.input, err)
+		}
+		if ret.String() != tt.expected {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expected, ret.String())
+		}
+		if unoptimized := testEval(tt.input, t); unoptimized.String() != ret.String() {
+			t.Fatalf("input %s, the optimizer changed its value from %s to %s", tt.input, unoptimized.String(), ret.String())
+		}
+	}
+
+	// a builtin redefined by an earlier expression isn't folded
+	program, err := parser.New(lexer.New(strings.NewReader("(define (+ a b) (* a b)) (+ 2 3)"))).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ret, err := New(WithStdin(strings.NewReader("")), WithOptimizer()).Eval(program)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ret.String() != "6" {
+		t.Fatalf("expected 6, got %s", ret.String())
+	}
+}
+
+func TestEvaluator_QuotedLiteral(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// every evaluation of a quote returns the same value
+		{`(define (f) '(a b)) (eq? (f) (f))`, "#t"},
+		{`(define (f) ''a) (eq? (f) (f))`, "#t"},
+		{`(eq? '(a b) '(a b))`, "#f"},
+		{`(define (f) '(1 (2 3))) (list (f) (cadr (f)) (cdr (f)))`, "'((1 (2 3)) (2 3) ((2 3)))"},
+		{`(define l (cons 0 '(1 2))) (eq? (cdr l) '(1 2))`, "#f"},
+		{`(define l (list 1 2)) (set-car! l 3) l`, "'(3 2)"},
+	}
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expected {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expected, ret.String())
+		}
+	}
+
+	for _, input := range []string{
+		`(set-car! '(1 2) 3)`,
+		`(set-cdr! '(1 2) 3)`,
+		`(set-car! (cdr '(1 2)) 3)`,
+		`(set-car! (car '((1) 2)) 3)`,
+		`(set-cdr! ''a 3)`,
+		// a pair built on a literal shares it
+		`(define l (cons 0 '(1 2))) (set-car! (cdr l) 5)`,
+	} {
+		err := testEvalError(input, t)
+		if conditionKindOf(err) != WrongTypeCondition || !strings.Contains(err.Error(), "cannot modify a quoted literal") {
+			t.Fatalf("input %s, expected a wrong-type condition about the literal, got %v", input, err)
+		}
+	}
+}
+
+func TestEvaluator_BufferedOutput(t *testing.T) {
+	program, err := parser.New(lexer.New(strings.NewReader(`(define (loop i) (if (< i 5) (begin (display i) (loop (+ i 1))))) (loop 0) (newline) (display "end")`))).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	buffered := &countingWriter{}
+	e := New(WithStdin(strings.NewReader("")), WithStdout(buffered))
+	if _, err := e.Eval(program); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// one write for the line newline ended, one when the run ended
+	if buffered.String() != "01234\nend" || buffered.writes != 2 {
+		t.Fatalf("expected \"01234\\nend\" in 2 writes, got %q in %d", buffered.String(), buffered.writes)
+	}
+
+	unbuffered := &countingWriter{}
+	e = New(WithStdin(strings.NewReader("")), WithStdout(unbuffered), WithUnbufferedOutput())
+	if _, err := e.Eval(program); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if unbuffered.String() != "01234\nend" || unbuffered.writes != 7 {
+		t.Fatalf("expected \"01234\\nend\" in 7 writes, got %q in %d", unbuffered.String(), unbuffered.writes)
+	}
+
+	stdout := &countingWriter{}
+	stdin := &promptReader{stdout: stdout}
+	e = New(WithStdin(stdin), WithStdout(stdout))
+	program, _ = parser.New(lexer.New(strings.NewReader(`(display "n? ") (read)`))).Parse()
+	ret, err := e.Eval(program)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stdin.prompted != "n? " || ret.String() != "42" {
+		t.Fatalf("expected the prompt before reading 42, got %q and %s", stdin.prompted, ret)
+	}
+}
+
+func TestNew_Options(t *testing.T) {
+	parse := func(input string) *parser.Program {
+		program, err := parser.New(lexer.New(strings.NewReader(input))).Parse()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return program
+	}
+	randoms := func(opts ...Option) string {
+		var stdout strings.Builder
+		e := New(append([]Option{WithStdin(strings.NewReader("")), WithStdout(&stdout)}, opts...)...)
+		if _, err := e.Eval(parse(`(display (list (random 1000) (random 1000) (random 1000)))`)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return stdout.String()
+	}
+	if randoms() != randoms() || randoms(WithRandomSeed(1)) != randoms(WithRandomSeed(1)) {
+		t.Fatalf("expected the same seed to give the same numbers")
+	}
+	if randoms(WithRandomSeed(1)) == randoms(WithRandomSeed(2)) {
+		t.Fatalf("expected different seeds to give different numbers")
+	}
+
+	e := New(
+		WithStdin(strings.NewReader("(1 2)")),
+		WithSandbox(NoCapabilities),
+		WithLimits(Limits{MaxSteps: 1000}),
+		WithCommandLine([]string{"prog", "arg"}),
+	)
+	if ret, err := e.Eval(parse(`(list (read) (command-line))`)); err != nil || ret.String() != `'((1 2) ("prog" "arg"))` {
+		t.Fatalf("expected stdin and the command line to be set, got %v, %v", ret, err)
+	}
+	if e.Capabilities() != NoCapabilities || e.Limits().MaxSteps != 1000 {
+		t.Fatalf("expected the sandbox and limits to be set, got %s and %+v", e.Capabilities(), e.Limits())
+	}
+}
+
+func TestEvaluator_Fork(t *testing.T) {
+	parse := func(input string) *parser.Program {
+		program, err := parser.New(lexer.New(strings.NewReader(input))).Parse()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return program
+	}
+
+	base := New(WithStdin(strings.NewReader("")))
+	if err := base.Register("twice", func(n int) int { return 2 * n }); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := base.Eval(parse(`(define base-only 1)`)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	results := make([]string, 8)
+	errs := make([]error, 8)
+	done := make(chan struct{})
+	for i := range results {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			var stdout strings.Builder
+			e := base.Fork(WithStdin(strings.NewReader("")), WithStdout(&stdout))
+			_, errs[i] = e.Eval(parse(fmt.Sprintf(`(define n %d) (define (square x) n) (display (list (square 3) (twice n) ((builtin 'square) 3)))`, i)))
+			results[i] = stdout.String()
+		}()
+	}
+	for range results {
+		<-done
+	}
+	for i, result := range results {
+		if errs[i] != nil {
+			t.Fatalf("fork %d, unexpected error: %v", i, errs[i])
+		}
+		if expected := fmt.Sprintf("'(%d %d 9)", i, 2*i); result != expected {
+			t.Fatalf("fork %d, expected %s, got %s", i, expected, result)
+		}
+	}
+
+	// the fork starts with the definitions of the base evaluator, then each goes its own way
+	fork := base.Fork(WithStdin(strings.NewReader("")))
+	if ret, err := fork.Eval(parse(`base-only`)); err != nil || ret.String() != "1" {
+		t.Fatalf("expected the fork to see the definitions made before it, got %v, %v", ret, err)
+	}
+	if _, err := base.Eval(parse(`(define after-fork 2) (set! base-only 3)`)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := fork.Eval(parse(`after-fork`)); err == nil {
+		t.Fatalf("expected the definitions made after the fork not to be shared")
+	}
+	if ret, err := fork.Eval(parse(`(set! base-only 4) base-only`)); err != nil || ret.String() != "4" {
+		t.Fatalf("expected the fork to keep its own base-only, got %v, %v", ret, err)
+	}
+	if ret, err := base.Eval(parse(`base-only`)); err != nil || ret.String() != "3" {
+		t.Fatalf("expected the base evaluator to keep its own base-only, got %v, %v", ret, err)
+	}
+	if _, err := fork.Eval(parse(`(set! square 1)`)); err == nil {
+		t.Fatalf("expected set! of a shared prelude procedure to fail")
+	}
+	if err := fork.Register("fork-only", func() int { return 1 }); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := base.Eval(parse(`(fork-only)`)); err == nil {
+		t.Fatalf("expected a builtin registered on a fork to stay in it")
+	}
+	if ret, err := base.Eval(parse(`(square 3)`)); err != nil || ret.String() != "9" {
+		t.Fatalf("expected the base evaluator to keep its square, got %v, %v", ret, err)
+	}
+}
+
+func TestEnvironment_Names(t *testing.T) {
+	e := New(WithStdin(strings.NewReader("")))
+	program, err := parser.New(lexer.New(strings.NewReader(`(define car 1) (define zzz-mine 2)`))).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := e.Eval(program); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names := e.GlobalEnvironment().Names()
+	if !sort.StringsAreSorted(names) {
+		t.Fatalf("expected the names to be sorted")
+	}
+	count := make(map[string]int)
+	for _, name := range names {
+		count[name]++
+	}
+	for _, name := range []string{"car", "zzz-mine", "map"} {
+		if count[name] != 1 {
+			t.Fatalf("expected %s to be listed once, got %d times", name, count[name])
+		}
+	}
+	local := e.GlobalEnvironment().LocalNames()
+	if !reflect.DeepEqual(local, []string{"car", "zzz-mine"}) {
+		t.Fatalf("expected only the global defines, got %v", local)
+	}
+	if e.GlobalEnvironment().Enclosing() == nil {
+		t.Fatalf("expected the global environment to be enclosed by the builtins")
+	}
+}
+
+func TestEnvironment_CallEnvironment(t *testing.T) {
+	env := newCallEnvironment(newEnvironment())
+	names := []string{"a", "b", "c", "d", "e", "f"}
+	for i, name := range names {
+		env.Put(name, &ReturnValue{Type: NumberType, Data: MakeInt64Number(int64(i))})
+		if _, err := env.Update("a", &ReturnValue{Type: NumberType, Data: MakeInt64Number(int64(10 + i))}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(env.LocalNames(), names[:i+1]) {
+			t.Fatalf("expected %v, got %v", names[:i+1], env.LocalNames())
+		}
+	}
+	for i, name := range names[1:] {
+		val, ok := env.Get(name)
+		if !ok || val.Number().Int64() != int64(i+1) {
+			t.Fatalf("expected %s to be %d, got %v", name, i+1, val)
+		}
+	}
+	if val, _ := env.Get("a"); val.Number().Int64() != 15 {
+		t.Fatalf("expected a to be 15, got %s", val)
+	}
+	if _, ok := env.Get("g"); ok {
+		t.Fatalf("expected g to be unbound")
+	}
+}
+
+func TestEvaluator_Image(t *testing.T) {
+	parse := func(input string) *parser.Program {
+		program, err := parser.New(lexer.New(strings.NewReader(input))).Parse()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return program
+	}
+
+	path := filepath.Join(t.TempDir(), "session.image")
+	saver := New(WithStdin(strings.NewReader("")))
+	_, err := saver.Eval(parse(fmt.Sprintf(`
+(define counter 3)
+(define ratio 2.0)
+(define names (list "a\"b" 'c (cons 1 2) '()))
+(define grid (vector 1 (vector 'x "y") (list 2)))
+(define (corners) #(0 (1 "a")))
+(define letters (list #\a (string-ref "é" 0) '(#\space #\x0)))
+(define header (vector (bytevector 0 127 255) (bytevector)))
+(define (inc x) (+ x 1))
+(define (sum first . rest) (if (null? rest) first (+ first (apply sum rest))))
+(define (classify n) (cond ((< n 0) 'negative) ((= n 0) 'zero) (else (let ((m (* n 2))) m))))
+(define make-adder (lambda (n) (lambda (x) (+ x n))))
+(define increment inc)
+(define shared (list 1 2))
+(define holders (vector (list 'x shared) (cons 0 shared)))
+(define loop (list 1 2 3))
+(set-cdr! (cddr loop) loop)
+(define self (vector 1 "a"))
+(vector-set! self 0 self)
+(define table (make-hash-table))
+(hash-table-set! table shared 'shared)
+(hash-table-set! table "key" (list inc car (lambda (x) (* x 10))))
+(define ids (make-hash-table eqv?))
+(hash-table-set! ids 'loop loop)
+(define buffer (string-copy "abc"))
+(define alias buffer)
+(save-image %q)`, path)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	image, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// a float written as 2 would come back an integer
+	if !strings.Contains(string(image), "(define ratio 2.0)\n") {
+		t.Fatalf("expected ratio to be written as a float, got\n%s", image)
+	}
+
+	loader := New(WithStdin(strings.NewReader("")))
+	if err := loader.LoadImage(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"counter", "3"},
+		{"(/ ratio 4)", "0.5"},
+		{"names", `'("a\"b" c (1 . 2) ())`},
+		{"grid", `'#(1 #(x "y") (2))`},
+		{"(corners)", `'#(0 (1 "a"))`},
+		{"letters", `'(#\a #\é (#\space #\null))`},
+		{"header", "'#(#u8(0 127 255) #u8())"},
+		{"(inc counter)", "4"},
+		{"(sum 1 2 3)", "6"},
+		{"(list (classify -1) (classify 0) (classify 4))", "'(negative zero 8)"},
+		{"((make-adder 5) 1)", "6"},
+		{"(eq? increment inc)", "#t"},
+		{"(list (eq? (cadr (vector-ref holders 0)) shared) (eq? (cdr (vector-ref holders 1)) shared))", "'(#t #t)"},
+		{"(list (list-ref loop 4) (eq? (cdddr loop) loop))", "'(2 #t)"},
+		{"(list (eq? (vector-ref self 0) self) (vector-ref self 1))", `'(#t "a")`},
+		{"(hash-table-ref table shared (lambda () 'missing))", "'shared"},
+		{`(let ((procedures (hash-table-ref table "key" (lambda () '())))) (list ((car procedures) 1) ((cadr procedures) '(2)) ((caddr procedures) 3)))`, "'(2 2 30)"},
+		{"(eq? (hash-table-ref ids 'loop (lambda () 'missing)) loop)", "#t"},
+		{"(begin (string-set! buffer 0 #\\z) alias)", `"zbc"`},
+	}
+	for _, tt := range tests {
+		result, err := loader.Eval(parse(tt.input))
+		if err != nil {
+			t.Fatalf("%s, unexpected error: %v", tt.input, err)
+		}
+		if result.String() != tt.expected {
+			t.Fatalf("%s, expected %s, got %s", tt.input, tt.expected, result.String())
+		}
+	}
+}
+
+func TestEvaluator_ImageErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedWarning string
+	}{
+		{"(define add2 (let ((n 2)) (lambda (x) (+ x n))))", "can't save add2, a procedure closing over a local environment can't be written"},
+		{"(define handlers (list 1 (let ((n 2)) (lambda (x) (+ x n)))))", "can't save handlers, a procedure closing over a local environment can't be written"},
+		{"(define (f . args) args) (define fs (list f)) (set! f 1)", "can't save fs, a lambda with a tail parameter can only be written as a define"},
+		{"(define p (delay 1))", "can't save p, a value of type Promise can't be written"},
+		{"(define p (make-parameter 5))", "can't save p, a value of type BuiltinFunction can't be written"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "session.image")
+		program, err := parser.New(lexer.New(strings.NewReader(fmt.Sprintf("(define counter 1) %s (save-image %q)", tt.input, path)))).Parse()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var stderr bytes.Buffer
+		ret, err := New(WithStdin(strings.NewReader("")), WithStderr(&stderr)).Eval(program)
+		name := strings.TrimSuffix(strings.Fields(tt.expectedWarning)[2], ",")
+		if err != nil || ret.String() != "'("+name+")" {
+			t.Fatalf("%s, expected the unsaved binding %s to be returned, got %v, %v", tt.input, name, ret, err)
+		}
+		if stderr.String() != "warning: 'save-image' "+tt.expectedWarning+"\n" {
+			t.Fatalf("%s, expected warning %q, got %q", tt.input, tt.expectedWarning, stderr.String())
+		}
+
+		// the bindings that could be saved are
+		loader := New(WithStdin(strings.NewReader("")))
+		if err := loader.LoadImage(path); err != nil {
+			t.Fatalf("%s, unexpected error: %v", tt.input, err)
+		}
+		if counter, ok := loader.GlobalEnvironment().Get("counter"); !ok || counter.String() != "1" {
+			t.Fatalf("%s, expected counter to be saved, got %v", tt.input, counter)
+		}
+		if val, ok := loader.GlobalEnvironment().Get(name); ok {
+			t.Fatalf("%s, expected %s not to be saved, got %s", tt.input, name, val.String())
+		}
+	}
+
+	err := testEvalError(`(load-image "missing.image")`, t)
+	if !strings.HasPrefix(err.Error(), "'load-image' could not find missing.image") {
+		t.Fatalf("expected load-image to be named, got %v", err)
+	}
+}
+
+func TestEvaluator_Steps(t *testing.T) {
+	e := New(WithStdin(strings.NewReader("")))
+	run := func(input string) uint64 {
+		program, err := parser.New(lexer.New(strings.NewReader(input))).Parse()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		before := e.Steps()
+		if _, err := e.Eval(program); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return e.Steps() - before
+	}
+
+	run(`(define (loop n) (if (> n 0) (loop (- n 1)) 'done))`)
+	if small, large := run(`(loop 10)`), run(`(loop 100)`); small == 0 || large <= small {
+		t.Fatalf("expected more steps for a longer loop, got %d and %d", small, large)
+	}
+	if steps := run(`1`); steps != 1 {
+		t.Fatalf("expected 1 step for a literal, got %d", steps)
+	}
+}
+
+func TestEvaluator_Limits(t *testing.T) {
+	tests := []struct {
+		input           string
+		limits          Limits
+		expectedMessage string
+	}{
+		{`(define (fib n) (if (< n 2) n (+ (fib (- n 1)) (fib (- n 2))))) (fib 30)`, Limits{MaxSteps: 1000}, "evaluated more than 1000 steps"},
+		{`(define (count n) (if (= n 0) 0 (+ 1 (count (- n 1))))) (count 100)`, Limits{MaxDepth: 50}, "nested more than 50 deep"},
+		{`(define (fib n) (if (< n 2) n (+ (fib (- n 1)) (fib (- n 2))))) (fib 40)`, Limits{Timeout: 20 * time.Millisecond}, "ran for more than 20ms"},
+		{`(define (boom) (cons 1 (boom))) (boom)`, Limits{MaxCells: 10000}, "allocated more than 10000 cells"},
+		{`(make-vector 2000000000)`, Limits{MaxCells: 100000}, "allocated more than 100000 cells"},
+		{`(define (grow l) (grow (append l l))) (grow (list 1))`, Limits{MaxCells: 100000}, "allocated more than 100000 cells"},
+		{`(define (grow s) (grow (write-to-string (list s s)))) (grow "soup")`, Limits{MaxCells: 100000}, "allocated more than 100000 cells"},
+	}
+
+	for _, tt := range tests {
+		program, err := parser.New(lexer.New(strings.NewReader(tt.input))).Parse()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		e := New(WithStdin(strings.NewReader("")))
+		e.SetLimits(tt.limits)
+		_, err = e.Eval(program)
+		if conditionKindOf(err) != ResourceLimitCondition || !strings.Contains(err.Error(), tt.expectedMessage) {
+			t.Fatalf("input %s, expected a resource limit error containing %q, got %v", tt.input, tt.expectedMessage, err)
+		}
+	}
+
+	// running out of cells can be handled once, running out of steps can't
+	handled := []struct {
+		input          string
+		limits         Limits
+		expectedOutput string
+	}{
+		{`(define (boom) (cons 1 (boom))) (guard (e ((resource-limit-error? e) 'caught)) (boom))`, Limits{MaxCells: 10000}, `'caught`},
+		{`(guard (e (#t (error-object-message e))) (make-vector 2000000000))`, Limits{MaxCells: 100000}, `"resource limit exceeded: allocated more than 100000 cells"`},
+		{`(define (loop) (loop)) (guard (e (#t 'caught)) (loop))`, Limits{MaxSteps: 1000}, ``},
+		{`(define (boom) (cons 1 (boom))) (guard (e (#t (boom))) (boom))`, Limits{MaxCells: 10000}, ``},
+	}
+	for _, tt := range handled {
+		program, err := parser.New(lexer.New(strings.NewReader(tt.input))).Parse()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		e := New(WithStdin(strings.NewReader("")))
+		e.SetLimits(tt.limits)
+		ret, err := e.Eval(program)
+		if tt.expectedOutput == "" {
+			if conditionKindOf(err) != ResourceLimitCondition {
+				t.Fatalf("input %s, expected the resource limit not to be caught, got %v, %v", tt.input, ret, err)
+			}
+			continue
+		}
+		if err != nil || ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %v, %v", tt.input, tt.expectedOutput, ret, err)
+		}
+	}
+
+	// each run gets the full budget, and programs within the limits are unaffected
+	e := New(WithStdin(strings.NewReader("")))
+	e.SetLimits(Limits{MaxSteps: 100, MaxDepth: 10, MaxCells: 100})
+	program, err := parser.New(lexer.New(strings.NewReader(`(define (count n) (if (= n 0) 0 (+ 1 (count (- n 1))))) (count 5)`))).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		ret, err := e.Eval(program)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ret.String() != "5" {
+			t.Fatalf("expected 5, got %s", ret.String())
+		}
+	}
+}
+
+type recordingTracer struct {
+	events []string
+}
+
+func (r *recordingTracer) Enter(call TraceCall) {
+	args := make([]string, len(call.Args))
+	for i, arg := range call.Args {
+		args[i] = arg.Write()
+	}
+	r.events = append(r.events, fmt.Sprintf("%d > (%s)", call.Depth, strings.Join(append([]string{call.Name}, args...), " ")))
+}
+
+func (r *recordingTracer) Exit(call TraceCall, result *ReturnValue, err error) {
+	if err != nil {
+		r.events = append(r.events, fmt.Sprintf("%d < %s error: %s", call.Depth, call.Name, err))
+		return
+	}
+	r.events = append(r.events, fmt.Sprintf("%d < %s %s", call.Depth, call.Name, result.Write()))
+}
+
+func TestEvaluator_DeepRecursion(t *testing.T) {
+	count := `(define (count n) (if (= n 0) 0 (+ 1 (count (- n 1)))))`
+	deep := fmt.Sprint(3 * stackSegmentDepth)
+	eval := func(input string, limits Limits) (*ReturnValue, *Evaluator, error) {
+		program, err := parser.New(lexer.New(strings.NewReader(input))).Parse()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		e := New(WithStdin(strings.NewReader("")))
+		e.SetLimits(limits)
+		ret, err := e.Eval(program)
+		return ret, e, err
+	}
+
+	limits := Limits{MaxDepth: 4 * stackSegmentDepth}
+	ret, _, err := eval(count+`(count `+deep+`)`, limits)
+	if err != nil || ret.String() != deep {
+		t.Fatalf("expected %s, got %v, %v", deep, ret, err)
+	}
+	ret, _, err = eval(count+`(define g (make-generator (lambda (yield) (yield (count `+deep+`))))) (next g)`, limits)
+	if err != nil || ret.String() != deep {
+		t.Fatalf("expected %s from the generator, got %v, %v", deep, ret, err)
+	}
+
+	defaultMaxDepth := DefaultMaxDepth
+	DefaultMaxDepth = 2 * stackSegmentDepth
+	for _, limits := range []Limits{{MaxDepth: 2 * stackSegmentDepth}, {}} {
+		_, e, err := eval(count+`(count `+deep+`)`, limits)
+		if conditionKindOf(err) != ResourceLimitCondition {
+			t.Fatalf("expected a resource limit condition with %+v, got %v", limits, err)
+		}
+		if e.segmentDepth != 0 {
+			t.Fatalf("expected the error to unwind every segment, %d calls are left", e.segmentDepth)
+		}
+
+		// nesting too deep can be handled, like any other error
+		ret, _, err = eval(count+`(list (guard (e ((resource-limit-error? e) 'caught)) (count `+deep+`)) (count 5))`, limits)
+		if err != nil || ret.String() != `'(caught 5)` {
+			t.Fatalf("expected the guard to catch the limit with %+v, got %v, %v", limits, ret, err)
+		}
+		ret, _, err = eval(count+`(guard (e ((string? e) e)) (with-exception-handler (lambda (e) (raise (error-object-message e))) (lambda () (count `+deep+`))))`, limits)
+		if err != nil || !strings.Contains(ret.String(), "nested more than 20000 deep") {
+			t.Fatalf("expected the handler to see the limit with %+v, got %v, %v", limits, ret, err)
+		}
+	}
+	DefaultMaxDepth = defaultMaxDepth
+
+	// the default is only reached once the stacks would take half of the memory
+	if DefaultMaxDepth < 100000 {
+		t.Fatalf("expected a default depth of at least 100000, got %d", DefaultMaxDepth)
+	}
+	ret, _, err = eval(count+`(count 100000)`, Limits{})
+	if err != nil || ret.String() != "100000" {
+		t.Fatalf("expected 100000, got %v, %v", ret, err)
+	}
+	ret, _, err = eval(`(define (upto i n) (if (> i n) '() (cons i (upto (+ i 1) n)))) (length (upto 1 50000))`, Limits{})
+	if err != nil || ret.String() != "50000" {
+		t.Fatalf("expected a list of 50000 built by recursion, got %v, %v", ret, err)
+	}
+}
+
+func TestEvaluator_TailCalls(t *testing.T) {
+	// far deeper than DefaultMaxDepth, tail calls don't nest
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{`(define (loop n) (if (= n 0) 'done (loop (- n 1)))) (loop 100000)`, `'done`},
+		{`(define (even? n) (if (= n 0) #t (odd? (- n 1)))) (define (odd? n) (if (= n 0) #f (even? (- n 1)))) (even? 100001)`, `#f`},
+		{`(define (loop n) (cond ((= n 0) 'done) (else (loop (- n 1))))) (loop 100000)`, `'done`},
+		{`(define (loop n) (begin (set! n (- n 1)) (if (< n 0) 'done (loop n)))) (loop 100000)`, `'done`},
+		{`(define (f) (define (loop i acc) (if (= i 100000) (length acc) (loop (+ i 1) (cons i acc)))) (loop 0 '())) (f)`, `100000`},
+		{`(define (f n) (let ((m (- n 1))) (if (= m 0) 'done (f m)))) (f 100000)`, `'done`},
+	}
+	for _, tt := range tests {
+		program, err := parser.New(lexer.New(strings.NewReader(tt.input))).Parse()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		e := New(WithStdin(strings.NewReader("")))
+		e.SetLimits(Limits{MaxDepth: 10})
+		ret, err := e.Eval(program)
+		if err != nil {
+			t.Fatalf("input %s, unexpected error: %v", tt.input, err)
+		}
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+}
+
+func TestEvaluator_Tracer(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedEvents []string
+	}{
+		{
+			`(define (fact n) (if (= n 0) 1 (* n (fact (- n 1))))) (fact 2)`,
+			[]string{"1 > (fact 2)", "2 > (fact 1)", "3 > (fact 0)", "3 < fact 1", "2 < fact 1", "1 < fact 2"},
+		},
+		{
+			`(define (double x) (* 2 x)) (map double (list 1 2)) ((lambda (x) x) 3)`,
+			[]string{"1 > (double 1)", "1 < double 2", "1 > (double 2)", "1 < double 4", "1 > (lambda 3)", "1 < lambda 3"},
+		},
+		{
+			`(define (outer) (define (inner) (car 1)) (inner)) (outer)`,
+			[]string{"1 > (outer)", "2 > (inner)", "2 < inner error: 'car' expected cons or list value, got Number", "1 < outer error: 'car' expected cons or list value, got Number"},
+		},
+	}
+
+	for _, tt := range tests {
+		program, err := parser.New(lexer.New(strings.NewReader(tt.input))).Parse()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		tracer := &recordingTracer{}
+		e := New(WithStdin(strings.NewReader("")))
+		e.SetTracer(tracer)
+		e.Eval(program)
+		if strings.Join(tracer.events, "\n") != strings.Join(tt.expectedEvents, "\n") {
+			t.Fatalf("input %s, expected events\n%s\ngot\n%s", tt.input, strings.Join(tt.expectedEvents, "\n"), strings.Join(tracer.events, "\n"))
+		}
+	}
+}
+
+type recordingHook struct {
+	events []string
+}
+
+func (r *recordingHook) Before(exp parser.Expression, environment *Environment) {
+	r.events = append(r.events, "> "+exp.String())
+}
+
+func (r *recordingHook) After(exp parser.Expression, environment *Environment, result *ReturnValue, err error) {
+	if err != nil {
+		r.events = append(r.events, fmt.Sprintf("< %s error: %s", exp.String(), err))
+		return
+	}
+	r.events = append(r.events, fmt.Sprintf("< %s %s", exp.String(), result.Write()))
+}
+
+func TestEvaluator_Hook(t *testing.T) {
+	program, err := parser.New(lexer.New(strings.NewReader(`(define x 2) (+ x (car 1))`))).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hook := &recordingHook{}
+	e := New(WithStdin(strings.NewReader("")), WithHook(hook))
+	e.Eval(program)
+	expected := []string{
+		"> (define x 2)", "> 2", "< 2 2", "< (define x 2) 2",
+		"> (+ x (car 1))", "> +", "< + <builtin function>", "> x", "< x 2",
+		"> (car 1)", "> car", "< car <builtin function>", "> 1", "< 1 1",
+		"< (car 1) error: 'car' expected cons or list value, got Number",
+		"< (+ x (car 1)) error: 'car' expected cons or list value, got Number",
+	}
+	if strings.Join(hook.events, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected events\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(hook.events, "\n"))
+	}
+
+	e.SetHook(nil)
+	hook.events = nil
+	e.Eval(program)
+	if len(hook.events) != 0 {
+		t.Fatalf("expected no events once the hook is removed, got %v", hook.events)
+	}
+}
+
+func TestEvaluator_Stepper(t *testing.T) {
+	parse := func(input string) *parser.Program {
+		program, err := parser.New(lexer.New(strings.NewReader(input))).Parse()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return program
+	}
+
+	e := New(WithStdin(strings.NewReader("")))
+	s := e.Stepper(parse(`(define (double x) (* 2 x)) (double 3)`))
+	var steps []string
+	for s.Next() {
+		step := s.Step()
+		indent := strings.Repeat(" ", step.Depth)
+		if step.Evaluated {
+			steps = append(steps, fmt.Sprintf("%s%s => %s", indent, step.Expression.String(), step.Result.Write()))
+		} else {
+			steps = append(steps, indent+step.Expression.String())
+		}
+	}
+	ret, err := s.Result()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ret.String() != "6" {
+		t.Fatalf("expected 6, got %s", ret.String())
+	}
+	expected := []string{
+		"(define (double x) (* 2 x))", " (lambda (x) (* 2 x))", " (lambda (x) (* 2 x)) => <procedure>", "(define (double x) (* 2 x)) => <procedure>",
+		"(double 3)", " double", " double => <procedure>", " 3", " 3 => 3",
+		" (* 2 x)", "  *", "  * => <builtin function>", "  2", "  2 => 2", "  x", "  x => 3", " (* 2 x) => 6",
+		"(double 3) => 6",
+	}
+	if strings.Join(steps, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected steps\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(steps, "\n"))
+	}
+
+	// closing a stepper stops an evaluation that would never end, the evaluator stays usable
+	s = e.Stepper(parse(`(define (loop n) (loop (+ n 1))) (loop 0)`))
+	for i := 0; i < 100 && s.Next(); i++ {
+	}
+	s.Close()
+	if _, err := s.Result(); !errors.Is(err, ErrCanceled) {
+		t.Fatalf("expected the evaluation to be canceled, got %v", err)
+	}
+	if ret, err := e.Eval(parse(`(double 4)`)); err != nil || ret.String() != "8" {
+		t.Fatalf("expected 8, got %v, %v", ret, err)
+	}
+}
+
+func TestEvaluator_EvalInErrorEnvironment(t *testing.T) {
+	parse := func(input string) *parser.Program {
+		program, err := parser.New(lexer.New(strings.NewReader(input))).Parse()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return program
+	}
+
+	e := New(WithStdin(strings.NewReader("")))
+	_, err := e.Eval(parse(`(define y 10) (define (f x) (define z (* x 2)) (car z)) (define (g n) (f (+ n 1))) (g 4)`))
+	var runtimeError *RuntimeError
+	if !errors.As(err, &runtimeError) {
+		t.Fatalf("expected a runtime error, got %v", err)
+	}
+
+	ret, err := e.EvalIn(parse(`(list x z y)`), runtimeError.Environment())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ret.String() != `'(5 10 10)` {
+		t.Fatalf("expected the locals of f, got %s", ret.String())
+	}
+	if _, err := e.Eval(parse(`x`)); err == nil {
+		t.Fatalf("expected x to be unbound in the global environment")
+	}
+}
+
+func TestEvaluator_RuntimeErrorLocation(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "lib.soup")
+	main := filepath.Join(dir, "main.soup")
+	if err := os.WriteFile(lib, []byte("(define (f x)\n  (+ 1 (car x)))\n(f 2)\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(main, []byte(fmt.Sprintf("(load %q)\n", lib)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	e := New(WithStdin(strings.NewReader("")))
+	_, err := e.LoadFile(main)
+	var runtimeError *RuntimeError
+	if !errors.As(err, &runtimeError) {
+		t.Fatalf("expected a runtime error, got %v", err)
+	}
+	if tok := runtimeError.Token(); tok.Content != "car" || tok.Line != 2 || tok.Column != 9 {
+		t.Fatalf("expected the error at car on 2:9, got %+v", tok)
+	}
+	if runtimeError.File() != lib {
+		t.Fatalf("expected the error in %s, got %q", lib, runtimeError.File())
+	}
+
+	program, _ := parser.New(lexer.New(strings.NewReader("(+ 1\n   undefined-name)"))).Parse()
+	_, err = e.Eval(program)
+	if !errors.As(err, &runtimeError) {
+		t.Fatalf("expected a runtime error, got %v", err)
+	}
+	if tok := runtimeError.Token(); tok.Content != "undefined-name" || tok.Line != 2 || runtimeError.File() != "" {
+		t.Fatalf("expected the error at the operand, got %+v in %q", tok, runtimeError.File())
+	}
+
+	// a procedure, or a lambda it makes, fails where it was written rather than where it's called
+	if err := os.WriteFile(lib, []byte("(define (g x)\n  (lambda () (cdr x)))\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(main, []byte(fmt.Sprintf("(load %q)\n((g 1))\n", lib)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = e.LoadFile(main)
+	if !errors.As(err, &runtimeError) {
+		t.Fatalf("expected a runtime error, got %v", err)
+	}
+	if tok := runtimeError.Token(); tok.Content != "cdr" || tok.Line != 2 || runtimeError.File() != lib || runtimeError.Source() != "" {
+		t.Fatalf("expected the error at cdr in %s, got %+v in %q", lib, tok, runtimeError.File())
+	}
+
+	entry := "(define (h x)\n  (vector-ref x 0))"
+	program, _ = parser.New(lexer.New(strings.NewReader(entry))).Parse()
+	program.Source = entry
+	if _, err := e.Eval(program); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	program, _ = parser.New(lexer.New(strings.NewReader("(h 1)"))).Parse()
+	program.Source = "(h 1)"
+	_, err = e.Eval(program)
+	if !errors.As(err, &runtimeError) {
+		t.Fatalf("expected a runtime error, got %v", err)
+	}
+	if tok := runtimeError.Token(); tok.Content != "vector-ref" || tok.Line != 2 || runtimeError.File() != "" || runtimeError.Source() != entry {
+		t.Fatalf("expected the error in the entry defining h, got %+v in %q", tok, runtimeError.Source())
+	}
+	program, _ = parser.New(lexer.New(strings.NewReader("(car 1)"))).Parse()
+	program.Source = "(car 1)"
+	if _, err = e.Eval(program); !errors.As(err, &runtimeError) || runtimeError.Source() != "(car 1)" {
+		t.Fatalf("expected the error in the program, got %v", err)
+	}
+}
+
+func TestEvaluator_StackTrace(t *testing.T) {
+	err := testEvalError("(define (g x)\n  (car x))\n(define (f x)\n  (list (let ((y x))\n    (list (g y)))))\n(f 1)", t)
+	var runtimeError *RuntimeError
+	if !errors.As(err, &runtimeError) {
+		t.Fatalf("expected a runtime error, got %v", err)
+	}
+	if runtimeError.Error() != "'car' expected cons or list value, got Number" {
+		t.Fatalf("unexpected message %q", runtimeError.Error())
+	}
+	frames := make([]string, 0)
+	for _, element := range runtimeError.StackTrace() {
+		frames = append(frames, fmt.Sprintf("%s:%d", element.IdentifierName(), element.LineNumber()))
+	}
+	// the let's lambda is named after where it was written rather than its whole source
+	expected := []string{"g:2", "#<lambda 4:10>:5", "f:4"}
+	if !reflect.DeepEqual(frames, expected) {
+		t.Fatalf("expected %v, got %v", expected, frames)
+	}
+
+	// the let's lambda is called in tail position of f and calls g in tail position, each replaces
+	// the frame of its caller
+	err = testEvalError("(define (g x)\n  (car x))\n(define (f x)\n  (let ((y x))\n    (g y)))\n(f 1)", t)
+	if !errors.As(err, &runtimeError) {
+		t.Fatalf("expected a runtime error, got %v", err)
+	}
+	frames = frames[:0]
+	for _, element := range runtimeError.StackTrace() {
+		frames = append(frames, fmt.Sprintf("%s:%d", element.IdentifierName(), element.LineNumber()))
+	}
+	expected = []string{"g:2", "f:5"}
+	if !reflect.DeepEqual(frames, expected) {
+		t.Fatalf("expected %v, got %v", expected, frames)
+	}
+
+	// a frame is named after the procedure rather than the operator calling it, and an error in an
+	// operand or an if adds no frame of its own
+	err = testEvalError("(define (g x)\n  (car x))\n(define h g)\n(define (f x)\n  (if (h x) (list (h x)) 0))\n(f 1)", t)
+	if !errors.As(err, &runtimeError) {
+		t.Fatalf("expected a runtime error, got %v", err)
+	}
+	frames = frames[:0]
+	for _, element := range runtimeError.StackTrace() {
+		frames = append(frames, fmt.Sprintf("%s:%d", element.IdentifierName(), element.LineNumber()))
+	}
+	expected = []string{"g:2", "f:5"}
+	if !reflect.DeepEqual(frames, expected) {
+		t.Fatalf("expected %v, got %v", expected, frames)
+	}
+
+	// a lambda called through an expression is named after where it was written too
+	err = testEvalError("(define handlers\n  (list (lambda (x)\n    (car x))))\n(list ((car handlers) 1))", t)
+	if !errors.As(err, &runtimeError) {
+		t.Fatalf("expected a runtime error, got %v", err)
+	}
+	frames = frames[:0]
+	for _, element := range runtimeError.StackTrace() {
+		frames = append(frames, fmt.Sprintf("%s:%d", element.IdentifierName(), element.LineNumber()))
+	}
+	expected = []string{"#<lambda 2:10>:3"}
+	if !reflect.DeepEqual(frames, expected) {
+		t.Fatalf("expected %v, got %v", expected, frames)
+	}
+}
+
+func TestEvaluator_Generator(t *testing.T) {
+	counter := `
+(define (count-from n)
+  (make-generator
+    (lambda (yield)
+      (define (loop i) (yield i) (loop (+ i 1)))
+      (loop n))))
+`
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{`(define g (make-generator (lambda (yield) (yield 1) (yield 2)))) (list (next g) (next g) (next g 'end) (next g 'end))`, `'(1 2 end end)`},
+		{counter + `(define g (count-from 5)) (next g) (next g) (next g)`, `7`},
+		{counter + `(generator->list (count-from 1) 4)`, `'(1 2 3 4)`},
+		{`(generator->list (make-generator (lambda (yield) (for-each yield '(a b c)))))`, `'(a b c)`},
+		{counter + `(define a (count-from 1)) (define b (count-from 10)) (list (next a) (next b) (next a) (next b))`, `'(1 10 2 11)`},
+		{`(define (tree-walk tree yield) (cond ((null? tree) 'done) ((pair? tree) (tree-walk (car tree) yield) (tree-walk (cdr tree) yield)) (else (yield tree)))) (generator->list (make-generator (lambda (yield) (tree-walk '((1 2) (3 (4))) yield))))`, `'(1 2 3 4)`},
+		{`(generator? (make-generator (lambda (yield) 1)))`, `#t`},
+		{`(define g (make-generator (lambda (yield) (list 100 (yield 1) (car (list (yield 2) 9)))))) (list (next g) (+ 5 (next g)) (next g 'end))`, `'(1 7 end)`},
+		// each turn the procedure sees the handlers and parameters of the caller of next
+		{`(define g (make-generator (lambda (yield) (yield 1) (raise 'oops)))) (next g) (guard (e (#t (list 'caught e))) (next g))`, `'(caught oops)`},
+		{`(define p (make-parameter 1)) (define g (make-generator (lambda (yield) (yield (p)) (yield (p))))) (list (parameterize ((p 2)) (next g)) (parameterize ((p 3)) (next g)))`, `'(2 3)`},
+		// and the caller of next doesn't see those the procedure installed
+		{`(define g (make-generator (lambda (yield) (guard (e (#t (yield (list 'inner e)))) (yield 1) (raise 'in-generator))))) (list (next g) (guard (e (#t (list 'outer e))) (raise 'in-caller)) (next g))`, `'(1 (outer in-caller) (inner in-generator))`},
+		{`(define p (make-parameter 1)) (define g (make-generator (lambda (yield) (parameterize ((p 10)) (yield (p)) (yield (p)))))) (list (next g) (p) (parameterize ((p 3)) (next g)))`, `'(10 1 10)`},
+		{`(define p (make-parameter 1)) (define g (make-generator (lambda (yield) (parameterize ((p 10)) (guard (e (#t (yield (list e (p))))) (yield 1) (raise 'oops)))))) (list (next g) (parameterize ((p 3)) (next g)))`, `'(1 (oops 10))`},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+
+	err := testEvalError(`(define g (make-generator (lambda (yield) (yield 1)))) (next g) (next g)`, t)
+	if conditionKindOf(err) != IndexOutOfRangeCondition {
+		t.Fatalf("expected index out of range condition, got %v", err)
+	}
+	testEvalError(`(define g (make-generator (lambda (yield) (car 1)))) (next g)`, t)
+	testEvalError(`(define saved #f) (define g (make-generator (lambda (yield) (set! saved yield) (yield 1)))) (next g) (saved 2)`, t)
+}
+
+func TestEvaluator_GeneratorDropped(t *testing.T) {
+	ev := New(WithStdin(strings.NewReader("")))
+	eval := func(src string) string {
+		program, err := parser.New(lexer.New(strings.NewReader(src))).Parse()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ret, err := ev.Eval(program)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return ret.String()
+	}
+	eval(`
+(define (count-from n)
+  (make-generator
+    (lambda (yield)
+      (define (loop i) (yield i) (loop (+ i 1)))
+      (loop n))))
+(define (drop-generators n)
+  (if (> n 0)
+      (begin (next (count-from n)) (drop-generators (- n 1)))))
+(define kept (count-from 1))
+(next kept)`)
+	before := runtime.NumGoroutine()
+	eval(`(drop-generators 100)`)
+
+	// the generators that became garbage are closed by a later run, the one kept goes on
+	for i := 2; i < 50 && runtime.NumGoroutine() > before; i++ {
+		runtime.GC()
+		if ret := eval(`(next kept)`); ret != strconv.Itoa(i) {
+			t.Fatalf("expected %d from the generator kept, got %s", i, ret)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if left := runtime.NumGoroutine() - before; left > 0 {
+		t.Fatalf("expected the goroutines of the dropped generators to exit, %d are left", left)
+	}
+}
+
+func TestEvaluator_ReadAndWriteString(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{`(read-from-string "(1 \"two\" #t (a . b))")`, `'(1 "two" #t (a . b))`},
+		{`(read-from-string "(1 2 . (3))")`, `'(1 2 3)`},
+		{`(read-from-string "  sym  ")`, `'sym`},
+		{`(write-to-string (list 1 "two" #f (cons 'a 'b) (list 'quote 'c)))`, `"(1 \"two\" #f (a . b) (quote c))"`},
+		{`(write-to-string "a\\b")`, `"\"a\\\\b\""`},
+		{`(write-to-string 'sym)`, `"sym"`},
+		{`(define data (list 'x "y" (cons 1.5 2) '(nested (list)))) (equal? data (read-from-string (write-to-string data)))`, `#t`},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+
+	evalErrorTests := []string{
+		`(read-from-string "(1 2")`,
+		`(read-from-string "")`,
+		`(read-from-string ")")`,
+		`(read-from-string 12)`,
+	}
+	for _, input := range evalErrorTests {
+		testEvalError(input, t)
+	}
+}
+
+func TestEvaluator_PrettyString(t *testing.T) {
+	tests := []struct {
+		input          string
+		width          int
+		expectedOutput string
+	}{
+		{`'(a b c)`, 80, `(a b c)`},
+		{`'(define (f x) (if (> x 0) x (- x)))`, 20, "(define (f x)\n  (if (> x 0)\n      x\n      (- x)))"},
+		{`'(define (count-leaves tree) (cond ((null? tree) 0) ((pair? tree) (+ (count-leaves (car tree)) (count-leaves (cdr tree)))) (else 1)))`, 50,
+			"(define (count-leaves tree)\n  (cond ((null? tree) 0)\n        ((pair? tree)\n         (+ (count-leaves (car tree))\n            (count-leaves (cdr tree))))\n        (else 1)))"},
+		{`'(define (f x) (define y (* x x)) (display y) (let loop ((i 0)) (when (< i y) (display i) (loop (+ i 1)))))`, 40,
+			"(define (f x)\n  (define y (* x x))\n  (display y)\n  (let loop ((i 0))\n    (when (< i y)\n      (display i)\n      (loop (+ i 1)))))"},
+		{`'(lambda (x y) (let ((sum (+ x y)) (product (* x y))) (list sum product)))`, 30,
+			"(lambda (x y)\n  (let ((sum (+ x y))\n        (product (* x y)))\n    (list sum product)))"},
+		{`'(do ((i 0 (+ i 1))) ((= i 10) done) (display i))`, 22, "(do ((i 0 (+ i 1)))\n    ((= i 10) done)\n  (display i))"},
+		{`'(define some-long-variable-name (list 1 2 3))`, 30, "(define some-long-variable-name\n  (list 1 2 3))"},
+		{`(list (list 1 2 3) "str" (cons 'a 'b))`, 12, "((1 2 3)\n \"str\"\n (a . b))"},
+		{`(cons '(1 2 3) '(4 5 6))`, 8, "((1 2 3)\n 4\n 5\n 6)"},
+		{`(cons '(1 2 3) 4)`, 8, "((1 2 3)\n . 4)"},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if got := ret.PrettyString(tt.width); got != tt.expectedOutput {
+			t.Fatalf("input %s, expected\n%s\ngot\n%s", tt.input, tt.expectedOutput, got)
+		}
+	}
+}
+
+func TestEvaluator_LimitedPrettyString(t *testing.T) {
+	tests := []struct {
+		input          string
+		width          int
+		limits         PrintLimits
+		expectedOutput string
+	}{
+		{`'(1 2 3 4 5)`, 80, PrintLimits{MaxLength: 3}, `(1 2 3 ...)`},
+		{`'(1 (2 (3 (4))))`, 80, PrintLimits{MaxDepth: 2}, `(1 (2 ...))`},
+		{`(cons 1 (cons 2 (cons 3 4)))`, 80, PrintLimits{MaxLength: 2}, `(1 2 ...)`},
+		{`(cons 1 (cons 2 (cons 3 4)))`, 80, PrintLimits{MaxLength: 3}, `(1 2 3 . 4)`},
+		{`(cons (cons 1 2) (cons 3 4))`, 80, PrintLimits{MaxDepth: 1}, `(... 3 . 4)`},
+		{`'(1 2 3)`, 80, PrintLimits{MaxDepth: 1, MaxLength: 3}, `(1 2 3)`},
+		{`'(define (f x) (if (> x 0) x (- x)))`, 16, PrintLimits{MaxLength: 3}, "(define (f x)\n  (if (> x 0)\n      x\n      ...))"},
+		{`(define x (list 1 2)) (set-cdr! x x) x`, 80, PrintLimits{MaxLength: 3}, `(1 1 1 ...)`},
+		{`(define x (list 1 2)) (set-cdr! x x) x`, 80, PrintLimits{}, `(1 ...)`},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if got := ret.LimitedPrettyString(tt.width, tt.limits); got != tt.expectedOutput {
+			t.Fatalf("input %s, expected\n%s\ngot\n%s", tt.input, tt.expectedOutput, got)
+		}
+	}
+}
+
+func TestEvaluator_ConditionKind(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedKind ConditionKind
+	}{
+		{`(car 1)`, WrongTypeCondition},
+		{`(+ 1 "a")`, WrongTypeCondition},
+		{`(1 2)`, WrongTypeCondition},
+		{`(car '(1) '(2))`, WrongArityCondition},
+		{`(define (f a) a) (f 1 2)`, WrongArityCondition},
+		{`(remainder 1 0)`, DivisionByZeroCondition},
+		{`(stream-ref (stream-enumerate-interval 1 3) 5)`, IndexOutOfRangeCondition},
+		{`(error "boom")`, GenericCondition},
+		{`(define (f x) (car x)) (define (g x) (f x)) (g 1)`, WrongTypeCondition},
+		{`(#t 1)`, WrongTypeCondition},
+	}
+
+	for _, tt := range tests {
+		err := testEvalError(tt.input, t)
+		var condition *Condition
+		if !errors.As(err, &condition) {
+			t.Fatalf("input %s, expected a condition, got %v", tt.input, err)
+		}
+		if condition.Kind != tt.expectedKind {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedKind, condition.Kind)
+		}
+	}
+}
+
+func TestEvaluator_ArityError(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"(define (f a b)\n  (+ a b))\n(f 1)", "'f' (defined on line 1) has been called with 1 argument; it requires exactly 2 arguments"},
+		{"(define (f a . rest) a)\n(f)", "'f' (defined on line 1) has been called with 0 arguments; it requires at least 1 argument"},
+		{"\n((lambda () 1) 2 3)", "'lambda' (defined on line 2) has been called with 2 arguments; it requires exactly 0 arguments"},
+		{"(define g (lambda (x) x))\n(g)", "'g' (defined on line 1) has been called with 0 arguments; it requires exactly 1 argument"},
+	}
+	for _, tt := range tests {
+		err := testEvalError(tt.input, t)
+		if conditionKindOf(err) != WrongArityCondition {
+			t.Fatalf("input %s, expected a wrong-arity condition, got %v", tt.input, err)
+		}
+		if err.Error() != tt.expectedMessage {
+			t.Fatalf("input %s, expected %q, got %q", tt.input, tt.expectedMessage, err.Error())
+		}
+	}
+}
+
+func TestEvaluator_ErrorsIs(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected error
+	}{
+		{`(car 1)`, ErrWrongType},
+		{`(define (f a) a) (f 1 2)`, ErrWrongArity},
+		{`(define (f x) (+ x y)) (f 1)`, ErrUndefinedVariable},
+		{`(set! nope 1)`, ErrUndefinedVariable},
+		{`(define (loop) (loop)) (loop)`, ErrLimitExceeded},
+	}
+	sentinels := []error{ErrUndefinedVariable, ErrWrongArity, ErrWrongType, ErrLimitExceeded, ErrCanceled}
+
+	for _, tt := range tests {
+		program, err := parser.New(lexer.New(strings.NewReader(tt.input))).Parse()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		e := New(WithStdin(strings.NewReader("")), WithLimits(Limits{MaxSteps: 1000}))
+		_, err = e.Eval(program)
+		for _, sentinel := range sentinels {
+			if errors.Is(err, sentinel) != (sentinel == tt.expected) {
+				t.Fatalf("input %s, expected errors.Is(%v, %v) to be %t", tt.input, err, sentinel, sentinel == tt.expected)
+			}
+		}
+	}
+
+	program, _ := parser.New(lexer.New(strings.NewReader(`(define (loop) (loop)) (loop)`))).Parse()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := New(WithStdin(strings.NewReader(""))).EvalContext(ctx, program)
+	if !errors.Is(err, ErrCanceled) || conditionKindOf(err) != CanceledCondition {
+		t.Fatalf("expected the run to be canceled, got %v", err)
+	}
+}
+
+func TestEvaluator_EvalInContextKeepsEnvironment(t *testing.T) {
+	e := New(WithStdin(strings.NewReader("")))
+	env := e.GlobalEnvironment()
+
+	program, _ := parser.New(lexer.New(strings.NewReader(`(define x 5) (define (loop) (loop)) (loop)`))).Parse()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := e.EvalInContext(ctx, program, env); !errors.Is(err, ErrCanceled) {
+		t.Fatalf("expected the entry to be canceled, got %v", err)
+	}
+
+	// the definitions made before the entry was canceled are still there
+	program, _ = parser.New(lexer.New(strings.NewReader(`(+ x 1)`))).Parse()
+	ret, err := e.EvalIn(program, env)
+	if err != nil || ret.String() != "6" {
+		t.Fatalf("expected (+ x 1) to be 6 after the canceled entry, got %v, %v", ret, err)
+	}
+}
+
+func TestEvaluator_ConditionPredicates(t *testing.T) {
+	tests := []struct {
+		kind           ConditionKind
+		input          string
+		expectedOutput string
+	}{
+		{FileErrorCondition, "(file-error? c)", `#t`},
+		{FileErrorCondition, "(wrong-type-error? c)", `#f`},
+		{WrongTypeCondition, "(wrong-type-error? c)", `#t`},
+		{WrongArityCondition, "(wrong-arity-error? c)", `#t`},
+		{DivisionByZeroCondition, "(division-by-zero-error? c)", `#t`},
+		{IndexOutOfRangeCondition, "(index-out-of-range-error? c)", `#t`},
+		{GenericCondition, "(condition? c)", `#t`},
+		{GenericCondition, "(condition? 1)", `#f`},
+		{GenericCondition, "(file-error? 1)", `#f`},
+		{UndefinedVariableCondition, "(undefined-variable-error? c)", `#t`},
+		{CanceledCondition, "(canceled-error? c)", `#t`},
+		{AssertionCondition, "(assertion-error? c)", `#t`},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(strings.NewReader(tt.input))
+		program, err := parser.New(l).Parse()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		evaluator := New(WithStdin(strings.NewReader("")))
+		evaluator.globalEnv.Put("c", &ReturnValue{Type: ConditionType, Data: &Condition{Kind: tt.kind, Message: "oops"}})
+		ret, err := evaluator.Eval(program)
+		if err != nil {
+			t.Fatalf("input %s, unexpected error: %v", tt.input, err)
+		}
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+}
+
+func TestEvaluator_HTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/largest" || r.URL.Path == "/too-large" {
+			size := MaxHTTPBodySize
+			if r.URL.Path == "/too-large" {
+				size++
+			}
+			w.Write(bytes.Repeat([]byte("a"), size))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Echo", r.Header.Get("X-Token"))
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "%s %s", r.Method, body)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{fmt.Sprintf(`(cdr (assoc 'status (http-get "%s")))`, server.URL), `201`},
+		{fmt.Sprintf(`(cdr (assoc 'body (http-get "%s")))`, server.URL), `"GET "`},
+		{fmt.Sprintf(`(cdr (assoc 'body (http-post "%s" "hello" '())))`, server.URL), `"POST hello"`},
+		{fmt.Sprintf(`(define resp (http-post "%s" "" (list (cons "x-token" "abc"))))
+		  (cdr (assoc "x-echo" (cdr (assoc 'headers resp))))`, server.URL), `"abc"`},
+		{fmt.Sprintf(`(string-length (cdr (assoc 'body (http-get "%s/largest"))))`, server.URL), strconv.Itoa(MaxHTTPBodySize)},
+	}
+
+	for _, tt := range tests {
+		ret := testEval(tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+
+	err := testEvalError(fmt.Sprintf(`(http-get "%s/too-large")`, server.URL), t)
+	if expected := fmt.Sprintf("'http-get' failed to read response: the body is larger than %d bytes", MaxHTTPBodySize); err.Error() != expected {
+		t.Fatalf("expected %q, got %q", expected, err)
+	}
+}
+
+func TestEvaluator_SandboxDeniesNetwork(t *testing.T) {
+	l := lexer.New(strings.NewReader(`(http-get "http://127.0.0.1:1")`))
+	program, err := parser.New(l).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	evaluator := New(WithStdin(strings.NewReader("")))
+	evaluator.SetCapabilities(AllCapabilities &^ CapabilityNetwork)
+	_, err = evaluator.Eval(program)
+	var condition *Condition
+	if !errors.As(err, &condition) || condition.Kind != PermissionDeniedCondition {
+		t.Fatalf("expected permission denied condition, got %v", err)
+	}
+}
+
+func TestEvaluator_Load(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.scm":          `(load "lib/helper.scm") (define (area r) (* pi (square r))) (area 2)`,
+		"lib/helper.scm":    `(load "constants.scm") (define (square x) (* x x))`,
+		"lib/constants.scm": `(define pi 3)`,
+		"a.scm":             `(load "b.scm")`,
+		"b.scm":             `(load "a.scm")`,
+		"local.scm":         `(define y (* x 2)) y`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ret, err := New(WithStdin(strings.NewReader(""))).LoadFile(filepath.Join(dir, "main.scm"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ret.String() != "12" {
+		t.Fatalf("expected 12, got %s", ret.String())
+	}
+
+	// load evaluates in the environment of the caller
+	ret = testEval(fmt.Sprintf(`(define (f x) (load %q) y) (f 21)`, filepath.Join(dir, "local.scm")), t)
+	if ret.String() != "42" {
+		t.Fatalf("expected 42, got %s", ret.String())
+	}
+
+	errorTests := []struct {
+		input        string
+		expectedKind ConditionKind
+	}{
+		{fmt.Sprintf(`(load %q)`, filepath.Join(dir, "a.scm")), FileErrorCondition},
+		{fmt.Sprintf(`(load %q)`, filepath.Join(dir, "missing.scm")), FileErrorCondition},
+		{`(load 'main)`, WrongTypeCondition},
+	}
+	for _, tt := range errorTests {
+		err := testEvalError(tt.input, t)
+		var condition *Condition
+		if !errors.As(err, &condition) || condition.Kind != tt.expectedKind {
+			t.Fatalf("input %s, expected %s condition, got %v", tt.input, tt.expectedKind, err)
+		}
+	}
+
+	evaluator := New(WithStdin(strings.NewReader("")))
+	evaluator.SetCapabilities(AllCapabilities &^ CapabilityFileSystem)
+	_, err = evaluator.LoadFile(filepath.Join(dir, "main.scm"))
+	var condition *Condition
+	if !errors.As(err, &condition) || condition.Kind != PermissionDeniedCondition {
+		t.Fatalf("expected permission denied condition, got %v", err)
+	}
+}
+
+func TestEvaluator_LoadWithShadowing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shadow.scm")
+	if err := os.WriteFile(path, []byte(`((lambda (not) (not 1)) (lambda (x) (+ x 1)))`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(WithStdin(strings.NewReader(""))).LoadFile(path); err == nil || !strings.Contains(err.Error(), "'not' is a builtin") {
+		t.Fatalf("expected a parsing error about shadowing not, got %v", err)
+	}
+	ret, err := New(WithStdin(strings.NewReader("")), WithShadowing()).LoadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ret.String() != "2" {
+		t.Fatalf("expected 2, got %s", ret.String())
+	}
+}
+
+func TestEvaluator_Racket(t *testing.T) {
+	dir := t.TempDir()
+	helpers := `#lang racket/base
+(provide count-up)
+(define loaded 0)
+(set! loaded (add1 loaded))
+(define (count-up n)
+  (let ([last (sub1 n)])
+    (if (< last 0) '() (append (count-up last) (list last)))))`
+	main := `#lang racket/base
+(require racket/list "helpers.rkt")
+(require "helpers.rkt")
+(displayln (count-up 3))
+(displayln (cond [(empty? (rest '(1))) (first '(a b))] [else 'no]))
+loaded`
+	if err := os.WriteFile(filepath.Join(dir, "helpers.rkt"), []byte(helpers), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "main.rkt")
+	if err := os.WriteFile(path, []byte(main), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	ret, err := New(WithStdout(&out), WithStdin(strings.NewReader("")), WithRacket()).LoadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out.String() != "'(0 1 2)\n'a\n" {
+		t.Fatalf("expected output %q, got %q", "'(0 1 2)\n'a\n", out.String())
+	}
+	if ret.String() != "1" {
+		t.Fatalf("expected helpers.rkt to be loaded once, got loaded = %s", ret.String())
+	}
+
+	if _, err := New(WithStdin(strings.NewReader(""))).LoadFile(path); err == nil {
+		t.Fatal("expected an error running a Racket program without WithRacket")
+	}
+}
+
+func TestEvaluator_MITCompat(t *testing.T) {
+	tests := []struct {
+		input           string
+		expected        string
+		expectedMessage string
+	}{
+		{"(list (1+ 1) (-1+ 1) true false)", "'(2 0 #t #f)", ""},
+		{`(error "Something bad:" 42 "str" 'sym (list 1 "a"))`, "", `Something bad: 42 "str" sym (1 "a")`},
+		{"(error 'my-proc \"failed\")", "", `my-proc "failed"`},
+		{"(define x 2) (eval '(* x 3) user-initial-environment)", "6", ""},
+	}
+	for _, tt := range tests {
+		program, err := parser.New(lexer.New(strings.NewReader(tt.input))).Parse()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ret, err := New(WithStdin(strings.NewReader("")), WithMITCompat()).Eval(program)
+		if tt.expectedMessage != "" {
+			if err == nil || err.Error() != tt.expectedMessage {
+				t.Fatalf("input %s, expected the error %q, got %v", tt.input, tt.expectedMessage, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("input %s, unexpected error: %v", tt.input, err)
+		}
+		if ret.String() != tt.expected {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expected, ret.String())
+		}
+	}
+
+	if err := testEvalError(`(error "Something bad:" 42)`, t); err.Error() != `failed to evaluate: "Something bad:", 42` {
+		t.Fatalf("expected the default error message, got %v", err)
+	}
+}
+
+func TestEvaluator_Library(t *testing.T) {
+	library := `
+(define-library (soup math)
+  (export square (rename cube-impl cube) counter bump!)
+  (import (scheme base))
+  (begin
+    (define (square x) (* x x))
+    (define (cube-impl x) (* x (square x)))
+    (define counter 0)
+    (define (bump!) (set! counter (+ counter 1)) counter)
+    (define hidden 'secret)))
+`
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{`(import (soup math)) (cube 3)`, `27`},
+		{`(import (only (soup math) square)) (square 5)`, `25`},
+		{`(import (prefix (soup math) m:)) (m:square 4)`, `16`},
+		{`(import (rename (soup math) (square sq))) (sq 6)`, `36`},
+		{`(import (except (soup math) cube)) (define cube 'mine) cube`, `'mine`},
+		{`(define hidden 'global) (import (soup math)) hidden`, `'global`},
+		{`(import (soup math)) (bump!) (bump!)`, `2`},
+		{`(define (square x) 'shadowed) (import (soup math)) (cube 2)`, `8`},
+	}
+	for _, tt := range tests {
+		ret := testEval(library+tt.input, t)
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+
+	errorTests := []string{
+		`(import (soup math)) hidden`,
+		`(import (only (soup math) hidden))`,
+		`(import (soup missing))`,
+		`(define-library (broken) (export nothing))`,
+	}
+	for _, input := range errorTests {
+		testEvalError(library+input, t)
+	}
+}
+
+func TestEvaluator_LibraryFromFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "soup"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"soup/greet.sld": `(define-library (soup greet) (export greet) (import (soup names)) (begin (define (greet) (list 'hello name))))`,
+		"soup/names.scm": `(define-library (soup names) (export name) (begin (define name 'soup)))`,
+		"main.scm":       `(import (soup greet)) (greet)`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ret, err := New(WithStdin(strings.NewReader(""))).LoadFile(filepath.Join(dir, "main.scm"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ret.String() != `'(hello soup)` {
+		t.Fatalf("expected '(hello soup), got %s", ret.String())
+	}
+}
+
+func TestEvaluator_SearchPath(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"first/util.scm":       `(define where 'first)`,
+		"second/util.scm":      `(define where 'second)`,
+		"second/only.scm":      `(define only 'second)`,
+		"second/soup/lib.sld":  `(define-library (soup lib) (export lib) (begin (define lib 'from-path)))`,
+		"program/soup/lib.sld": `(define-library (soup lib) (export lib) (begin (define lib 'next-to-program)))`,
+		"program/main.scm":     `(import (soup lib)) lib`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Setenv(SearchPathEnv, strings.Join([]string{filepath.Join(dir, "first"), "", filepath.Join(dir, "second")}, string(os.PathListSeparator)))
+	searchPath := SearchPathFromEnv()
+	if len(searchPath) != 2 {
+		t.Fatalf("expected 2 directories, got %v", searchPath)
+	}
+
+	tests := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{`(load "util.scm") where`, `'first`},
+		{`(load "only.scm") only`, `'second`},
+		{`(import (soup lib)) lib`, `'from-path`},
+	}
+	for _, tt := range tests {
+		program, err := parser.New(lexer.New(strings.NewReader(tt.input))).Parse()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		evaluator := New(WithStdin(strings.NewReader("")))
+		evaluator.SetSearchPath(searchPath)
+		ret, err := evaluator.Eval(program)
+		if err != nil {
+			t.Fatalf("input %s, unexpected error: %v", tt.input, err)
+		}
+		if ret.String() != tt.expectedOutput {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedOutput, ret.String())
+		}
+	}
+
+	// the directory of the program comes before the search path
+	evaluator := New(WithStdin(strings.NewReader("")))
+	evaluator.SetSearchPath(searchPath)
+	ret, err := evaluator.LoadFile(filepath.Join(dir, "program", "main.scm"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ret.String() != `'next-to-program` {
+		t.Fatalf("expected 'next-to-program, got %s", ret.String())
+	}
+
+	err = testEvalError(`(load "missing.scm")`, t)
+	if !strings.Contains(err.Error(), "could not find missing.scm, searched: ") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestEvaluator_ParseCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lib.scm")
+	if err := os.WriteFile(path, []byte(`(define x 1)`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := parseFile(path, syntaxOptions{}, "load")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, err := parseFile(path, syntaxOptions{}, "load")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first != second {
+		t.Fatalf("expected the unchanged file to be served from the cache")
+	}
+
+	if err := os.WriteFile(path, []byte(`(define x 2)`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ret := testEval(fmt.Sprintf(`(load %q) x`, path), t)
+	if ret.String() != "2" {
+		t.Fatalf("expected the edited file to be parsed again, got %s", ret.String())
+	}
+
+	// ParseFile hands out the program load evaluates
+	exported, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if latest, _ := parseFile(path, syntaxOptions{}, "load"); exported != latest {
+		t.Fatalf("expected ParseFile to return the cached program")
+	}
+}
+
+func TestEvaluator_SharedConstants(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected *ReturnValue
+	}{
+		{"#t", True},
+		{"#f", False},
+		{"(< 1 2)", True},
+		{"(null? '(1))", False},
+		{"(eq? 'a 'a)", True},
+		{"(not #t)", False},
+		{"(newline)", Void},
+		{"(if #f #f)", Void},
+	}
+
+	for _, tt := range tests {
+		if ret := testEval(tt.input, t); ret != tt.expected {
+			t.Fatalf("%s: expected the shared %s, got a new %s", tt.input, tt.expected.String(), ret.String())
+		}
+	}
+
+	// a local define is still updated in place once its value is known
+	ret := testEval(`(define (f) (define x 1) (define (g) x) (g)) (f)`, t)
+	if ret.String() != "1" || Void.String() != "<void>" {
+		t.Fatalf("expected 1 and an untouched void, got %s and %s", ret.String(), Void.String())
+	}
+}
+
+func testEvalError(input string, t *testing.T) error {
+	l := lexer.New(strings.NewReader(input))
+	p := parser.New(l)
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	evaluator := New(WithStdin(strings.NewReader("")))
+	_, err = evaluator.Eval(program)
+	if err == nil {
+		t.Fatalf("input %s, expected an error", input)
+	}
+	return err
+}
+
+func testEval(input string, t *testing.T) *ReturnValue {
+	l := lexer.New(strings.NewReader(input))
+	p := parser.New(l)
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	evaluator := New(WithStdin(strings.NewReader("")))
+	result, err := evaluator.Eval(program)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return result
+}
+
+func benchmarkEval(b *testing.B, src string, opts ...Option) {
+	program, err := parser.New(lexer.New(strings.NewReader(src))).Parse()
+	if err != nil {
+		b.Fatalf("unexpected error: %v", err)
+	}
+	e := New(append([]Option{WithStdin(strings.NewReader(""))}, opts...)...)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := e.Eval(program); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+const benchmarkFib = `
+(define (fib n)
+  (if (< n 2)
+      n
+      (+ (fib (- n 1)) (fib (- n 2)))))
+(fib 20)`
+
+func BenchmarkEvaluator_Fib(b *testing.B) {
+	benchmarkEval(b, benchmarkFib)
+}
+
+func BenchmarkEvaluator_FibArena(b *testing.B) {
+	benchmarkEval(b, benchmarkFib, WithAllocationArena())
+}
+
+func BenchmarkEvaluator_Ack(b *testing.B) {
+	benchmarkEval(b, `
+(define (ack m n)
+  (cond ((= m 0) (+ n 1))
+        ((= n 0) (ack (- m 1) 1))
+        (else (ack (- m 1) (ack m (- n 1))))))
+(ack 2 9)`)
+}
+
+func BenchmarkEvaluator_Let(b *testing.B) {
+	benchmarkEval(b, `
+(define (loop i acc)
+  (if (= i 0)
+      acc
+      (let ((next (- i 1)))
+        (loop next (+ acc i)))))
+(loop 10000 0)`)
+}
+
+const benchmarkBuildList = `
+(define (build n acc)
+  (if (= n 0)
+      acc
+      (build (- n 1) (cons (* n n) acc))))
+(define (sum l acc)
+  (if (null? l)
+      acc
+      (sum (cdr l) (+ acc (car l)))))
+(sum (build 5000 '()) 0)`
+
+func BenchmarkEvaluator_BuildList(b *testing.B) {
+	benchmarkEval(b, benchmarkBuildList)
+}
+
+func BenchmarkEvaluator_BuildListArena(b *testing.B) {
+	benchmarkEval(b, benchmarkBuildList, WithAllocationArena())
+}
+
+const benchmarkConstants = `
+(define (loop i acc)
+  (if (= i 0)
+      acc
+      (let ((scale 4) (offset (* 60 60)))
+        (begin
+          'step
+          (loop (- i 1) (+ acc (* scale i) offset (if (> 2 1) 1 0)))))))
+(loop 10000 0)`
+
+func BenchmarkEvaluator_Constants(b *testing.B) {
+	benchmarkEval(b, benchmarkConstants)
+}
+
+func BenchmarkEvaluator_ConstantsOptimized(b *testing.B) {
+	benchmarkEval(b, benchmarkConstants, WithOptimizer())
+}
+
+func BenchmarkEvaluator_StringLiteral(b *testing.B) {
+	benchmarkEval(b, `
+(define (loop i acc)
+  (if (= i 0)
+      acc
+      (loop (- i 1) (if (string=? "even" "odd") acc (+ acc (string-length "é"))))))
+(loop 5000 0)`)
+}
+
+func BenchmarkEvaluator_QuotedLiteral(b *testing.B) {
+	benchmarkEval(b, `
+(define (classify x)
+  (cond ((equal? x '(red green blue)) 'colors)
+        ((equal? x '(1 2 3)) 'numbers)
+        (else 'other)))
+(define (loop i acc)
+  (if (= i 0)
+      acc
+      (loop (- i 1) (cons (classify '(1 2 3)) acc))))
+(length (loop 5000 '()))`)
 }
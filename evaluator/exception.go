@@ -0,0 +1,277 @@
+package evaluator
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ocowchun/soup/parser"
+)
+
+// Exceptions follow R7RS: raise calls the handler installed last, with the handlers installed before
+// it in place, and a handler returning from it raises a secondary exception; the value of a handler
+// returning from raise-continuable is the value of raise-continuable. A guard is a handler too: the
+// clauses are tried where the object is raised, and the stack unwinds to the guard only when one
+// matches, the handlers outside of the guard are called otherwise.
+//
+// The errors of builtins are Go errors, they unwind the Go stack until the closest
+// with-exception-handler or guard, where they're raised as the condition they carry. The errors of
+// the evaluator's limits, of a canceled context and of amb's search can't be handled.
+
+// handler is an entry of the handlers installed: the procedure of a with-exception-handler, or a
+// guard with the environment and the parameterizations it's evaluated in.
+type handler struct {
+	procedure         *ReturnValue
+	guard             *parser.GuardExpression
+	environment       *Environment
+	parameterizations []parameterization
+	parameterBase     []parameterization
+}
+
+// exception is the error unwinding the stack once an object was raised: to the guard with a clause
+// that matched it, or out of the program when nothing handled it.
+type exception struct {
+	value *ReturnValue
+	// cause is the error value was raised as, nil for raise; it's never a RuntimeError, an unhandled
+	// RuntimeError is marked raised instead of wrapped, since its frames are added to it in place
+	cause error
+	// target is the guard to unwind to, clauseEnv binds its variable to value for clause, whose
+	// test evaluated to test
+	target    *handler
+	clause    int
+	clauseEnv *Environment
+	test      *ReturnValue
+}
+
+func (x *exception) Error() string {
+	if x.cause != nil {
+		return x.cause.Error()
+	}
+	if x.value.Type == ConditionType {
+		return x.value.Condition().Message
+	}
+	return fmt.Sprintf("uncaught exception: %s", x.value)
+}
+
+// Unwrap returns the error or the condition raised, so errors.Is matches its kind like the error
+// of a builtin.
+func (x *exception) Unwrap() error {
+	if x.cause != nil {
+		return x.cause
+	}
+	if x.value.Type == ConditionType {
+		return x.value.Condition()
+	}
+	return nil
+}
+
+// exceptionValue returns the object the handlers of err are called with, and false when err can't
+// be handled.
+func exceptionValue(err error) (*ReturnValue, bool) {
+	if errors.Is(err, errAmbFailure) || errors.Is(err, errTryAgain) || errors.Is(err, errGeneratorClosed) {
+		return nil, false
+	}
+	var condition *Condition
+	if !errors.As(err, &condition) {
+		condition = &Condition{Kind: GenericCondition, Message: err.Error()}
+	}
+	if condition.uncatchable || condition.Kind == CanceledCondition {
+		return nil, false
+	}
+	return &ReturnValue{Type: ConditionType, Data: condition}, true
+}
+
+// isRaised reports whether err was raised to the handlers already.
+func isRaised(err error) bool {
+	var x *exception
+	if errors.As(err, &x) {
+		return true
+	}
+	var runtimeError *RuntimeError
+	return errors.As(err, &runtimeError) && runtimeError.raised
+}
+
+// pushHandler installs h and returns the handlers to restore once it's out of scope. The slice is
+// copied: a handler may hold on to the handlers below it while others are installed above.
+func (e *Evaluator) pushHandler(h *handler) []*handler {
+	installed := e.handlers
+	e.handlers = append(installed[:len(installed):len(installed)], h)
+	return installed
+}
+
+// raise calls the installed handlers with obj, raised as err or by raise when err is nil, the
+// innermost first, until one returns from a continuable exception or the clause of a guard matches
+// it. It returns the value of the handler that returned, or the error to unwind with.
+func (e *Evaluator) raise(obj *ReturnValue, err error, continuable bool, environment *Environment) (*ReturnValue, error) {
+	installed, installedBase := e.handlers, e.handlerBase
+	defer func() {
+		e.handlers, e.handlerBase = installed, installedBase
+	}()
+	for len(e.handlers) > 0 || len(e.handlerBase) > 0 {
+		if len(e.handlers) == 0 {
+			// past the handlers of a generator are those of the caller of next
+			e.handlers, e.handlerBase = e.handlerBase, nil
+		}
+		h := e.handlers[len(e.handlers)-1]
+		// a handler runs with the handlers installed outside of it
+		e.handlers = e.handlers[:len(e.handlers)-1]
+		if h.guard != nil {
+			clause, test, clauseEnv, testErr := e.matchGuard(h, obj)
+			if testErr != nil {
+				return nil, e.raiseError(testErr, clauseEnv)
+			}
+			if clause >= 0 {
+				return nil, &exception{value: obj, target: h, clause: clause, clauseEnv: clauseEnv, test: test}
+			}
+			continue
+		}
+		val, handlerErr := e.applyProcedure(h.procedure, []*ReturnValue{obj}, environment)
+		if handlerErr != nil {
+			return nil, e.raiseError(handlerErr, environment)
+		}
+		if continuable {
+			return val, nil
+		}
+		obj = &ReturnValue{Type: ConditionType, Data: &Condition{Kind: GenericCondition, Message: fmt.Sprintf("a handler returned from the non-continuable exception %s", obj)}}
+		err = nil
+	}
+
+	// nothing handled obj
+	var runtimeError *RuntimeError
+	if err != nil && errors.As(err, &runtimeError) {
+		runtimeError.raised = true
+		return nil, err
+	}
+	return nil, &exception{value: obj, cause: err}
+}
+
+// raiseError raises the Go error err to the installed handlers, unless it has been raised already
+// or can't be handled.
+func (e *Evaluator) raiseError(err error, environment *Environment) error {
+	if isRaised(err) {
+		return err
+	}
+	obj, ok := exceptionValue(err)
+	if !ok {
+		return err
+	}
+	_, err = e.raise(obj, err, false, environment)
+	return err
+}
+
+// matchGuard tries the clauses of the guard h on obj, it returns the index of the clause that
+// matched, -1 if none did, the value of its test and the environment it's evaluated in.
+func (e *Evaluator) matchGuard(h *handler, obj *ReturnValue) (int, *ReturnValue, *Environment, error) {
+	clauseEnv := newCallEnvironment(h.environment)
+	if err := e.allocate(2); err != nil {
+		return -1, nil, clauseEnv, err
+	}
+	clauseEnv.Put(h.guard.Variable, obj)
+	// the clauses see the parameters as the guard does
+	parameterizations, parameterBase := e.parameterizations, e.parameterBase
+	e.parameterizations, e.parameterBase = h.parameterizations, h.parameterBase
+	defer func() {
+		e.parameterizations, e.parameterBase = parameterizations, parameterBase
+	}()
+	for i, clause := range h.guard.Clauses {
+		if clause.Test == nil {
+			return i, True, clauseEnv, nil
+		}
+		test, err := e.eval(clause.Test, clauseEnv)
+		if err != nil {
+			return -1, nil, clauseEnv, runtimeErrorAt(err, clause.Test.Token(), clauseEnv)
+		}
+		if !(test.Type == ConstantType && test.Data == FalseValue) {
+			return i, test, clauseEnv, nil
+		}
+	}
+	return -1, nil, clauseEnv, nil
+}
+
+func (e *Evaluator) evalGuardExpression(exp *parser.GuardExpression, environment *Environment) (*ReturnValue, error) {
+	h := &handler{guard: exp, environment: environment, parameterizations: e.parameterizations, parameterBase: e.parameterBase}
+	installed := e.pushHandler(h)
+	defer func() {
+		e.handlers = installed
+	}()
+	var val *ReturnValue
+	var err error
+	for _, subExp := range exp.Body {
+		if val, err = e.eval(subExp, environment); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		return val, nil
+	}
+	// the error of a builtin in the body is raised here, with the guard still installed
+	err = e.raiseError(err, environment)
+	var x *exception
+	if !errors.As(err, &x) || x.target != h {
+		return nil, err
+	}
+
+	e.handlers = installed
+	clause := exp.Clauses[x.clause]
+	if len(clause.Body) == 0 {
+		return x.test, nil
+	}
+	for _, subExp := range clause.Body {
+		if val, err = e.eval(subExp, x.clauseEnv); err != nil {
+			return nil, runtimeErrorAt(err, subExp.Token(), x.clauseEnv)
+		}
+	}
+	if clause.Arrow {
+		if val, err = e.applyProcedure(val, []*ReturnValue{x.test}, x.clauseEnv); err != nil {
+			return nil, runtimeErrorAt(err, clause.Body[0].Token(), x.clauseEnv)
+		}
+	}
+	return val, nil
+}
+
+func addExceptionBuiltins(env *Environment) {
+	addBuiltinToEnv(env, "raise", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'raise' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+
+			return evaluator.raise(parameters[0], nil, false, environment)
+		},
+	})
+
+	addBuiltinToEnv(env, "raise-continuable", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'raise-continuable' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+
+			return evaluator.raise(parameters[0], nil, true, environment)
+		},
+	})
+
+	// (with-exception-handler handler thunk) calls thunk with handler installed
+	addBuiltinToEnv(env, "with-exception-handler", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'with-exception-handler' has been called with %d arguments; it requires exactly 2 arguments", len(parameters))
+			}
+			procedure, thunk := parameters[0], parameters[1]
+			for _, proc := range parameters {
+				if proc.Type != ProcedureType && proc.Type != BuiltinFunctionType {
+					return nil, conditionErrorf(WrongTypeCondition, "'with-exception-handler' expected a procedure, got %s", proc.Type)
+				}
+			}
+
+			installed := evaluator.pushHandler(&handler{procedure: procedure})
+			defer func() {
+				evaluator.handlers = installed
+			}()
+			val, err := evaluator.applyProcedure(thunk, nil, environment)
+			if err != nil {
+				// the error of a builtin in thunk is raised here, with procedure still installed
+				return nil, evaluator.raiseError(err, environment)
+			}
+			return val, nil
+		},
+	})
+}
@@ -0,0 +1,27 @@
+package evaluator
+
+import "maps"
+
+// Fork creates an evaluator configured by opts, like New does, that shares e's builtins and prelude
+// instead of building its own, which is most of the cost of New. A service evaluating many small
+// programs creates one evaluator and forks one per program:
+//
+//	base := evaluator.New()
+//	...
+//	e := base.Fork(evaluator.WithStdout(w), evaluator.WithLimits(limits))
+//
+// The fork's global environment starts as a copy of e's when Fork is called: defining or setting a
+// variable on either doesn't change the other, but the values are shared, and so is the global
+// environment procedures defined on e refer to. The libraries e defined aren't shared. Forks can run
+// concurrently, as long as nothing is registered on or evaluated by e meanwhile and they don't
+// mutate the values they share; a builtin registered on a fork stays in that fork.
+func (e *Evaluator) Fork(opts ...Option) *Evaluator {
+	o := newOptions(opts)
+	// the fork's own layer of builtins takes Register, the shared one is never written to again
+	baseEnv := &Environment{enclosing: e.baseEnv, store: make(map[string]*ReturnValue), readOnly: true}
+	child := newEvaluator(baseEnv, o)
+	maps.Copy(child.globalEnv.store, e.globalEnv.store)
+	child.tracer = o.tracer
+	child.hook = o.hook
+	return child
+}
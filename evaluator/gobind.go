@@ -0,0 +1,205 @@
+package evaluator
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// A GoObject is a pointer to a Go struct handed to soup, by FromGo or a registered function
+// returning one. Soup reads and writes its fields with the procedures RegisterType defines and calls
+// its methods with send:
+//
+//	type Person struct{ Name string }
+//	func (p *Person) Greet(greeting string) string { return greeting + ", " + p.Name }
+//
+//	e.RegisterType("person", Person{})
+//	e.Register("make-person", func(name string) *Person { return &Person{Name: name} })
+//
+//	(define p (make-person "Ada"))
+//	(person-name p)          ; "Ada"
+//	(set-person-name! p "Grace")
+//	(send p 'greet "hi")     ; "hi, Grace"
+//
+// Field and method names are written the soup way, FirstName is first-name.
+type GoObject struct {
+	value reflect.Value
+}
+
+// Value returns the pointer to the struct.
+func (o *GoObject) Value() any {
+	return o.value.Interface()
+}
+
+func (rv *ReturnValue) GoObject() *GoObject {
+	if rv.Type != GoObjectType {
+		panic("not a go object")
+	}
+	if o, ok := rv.Data.(*GoObject); ok {
+		return o
+	}
+	panic("invalid go object")
+}
+
+func makeGoObject(ptr reflect.Value) *ReturnValue {
+	return &ReturnValue{Type: GoObjectType, Data: &GoObject{value: ptr}}
+}
+
+// RegisterType defines the procedures soup uses the struct type of sample with, a struct or a pointer
+// to one, named after name: (name? v) tells objects of the type apart, and for each exported field
+// F whose type converts to soup, (name-f obj) reads it and (set-name-f! obj value) writes it. The
+// methods of the type are called with send, whether or not it is registered.
+func (e *Evaluator) RegisterType(name string, sample any) error {
+	t := reflect.TypeOf(sample)
+	if t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return fmt.Errorf("register type %s: expected a struct, got %v", name, t)
+	}
+	ptrType := reflect.PointerTo(t)
+
+	object := func(procName string, val *ReturnValue) (reflect.Value, error) {
+		if val.Type != GoObjectType || val.GoObject().value.Type() != ptrType {
+			return reflect.Value{}, conditionErrorf(WrongTypeCondition, "'%s' expected a %s, got %s", procName, name, describeValue(val))
+		}
+		return val.GoObject().value.Elem(), nil
+	}
+
+	predicate := name + "?"
+	addBuiltinToEnv(e.baseEnv, predicate, &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'%s' has been called with %d arguments; it requires exactly 1 argument", predicate, len(parameters))
+			}
+			return boolValue(parameters[0].Type == GoObjectType && parameters[0].GoObject().value.Type() == ptrType), nil
+		},
+	})
+
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() || field.Anonymous || !convertible(field.Type) {
+			continue
+		}
+		index := field.Index
+		fieldType := field.Type
+		getter := name + "-" + lispName(field.Name)
+		setter := "set-" + getter + "!"
+
+		addBuiltinToEnv(e.baseEnv, getter, &BuiltinFunction{
+			Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+				if len(parameters) != 1 {
+					return nil, conditionErrorf(WrongArityCondition, "'%s' has been called with %d arguments; it requires exactly 1 argument", getter, len(parameters))
+				}
+				obj, err := object(getter, parameters[0])
+				if err != nil {
+					return nil, err
+				}
+				// an embedded struct behind a nil pointer has no field to read
+				v, err := obj.FieldByIndexErr(index)
+				if err != nil {
+					return nil, conditionErrorf(GenericCondition, "'%s' failed: %s", getter, err)
+				}
+				ret, err := fromGoValue(v)
+				if err != nil {
+					return nil, conditionErrorf(WrongTypeCondition, "'%s' returned a value soup can't represent: %s", getter, err)
+				}
+				return ret, nil
+			},
+		})
+
+		addBuiltinToEnv(e.baseEnv, setter, &BuiltinFunction{
+			Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+				if len(parameters) != 2 {
+					return nil, conditionErrorf(WrongArityCondition, "'%s' has been called with %d arguments; it requires exactly 2 arguments", setter, len(parameters))
+				}
+				obj, err := object(setter, parameters[0])
+				if err != nil {
+					return nil, err
+				}
+				v, err := toGoValue(parameters[1], fieldType)
+				if err != nil {
+					return nil, conditionErrorf(WrongTypeCondition, "'%s' expected %s, got %s", setter, describeGoType(fieldType), err)
+				}
+				f, err := obj.FieldByIndexErr(index)
+				if err != nil {
+					return nil, conditionErrorf(GenericCondition, "'%s' failed: %s", setter, err)
+				}
+				f.Set(v)
+				return Void, nil
+			},
+		})
+	}
+	return nil
+}
+
+// describeValue names the type of val for error messages, the Go type for a GoObject.
+func describeValue(val *ReturnValue) string {
+	if val.Type == GoObjectType {
+		return val.GoObject().value.Type().String()
+	}
+	return val.Type.String()
+}
+
+// lispName spells a Go identifier the soup way: Name is name, FirstName first-name and HTTPServer
+// http-server.
+func lispName(goName string) string {
+	runes := []rune(goName)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// a word starts at an upper case letter following a lower case one, or at the last upper
+			// case letter of an acronym followed by a lower case one
+			if i > 0 && (unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1])) {
+				b.WriteByte('-')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// goMethod finds the method of obj soup calls name, either its Go name or its soup name.
+func goMethod(obj *GoObject, name string) (reflect.Value, bool) {
+	t := obj.value.Type()
+	for i := 0; i < t.NumMethod(); i++ {
+		if m := t.Method(i); m.Name == name || lispName(m.Name) == name {
+			return obj.value.Method(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func addGoObjectBuiltins(env *Environment) {
+	// (send obj 'method arg ...) calls a method of a Go object
+	addBuiltinToEnv(env, "send", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) < 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'send' has been called with %d arguments; it requires at least 2 arguments", len(parameters))
+			}
+			if parameters[0].Type != GoObjectType {
+				return nil, conditionErrorf(WrongTypeCondition, "'send' expected a Go object, got %s", parameters[0].Type)
+			}
+			var name string
+			switch parameters[1].Type {
+			case SymbolType:
+				name = parameters[1].Symbol()
+			case StringType:
+				name = parameters[1].StringValue()
+			default:
+				return nil, conditionErrorf(WrongTypeCondition, "'send' expected a method name, got %s", parameters[1].Type)
+			}
+
+			obj := parameters[0].GoObject()
+			method, ok := goMethod(obj, name)
+			if !ok {
+				return nil, conditionErrorf(GenericCondition, "'send': %s has no method %s", obj.value.Type(), name)
+			}
+			if err := checkGoFunction(method.Type()); err != nil {
+				return nil, conditionErrorf(GenericCondition, "'send': method %s of %s can't be called from soup: %s", name, obj.value.Type(), err)
+			}
+			return callGo(name, method, parameters[2:])
+		},
+	})
+}
@@ -0,0 +1,26 @@
+package evaluator
+
+import "github.com/ocowchun/soup/parser"
+
+// A Hook is called around the evaluation of every expression, the subexpressions of a call or an if
+// included, so coverage tools, profilers and debuggers can be built without changing eval. The
+// expressions of the prelude aren't reported.
+type Hook interface {
+	// Before is called with the expression about to be evaluated and the environment it is
+	// evaluated in
+	Before(exp parser.Expression, environment *Environment)
+	// After is called once exp is evaluated, with its value or the error evaluating it failed with
+	After(exp parser.Expression, environment *Environment, result *ReturnValue, err error)
+}
+
+// SetHook starts calling hook around every expression, nil stops it.
+func (e *Evaluator) SetHook(hook Hook) {
+	e.hook = hook
+}
+
+func (e *Evaluator) hookExpression(expression parser.Expression, environment *Environment) (*ReturnValue, error) {
+	e.hook.Before(expression, environment)
+	ret, err := e.evalExpression(expression, environment)
+	e.hook.After(expression, environment, ret, err)
+	return ret, err
+}
@@ -0,0 +1,637 @@
+package evaluator
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ocowchun/soup/lexer"
+	"github.com/ocowchun/soup/parser"
+)
+
+// An image is soup source made of definitions for the bindings of the global environment, so
+// restoring it is loading it. Procedures are written back from their syntax tree, which only works for
+// those closing over the global environment. Data is rebuilt with list, vector and the like rather
+// than quoted, and an object referenced from more than one place, a hash table or a lambda gets an id:
+// it's built by the restore call ahead of the bindings using it, filled in once every object exists,
+// so shared and circular structure comes back the way it was saved:
+//
+//	; soup image, restore it with load-image
+//	(define (inc x) (+ x 1))
+//	(define counter 3)
+//	(define names (list "a" 'b))
+//	(define loop #f)
+//	((lambda (image-objects)
+//	  (set-car! (vector-ref image-objects 0) 1)
+//	  (set-cdr! (vector-ref image-objects 0) (vector-ref image-objects 0))
+//	  (set! loop (vector-ref image-objects 0)))
+//	 (vector (cons #f #f)))
+
+const imageHeader = "; soup image, restore it with load-image\n"
+
+// SaveImage writes the definitions of the global environment to w as an image, see load-image. The
+// bindings that can't be saved, like a closure over a let or a port, are left out, it returns an
+// error naming each of them.
+func (e *Evaluator) SaveImage(w io.Writer) ([]error, error) {
+	image, skipped := e.image()
+	errs := make([]error, len(skipped))
+	for i, binding := range skipped {
+		errs[i] = binding
+	}
+	_, err := io.WriteString(w, image)
+	return errs, err
+}
+
+// unsavedBinding is a binding an image leaves out, reason is why it can't be saved.
+type unsavedBinding struct {
+	name   string
+	reason error
+}
+
+func (b *unsavedBinding) Error() string {
+	return fmt.Sprintf("can't save %s, %s", b.name, b.reason)
+}
+
+// LoadImage evaluates the image at path in the global environment, the definitions replace those
+// with the same names.
+func (e *Evaluator) LoadImage(path string) error {
+	e.startRun()
+	defer e.flushOutput()
+	_, err := e.load(path, e.globalEnv, "load-image")
+	return err
+}
+
+// imageWriter holds what's known about the objects reachable from the global environment while
+// writing an image.
+type imageWriter struct {
+	e *Evaluator
+	// references counts the references to each object, from bindings and from other objects
+	references map[any]int
+	// reached holds the objects in the order they were first reached
+	reached []*ReturnValue
+	// ids are the indexes of the objects built by the restore call
+	ids     map[any]int
+	objects []*ReturnValue
+	// homes are the global names procedures are defined as, baseNames the names of builtins
+	homes     map[*ProcedureValue]string
+	baseNames map[any]string
+	// vector is the name the restore call binds its objects to, usedVector is set once an expression
+	// refers to one
+	vector     string
+	usedVector bool
+}
+
+// image returns the image of the global environment, and why each of the bindings it leaves out
+// can't be saved.
+func (e *Evaluator) image() (string, []*unsavedBinding) {
+	w := &imageWriter{
+		e:          e,
+		references: make(map[any]int),
+		ids:        make(map[any]int),
+		homes:      make(map[*ProcedureValue]string),
+		baseNames:  make(map[any]string),
+		vector:     "image-objects",
+	}
+	for _, n := range e.baseEnv.LocalNames() {
+		if _, shadowed := e.globalEnv.lookup(n); shadowed {
+			continue
+		}
+		val, _ := e.baseEnv.lookup(n)
+		if val.Type != BuiltinFunctionType && val.Type != ProcedureType {
+			continue
+		}
+		if _, ok := w.baseNames[val.Data]; !ok {
+			w.baseNames[val.Data] = n
+		}
+	}
+	names := e.globalEnv.LocalNames()
+	for _, n := range names {
+		val, _ := e.globalEnv.lookup(n)
+		if val.Type != ProcedureType || val.Procedure().Env != e.globalEnv {
+			continue
+		}
+		// a procedure is defined as the name it was defined as, or else as the first name bound to it
+		proc := val.Procedure()
+		if home, ok := w.homes[proc]; !ok || (n == proc.Name && home != proc.Name) {
+			w.homes[proc] = n
+		}
+	}
+	for _, ok := e.globalEnv.lookup(w.vector); ok; _, ok = e.globalEnv.lookup(w.vector) {
+		w.vector += "*"
+	}
+
+	// a binding is only counted once it's known to be saveable, so it can't leave behind objects
+	// the image would then fail to write
+	var skipped []*unsavedBinding
+	saved := make([]string, 0, len(names))
+	for _, n := range names {
+		val, _ := e.globalEnv.lookup(n)
+		if val.Type == ProcedureType && w.homes[val.Procedure()] == n {
+			if _, err := procedureSource(n, val.Procedure()); err != nil {
+				skipped = append(skipped, &unsavedBinding{name: n, reason: err})
+				continue
+			}
+			saved = append(saved, n)
+			continue
+		}
+		trial := *w
+		trial.references = make(map[any]int)
+		trial.reached = nil
+		if err := trial.count(val); err != nil {
+			skipped = append(skipped, &unsavedBinding{name: n, reason: err})
+			continue
+		}
+		w.count(val)
+		saved = append(saved, n)
+	}
+	names = saved
+	for _, val := range w.reached {
+		key := objectKey(val)
+		withID := w.references[key] > 1 || val.Type == HashTableType
+		if val.Type == ProcedureType {
+			// a lambda is built by the restore call, its body would close over the restore lambda otherwise
+			_, based := w.baseNames[val.Data]
+			withID = w.homes[val.Procedure()] == "" && !based
+		}
+		if withID {
+			w.ids[key] = len(w.objects)
+			w.objects = append(w.objects, val)
+		}
+	}
+
+	var out strings.Builder
+	out.WriteString(imageHeader)
+	for _, n := range names {
+		val, _ := e.globalEnv.lookup(n)
+		if val.Type == ProcedureType && w.homes[val.Procedure()] == n {
+			source, _ := procedureSource(n, val.Procedure())
+			out.WriteString(source + "\n")
+		}
+	}
+	// bindings reaching an object with an id are defined ahead of the restore call, which sets them
+	var placeholders, assignments []string
+	for _, n := range names {
+		val, _ := e.globalEnv.lookup(n)
+		if val.Type == ProcedureType && w.homes[val.Procedure()] == n {
+			continue
+		}
+		w.usedVector = false
+		source := w.source(val)
+		if w.usedVector {
+			placeholders = append(placeholders, fmt.Sprintf("(define %s #f)\n", n))
+			assignments = append(assignments, form("set!", []string{n, source}))
+			continue
+		}
+		out.WriteString(form("define", []string{n, source}) + "\n")
+	}
+	if len(w.objects) == 0 {
+		return out.String(), skipped
+	}
+
+	for _, placeholder := range placeholders {
+		out.WriteString(placeholder)
+	}
+	objects := make([]string, len(w.objects))
+	var fills, tables []string
+	for i, val := range w.objects {
+		objects[i] = w.shell(val)
+		object := w.reference(i)
+		switch val.Type {
+		case ConsType:
+			fills = append(fills,
+				form("set-car!", []string{object, w.source(val.Cons().Car)}),
+				form("set-cdr!", []string{object, w.source(val.Cons().Cdr)}))
+		case VectorType:
+			for j, element := range val.Vector().Elements {
+				fills = append(fills, form("vector-set!", []string{object, strconv.Itoa(j), w.source(element)}))
+			}
+		case HashTableType:
+			// tables are filled last, a key is hashed by what it holds once it holds it
+			for _, entry := range sortedEntries(val.HashTable()) {
+				tables = append(tables, form("hash-table-set!", []string{object, w.source(entry.key), w.source(entry.value)}))
+			}
+		}
+	}
+	out.WriteString("((lambda (" + w.vector + ")\n")
+	statements := append(append(fills, tables...), assignments...)
+	out.WriteString("  " + strings.Join(statements, "\n  ") + ")\n")
+	out.WriteString(" " + form("vector", objects) + ")\n")
+	return out.String(), skipped
+}
+
+// objectKey returns what tells val apart from the values equal to it, nil for values the image
+// doesn't need to tell apart like numbers or symbols.
+func objectKey(val *ReturnValue) any {
+	switch val.Type {
+	case ConsType, VectorType, HashTableType, BytevectorType, ProcedureType:
+		return val.Data
+	case StringType:
+		return stringObject(val)
+	}
+	return nil
+}
+
+// count adds a reference to val, and the first time it's reached to the objects it holds, checking it
+// can be saved.
+func (w *imageWriter) count(val *ReturnValue) error {
+	key := objectKey(val)
+	if key == nil {
+		switch val.Type {
+		case NumberType, CharType, SymbolType, ListType:
+			return nil
+		case ConstantType:
+			if val.Data == TrueValue || val.Data == FalseValue {
+				return nil
+			}
+		case BuiltinFunctionType:
+			if _, ok := w.baseNames[val.Data]; ok {
+				return nil
+			}
+		}
+		return fmt.Errorf("a value of type %s can't be written", val.Type)
+	}
+	w.references[key]++
+	if w.references[key] > 1 {
+		return nil
+	}
+	w.reached = append(w.reached, val)
+
+	switch val.Type {
+	case ConsType:
+		// a long list is walked along its cdrs rather than recursively
+		for {
+			if err := w.count(val.Cons().Car); err != nil {
+				return err
+			}
+			next := val.Cons().Cdr
+			if next.Type != ConsType {
+				return w.count(next)
+			}
+			if w.references[next.Data]++; w.references[next.Data] > 1 {
+				return nil
+			}
+			w.reached = append(w.reached, next)
+			val = next
+		}
+	case VectorType:
+		for _, element := range val.Vector().Elements {
+			if err := w.count(element); err != nil {
+				return err
+			}
+		}
+	case HashTableType:
+		for _, entry := range sortedEntries(val.HashTable()) {
+			if err := w.count(entry.key); err != nil {
+				return err
+			}
+			if err := w.count(entry.value); err != nil {
+				return err
+			}
+		}
+	case ProcedureType:
+		proc := val.Procedure()
+		if _, ok := w.homes[proc]; ok {
+			return nil
+		}
+		if _, ok := w.baseNames[val.Data]; ok {
+			return nil
+		}
+		if proc.Env != w.e.globalEnv {
+			return fmt.Errorf("a procedure closing over a local environment can't be written")
+		}
+		if _, err := expressionSource(&parser.LambdaExpression{Parameters: proc.Parameters, OptionalTailParameter: proc.OptionalTailParameter, Body: proc.Body}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *imageWriter) reference(id int) string {
+	w.usedVector = true
+	return fmt.Sprintf("(vector-ref %s %d)", w.vector, id)
+}
+
+// source returns an expression evaluating to val, which refers to the objects with an id rather than
+// building them.
+func (w *imageWriter) source(val *ReturnValue) string {
+	if key := objectKey(val); key != nil {
+		if id, ok := w.ids[key]; ok {
+			return w.reference(id)
+		}
+	}
+	switch val.Type {
+	case ConsType:
+		// a list is written with list up to its first pair with an id
+		var elements []string
+		for ; val.Type == ConsType; val = val.Cons().Cdr {
+			if _, ok := w.ids[val.Data]; ok && len(elements) > 0 {
+				break
+			}
+			elements = append(elements, w.source(val.Cons().Car))
+		}
+		if val.Type == ListType {
+			return form("list", elements)
+		}
+		if len(elements) == 1 {
+			return form("cons", []string{elements[0], w.source(val)})
+		}
+		return form("append", []string{form("list", elements), w.source(val)})
+	case VectorType:
+		elements := make([]string, len(val.Vector().Elements))
+		for i, element := range val.Vector().Elements {
+			elements[i] = w.source(element)
+		}
+		return form("vector", elements)
+	case ProcedureType:
+		if home, ok := w.homes[val.Procedure()]; ok {
+			return home
+		}
+		return w.baseNames[val.Data]
+	case BuiltinFunctionType:
+		return w.baseNames[val.Data]
+	}
+	return scalarSource(val)
+}
+
+// shell returns the expression building an object with an id, pairs, vectors and hash tables are
+// built empty and filled in after.
+func (w *imageWriter) shell(val *ReturnValue) string {
+	switch val.Type {
+	case ConsType:
+		return "(cons #f #f)"
+	case VectorType:
+		return fmt.Sprintf("(make-vector %d)", len(val.Vector().Elements))
+	case HashTableType:
+		if val.HashTable().equal {
+			return "(make-hash-table)"
+		}
+		return "(make-hash-table eqv?)"
+	case ProcedureType:
+		proc := val.Procedure()
+		// count made sure it can be written
+		source, _ := expressionSource(&parser.LambdaExpression{Parameters: proc.Parameters, Body: proc.Body})
+		return source
+	}
+	return scalarSource(val)
+}
+
+// scalarSource writes a value that holds no other values, a string that could be changed comes back
+// as a copy so it still can.
+func scalarSource(val *ReturnValue) string {
+	switch val.Type {
+	case NumberType:
+		return numberSource(val.Number())
+	case StringType:
+		if _, ok := val.Data.(*mutableString); ok {
+			return form("string-copy", []string{lexer.QuoteString(val.StringValue())})
+		}
+		return lexer.QuoteString(val.StringValue())
+	case CharType:
+		return val.String()
+	case SymbolType:
+		return "'" + val.Symbol()
+	case ConstantType:
+		if val.Data == TrueValue {
+			return "#t"
+		}
+		return "#f"
+	case BytevectorType:
+		octets := make([]string, len(val.Bytevector().Bytes))
+		for i, octet := range val.Bytevector().Bytes {
+			octets[i] = strconv.Itoa(int(octet))
+		}
+		return form("bytevector", octets)
+	}
+	return "'()"
+}
+
+// sortedEntries returns the entries of table ordered by hash, so an image comes out the same each time.
+func sortedEntries(table *HashTableValue) []*hashTableEntry {
+	hashes := make([]string, 0, len(table.buckets))
+	for hash := range table.buckets {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	var entries []*hashTableEntry
+	for _, hash := range hashes {
+		entries = append(entries, table.buckets[hash]...)
+	}
+	return entries
+}
+
+// numberSource writes n so the lexer reads back the same number, a float keeps its fraction so it
+// doesn't come back an integer.
+func numberSource(n Number) string {
+	f := n.Float64()
+	if n.isExact() || math.IsNaN(f) || math.IsInf(f, 0) {
+		return n.String()
+	}
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
+
+func procedureSource(name string, proc *ProcedureValue) (string, error) {
+	params := append([]string{name}, proc.Parameters...)
+	if proc.OptionalTailParameter != "" {
+		params = append(params, ".", proc.OptionalTailParameter)
+	}
+	body, err := expressionsSource(proc.Body)
+	if err != nil {
+		return "", err
+	}
+	return form("define", append([]string{form(params[0], params[1:])}, body...)), nil
+}
+
+// form writes (head part...)
+func form(head string, parts []string) string {
+	return "(" + strings.Join(append([]string{head}, parts...), " ") + ")"
+}
+
+func expressionsSource(expressions []parser.Expression) ([]string, error) {
+	parts := make([]string, len(expressions))
+	for i, exp := range expressions {
+		part, err := expressionSource(exp)
+		if err != nil {
+			return nil, err
+		}
+		parts[i] = part
+	}
+	return parts, nil
+}
+
+// expressionSource writes exp back as source the parser turns into the same tree. Derived forms like
+// let and cond were already rewritten by the parser, they come back as what they were rewritten to.
+func expressionSource(exp parser.Expression) (string, error) {
+	switch exp {
+	case parser.TrueLiteral:
+		return "#t", nil
+	case parser.FalseLiteral:
+		return "#f", nil
+	}
+
+	var head string
+	var parts []parser.Expression
+	switch exp := exp.(type) {
+	case *parser.NumberLiteral:
+		return exp.NumToken.Content, nil
+	case *parser.StringLiteral:
+		return lexer.QuoteString(exp.Value), nil
+	case *parser.CharLiteral:
+		return exp.String(), nil
+	case *parser.IdentifierExpression:
+		return exp.Value, nil
+	case *parser.PrimitiveProcedureExpression:
+		return exp.Value, nil
+	case *parser.SymbolExpression, *parser.ListExpression:
+		datum, err := datumSource(exp)
+		if err != nil {
+			return "", err
+		}
+		return "'" + datum, nil
+	case *parser.VectorExpression:
+		return datumSource(exp)
+	case *parser.LambdaExpression:
+		if exp.OptionalTailParameter != "" {
+			return "", fmt.Errorf("a lambda with a tail parameter can only be written as a define")
+		}
+		body, err := expressionsSource(exp.Body)
+		if err != nil {
+			return "", err
+		}
+		return form("lambda", append([]string{"(" + strings.Join(exp.Parameters, " ") + ")"}, body...)), nil
+	case *parser.DefineExpression:
+		if lambda, ok := exp.Value.(*parser.LambdaExpression); ok {
+			return procedureSource(exp.Name, &ProcedureValue{Parameters: lambda.Parameters, OptionalTailParameter: lambda.OptionalTailParameter, Body: lambda.Body})
+		}
+		value, err := expressionSource(exp.Value)
+		if err != nil {
+			return "", err
+		}
+		return form("define", []string{exp.Name, value}), nil
+	case *parser.SetExpression:
+		value, err := expressionSource(exp.Value)
+		if err != nil {
+			return "", err
+		}
+		return form("set!", []string{exp.Name, value}), nil
+	case *parser.CallExpression:
+		operator, err := expressionSource(exp.Operator)
+		if err != nil {
+			return "", err
+		}
+		head, parts = operator, exp.Operands
+	case *parser.IfExpression:
+		head, parts = "if", []parser.Expression{exp.Predicate, exp.Consequent}
+		if exp.Alternative != nil {
+			parts = append(parts, exp.Alternative)
+		}
+	case *parser.BeginExpression:
+		head, parts = "begin", exp.Expressions
+	case *parser.DelayExpression:
+		head, parts = "delay", []parser.Expression{exp.Expression}
+		if exp.Force {
+			head = "delay-force"
+		}
+	case *parser.StreamExpression:
+		head, parts = "cons-stream", []parser.Expression{exp.CarExpression, exp.CdrExpression}
+	case *parser.AmbExpression:
+		head, parts = "amb", exp.Choices
+	case *parser.AssertExpression:
+		head, parts = "assert", []parser.Expression{exp.Expression}
+	default:
+		return "", fmt.Errorf("can't write %T", exp)
+	}
+
+	all, err := expressionsSource(parts)
+	if err != nil {
+		return "", err
+	}
+	return form(head, all), nil
+}
+
+// datumSource writes the elements of a quoted list, which are data rather than expressions.
+func datumSource(exp parser.Expression) (string, error) {
+	switch exp := exp.(type) {
+	case *parser.SymbolExpression:
+		return exp.Value, nil
+	case *parser.NumberLiteral, *parser.StringLiteral, *parser.CharLiteral:
+		return expressionSource(exp)
+	case *parser.ListExpression:
+		parts := make([]string, len(exp.Elements))
+		for i, element := range exp.Elements {
+			part, err := datumSource(element)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = part
+		}
+		return "(" + strings.Join(parts, " ") + ")", nil
+	case *parser.VectorExpression:
+		list, err := datumSource(&parser.ListExpression{Elements: exp.Elements})
+		if err != nil {
+			return "", err
+		}
+		return "#" + list, nil
+	}
+	return "", fmt.Errorf("can't write %T in a quoted list", exp)
+}
+
+func addImageBuiltins(env *Environment) {
+	addBuiltinToEnv(env, "save-image", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'save-image' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+			if parameters[0].Type != StringType {
+				return nil, conditionErrorf(WrongTypeCondition, "'save-image' expected a file name string, got %s", parameters[0].Type)
+			}
+			if err := evaluator.requireCapability(CapabilityFileSystem, "save-image"); err != nil {
+				return nil, err
+			}
+
+			// the bindings that can't be saved are left out with a warning, their names are returned
+			path := parameters[0].StringValue()
+			image, skipped := evaluator.image()
+			if err := os.WriteFile(path, []byte(image), 0o644); err != nil {
+				return nil, conditionErrorf(FileErrorCondition, "'save-image' failed to write %s: %s", path, err)
+			}
+			if len(skipped) == 0 {
+				return Void, nil
+			}
+			evaluator.flushOutput()
+			names := make([]*ReturnValue, len(skipped))
+			for i, binding := range skipped {
+				fmt.Fprintf(evaluator.stderr, "warning: 'save-image' %s\n", binding)
+				names[i] = &ReturnValue{Type: SymbolType, Data: binding.name}
+			}
+			return makeList(names), nil
+		},
+	})
+
+	addBuiltinToEnv(env, "load-image", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'load-image' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+			if parameters[0].Type != StringType {
+				return nil, conditionErrorf(WrongTypeCondition, "'load-image' expected a file name string, got %s", parameters[0].Type)
+			}
+			if err := evaluator.requireCapability(CapabilityFileSystem, "load-image"); err != nil {
+				return nil, err
+			}
+
+			// images are global, restored into the global environment wherever load-image is called
+			if _, err := evaluator.load(parameters[0].StringValue(), evaluator.globalEnv, "load-image"); err != nil {
+				return nil, err
+			}
+			return Void, nil
+		},
+	})
+}
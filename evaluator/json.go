@@ -0,0 +1,267 @@
+package evaluator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MarshalJSON writes rv as JSON:
+//
+//	numbers               numbers, floats keep a fraction, 2.0 rather than 2, rationals are floats
+//	strings and symbols   strings
+//	characters            strings of one character
+//	#t and #f             true and false
+//	void                  null
+//	hash tables           objects, when every key is a string or a symbol and none repeats
+//	association lists     objects, when every entry is a dotted pair keyed like a hash table
+//	other lists, vectors  arrays
+//
+// Other values, like procedures, pairs outside an association list, NaN or infinities, are an error.
+// The empty list is the empty array. A list of lists like (("a" 1)) is an array of arrays, its
+// entries could be either.
+func (rv *ReturnValue) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, rv); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeJSON(buf *bytes.Buffer, rv *ReturnValue) error {
+	if rv == nil {
+		buf.WriteString("null")
+		return nil
+	}
+	switch rv.Type {
+	case NumberType:
+		n := rv.Number()
+		if f := n.Float64(); !n.isExact() && (math.IsNaN(f) || math.IsInf(f, 0)) {
+			return fmt.Errorf("can't write %s to JSON", n)
+		}
+		if n.isRational() {
+			n = MakeFloat64Number(n.Float64())
+		}
+		buf.WriteString(numberSource(n))
+		return nil
+	case StringType:
+		return writeJSONString(buf, rv.StringValue())
+	case SymbolType:
+		return writeJSONString(buf, rv.Symbol())
+	case CharType:
+		return writeJSONString(buf, string(rv.Char()))
+	case ConstantType:
+		switch rv.Data {
+		case TrueValue:
+			buf.WriteString("true")
+		case FalseValue:
+			buf.WriteString("false")
+		default:
+			buf.WriteString("null")
+		}
+		return nil
+	case ListType:
+		buf.WriteString("[]")
+		return nil
+	case ConsType:
+		elements, ok := listElements(rv)
+		if !ok {
+			break
+		}
+		if keys, ok := objectKeys(elements); ok {
+			buf.WriteByte('{')
+			for i, entry := range elements {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				if err := writeJSONString(buf, keys[i]); err != nil {
+					return err
+				}
+				buf.WriteByte(':')
+				cdr, _ := getCdr(entry)
+				if err := writeJSON(buf, cdr); err != nil {
+					return err
+				}
+			}
+			buf.WriteByte('}')
+			return nil
+		}
+
+		return writeJSONArray(buf, elements)
+	case VectorType:
+		return writeJSONArray(buf, rv.Vector().Elements)
+	case HashTableType:
+		entries := sortedEntries(rv.HashTable())
+		keys := make([]string, len(entries))
+		seen := make(map[string]bool, len(entries))
+		for i, entry := range entries {
+			key, ok := jsonKey(entry.key)
+			if !ok {
+				return fmt.Errorf("can't write a hash table keyed by a %s to JSON", entry.key.Type)
+			}
+			if seen[key] {
+				return fmt.Errorf("can't write a hash table with two %q keys to JSON", key)
+			}
+			seen[key] = true
+			keys[i] = key
+		}
+		// ordered by key, a hash table has no order of its own
+		sort.Sort(byKey{keys, entries})
+		buf.WriteByte('{')
+		for i, entry := range entries {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSONString(buf, keys[i]); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := writeJSON(buf, entry.value); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+		return nil
+	}
+	return fmt.Errorf("can't write a %s to JSON", rv.Type)
+}
+
+// writeJSONArray writes elements as a JSON array.
+func writeJSONArray(buf *bytes.Buffer, elements []*ReturnValue) error {
+	buf.WriteByte('[')
+	for i, element := range elements {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := writeJSON(buf, element); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte(']')
+	return nil
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	buf.Write(b)
+	return nil
+}
+
+// byKey sorts the entries of a hash table along with their keys.
+type byKey struct {
+	keys    []string
+	entries []*hashTableEntry
+}
+
+func (b byKey) Len() int           { return len(b.keys) }
+func (b byKey) Less(i, j int) bool { return b.keys[i] < b.keys[j] }
+func (b byKey) Swap(i, j int) {
+	b.keys[i], b.keys[j] = b.keys[j], b.keys[i]
+	b.entries[i], b.entries[j] = b.entries[j], b.entries[i]
+}
+
+// jsonKey returns the object key a string or a symbol is written as.
+func jsonKey(rv *ReturnValue) (string, bool) {
+	switch rv.Type {
+	case StringType:
+		return rv.StringValue(), true
+	case SymbolType:
+		return rv.Symbol(), true
+	}
+	return "", false
+}
+
+// objectKeys returns the keys of elements when they are an association list that can be written as
+// an object, one of dotted pairs: (("a" . 1)) is an object but (("a" 1)) is a list of lists.
+func objectKeys(elements []*ReturnValue) ([]string, bool) {
+	if len(elements) == 0 {
+		return nil, false
+	}
+	keys := make([]string, len(elements))
+	seen := make(map[string]bool, len(elements))
+	for i, entry := range elements {
+		if entry.Type != ConsType {
+			return nil, false
+		}
+		if cdr := entry.Cons().Cdr; cdr.Type == ConsType || cdr.Type == ListType {
+			return nil, false
+		}
+		key, ok := jsonKey(entry.Cons().Car)
+		if !ok {
+			return nil, false
+		}
+		keys[i] = key
+		if seen[keys[i]] {
+			return nil, false
+		}
+		seen[keys[i]] = true
+	}
+	return keys, true
+}
+
+// UnmarshalJSON sets rv to the soup value of a JSON document, the reverse of MarshalJSON: numbers
+// without a fraction or exponent become integers, objects hash tables keyed by strings, and null
+// void.
+func (rv *ReturnValue) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return err
+	}
+	val, err := fromJSON(v)
+	if err != nil {
+		return err
+	}
+	*rv = *val
+	return nil
+}
+
+func fromJSON(v any) (*ReturnValue, error) {
+	switch v := v.(type) {
+	case json.Number:
+		if !strings.ContainsAny(v.String(), ".eE") {
+			if i, err := strconv.ParseInt(v.String(), 10, 64); err == nil {
+				return &ReturnValue{Type: NumberType, Data: MakeInt64Number(i)}, nil
+			}
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("json: %w", err)
+		}
+		return &ReturnValue{Type: NumberType, Data: MakeFloat64Number(f)}, nil
+	case []any:
+		elements := make([]*ReturnValue, len(v))
+		for i, element := range v {
+			val, err := fromJSON(element)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = val
+		}
+		return makeList(elements), nil
+	case map[string]any:
+		table := &HashTableValue{equal: true, buckets: make(map[string][]*hashTableEntry)}
+		for k, element := range v {
+			val, err := fromJSON(element)
+			if err != nil {
+				return nil, err
+			}
+			key := newString(k)
+			// a string can't hold itself, looking it up doesn't fail
+			_, hash, _ := table.lookup(key, "json")
+			table.buckets[hash] = append(table.buckets[hash], &hashTableEntry{key: key, value: val})
+			table.count++
+		}
+		return &ReturnValue{Type: HashTableType, Data: table}, nil
+	}
+	// strings, bools and null convert like Go values do
+	return FromGo(v)
+}
@@ -0,0 +1,221 @@
+package evaluator
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/ocowchun/soup/parser"
+)
+
+// Library is a module defined by define-library, its body is evaluated in its own environment
+// and only the exported bindings are visible to importers.
+type Library struct {
+	Name string
+	env  *Environment
+	// exports maps the external name of each export to the binding in env
+	exports map[string]string
+	// order keeps the external names in declaration order
+	order []string
+}
+
+func (e *Evaluator) evalDefineLibraryExpression(exp *parser.DefineLibraryExpression) (*ReturnValue, error) {
+	name := parser.LibraryName(exp.Name)
+	library := &Library{
+		Name:    name,
+		env:     &Environment{enclosing: e.baseEnv, store: make(map[string]*ReturnValue)},
+		exports: make(map[string]string),
+	}
+
+	for _, set := range exp.Imports {
+		if err := e.importInto(set, library.env); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, body := range exp.Body {
+		if _, err := e.eval(body, library.env); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, export := range exp.Exports {
+		if _, ok := library.env.Get(export.Internal); !ok {
+			return nil, conditionErrorf(GenericCondition, "library %s exports `%s`, which is not defined", name, export.Internal)
+		}
+		if _, ok := library.exports[export.External]; ok {
+			return nil, conditionErrorf(GenericCondition, "library %s exports `%s` more than once", name, export.External)
+		}
+		library.exports[export.External] = export.Internal
+		library.order = append(library.order, export.External)
+	}
+
+	e.libraries[name] = library
+	return Void, nil
+}
+
+func (e *Evaluator) evalImportExpression(exp *parser.ImportExpression, environment *Environment) (*ReturnValue, error) {
+	for _, set := range exp.Sets {
+		if err := e.importInto(set, environment); err != nil {
+			return nil, err
+		}
+	}
+	return Void, nil
+}
+
+// evalRequireExpression loads the files a Racket require names into environment, each of them once
+// like Racket instantiates a module once. The libraries it names, such as racket/list, are ignored:
+// what soup has of them is builtin.
+func (e *Evaluator) evalRequireExpression(exp *parser.RequireExpression, environment *Environment) (*ReturnValue, error) {
+	for _, name := range exp.Files {
+		if err := e.requireCapability(CapabilityFileSystem, "require"); err != nil {
+			return nil, err
+		}
+		path, err := e.resolveLoadPath(name, "require")
+		if err != nil {
+			return nil, err
+		}
+		if e.required[path] {
+			continue
+		}
+		if _, err := e.load(path, environment, "require"); err != nil {
+			return nil, err
+		}
+		e.required[path] = true
+	}
+	return Void, nil
+}
+
+// importInto binds the values of the import set in environment. Bindings are copied at import time,
+// so a later set! inside the library is not visible to the importer.
+func (e *Evaluator) importInto(set *parser.ImportSet, environment *Environment) error {
+	bindings, order, err := e.resolveImportSet(set)
+	if err != nil {
+		return err
+	}
+	for _, name := range order {
+		environment.Put(name, bindings[name])
+	}
+	return nil
+}
+
+// resolveImportSet returns the bindings an import set contributes and their names in declaration order.
+func (e *Evaluator) resolveImportSet(set *parser.ImportSet) (map[string]*ReturnValue, []string, error) {
+	if set.Modifier == parser.ImportLibrary {
+		// the builtins are always available, (import (scheme base)) is accepted so R7RS programs run unchanged
+		if set.Library[0] == "scheme" {
+			return map[string]*ReturnValue{}, nil, nil
+		}
+
+		library, err := e.findLibrary(set.Library)
+		if err != nil {
+			return nil, nil, err
+		}
+		bindings := make(map[string]*ReturnValue, len(library.exports))
+		for _, external := range library.order {
+			val, _ := library.env.Get(library.exports[external])
+			bindings[external] = val
+		}
+		return bindings, library.order, nil
+	}
+
+	bindings, order, err := e.resolveImportSet(set.Inner)
+	if err != nil {
+		return nil, nil, err
+	}
+	checkImported := func(name string) error {
+		if _, ok := bindings[name]; !ok {
+			return conditionErrorf(GenericCondition, "`%s` is not exported by %s", name, set.Inner.String())
+		}
+		return nil
+	}
+
+	switch set.Modifier {
+	case parser.ImportOnly:
+		only := make(map[string]*ReturnValue, len(set.Identifiers))
+		for _, name := range set.Identifiers {
+			if err := checkImported(name); err != nil {
+				return nil, nil, err
+			}
+			only[name] = bindings[name]
+		}
+		return only, set.Identifiers, nil
+	case parser.ImportExcept:
+		for _, name := range set.Identifiers {
+			if err := checkImported(name); err != nil {
+				return nil, nil, err
+			}
+			delete(bindings, name)
+		}
+		return bindings, filterNames(order, bindings), nil
+	case parser.ImportPrefix:
+		prefixed := make(map[string]*ReturnValue, len(bindings))
+		prefixedOrder := make([]string, len(order))
+		for i, name := range order {
+			prefixed[set.Prefix+name] = bindings[name]
+			prefixedOrder[i] = set.Prefix + name
+		}
+		return prefixed, prefixedOrder, nil
+	case parser.ImportRename:
+		renamedOrder := append([]string{}, order...)
+		for _, rename := range set.Renames {
+			if err := checkImported(rename.Internal); err != nil {
+				return nil, nil, err
+			}
+			bindings[rename.External] = bindings[rename.Internal]
+			delete(bindings, rename.Internal)
+			for i, name := range renamedOrder {
+				if name == rename.Internal {
+					renamedOrder[i] = rename.External
+				}
+			}
+		}
+		return bindings, renamedOrder, nil
+	default:
+		panic("unreachable")
+	}
+}
+
+func filterNames(names []string, bindings map[string]*ReturnValue) []string {
+	filtered := make([]string, 0, len(bindings))
+	for _, name := range names {
+		if _, ok := bindings[name]; ok {
+			filtered = append(filtered, name)
+		}
+	}
+	return filtered
+}
+
+// findLibrary returns a library defined earlier, or loads it from a file: (soup math) is looked up
+// as soup/math.sld then soup/math.scm, in the directory of the program being run (the working
+// directory when no file is being loaded) and then in each search path directory.
+func (e *Evaluator) findLibrary(nameParts []string) (*Library, error) {
+	name := parser.LibraryName(nameParts)
+	if library, ok := e.libraries[name]; ok {
+		return library, nil
+	}
+
+	if err := e.requireCapability(CapabilityFileSystem, "import"); err != nil {
+		return nil, err
+	}
+
+	root := "."
+	if len(e.loadingFiles) > 0 {
+		root = filepath.Dir(e.loadingFiles[0])
+	}
+	base := filepath.Join(nameParts...)
+	path, searched, err := findInDirs(append([]string{root}, e.searchPath...), []string{base + ".sld", base + ".scm"})
+	if err != nil {
+		return nil, conditionErrorf(FileErrorCondition, "failed to resolve library %s: %s", name, err)
+	}
+	if path == "" {
+		return nil, conditionErrorf(FileErrorCondition, "library %s not found, searched: %s", name, strings.Join(searched, ", "))
+	}
+
+	if _, err := e.load(path, e.globalEnv, "import"); err != nil {
+		return nil, err
+	}
+	if library, ok := e.libraries[name]; ok {
+		return library, nil
+	}
+	return nil, conditionErrorf(GenericCondition, "%s does not define library %s", path, name)
+}
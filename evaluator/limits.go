@@ -0,0 +1,128 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Limits bounds the resources of each top-level Eval or LoadFile, a zero field means no limit, except
+// that calls never nest deeper than DefaultMaxDepth. Exceeding one raises a resource-limit condition,
+// which guard and with-exception-handler can catch when calls nest too deep or the run is out of
+// cells, but not when it's out of steps or time: handling those would only run longer. The handlers
+// of the cells condition may allocate handlerCells more, exceeding those ends the run.
+type Limits struct {
+	// Timeout is checked between expressions, a builtin blocked on input or the network isn't interrupted
+	Timeout time.Duration
+	// MaxSteps is how many expressions may be evaluated, counted like Steps
+	MaxSteps uint64
+	// MaxDepth is how deeply procedure calls may nest, a call in tail position doesn't nest
+	MaxDepth int
+	// MaxCells is roughly how much memory a run may allocate, counted like Cells
+	MaxCells uint64
+}
+
+// deadlineCheckInterval is how many steps pass between looking at the clock.
+const deadlineCheckInterval = 1024
+
+func (e *Evaluator) SetLimits(limits Limits) {
+	e.limits = limits
+}
+
+func (e *Evaluator) Limits() Limits {
+	return e.limits
+}
+
+// cellBytes is how many bytes of a string count as a cell.
+const cellBytes = 16
+
+// handlerCells is how many cells a run may allocate after running out, enough for a handler to bind
+// the condition and report it.
+const handlerCells = 1024
+
+// Cells returns how many cells the evaluator has allocated so far, an approximation of memory use
+// that counts a cell for each procedure call and each variable it binds, each element of a list
+// literal or of a list or vector a builtin returns, each pair, each entry of a hash table, and each
+// 16 bytes of a string or bytevector a builtin returns. Cells are counted when they are created and
+// never given back, so a long run that keeps little alive still adds up.
+func (e *Evaluator) Cells() uint64 {
+	return e.cells
+}
+
+// allocate counts n cells against the run's MaxCells.
+func (e *Evaluator) allocate(n int) error {
+	e.cells += uint64(n)
+	if e.maxCells > 0 && e.cells > e.maxCells {
+		if e.outOfCells {
+			return runLimitErrorf("resource limit exceeded: allocated more than %d cells", e.limits.MaxCells)
+		}
+		e.outOfCells = true
+		e.maxCells = e.cells + handlerCells
+		return conditionErrorf(ResourceLimitCondition, "resource limit exceeded: allocated more than %d cells", e.limits.MaxCells)
+	}
+	return nil
+}
+
+// valueCells is how many cells allocate counts for a value a builtin returns. A builtin returning a
+// pair or a vector it was given, like cdr, is counted as if it built it, which overcounts rather than
+// misses any. The pairs of a list after its first are counted by the builtin that builds them.
+func valueCells(val *ReturnValue) int {
+	switch val.Type {
+	case ConsType:
+		return 1
+	case VectorType:
+		return len(val.Vector().Elements)
+	case StringType:
+		return len(val.StringValue())/cellBytes + 1
+	case BytevectorType:
+		return len(val.Bytevector().Bytes)/cellBytes + 1
+	}
+	return 0
+}
+
+// runLimitErrorf returns the resource-limit condition of a run out of steps or time, or out of cells
+// a second time, which no handler can catch.
+func runLimitErrorf(format string, a ...any) error {
+	return &Condition{Kind: ResourceLimitCondition, Message: fmt.Sprintf(format, a...), uncatchable: true}
+}
+
+// startRun sets the step and cell budgets and the deadline of a top-level Eval or LoadFile.
+func (e *Evaluator) startRun() {
+	e.closeDroppedGenerators()
+	if e.arena != nil {
+		e.arena.reset()
+	}
+	e.maxSteps = 0
+	if e.limits.MaxSteps > 0 {
+		e.maxSteps = e.steps + e.limits.MaxSteps
+	}
+	e.maxCells = 0
+	e.outOfCells = false
+	if e.limits.MaxCells > 0 {
+		e.maxCells = e.cells + e.limits.MaxCells
+	}
+	e.deadline = time.Time{}
+	if e.limits.Timeout > 0 {
+		e.deadline = time.Now().Add(e.limits.Timeout)
+	}
+}
+
+func (e *Evaluator) checkLimits() error {
+	if e.maxSteps > 0 && e.steps > e.maxSteps {
+		return runLimitErrorf("resource limit exceeded: evaluated more than %d steps", e.limits.MaxSteps)
+	}
+	if e.limits.MaxDepth > 0 && e.depth > e.limits.MaxDepth {
+		return conditionErrorf(ResourceLimitCondition, "resource limit exceeded: procedure calls nested more than %d deep", e.limits.MaxDepth)
+	}
+	if !e.deadline.IsZero() && e.steps%deadlineCheckInterval == 0 && time.Now().After(e.deadline) {
+		return runLimitErrorf("resource limit exceeded: ran for more than %s", e.limits.Timeout)
+	}
+	if e.ctx != nil {
+		select {
+		case <-e.ctx.Done():
+			return conditionErrorf(CanceledCondition, "evaluation canceled: %s", context.Cause(e.ctx))
+		default:
+		}
+	}
+	return nil
+}
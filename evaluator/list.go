@@ -0,0 +1,98 @@
+package evaluator
+
+import "strings"
+
+// A list is a chain of pairs ending in the empty list, as in any Scheme: (1 2) is a pair of 1 and a
+// pair of 2 and EmptyList, the only value of ListType. cons, car and cdr take constant time, and
+// lists share their pairs: (cons 0 l) is a new pair whose cdr is l itself, so set-car! and
+// set-cdr! on a pair of l change every list made from it. A chain ending in anything but the empty
+// list is an improper list such as (1 2 . 3); set-cdr! can also make a chain circular.
+
+// makeList returns a new list of elements.
+func makeList(elements []*ReturnValue) *ReturnValue {
+	return makeDottedList(elements, EmptyList)
+}
+
+// makeDottedList returns new pairs of elements chained to tail, an improper list unless tail is a
+// list.
+func makeDottedList(elements []*ReturnValue, tail *ReturnValue) *ReturnValue {
+	list := tail
+	for i := len(elements) - 1; i >= 0; i-- {
+		list = &ReturnValue{Type: ConsType, Data: &ConsValue{Car: elements[i], Cdr: list}}
+	}
+	return list
+}
+
+// newList is makeList for the lists builtins return, its pairs are counted against the limits and
+// come from the arena when there's one.
+func (e *Evaluator) newList(elements []*ReturnValue) (*ReturnValue, error) {
+	if err := e.allocate(len(elements)); err != nil {
+		return nil, err
+	}
+	list := EmptyList
+	for i := len(elements) - 1; i >= 0; i-- {
+		list = e.newValue(ConsType, &ConsValue{Car: elements[i], Cdr: list})
+	}
+	return list, nil
+}
+
+// listParts returns the cars of the pairs chained from val and what the chain ends in: EmptyList
+// for a list, another value for an improper list, and nil for a circular list, whose elements are
+// then the ones walked before the cycle was found.
+func listParts(val *ReturnValue) ([]*ReturnValue, *ReturnValue) {
+	var elements []*ReturnValue
+	// slow follows at half the speed, the chain is circular when val catches up with it
+	slow := val
+	for val.Type == ConsType {
+		elements = append(elements, val.Cons().Car)
+		val = val.Cons().Cdr
+		if len(elements)%2 == 0 {
+			slow = slow.Cons().Cdr
+		}
+		if val.Type == ConsType && val.Cons() == slow.Cons() {
+			return elements, nil
+		}
+	}
+	return elements, val
+}
+
+// listElements returns the elements of val when it's a list, the empty list or pairs ending in it.
+func listElements(val *ReturnValue) ([]*ReturnValue, bool) {
+	elements, tail := listParts(val)
+	if tail == nil || tail.Type != ListType {
+		return nil, false
+	}
+	return elements, true
+}
+
+// ListElements returns the elements of rv when it's a list, the empty list or pairs ending in it.
+func (rv *ReturnValue) ListElements() ([]*ReturnValue, bool) {
+	return listElements(rv)
+}
+
+// isList reports whether val is a list, the empty list or pairs ending in it.
+func isList(val *ReturnValue) bool {
+	slow := val
+	for n := 1; val.Type == ConsType; n++ {
+		val = val.Cons().Cdr
+		if n%2 == 0 {
+			slow = slow.Cons().Cdr
+		}
+		if val.Type == ConsType && val.Cons() == slow.Cons() {
+			return false
+		}
+	}
+	return val.Type == ListType
+}
+
+// writeListTail writes what ends the elements of a list written to b, see listParts: nothing for
+// the empty list, " . tail" for an improper list and " ..." for a circular one.
+func writeListTail(b *strings.Builder, tail *ReturnValue, write func(*ReturnValue) string) {
+	switch {
+	case tail == nil:
+		b.WriteString(" ...")
+	case tail.Type != ListType:
+		b.WriteString(" . ")
+		b.WriteString(write(tail))
+	}
+}
@@ -0,0 +1,244 @@
+package evaluator
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ocowchun/soup/lexer"
+	"github.com/ocowchun/soup/parser"
+)
+
+// LoadFile evaluates the file at path in the global environment and returns the value of its last
+// expression. Relative `load`s inside the file resolve against the file's directory.
+func (e *Evaluator) LoadFile(path string) (*ReturnValue, error) {
+	e.startRun()
+	defer e.flushOutput()
+	return e.load(path, e.globalEnv, "")
+}
+
+// LoadFileContext is LoadFile with the cancellation of EvalContext.
+func (e *Evaluator) LoadFileContext(ctx context.Context, path string) (*ReturnValue, error) {
+	defer e.setContext(ctx)()
+	return e.LoadFile(path)
+}
+
+// load evaluates the file name in environment, who is the form loading it, named in the errors
+// about finding and reading the file, "" for LoadFile.
+func (e *Evaluator) load(name string, environment *Environment, who string) (*ReturnValue, error) {
+	path, err := e.resolveLoadPath(name, who)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, loading := range e.loadingFiles {
+		if loading == path {
+			chain := append(append([]string{}, e.loadingFiles...), path)
+			return nil, conditionErrorf(FileErrorCondition, "%sdetected a recursive load: %s", loadPrefix(who), strings.Join(chain, " -> "))
+		}
+	}
+
+	program, err := parseFile(path, e.syntax, who)
+	if err != nil {
+		return nil, err
+	}
+
+	e.loadingFiles = append(e.loadingFiles, path)
+	defer func() {
+		e.loadingFiles = e.loadingFiles[:len(e.loadingFiles)-1]
+	}()
+	defer e.setSource(&source{file: path})()
+
+	ret := Void
+	for _, exp := range program.Expressions {
+		ret, err = e.evalTopLevel(exp, environment)
+		if err != nil {
+			locateRuntimeError(err, e.source)
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// resolveLoadPath turns name into an absolute path. A relative name is looked up in the directory of
+// the file being loaded, or the working directory at the top level, then in each search path directory.
+func (e *Evaluator) resolveLoadPath(name string, who string) (string, error) {
+	if filepath.IsAbs(name) {
+		if _, err := os.Stat(name); errors.Is(err, os.ErrNotExist) {
+			return "", conditionErrorf(FileErrorCondition, "%scould not find %s", loadPrefix(who), name)
+		}
+		return filepath.Clean(name), nil
+	}
+
+	dir := "."
+	if len(e.loadingFiles) > 0 {
+		dir = filepath.Dir(e.loadingFiles[len(e.loadingFiles)-1])
+	}
+	path, searched, err := findInDirs(append([]string{dir}, e.searchPath...), []string{name})
+	if err != nil {
+		return "", conditionErrorf(FileErrorCondition, "%sfailed to resolve %s: %s", loadPrefix(who), name, err)
+	}
+	if path == "" {
+		return "", conditionErrorf(FileErrorCondition, "%scould not find %s, searched: %s", loadPrefix(who), name, strings.Join(searched, ", "))
+	}
+	return path, nil
+}
+
+// loadPrefix starts the errors of the form who, e.g. 'load' could not find..., LoadFile's have none.
+func loadPrefix(who string) string {
+	if who == "" {
+		return ""
+	}
+	return "'" + who + "' "
+}
+
+// findInDirs returns the absolute path of the first existing dir/name, trying every name in a directory
+// before moving to the next one, along with every path it tried.
+func findInDirs(dirs []string, names []string) (string, []string, error) {
+	searched := make([]string, 0, len(dirs)*len(names))
+	for _, dir := range dirs {
+		for _, name := range names {
+			path, err := filepath.Abs(filepath.Join(dir, name))
+			if err != nil {
+				return "", nil, err
+			}
+			searched = append(searched, path)
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				return path, searched, nil
+			}
+		}
+	}
+	return "", searched, nil
+}
+
+// ParseFile parses the file at path the way load does. Until the file changes, the program it
+// returns is the one LoadFile and load evaluate, so a Hook can tell which file an expression is from.
+func ParseFile(path string) (*parser.Program, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, conditionErrorf(FileErrorCondition, "'load' failed to resolve %s: %s", path, err)
+	}
+	return parseFile(path, syntaxOptions{}, "load")
+}
+
+type parsedFile struct {
+	sum     [sha256.Size]byte
+	syntax  syntaxOptions
+	program *parser.Program
+}
+
+// syntaxOptions are the parser settings the evaluator's options select, see WithShadowing and WithRacket,
+// and the macros it expands.
+type syntaxOptions struct {
+	shadowing bool
+	racket    bool
+	macros    *parser.Macros
+}
+
+func (s syntaxOptions) newParser(r io.Reader) *parser.Parser {
+	p := parser.New(lexer.New(r))
+	if s.shadowing {
+		p.AllowShadowing()
+	}
+	if s.racket {
+		p.Racket()
+	}
+	if s.macros != nil {
+		p.UseMacros(s.macros)
+	}
+	return p
+}
+
+// parseCache keeps the latest parsed program of every file, shared by all evaluators like the prelude.
+// Entries are keyed by path and reused while the content hash matches, so reloading an unchanged file
+// skips lexing and parsing.
+var parseCache = struct {
+	sync.Mutex
+	files map[string]parsedFile
+}{files: make(map[string]parsedFile)}
+
+// parseFile parses the file at path with the parser settings of syntax, for the form who like load.
+// How a file parses depends on the macros defined before it's loaded, so only files parsed without
+// any are cached.
+func parseFile(path string, syntax syntaxOptions, who string) (*parser.Program, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, conditionErrorf(FileErrorCondition, "%sfailed to open %s: %s", loadPrefix(who), path, err)
+	}
+
+	sum := sha256.Sum256(content)
+	cacheable := syntax.macros.Len() == 0
+	key := syntax
+	key.macros = nil
+	parseCache.Lock()
+	cached, ok := parseCache.files[path]
+	parseCache.Unlock()
+	if cacheable && ok && cached.sum == sum && cached.syntax == key {
+		return cached.program, nil
+	}
+
+	program, err := syntax.newParser(bytes.NewReader(content)).Parse()
+	if err != nil {
+		var parsingError *parser.ParsingError
+		if errors.As(err, &parsingError) {
+			parsingError.File = path
+		}
+		return nil, fmt.Errorf("%sfailed to parse %s: %w", loadPrefix(who), path, err)
+	}
+
+	if cacheable {
+		parseCache.Lock()
+		parseCache.files[path] = parsedFile{sum: sum, syntax: key, program: program}
+		parseCache.Unlock()
+	}
+	return program, nil
+}
+
+func addLoadBuiltins(env *Environment) {
+	addBuiltinToEnv(env, "load", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'load' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+			if parameters[0].Type != StringType {
+				return nil, conditionErrorf(WrongTypeCondition, "'load' expected a file name string, got %s", parameters[0].Type)
+			}
+			if err := evaluator.requireCapability(CapabilityFileSystem, "load"); err != nil {
+				return nil, err
+			}
+
+			return evaluator.load(parameters[0].StringValue(), environment, "load")
+		},
+	})
+}
+
+// SearchPathEnv lists extra directories, separated like PATH, that load and import search.
+const SearchPathEnv = "SOUP_PATH"
+
+// SetSearchPath sets the directories searched, in order, by load and import when a file isn't found
+// next to the program.
+func (e *Evaluator) SetSearchPath(dirs []string) {
+	e.searchPath = append([]string{}, dirs...)
+}
+
+func (e *Evaluator) SearchPath() []string {
+	return e.searchPath
+}
+
+// SearchPathFromEnv returns the directories listed in SOUP_PATH, skipping empty entries.
+func SearchPathFromEnv() []string {
+	dirs := make([]string, 0)
+	for _, dir := range filepath.SplitList(os.Getenv(SearchPathEnv)) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
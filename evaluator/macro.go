@@ -0,0 +1,98 @@
+package evaluator
+
+import (
+	"strings"
+
+	"github.com/ocowchun/soup/parser"
+)
+
+// expandMacro is the parser.MacroExpander of e's macros. The transformer of a define-macro is
+// evaluated in the global environment when the macro is first used, and the procedure it evaluates
+// to is kept for the next uses.
+func (e *Evaluator) expandMacro(name string, transformer parser.Expression, operands []string) (string, error) {
+	proc, ok := e.transformers[transformer]
+	if !ok {
+		val, err := e.eval(transformer, e.globalEnv)
+		if err != nil {
+			return "", err
+		}
+		if val.Type != ProcedureType && val.Type != BuiltinFunctionType {
+			return "", conditionErrorf(WrongTypeCondition, "the transformer of %s is %s rather than a procedure", name, val)
+		}
+		nameProcedure(val, name)
+		e.transformers[transformer] = val
+		proc = val
+	}
+
+	args := make([]*ReturnValue, len(operands))
+	for i, operand := range operands {
+		arg, err := read(strings.NewReader(operand))
+		if err != nil {
+			return "", err
+		}
+		args[i] = arg
+	}
+	val, err := e.applyProcedure(proc, args, e.globalEnv)
+	if err != nil {
+		return "", err
+	}
+	return formSource(val)
+}
+
+// formSource writes val, what a transformer returned, as the source of the form the parser reads
+// in place of the macro use. The parser has no quote form, (quote x) is written 'x.
+func formSource(val *ReturnValue) (string, error) {
+	switch val.Type {
+	case SymbolType:
+		return val.Symbol(), nil
+	case NumberType, StringType, CharType:
+		return val.String(), nil
+	case ConstantType:
+		if val == Void {
+			break
+		}
+		return val.String(), nil
+	case ListType, ConsType:
+		elements, tail := listParts(val)
+		if tail == nil {
+			return "", conditionErrorf(WrongTypeCondition, "a circular list can't be part of a form")
+		}
+		if tail.Type == ListType {
+			tail = nil
+		}
+		if tail == nil && len(elements) == 2 && elements[0].Type == SymbolType && elements[0].Symbol() == "quote" {
+			quoted, err := formSource(elements[1])
+			if err != nil {
+				return "", err
+			}
+			return "'" + quoted, nil
+		}
+		parts := make([]string, 0, len(elements)+2)
+		for _, element := range elements {
+			part, err := formSource(element)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, part)
+		}
+		if tail != nil {
+			part, err := formSource(tail)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, ".", part)
+		}
+		return "(" + strings.Join(parts, " ") + ")", nil
+	case VectorType:
+		parts := make([]string, len(val.Vector().Elements))
+		for i, element := range val.Vector().Elements {
+			part, err := formSource(element)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = part
+		}
+		return "#(" + strings.Join(parts, " ") + ")", nil
+	}
+	return "", conditionErrorf(WrongTypeCondition, "%s can't be part of a form", val)
+}
@@ -0,0 +1,11 @@
+;; mit.scm is evaluated by evaluator.New after prelude.scm when WithMITCompat is given.
+;; It defines the MIT Scheme procedures the SICP lectures use that soup doesn't have.
+
+(define (1+ n) (+ n 1))
+
+(define (-1+ n) (- n 1))
+
+;; the environments SICP's metacircular evaluator passes to eval
+(define user-initial-environment (interaction-environment))
+
+(define system-global-environment (interaction-environment))
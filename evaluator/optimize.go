@@ -0,0 +1,359 @@
+package evaluator
+
+import (
+	"github.com/ocowchun/soup/lexer"
+	"github.com/ocowchun/soup/parser"
+)
+
+// With WithOptimizer every top-level expression is rewritten before it's evaluated:
+//
+//   - arithmetic and comparisons of literals are folded, (* 2 3) becomes 6
+//   - an if with a literal predicate becomes the branch it takes
+//   - the literal bindings of a let whose body is one expression are substituted into it
+//   - literals and lambdas in a begin or a body are dropped unless they are its value
+//
+// The rewrite never changes the expression it's given, which may be a cached program, it builds new
+// nodes where something changed. A folded call is one whose operator resolves to the builtin when
+// the expression is optimized and isn't bound anywhere in it; redefining + afterwards doesn't change
+// the procedures already optimized, the price of folding at all.
+
+// foldableBuiltins are the builtins that are folded when all of their operands are literals.
+var foldableBuiltins = map[string]bool{
+	"+": true, "-": true, "*": true, "/": true, "remainder": true, "quotient": true,
+	"modulo": true, "abs": true,
+	"=": true, "<": true, ">": true, "<=": true, ">=": true,
+}
+
+type optimizer struct {
+	e           *Evaluator
+	environment *Environment
+	// bound holds every name the expression binds or assigns, those are never folded
+	bound map[string]bool
+}
+
+// optimize returns exp rewritten for evaluation in environment.
+func (e *Evaluator) optimize(exp parser.Expression, environment *Environment) parser.Expression {
+	o := &optimizer{e: e, environment: environment, bound: make(map[string]bool)}
+	collectBoundNames(exp, o.bound)
+	return o.optimize(exp)
+}
+
+func (o *optimizer) optimize(exp parser.Expression) parser.Expression {
+	if _, ok := exp.(*parser.AssertExpression); ok {
+		// an assert is kept as written, it reports its source when it fails
+		return exp
+	}
+	exp = mapSubexpressions(exp, o.optimize)
+	switch exp := exp.(type) {
+	case *parser.CallExpression:
+		if lambda, ok := exp.Operator.(*parser.LambdaExpression); ok {
+			return o.inlineLet(exp, lambda)
+		}
+		return o.fold(exp)
+	case *parser.IfExpression:
+		if isLiteral(exp.Predicate) {
+			if exp.Predicate == parser.FalseLiteral {
+				if exp.Alternative == nil {
+					return parser.Void
+				}
+				return exp.Alternative
+			}
+			return exp.Consequent
+		}
+	case *parser.BeginExpression:
+		expressions := dropDeadExpressions(exp.Expressions)
+		if len(expressions) == 1 {
+			return expressions[0]
+		}
+		if len(expressions) != len(exp.Expressions) {
+			return &parser.BeginExpression{LeftParenToken: exp.LeftParenToken, Expressions: expressions}
+		}
+	case *parser.LambdaExpression:
+		if body := dropDeadExpressions(exp.Body); len(body) != len(exp.Body) {
+			lambda := *exp
+			lambda.Body = body
+			return &lambda
+		}
+	}
+	return exp
+}
+
+// fold evaluates the call of a foldable builtin with literal operands, a call that fails is kept
+// so it raises its error when it's evaluated.
+func (o *optimizer) fold(exp *parser.CallExpression) parser.Expression {
+	name := ""
+	switch operator := exp.Operator.(type) {
+	case *parser.PrimitiveProcedureExpression:
+		name = operator.Value
+	case *parser.IdentifierExpression:
+		name = operator.Value
+	}
+	if !foldableBuiltins[name] || o.bound[name] {
+		return exp
+	}
+	val, ok := o.environment.Get(name)
+	builtin, isBuiltin := o.e.baseEnv.Get(name)
+	if !ok || !isBuiltin || val != builtin || val.Type != BuiltinFunctionType {
+		return exp
+	}
+
+	operands := make([]*ReturnValue, len(exp.Operands))
+	for i, operand := range exp.Operands {
+		literal, ok := operand.(*parser.NumberLiteral)
+		if !ok {
+			return exp
+		}
+		n, err := o.e.evalExpression(literal, nil)
+		if err != nil {
+			return exp
+		}
+		operands[i] = n
+	}
+	ret, err := val.BuiltinFunction().Fn(operands, o.e, o.environment)
+	if err != nil {
+		return exp
+	}
+	if literal := literalOf(ret, exp.Token()); literal != nil {
+		return literal
+	}
+	return exp
+}
+
+// inlineLet substitutes the literal operands of a let into its body, the let is gone once all of
+// them are. Only a body of one expression that doesn't rebind or assign the names is inlined.
+func (o *optimizer) inlineLet(exp *parser.CallExpression, lambda *parser.LambdaExpression) parser.Expression {
+	if lambda.OptionalTailParameter != "" || len(lambda.Parameters) != len(exp.Operands) || len(lambda.Body) != 1 {
+		return exp
+	}
+	body := lambda.Body[0]
+	if _, ok := body.(*parser.DefineExpression); ok || callsTheEnvironment(body) {
+		return exp
+	}
+	rebound := make(map[string]bool)
+	collectBoundNames(body, rebound)
+
+	substitutions := make(map[string]parser.Expression)
+	parameters := make([]string, 0, len(lambda.Parameters))
+	operands := make([]parser.Expression, 0, len(exp.Operands))
+	for i, param := range lambda.Parameters {
+		operand := exp.Operands[i]
+		if isLiteral(operand) && !rebound[param] && substitutions[param] == nil {
+			substitutions[param] = operand
+			continue
+		}
+		parameters = append(parameters, param)
+		operands = append(operands, operand)
+	}
+	if len(substitutions) == 0 {
+		if len(parameters) == 0 {
+			return body
+		}
+		return exp
+	}
+	// a parameter that's kept shadows a substituted one of the same name
+	for _, param := range parameters {
+		delete(substitutions, param)
+	}
+
+	body = o.optimize(substitute(body, substitutions))
+	if len(parameters) == 0 {
+		return body
+	}
+	inlined := *lambda
+	inlined.Parameters = parameters
+	inlined.Body = []parser.Expression{body}
+	return &parser.CallExpression{LeftParenToken: exp.LeftParenToken, Operator: &inlined, Operands: operands}
+}
+
+// substitute replaces the identifiers named in substitutions, the caller has made sure no binding
+// inside exp shadows them.
+func substitute(exp parser.Expression, substitutions map[string]parser.Expression) parser.Expression {
+	if identifier, ok := exp.(*parser.IdentifierExpression); ok {
+		if replacement, ok := substitutions[identifier.Value]; ok {
+			return replacement
+		}
+		return exp
+	}
+	return mapSubexpressions(exp, func(sub parser.Expression) parser.Expression {
+		return substitute(sub, substitutions)
+	})
+}
+
+// dropDeadExpressions drops the literals and lambdas of a sequence but its last expression, their
+// values are discarded and evaluating them has no effect.
+func dropDeadExpressions(expressions []parser.Expression) []parser.Expression {
+	dead := 0
+	for _, exp := range expressions[:len(expressions)-1] {
+		if isPure(exp) {
+			dead++
+		}
+	}
+	if dead == 0 {
+		return expressions
+	}
+	live := make([]parser.Expression, 0, len(expressions)-dead)
+	for i, exp := range expressions {
+		if i == len(expressions)-1 || !isPure(exp) {
+			live = append(live, exp)
+		}
+	}
+	return live
+}
+
+// isLiteral reports whether exp is a number or a boolean, literals that can be copied into an
+// expression without changing what it evaluates to.
+func isLiteral(exp parser.Expression) bool {
+	if exp == parser.TrueLiteral || exp == parser.FalseLiteral {
+		return true
+	}
+	_, ok := exp.(*parser.NumberLiteral)
+	return ok
+}
+
+func isPure(exp parser.Expression) bool {
+	if isLiteral(exp) || exp == parser.Void {
+		return true
+	}
+	switch exp.(type) {
+	case *parser.StringLiteral, *parser.CharLiteral, *parser.DatumExpression, *parser.SymbolExpression, *parser.LambdaExpression:
+		return true
+	}
+	return false
+}
+
+// literalOf returns the literal spelling val, the value of a call folded at token, nil if there's none.
+func literalOf(val *ReturnValue, token lexer.Token) parser.Expression {
+	switch {
+	case val == True:
+		return parser.TrueLiteral
+	case val == False:
+		return parser.FalseLiteral
+	case val.Type == NumberType:
+		n := val.Number()
+		return &parser.NumberLiteral{
+			NumToken: lexer.Token{Content: n.String(), Line: token.Line, Column: token.Column, TokenType: lexer.TokenTypeNumber},
+			Value:    n.data,
+		}
+	}
+	return nil
+}
+
+// collectBoundNames adds the names exp defines, assigns or binds as parameters to names.
+func collectBoundNames(exp parser.Expression, names map[string]bool) {
+	switch exp := exp.(type) {
+	case *parser.DefineExpression:
+		names[exp.Name] = true
+	case *parser.SetExpression:
+		names[exp.Name] = true
+	case *parser.LambdaExpression:
+		for _, param := range exp.Parameters {
+			names[param] = true
+		}
+		if exp.OptionalTailParameter != "" {
+			names[exp.OptionalTailParameter] = true
+		}
+	case *parser.GuardExpression:
+		names[exp.Variable] = true
+	}
+	mapSubexpressions(exp, func(sub parser.Expression) parser.Expression {
+		collectBoundNames(sub, names)
+		return sub
+	})
+}
+
+// mapSubexpressions returns exp with f applied to the expressions it evaluates, exp itself when f
+// changes none of them. Quoted data, libraries and imports have none.
+func mapSubexpressions(exp parser.Expression, f func(parser.Expression) parser.Expression) parser.Expression {
+	switch exp := exp.(type) {
+	case *parser.CallExpression:
+		operator := f(exp.Operator)
+		operands, changed := mapExpressions(exp.Operands, f)
+		if operator != exp.Operator || changed {
+			return &parser.CallExpression{LeftParenToken: exp.LeftParenToken, Operator: operator, Operands: operands}
+		}
+	case *parser.IfExpression:
+		predicate, consequent, alternative := f(exp.Predicate), f(exp.Consequent), exp.Alternative
+		if alternative != nil {
+			alternative = f(alternative)
+		}
+		if predicate != exp.Predicate || consequent != exp.Consequent || alternative != exp.Alternative {
+			return &parser.IfExpression{LeftParenToken: exp.LeftParenToken, Predicate: predicate, Consequent: consequent, Alternative: alternative}
+		}
+	case *parser.LambdaExpression:
+		if body, changed := mapExpressions(exp.Body, f); changed {
+			lambda := *exp
+			lambda.Body = body
+			return &lambda
+		}
+	case *parser.DefineExpression:
+		if value := f(exp.Value); value != exp.Value {
+			return &parser.DefineExpression{LeftParenToken: exp.LeftParenToken, Name: exp.Name, Value: value}
+		}
+	case *parser.SetExpression:
+		if value := f(exp.Value); value != exp.Value {
+			return &parser.SetExpression{LeftParenToken: exp.LeftParenToken, Name: exp.Name, Value: value}
+		}
+	case *parser.BeginExpression:
+		if expressions, changed := mapExpressions(exp.Expressions, f); changed {
+			return &parser.BeginExpression{LeftParenToken: exp.LeftParenToken, Expressions: expressions}
+		}
+	case *parser.DelayExpression:
+		if delayed := f(exp.Expression); delayed != exp.Expression {
+			return &parser.DelayExpression{DelayToken: exp.DelayToken, Expression: delayed, Force: exp.Force}
+		}
+	case *parser.StreamExpression:
+		car, cdr := f(exp.CarExpression), f(exp.CdrExpression)
+		if car != exp.CarExpression || cdr != exp.CdrExpression {
+			return &parser.StreamExpression{ConsStreamToken: exp.ConsStreamToken, CarExpression: car, CdrExpression: cdr}
+		}
+	case *parser.AmbExpression:
+		if choices, changed := mapExpressions(exp.Choices, f); changed {
+			return &parser.AmbExpression{AmbToken: exp.AmbToken, Choices: choices}
+		}
+	case *parser.GuardExpression:
+		body, changed := mapExpressions(exp.Body, f)
+		clauses := make([]parser.GuardClause, len(exp.Clauses))
+		for i, clause := range exp.Clauses {
+			clauses[i].Test, clauses[i].Arrow = clause.Test, clause.Arrow
+			if clause.Test != nil {
+				clauses[i].Test = f(clause.Test)
+			}
+			var clauseChanged bool
+			clauses[i].Body, clauseChanged = mapExpressions(clause.Body, f)
+			changed = changed || clauseChanged || clauses[i].Test != clause.Test
+		}
+		if changed {
+			return &parser.GuardExpression{GuardToken: exp.GuardToken, Variable: exp.Variable, Clauses: clauses, Body: body}
+		}
+	case *parser.AssertExpression:
+		if asserted := f(exp.Expression); asserted != exp.Expression {
+			return &parser.AssertExpression{AssertToken: exp.AssertToken, Expression: asserted}
+		}
+	case *parser.ParameterizeExpression:
+		parameters, parametersChanged := mapExpressions(exp.Parameters, f)
+		values, valuesChanged := mapExpressions(exp.Values, f)
+		body, bodyChanged := mapExpressions(exp.Body, f)
+		if parametersChanged || valuesChanged || bodyChanged {
+			return &parser.ParameterizeExpression{ParameterizeToken: exp.ParameterizeToken, Parameters: parameters, Values: values, Body: body}
+		}
+	}
+	return exp
+}
+
+// mapExpressions applies f to each of expressions, it returns a new slice only if f changed one.
+func mapExpressions(expressions []parser.Expression, f func(parser.Expression) parser.Expression) ([]parser.Expression, bool) {
+	var mapped []parser.Expression
+	for i, exp := range expressions {
+		if m := f(exp); m != exp && mapped == nil {
+			mapped = make([]parser.Expression, len(expressions))
+			copy(mapped, expressions[:i])
+			mapped[i] = m
+		} else if mapped != nil {
+			mapped[i] = m
+		}
+	}
+	if mapped == nil {
+		return expressions, false
+	}
+	return mapped, true
+}
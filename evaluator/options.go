@@ -0,0 +1,181 @@
+package evaluator
+
+import (
+	"io"
+	"os"
+)
+
+// defaultRandomSeed makes random repeat the same numbers on every run unless WithRandomSeed is given.
+const defaultRandomSeed = 9527
+
+type options struct {
+	stdin        io.Reader
+	stdout       io.Writer
+	stderr       io.Writer
+	randomSeed   int64
+	capabilities Capability
+	limits       Limits
+	searchPath   []string
+	commandLine  []string
+	tracer       Tracer
+	hook         Hook
+	prelude      bool
+	// unbufferedOutput writes every display straight to stdout
+	unbufferedOutput bool
+	arena            bool
+	optimize         bool
+	shadowing        bool
+	racket           bool
+	mit              bool
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		stdin:        os.Stdin,
+		stdout:       os.Stdout,
+		stderr:       os.Stderr,
+		randomSeed:   defaultRandomSeed,
+		capabilities: AllCapabilities,
+		prelude:      true,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// An Option configures the evaluator New or Fork creates.
+type Option func(*options)
+
+// WithStdin sets what read reads, os.Stdin by default.
+func WithStdin(stdin io.Reader) Option {
+	return func(o *options) {
+		o.stdin = stdin
+	}
+}
+
+// WithStdout sets where display and the other printing builtins write, os.Stdout by default.
+func WithStdout(stdout io.Writer) Option {
+	return func(o *options) {
+		o.stdout = stdout
+	}
+}
+
+// WithUnbufferedOutput makes the printing builtins write to stdout as they're called. By default
+// their output is buffered and flushed by newline, before read and when a run ends, which is what a
+// program printing in a loop wants; a debugger or other interactive use wants to see every display.
+func WithUnbufferedOutput() Option {
+	return func(o *options) {
+		o.unbufferedOutput = true
+	}
+}
+
+// WithAllocationArena allocates the numbers and pairs a run makes in chunks, see arena.go. It cuts
+// the garbage collector's work for batch evaluation that makes many short-lived numbers, at the cost
+// of some memory staying alive longer.
+func WithAllocationArena() Option {
+	return func(o *options) {
+		o.arena = true
+	}
+}
+
+// WithOptimizer rewrites every top-level expression before evaluating it, folding constant
+// arithmetic and inlining simple lets, see optimize.go. A builtin that's folded into the procedures
+// optimized before it's redefined stays folded.
+func WithOptimizer() Option {
+	return func(o *options) {
+		o.optimize = true
+	}
+}
+
+// WithShadowing lets the parameters and let bindings of the files the evaluator loads shadow the
+// builtins that are lexed as keywords, such as not and +, see parser.AllowShadowing.
+func WithShadowing() Option {
+	return func(o *options) {
+		o.shadowing = true
+	}
+}
+
+// WithRacket runs Racket-flavored programs: the files the evaluator loads can use brackets as parens,
+// (require "file.rkt") loads a file once and requiring a library such as racket/list does nothing,
+// and the racket/base procedures in racket.scm, such as add1 and displayln, are defined. See
+// parser.Racket.
+func WithRacket() Option {
+	return func(o *options) {
+		o.racket = true
+	}
+}
+
+// WithMITCompat makes soup's output closer to MIT Scheme's, so SICP transcripts can be diffed
+// against it: 1+ and -1+ are defined, see mit.scm, and error formats its message like MIT does, the
+// message displayed and the irritants written after it, e.g. (error "Bad value:" "x" 'y) raises
+// Bad value: "x" y. true and false are #t and #f in every mode.
+func WithMITCompat() Option {
+	return func(o *options) {
+		o.mit = true
+	}
+}
+
+// WithStderr sets where diagnostics go, os.Stderr by default.
+func WithStderr(stderr io.Writer) Option {
+	return func(o *options) {
+		o.stderr = stderr
+	}
+}
+
+// WithRandomSeed seeds the generator behind random.
+func WithRandomSeed(seed int64) Option {
+	return func(o *options) {
+		o.randomSeed = seed
+	}
+}
+
+// WithSandbox only grants capabilities to the builtins, see SetCapabilities.
+func WithSandbox(capabilities Capability) Option {
+	return func(o *options) {
+		o.capabilities = capabilities
+	}
+}
+
+// WithLimits bounds every run, see SetLimits.
+func WithLimits(limits Limits) Option {
+	return func(o *options) {
+		o.limits = limits
+	}
+}
+
+// WithSearchPath sets where load and import look for files, see SetSearchPath.
+func WithSearchPath(dirs []string) Option {
+	return func(o *options) {
+		o.searchPath = append([]string{}, dirs...)
+	}
+}
+
+// WithCommandLine sets the list command-line returns, see SetCommandLine.
+func WithCommandLine(args []string) Option {
+	return func(o *options) {
+		o.commandLine = append([]string{}, args...)
+	}
+}
+
+// WithTracer reports every procedure call to tracer, see SetTracer.
+func WithTracer(tracer Tracer) Option {
+	return func(o *options) {
+		o.tracer = tracer
+	}
+}
+
+// WithHook calls hook around every expression, see SetHook.
+func WithHook(hook Hook) Option {
+	return func(o *options) {
+		o.hook = hook
+	}
+}
+
+// WithoutPrelude leaves out the procedures defined in prelude.scm, the evaluator only has the
+// builtins implemented in Go.
+func WithoutPrelude() Option {
+	return func(o *options) {
+		o.prelude = false
+	}
+}
@@ -0,0 +1,136 @@
+package evaluator
+
+import (
+	"github.com/ocowchun/soup/parser"
+)
+
+// A parameter object, made by make-parameter, is a procedure without arguments returning the
+// parameter's value. parameterize binds parameters to other values while its body is evaluated,
+// including in the procedures the body calls, and restores them once it returns or raises.
+//
+//	(define indent (make-parameter 0))
+//	(define (show) (indent))
+//	(parameterize ((indent 2)) (show)) ; 2
+//	(show)                              ; 0
+
+type parameter struct {
+	// value is the value outside of any parameterize
+	value *ReturnValue
+	// converter, if any, is applied to the initial value and to the values of parameterize
+	converter *ReturnValue
+}
+
+// parameterization is the binding of a parameter by parameterize.
+type parameterization struct {
+	parameter *parameter
+	value     *ReturnValue
+}
+
+// parameterValue returns the value p is bound to by the innermost parameterize, its value
+// otherwise.
+func (e *Evaluator) parameterValue(p *parameter) *ReturnValue {
+	for _, parameterizations := range [][]parameterization{e.parameterizations, e.parameterBase} {
+		for i := len(parameterizations) - 1; i >= 0; i-- {
+			if parameterizations[i].parameter == p {
+				return parameterizations[i].value
+			}
+		}
+	}
+	return p.value
+}
+
+func (e *Evaluator) convertParameterValue(p *parameter, val *ReturnValue, environment *Environment) (*ReturnValue, error) {
+	if p.converter == nil {
+		return val, nil
+	}
+	return e.applyProcedure(p.converter, []*ReturnValue{val}, environment)
+}
+
+func (e *Evaluator) evalParameterizeExpression(exp *parser.ParameterizeExpression, environment *Environment) (*ReturnValue, error) {
+	bindings := make([]parameterization, len(exp.Parameters))
+	for i, parameterExp := range exp.Parameters {
+		val, err := e.eval(parameterExp, environment)
+		if err != nil {
+			return nil, runtimeErrorAt(err, parameterExp.Token(), environment)
+		}
+		p := parameterOf(val)
+		if p == nil {
+			return nil, runtimeErrorAt(conditionErrorf(WrongTypeCondition, "'parameterize' expected a parameter, got %s", val.Type), parameterExp.Token(), environment)
+		}
+		val, err = e.eval(exp.Values[i], environment)
+		if err != nil {
+			return nil, runtimeErrorAt(err, exp.Values[i].Token(), environment)
+		}
+		if val, err = e.convertParameterValue(p, val, environment); err != nil {
+			return nil, runtimeErrorAt(err, exp.Values[i].Token(), environment)
+		}
+		bindings[i] = parameterization{parameter: p, value: val}
+	}
+
+	installed := e.parameterizations
+	e.parameterizations = append(installed[:len(installed):len(installed)], bindings...)
+	defer func() {
+		e.parameterizations = installed
+	}()
+	var val *ReturnValue
+	var err error
+	for _, subExp := range exp.Body {
+		if val, err = e.eval(subExp, environment); err != nil {
+			return nil, runtimeErrorAt(err, subExp.Token(), environment)
+		}
+	}
+	return val, nil
+}
+
+// parameterOf returns the parameter val is the procedure of, nil if it's not a parameter object.
+func parameterOf(val *ReturnValue) *parameter {
+	if val.Type != BuiltinFunctionType {
+		return nil
+	}
+	return val.BuiltinFunction().parameter
+}
+
+func addParameterBuiltins(env *Environment) {
+	// (make-parameter value [converter])
+	addBuiltinToEnv(env, "make-parameter", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 && len(parameters) != 2 {
+				return nil, conditionErrorf(WrongArityCondition, "'make-parameter' has been called with %d arguments; it requires 1 or 2 arguments", len(parameters))
+			}
+			p := &parameter{}
+			if len(parameters) == 2 {
+				converter := parameters[1]
+				if converter.Type != ProcedureType && converter.Type != BuiltinFunctionType {
+					return nil, conditionErrorf(WrongTypeCondition, "'make-parameter' expected a procedure as converter, got %s", converter.Type)
+				}
+				p.converter = converter
+			}
+			val, err := evaluator.convertParameterValue(p, parameters[0], environment)
+			if err != nil {
+				return nil, err
+			}
+			p.value = val
+
+			return &ReturnValue{Type: BuiltinFunctionType, Data: &BuiltinFunction{
+				Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+					if len(parameters) != 0 {
+						return nil, conditionErrorf(WrongArityCondition, "a parameter has been called with %d arguments; it requires exactly 0 arguments", len(parameters))
+					}
+
+					return evaluator.parameterValue(p), nil
+				},
+				parameter: p,
+			}}, nil
+		},
+	})
+
+	addBuiltinToEnv(env, "parameter?", &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			if len(parameters) != 1 {
+				return nil, conditionErrorf(WrongArityCondition, "'parameter?' has been called with %d arguments; it requires exactly 1 argument", len(parameters))
+			}
+
+			return boolValue(parameterOf(parameters[0]) != nil), nil
+		},
+	})
+}
@@ -0,0 +1,75 @@
+package evaluator
+
+import (
+	_ "embed"
+	"strings"
+	"sync"
+
+	"github.com/ocowchun/soup/lexer"
+	"github.com/ocowchun/soup/parser"
+)
+
+//go:embed prelude.scm
+var preludeSource string
+
+var (
+	preludeOnce    sync.Once
+	preludeProgram *parser.Program
+)
+
+// parsedPrelude parses prelude.scm once, the program is shared by every evaluator since eval never mutates it.
+func parsedPrelude() *parser.Program {
+	preludeOnce.Do(func() {
+		p := parser.New(lexer.New(strings.NewReader(preludeSource)))
+		program, err := p.Parse()
+		if err != nil {
+			panic("invalid prelude: " + err.Error())
+		}
+		preludeProgram = program
+	})
+	return preludeProgram
+}
+
+func (e *Evaluator) loadPrelude() {
+	for _, exp := range parsedPrelude().Expressions {
+		if _, err := e.eval(exp, e.baseEnv); err != nil {
+			panic("failed to evaluate prelude: " + err.Error())
+		}
+	}
+}
+
+// embeddedProgram is a Scheme source an option evaluates into the base environment, after the
+// prelude. It's parsed once and shared by every evaluator like the prelude.
+type embeddedProgram struct {
+	name    string
+	source  string
+	once    sync.Once
+	program *parser.Program
+}
+
+//go:embed racket.scm
+var racketSource string
+
+//go:embed mit.scm
+var mitSource string
+
+var (
+	racketProgram = &embeddedProgram{name: "racket.scm", source: racketSource}
+	mitProgram    = &embeddedProgram{name: "mit.scm", source: mitSource}
+)
+
+// loadEmbedded evaluates p in the base environment, see WithRacket and WithMITCompat.
+func (e *Evaluator) loadEmbedded(p *embeddedProgram) {
+	p.once.Do(func() {
+		program, err := parser.New(lexer.New(strings.NewReader(p.source))).Parse()
+		if err != nil {
+			panic("invalid " + p.name + ": " + err.Error())
+		}
+		p.program = program
+	})
+	for _, exp := range p.program.Expressions {
+		if _, err := e.eval(exp, e.baseEnv); err != nil {
+			panic("failed to evaluate " + p.name + ": " + err.Error())
+		}
+	}
+}
@@ -0,0 +1,190 @@
+;; prelude.scm is evaluated by evaluator.New before any user program runs.
+;; Procedures that can be derived from the builtins live here instead of in Go.
+
+(define nil '())
+
+(define (square x) (* x x))
+
+(define (filter predicate sequence)
+  (define (iter kept rest)
+    (cond ((null? rest) (reverse kept))
+          ((predicate (car rest)) (iter (cons (car rest) kept) (cdr rest)))
+          (else (iter kept (cdr rest)))))
+  (iter nil sequence))
+
+(define (fold-left op initial sequence)
+  (define (iter result rest)
+    (if (null? rest)
+        result
+        (iter (op result (car rest)) (cdr rest))))
+  (iter initial sequence))
+
+(define (fold-right op initial sequence)
+  (fold-left (lambda (acc item) (op item acc)) initial (reverse sequence)))
+
+;; SRFI-1 style fold, the accumulated value is the last argument of op
+(define (fold op initial sequence)
+  (fold-left (lambda (acc item) (op item acc)) initial sequence))
+
+(define accumulate fold-right)
+
+(define (reduce op initial sequence)
+  (if (null? sequence)
+      initial
+      (fold-left op (car sequence) (cdr sequence))))
+
+(define (accumulate-n op initial seqs)
+  (if (null? (car seqs))
+      nil
+      (cons (accumulate op initial (map car seqs))
+            (accumulate-n op initial (map cdr seqs)))))
+
+(define (reverse sequence)
+  (fold-left (lambda (acc item) (cons item acc)) nil sequence))
+
+(define (for-each proc . lists)
+  (define (iter lists)
+    (if (null? (car lists))
+        'done
+        (begin
+          (apply proc (map car lists))
+          (iter (map cdr lists)))))
+  (iter lists))
+
+(define (list-tail items k)
+  (if (= k 0)
+      items
+      (list-tail (cdr items) (- k 1))))
+
+(define (list-ref items n)
+  (car (list-tail items n)))
+
+(define (last-pair items)
+  (if (null? (cdr items))
+      items
+      (last-pair (cdr items))))
+
+(define (enumerate-interval low high)
+  (define (iter high interval)
+    (if (< high low)
+        interval
+        (iter (- high 1) (cons high interval))))
+  (iter high nil))
+
+(define (flatmap proc seq)
+  (accumulate append nil (map proc seq)))
+
+;; The picture language, https://sarabander.github.io/sicp/html/2_002e2.xhtml#g_t2_002e2_002e4
+;; draw-line and paint are builtins, paint applies a painter to the unit frame and writes an SVG or PNG.
+
+(define (make-vect x y) (cons x y))
+(define (xcor-vect v) (car v))
+(define (ycor-vect v) (cdr v))
+(define (add-vect a b)
+  (make-vect (+ (xcor-vect a) (xcor-vect b)) (+ (ycor-vect a) (ycor-vect b))))
+(define (sub-vect a b)
+  (make-vect (- (xcor-vect a) (xcor-vect b)) (- (ycor-vect a) (ycor-vect b))))
+(define (scale-vect s v)
+  (make-vect (* s (xcor-vect v)) (* s (ycor-vect v))))
+
+(define (make-frame origin edge1 edge2) (list origin edge1 edge2))
+(define (origin-frame frame) (car frame))
+(define (edge1-frame frame) (car (cdr frame)))
+(define (edge2-frame frame) (car (cdr (cdr frame))))
+
+(define (make-segment start end) (cons start end))
+(define (start-segment segment) (car segment))
+(define (end-segment segment) (cdr segment))
+
+(define (frame-coord-map frame)
+  (lambda (v)
+    (add-vect (origin-frame frame)
+              (add-vect (scale-vect (xcor-vect v) (edge1-frame frame))
+                        (scale-vect (ycor-vect v) (edge2-frame frame))))))
+
+(define (segments->painter segment-list)
+  (lambda (frame)
+    (for-each
+     (lambda (segment)
+       (draw-line ((frame-coord-map frame) (start-segment segment))
+                  ((frame-coord-map frame) (end-segment segment))))
+     segment-list)))
+
+(define (transform-painter painter origin corner1 corner2)
+  (lambda (frame)
+    (let ((m (frame-coord-map frame)))
+      (let ((new-origin (m origin)))
+        (painter (make-frame new-origin
+                             (sub-vect (m corner1) new-origin)
+                             (sub-vect (m corner2) new-origin)))))))
+
+(define (identity x) x)
+(define (flip-vert painter)
+  (transform-painter painter (make-vect 0 1) (make-vect 1 1) (make-vect 0 0)))
+(define (flip-horiz painter)
+  (transform-painter painter (make-vect 1 0) (make-vect 0 0) (make-vect 1 1)))
+(define (rotate90 painter)
+  (transform-painter painter (make-vect 1 0) (make-vect 1 1) (make-vect 0 0)))
+(define (rotate180 painter)
+  (transform-painter painter (make-vect 1 1) (make-vect 0 1) (make-vect 1 0)))
+(define (rotate270 painter)
+  (transform-painter painter (make-vect 0 1) (make-vect 0 0) (make-vect 1 1)))
+
+(define (beside painter1 painter2)
+  (let ((split-point (make-vect 0.5 0)))
+    (let ((paint-left (transform-painter painter1 (make-vect 0 0) split-point (make-vect 0 1)))
+          (paint-right (transform-painter painter2 split-point (make-vect 1 0) (make-vect 0.5 1))))
+      (lambda (frame)
+        (paint-left frame)
+        (paint-right frame)))))
+
+(define (below painter1 painter2)
+  (let ((split-point (make-vect 0 0.5)))
+    (let ((paint-bottom (transform-painter painter1 (make-vect 0 0) (make-vect 1 0) split-point))
+          (paint-top (transform-painter painter2 split-point (make-vect 1 0.5) (make-vect 0 1))))
+      (lambda (frame)
+        (paint-bottom frame)
+        (paint-top frame)))))
+
+(define (right-split painter n)
+  (if (= n 0)
+      painter
+      (let ((smaller (right-split painter (- n 1))))
+        (beside painter (below smaller smaller)))))
+
+(define (up-split painter n)
+  (if (= n 0)
+      painter
+      (let ((smaller (up-split painter (- n 1))))
+        (below painter (beside smaller smaller)))))
+
+(define (corner-split painter n)
+  (if (= n 0)
+      painter
+      (let ((up (up-split painter (- n 1)))
+            (right (right-split painter (- n 1))))
+        (let ((top-left (beside up up))
+              (bottom-right (below right right))
+              (corner (corner-split painter (- n 1))))
+          (beside (below painter top-left)
+                  (below bottom-right corner))))))
+
+(define (square-of-four tl tr bl br)
+  (lambda (painter)
+    (let ((top (beside (tl painter) (tr painter)))
+          (bottom (beside (bl painter) (br painter))))
+      (below bottom top))))
+
+(define (square-limit painter n)
+  (let ((combine4 (square-of-four flip-horiz identity rotate180 flip-vert)))
+    (combine4 (corner-split painter n))))
+
+;; Generic operations, https://sarabander.github.io/sicp/html/2_002e4.xhtml#g_t2_002e4_002e3
+;; put, get, attach-tag, type-tag and contents are builtins, plain numbers are tagged scheme-number.
+
+(define (apply-generic op . args)
+  (let ((type-tags (map type-tag args)))
+    (let ((proc (get op type-tags)))
+      (if proc
+          (apply proc (map contents args))
+          (error "No method for these types -- APPLY-GENERIC" (list op type-tags))))))
@@ -0,0 +1,239 @@
+package evaluator
+
+import "strings"
+
+const defaultPrettyWidth = 80
+
+// elision replaces the elements and nested values left out by PrintLimits.
+const elision = "..."
+
+// PrintLimits bounds how much of a value is printed, a zero field means no limit.
+type PrintLimits struct {
+	// MaxDepth is how deeply lists, vectors and pairs nest before a nested one is shown as ...
+	MaxDepth int
+	// MaxLength is how many elements of a list or vector are shown before the rest are shown as ...
+	MaxLength int
+}
+
+// PrettyString renders rv with write semantics, breaking lists and vectors that don't fit in width
+// across lines. A list headed by a symbol keeps its first argument on the head's line
+// and aligns the remaining arguments under it, except that the body of a special form like define
+// or let is indented by 2 from its opening paren, e.g.
+//
+//	(define (f x)
+//	  (if (> x 0)
+//	      x
+//	      (- x)))
+func (rv *ReturnValue) PrettyString(width int) string {
+	return rv.LimitedPrettyString(width, PrintLimits{})
+}
+
+// LimitedPrettyString is PrettyString with the parts of rv beyond limits elided, e.g. (1 2 3 ...).
+func (rv *ReturnValue) LimitedPrettyString(width int, limits PrintLimits) string {
+	p := &prettyPrinter{width: width, limits: limits}
+	p.write(rv, 0, 1)
+	return p.b.String()
+}
+
+// bodyForms are the special forms whose body is indented by 2, with how many arguments come before
+// the body, kept on the head's line while they fit and aligned under the first otherwise.
+var bodyForms = map[string]int{
+	"define":        1,
+	"define-syntax": 1,
+	"lambda":        1,
+	"named-lambda":  1,
+	"let":           1,
+	"let*":          1,
+	"letrec":        1,
+	"letrec*":       1,
+	"let-values":    1,
+	"let*-values":   1,
+	"fluid-let":     1,
+	"parameterize":  1,
+	"when":          1,
+	"unless":        1,
+	"case":          1,
+	"guard":         1,
+	"syntax-rules":  1,
+	"do":            2,
+}
+
+// bodyStart returns where the body of the list elements, headed by the symbol head, starts when it's
+// a special form with one, 0 otherwise.
+func bodyStart(head string, elements []*ReturnValue) int {
+	n, ok := bodyForms[head]
+	if !ok {
+		return 0
+	}
+	if head == "let" && len(elements) > 1 && elements[1].Type == SymbolType {
+		// a named let
+		n++
+	}
+	if len(elements) <= n+1 {
+		return 0
+	}
+	return n + 1
+}
+
+type prettyPrinter struct {
+	b      strings.Builder
+	width  int
+	limits PrintLimits
+}
+
+func (p *prettyPrinter) tooDeep(depth int) bool {
+	return p.limits.MaxDepth > 0 && depth > p.limits.MaxDepth
+}
+
+// shown returns the elements of a list that fit in the length limit, and whether any were left out.
+func (p *prettyPrinter) shown(elements []*ReturnValue) ([]*ReturnValue, bool) {
+	if p.limits.MaxLength > 0 && len(elements) > p.limits.MaxLength {
+		return elements[:p.limits.MaxLength], true
+	}
+	return elements, false
+}
+
+// shownList is shown for the list of pairs rv, which it walks no further than the length limit so
+// a circular list is cut short too. It returns the tail as listParts does, EmptyList when elements
+// were left out.
+func (p *prettyPrinter) shownList(rv *ReturnValue) ([]*ReturnValue, *ReturnValue, bool) {
+	if p.limits.MaxLength == 0 {
+		elements, tail := listParts(rv)
+		return elements, tail, false
+	}
+	var elements []*ReturnValue
+	for rv.Type == ConsType && len(elements) < p.limits.MaxLength {
+		elements = append(elements, rv.Cons().Car)
+		rv = rv.Cons().Cdr
+	}
+	if rv.Type == ConsType {
+		return elements, EmptyList, true
+	}
+	return elements, rv, false
+}
+
+// flat is Write with the limits applied, depth is the nesting depth of rv.
+func (p *prettyPrinter) flat(rv *ReturnValue, depth int) string {
+	switch rv.Type {
+	case ListType, ConsType, VectorType:
+		if p.tooDeep(depth) {
+			return elision
+		}
+	default:
+		return rv.Write()
+	}
+
+	if rv.Type == ListType {
+		return "()"
+	}
+
+	var b strings.Builder
+	var elements []*ReturnValue
+	var elided bool
+	tail := EmptyList
+	if rv.Type == VectorType {
+		b.WriteString("#")
+		elements, elided = p.shown(rv.Vector().Elements)
+	} else {
+		elements, tail, elided = p.shownList(rv)
+	}
+	b.WriteString("(")
+	for i, elem := range elements {
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		b.WriteString(p.flat(elem, depth+1))
+	}
+	if elided {
+		b.WriteString(" " + elision)
+	}
+	writeListTail(&b, tail, func(val *ReturnValue) string {
+		return p.flat(val, depth+1)
+	})
+	b.WriteString(")")
+	return b.String()
+}
+
+func (p *prettyPrinter) write(rv *ReturnValue, indent int, depth int) {
+	flat := p.flat(rv, depth)
+	if indent+len(flat) <= p.width || p.tooDeep(depth) {
+		p.b.WriteString(flat)
+		return
+	}
+
+	switch rv.Type {
+	case ConsType:
+		elements, tail, elided := p.shownList(rv)
+		p.b.WriteString("(")
+		childIndent := indent + 1
+		rest := elements[1:]
+		var body []*ReturnValue
+		if elements[0].Type == SymbolType && len(elements) > 1 {
+			head := elements[0].Symbol()
+			if start := bodyStart(head, elements); start > 0 {
+				rest, body = elements[2:start], elements[start:]
+			} else {
+				rest = elements[2:]
+			}
+			p.b.WriteString(head)
+			p.b.WriteString(" ")
+			childIndent += len(head) + 1
+			p.write(elements[1], childIndent, depth+1)
+		} else {
+			p.write(elements[0], childIndent, depth+1)
+		}
+		for _, elem := range rest {
+			// the arguments before a body stay on the head's line while they fit
+			if flat := p.flat(elem, depth+1); len(body) > 0 && p.column()+1+len(flat) <= p.width {
+				p.b.WriteString(" " + flat)
+				continue
+			}
+			p.newline(childIndent)
+			p.write(elem, childIndent, depth+1)
+		}
+		if len(body) > 0 {
+			childIndent = indent + 2
+		}
+		for _, elem := range body {
+			p.newline(childIndent)
+			p.write(elem, childIndent, depth+1)
+		}
+		switch {
+		case elided || tail == nil:
+			p.newline(childIndent)
+			p.b.WriteString(elision)
+		case tail.Type != ListType:
+			p.newline(childIndent)
+			p.b.WriteString(". ")
+			p.write(tail, childIndent+2, depth+1)
+		}
+		p.b.WriteString(")")
+	case VectorType:
+		elements, elided := p.shown(rv.Vector().Elements)
+		p.b.WriteString("#(")
+		for i, elem := range elements {
+			if i > 0 {
+				p.newline(indent + 2)
+			}
+			p.write(elem, indent+2, depth+1)
+		}
+		if elided {
+			p.newline(indent + 2)
+			p.b.WriteString(elision)
+		}
+		p.b.WriteString(")")
+	default:
+		p.b.WriteString(flat)
+	}
+}
+
+// column is where the next character written goes on its line.
+func (p *prettyPrinter) column() int {
+	s := p.b.String()
+	return len(s) - strings.LastIndexByte(s, '\n') - 1
+}
+
+func (p *prettyPrinter) newline(indent int) {
+	p.b.WriteString("\n")
+	p.b.WriteString(strings.Repeat(" ", indent))
+}
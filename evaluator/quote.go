@@ -0,0 +1,113 @@
+package evaluator
+
+import (
+	"math/big"
+
+	"github.com/ocowchun/soup/parser"
+)
+
+// A quoted literal is built the first time it's evaluated and the same value is returned every time
+// after that, so '(a b c) in a loop allocates once rather than on every iteration. The value is
+// cached on the expression, see parser.LiteralCache, so it goes away with the program rather than
+// staying with the evaluator. It's shared by every evaluation of the quote, set-car! and set-cdr!
+// refuse to modify it, as the standard allows: the pairs it's made of are marked literal. Vector
+// literals, quoted or not, are built the same way and vector-set! refuses them.
+//
+// String literals are cached the same way, so "" or " " in a loop doesn't allocate. They can't be
+// modified either, string-set! and string-fill! refuse them.
+
+// literalCache returns the cache of the literal exp.
+func literalCache(exp parser.Expression) *parser.LiteralCache {
+	switch exp := exp.(type) {
+	case *parser.StringLiteral:
+		return &exp.Cache
+	case *parser.SymbolExpression:
+		return &exp.Cache
+	case *parser.ListExpression:
+		return &exp.Cache
+	case *parser.VectorExpression:
+		return &exp.Cache
+	case *parser.NestedSymbolExpression:
+		return &exp.Cache
+	}
+	panic("unexpected literal expression")
+}
+
+// evalQuotedExpression returns the value of the literal exp, building it the first time.
+func (e *Evaluator) evalQuotedExpression(exp parser.Expression) (*ReturnValue, error) {
+	cache := literalCache(exp)
+	if val, ok := cache.Load().(*ReturnValue); ok {
+		return val, nil
+	}
+	val := quotedDatum(exp)
+	cells := valueCells(val)
+	if val.Type == ConsType {
+		elements, _ := listParts(val)
+		cells = len(elements)
+	}
+	if err := e.allocate(cells); err != nil {
+		return nil, err
+	}
+	return cache.LoadOrStore(val).(*ReturnValue), nil
+}
+
+// quotedDatum builds the value of the quoted expression exp.
+func quotedDatum(exp parser.Expression) *ReturnValue {
+	switch exp := exp.(type) {
+	case *parser.SymbolExpression:
+		return &ReturnValue{Type: SymbolType, Data: exp.Value}
+	case *parser.StringLiteral:
+		return &ReturnValue{Type: StringType, Data: literalString(exp.Value)}
+	case *parser.CharLiteral:
+		return &ReturnValue{Type: CharType, Data: exp.Value}
+	case *parser.DatumExpression:
+		return exp.Value.(*ReturnValue)
+	case *parser.NumberLiteral:
+		switch v := exp.Value.(type) {
+		case int64:
+			return &ReturnValue{Type: NumberType, Data: MakeInt64Number(v)}
+		case float64:
+			return &ReturnValue{Type: NumberType, Data: MakeFloat64Number(v)}
+		case *big.Int:
+			return &ReturnValue{Type: NumberType, Data: makeIntegerNumber(v)}
+		case *big.Rat:
+			return &ReturnValue{Type: NumberType, Data: makeRationalNumber(v)}
+		}
+		// a literal built without the parser
+		n, _ := MakeNumber(exp.NumToken.Content)
+		return n
+	case *parser.ListExpression:
+		elements := make([]*ReturnValue, len(exp.Elements))
+		for i, element := range exp.Elements {
+			elements[i] = quotedDatum(element)
+		}
+		list := EmptyList
+		for i := len(elements) - 1; i >= 0; i-- {
+			list = &ReturnValue{Type: ConsType, Data: &ConsValue{Car: elements[i], Cdr: list, literal: true}}
+		}
+		return list
+	case *parser.VectorExpression:
+		elements := make([]*ReturnValue, len(exp.Elements))
+		for i, element := range exp.Elements {
+			elements[i] = quotedDatum(element)
+		}
+		return &ReturnValue{Type: VectorType, Data: &VectorValue{Elements: elements, literal: true}}
+	case *parser.NestedSymbolExpression:
+		// ''a is (quote a)
+		cdr := &ReturnValue{Type: ConsType, Data: &ConsValue{Car: quotedDatum(exp.Value), Cdr: EmptyList, literal: true}}
+		quote := &ReturnValue{Type: SymbolType, Data: "quote"}
+		return &ReturnValue{Type: ConsType, Data: &ConsValue{Car: quote, Cdr: cdr, literal: true}}
+	}
+	panic("unexpected quoted expression")
+}
+
+// isQuotedLiteral reports whether val is a pair of a quoted literal, or a vector literal.
+func isQuotedLiteral(val *ReturnValue) bool {
+	switch val.Type {
+	case ConsType:
+		return val.Cons().literal
+	case VectorType:
+		return val.Vector().literal
+	}
+	return false
+}
@@ -0,0 +1,20 @@
+;; racket.scm is evaluated by evaluator.New after prelude.scm when WithRacket is given.
+;; It defines the racket/base and racket/list procedures SICP materials written for Racket use.
+
+(define null '())
+
+(define empty '())
+
+(define (empty? x) (null? x))
+
+(define (first lst) (car lst))
+
+(define (rest lst) (cdr lst))
+
+(define (add1 n) (+ n 1))
+
+(define (sub1 n) (- n 1))
+
+(define (displayln x)
+  (display x)
+  (newline))
@@ -0,0 +1,133 @@
+package evaluator
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// Register defines name as a builtin calling fn, a Go function whose parameters and results are
+// converted from and to soup values:
+//
+//	e.Register("shout", func(s string) string { return strings.ToUpper(s) })
+//
+// Parameters and results can be bools, strings, integers, floats, slices and maps of those, structs
+// and pointers to them, see RegisterType, *ReturnValue to get a value as is, or any for what ToGo
+// converts the value to, results are converted like FromGo does. fn may be variadic, and it returns
+// nothing, a value, an error, or a value and an error. Calls with the wrong number or types of
+// arguments raise wrong-arity and wrong-type conditions, an error returned by fn is raised as a
+// generic condition unless it already is one.
+func (e *Evaluator) Register(name string, fn any) error {
+	builtin, err := newGoBuiltin(name, reflect.ValueOf(fn))
+	if err != nil {
+		return err
+	}
+	addBuiltinToEnv(e.baseEnv, name, builtin)
+	return nil
+}
+
+func newGoBuiltin(name string, fn reflect.Value) (*BuiltinFunction, error) {
+	if fn.Kind() != reflect.Func || fn.IsNil() {
+		return nil, fmt.Errorf("register %s: expected a function, got %v", name, fn.Kind())
+	}
+	fnType := fn.Type()
+	if err := checkGoFunction(fnType); err != nil {
+		return nil, fmt.Errorf("register %s: %w", name, err)
+	}
+
+	return &BuiltinFunction{
+		Fn: func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error) {
+			return callGo(name, fn, parameters)
+		},
+	}, nil
+}
+
+// checkGoFunction reports why soup can't call functions of fnType, nil if it can.
+func checkGoFunction(fnType reflect.Type) error {
+	for i := 0; i < fnType.NumIn(); i++ {
+		in := fnType.In(i)
+		if fnType.IsVariadic() && i == fnType.NumIn()-1 {
+			in = in.Elem()
+		}
+		if !convertible(in) {
+			return fmt.Errorf("unsupported parameter type %v", fnType.In(i))
+		}
+	}
+	switch {
+	case fnType.NumOut() > 2:
+		return fmt.Errorf("expected at most 2 results, got %d", fnType.NumOut())
+	case fnType.NumOut() == 2 && fnType.Out(1) != errorType:
+		return fmt.Errorf("the second result must be an error, got %v", fnType.Out(1))
+	case fnType.NumOut() >= 1 && fnType.Out(0) != errorType && !convertible(fnType.Out(0)):
+		return fmt.Errorf("unsupported result type %v", fnType.Out(0))
+	}
+	return nil
+}
+
+// callGo calls fn with parameters converted to Go values, name is what errors call it.
+func callGo(name string, fn reflect.Value, parameters []*ReturnValue) (*ReturnValue, error) {
+	args, err := goArguments(name, fn.Type(), parameters)
+	if err != nil {
+		return nil, err
+	}
+	return goResults(name, fn.Call(args))
+}
+
+func goArguments(name string, fnType reflect.Type, parameters []*ReturnValue) ([]reflect.Value, error) {
+	required := fnType.NumIn()
+	if fnType.IsVariadic() {
+		required--
+		if len(parameters) < required {
+			return nil, conditionErrorf(WrongArityCondition, "'%s' has been called with %d arguments; it requires at least %d %s", name, len(parameters), required, pluralArguments(required))
+		}
+	} else if len(parameters) != required {
+		return nil, conditionErrorf(WrongArityCondition, "'%s' has been called with %d arguments; it requires exactly %d %s", name, len(parameters), required, pluralArguments(required))
+	}
+
+	args := make([]reflect.Value, len(parameters))
+	for i, parameter := range parameters {
+		var t reflect.Type
+		if i < required {
+			t = fnType.In(i)
+		} else {
+			t = fnType.In(required).Elem()
+		}
+		arg, err := toGoValue(parameter, t)
+		if err != nil {
+			return nil, conditionErrorf(WrongTypeCondition, "'%s' expected %s for argument %d, got %s", name, describeGoType(t), i+1, err)
+		}
+		args[i] = arg
+	}
+	return args, nil
+}
+
+func pluralArguments(n int) string {
+	if n == 1 {
+		return "argument"
+	}
+	return "arguments"
+}
+
+func goResults(name string, results []reflect.Value) (*ReturnValue, error) {
+	if len(results) > 0 && results[len(results)-1].Type() == errorType {
+		if err, _ := results[len(results)-1].Interface().(error); err != nil {
+			var condition *Condition
+			if errors.As(err, &condition) {
+				return nil, err
+			}
+			return nil, conditionErrorf(GenericCondition, "'%s' failed: %s", name, err)
+		}
+		results = results[:len(results)-1]
+	}
+	if len(results) == 0 {
+		return Void, nil
+	}
+
+	ret, err := fromGoValue(results[0])
+	if err != nil {
+		return nil, conditionErrorf(WrongTypeCondition, "'%s' returned a value soup can't represent: %s", name, err)
+	}
+	return ret, nil
+}
@@ -2,8 +2,10 @@ package evaluator
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
+	"github.com/ocowchun/soup/lexer"
 	"github.com/ocowchun/soup/parser"
 )
 
@@ -19,6 +21,14 @@ const (
 	ListType
 	ConsType
 	PromiseType
+	ConditionType
+	GeneratorType
+	GoObjectType
+	EnvironmentType
+	VectorType
+	HashTableType
+	CharType
+	BytevectorType
 )
 
 func (t ValueType) String() string {
@@ -41,6 +51,22 @@ func (t ValueType) String() string {
 		return "Cons"
 	case PromiseType:
 		return "Promise"
+	case ConditionType:
+		return "Condition"
+	case GeneratorType:
+		return "Generator"
+	case GoObjectType:
+		return "GoObject"
+	case EnvironmentType:
+		return "Environment"
+	case VectorType:
+		return "Vector"
+	case HashTableType:
+		return "HashTable"
+	case CharType:
+		return "Char"
+	case BytevectorType:
+		return "Bytevector"
 	default:
 		return "Unknown"
 	}
@@ -51,6 +77,31 @@ type ReturnValue struct {
 	Data any
 }
 
+// literalString is the Data of a string literal, string-set! and string-fill! refuse it.
+type literalString string
+
+// mutableString is the Data of the other strings. Copies of the ReturnValue, such as the value an
+// inner define stores in its placeholder, share the pointer, so string-set! and string-fill! replace
+// the text of every copy. A Go string Data, from code building values outside this package, is a
+// mutable string too, but each copy of it has its own text.
+type mutableString struct {
+	text string
+}
+
+// newString returns a new mutable string with the text s.
+func newString(s string) *ReturnValue {
+	return &ReturnValue{Type: StringType, Data: &mutableString{text: s}}
+}
+
+// stringObject returns what makes the string rv itself for eq? and eqv?, its text for a mutable
+// string and rv for the others.
+func stringObject(rv *ReturnValue) any {
+	if str, ok := rv.Data.(*mutableString); ok {
+		return str
+	}
+	return rv
+}
+
 func (rv *ReturnValue) String() string {
 	return rv.Display(0)
 }
@@ -58,13 +109,16 @@ func (rv *ReturnValue) String() string {
 func (rv *ReturnValue) Display(depth int) string {
 	switch rv.Type {
 	case NumberType:
-		if c, ok := rv.Data.(Number); ok {
+		switch c := rv.Data.(type) {
+		case Number:
 			return c.String()
-		} else {
+		case *Number:
+			return c.String()
+		default:
 			return "<invalid number>"
 		}
 	case StringType:
-		return fmt.Sprintf("\"%s\"", rv.Data)
+		return lexer.QuoteString(rv.StringValue())
 	case ConstantType:
 		if c, ok := rv.Data.(ConstantValue); ok {
 			return c.String()
@@ -85,65 +139,143 @@ func (rv *ReturnValue) Display(depth int) string {
 		}
 		return "<invalid symbol>"
 	case ListType:
-		l, ok := rv.Data.(*ListValue)
-		if !ok {
-			return "<invalid list!>"
+		if depth == 0 {
+			return "'()"
+		}
+		return "()"
+	case ConsType:
+		elements, tail := listParts(rv)
+		if tail != nil && tail.Type == ListType && len(elements) == 2 && elements[0].Type == SymbolType && elements[0].Symbol() == "quote" {
+			if depth == 0 {
+				return "''" + elements[1].Display(depth+1)
+			}
+			return "'" + elements[1].Display(depth+1)
 		}
 
 		var b strings.Builder
 		if depth == 0 {
 			b.WriteString("'")
 		}
-		elements := l.Elements
-		if len(elements) == 2 && elements[0].Type == SymbolType && elements[0].Symbol() == "quote" {
-			if elements[1].Type == SymbolType && elements[1].Symbol() != "quote" {
-				return fmt.Sprintf("''%s", elements[1].Symbol())
-			} else if depth > 0 && elements[1].Type == ListType {
-				return fmt.Sprintf("'%s", elements[1].Display(depth+1))
-			} else {
-				return fmt.Sprintf("''%s", elements[1].Display(depth+1))
-			}
-		}
-
 		b.WriteString("(")
 		for i, elem := range elements {
-			b.WriteString(elem.Display(depth + 1))
-			if i != len(elements)-1 {
+			if i > 0 {
 				b.WriteString(" ")
 			}
+			b.WriteString(elem.Display(depth + 1))
 		}
+		writeListTail(&b, tail, func(val *ReturnValue) string { return val.Display(depth + 1) })
 		b.WriteString(")")
 		return b.String()
-	case ConsType:
-		c, ok := rv.Data.(*ConsValue)
+	case PromiseType:
+		return "<promise>"
+	case ConditionType:
+		if c, ok := rv.Data.(*Condition); ok {
+			return fmt.Sprintf("<condition %s: %s>", c.Kind, c.Message)
+		}
+		return "<invalid condition>"
+	case GeneratorType:
+		return "<generator>"
+	case GoObjectType:
+		if o, ok := rv.Data.(*GoObject); ok {
+			return fmt.Sprintf("<go %s>", o.value.Type())
+		}
+		return "<invalid go object>"
+	case EnvironmentType:
+		return "<environment>"
+	case VectorType:
+		v, ok := rv.Data.(*VectorValue)
 		if !ok {
-			return "<invalid cons>"
+			return "<invalid vector>"
 		}
 
 		var b strings.Builder
 		if depth == 0 {
 			b.WriteString("'")
 		}
+		b.WriteString("#(")
+		for i, elem := range v.Elements {
+			if i > 0 {
+				b.WriteString(" ")
+			}
+			b.WriteString(elem.Display(depth + 1))
+		}
+		b.WriteString(")")
+		return b.String()
+	case HashTableType:
+		return "<hash-table>"
+	case CharType:
+		if c, ok := rv.Data.(rune); ok {
+			return lexer.CharString(c)
+		}
+		return "<invalid char>"
+	case BytevectorType:
+		v, ok := rv.Data.(*BytevectorValue)
+		if !ok {
+			return "<invalid bytevector>"
+		}
 
-		b.WriteString("(")
-		b.WriteString(c.Car.Display(depth + 1))
-		b.WriteString(" . ")
-		b.WriteString(c.Cdr.Display(depth + 1))
+		var b strings.Builder
+		b.WriteString("#u8(")
+		for i, octet := range v.Bytes {
+			if i > 0 {
+				b.WriteString(" ")
+			}
+			b.WriteString(strconv.Itoa(int(octet)))
+		}
 		b.WriteString(")")
 		return b.String()
-	case PromiseType:
-		return "<promise>"
 	default:
 		return "<unknown return value type>"
 	}
 }
 
+// Write renders rv with write semantics: the result reads back as an equal datum,
+// so unlike Display there is no leading quote and quote forms are spelled out.
+func (rv *ReturnValue) Write() string {
+	switch rv.Type {
+	case SymbolType:
+		return rv.Symbol()
+	case ListType:
+		return "()"
+	case ConsType:
+		elements, tail := listParts(rv)
+		var b strings.Builder
+		b.WriteString("(")
+		for i, elem := range elements {
+			if i > 0 {
+				b.WriteString(" ")
+			}
+			b.WriteString(elem.Write())
+		}
+		writeListTail(&b, tail, (*ReturnValue).Write)
+		b.WriteString(")")
+		return b.String()
+	case VectorType:
+		var b strings.Builder
+		b.WriteString("#(")
+		for i, elem := range rv.Vector().Elements {
+			if i > 0 {
+				b.WriteString(" ")
+			}
+			b.WriteString(elem.Write())
+		}
+		b.WriteString(")")
+		return b.String()
+	default:
+		return rv.Display(1)
+	}
+}
+
 func (rv *ReturnValue) Number() Number {
 	if rv.Type != NumberType {
 		panic("not a number")
 	}
-	if n, ok := rv.Data.(Number); ok {
+	switch n := rv.Data.(type) {
+	case Number:
 		return n
+	case *Number:
+		// from the allocation arena, see arena.go
+		return *n
 	}
 	panic("invalid number")
 }
@@ -152,12 +284,27 @@ func (rv *ReturnValue) StringValue() string {
 	if rv.Type != StringType {
 		panic("not a string")
 	}
-	if str, ok := rv.Data.(string); ok {
+	switch str := rv.Data.(type) {
+	case *mutableString:
+		return str.text
+	case string:
 		return str
+	case literalString:
+		return string(str)
 	}
 	panic("invalid string")
 }
 
+func (rv *ReturnValue) Char() rune {
+	if rv.Type != CharType {
+		panic("not a char")
+	}
+	if c, ok := rv.Data.(rune); ok {
+		return c
+	}
+	panic("invalid char")
+}
+
 func (rv *ReturnValue) Constant() ConstantValue {
 	if rv.Type != ConstantType {
 		panic("not a constant")
@@ -198,16 +345,6 @@ func (rv *ReturnValue) Symbol() string {
 	panic("invalid symbol")
 }
 
-func (rv *ReturnValue) List() *ListValue {
-	if rv.Type != ListType {
-		panic("not a list")
-	}
-	if list, ok := rv.Data.(*ListValue); ok {
-		return list
-	}
-	panic("invalid list")
-}
-
 func (rv *ReturnValue) Cons() *ConsValue {
 	if rv.Type != ConsType {
 		panic("not a cons")
@@ -218,6 +355,36 @@ func (rv *ReturnValue) Cons() *ConsValue {
 	panic("invalid cons")
 }
 
+func (rv *ReturnValue) Vector() *VectorValue {
+	if rv.Type != VectorType {
+		panic("not a vector")
+	}
+	if vector, ok := rv.Data.(*VectorValue); ok {
+		return vector
+	}
+	panic("invalid vector")
+}
+
+func (rv *ReturnValue) Bytevector() *BytevectorValue {
+	if rv.Type != BytevectorType {
+		panic("not a bytevector")
+	}
+	if bytevector, ok := rv.Data.(*BytevectorValue); ok {
+		return bytevector
+	}
+	panic("invalid bytevector")
+}
+
+func (rv *ReturnValue) HashTable() *HashTableValue {
+	if rv.Type != HashTableType {
+		panic("not a hash table")
+	}
+	if table, ok := rv.Data.(*HashTableValue); ok {
+		return table
+	}
+	panic("invalid hash table")
+}
+
 func (rv *ReturnValue) Promise() *PromiseValue {
 	if rv.Type != PromiseType {
 		panic("not a promise")
@@ -228,6 +395,16 @@ func (rv *ReturnValue) Promise() *PromiseValue {
 	panic("invalid promise")
 }
 
+func (rv *ReturnValue) Condition() *Condition {
+	if rv.Type != ConditionType {
+		panic("not a condition")
+	}
+	if condition, ok := rv.Data.(*Condition); ok {
+		return condition
+	}
+	panic("invalid condition")
+}
+
 type ConstantValue uint8
 
 const (
@@ -236,6 +413,24 @@ const (
 	FalseValue
 )
 
+// True, False, Void and EmptyList are the values of #t, #f, void and '(). They are shared by every
+// expression returning one, so they are never modified; a value that's updated in place needs its
+// own.
+var (
+	True      = &ReturnValue{Type: ConstantType, Data: TrueValue}
+	False     = &ReturnValue{Type: ConstantType, Data: FalseValue}
+	Void      = &ReturnValue{Type: ConstantType, Data: VoidConst}
+	EmptyList = &ReturnValue{Type: ListType}
+)
+
+// boolValue returns True or False.
+func boolValue(b bool) *ReturnValue {
+	if b {
+		return True
+	}
+	return False
+}
+
 func (c ConstantValue) String() string {
 	switch c {
 	case VoidConst:
@@ -250,10 +445,23 @@ func (c ConstantValue) String() string {
 }
 
 type ProcedureValue struct {
+	// Name is the name the procedure was first defined as, empty for a lambda that never was
+	Name                  string
 	Parameters            []string
 	OptionalTailParameter string // empty if not present
 	Body                  []parser.Expression
 	Env                   *Environment
+	// line and column are where the lambda was written, 0 if it's not known, and source in what
+	line   int
+	column int
+	source *source
+}
+
+// nameProcedure names val after the definition binding it, unless it's not a procedure or already has a name.
+func nameProcedure(val *ReturnValue, name string) {
+	if val.Type == ProcedureType && val.Procedure().Name == "" {
+		val.Procedure().Name = name
+	}
 }
 
 func (p *ProcedureValue) CaneTakeArbitraryParameters() bool {
@@ -263,19 +471,56 @@ func (p *ProcedureValue) CaneTakeArbitraryParameters() bool {
 type BuiltinFunction struct {
 	//Fn func(parameters []parser.Expression, evaluator *Evaluator, environment *Environment) (*ReturnValue, error)
 	Fn func(parameters []*ReturnValue, evaluator *Evaluator, environment *Environment) (*ReturnValue, error)
+	// borrowsParameters is set for builtins that don't keep parameters once they return, a call
+	// passes them the operand stack instead of a copy
+	borrowsParameters bool
+	// parameter is set for the parameter objects made by make-parameter
+	parameter *parameter
 }
 
-type ListValue struct {
-	Elements []*ReturnValue
-}
-
+// ConsValue is a pair, a list is a chain of them ending in EmptyList, see list.go.
 type ConsValue struct {
 	Car *ReturnValue
 	Cdr *ReturnValue
+	// literal is set for a quoted pair, which can't be modified
+	literal bool
+}
+
+type VectorValue struct {
+	Elements []*ReturnValue
+	// literal is set for a vector literal, which can't be modified
+	literal bool
+}
+
+type BytevectorValue struct {
+	Bytes []byte
+}
+
+// HashTableValue is a hash table, see builtin_hash_table.go.
+type HashTableValue struct {
+	// equal is set for a table keyed by equal?, it's keyed by eqv? otherwise
+	equal   bool
+	buckets map[string][]*hashTableEntry
+	count   int
 }
 
 type PromiseValue struct {
 	Expression     parser.Expression
 	Env            *Environment
 	EvaluatedValue *ReturnValue
+	// Thunk computes the value instead of Expression, used by promises created from Go code
+	Thunk func() (*ReturnValue, error)
+	// delayForce is set for the promises of delay-force, whose expression evaluates to another
+	// promise
+	delayForce bool
+	// forward is the promise of delay-force that took this one over, see force
+	forward *PromiseValue
+}
+
+// resolve returns the promise holding the state of p, p unless it was taken over.
+func (p *PromiseValue) resolve() *PromiseValue {
+	for p.forward != nil {
+		p = p.forward
+	}
+	return p
 }
@@ -0,0 +1,45 @@
+package evaluator
+
+import "strings"
+
+// Capability is a set of side effects soup code is allowed to perform, builtins that reach outside
+// the evaluator check it before doing any work.
+type Capability uint8
+
+const (
+	CapabilityNetwork Capability = 1 << iota
+	CapabilityFileSystem
+
+	NoCapabilities  Capability = 0
+	AllCapabilities            = CapabilityNetwork | CapabilityFileSystem
+)
+
+func (c Capability) String() string {
+	names := make([]string, 0)
+	if c&CapabilityNetwork != 0 {
+		names = append(names, "network")
+	}
+	if c&CapabilityFileSystem != 0 {
+		names = append(names, "file-system")
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+// SetCapabilities restricts what builtins may do, evaluators start with AllCapabilities.
+func (e *Evaluator) SetCapabilities(capabilities Capability) {
+	e.capabilities = capabilities
+}
+
+func (e *Evaluator) Capabilities() Capability {
+	return e.capabilities
+}
+
+func (e *Evaluator) requireCapability(capability Capability, procedureName string) error {
+	if e.capabilities&capability != capability {
+		return conditionErrorf(PermissionDeniedCondition, "'%s' requires the %s capability, which is disabled by the sandbox", procedureName, capability)
+	}
+	return nil
+}
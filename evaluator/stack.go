@@ -0,0 +1,160 @@
+package evaluator
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"os"
+	"runtime/debug"
+	"strconv"
+
+	"github.com/ocowchun/soup/parser"
+)
+
+// A call in tail position of a procedure body, through ifs and begins, replaces the call of the
+// procedure rather than nesting in it, so a loop written as a tail recursion runs in constant stack
+// and doesn't count toward MaxDepth. The procedures a tail call replaced aren't in stack traces.
+//
+// Evaluating any other procedure call nests Go calls, so deep recursion in soup is deep recursion in Go,
+// and the Go runtime aborts a goroutine whose stack outgrows 1GB with a fatal error nothing can
+// recover from. Instead the evaluator continues on a new goroutine every stackSegmentDepth nested
+// calls and waits for it to return: each segment has a stack of its own, so calls can nest as deep
+// as the heap allows. A nested call takes about 3KB of stack, a full segment about 32MB, and the
+// segments below the running one keep theirs until it returns. DefaultMaxDepth, used when MaxDepth
+// isn't set, turns a runaway recursion into a resource-limit condition, which handlers can catch,
+// before the stacks take half of the memory.
+
+// stackSegmentDepth is how many procedure calls nest on one goroutine's stack.
+const stackSegmentDepth = 10000
+
+// callStackBytes is about how much stack a nested procedure call takes.
+const callStackBytes = 3 << 10
+
+// fallbackMemory is how much memory is assumed to be available when the system doesn't tell.
+const fallbackMemory = 4 << 30
+
+// DefaultMaxDepth is how deeply procedure calls may nest when Limits.MaxDepth is zero: as many as
+// half of the memory available when the program started holds the stacks of, the memory limit of
+// the Go runtime if it's lower. A program recursing deeper sets MaxDepth, with --max-depth for the
+// command line.
+var DefaultMaxDepth = max(int(availableMemory()/2/callStackBytes), 2*stackSegmentDepth)
+
+// availableMemory returns how much memory the program can use: the memory limit of the Go runtime,
+// set with GOMEMLIMIT, or else the memory Linux reports available, or else fallbackMemory.
+func availableMemory() uint64 {
+	if limit := debug.SetMemoryLimit(-1); limit > 0 && limit < math.MaxInt64 {
+		return uint64(limit)
+	}
+	if content, err := os.ReadFile("/proc/meminfo"); err == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		for scanner.Scan() {
+			// MemAvailable:    5554272 kB
+			fields := bytes.Fields(scanner.Bytes())
+			if len(fields) == 3 && string(fields[0]) == "MemAvailable:" && string(fields[2]) == "kB" {
+				if kb, err := strconv.ParseUint(string(fields[1]), 10, 64); err == nil {
+					return kb << 10
+				}
+			}
+		}
+	}
+	return fallbackMemory
+}
+
+// evalOnNewSegment evaluates the call of procedure on a new stack segment, once the current one
+// is full.
+func (e *Evaluator) evalOnNewSegment(procedure *ProcedureValue, operands []*ReturnValue, environment *Environment) (*ReturnValue, error) {
+	// checked once per segment, it's a bound on memory rather than an exact limit like MaxDepth
+	if e.limits.MaxDepth <= 0 && e.depth >= DefaultMaxDepth {
+		return nil, conditionErrorf(ResourceLimitCondition, "resource limit exceeded: procedure calls nested more than %d deep", DefaultMaxDepth)
+	}
+
+	depth := e.segmentDepth
+	e.segmentDepth = 0
+	defer func() {
+		e.segmentDepth = depth
+	}()
+
+	var ret *ReturnValue
+	var err error
+	var panicked any
+	done := make(chan struct{})
+	go func() {
+		defer func() {
+			panicked = recover()
+			close(done)
+		}()
+		ret, err = e.evalProcedure(procedure, operands, environment)
+	}()
+	<-done
+	// only one segment runs at a time, a panic is raised again in the segment waiting for it
+	if panicked != nil {
+		panic(panicked)
+	}
+	return ret, err
+}
+
+// tailCall is a call of procedure with operands in tail position of a procedure body, at operator
+// in environment.
+type tailCall struct {
+	operator    parser.Expression
+	procedure   *ProcedureValue
+	operands    []*ReturnValue
+	environment *Environment
+}
+
+// evalTail evaluates expression, the last of a procedure body, returning the procedure call it ends
+// with instead of making it.
+func (e *Evaluator) evalTail(expression parser.Expression, environment *Environment) (*ReturnValue, *tailCall, error) {
+	switch exp := expression.(type) {
+	case *parser.CallExpression:
+		if err := e.step(); err != nil {
+			return nil, nil, err
+		}
+		return e.evalCall(exp, environment, true)
+	case *parser.IfExpression:
+		if err := e.step(); err != nil {
+			return nil, nil, err
+		}
+		cond, err := e.eval(exp.Predicate, environment)
+		if err != nil {
+			return nil, nil, runtimeErrorAt(err, exp.Predicate.Token(), environment)
+		}
+		branch := exp.Consequent
+		if cond.Type == ConstantType && cond.Data == FalseValue {
+			if exp.Alternative == nil {
+				return Void, nil, nil
+			}
+			branch = exp.Alternative
+		}
+		ret, call, err := e.evalTail(branch, environment)
+		if err != nil {
+			return nil, nil, runtimeErrorAt(err, branch.Token(), environment)
+		}
+		return ret, call, nil
+	case *parser.BeginExpression:
+		if len(exp.Expressions) == 0 {
+			break
+		}
+		if err := e.step(); err != nil {
+			return nil, nil, err
+		}
+		last := len(exp.Expressions) - 1
+		for _, subExp := range exp.Expressions[:last] {
+			if _, err := e.eval(subExp, environment); err != nil {
+				return nil, nil, err
+			}
+		}
+		return e.evalTail(exp.Expressions[last], environment)
+	}
+	ret, err := e.eval(expression, environment)
+	return ret, nil, err
+}
+
+// evalTailCall makes call, adding its frame to the stack trace of an error.
+func (e *Evaluator) evalTailCall(call *tailCall) (*ReturnValue, *tailCall, error) {
+	ret, next, err := e.evalProcedureBody(call.procedure, call.operands, call.environment)
+	if err != nil {
+		return nil, nil, newRuntimeError(err, call.operator.Token(), procedureName{name: call.procedure.Name, operator: call.operator, procedure: call.procedure}, call.environment)
+	}
+	return ret, next, nil
+}
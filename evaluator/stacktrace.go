@@ -2,28 +2,100 @@ package evaluator
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/ocowchun/soup/lexer"
+	"github.com/ocowchun/soup/parser"
 )
 
+// procedureName names the procedure of a stack frame: the name it was defined as, or else where the
+// lambda was written, as for a let's lambda, or else the operator of the call. The operator is kept
+// rather than rendered, that's only worth it when someone asks for the name.
+type procedureName struct {
+	name      string
+	operator  parser.Expression
+	procedure *ProcedureValue
+}
+
+// maxOperatorName is how much of an operator a frame name shows, the operator can be a whole lambda
+const maxOperatorName = 40
+
+func (p procedureName) String() string {
+	if p.name != "" {
+		return p.name
+	}
+	if p.procedure != nil && p.procedure.line > 0 {
+		return fmt.Sprintf("#<lambda %d:%d>", p.procedure.line, p.procedure.column)
+	}
+	name := p.operator.String()
+	if line, _, ok := strings.Cut(name, "\n"); ok || len(line) > maxOperatorName {
+		name = strings.ToValidUTF8(line[:min(len(line), maxOperatorName)], "") + "..."
+	}
+	return name
+}
+
 type StackTraceElement struct {
 	//token lexer.Token
-	lineNumber     int
-	identifierName string
+	lineNumber int
+	// procedure is only rendered to a name by IdentifierName, most errors are handled or discarded
+	// before anyone asks for it
+	procedure procedureName
 }
 
 func (e StackTraceElement) LineNumber() int {
 	return e.lineNumber
 }
 func (e StackTraceElement) IdentifierName() string {
-	return e.identifierName
+	return e.procedure.String()
 }
 
 // how to handle runtime error with stack trace?
 type RuntimeError struct {
+	// rawErrorMessage replaces the message of cause, it's set when a RuntimeError was wrapped by another error
 	rawErrorMessage string
 	lineNumber      int
 	stackTrace      []StackTraceElement
+	// cause is the innermost error, i.e. the *Condition raised by a builtin
+	cause error
+	// environment is the environment of the innermost frame, the one the error was raised in
+	environment *Environment
+	// token is the operator of the innermost call, source where it was written once located is set
+	token   lexer.Token
+	source  *source
+	located bool
+	// raised is set once the error was raised to the exception handlers and none handled it
+	raised bool
+}
+
+// Token returns the token the error was raised at, the operator of the call that failed.
+func (e *RuntimeError) Token() lexer.Token {
+	return e.token
+}
+
+// File returns the path of the file the token the error was raised at is in, "" if it's not in a file
+// or that isn't known. A procedure defined in one file and called from another reports the file it's
+// defined in.
+func (e *RuntimeError) File() string {
+	if e.source == nil {
+		return ""
+	}
+	return e.source.file
+}
+
+// Source returns the text the token the error was raised at is in when it's not in a file but was
+// given to the evaluator, as an entry of the REPL, "" otherwise.
+func (e *RuntimeError) Source() string {
+	if e.source == nil {
+		return ""
+	}
+	return e.source.text
+}
+
+// Environment returns the environment of the frame the error was raised in, its local variables are
+// those of the procedure call that failed.
+func (e *RuntimeError) Environment() *Environment {
+	return e.environment
 }
 
 func (e *RuntimeError) LineNumber() int {
@@ -34,28 +106,76 @@ func (e *RuntimeError) StackTrace() []StackTraceElement {
 }
 
 func (e *RuntimeError) Error() string {
-	return e.rawErrorMessage
+	if e.rawErrorMessage != "" {
+		return e.rawErrorMessage
+	}
+	return e.cause.Error()
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.cause
 }
 
-func newRuntimeError(err error, token lexer.Token, procedureName string) *RuntimeError {
+// newRuntimeError returns err with the frame of procedure, the call at token that failed, added to
+// its stack trace. An error that is already a RuntimeError gets the frame added in place as it's
+// passing through the callers, so unwinding a deep stack costs a frame per call rather than a new error.
+func newRuntimeError(err error, token lexer.Token, procedure procedureName, environment *Environment) *RuntimeError {
 	var prevError *RuntimeError
 	if ok := errors.As(err, &prevError); ok {
-		stackTrace := append(prevError.stackTrace, StackTraceElement{
-			lineNumber:     prevError.lineNumber,
-			identifierName: procedureName,
+		if err != error(prevError) {
+			prevError.rawErrorMessage = err.Error()
+		}
+		// the first frame is of the procedure the error was raised in
+		if len(prevError.stackTrace) == 0 && procedure.procedure != nil {
+			locateRuntimeError(prevError, procedure.procedure.source)
+		}
+		prevError.stackTrace = append(prevError.stackTrace, StackTraceElement{
+			lineNumber: prevError.lineNumber,
+			procedure:  procedure,
 		})
+		prevError.lineNumber = token.Line
+		return prevError
+	}
+	return &RuntimeError{
+		lineNumber:  token.Line,
+		cause:       err,
+		environment: environment,
+		token:       token,
+	}
+}
 
-		return &RuntimeError{
-			rawErrorMessage: err.Error(),
-			lineNumber:      token.Line,
-			stackTrace:      stackTrace,
-		}
-	} else {
-		return &RuntimeError{
-			rawErrorMessage: err.Error(),
-			lineNumber:      token.Line,
-			stackTrace:      []StackTraceElement{},
+// source is where code was written: the file it was loaded from, or the text of a program that isn't
+// a file.
+type source struct {
+	file string
+	text string
+}
+
+// locateRuntimeError records that the runtime error err, if it is one, was raised in code from src,
+// unless where it was raised is already known.
+func locateRuntimeError(err error, src *source) {
+	var runtimeError *RuntimeError
+	if errors.As(err, &runtimeError) && !runtimeError.located {
+		runtimeError.source = src
+		runtimeError.located = true
+	}
+}
+
+// runtimeErrorAt returns err as a RuntimeError raised at token, an error that already is one is
+// returned as is: only calls add frames.
+func runtimeErrorAt(err error, token lexer.Token, environment *Environment) *RuntimeError {
+	var prevError *RuntimeError
+	if ok := errors.As(err, &prevError); ok {
+		if err != error(prevError) {
+			prevError.rawErrorMessage = err.Error()
 		}
+		return prevError
+	}
+	return &RuntimeError{
+		lineNumber:  token.Line,
+		cause:       err,
+		environment: environment,
+		token:       token,
 	}
 }
 
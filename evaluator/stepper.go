@@ -0,0 +1,120 @@
+package evaluator
+
+import (
+	"context"
+
+	"github.com/ocowchun/soup/parser"
+)
+
+// A Step is a point in the evaluation of a program: an expression about to be evaluated, or one that
+// just was, with its value.
+type Step struct {
+	Expression  parser.Expression
+	Environment *Environment
+	// Depth is how many expressions enclosing Expression are being evaluated, 0 for a top-level one
+	Depth int
+	// Evaluated is false before Expression is evaluated and true after, when Result or Err is set
+	Evaluated bool
+	Result    *ReturnValue
+	Err       error
+}
+
+// A Stepper evaluates a program one step at a time, for tools that show how an evaluation unfolds:
+//
+//	s := e.Stepper(program)
+//	defer s.Close()
+//	for s.Next() {
+//		step := s.Step()
+//		...
+//	}
+//	ret, err := s.Result()
+//
+// Like a generator, the program runs on its own goroutine that takes turns with the caller, so the
+// evaluator must not be used for anything else until Next returns false or Close is called. The
+// evaluator's Hook isn't called while the stepper runs.
+type Stepper struct {
+	resume chan struct{}
+	steps  chan Step
+	// done is closed once the program is evaluated, result and err are set then
+	done     chan struct{}
+	finished bool
+	cancel   context.CancelFunc
+	step     Step
+	depth    int
+	result   *ReturnValue
+	err      error
+}
+
+// Stepper returns a stepper evaluating program in the global environment, nothing is evaluated
+// before the first call to Next.
+func (e *Evaluator) Stepper(program *parser.Program) *Stepper {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Stepper{
+		resume: make(chan struct{}),
+		steps:  make(chan Step),
+		done:   make(chan struct{}),
+		cancel: cancel,
+	}
+	go func() {
+		<-s.resume
+		prev := e.hook
+		e.hook = stepperHook{s}
+		s.result, s.err = e.EvalContext(ctx, program)
+		e.hook = prev
+		close(s.done)
+	}()
+	return s
+}
+
+// Next evaluates up to the next step and reports whether there is one, it returns false once the
+// program is evaluated.
+func (s *Stepper) Next() bool {
+	if s.finished {
+		return false
+	}
+	s.resume <- struct{}{}
+	select {
+	case s.step = <-s.steps:
+		return true
+	case <-s.done:
+		s.finished = true
+		return false
+	}
+}
+
+// Step returns the step Next stopped at.
+func (s *Stepper) Step() Step {
+	return s.step
+}
+
+// Result returns what evaluating the program returned, once Next has returned false.
+func (s *Stepper) Result() (*ReturnValue, error) {
+	return s.result, s.err
+}
+
+// Close stops the evaluation where it is, Result then returns a canceled condition unless the program
+// was already evaluated.
+func (s *Stepper) Close() {
+	s.cancel()
+	for s.Next() {
+	}
+}
+
+// stepperHook hands control back to the caller of Next around every expression.
+type stepperHook struct {
+	s *Stepper
+}
+
+func (h stepperHook) Before(exp parser.Expression, environment *Environment) {
+	s := h.s
+	s.steps <- Step{Expression: exp, Environment: environment, Depth: s.depth}
+	<-s.resume
+	s.depth++
+}
+
+func (h stepperHook) After(exp parser.Expression, environment *Environment, result *ReturnValue, err error) {
+	s := h.s
+	s.depth--
+	s.steps <- Step{Expression: exp, Environment: environment, Depth: s.depth, Evaluated: true, Result: result, Err: err}
+	<-s.resume
+}
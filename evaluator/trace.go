@@ -0,0 +1,43 @@
+package evaluator
+
+import "slices"
+
+// TraceCall describes a call to a compound procedure, Depth is 1 for a call that isn't made from
+// another traced call.
+type TraceCall struct {
+	// Name is the procedure's name, lambda for an anonymous one
+	Name  string
+	Args  []*ReturnValue
+	Depth int
+}
+
+// Tracer is told about every call to a compound procedure, including those made by builtins such as
+// map. Calls to builtins aren't traced.
+type Tracer interface {
+	Enter(call TraceCall)
+	// Exit is called when the call returns, with the error it failed with if it did
+	Exit(call TraceCall, result *ReturnValue, err error)
+}
+
+// SetTracer starts tracing calls with tracer, nil stops tracing.
+func (e *Evaluator) SetTracer(tracer Tracer) {
+	e.tracer = tracer
+}
+
+func (e *Evaluator) traceProcedure(procedure *ProcedureValue, operands []*ReturnValue, environment *Environment) (*ReturnValue, error) {
+	e.traceDepth++
+	defer func() {
+		e.traceDepth--
+	}()
+
+	name := procedure.Name
+	if name == "" {
+		name = "lambda"
+	}
+	call := TraceCall{Name: name, Args: slices.Clone(operands), Depth: e.traceDepth}
+	e.tracer.Enter(call)
+	// a traced body makes its tail calls itself, each is traced
+	ret, _, err := e.evalProcedureBody(procedure, operands, environment)
+	e.tracer.Exit(call, ret, err)
+	return ret, err
+}
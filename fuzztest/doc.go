@@ -0,0 +1,12 @@
+// Package fuzztest holds the fuzz targets of the lexer and the parser:
+//
+//   - the lexer never panics, whatever the bytes it reads
+//   - the parser never panics and every error it returns is a *parser.ParsingError
+//   - printing a program that parses and parsing it back gives the same program, which prints the same
+//
+// go test runs the targets on their seeds, the programs of soup-script among them, and
+//
+//	go test ./fuzztest -fuzz FuzzParser
+//
+// fuzzes one of them.
+package fuzztest
@@ -0,0 +1,111 @@
+package fuzztest
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ocowchun/soup/lexer"
+	"github.com/ocowchun/soup/parser"
+)
+
+var seeds = []string{
+	"",
+	"(define (square x) (* x x)) (square 4)",
+	"(let ((x 1) (y 2)) (cond ((< x y) 'less) (else 'more)))",
+	"'(1 (2 \"three\") . 4)",
+	"''a",
+	"(define (f x . rest) (if x rest)) (lambda (x) x)",
+	"(cons-stream 1 (delay (force x)))",
+	"(delay-force (make-promise 1))",
+	"(cond (x => car) ((f)) (else 1))",
+	"(assert (= (f 1) 2))",
+	"(define-library (lib) (export f) (import (scheme base)) (begin (define (f) 1)))",
+	"(import (only (lib) f) (rename (lib) (f g)))",
+	"(amb 1 2 3)",
+	"#lang racket\n(display \"a\\nb\") ; comment",
+	"(+ -1 .5 1e21 -2.5e-7 1+)",
+	"(list #x1F #b-101 #o17 #e1. #i#d3)",
+	"(+ 1/3 -7/2 #e1.5 #x-1/F)",
+	"(list #(1 (a #()) \"s\") '#(b))",
+	"(list #\\a #\\space #\\x41 '(#\\( #\\)))",
+	"(list +inf.0 -inf.0 +nan.0 #i-inf.0 +inf)",
+	"(set! x #t) (begin #f)",
+	"(define-syntax swap! (syntax-rules () ((_ a b) (let ((tmp a)) (set! a b) (set! b tmp))))) (swap! x y)",
+	"(define-syntax my-let (syntax-rules () ((_ ((n v) ...) b ...) ((lambda (n ...) b ...) v ...)))) (my-let ((a 1)) a)",
+	"(define-macro (unless c . body) (list 'if c #f (cons 'begin body))) (define-macro m (lambda (x) x))",
+	"(guard (e ((symbol? e) e) (else 0)) (raise 'x))",
+	"(parameterize ((p 1) ((f) \"s\")) (p))",
+}
+
+// addSeeds adds the seeds and the programs of soup-script to the corpus of f.
+func addSeeds(f *testing.F) {
+	for _, seed := range seeds {
+		f.Add([]byte(seed))
+	}
+	files, _ := filepath.Glob(filepath.Join("..", "soup-script", "*.soup"))
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(src)
+	}
+}
+
+func FuzzLexer(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, src []byte) {
+		l := lexer.New(bytes.NewReader(src))
+		// every token but EOF takes at least one byte
+		for i := 0; i <= len(src)+1; i++ {
+			if l.NextToken().TokenType == lexer.TokenTypeEOF {
+				return
+			}
+		}
+		t.Fatalf("the lexer didn't reach EOF after %d tokens", len(src)+2)
+	})
+}
+
+func FuzzParser(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, src []byte) {
+		_, err := parser.New(lexer.New(bytes.NewReader(src))).Parse()
+		var parsingError *parser.ParsingError
+		if err != nil && !errors.As(err, &parsingError) {
+			t.Fatalf("expected a *parser.ParsingError, got %T: %v", err, err)
+		}
+	})
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, src []byte) {
+		program, err := parser.New(lexer.New(bytes.NewReader(src))).Parse()
+		if err != nil {
+			return
+		}
+		printed := printProgram(program)
+		reparsed, err := parser.New(lexer.New(strings.NewReader(printed))).Parse()
+		if err != nil {
+			t.Fatalf("the printed program doesn't parse: %v\n%s", err, printed)
+		}
+		if len(reparsed.Expressions) != len(program.Expressions) {
+			t.Fatalf("the printed program has %d expressions rather than %d\n%s", len(reparsed.Expressions), len(program.Expressions), printed)
+		}
+		if reprinted := printProgram(reparsed); reprinted != printed {
+			t.Fatalf("the printed program prints as\n%s\nrather than\n%s", reprinted, printed)
+		}
+	})
+}
+
+func printProgram(program *parser.Program) string {
+	lines := make([]string, len(program.Expressions))
+	for i, exp := range program.Expressions {
+		lines[i] = exp.String()
+	}
+	return strings.Join(lines, "\n")
+}
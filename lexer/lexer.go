@@ -4,6 +4,12 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 type Lexer struct {
@@ -11,6 +17,12 @@ type Lexer struct {
 	line    string
 	lineNo  int
 	column  int
+	// tokenLine and tokenColumn are where the token being read starts
+	tokenLine   int
+	tokenColumn int
+	// brackets is set by AcceptBrackets, opened holds the parens and brackets not yet closed then
+	brackets bool
+	opened   []byte
 }
 
 type TokenType uint8
@@ -51,6 +63,27 @@ const (
 	TokenTypeDelay
 	TokenTypeForce
 	TokenTypeConsStream
+	TokenTypeDefineLibrary
+	TokenTypeImport
+	TokenTypeAmb
+	TokenTypeLetrec
+	TokenTypeLetrecStar
+	TokenTypeLetStar
+	TokenTypeDefineSyntax
+	TokenTypeDefineMacro
+	TokenTypeGuard
+	TokenTypeParameterize
+	TokenTypeDelayForce
+	TokenTypeAssert
+	TokenTypeLetSyntax
+	TokenTypeLetrecSyntax
+	// TokenTypeVectorPrefix is the # of a vector literal, the list of its elements follows
+	TokenTypeVectorPrefix
+	// TokenTypeChar is a character literal like #\a, see ParseChar
+	TokenTypeChar
+	// TokenTypeDatum stands for a value that has no source, such as a procedure in a form given to
+	// eval, its Datum holds the value. The lexer never makes one.
+	TokenTypeDatum
 )
 
 func (t TokenType) String() string {
@@ -125,15 +158,53 @@ func (t TokenType) String() string {
 		return "Force"
 	case TokenTypeConsStream:
 		return "ConsStream"
+	case TokenTypeDefineLibrary:
+		return "DefineLibrary"
+	case TokenTypeImport:
+		return "Import"
+	case TokenTypeAmb:
+		return "Amb"
+	case TokenTypeLetrec:
+		return "Letrec"
+	case TokenTypeLetrecStar:
+		return "Letrec*"
+	case TokenTypeLetStar:
+		return "Let*"
+	case TokenTypeDefineSyntax:
+		return "DefineSyntax"
+	case TokenTypeDefineMacro:
+		return "DefineMacro"
+	case TokenTypeGuard:
+		return "Guard"
+	case TokenTypeParameterize:
+		return "Parameterize"
+	case TokenTypeDelayForce:
+		return "DelayForce"
+	case TokenTypeAssert:
+		return "Assert"
+	case TokenTypeLetSyntax:
+		return "LetSyntax"
+	case TokenTypeLetrecSyntax:
+		return "LetrecSyntax"
+	case TokenTypeVectorPrefix:
+		return "VectorPrefix"
+	case TokenTypeChar:
+		return "Char"
+	case TokenTypeDatum:
+		return "Datum"
 	default:
 		return "Unknown"
 	}
 }
 
 type Token struct {
-	Content   string
-	Line      int
+	Content string
+	Line    int
+	// Column is the byte offset of the token in its line, starting at 1
+	Column    int
 	TokenType TokenType
+	// Datum is the value of a TokenTypeDatum token
+	Datum any
 }
 
 func New(reader io.Reader) *Lexer {
@@ -146,6 +217,37 @@ func New(reader io.Reader) *Lexer {
 	}
 }
 
+// AcceptBrackets makes [ and ] parens, as in Racket, lexed as LeftParen and RightParen tokens with
+// the bracket as content. A bracket must be closed by a bracket and a paren by a paren.
+func (l *Lexer) AcceptBrackets() {
+	l.brackets = true
+}
+
+// isDelimiter reports whether c ends a number, an identifier or a # token.
+func (l *Lexer) isDelimiter(c byte) bool {
+	return isSpaceOrNewline(c) || c == '(' || c == ')' || (l.brackets && (c == '[' || c == ']'))
+}
+
+// open records the paren or bracket c, opening when brackets are accepted.
+func (l *Lexer) open(c byte) {
+	if l.brackets {
+		l.opened = append(l.opened, c)
+	}
+}
+
+// close checks that c closes the last paren or bracket opened, when brackets are accepted.
+func (l *Lexer) close(c byte) error {
+	if !l.brackets || len(l.opened) == 0 {
+		return nil
+	}
+	opening := l.opened[len(l.opened)-1]
+	l.opened = l.opened[:len(l.opened)-1]
+	if (opening == '(') != (c == ')') {
+		return fmt.Errorf("'%c' at line %d, column %d closes a '%c'", c, l.lineNo, l.column, opening)
+	}
+	return nil
+}
+
 func isDigit(c byte) bool {
 	return c >= '0' && c <= '9'
 }
@@ -163,6 +265,21 @@ func (l *Lexer) readNumber(acceptDot bool) (string, error) {
 	for l.column < len(l.line) && isDigit(l.line[l.column]) {
 		l.column++
 	}
+	// a rational, e.g. -7/2
+	if acceptDot && l.column+1 < len(l.line) && l.line[l.column] == '/' && isDigit(l.line[l.column+1]) {
+		l.column++
+		for l.column < len(l.line) && isDigit(l.line[l.column]) {
+			l.column++
+		}
+		n, err := l.endNumber(start)
+		if err != nil {
+			return "", err
+		}
+		if _, err := ParseNumber(n); err != nil {
+			return "", fmt.Errorf("%s at line %d, column %d", err, l.lineNo, start+1)
+		}
+		return n, nil
+	}
 	// TODO: handle float correctly
 	if l.column < len(l.line) && l.line[l.column] == '.' {
 		if !acceptDot {
@@ -174,10 +291,15 @@ func (l *Lexer) readNumber(acceptDot bool) (string, error) {
 			l.column++
 		}
 	}
+	l.readExponent()
+	return l.endNumber(start)
+}
 
+// endNumber returns the number read from start, which a delimiter has to follow.
+func (l *Lexer) endNumber(start int) (string, error) {
 	if l.column < len(l.line) {
 		firstChar := l.line[l.column]
-		if firstChar != '(' && firstChar != ')' && !isSpaceOrNewline(firstChar) {
+		if !l.isDelimiter(firstChar) {
 			return "", fmt.Errorf("invalid character '%c' after number at line %d, column %d", firstChar, l.lineNo, l.column+1)
 		}
 	}
@@ -185,6 +307,222 @@ func (l *Lexer) readNumber(acceptDot bool) (string, error) {
 	return l.line[start:l.column], nil
 }
 
+// readExponent reads the exponent of a number, e.g. the e-7 of 1.5e-7, if there's one.
+func (l *Lexer) readExponent() {
+	i := l.column
+	if i >= len(l.line) || (l.line[i] != 'e' && l.line[i] != 'E') {
+		return
+	}
+	i++
+	if i < len(l.line) && (l.line[i] == '+' || l.line[i] == '-') {
+		i++
+	}
+	if i >= len(l.line) || !isDigit(l.line[i]) {
+		return
+	}
+	for i < len(l.line) && isDigit(l.line[i]) {
+		i++
+	}
+	l.column = i
+}
+
+// ParseNumber returns the value of the number token content: an int64 for an exact integer that fits
+// one, a *big.Int for one that doesn't, a *big.Rat for an exact rational such as -7/2 and a float64
+// otherwise, which +inf.0, -inf.0 and +nan.0 are. The digits can follow a radix prefix, #x, #o, #b or #d, and an exactness prefix,
+// #e or #i, in either order: #x1F is 31, #e1.5 the exact 3/2 and #i3 the float 3.
+func ParseNumber(content string) (any, error) {
+	radix, exactness := 0, byte(0)
+	body := content
+	for len(body) >= 2 && body[0] == '#' {
+		switch prefix := body[1] | 0x20; prefix {
+		case 'x', 'o', 'b', 'd':
+			if radix != 0 {
+				return nil, fmt.Errorf("%s has two radix prefixes", content)
+			}
+			radix = radixOf(prefix)
+		case 'e', 'i':
+			if exactness != 0 {
+				return nil, fmt.Errorf("%s has two exactness prefixes", content)
+			}
+			exactness = prefix
+		default:
+			return nil, fmt.Errorf("invalid prefix #%c in %s", body[1], content)
+		}
+		body = body[2:]
+	}
+	if radix == 0 {
+		radix = 10
+	}
+
+	var value any
+	if numerator, denominator, ok := strings.Cut(body, "/"); ok {
+		if !isDigits(numerator, radix, true) || !isDigits(denominator, radix, false) {
+			return nil, fmt.Errorf("invalid number %s", content)
+		}
+		n, _ := new(big.Int).SetString(numerator, radix)
+		d, _ := new(big.Int).SetString(denominator, radix)
+		if d.Sign() == 0 {
+			return nil, fmt.Errorf("%s divides by zero", content)
+		}
+		value = rationalValue(new(big.Rat).SetFrac(n, d))
+	} else if radix != 10 {
+		if !isDigits(body, radix, true) {
+			return nil, fmt.Errorf("invalid number %s", content)
+		}
+		n, _ := new(big.Int).SetString(body, radix)
+		value = rationalValue(new(big.Rat).SetInt(n))
+	} else if f, ok := specialFloat(body); ok {
+		if exactness == 'e' {
+			return nil, fmt.Errorf("%s has no exact value", content)
+		}
+		value = f
+	} else if !isDecimal(body) {
+		return nil, fmt.Errorf("invalid number %s", content)
+	} else if i, err := strconv.ParseInt(body, 10, 64); err == nil {
+		value = i
+	} else if isDigits(body, 10, true) {
+		n, _ := new(big.Int).SetString(body, 10)
+		value = n
+	} else if exactness == 'e' {
+		// the decimal rather than the float closest to it, #e0.1 is 1/10
+		if _, exponent, _ := strings.Cut(strings.ToLower(body), "e"); len(strings.TrimLeft(exponent, "+-")) > 3 {
+			return nil, fmt.Errorf("the exponent of %s is too large for an exact number", content)
+		}
+		r, _ := new(big.Rat).SetString(body)
+		value = rationalValue(r)
+	} else if f, err := strconv.ParseFloat(body, 64); err == nil {
+		value = f
+	} else {
+		return nil, fmt.Errorf("invalid number %s", content)
+	}
+
+	if exactness == 'i' {
+		switch v := value.(type) {
+		case int64:
+			value = float64(v)
+		case *big.Int:
+			value, _ = new(big.Float).SetInt(v).Float64()
+		case *big.Rat:
+			value, _ = v.Float64()
+		}
+	}
+	return value, nil
+}
+
+// specialFloat returns the float s spells if it's +inf.0, -inf.0, +nan.0 or -nan.0.
+func specialFloat(s string) (float64, bool) {
+	if len(s) != len("+inf.0") || (s[0] != '+' && s[0] != '-') {
+		return 0, false
+	}
+	switch strings.ToLower(s[1:]) {
+	case "inf.0":
+		if s[0] == '-' {
+			return math.Inf(-1), true
+		}
+		return math.Inf(1), true
+	case "nan.0":
+		return math.NaN(), true
+	}
+	return 0, false
+}
+
+// readSpecialFloat reads +inf.0, -inf.0, +nan.0 or -nan.0 once its sign was read, and reports
+// whether it's one of them.
+func (l *Lexer) readSpecialFloat() (string, bool) {
+	start := l.column - 1
+	end := start + len("+inf.0")
+	if end > len(l.line) || (end < len(l.line) && !l.isDelimiter(l.line[end])) {
+		return "", false
+	}
+	if _, ok := specialFloat(l.line[start:end]); !ok {
+		return "", false
+	}
+	l.column = end
+	return l.line[start:end], true
+}
+
+// rationalValue returns the value of the exact number r: an int64 when it's an integer that fits
+// one, its numerator when it's another integer, r otherwise.
+func rationalValue(r *big.Rat) any {
+	if !r.IsInt() {
+		return r
+	}
+	if r.Num().IsInt64() {
+		return r.Num().Int64()
+	}
+	return r.Num()
+}
+
+// isDigits reports whether s is digits in radix, after a sign when signed.
+func isDigits(s string, radix int, signed bool) bool {
+	if signed && s != "" && (s[0] == '+' || s[0] == '-') {
+		s = s[1:]
+	}
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i] | 0x20
+		digit := radix
+		if isDigit(s[i]) {
+			digit = int(s[i] - '0')
+		} else if c >= 'a' && c <= 'f' {
+			digit = int(c-'a') + 10
+		}
+		if digit >= radix {
+			return false
+		}
+	}
+	return true
+}
+
+// radixOf returns the radix of the prefix #x, #o, #b or #d, given as the lowercase letter.
+func radixOf(prefix byte) int {
+	switch prefix {
+	case 'x':
+		return 16
+	case 'o':
+		return 8
+	case 'b':
+		return 2
+	}
+	return 10
+}
+
+// isDecimal reports whether s is a decimal number readNumber reads, with a sign, a fraction and an
+// exponent, e.g. -1.5e-7 or .5.
+func isDecimal(s string) bool {
+	i := 0
+	if i < len(s) && (s[i] == '+' || s[i] == '-') {
+		i++
+	}
+	digits := 0
+	for ; i < len(s) && isDigit(s[i]); i++ {
+		digits++
+	}
+	if i < len(s) && s[i] == '.' {
+		for i++; i < len(s) && isDigit(s[i]); i++ {
+			digits++
+		}
+	}
+	if digits == 0 {
+		return false
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+		if i == len(s) || !isDigit(s[i]) {
+			return false
+		}
+		for i < len(s) && isDigit(s[i]) {
+			i++
+		}
+	}
+	return i == len(s)
+}
+
 func (l *Lexer) skipWhitespace() {
 	for l.column < len(l.line) && isSpaceOrNewline(l.line[l.column]) {
 		l.column++
@@ -224,12 +562,54 @@ var keywordMap = map[string]TokenType{
 	"delay":       TokenTypeDelay,
 	"force":       TokenTypeForce,
 	"cons-stream": TokenTypeConsStream,
+
+	"define-library": TokenTypeDefineLibrary,
+	"import":         TokenTypeImport,
+	"amb":            TokenTypeAmb,
+	"letrec":         TokenTypeLetrec,
+	"letrec*":        TokenTypeLetrecStar,
+	"let*":           TokenTypeLetStar,
+	"define-syntax":  TokenTypeDefineSyntax,
+	"define-macro":   TokenTypeDefineMacro,
+	"guard":          TokenTypeGuard,
+	"parameterize":   TokenTypeParameterize,
+	"delay-force":    TokenTypeDelayForce,
+	"assert":         TokenTypeAssert,
+	"let-syntax":     TokenTypeLetSyntax,
+	"letrec-syntax":  TokenTypeLetrecSyntax,
+}
+
+// LookupKeyword returns the token type of word if it's a keyword such as define or lambda.
+func LookupKeyword(word string) (TokenType, bool) {
+	tokenType, ok := keywordMap[word]
+	return tokenType, ok
+}
+
+// isIncrementName reports whether the token starting at the character just read is 1+ or -1+, the
+// MIT Scheme names of increment and decrement, which are identifiers rather than numbers.
+func (l *Lexer) isIncrementName() bool {
+	start := l.column - 1
+	for _, name := range []string{"1+", "-1+"} {
+		end := start + len(name)
+		if strings.HasPrefix(l.line[start:], name) && (end == len(l.line) || l.isDelimiter(l.line[end])) {
+			return true
+		}
+	}
+	return false
+}
+
+// isEllipsis reports whether the token starting at the '.' just read is ..., the ellipsis of
+// syntax-rules, which is an identifier.
+func (l *Lexer) isEllipsis() bool {
+	start := l.column - 1
+	end := start + len("...")
+	return strings.HasPrefix(l.line[start:], "...") && (end == len(l.line) || l.isDelimiter(l.line[end]))
 }
 
 func (l *Lexer) readIdentifierOrKeyword() (Token, error) {
 	start := l.column - 1
 	// can be identifier or keyword
-	for l.column < len(l.line) && !isSpaceOrNewline(l.line[l.column]) && l.line[l.column] != '(' && l.line[l.column] != ')' {
+	for l.column < len(l.line) && !l.isDelimiter(l.line[l.column]) {
 		l.column++
 	}
 
@@ -244,32 +624,118 @@ func (l *Lexer) readIdentifierOrKeyword() (Token, error) {
 
 func (l *Lexer) readString() (Token, error) {
 	//string can be multi-line
-	start := l.column
-	content := ""
+	var b strings.Builder
 	for l.column == len(l.line) || l.line[l.column] != '"' {
 		if l.column == len(l.line) {
-			content += l.line[start:l.column]
-
 			// read next line
 			if !l.readNextLine() {
 				return Token{}, fmt.Errorf("unterminated string at line %d, column %d", l.lineNo, l.column)
 			}
 			// include newline in string
-			start = 0
-			l.column = 0
+			b.WriteByte('\n')
+			continue
+		}
 
-		} else {
-			l.column++
+		c := l.line[l.column]
+		l.column++
+		if c == '\\' && l.column < len(l.line) {
+			if escaped, ok := stringEscapes[l.line[l.column]]; ok {
+				b.WriteByte(escaped)
+				l.column++
+				continue
+			}
 		}
+		b.WriteByte(c)
 	}
-	content += l.line[start:l.column]
+	l.column++
+
+	return Token{Content: b.String(), Line: l.lineNo, TokenType: TokenTypeString}, nil
+}
+
+var stringEscapes = map[byte]byte{
+	'"':  '"',
+	'\\': '\\',
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+}
 
-	if l.column == len(l.line) || l.line[l.column] != '"' {
-		return Token{}, fmt.Errorf("unterminated string at line %d, column %d", l.lineNo, l.column)
+// QuoteString returns s as a string literal the lexer reads back as s.
+func QuoteString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			b.WriteByte(s[i])
+		}
 	}
-	l.column++
+	b.WriteByte('"')
+	return b.String()
+}
 
-	return Token{Content: content, Line: l.lineNo, TokenType: TokenTypeString}, nil
+// charNames are the names of the characters written #\name, in the order CharString prefers them.
+var charNames = []struct {
+	name string
+	char rune
+}{
+	{"space", ' '},
+	{"newline", '\n'},
+	{"tab", '\t'},
+	{"return", '\r'},
+	{"null", 0},
+	{"alarm", 7},
+	{"backspace", 8},
+	{"escape", 27},
+	{"delete", 127},
+	{"nul", 0},
+	{"linefeed", '\n'},
+}
+
+// ParseChar returns the character a Char token spells: #\ followed by the character itself, its
+// name, like #\space, or x and its code point in hex, like #\x41.
+func ParseChar(content string) (rune, error) {
+	name, ok := strings.CutPrefix(content, `#\`)
+	if !ok || name == "" {
+		return 0, fmt.Errorf("invalid character %s", content)
+	}
+	if r, size := utf8.DecodeRuneInString(name); size == len(name) && r != utf8.RuneError {
+		return r, nil
+	}
+	for _, c := range charNames {
+		if c.name == name {
+			return c.char, nil
+		}
+	}
+	if name[0] == 'x' {
+		if n, err := strconv.ParseUint(name[1:], 16, 32); err == nil && utf8.ValidRune(rune(n)) {
+			return rune(n), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid character %s", content)
+}
+
+// CharString returns the character literal the lexer reads back as r.
+func CharString(r rune) string {
+	for _, c := range charNames {
+		if c.char == r {
+			return `#\` + c.name
+		}
+	}
+	if unicode.IsGraphic(r) && !unicode.IsSpace(r) {
+		return `#\` + string(r)
+	}
+	return fmt.Sprintf(`#\x%x`, r)
 }
 
 // skipComment skips the comment starting with `;` or `#` until the end of the line.
@@ -303,9 +769,29 @@ func (l *Lexer) isLangDirective() bool {
 }
 
 func (l *Lexer) readSharp() (Token, error) {
-	// TODO: handle other cases like #(123)
 	start := l.column - 1
-	for l.column < len(l.line) && !isSpaceOrNewline(l.line[l.column]) && l.line[l.column] != '(' && l.line[l.column] != ')' {
+	// #( opens a vector, the ( is the next token
+	if l.column < len(l.line) && l.line[l.column] == '(' {
+		return Token{Content: "#", Line: l.lineNo, TokenType: TokenTypeVectorPrefix}, nil
+	}
+	// #\a is a character, its name runs to the next delimiter but has at least one character, so
+	// #\( and #\  are characters too
+	if l.column < len(l.line) && l.line[l.column] == '\\' {
+		l.column++
+		if l.column < len(l.line) {
+			_, size := utf8.DecodeRuneInString(l.line[l.column:])
+			l.column += size
+		}
+		for l.column < len(l.line) && !l.isDelimiter(l.line[l.column]) {
+			l.column++
+		}
+		content := l.line[start:l.column]
+		if _, err := ParseChar(content); err != nil {
+			return Token{}, fmt.Errorf("%s at line %d, column %d", err, l.lineNo, start+1)
+		}
+		return Token{Content: content, Line: l.lineNo, TokenType: TokenTypeChar}, nil
+	}
+	for l.column < len(l.line) && !l.isDelimiter(l.line[l.column]) {
 		l.column++
 	}
 	content := l.line[start:l.column]
@@ -314,23 +800,42 @@ func (l *Lexer) readSharp() (Token, error) {
 	} else if content == "#f" || content == "#false" {
 		return Token{Content: content, Line: l.lineNo, TokenType: TokenTypeFalse}, nil
 	}
+	// a number with a radix or an exactness prefix
+	if len(content) > 2 && strings.IndexByte("xXoObBdDeEiI", content[1]) >= 0 {
+		if _, err := ParseNumber(content); err != nil {
+			return Token{}, fmt.Errorf("%s at line %d, column %d", err, l.lineNo, start+1)
+		}
+		return Token{Content: content, Line: l.lineNo, TokenType: TokenTypeNumber}, nil
+	}
 
 	return Token{}, fmt.Errorf("invalid token after #: %s at line %d, column %d", content, l.lineNo, start)
 }
 
+// NextToken returns the next token, its Line and Column are where it starts even when it spans
+// several lines like a multi-line string.
 func (l *Lexer) NextToken() Token {
+	tok := l.nextToken()
+	tok.Line = l.tokenLine
+	tok.Column = l.tokenColumn
+	return tok
+}
+
+func (l *Lexer) nextToken() Token {
 	for l.column == len(l.line) || isSpaceOrNewline(l.line[l.column]) || isComment(l.line[l.column]) || l.isLangDirective() {
 		if l.column == len(l.line) || l.isLangDirective() {
 			if !l.readNextLine() {
+				l.tokenLine, l.tokenColumn = l.lineNo, len(l.line)+1
 				return Token{TokenType: TokenTypeEOF, Line: l.lineNo}
 			}
 		}
 
 		l.skipWhitespace()
 		if !l.skipComment() {
+			l.tokenLine, l.tokenColumn = l.lineNo, len(l.line)+1
 			return Token{TokenType: TokenTypeEOF, Line: l.lineNo}
 		}
 	}
+	l.tokenLine, l.tokenColumn = l.lineNo, l.column+1
 
 	content := ""
 	firstChar := l.line[l.column]
@@ -346,9 +851,31 @@ func (l *Lexer) NextToken() Token {
 	case '(':
 		content = "("
 		tokenType = TokenTypeLeftParen
+		l.open(firstChar)
 	case ')':
+		if err := l.close(firstChar); err != nil {
+			return Token{Content: err.Error(), Line: l.lineNo, TokenType: TokenTypeInvalid}
+		}
 		content = ")"
 		tokenType = TokenTypeRightParen
+	case '[', ']':
+		if !l.brackets {
+			token, err := l.readIdentifierOrKeyword()
+			if err != nil {
+				return Token{Content: err.Error(), Line: l.lineNo, TokenType: TokenTypeInvalid}
+			}
+			return token
+		}
+		content = string(firstChar)
+		tokenType = TokenTypeLeftParen
+		if firstChar == '[' {
+			l.open(firstChar)
+			break
+		}
+		if err := l.close(firstChar); err != nil {
+			return Token{Content: err.Error(), Line: l.lineNo, TokenType: TokenTypeInvalid}
+		}
+		tokenType = TokenTypeRightParen
 		//TODO: handle +foo, -bar, *baz, /qux, these are all valid identifiers in scheme
 		// also *123, /123 are valid identifiers, but +123, -123 are not valid identifiers
 	case '+':
@@ -360,6 +887,9 @@ func (l *Lexer) NextToken() Token {
 				}
 				content = n
 				tokenType = TokenTypeNumber
+			} else if n, ok := l.readSpecialFloat(); ok {
+				content = n
+				tokenType = TokenTypeNumber
 			} else {
 				token, err := l.readIdentifierOrKeyword()
 				if err != nil {
@@ -373,13 +903,16 @@ func (l *Lexer) NextToken() Token {
 		}
 	case '-':
 		if hasNextChar && !isSpaceOrNewline(nextChar) {
-			if isDigit(nextChar) {
+			if isDigit(nextChar) && !l.isIncrementName() {
 				n, err := l.readNumber(true)
 				if err != nil {
 					return Token{Content: err.Error(), Line: l.lineNo, TokenType: TokenTypeInvalid}
 				}
 				content = n
 				tokenType = TokenTypeNumber
+			} else if n, ok := l.readSpecialFloat(); ok {
+				content = n
+				tokenType = TokenTypeNumber
 			} else {
 				token, err := l.readIdentifierOrKeyword()
 				if err != nil {
@@ -446,7 +979,7 @@ func (l *Lexer) NextToken() Token {
 		return tok
 
 	case '.':
-		if hasNextChar && !isSpaceOrNewline(nextChar) {
+		if hasNextChar && !l.isDelimiter(nextChar) {
 			if isDigit(nextChar) {
 				// .123
 				n, err := l.readNumber(false)
@@ -462,6 +995,10 @@ func (l *Lexer) NextToken() Token {
 				}
 				content = token.Content
 				tokenType = TokenTypeIdentifier
+			} else if l.isEllipsis() {
+				l.column += len("...") - 1
+				content = "..."
+				tokenType = TokenTypeIdentifier
 			} else {
 				content = fmt.Sprintf("invalid character '%c' after . at line %d, column %d", nextChar, l.lineNo, l.column)
 				return Token{Content: content, Line: l.lineNo, TokenType: TokenTypeInvalid}
@@ -472,7 +1009,7 @@ func (l *Lexer) NextToken() Token {
 		}
 
 	default:
-		if isDigit(firstChar) {
+		if isDigit(firstChar) && !l.isIncrementName() {
 			// do we need to handle 123a?
 			n, err := l.readNumber(true)
 			if err != nil {
@@ -1,6 +1,9 @@
 package lexer
 
 import (
+	"fmt"
+	"math"
+	"math/big"
 	"strings"
 	"testing"
 )
@@ -8,9 +11,11 @@ import (
 func TestLexer(t *testing.T) {
 	input := `
 (define if lambda let begin set! cond else and or not delay force cons-stream square > < >= <= + - * / ' "hello" 123 45.67)
-+abc -bcd *cd /de *123 /67 +123 -45.67 #t #f
++abc -bcd *cd /de *123 /67 +123 -45.67 #t #f 1e21 -1.5E-7
 . .a a.b
 true false
+letrec letrec* let*
+define-syntax ... a... define-macro guard parameterize delay-force assert
 `
 	l := New(strings.NewReader(input))
 	expectedTokens := []Token{
@@ -53,12 +58,25 @@ true false
 		{Content: "-45.67", Line: 3, TokenType: TokenTypeNumber},
 		{Content: "#t", Line: 3, TokenType: TokenTypeTrue},
 		{Content: "#f", Line: 3, TokenType: TokenTypeFalse},
+		{Content: "1e21", Line: 3, TokenType: TokenTypeNumber},
+		{Content: "-1.5E-7", Line: 3, TokenType: TokenTypeNumber},
 		{Content: ".", Line: 4, TokenType: TokenTypeDot},
 		{Content: ".a", Line: 4, TokenType: TokenTypeIdentifier},
 		{Content: "a.b", Line: 4, TokenType: TokenTypeIdentifier},
 		{Content: "true", Line: 5, TokenType: TokenTypeTrue},
 		{Content: "false", Line: 5, TokenType: TokenTypeFalse},
-		{Content: "", Line: 5, TokenType: TokenTypeEOF},
+		{Content: "letrec", Line: 6, TokenType: TokenTypeLetrec},
+		{Content: "letrec*", Line: 6, TokenType: TokenTypeLetrecStar},
+		{Content: "let*", Line: 6, TokenType: TokenTypeLetStar},
+		{Content: "define-syntax", Line: 7, TokenType: TokenTypeDefineSyntax},
+		{Content: "...", Line: 7, TokenType: TokenTypeIdentifier},
+		{Content: "a...", Line: 7, TokenType: TokenTypeIdentifier},
+		{Content: "define-macro", Line: 7, TokenType: TokenTypeDefineMacro},
+		{Content: "guard", Line: 7, TokenType: TokenTypeGuard},
+		{Content: "parameterize", Line: 7, TokenType: TokenTypeParameterize},
+		{Content: "delay-force", Line: 7, TokenType: TokenTypeDelayForce},
+		{Content: "assert", Line: 7, TokenType: TokenTypeAssert},
+		{Content: "", Line: 7, TokenType: TokenTypeEOF},
 	}
 
 	for i, expected := range expectedTokens {
@@ -66,8 +84,318 @@ true false
 		if tok.TokenType == TokenTypeInvalid {
 			t.Fatalf("unexpected error at token %d: %v", i, tok.Content)
 		}
-		if tok != expected {
+		if tok.Content != expected.Content || tok.Line != expected.Line || tok.TokenType != expected.TokenType {
 			t.Fatalf("unexpected token at %d: got %+v, want %+v", i, tok, expected)
 		}
 	}
 }
+
+func TestLexer_StringEscapes(t *testing.T) {
+	input := `"a\"b\\c\nd\te" "multi
+line"`
+	l := New(strings.NewReader(input))
+	for _, expected := range []string{"a\"b\\c\nd\te", "multi\nline"} {
+		tok := l.NextToken()
+		if tok.TokenType != TokenTypeString || tok.Content != expected {
+			t.Fatalf("unexpected token: got %+v, want string %q", tok, expected)
+		}
+		if got := QuoteString(expected); New(strings.NewReader(got)).NextToken().Content != expected {
+			t.Fatalf("QuoteString(%q) = %s does not read back", expected, got)
+		}
+	}
+}
+
+func TestLexer_Columns(t *testing.T) {
+	input := `(define x "two
+lines")
+  (f -1) ; done`
+	l := New(strings.NewReader(input))
+	expected := []struct {
+		content string
+		line    int
+		column  int
+	}{
+		{"(", 1, 1},
+		{"define", 1, 2},
+		{"x", 1, 9},
+		{"two\nlines", 1, 11},
+		{")", 2, 7},
+		{"(", 3, 3},
+		{"f", 3, 4},
+		{"-1", 3, 6},
+		{")", 3, 8},
+		{"", 3, 16},
+	}
+	for _, e := range expected {
+		tok := l.NextToken()
+		if tok.Content != e.content || tok.Line != e.line || tok.Column != e.column {
+			t.Fatalf("unexpected token: got %+v, want %q at %d:%d", tok, e.content, e.line, e.column)
+		}
+	}
+}
+
+func TestLexer_Brackets(t *testing.T) {
+	input := "#lang racket/base\n(let ([x 1]) x)"
+	l := New(strings.NewReader(input))
+	l.AcceptBrackets()
+	expected := []struct {
+		content   string
+		tokenType TokenType
+	}{
+		{"(", TokenTypeLeftParen},
+		{"let", TokenTypeLet},
+		{"(", TokenTypeLeftParen},
+		{"[", TokenTypeLeftParen},
+		{"x", TokenTypeIdentifier},
+		{"1", TokenTypeNumber},
+		{"]", TokenTypeRightParen},
+		{")", TokenTypeRightParen},
+		{"x", TokenTypeIdentifier},
+		{")", TokenTypeRightParen},
+		{"", TokenTypeEOF},
+	}
+	for _, e := range expected {
+		tok := l.NextToken()
+		if tok.Content != e.content || tok.TokenType != e.tokenType {
+			t.Fatalf("unexpected token: got %+v, want %s %q", tok, e.tokenType, e.content)
+		}
+	}
+
+	l = New(strings.NewReader("(f [x)]"))
+	l.AcceptBrackets()
+	for range 4 {
+		l.NextToken()
+	}
+	if tok := l.NextToken(); tok.TokenType != TokenTypeInvalid || tok.Content != "')' at line 1, column 6 closes a '['" {
+		t.Fatalf("unexpected token for a mismatched paren: %+v", tok)
+	}
+
+	// without AcceptBrackets a bracket is part of an identifier
+	if tok := New(strings.NewReader("[x]")).NextToken(); tok.TokenType != TokenTypeIdentifier || tok.Content != "[x]" {
+		t.Fatalf("unexpected token for a bracket: %+v", tok)
+	}
+}
+
+func TestLexer_IncrementNames(t *testing.T) {
+	l := New(strings.NewReader("(1+ 1) (-1+ 2) 1 -1"))
+	expected := []Token{
+		{Content: "(", TokenType: TokenTypeLeftParen},
+		{Content: "1+", TokenType: TokenTypeIdentifier},
+		{Content: "1", TokenType: TokenTypeNumber},
+		{Content: ")", TokenType: TokenTypeRightParen},
+		{Content: "(", TokenType: TokenTypeLeftParen},
+		{Content: "-1+", TokenType: TokenTypeIdentifier},
+		{Content: "2", TokenType: TokenTypeNumber},
+		{Content: ")", TokenType: TokenTypeRightParen},
+		{Content: "1", TokenType: TokenTypeNumber},
+		{Content: "-1", TokenType: TokenTypeNumber},
+	}
+	for _, e := range expected {
+		if tok := l.NextToken(); tok.Content != e.Content || tok.TokenType != e.TokenType {
+			t.Fatalf("unexpected token: got %+v, want %s %q", tok, e.TokenType, e.Content)
+		}
+	}
+	if tok := New(strings.NewReader("1+2")).NextToken(); tok.TokenType != TokenTypeInvalid {
+		t.Fatalf("expected 1+2 to be invalid, got %+v", tok)
+	}
+}
+
+func TestLexer_PrefixedNumbers(t *testing.T) {
+	tests := []struct {
+		input string
+		value any
+	}{
+		{"#x1F", int64(31)},
+		{"#XfF", int64(255)},
+		{"#x-1a", int64(-26)},
+		{"#b1010", int64(10)},
+		{"#b+101", int64(5)},
+		{"#o17", int64(15)},
+		{"#d10", int64(10)},
+		{"#e2.", int64(2)},
+		{"#e1e3", int64(1000)},
+		{"#e1e20", new(big.Int).Exp(big.NewInt(10), big.NewInt(20), nil)},
+		{"#i3", float64(3)},
+		{"#x#i10", float64(16)},
+		{"#i#b11", float64(3)},
+		{"#x10000000000000000", new(big.Int).Lsh(big.NewInt(1), 64)},
+		{"#i#x10000000000000000", float64(1 << 64)},
+		{"-18446744073709551616", new(big.Int).Lsh(big.NewInt(-1), 64)},
+		{"#i1/2", 0.5},
+		{"#e1.25", big.NewRat(5, 4)},
+		{"#e0.1", big.NewRat(1, 10)},
+		{"#x-1/A", big.NewRat(-1, 10)},
+		{"#x4/2", int64(2)},
+	}
+	for _, tt := range tests {
+		tok := New(strings.NewReader(tt.input)).NextToken()
+		if tok.TokenType != TokenTypeNumber || tok.Content != tt.input {
+			t.Fatalf("unexpected token for %s: %+v", tt.input, tok)
+		}
+		// a *big.Rat prints as its fraction
+		if value, err := ParseNumber(tok.Content); err != nil || fmt.Sprintf("%T %v", value, value) != fmt.Sprintf("%T %v", tt.value, tt.value) {
+			t.Fatalf("%s parsed as %v (%T), %v, want %v (%T)", tt.input, value, value, err, tt.value, tt.value)
+		}
+	}
+
+	invalid := []struct {
+		input   string
+		message string
+	}{
+		{"#x1.5", "invalid number #x1.5 at line 1, column 1"},
+		{"#xg", "invalid number #xg"},
+		{"#b102", "invalid number #b102"},
+		{"#x+-1", "invalid number #x+-1"},
+		{"#d1e", "invalid number #d1e"},
+		{"#e1e1000", "the exponent of #e1e1000 is too large for an exact number"},
+		{"#x1/0", "#x1/0 divides by zero"},
+		{"#x1/-2", "invalid number #x1/-2"},
+		{"#b1/2", "invalid number #b1/2"},
+		{"#e#i1", "#e#i1 has two exactness prefixes"},
+		{"#x#b1", "#x#b1 has two radix prefixes"},
+		{"#xz#e1", "invalid number #xz#e1"},
+	}
+	for _, tt := range invalid {
+		tok := New(strings.NewReader(tt.input)).NextToken()
+		if tok.TokenType != TokenTypeInvalid || !strings.Contains(tok.Content, tt.message) {
+			t.Fatalf("unexpected token for %s: %+v, want an error %q", tt.input, tok, tt.message)
+		}
+	}
+}
+
+func TestLexer_Rationals(t *testing.T) {
+	l := New(strings.NewReader("(f 1/3 -7/2 +1/2 10/4) /2"))
+	expected := []Token{
+		{Content: "(", TokenType: TokenTypeLeftParen},
+		{Content: "f", TokenType: TokenTypeIdentifier},
+		{Content: "1/3", TokenType: TokenTypeNumber},
+		{Content: "-7/2", TokenType: TokenTypeNumber},
+		{Content: "+1/2", TokenType: TokenTypeNumber},
+		{Content: "10/4", TokenType: TokenTypeNumber},
+		{Content: ")", TokenType: TokenTypeRightParen},
+		{Content: "/2", TokenType: TokenTypeIdentifier},
+	}
+	for _, e := range expected {
+		if tok := l.NextToken(); tok.Content != e.Content || tok.TokenType != e.TokenType {
+			t.Fatalf("unexpected token: got %+v, want %s %q", tok, e.TokenType, e.Content)
+		}
+	}
+	if value, err := ParseNumber("10/4"); err != nil || value.(*big.Rat).Cmp(big.NewRat(5, 2)) != 0 {
+		t.Fatalf("10/4 parsed as %v, %v", value, err)
+	}
+
+	for _, input := range []string{"1/x", "1/2/3", "1.5/2", ".5/2", "1e2/3", "1/0"} {
+		if tok := New(strings.NewReader(input)).NextToken(); tok.TokenType != TokenTypeInvalid {
+			t.Fatalf("expected %s to be invalid, got %+v", input, tok)
+		}
+	}
+}
+
+func TestLexer_SpecialFloats(t *testing.T) {
+	l := New(strings.NewReader("(f +inf.0 -inf.0 +nan.0 -nan.0 #i+inf.0 +inf +inf.0x +i)"))
+	expected := []Token{
+		{Content: "(", TokenType: TokenTypeLeftParen},
+		{Content: "f", TokenType: TokenTypeIdentifier},
+		{Content: "+inf.0", TokenType: TokenTypeNumber},
+		{Content: "-inf.0", TokenType: TokenTypeNumber},
+		{Content: "+nan.0", TokenType: TokenTypeNumber},
+		{Content: "-nan.0", TokenType: TokenTypeNumber},
+		{Content: "#i+inf.0", TokenType: TokenTypeNumber},
+		{Content: "+inf", TokenType: TokenTypeIdentifier},
+		{Content: "+inf.0x", TokenType: TokenTypeIdentifier},
+		{Content: "+i", TokenType: TokenTypeIdentifier},
+		{Content: ")", TokenType: TokenTypeRightParen},
+	}
+	for _, e := range expected {
+		if tok := l.NextToken(); tok.Content != e.Content || tok.TokenType != e.TokenType {
+			t.Fatalf("unexpected token: got %+v, want %s %q", tok, e.TokenType, e.Content)
+		}
+	}
+	if value, err := ParseNumber("-inf.0"); err != nil || !math.IsInf(value.(float64), -1) {
+		t.Fatalf("-inf.0 parsed as %v, %v", value, err)
+	}
+	if value, err := ParseNumber("+nan.0"); err != nil || !math.IsNaN(value.(float64)) {
+		t.Fatalf("+nan.0 parsed as %v, %v", value, err)
+	}
+	if _, err := ParseNumber("#e+inf.0"); err == nil {
+		t.Fatalf("expected #e+inf.0 to be invalid")
+	}
+}
+
+func TestLexer_VectorPrefix(t *testing.T) {
+	l := New(strings.NewReader("#(1 #()) '#(a)"))
+	expected := []Token{
+		{Content: "#", TokenType: TokenTypeVectorPrefix},
+		{Content: "(", TokenType: TokenTypeLeftParen},
+		{Content: "1", TokenType: TokenTypeNumber},
+		{Content: "#", TokenType: TokenTypeVectorPrefix},
+		{Content: "(", TokenType: TokenTypeLeftParen},
+		{Content: ")", TokenType: TokenTypeRightParen},
+		{Content: ")", TokenType: TokenTypeRightParen},
+		{Content: "'", TokenType: TokenTypeQuote},
+		{Content: "#", TokenType: TokenTypeVectorPrefix},
+		{Content: "(", TokenType: TokenTypeLeftParen},
+		{Content: "a", TokenType: TokenTypeIdentifier},
+		{Content: ")", TokenType: TokenTypeRightParen},
+	}
+	for _, e := range expected {
+		if tok := l.NextToken(); tok.Content != e.Content || tok.TokenType != e.TokenType {
+			t.Fatalf("unexpected token: got %+v, want %s %q", tok, e.TokenType, e.Content)
+		}
+	}
+	if tok := New(strings.NewReader("# (1)")).NextToken(); tok.TokenType != TokenTypeInvalid {
+		t.Fatalf("expected # followed by a space to be invalid, got %+v", tok)
+	}
+}
+
+func TestLexer_Chars(t *testing.T) {
+	l := New(strings.NewReader(`#\a #\space #\( #\) #\x41 #\é(#\x)`))
+	expected := []Token{
+		{Content: `#\a`, TokenType: TokenTypeChar},
+		{Content: `#\space`, TokenType: TokenTypeChar},
+		{Content: `#\(`, TokenType: TokenTypeChar},
+		{Content: `#\)`, TokenType: TokenTypeChar},
+		{Content: `#\x41`, TokenType: TokenTypeChar},
+		{Content: `#\é`, TokenType: TokenTypeChar},
+		{Content: "(", TokenType: TokenTypeLeftParen},
+		{Content: `#\x`, TokenType: TokenTypeChar},
+		{Content: ")", TokenType: TokenTypeRightParen},
+	}
+	for _, e := range expected {
+		if tok := l.NextToken(); tok.Content != e.Content || tok.TokenType != e.TokenType {
+			t.Fatalf("unexpected token: got %+v, want %s %q", tok, e.TokenType, e.Content)
+		}
+	}
+	for _, input := range []string{`#\foo`, `#\xzz`, `#\`} {
+		if tok := New(strings.NewReader(input)).NextToken(); tok.TokenType != TokenTypeInvalid {
+			t.Fatalf("expected %s to be invalid, got %+v", input, tok)
+		}
+	}
+
+	inputs := []struct {
+		content string
+		char    rune
+		printed string
+	}{
+		{`#\a`, 'a', `#\a`},
+		{`#\space`, ' ', `#\space`},
+		{`#\newline`, '\n', `#\newline`},
+		{`#\nul`, 0, `#\null`},
+		{`#\x41`, 'A', `#\A`},
+		{`#\x7f`, 0x7f, `#\delete`},
+		{`#\x200b`, 0x200b, `#\x200b`},
+		{`#\λ`, 'λ', `#\λ`},
+	}
+	for _, input := range inputs {
+		r, err := ParseChar(input.content)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", input.content, err)
+		}
+		if r != input.char {
+			t.Fatalf("expected %s to be %q, got %q", input.content, input.char, r)
+		}
+		if printed := CharString(r); printed != input.printed {
+			t.Fatalf("expected %q to print as %s, got %s", r, input.printed, printed)
+		}
+	}
+}
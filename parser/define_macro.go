@@ -0,0 +1,106 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ocowchun/soup/lexer"
+)
+
+// A macro defined with define-macro is expanded by a procedure, its transformer, written in soup
+// rather than as syntax-rules patterns. The transformer is called with the operands of a use as
+// data, unevaluated, and returns the form to parse in place of the use. Unlike the expansions of
+// syntax-rules, the form isn't renamed: what it binds can capture the variables of the code the
+// macro is used in.
+//
+// The parser can't call a procedure, a MacroExpander does it for the parser, see
+// Macros.SetExpander. The transformer is evaluated in the global environment when the macro is
+// first used, which is while the program is parsed: it can use the definitions of the programs
+// evaluated before, but not the ones of the program it's in.
+
+// A MacroExpander calls the transformer of the define-macro name with the operands of a use of
+// the macro, given as their source to be read as data, and returns the source of the form the use
+// expands to.
+type MacroExpander func(name string, transformer Expression, operands []string) (string, error)
+
+// parseDefineMacroExpression parses (define-macro (name param ...) body ...), whose transformer is
+// (lambda (param ...) body ...), or (define-macro name transformer). From then on the parser
+// expands the uses of name.
+func (p *Parser) parseDefineMacroExpression() (Expression, error) {
+	firstToken := p.currentToken
+	// the rest reads like a definition
+	exp, err := p.parseDefineExpression()
+	if err != nil {
+		return nil, err
+	}
+	def := exp.(*DefineExpression)
+	if _, ok := lexer.LookupKeyword(def.Name); ok {
+		return nil, NewParsingError(firstToken, fmt.Sprintf("'%s' is a builtin, it can't be a macro name", def.Name))
+	}
+
+	p.macros.DefineTransformer(def.Name, def.Value)
+	return &DefineMacroExpression{LeftParenToken: firstToken, Name: def.Name, Transformer: def.Value}, nil
+}
+
+// parseTransformerUse expands the use of the define-macro whose keyword is the current token, and
+// parses the expansion in its place.
+func (p *Parser) parseTransformerUse(transformer Expression) (Expression, error) {
+	open := p.prevToken
+	keyword, err := p.readSyntax()
+	if err != nil {
+		return nil, err
+	}
+	form, err := p.readSyntaxList(open, []*syntax{keyword})
+	if err != nil {
+		return nil, err
+	}
+	name := keyword.token.Content
+
+	if p.expanding >= maxExpansionDepth {
+		return nil, NewParsingError(keyword.token, fmt.Sprintf("expansions of %s nested more than %d deep, does it expand to itself?", name, maxExpansionDepth))
+	}
+	expander := p.macros.expanderOf()
+	if expander == nil {
+		return nil, NewParsingError(keyword.token, fmt.Sprintf("%s is a define-macro, only a parser using the macros of an evaluator can expand it", name))
+	}
+	if form.tail != nil {
+		return nil, NewParsingError(form.tail.token, fmt.Sprintf("a use of %s can't be a dotted list", name))
+	}
+	operands := make([]string, len(form.items)-1)
+	for i, item := range form.items[1:] {
+		operands[i] = item.String()
+	}
+	src, err := expander(name, transformer, operands)
+	if err != nil {
+		return nil, NewParsingError(keyword.token, fmt.Sprintf("expanding %s: %s", name, err))
+	}
+
+	expanded, err := readExpansion(src)
+	if err != nil {
+		return nil, NewParsingError(keyword.token, fmt.Sprintf("the expansion of %s, %s, %s", name, src, err))
+	}
+	// the expansion is reported where the macro is used
+	tokens := expanded.tokens(nil)
+	for i := range tokens {
+		tokens[i].Line = open.Line
+		tokens[i].Column = open.Column
+	}
+	return p.parseExpansion(tokens)
+}
+
+// readExpansion reads the form a transformer returned the source of.
+func readExpansion(src string) (*syntax, error) {
+	r := New(lexer.New(strings.NewReader(src)))
+	r.nextToken()
+	if r.currentToken.TokenType == lexer.TokenTypeEOF {
+		return nil, fmt.Errorf("is empty")
+	}
+	form, err := r.readSyntax()
+	if err != nil {
+		return nil, fmt.Errorf("doesn't read: %w", err)
+	}
+	if r.currentToken.TokenType != lexer.TokenTypeEOF {
+		return nil, fmt.Errorf("is more than one form")
+	}
+	return form, nil
+}
@@ -3,6 +3,7 @@ package parser
 import (
 	"fmt"
 	"strings"
+	"sync/atomic"
 
 	"github.com/ocowchun/soup/lexer"
 )
@@ -15,6 +16,9 @@ type Expression interface {
 
 type NumberLiteral struct {
 	NumToken lexer.Token
+	// Value is the number the token spells, parsed once rather than whenever the literal is
+	// evaluated: an int64 for an integer that fits one, a float64 otherwise
+	Value any
 }
 
 func (n *NumberLiteral) expressionNode() {}
@@ -25,21 +29,72 @@ func (n *NumberLiteral) Token() lexer.Token {
 	return n.NumToken
 }
 
+// LiteralCache holds the value an evaluator built for a literal the first time it evaluated it, so
+// later evaluations return the same value and the value lives exactly as long as the expression.
+// It's safe for concurrent use by evaluators sharing a program.
+type LiteralCache struct {
+	value atomic.Value
+}
+
+// Load returns the cached value, nil if there's none yet.
+func (c *LiteralCache) Load() any {
+	return c.value.Load()
+}
+
+// LoadOrStore returns the cached value, caching v first if there's none yet.
+func (c *LiteralCache) LoadOrStore(v any) any {
+	if c.value.CompareAndSwap(nil, v) {
+		return v
+	}
+	return c.value.Load()
+}
+
 type StringLiteral struct {
 	StrToken lexer.Token
 	Value    string
+	Cache    LiteralCache
 }
 
 func (s *StringLiteral) expressionNode() {
 }
 
 func (s *StringLiteral) String() string {
-	return fmt.Sprintf("\"%s\"", s.Value)
+	return lexer.QuoteString(s.Value)
 }
 func (s *StringLiteral) Token() lexer.Token {
 	return s.StrToken
 }
 
+// DatumExpression is a value that has no source, read from a TokenTypeDatum token. It evaluates to
+// the value, quoted or not.
+type DatumExpression struct {
+	DatumToken lexer.Token
+	Value      any
+}
+
+func (d *DatumExpression) expressionNode() {}
+
+func (d *DatumExpression) String() string {
+	return fmt.Sprintf("%v", d.Value)
+}
+func (d *DatumExpression) Token() lexer.Token {
+	return d.DatumToken
+}
+
+type CharLiteral struct {
+	CharToken lexer.Token
+	Value     rune
+}
+
+func (c *CharLiteral) expressionNode() {}
+
+func (c *CharLiteral) String() string {
+	return lexer.CharString(c.Value)
+}
+func (c *CharLiteral) Token() lexer.Token {
+	return c.CharToken
+}
+
 type CallExpression struct {
 	LeftParenToken lexer.Token
 	Operator       Expression
@@ -124,6 +179,9 @@ func (l *LambdaExpression) String() string {
 			b.WriteString(" ")
 		}
 	}
+	if l.OptionalTailParameter != "" {
+		b.WriteString(" . " + l.OptionalTailParameter)
+	}
 	b.WriteString(")")
 	for _, expr := range l.Body {
 		b.WriteString(" ")
@@ -156,6 +214,9 @@ func (d *DefineExpression) String() string {
 				b.WriteString(param)
 			}
 		}
+		if lambda.OptionalTailParameter != "" {
+			b.WriteString(" . " + lambda.OptionalTailParameter)
+		}
 		b.WriteString(")")
 
 		for _, expr := range lambda.Body {
@@ -176,29 +237,39 @@ func (d *DefineExpression) Token() lexer.Token {
 type ListExpression struct {
 	LeftParenToken lexer.Token
 	Elements       []Expression
+	Cache          LiteralCache
 }
 
 func (l *ListExpression) expressionNode() {}
 
 func (l *ListExpression) String() string {
-	var b strings.Builder
-	b.WriteString("'(")
-	for i, elem := range l.Elements {
-		b.WriteString(elem.String())
-		if i != len(l.Elements)-1 {
-			b.WriteString(" ")
-		}
-	}
-	b.WriteString(")")
-	return b.String()
+	return "'" + datumString(l)
 }
 func (l *ListExpression) Token() lexer.Token {
 	return l.LeftParenToken
 }
 
+// VectorExpression is a vector literal, #(1 2 3), its elements are data like the elements of a
+// quoted list. It evaluates to itself, quoted or not.
+type VectorExpression struct {
+	PrefixToken lexer.Token
+	Elements    []Expression
+	Cache       LiteralCache
+}
+
+func (v *VectorExpression) expressionNode() {}
+
+func (v *VectorExpression) String() string {
+	return datumString(v)
+}
+func (v *VectorExpression) Token() lexer.Token {
+	return v.PrefixToken
+}
+
 type SymbolExpression struct {
 	FirstToken lexer.Token
 	Value      string
+	Cache      LiteralCache
 }
 
 func (s *SymbolExpression) expressionNode() {}
@@ -212,12 +283,35 @@ func (s *SymbolExpression) Token() lexer.Token {
 type NestedSymbolExpression struct {
 	QuoteToken lexer.Token
 	Value      Expression
+	Cache      LiteralCache
 }
 
 func (s *NestedSymbolExpression) expressionNode() {}
 func (s *NestedSymbolExpression) String() string {
 	return fmt.Sprintf("'%s", s.Value)
 }
+
+// datumString writes exp as an element of a quoted list, where symbols and lists have no quote.
+func datumString(exp Expression) string {
+	switch exp := exp.(type) {
+	case *SymbolExpression:
+		return exp.Value
+	case *ListExpression:
+		var b strings.Builder
+		b.WriteString("(")
+		for i, elem := range exp.Elements {
+			b.WriteString(datumString(elem))
+			if i != len(exp.Elements)-1 {
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString(")")
+		return b.String()
+	case *VectorExpression:
+		return "#" + datumString(&ListExpression{Elements: exp.Elements})
+	}
+	return exp.String()
+}
 func (s *NestedSymbolExpression) Token() lexer.Token {
 	return s.QuoteToken
 }
@@ -262,8 +356,10 @@ func (v *voidExpression) expressionNode() {}
 func (v *voidExpression) String() string {
 	return ""
 }
+
+// Token returns a token without a position, Void stands for every missing expression of a program.
 func (v *voidExpression) Token() lexer.Token {
-	panic("fix it later")
+	return lexer.Token{TokenType: lexer.TokenTypeNone}
 }
 
 var Void = &voidExpression{}
@@ -280,22 +376,34 @@ func (b *booleanLiteral) String() string {
 		return "#f"
 	}
 }
+
+// Token returns a token without a position, TrueLiteral and FalseLiteral are shared by every #t and
+// #f of a program.
 func (b *booleanLiteral) Token() lexer.Token {
-	panic("fix it later")
+	if b.Value {
+		return lexer.Token{Content: "#t", TokenType: lexer.TokenTypeTrue}
+	}
+	return lexer.Token{Content: "#f", TokenType: lexer.TokenTypeFalse}
 }
 
 var TrueLiteral = &booleanLiteral{Value: true}
 var FalseLiteral = &booleanLiteral{Value: false}
 
+// DelayExpression is (delay expression), or (delay-force expression) when Force is set.
 type DelayExpression struct {
 	DelayToken lexer.Token
 	Expression Expression
+	Force      bool
 }
 
 func (d *DelayExpression) expressionNode() {}
 func (d *DelayExpression) String() string {
 	var sb strings.Builder
-	sb.WriteString("(delay ")
+	if d.Force {
+		sb.WriteString("(delay-force ")
+	} else {
+		sb.WriteString("(delay ")
+	}
 	sb.WriteString(d.Expression.String())
 	sb.WriteString(")")
 	return sb.String()
@@ -325,3 +433,331 @@ func (s *StreamExpression) String() string {
 func (s *StreamExpression) Token() lexer.Token {
 	return s.ConsStreamToken
 }
+
+// AmbExpression evaluates to one of its choices, see https://sarabander.github.io/sicp/html/4_002e3.xhtml
+type AmbExpression struct {
+	AmbToken lexer.Token
+	Choices  []Expression
+}
+
+func (a *AmbExpression) expressionNode() {}
+func (a *AmbExpression) String() string {
+	var sb strings.Builder
+	sb.WriteString("(amb")
+	for _, choice := range a.Choices {
+		sb.WriteString(" ")
+		sb.WriteString(choice.String())
+	}
+	sb.WriteString(")")
+	return sb.String()
+}
+
+func (a *AmbExpression) Token() lexer.Token {
+	return a.AmbToken
+}
+
+// LibraryExport exports the binding Internal under the name External, they differ for (rename internal external).
+type LibraryExport struct {
+	Internal string
+	External string
+}
+
+type DefineLibraryExpression struct {
+	DefineLibraryToken lexer.Token
+	Name               []string
+	Exports            []LibraryExport
+	Imports            []*ImportSet
+	Body               []Expression
+}
+
+func (d *DefineLibraryExpression) expressionNode() {}
+func (d *DefineLibraryExpression) String() string {
+	var sb strings.Builder
+	sb.WriteString("(define-library ")
+	sb.WriteString(LibraryName(d.Name))
+	if len(d.Exports) > 0 {
+		sb.WriteString(" (export")
+		for _, export := range d.Exports {
+			if export.Internal == export.External {
+				sb.WriteString(" " + export.External)
+			} else {
+				sb.WriteString(fmt.Sprintf(" (rename %s %s)", export.Internal, export.External))
+			}
+		}
+		sb.WriteString(")")
+	}
+	if len(d.Imports) > 0 {
+		sb.WriteString(" (import")
+		for _, set := range d.Imports {
+			sb.WriteString(" " + set.String())
+		}
+		sb.WriteString(")")
+	}
+	if len(d.Body) > 0 {
+		sb.WriteString(" (begin")
+		for _, exp := range d.Body {
+			sb.WriteString(" " + exp.String())
+		}
+		sb.WriteString(")")
+	}
+	sb.WriteString(")")
+	return sb.String()
+}
+
+func (d *DefineLibraryExpression) Token() lexer.Token {
+	return d.DefineLibraryToken
+}
+
+type ImportExpression struct {
+	ImportToken lexer.Token
+	Sets        []*ImportSet
+}
+
+func (i *ImportExpression) expressionNode() {}
+func (i *ImportExpression) String() string {
+	var sb strings.Builder
+	sb.WriteString("(import")
+	for _, set := range i.Sets {
+		sb.WriteString(" " + set.String())
+	}
+	sb.WriteString(")")
+	return sb.String()
+}
+
+func (i *ImportExpression) Token() lexer.Token {
+	return i.ImportToken
+}
+
+// RequireExpression is a Racket (require spec ...), see Parser.Racket. Files holds the specs that are
+// file names, the others name Racket libraries.
+type RequireExpression struct {
+	RequireToken lexer.Token
+	Files        []string
+	Specs        []string
+}
+
+func (r *RequireExpression) expressionNode() {}
+func (r *RequireExpression) String() string {
+	return "(require" + joinSpecs(r.Specs) + ")"
+}
+
+func (r *RequireExpression) Token() lexer.Token {
+	return r.RequireToken
+}
+
+// ProvideExpression is a Racket (provide spec ...), see Parser.Racket.
+type ProvideExpression struct {
+	ProvideToken lexer.Token
+	Specs        []string
+}
+
+func (p *ProvideExpression) expressionNode() {}
+func (p *ProvideExpression) String() string {
+	return "(provide" + joinSpecs(p.Specs) + ")"
+}
+
+func (p *ProvideExpression) Token() lexer.Token {
+	return p.ProvideToken
+}
+
+func joinSpecs(specs []string) string {
+	var sb strings.Builder
+	for _, spec := range specs {
+		sb.WriteString(" " + spec)
+	}
+	return sb.String()
+}
+
+type ImportModifier int
+
+const (
+	ImportLibrary ImportModifier = iota
+	ImportOnly
+	ImportExcept
+	ImportPrefix
+	ImportRename
+)
+
+// ImportSet is either a library name, or a modifier applied to an inner import set, e.g.
+// (prefix (only (soup math) square) m:)
+type ImportSet struct {
+	Token       lexer.Token
+	Modifier    ImportModifier
+	Library     []string
+	Inner       *ImportSet
+	Identifiers []string
+	Prefix      string
+	Renames     []LibraryExport
+}
+
+func (s *ImportSet) String() string {
+	switch s.Modifier {
+	case ImportOnly:
+		return fmt.Sprintf("(only %s %s)", s.Inner.String(), strings.Join(s.Identifiers, " "))
+	case ImportExcept:
+		return fmt.Sprintf("(except %s %s)", s.Inner.String(), strings.Join(s.Identifiers, " "))
+	case ImportPrefix:
+		return fmt.Sprintf("(prefix %s %s)", s.Inner.String(), s.Prefix)
+	case ImportRename:
+		var sb strings.Builder
+		sb.WriteString("(rename " + s.Inner.String())
+		for _, rename := range s.Renames {
+			sb.WriteString(fmt.Sprintf(" (%s %s)", rename.Internal, rename.External))
+		}
+		sb.WriteString(")")
+		return sb.String()
+	default:
+		return LibraryName(s.Library)
+	}
+}
+
+// LibraryName formats the parts of a library name the way they are written, e.g. (soup math).
+func LibraryName(parts []string) string {
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+// DefineSyntaxExpression is (define-syntax name (syntax-rules ...)). The parser expands the uses of
+// the macro, evaluating it adds the macro to the ones the evaluator's later programs can use.
+type DefineSyntaxExpression struct {
+	LeftParenToken lexer.Token
+	Name           string
+	Rules          *SyntaxRules
+}
+
+func (d *DefineSyntaxExpression) expressionNode() {}
+
+func (d *DefineSyntaxExpression) String() string {
+	return fmt.Sprintf("(define-syntax %s %s)", d.Name, d.Rules)
+}
+
+func (d *DefineSyntaxExpression) Token() lexer.Token {
+	return d.LeftParenToken
+}
+
+// DefineMacroExpression is (define-macro (name param ...) body ...) or (define-macro name
+// transformer). The parser expands the uses of the macro, evaluating it adds the macro to the ones
+// the evaluator's later programs can use.
+type DefineMacroExpression struct {
+	LeftParenToken lexer.Token
+	Name           string
+	Transformer    Expression
+}
+
+func (d *DefineMacroExpression) expressionNode() {}
+
+func (d *DefineMacroExpression) String() string {
+	// the rest is written like the definition it reads like
+	def := &DefineExpression{Name: d.Name, Value: d.Transformer}
+	return "(define-macro" + strings.TrimPrefix(def.String(), "(define")
+}
+
+func (d *DefineMacroExpression) Token() lexer.Token {
+	return d.LeftParenToken
+}
+
+// GuardExpression is (guard (variable clause ...) body ...). When the body raises, the object
+// raised is bound to Variable and the clauses are tried like those of cond, the exception is
+// raised again when none matches.
+type GuardExpression struct {
+	GuardToken lexer.Token
+	Variable   string
+	Clauses    []GuardClause
+	Body       []Expression
+}
+
+// GuardClause is a clause of guard, the else clause has no Test. The value of a clause without a
+// Body is the value of Test, Body is the receiver Test's value is passed to when Arrow is set.
+type GuardClause struct {
+	Test  Expression
+	Arrow bool
+	Body  []Expression
+}
+
+func (g *GuardExpression) expressionNode() {}
+
+func (g *GuardExpression) String() string {
+	var sb strings.Builder
+	sb.WriteString("(guard (")
+	sb.WriteString(g.Variable)
+	for _, clause := range g.Clauses {
+		sb.WriteString(" (")
+		if clause.Test == nil {
+			sb.WriteString("else")
+		} else {
+			sb.WriteString(clause.Test.String())
+		}
+		if clause.Arrow {
+			sb.WriteString(" =>")
+		}
+		for _, exp := range clause.Body {
+			sb.WriteString(" ")
+			sb.WriteString(exp.String())
+		}
+		sb.WriteString(")")
+	}
+	sb.WriteString(")")
+	for _, exp := range g.Body {
+		sb.WriteString(" ")
+		sb.WriteString(exp.String())
+	}
+	sb.WriteString(")")
+	return sb.String()
+}
+
+func (g *GuardExpression) Token() lexer.Token {
+	return g.GuardToken
+}
+
+// ParameterizeExpression is (parameterize ((parameter value) ...) body ...), the body is evaluated
+// with each of Parameters, an expression evaluating to a parameter object, bound to its value.
+type ParameterizeExpression struct {
+	ParameterizeToken lexer.Token
+	Parameters        []Expression
+	Values            []Expression
+	Body              []Expression
+}
+
+func (p *ParameterizeExpression) expressionNode() {}
+
+func (p *ParameterizeExpression) String() string {
+	var sb strings.Builder
+	sb.WriteString("(parameterize (")
+	for i, parameter := range p.Parameters {
+		if i > 0 {
+			sb.WriteString(" ")
+		}
+		sb.WriteString("(")
+		sb.WriteString(parameter.String())
+		sb.WriteString(" ")
+		sb.WriteString(p.Values[i].String())
+		sb.WriteString(")")
+	}
+	sb.WriteString(")")
+	for _, exp := range p.Body {
+		sb.WriteString(" ")
+		sb.WriteString(exp.String())
+	}
+	sb.WriteString(")")
+	return sb.String()
+}
+
+func (p *ParameterizeExpression) Token() lexer.Token {
+	return p.ParameterizeToken
+}
+
+// AssertExpression is (assert expression), which raises an error describing Expression when it
+// evaluates to #f.
+type AssertExpression struct {
+	AssertToken lexer.Token
+	Expression  Expression
+}
+
+func (a *AssertExpression) expressionNode() {}
+
+func (a *AssertExpression) String() string {
+	return "(assert " + a.Expression.String() + ")"
+}
+
+func (a *AssertExpression) Token() lexer.Token {
+	return a.AssertToken
+}
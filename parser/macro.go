@@ -0,0 +1,870 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ocowchun/soup/lexer"
+)
+
+// Macros defined with define-syntax are expanded while parsing: a form whose keyword names one is
+// read as data, matched against the patterns of its syntax-rules, and the template of the first
+// rule that matches is parsed in its place. The evaluator only sees what macros expand to.
+//
+// Expansions are hygienic by renaming. The identifiers a template introduces, rather than the ones
+// its pattern variables stand for, are renamed to a name of their own for each expansion, so the
+// bindings an expansion makes can't capture the variables of the code the macro is used in. An
+// introduced identifier the expansion doesn't bind refers to the global binding of its original
+// name, see IntroducedName.
+
+// maxExpansionDepth bounds how deeply expansions nest, a macro expanding to a use of itself with
+// the same form would otherwise never stop.
+const maxExpansionDepth = 1000
+
+// Macros holds the macros in scope while parsing, see UseMacros.
+type Macros struct {
+	enclosing *Macros
+	rules     map[string]*SyntaxRules
+	// transformers are the transformers of the macros defined with define-macro, see define_macro.go
+	transformers map[string]Expression
+	expander     MacroExpander
+	// expansions counts the expansions that renamed an identifier, it's shared with the enclosing
+	// tables so every expansion renames to names of its own
+	expansions *int
+}
+
+func NewMacros() *Macros {
+	return &Macros{rules: make(map[string]*SyntaxRules), transformers: make(map[string]Expression), expansions: new(int)}
+}
+
+// Define makes name a macro transformed by rules.
+func (m *Macros) Define(name string, rules *SyntaxRules) {
+	delete(m.transformers, name)
+	m.rules[name] = rules
+}
+
+// DefineTransformer makes name a macro of define-macro, expanded by the procedure transformer
+// evaluates to.
+func (m *Macros) DefineTransformer(name string, transformer Expression) {
+	delete(m.rules, name)
+	m.transformers[name] = transformer
+}
+
+// SetExpander makes x run the transformers of define-macro for m and the tables enclosing it.
+func (m *Macros) SetExpander(x MacroExpander) {
+	m.expander = x
+}
+
+// lookup returns the macro name defined in m or the macros it encloses, either its rules or its
+// transformer.
+func (m *Macros) lookup(name string) (*SyntaxRules, Expression, bool) {
+	for t := m; t != nil; t = t.enclosing {
+		if rules, ok := t.rules[name]; ok {
+			return rules, nil, true
+		}
+		if transformer, ok := t.transformers[name]; ok {
+			return nil, transformer, true
+		}
+	}
+	return nil, nil, false
+}
+
+// expanderOf returns the expander of m or of the closest table it's enclosed by that has one.
+func (m *Macros) expanderOf() MacroExpander {
+	for t := m; t != nil; t = t.enclosing {
+		if t.expander != nil {
+			return t.expander
+		}
+	}
+	return nil
+}
+
+// alias makes name another name of the macro of rules or transformer.
+func (m *Macros) alias(name string, rules *SyntaxRules, transformer Expression) {
+	if rules != nil {
+		m.rules[name] = rules
+	} else {
+		m.transformers[name] = transformer
+	}
+}
+
+// Len is how many macros m and the macros it encloses define, 0 for nil.
+func (m *Macros) Len() int {
+	n := 0
+	for t := m; t != nil; t = t.enclosing {
+		n += len(t.rules) + len(t.transformers)
+	}
+	return n
+}
+
+// introducedSeparator separates the original name of an identifier introduced by an expansion
+// from the number of the expansion, e.g. tmp#3.
+const introducedSeparator = "#"
+
+// IntroducedName returns the name an identifier introduced by a macro expansion was renamed from,
+// and whether name is one. The evaluator looks an introduced identifier the expansion doesn't bind
+// up by that name in the global environment, so the free identifiers of a template refer to the
+// definitions the macro was written against rather than the local bindings where it's used.
+func IntroducedName(name string) (string, bool) {
+	i := strings.LastIndex(name, introducedSeparator)
+	if i <= 0 || i == len(name)-1 {
+		return name, false
+	}
+	for _, c := range name[i+1:] {
+		if c < '0' || c > '9' {
+			return name, false
+		}
+	}
+	return name[:i], true
+}
+
+// syntax is a form read as data rather than parsed, what macros match and are expanded to.
+type syntax struct {
+	// token is the atom, the paren opening a list, or the quote of a quoted form or the # of a vector
+	token lexer.Token
+	list  bool
+	items []*syntax
+	// tail follows the dot of an improper list, it's nil for a proper one
+	tail *syntax
+	// closing is the paren closing a list
+	closing lexer.Token
+	// quoted is the form a quote is followed by, or the list of a vector's elements
+	quoted *syntax
+}
+
+func (s *syntax) isIdentifier() bool {
+	return !s.list && s.quoted == nil && s.token.TokenType == lexer.TokenTypeIdentifier
+}
+
+func (s *syntax) String() string {
+	switch {
+	case s.quoted != nil:
+		return s.token.Content + s.quoted.String()
+	case s.list:
+		parts := make([]string, len(s.items))
+		for i, item := range s.items {
+			parts[i] = item.String()
+		}
+		if s.tail != nil {
+			parts = append(parts, ".", s.tail.String())
+		}
+		return "(" + strings.Join(parts, " ") + ")"
+	case s.token.TokenType == lexer.TokenTypeString:
+		return lexer.QuoteString(s.token.Content)
+	default:
+		return s.token.Content
+	}
+}
+
+// tokens returns the tokens s was read from, or would be read from if it was made by an expansion.
+func (s *syntax) tokens(out []lexer.Token) []lexer.Token {
+	switch {
+	case s.quoted != nil:
+		return s.quoted.tokens(append(out, s.token))
+	case s.list:
+		out = append(out, s.token)
+		for _, item := range s.items {
+			out = item.tokens(out)
+		}
+		if s.tail != nil {
+			dot := lexer.Token{Content: ".", Line: s.tail.token.Line, Column: s.tail.token.Column, TokenType: lexer.TokenTypeDot}
+			out = s.tail.tokens(append(out, dot))
+		}
+		return append(out, s.closing)
+	default:
+		return append(out, s.token)
+	}
+}
+
+// readSyntax reads the form at the current token as data.
+func (p *Parser) readSyntax() (*syntax, error) {
+	token := p.currentToken
+	switch token.TokenType {
+	case lexer.TokenTypeLeftParen:
+		p.nextToken()
+		return p.readSyntaxList(token, nil)
+	case lexer.TokenTypeQuote, lexer.TokenTypeVectorPrefix:
+		p.nextToken()
+		quoted, err := p.readSyntax()
+		if err != nil {
+			return nil, err
+		}
+		return &syntax{token: token, quoted: quoted}, nil
+	case lexer.TokenTypeRightParen, lexer.TokenTypeDot, lexer.TokenTypeEOF, lexer.TokenTypeInvalid:
+		return nil, NewParsingError(token, fmt.Sprintf("unexpected token: %s", token.TokenType))
+	default:
+		p.nextToken()
+		return &syntax{token: token}, nil
+	}
+}
+
+// readSyntaxList reads the rest of the list opened by open, after the items already read.
+func (p *Parser) readSyntaxList(open lexer.Token, items []*syntax) (*syntax, error) {
+	list := &syntax{token: open, list: true, items: items}
+	for p.currentToken.TokenType != lexer.TokenTypeRightParen {
+		if p.currentToken.TokenType == lexer.TokenTypeDot && len(list.items) > 0 {
+			p.nextToken()
+			tail, err := p.readSyntax()
+			if err != nil {
+				return nil, err
+			}
+			if p.currentToken.TokenType != lexer.TokenTypeRightParen {
+				return nil, NewParsingError(p.currentToken, "expected ')' after the tail of a dotted list")
+			}
+			list.tail = tail
+			break
+		}
+		item, err := p.readSyntax()
+		if err != nil {
+			return nil, err
+		}
+		list.items = append(list.items, item)
+	}
+	list.closing = p.currentToken
+	p.nextToken()
+	return list, nil
+}
+
+// SyntaxRules is the transformer of a macro, the rules of its syntax-rules form.
+type SyntaxRules struct {
+	name     string
+	ellipsis string
+	literals map[string]bool
+	rules    []syntaxRule
+	source   *syntax
+	// scope is the table the macro keywords its templates introduce are looked up in, the macros
+	// around a let-syntax or those of a letrec-syntax, nil for where the macro is used
+	scope *Macros
+}
+
+// syntaxRule is a pattern and its template, the pattern without the keyword it starts with.
+type syntaxRule struct {
+	pattern  *syntax
+	template *syntax
+}
+
+func (r *SyntaxRules) String() string {
+	return r.source.String()
+}
+
+// parseSyntaxRules checks the syntax-rules form spec of the macro name, made by form:
+//
+//	(syntax-rules (literal ...) (pattern template) ...)
+//	(syntax-rules ellipsis (literal ...) (pattern template) ...)
+func parseSyntaxRules(form string, name string, spec *syntax) (*SyntaxRules, error) {
+	if !spec.list || len(spec.items) == 0 || !spec.items[0].isIdentifier() || spec.items[0].token.Content != "syntax-rules" {
+		return nil, NewParsingError(spec.token, fmt.Sprintf("expected (syntax-rules (literal ...) (pattern template) ...) after %s %s", form, name))
+	}
+	if spec.tail != nil {
+		return nil, NewParsingError(spec.tail.token, "unexpected '.' in syntax-rules")
+	}
+	rules := &SyntaxRules{name: name, ellipsis: "...", literals: make(map[string]bool), source: spec}
+	rest := spec.items[1:]
+	if len(rest) > 0 && rest[0].isIdentifier() {
+		rules.ellipsis = rest[0].token.Content
+		rest = rest[1:]
+	}
+	if len(rest) == 0 || !rest[0].list || rest[0].tail != nil {
+		return nil, NewParsingError(spec.closing, "expected a list of literals in syntax-rules")
+	}
+	for _, literal := range rest[0].items {
+		// keywords such as else are literals too
+		if literal.list || literal.quoted != nil || literal.token.TokenType == lexer.TokenTypeNumber || literal.token.TokenType == lexer.TokenTypeString || literal.token.TokenType == lexer.TokenTypeChar {
+			return nil, NewParsingError(literal.token, "expected an identifier in the literals of syntax-rules")
+		}
+		rules.literals[literal.token.Content] = true
+	}
+
+	for _, rule := range rest[1:] {
+		if !rule.list || len(rule.items) != 2 || rule.tail != nil {
+			return nil, NewParsingError(rule.token, "expected (pattern template) in syntax-rules")
+		}
+		pattern := rule.items[0]
+		if !pattern.list || len(pattern.items) == 0 || !pattern.items[0].isIdentifier() {
+			return nil, NewParsingError(pattern.token, fmt.Sprintf("expected a pattern starting with %s or _", name))
+		}
+		// the keyword position always matches the macro's name and binds nothing
+		pattern = &syntax{token: pattern.token, list: true, items: pattern.items[1:], tail: pattern.tail, closing: pattern.closing}
+		if err := rules.checkPattern(pattern, make(map[string]bool)); err != nil {
+			return nil, err
+		}
+		depths := make(map[string]int)
+		rules.patternDepths(pattern, 0, depths)
+		if err := rules.checkTemplate(rule.items[1], depths, 0); err != nil {
+			return nil, err
+		}
+		rules.rules = append(rules.rules, syntaxRule{pattern: pattern, template: rule.items[1]})
+	}
+	return rules, nil
+}
+
+func (r *SyntaxRules) isEllipsis(s *syntax) bool {
+	return s.isIdentifier() && s.token.Content == r.ellipsis && !r.literals[s.token.Content]
+}
+
+// isVariable reports whether the pattern s is a pattern variable. Keywords such as else and
+// lambda, which can't be bound, match themselves like literals do.
+func (r *SyntaxRules) isVariable(s *syntax) bool {
+	return s.isIdentifier() && s.token.Content != "_" && !r.literals[s.token.Content] && s.token.Content != r.ellipsis
+}
+
+// checkPattern rejects a pattern variable used twice and misplaced ellipses, vars holds the
+// variables of the pattern seen so far.
+func (r *SyntaxRules) checkPattern(pattern *syntax, vars map[string]bool) error {
+	switch {
+	case pattern.quoted != nil:
+		return r.checkPattern(pattern.quoted, vars)
+	case pattern.list:
+		ellipses := 0
+		for i, item := range pattern.items {
+			if r.isEllipsis(item) {
+				if i == 0 || r.isEllipsis(pattern.items[i-1]) {
+					return NewParsingError(item.token, fmt.Sprintf("%s has to follow a pattern", r.ellipsis))
+				}
+				if ellipses++; ellipses > 1 {
+					return NewParsingError(item.token, fmt.Sprintf("a list pattern can have only one %s", r.ellipsis))
+				}
+				continue
+			}
+			if err := r.checkPattern(item, vars); err != nil {
+				return err
+			}
+		}
+		if pattern.tail != nil {
+			if r.isEllipsis(pattern.tail) {
+				return NewParsingError(pattern.tail.token, fmt.Sprintf("%s can't be the tail of a pattern", r.ellipsis))
+			}
+			return r.checkPattern(pattern.tail, vars)
+		}
+	case r.isVariable(pattern):
+		if vars[pattern.token.Content] {
+			return NewParsingError(pattern.token, fmt.Sprintf("pattern variable %s is used twice in a pattern of %s", pattern.token.Content, r.name))
+		}
+		vars[pattern.token.Content] = true
+	}
+	return nil
+}
+
+// patternDepths records how many ellipses each pattern variable of pattern is under.
+func (r *SyntaxRules) patternDepths(pattern *syntax, depth int, depths map[string]int) {
+	switch {
+	case pattern.quoted != nil:
+		r.patternDepths(pattern.quoted, depth, depths)
+	case pattern.list:
+		for i, item := range pattern.items {
+			if r.isEllipsis(item) {
+				continue
+			}
+			d := depth
+			if i+1 < len(pattern.items) && r.isEllipsis(pattern.items[i+1]) {
+				d++
+			}
+			r.patternDepths(item, d, depths)
+		}
+		if pattern.tail != nil {
+			r.patternDepths(pattern.tail, depth, depths)
+		}
+	case r.isVariable(pattern):
+		depths[pattern.token.Content] = depth
+	}
+}
+
+// checkTemplate rejects a pattern variable followed by fewer ellipses in template than in its
+// pattern, and an ellipsis following no pattern variable matched under one. depth is how many
+// ellipses follow template.
+func (r *SyntaxRules) checkTemplate(template *syntax, depths map[string]int, depth int) error {
+	switch {
+	case template.quoted != nil:
+		return r.checkTemplate(template.quoted, depths, depth)
+	case template.list:
+		items := template.items
+		if len(items) == 2 && r.isEllipsis(items[0]) && template.tail == nil {
+			escaped := &SyntaxRules{name: r.name, literals: r.literals}
+			return escaped.checkTemplate(items[1], depths, depth)
+		}
+		for i := 0; i < len(items); i++ {
+			if r.isEllipsis(items[i]) {
+				return NewParsingError(items[i].token, fmt.Sprintf("%s has to follow a template", r.ellipsis))
+			}
+			n := 0
+			for i+n+1 < len(items) && r.isEllipsis(items[i+n+1]) {
+				n++
+			}
+			if n > 0 && !r.repeats(items[i], depths, depth) {
+				return NewParsingError(items[i+1].token, fmt.Sprintf("%s in the template of %s doesn't follow a pattern variable matched under %s", r.ellipsis, r.name, r.ellipsis))
+			}
+			if err := r.checkTemplate(items[i], depths, depth+n); err != nil {
+				return err
+			}
+			i += n
+		}
+		if template.tail != nil {
+			return r.checkTemplate(template.tail, depths, depth)
+		}
+	case template.isIdentifier():
+		if d, ok := depths[template.token.Content]; ok && d > depth {
+			return NewParsingError(template.token, fmt.Sprintf("pattern variable %s has to be followed by %s in the template of %s", template.token.Content, r.ellipsis, r.name))
+		}
+	}
+	return nil
+}
+
+// repeats reports whether template has a pattern variable matched under more than depth ellipses.
+func (r *SyntaxRules) repeats(template *syntax, depths map[string]int, depth int) bool {
+	for _, name := range r.variables(template, nil) {
+		if depths[name] > depth {
+			return true
+		}
+	}
+	return false
+}
+
+// binding is what a pattern variable matched: a form, or a form for each repetition of the
+// ellipsis it's under.
+type binding struct {
+	form *syntax
+	seq  []*binding
+}
+
+type bindings map[string]*binding
+
+// match reports whether form matches pattern, adding what the pattern variables matched to b.
+func (r *SyntaxRules) match(pattern *syntax, form *syntax, b bindings) bool {
+	switch {
+	case pattern.quoted != nil:
+		return form.quoted != nil && form.token.TokenType == pattern.token.TokenType && r.match(pattern.quoted, form.quoted, b)
+	case pattern.list:
+		return form.list && r.matchList(pattern, form, b)
+	case r.isVariable(pattern):
+		b[pattern.token.Content] = &binding{form: form}
+		return true
+	case pattern.isIdentifier() && pattern.token.Content == "_":
+		return true
+	case pattern.isIdentifier():
+		// a literal matches the same identifier, renamed by an expansion or not
+		name, _ := IntroducedName(form.token.Content)
+		return form.isIdentifier() && name == pattern.token.Content
+	default:
+		return !form.list && form.quoted == nil && form.token.TokenType == pattern.token.TokenType && form.token.Content == pattern.token.Content
+	}
+}
+
+// matchList matches the items of the list pattern, at most one of which is followed by an ellipsis,
+// and its tail.
+func (r *SyntaxRules) matchList(pattern *syntax, form *syntax, b bindings) bool {
+	items := pattern.items
+	repeated := -1
+	for i := 1; i < len(items); i++ {
+		if r.isEllipsis(items[i]) {
+			repeated = i - 1
+			break
+		}
+	}
+
+	if repeated < 0 {
+		if len(form.items) < len(items) || pattern.tail == nil && (len(form.items) != len(items) || form.tail != nil) {
+			return false
+		}
+		for i, item := range items {
+			if !r.match(item, form.items[i], b) {
+				return false
+			}
+		}
+		if pattern.tail == nil {
+			return true
+		}
+		return r.match(pattern.tail, restOf(form, len(items)), b)
+	}
+
+	before, after := items[:repeated], items[repeated+2:]
+	if len(form.items) < len(before)+len(after) || pattern.tail == nil && form.tail != nil {
+		return false
+	}
+	for i, item := range before {
+		if !r.match(item, form.items[i], b) {
+			return false
+		}
+	}
+	end := len(form.items) - len(after)
+	seqs := make(map[string][]*binding)
+	for _, name := range r.variables(items[repeated], nil) {
+		seqs[name] = []*binding{}
+	}
+	for _, item := range form.items[len(before):end] {
+		sub := make(bindings)
+		if !r.match(items[repeated], item, sub) {
+			return false
+		}
+		for name := range seqs {
+			seqs[name] = append(seqs[name], sub[name])
+		}
+	}
+	for name, seq := range seqs {
+		b[name] = &binding{seq: seq}
+	}
+	for i, item := range after {
+		if !r.match(item, form.items[end+i], b) {
+			return false
+		}
+	}
+	if pattern.tail == nil {
+		return true
+	}
+	return r.match(pattern.tail, restOf(form, len(form.items)), b)
+}
+
+// restOf returns the list of the items of form from i on, followed by its tail.
+func restOf(form *syntax, i int) *syntax {
+	if i == len(form.items) && form.tail != nil {
+		return form.tail
+	}
+	return &syntax{token: form.token, list: true, items: form.items[i:], tail: form.tail, closing: form.closing}
+}
+
+// variables appends the pattern variables of pattern to names.
+func (r *SyntaxRules) variables(pattern *syntax, names []string) []string {
+	switch {
+	case pattern.quoted != nil:
+		return r.variables(pattern.quoted, names)
+	case pattern.list:
+		for _, item := range pattern.items {
+			names = r.variables(item, names)
+		}
+		if pattern.tail != nil {
+			names = r.variables(pattern.tail, names)
+		}
+	case r.isVariable(pattern):
+		names = append(names, pattern.token.Content)
+	}
+	return names
+}
+
+// expansion is the state of expanding one use of a macro.
+type expansion struct {
+	rules  *SyntaxRules
+	macros *Macros
+	// renamed maps the identifiers the template introduced to their new names
+	renamed map[string]string
+	number  int
+}
+
+// expand returns what the use form of the macro expands to.
+func (r *SyntaxRules) expand(form *syntax, macros *Macros) (*syntax, error) {
+	args := restOf(form, 1)
+	for _, rule := range r.rules {
+		b := make(bindings)
+		if !r.match(rule.pattern, args, b) {
+			continue
+		}
+		x := &expansion{rules: r, macros: macros, renamed: make(map[string]string)}
+		return x.instantiate(rule.template, b, false)
+	}
+	return nil, NewParsingError(form.token, fmt.Sprintf("no syntax-rules pattern of %s matches %s", r.name, form))
+}
+
+// instantiate returns template with the pattern variables replaced by what they matched. quoted
+// is set inside a quote or a vector, where introduced identifiers are data and aren't renamed.
+func (x *expansion) instantiate(template *syntax, b bindings, quoted bool) (*syntax, error) {
+	switch {
+	case template.quoted != nil:
+		inner, err := x.instantiate(template.quoted, b, true)
+		if err != nil {
+			return nil, err
+		}
+		return &syntax{token: template.token, quoted: inner}, nil
+	case template.list:
+		if len(template.items) == 2 && x.rules.isEllipsis(template.items[0]) && template.tail == nil {
+			// (... template) escapes the ellipses in template
+			escaped := *x
+			escaped.rules = &SyntaxRules{name: x.rules.name, ellipsis: "", literals: x.rules.literals, scope: x.rules.scope}
+			return escaped.instantiate(template.items[1], b, quoted)
+		}
+		return x.instantiateList(template, b, quoted)
+	case template.isIdentifier():
+		name := template.token.Content
+		if bound, ok := b[name]; ok {
+			if bound.form == nil {
+				return nil, NewParsingError(template.token, fmt.Sprintf("pattern variable %s has to be followed by %s in the template of %s", name, x.rules.ellipsis, x.rules.name))
+			}
+			return bound.form, nil
+		}
+		if quoted {
+			return template, nil
+		}
+		return &syntax{token: x.rename(template.token)}, nil
+	default:
+		return template, nil
+	}
+}
+
+func (x *expansion) instantiateList(template *syntax, b bindings, quoted bool) (*syntax, error) {
+	list := &syntax{token: template.token, list: true, closing: template.closing}
+	items := template.items
+	for i := 0; i < len(items); i++ {
+		depth := 0
+		for i+depth+1 < len(items) && x.rules.isEllipsis(items[i+depth+1]) {
+			depth++
+		}
+		if depth == 0 {
+			item, err := x.instantiate(items[i], b, quoted)
+			if err != nil {
+				return nil, err
+			}
+			list.items = append(list.items, item)
+			continue
+		}
+		repeated, err := x.instantiateRepeated(items[i], b, depth, quoted)
+		if err != nil {
+			return nil, err
+		}
+		list.items = append(list.items, repeated...)
+		i += depth
+	}
+	if template.tail == nil {
+		return list, nil
+	}
+
+	tail, err := x.instantiate(template.tail, b, quoted)
+	if err != nil {
+		return nil, err
+	}
+	if tail.list {
+		// (a . (b c)) is (a b c)
+		list.items = append(list.items, tail.items...)
+		list.tail = tail.tail
+	} else {
+		list.tail = tail
+	}
+	return list, nil
+}
+
+// instantiateRepeated instantiates template, which is followed by depth ellipses, once for each
+// form its pattern variables matched.
+func (x *expansion) instantiateRepeated(template *syntax, b bindings, depth int, quoted bool) ([]*syntax, error) {
+	n := -1
+	var repeated []string
+	for _, name := range x.rules.variables(template, nil) {
+		bound, ok := b[name]
+		if !ok || bound.seq == nil {
+			continue
+		}
+		if n >= 0 && len(bound.seq) != n {
+			return nil, NewParsingError(template.token, fmt.Sprintf("the pattern variables of %s in the template of %s matched different numbers of forms", template, x.rules.name))
+		}
+		n = len(bound.seq)
+		repeated = append(repeated, name)
+	}
+	if n < 0 {
+		return nil, NewParsingError(template.token, fmt.Sprintf("%s in the template of %s doesn't follow a pattern variable matched under %s", x.rules.ellipsis, x.rules.name, x.rules.ellipsis))
+	}
+
+	var out []*syntax
+	for i := 0; i < n; i++ {
+		each := make(bindings, len(b))
+		for name, bound := range b {
+			each[name] = bound
+		}
+		for _, name := range repeated {
+			each[name] = b[name].seq[i]
+		}
+		if depth > 1 {
+			items, err := x.instantiateRepeated(template, each, depth-1, quoted)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, items...)
+			continue
+		}
+		item, err := x.instantiate(template, each, quoted)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, item)
+	}
+	return out, nil
+}
+
+// rename returns the token of an identifier the template introduced, renamed for this expansion.
+// Macro keywords, syntax-rules and an escaped ellipsis aren't variables and keep their names,
+// unless the keyword is another macro where the expansion is parsed than in the scope of the
+// template, then it's renamed to an alias of the macro in scope.
+func (x *expansion) rename(token lexer.Token) lexer.Token {
+	name := token.Content
+	if name == "syntax-rules" || name == "..." {
+		return token
+	}
+	scope := x.macros
+	if x.rules.scope != nil {
+		scope = x.rules.scope
+	}
+	if rules, transformer, ok := scope.lookup(name); ok {
+		if usedRules, usedTransformer, _ := x.macros.lookup(name); usedRules == rules && usedTransformer == transformer {
+			return token
+		}
+		token.Content = x.renamedName(name)
+		x.macros.alias(token.Content, rules, transformer)
+		return token
+	}
+	token.Content = x.renamedName(name)
+	return token
+}
+
+// renamedName returns the name of this expansion for the identifier name the template introduced.
+func (x *expansion) renamedName(name string) string {
+	renamed, ok := x.renamed[name]
+	if !ok {
+		if x.number == 0 {
+			*x.macros.expansions++
+			x.number = *x.macros.expansions
+		}
+		renamed = name + introducedSeparator + strconv.Itoa(x.number)
+		x.renamed[name] = renamed
+	}
+	return renamed
+}
+
+// parseDefineSyntaxExpression parses (define-syntax name (syntax-rules ...)), from then on the
+// parser expands the uses of name.
+func (p *Parser) parseDefineSyntaxExpression() (Expression, error) {
+	firstToken := p.currentToken
+	p.nextToken()
+
+	if p.currentToken.TokenType != lexer.TokenTypeIdentifier {
+		if isReservedWord(p.currentToken.TokenType) {
+			return nil, NewParsingError(p.currentToken, fmt.Sprintf("'%s' is a reserved word, it can't be a macro name", p.currentToken.Content))
+		}
+		return nil, NewParsingError(p.currentToken, "expected identifier after define-syntax")
+	}
+	name := p.currentToken.Content
+	p.nextToken()
+
+	spec, err := p.readSyntax()
+	if err != nil {
+		return nil, err
+	}
+	rules, err := parseSyntaxRules("define-syntax", name, spec)
+	if err != nil {
+		return nil, err
+	}
+	if p.currentToken.TokenType != lexer.TokenTypeRightParen {
+		return nil, NewParsingError(p.currentToken, "expected ')' after syntax-rules")
+	}
+	p.nextToken()
+
+	p.macros.Define(name, rules)
+	return &DefineSyntaxExpression{LeftParenToken: firstToken, Name: name, Rules: rules}, nil
+}
+
+// parseLetSyntaxExpression parses let-syntax and letrec-syntax, which make macros for their body
+// only:
+//
+//	(let-syntax ((name (syntax-rules ...)) ...) body ...)
+//
+// The macro keywords the templates of a let-syntax introduce are the macros around it, those of a
+// letrec-syntax can be the macros it makes, so they can expand to uses of each other. The body
+// becomes the call of a procedure without parameters, like the body of a let without bindings.
+func (p *Parser) parseLetSyntaxExpression() (Expression, error) {
+	firstToken := p.currentToken
+	form := firstToken.Content
+	p.nextToken()
+
+	if p.currentToken.TokenType != lexer.TokenTypeLeftParen {
+		return nil, NewParsingError(p.currentToken, fmt.Sprintf("expected '(' after %s", form))
+	}
+	p.nextToken()
+
+	outer := p.macros
+	scope := &Macros{enclosing: outer, rules: make(map[string]*SyntaxRules), transformers: make(map[string]Expression), expansions: outer.expansions}
+	for p.currentToken.TokenType != lexer.TokenTypeRightParen {
+		if p.currentToken.TokenType != lexer.TokenTypeLeftParen {
+			return nil, NewParsingError(p.currentToken, "expected '(' in binding list")
+		}
+		p.nextToken()
+		if p.currentToken.TokenType != lexer.TokenTypeIdentifier {
+			if isReservedWord(p.currentToken.TokenType) {
+				return nil, NewParsingError(p.currentToken, fmt.Sprintf("'%s' is a reserved word, it can't be a macro name", p.currentToken.Content))
+			}
+			return nil, NewParsingError(p.currentToken, "expected identifier in binding")
+		}
+		name := p.currentToken.Content
+		p.nextToken()
+
+		spec, err := p.readSyntax()
+		if err != nil {
+			return nil, err
+		}
+		rules, err := parseSyntaxRules(form, name, spec)
+		if err != nil {
+			return nil, err
+		}
+		rules.scope = outer
+		if firstToken.TokenType == lexer.TokenTypeLetrecSyntax {
+			rules.scope = scope
+		}
+		if p.currentToken.TokenType != lexer.TokenTypeRightParen {
+			return nil, NewParsingError(p.currentToken, "expected ')' after syntax-rules")
+		}
+		p.nextToken()
+		scope.Define(name, rules)
+	}
+	p.nextToken()
+
+	p.macros = scope
+	body := make([]Expression, 0)
+	for p.currentToken.TokenType != lexer.TokenTypeRightParen {
+		expr, err := p.parseExpression()
+		if err != nil {
+			p.macros = outer
+			return nil, NewParsingError(p.currentToken, err.Error())
+		}
+		body = append(body, expr)
+	}
+	p.macros = outer
+	if len(body) == 0 {
+		return nil, NewParsingError(p.currentToken, fmt.Sprintf("expected at least one expression in %s body", form))
+	}
+	p.nextToken()
+
+	return &CallExpression{
+		LeftParenToken: firstToken,
+		Operator:       &LambdaExpression{LeftParenToken: firstToken, Parameters: []string{}, Body: body},
+		Operands:       []Expression{},
+	}, nil
+}
+
+// parseMacroUse expands the use of the macro rules whose keyword is the current token, and parses
+// the expansion in its place.
+func (p *Parser) parseMacroUse(rules *SyntaxRules) (Expression, error) {
+	open := p.prevToken
+	keyword, err := p.readSyntax()
+	if err != nil {
+		return nil, err
+	}
+	form, err := p.readSyntaxList(open, []*syntax{keyword})
+	if err != nil {
+		return nil, err
+	}
+
+	if p.expanding >= maxExpansionDepth {
+		return nil, NewParsingError(keyword.token, fmt.Sprintf("expansions of %s nested more than %d deep, does it expand to itself?", rules.name, maxExpansionDepth))
+	}
+	expanded, err := rules.expand(form, p.macros)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.parseExpansion(expanded.tokens(nil))
+}
+
+// parseExpansion parses the tokens a macro use expanded to in its place.
+func (p *Parser) parseExpansion(tokens []lexer.Token) (Expression, error) {
+	// the expansion is read before the token following the use
+	p.pending = append(append(tokens[1:], p.currentToken), p.pending...)
+	p.currentToken = tokens[0]
+	p.expanding++
+	defer func() {
+		p.expanding--
+	}()
+	return p.parseExpression()
+}
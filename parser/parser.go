@@ -1,8 +1,9 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
-	"strconv"
+	"strings"
 
 	"github.com/ocowchun/soup/lexer"
 )
@@ -13,20 +14,75 @@ type Parser struct {
 	l            *lexer.Lexer
 	prevToken    lexer.Token
 	currentToken lexer.Token
+	// open holds the parens opened and not yet closed before currentToken, innermost last
+	open []lexer.Token
+	// allowShadowing lets parameters and let bindings be named after builtins such as not and +
+	allowShadowing bool
+	// racket is set by Racket
+	racket bool
+	// macros are the macros expanded, see macro.go; pending holds the tokens of an expansion to
+	// read before the lexer's, expanding counts the expansions being parsed
+	macros    *Macros
+	pending   []lexer.Token
+	expanding int
 }
 
 func (p *Parser) nextToken() {
-	token := p.l.NextToken()
+	switch p.currentToken.TokenType {
+	case lexer.TokenTypeLeftParen:
+		p.open = append(p.open, p.currentToken)
+	case lexer.TokenTypeRightParen:
+		if len(p.open) > 0 {
+			p.open = p.open[:len(p.open)-1]
+		}
+	}
+	var token lexer.Token
+	if len(p.pending) > 0 {
+		token = p.pending[0]
+		p.pending = p.pending[1:]
+	} else {
+		token = p.l.NextToken()
+	}
 	p.prevToken = p.currentToken
 	p.currentToken = token
 }
 
 type Program struct {
 	Expressions []Expression
+	// Source is the text of a program that isn't a file, for errors raised in it to quote. Parse leaves
+	// it empty, whoever has the text sets it.
+	Source string
 }
 
 func New(l *lexer.Lexer) *Parser {
-	return &Parser{l: l}
+	return &Parser{l: l, macros: NewMacros()}
+}
+
+// Feed makes the parser read tokens before what its lexer reads, such as the tokens of a form built
+// as data, which may hold TokenTypeDatum tokens.
+func (p *Parser) Feed(tokens []lexer.Token) {
+	p.pending = append(tokens, p.pending...)
+}
+
+// UseMacros makes the parser expand the macros of m as well as the ones the program defines, which
+// are added to a table of the parser's own. An evaluator keeps the macros of the programs it
+// evaluated in m, so they can be used in the next one.
+func (p *Parser) UseMacros(m *Macros) {
+	p.macros = &Macros{enclosing: m, rules: make(map[string]*SyntaxRules), transformers: make(map[string]Expression), expansions: m.expansions}
+}
+
+// AllowShadowing lets parameters and let bindings shadow the builtins that are lexed as keywords,
+// such as not and +. By default only define and set! can rebind those.
+func (p *Parser) AllowShadowing() {
+	p.allowShadowing = true
+}
+
+// Racket makes the parser accept Racket-flavored programs: brackets are parens, see
+// lexer.AcceptBrackets, and (require spec ...) and (provide spec ...) are parsed into RequireExpression
+// and ProvideExpression. The #lang line is skipped either way.
+func (p *Parser) Racket() {
+	p.racket = true
+	p.l.AcceptBrackets()
 }
 
 func (p *Parser) match(t lexer.TokenType) bool {
@@ -50,7 +106,7 @@ func (p *Parser) Parse() (*Program, error) {
 
 		expr, err := p.parseExpression()
 		if err != nil {
-			return nil, err
+			return nil, p.unclosed(err)
 		}
 		program.Expressions = append(program.Expressions, expr)
 
@@ -58,9 +114,57 @@ func (p *Parser) Parse() (*Program, error) {
 	return program, nil
 }
 
+// unclosed returns err pointing out the paren left open when it was raised at the end of the input,
+// which is where a missing ')' is noticed.
+func (p *Parser) unclosed(err error) error {
+	var parsingError *ParsingError
+	if len(p.open) == 0 || p.currentToken.TokenType != lexer.TokenTypeEOF || !errors.As(err, &parsingError) {
+		return err
+	}
+	paren := p.open[len(p.open)-1]
+	parsingError.Message = fmt.Sprintf("unclosed '(' opened at %d:%d", paren.Line, paren.Column)
+	return parsingError
+}
+
+// ParseAll parses the program like Parse does, but carries on after an error with the next top-level
+// expression, so all the errors of a file are found at once. The program holds the expressions that
+// parsed, the errors are in the order they were found.
+func (p *Parser) ParseAll() (*Program, []*ParsingError) {
+	program := &Program{Expressions: []Expression{}}
+	var errs []*ParsingError
+
+	p.nextToken()
+	for !p.match(lexer.TokenTypeEOF) {
+		start := p.currentToken
+		p.open = p.open[:0]
+		expr, err := p.parseExpression()
+		if err == nil {
+			program.Expressions = append(program.Expressions, expr)
+			continue
+		}
+
+		var parsingError *ParsingError
+		if !errors.As(p.unclosed(err), &parsingError) {
+			parsingError = NewParsingError(start, err.Error())
+		}
+		errs = append(errs, parsingError)
+		// skip to the paren closing the expression that failed
+		for len(p.open) > 0 && p.currentToken.TokenType != lexer.TokenTypeEOF {
+			p.nextToken()
+		}
+		if p.currentToken == start {
+			// nothing was read, e.g. a stray ')'
+			p.nextToken()
+		}
+	}
+	return program, errs
+}
+
 type ParsingError struct {
 	Message string
 	Token   lexer.Token
+	// File is the path of the source file, set by callers that parse one
+	File string
 }
 
 func (e *ParsingError) Error() string {
@@ -75,17 +179,18 @@ func NewParsingError(token lexer.Token, message string) *ParsingError {
 }
 
 func (p *Parser) parseNumber() (*NumberLiteral, error) {
-	_, err := strconv.ParseFloat(p.currentToken.Content, 64)
+	value, err := lexer.ParseNumber(p.currentToken.Content)
 	if err != nil {
 		return nil, NewParsingError(p.currentToken, err.Error())
 	}
 
 	exp := &NumberLiteral{
 		NumToken: p.currentToken,
+		Value:    value,
 	}
 	p.nextToken()
 
-	return exp, err
+	return exp, nil
 }
 
 func (p *Parser) parseString() (Expression, error) {
@@ -94,6 +199,16 @@ func (p *Parser) parseString() (Expression, error) {
 	return str, nil
 }
 
+func (p *Parser) parseChar() (Expression, error) {
+	value, err := lexer.ParseChar(p.currentToken.Content)
+	if err != nil {
+		return nil, NewParsingError(p.currentToken, err.Error())
+	}
+	exp := &CharLiteral{CharToken: p.currentToken, Value: value}
+	p.nextToken()
+	return exp, nil
+}
+
 func (p *Parser) parseCallExpression() (Expression, error) {
 	currentToken := p.currentToken
 	operator, err := p.parseExpression()
@@ -175,8 +290,8 @@ func (p *Parser) parseDefineExpression() (Expression, error) {
 		// (define (name params...) body...)
 		p.nextToken()
 
-		if p.currentToken.TokenType != lexer.TokenTypeIdentifier {
-			return nil, NewParsingError(p.currentToken, "expected identifier after '(' in define")
+		if err := p.checkBindingName("a procedure name", "expected identifier after '(' in define", true); err != nil {
+			return nil, err
 		}
 		name := p.currentToken.Content
 
@@ -189,8 +304,8 @@ func (p *Parser) parseDefineExpression() (Expression, error) {
 			// TODO: how to adjust struct to support dotted-tail notation?
 			if p.currentToken.TokenType == lexer.TokenTypeDot {
 				p.nextToken()
-				if p.currentToken.TokenType != lexer.TokenTypeIdentifier {
-					return nil, NewParsingError(p.currentToken, "expected identifier in parameter list")
+				if err := p.checkBindingName("a parameter name", "expected identifier in parameter list", p.allowShadowing); err != nil {
+					return nil, err
 				}
 
 				optionalTailParameter = p.currentToken.Content
@@ -202,8 +317,8 @@ func (p *Parser) parseDefineExpression() (Expression, error) {
 				}
 			}
 
-			if p.currentToken.TokenType != lexer.TokenTypeIdentifier {
-				return nil, NewParsingError(p.currentToken, "expected identifier in parameter list")
+			if err := p.checkBindingName("a parameter name", "expected identifier in parameter list", p.allowShadowing); err != nil {
+				return nil, err
 			}
 			parameters = append(parameters, p.currentToken.Content)
 
@@ -226,6 +341,7 @@ func (p *Parser) parseDefineExpression() (Expression, error) {
 		p.nextToken()
 
 		lambda := &LambdaExpression{
+			LeftParenToken:        firstToken,
 			Parameters:            parameters,
 			Body:                  body,
 			OptionalTailParameter: optionalTailParameter,
@@ -237,8 +353,8 @@ func (p *Parser) parseDefineExpression() (Expression, error) {
 		}, nil
 	} else {
 		// (define name body...) -> variable
-		if p.currentToken.TokenType != lexer.TokenTypeIdentifier {
-			return nil, NewParsingError(p.currentToken, "expected identifier after define")
+		if err := p.checkBindingName("a variable name", "expected identifier after define", true); err != nil {
+			return nil, err
 		}
 		name := p.currentToken.Content
 		p.nextToken()
@@ -261,6 +377,56 @@ func (p *Parser) parseDefineExpression() (Expression, error) {
 	}
 }
 
+// checkBindingName returns an error unless the current token is a name that can be what, e.g. "a
+// parameter name". A reserved word such as if never can; a keyword that's merely a builtin, like not
+// or +, can when builtins is true, and an error for any other token says expected.
+func (p *Parser) checkBindingName(what string, expected string, builtins bool) error {
+	token := p.currentToken
+	switch {
+	case token.TokenType == lexer.TokenTypeIdentifier:
+		return nil
+	case isReservedWord(token.TokenType):
+		return NewParsingError(token, fmt.Sprintf("'%s' is a reserved word, it can't be %s", token.Content, what))
+	case isBuiltinKeyword(token.TokenType):
+		if builtins {
+			return nil
+		}
+		return NewParsingError(token, fmt.Sprintf("'%s' is a builtin, it can only be %s when shadowing builtins is allowed", token.Content, what))
+	default:
+		return NewParsingError(token, expected)
+	}
+}
+
+// isReservedWord reports whether a token is the keyword of a special form, which can't be bound.
+func isReservedWord(tokenType lexer.TokenType) bool {
+	switch tokenType {
+	case lexer.TokenTypeIf, lexer.TokenTypeDefine, lexer.TokenTypeLambda, lexer.TokenTypeLet, lexer.TokenTypeBegin,
+		lexer.TokenTypeSet, lexer.TokenTypeCond, lexer.TokenTypeElse, lexer.TokenTypeTrue, lexer.TokenTypeFalse,
+		lexer.TokenTypeDelay, lexer.TokenTypeConsStream, lexer.TokenTypeDefineLibrary, lexer.TokenTypeImport,
+		lexer.TokenTypeAmb, lexer.TokenTypeLetrec, lexer.TokenTypeLetrecStar,
+		lexer.TokenTypeLetStar, lexer.TokenTypeDefineSyntax, lexer.TokenTypeDefineMacro, lexer.TokenTypeGuard,
+		lexer.TokenTypeParameterize, lexer.TokenTypeDelayForce, lexer.TokenTypeAssert, lexer.TokenTypeLetSyntax,
+		lexer.TokenTypeLetrecSyntax:
+		return true
+	default:
+		return false
+	}
+}
+
+// isBuiltinKeyword reports whether a token that's lexed as a keyword names a builtin procedure.
+// define and set! can redefine those like any other builtin, a local binding only shadows one
+// when the parser allows it, see AllowShadowing.
+func isBuiltinKeyword(tokenType lexer.TokenType) bool {
+	switch tokenType {
+	case lexer.TokenTypePlus, lexer.TokenTypeMinus, lexer.TokenTypeAsterisk, lexer.TokenTypeSlash,
+		lexer.TokenTypeLess, lexer.TokenTypeGreater, lexer.TokenTypeLessEqual, lexer.TokenTypeGreaterEqual,
+		lexer.TokenTypeNot, lexer.TokenTypeForce, lexer.TokenTypeAnd, lexer.TokenTypeOr:
+		return true
+	default:
+		return false
+	}
+}
+
 func (p *Parser) parseLambdaExpression() (Expression, error) {
 	firstToken := p.currentToken
 	p.nextToken()
@@ -274,8 +440,8 @@ func (p *Parser) parseLambdaExpression() (Expression, error) {
 
 	parameters := make([]string, 0)
 	for p.currentToken.TokenType != lexer.TokenTypeRightParen {
-		if p.currentToken.TokenType != lexer.TokenTypeIdentifier {
-			return nil, NewParsingError(p.currentToken, "expected identifier in parameter list")
+		if err := p.checkBindingName("a parameter name", "expected identifier in parameter list", p.allowShadowing); err != nil {
+			return nil, err
 		}
 		parameters = append(parameters, p.currentToken.Content)
 
@@ -307,11 +473,90 @@ func (p *Parser) parseLambdaExpression() (Expression, error) {
 
 func (p *Parser) parseLetExpression() (Expression, error) {
 	firstToken := p.currentToken
+	parameterNames, parameterExprs, body, err := p.parseLetForm()
+	if err != nil {
+		return nil, err
+	}
+
+	lambda := &LambdaExpression{
+		LeftParenToken: firstToken,
+		Parameters:     parameterNames,
+		Body:           body,
+	}
+	return &CallExpression{
+		LeftParenToken: firstToken,
+		Operator:       lambda,
+		Operands:       parameterExprs,
+	}, nil
+}
+
+// parseLetStarExpression parses let*, whose bindings are made one after the other so each can
+// refer to the ones before it. It becomes nested lets, one per binding.
+func (p *Parser) parseLetStarExpression() (Expression, error) {
+	firstToken := p.currentToken
+	parameterNames, parameterExprs, body, err := p.parseLetForm()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(parameterNames) == 0 {
+		return &CallExpression{
+			LeftParenToken: firstToken,
+			Operator:       &LambdaExpression{LeftParenToken: firstToken, Parameters: []string{}, Body: body},
+			Operands:       []Expression{},
+		}, nil
+	}
+	var exp Expression
+	for i := len(parameterNames) - 1; i >= 0; i-- {
+		exp = &CallExpression{
+			LeftParenToken: firstToken,
+			Operator:       &LambdaExpression{LeftParenToken: firstToken, Parameters: []string{parameterNames[i]}, Body: body},
+			Operands:       []Expression{parameterExprs[i]},
+		}
+		body = []Expression{exp}
+	}
+	return exp, nil
+}
+
+// parseLetrecExpression parses letrec and letrec*, whose bindings are in scope in every
+// expression they're bound to so local procedures can call each other. Both become a call of a
+// procedure without parameters that defines the bindings in order before the body, the way
+// internal definitions are evaluated, which is the letrec* semantics letrec allows too.
+func (p *Parser) parseLetrecExpression() (Expression, error) {
+	firstToken := p.currentToken
+	parameterNames, parameterExprs, body, err := p.parseLetForm()
+	if err != nil {
+		return nil, err
+	}
+
+	definitions := make([]Expression, 0, len(parameterNames)+len(body))
+	for i, name := range parameterNames {
+		definitions = append(definitions, &DefineExpression{
+			LeftParenToken: firstToken,
+			Name:           name,
+			Value:          parameterExprs[i],
+		})
+	}
+	lambda := &LambdaExpression{
+		LeftParenToken: firstToken,
+		Parameters:     []string{},
+		Body:           append(definitions, body...),
+	}
+	return &CallExpression{
+		LeftParenToken: firstToken,
+		Operator:       lambda,
+		Operands:       []Expression{},
+	}, nil
+}
+
+// parseLetForm parses the bindings and the body of a let-like form, starting at its keyword.
+func (p *Parser) parseLetForm() ([]string, []Expression, []Expression, error) {
+	form := p.currentToken.Content
 	p.nextToken()
 
 	// (let ( (var expr) ... ) body ... )
 	if p.currentToken.TokenType != lexer.TokenTypeLeftParen {
-		return nil, NewParsingError(p.currentToken, "expected '(' after let")
+		return nil, nil, nil, NewParsingError(p.currentToken, fmt.Sprintf("expected '(' after %s", form))
 	}
 
 	p.nextToken()
@@ -320,12 +565,12 @@ func (p *Parser) parseLetExpression() (Expression, error) {
 	parameterExprs := make([]Expression, 0)
 	for p.currentToken.TokenType != lexer.TokenTypeRightParen {
 		if p.currentToken.TokenType != lexer.TokenTypeLeftParen {
-			return nil, NewParsingError(p.currentToken, "expected '(' in binding list")
+			return nil, nil, nil, NewParsingError(p.currentToken, "expected '(' in binding list")
 		}
 		p.nextToken()
 
-		if p.currentToken.TokenType != lexer.TokenTypeIdentifier {
-			return nil, NewParsingError(p.currentToken, "expected identifier in binding")
+		if err := p.checkBindingName("bound by "+form, "expected identifier in binding", p.allowShadowing); err != nil {
+			return nil, nil, nil, err
 		}
 		parameterName := p.currentToken.Content
 
@@ -333,11 +578,11 @@ func (p *Parser) parseLetExpression() (Expression, error) {
 
 		parameterExp, err := p.parseExpression()
 		if err != nil {
-			return nil, NewParsingError(p.currentToken, err.Error())
+			return nil, nil, nil, NewParsingError(p.currentToken, err.Error())
 		}
 
 		if p.currentToken.TokenType != lexer.TokenTypeRightParen {
-			return nil, NewParsingError(p.currentToken, "expected ')' after binding")
+			return nil, nil, nil, NewParsingError(p.currentToken, "expected ')' after binding")
 		}
 
 		p.nextToken()
@@ -352,25 +597,15 @@ func (p *Parser) parseLetExpression() (Expression, error) {
 	for p.currentToken.TokenType != lexer.TokenTypeRightParen {
 		expr, err := p.parseExpression()
 		if err != nil {
-			return nil, NewParsingError(p.currentToken, err.Error())
+			return nil, nil, nil, NewParsingError(p.currentToken, err.Error())
 		}
 		body = append(body, expr)
 	}
 	if len(body) == 0 {
-		return nil, NewParsingError(p.currentToken, "expected at least one expression in let body")
+		return nil, nil, nil, NewParsingError(p.currentToken, fmt.Sprintf("expected at least one expression in %s body", form))
 	}
 	p.nextToken()
-
-	lambda := &LambdaExpression{
-		LeftParenToken: firstToken,
-		Parameters:     parameterNames,
-		Body:           body,
-	}
-	return &CallExpression{
-		LeftParenToken: firstToken,
-		Operator:       lambda,
-		Operands:       parameterExprs,
-	}, nil
+	return parameterNames, parameterExprs, body, nil
 }
 
 func (p *Parser) parseCondExpression() (Expression, error) {
@@ -384,8 +619,10 @@ func (p *Parser) parseCondExpression() (Expression, error) {
 	ifFirstToken := p.currentToken
 
 	p.nextToken()
-	var ifExp *IfExpression
-	var currentIfExp = ifExp
+	// root is the expression of the first clause, currentIfExp the if of the last clause, the
+	// next clause is its alternative
+	var root Expression
+	var currentIfExp *IfExpression
 	for {
 		if p.currentToken.TokenType == lexer.TokenTypeRightParen {
 			break
@@ -402,6 +639,10 @@ func (p *Parser) parseCondExpression() (Expression, error) {
 			if err != nil {
 				return nil, NewParsingError(p.currentToken, err.Error())
 			}
+			arrow := p.currentToken.TokenType == lexer.TokenTypeIdentifier && p.currentToken.Content == "=>"
+			if arrow {
+				p.nextToken()
+			}
 
 			exps := make([]Expression, 0)
 			for p.currentToken.TokenType != lexer.TokenTypeRightParen {
@@ -411,39 +652,49 @@ func (p *Parser) parseCondExpression() (Expression, error) {
 				}
 				exps = append(exps, exp)
 			}
-			var consequent Expression
-			if len(exps) == 0 {
-				return nil, NewParsingError(p.currentToken, "expected at least one expression in cond clause")
-			} else if len(exps) == 1 {
-				consequent = exps[0]
-			} else {
-				consequent = &BeginExpression{Expressions: exps, LeftParenToken: exps[0].Token()}
+
+			var clauseIfExp *IfExpression
+			var clauseExp Expression
+			switch {
+			case arrow:
+				// (test => receiver) calls receiver with the value of test
+				if len(exps) != 1 {
+					return nil, NewParsingError(p.currentToken, "expected exactly one expression after => in cond clause")
+				}
+				value := &IdentifierExpression{NameToken: test.Token(), Value: condValue}
+				call := &CallExpression{LeftParenToken: exps[0].Token(), Operator: exps[0], Operands: []Expression{value}}
+				clauseIfExp = &IfExpression{LeftParenToken: ifFirstToken, Predicate: value, Consequent: call}
+				clauseExp = bindCondValue(ifFirstToken, test, clauseIfExp)
+			case len(exps) == 0:
+				// (test) is the value of test
+				value := &IdentifierExpression{NameToken: test.Token(), Value: condValue}
+				clauseIfExp = &IfExpression{LeftParenToken: ifFirstToken, Predicate: value, Consequent: value}
+				clauseExp = bindCondValue(ifFirstToken, test, clauseIfExp)
+			default:
+				var consequent Expression
+				if len(exps) == 1 {
+					consequent = exps[0]
+				} else {
+					consequent = &BeginExpression{Expressions: exps, LeftParenToken: exps[0].Token()}
+				}
+				clauseIfExp = &IfExpression{LeftParenToken: ifFirstToken, Predicate: test, Consequent: consequent}
+				clauseExp = clauseIfExp
 			}
 
 			if !p.match(lexer.TokenTypeRightParen) {
 				return nil, NewParsingError(p.currentToken, "expected ')' after cond clause")
 			}
 
-			if ifExp == nil {
-				ifExp = &IfExpression{
-					//LeftParenToken: test.Token(),
-					Predicate:  test,
-					Consequent: consequent,
-				}
-				currentIfExp = ifExp
+			if root == nil {
+				root = clauseExp
 			} else {
-				newIfExp := &IfExpression{
-					LeftParenToken: ifFirstToken,
-					Predicate:      test,
-					Consequent:     consequent,
-				}
-				currentIfExp.Alternative = newIfExp
-				currentIfExp = newIfExp
+				currentIfExp.Alternative = clauseExp
 			}
+			currentIfExp = clauseIfExp
 		}
 	}
 
-	if ifExp == nil {
+	if root == nil {
 		return nil, NewParsingError(p.currentToken, "expected at least one cond clause")
 	}
 
@@ -482,14 +733,164 @@ func (p *Parser) parseCondExpression() (Expression, error) {
 		return nil, NewParsingError(p.currentToken, "expected ')' after cond expression")
 	}
 
-	ifExp.LeftParenToken = ifFirstToken
-	return ifExp, nil
+	return root, nil
+}
+
+// condValue is the variable a cond clause without a body or with => binds the value of its test
+// to. It's named like an identifier introduced by a macro expansion, numbered 0 which no
+// expansion is, so it doesn't capture a variable of the clauses after it.
+const condValue = "=>" + introducedSeparator + "0"
+
+// bindCondValue returns (let ((condValue test)) exp).
+func bindCondValue(token lexer.Token, test Expression, exp Expression) Expression {
+	return &CallExpression{
+		LeftParenToken: token,
+		Operator:       &LambdaExpression{LeftParenToken: token, Parameters: []string{condValue}, Body: []Expression{exp}},
+		Operands:       []Expression{test},
+	}
+}
+
+// parseGuardExpression parses (guard (var clause ...) body ...), whose clauses are those of cond.
+func (p *Parser) parseGuardExpression() (Expression, error) {
+	firstToken := p.currentToken
+	p.nextToken()
+
+	if !p.match(lexer.TokenTypeLeftParen) {
+		return nil, NewParsingError(p.currentToken, "expected '(' after guard")
+	}
+	if err := p.checkBindingName("bound by guard", "expected identifier after '(' in guard", p.allowShadowing); err != nil {
+		return nil, err
+	}
+	variable := p.currentToken.Content
+	p.nextToken()
+
+	var clauses []GuardClause
+	for p.currentToken.TokenType != lexer.TokenTypeRightParen {
+		if !p.match(lexer.TokenTypeLeftParen) {
+			return nil, NewParsingError(p.currentToken, "expected '(' in guard clause")
+		}
+		var clause GuardClause
+		if p.currentToken.TokenType == lexer.TokenTypeElse {
+			p.nextToken()
+		} else {
+			test, err := p.parseExpression()
+			if err != nil {
+				return nil, NewParsingError(p.currentToken, err.Error())
+			}
+			clause.Test = test
+			if p.currentToken.TokenType == lexer.TokenTypeIdentifier && p.currentToken.Content == "=>" {
+				clause.Arrow = true
+				p.nextToken()
+			}
+		}
+		for p.currentToken.TokenType != lexer.TokenTypeRightParen {
+			exp, err := p.parseExpression()
+			if err != nil {
+				return nil, NewParsingError(p.currentToken, err.Error())
+			}
+			clause.Body = append(clause.Body, exp)
+		}
+		if clause.Arrow && len(clause.Body) != 1 {
+			return nil, NewParsingError(p.currentToken, "expected exactly one expression after => in guard clause")
+		}
+		if clause.Test == nil && len(clause.Body) == 0 {
+			return nil, NewParsingError(p.currentToken, "expected at least one expression in guard clause")
+		}
+		p.nextToken()
+		clauses = append(clauses, clause)
+		if clause.Test == nil && p.currentToken.TokenType != lexer.TokenTypeRightParen {
+			return nil, NewParsingError(p.currentToken, "the else clause has to be the last clause of guard")
+		}
+	}
+	if len(clauses) == 0 {
+		return nil, NewParsingError(p.currentToken, "expected at least one guard clause")
+	}
+	p.nextToken()
+
+	body := make([]Expression, 0)
+	for p.currentToken.TokenType != lexer.TokenTypeRightParen {
+		exp, err := p.parseExpression()
+		if err != nil {
+			return nil, NewParsingError(p.currentToken, err.Error())
+		}
+		body = append(body, exp)
+	}
+	if len(body) == 0 {
+		return nil, NewParsingError(p.currentToken, "expected at least one expression in guard body")
+	}
+	p.nextToken()
+
+	return &GuardExpression{GuardToken: firstToken, Variable: variable, Clauses: clauses, Body: body}, nil
+}
+
+// parseParameterizeExpression parses (parameterize ((parameter value) ...) body ...), whose
+// parameters are expressions rather than names.
+func (p *Parser) parseParameterizeExpression() (Expression, error) {
+	firstToken := p.currentToken
+	p.nextToken()
+
+	if !p.match(lexer.TokenTypeLeftParen) {
+		return nil, NewParsingError(p.currentToken, "expected '(' after parameterize")
+	}
+	parameters := make([]Expression, 0)
+	values := make([]Expression, 0)
+	for p.currentToken.TokenType != lexer.TokenTypeRightParen {
+		if !p.match(lexer.TokenTypeLeftParen) {
+			return nil, NewParsingError(p.currentToken, "expected '(' in parameterize binding")
+		}
+		parameter, err := p.parseExpression()
+		if err != nil {
+			return nil, NewParsingError(p.currentToken, err.Error())
+		}
+		value, err := p.parseExpression()
+		if err != nil {
+			return nil, NewParsingError(p.currentToken, err.Error())
+		}
+		if !p.match(lexer.TokenTypeRightParen) {
+			return nil, NewParsingError(p.currentToken, "expected ')' after parameterize binding")
+		}
+		parameters = append(parameters, parameter)
+		values = append(values, value)
+	}
+	p.nextToken()
+
+	body := make([]Expression, 0)
+	for p.currentToken.TokenType != lexer.TokenTypeRightParen {
+		exp, err := p.parseExpression()
+		if err != nil {
+			return nil, NewParsingError(p.currentToken, err.Error())
+		}
+		body = append(body, exp)
+	}
+	if len(body) == 0 {
+		return nil, NewParsingError(p.currentToken, "expected at least one expression in parameterize body")
+	}
+	p.nextToken()
+
+	return &ParameterizeExpression{ParameterizeToken: firstToken, Parameters: parameters, Values: values, Body: body}, nil
+}
+
+func (p *Parser) parseAssertExpression() (Expression, error) {
+	assertToken := p.currentToken
+	p.nextToken()
+
+	if p.currentToken.TokenType == lexer.TokenTypeRightParen {
+		return nil, NewParsingError(p.currentToken, "expected an expression after assert")
+	}
+	exp, err := p.parseExpression()
+	if err != nil {
+		return nil, NewParsingError(p.currentToken, err.Error())
+	}
+	if !p.match(lexer.TokenTypeRightParen) {
+		return nil, NewParsingError(p.currentToken, "expected ')' after the expression of assert")
+	}
+	return &AssertExpression{AssertToken: assertToken, Expression: exp}, nil
 }
 
 func (p *Parser) parseSetExpression() (Expression, error) {
 	p.nextToken()
-	if p.currentToken.TokenType != lexer.TokenTypeIdentifier {
-		return nil, NewParsingError(p.currentToken, "expected identifier after set!")
+	if err := p.checkBindingName("assigned by set!", "expected identifier after set!", true); err != nil {
+		return nil, err
 	}
 	name := p.currentToken.Content
 
@@ -540,6 +941,10 @@ func (p *Parser) parseGroupExpression() (Expression, error) {
 		return p.parseDefineExpression()
 	case lexer.TokenTypeLet:
 		return p.parseLetExpression()
+	case lexer.TokenTypeLetStar:
+		return p.parseLetStarExpression()
+	case lexer.TokenTypeLetrec, lexer.TokenTypeLetrecStar:
+		return p.parseLetrecExpression()
 	case lexer.TokenTypeBegin:
 		return p.parseBeginExpression()
 	case lexer.TokenTypeSet:
@@ -557,19 +962,350 @@ func (p *Parser) parseGroupExpression() (Expression, error) {
 	case lexer.TokenTypeNot:
 		return p.parseCallExpression()
 	case lexer.TokenTypeRightParen:
-		p.nextToken()
-		return &ListExpression{Elements: []Expression{}}, nil
-	case lexer.TokenTypeDelay:
+		return nil, NewParsingError(p.prevToken, "empty combination: () has no procedure to call, write '() for the empty list")
+	case lexer.TokenTypeDelay, lexer.TokenTypeDelayForce:
 		return p.parseDelayExpression()
 	case lexer.TokenTypeConsStream:
 		return p.parseStreamExpression()
+	case lexer.TokenTypeDefineLibrary:
+		return p.parseDefineLibraryExpression()
+	case lexer.TokenTypeImport:
+		return p.parseImportExpression()
+	case lexer.TokenTypeAmb:
+		return p.parseAmbExpression()
+	case lexer.TokenTypeDefineSyntax:
+		return p.parseDefineSyntaxExpression()
+	case lexer.TokenTypeLetSyntax, lexer.TokenTypeLetrecSyntax:
+		return p.parseLetSyntaxExpression()
+	case lexer.TokenTypeDefineMacro:
+		return p.parseDefineMacroExpression()
+	case lexer.TokenTypeGuard:
+		return p.parseGuardExpression()
+	case lexer.TokenTypeParameterize:
+		return p.parseParameterizeExpression()
+	case lexer.TokenTypeAssert:
+		return p.parseAssertExpression()
 	default:
+		if p.currentToken.TokenType == lexer.TokenTypeIdentifier {
+			if rules, transformer, ok := p.macros.lookup(p.currentToken.Content); ok {
+				if transformer != nil {
+					return p.parseTransformerUse(transformer)
+				}
+				return p.parseMacroUse(rules)
+			}
+		}
+		if p.racket && p.currentToken.TokenType == lexer.TokenTypeIdentifier {
+			switch p.currentToken.Content {
+			case "require":
+				return p.parseRequireExpression()
+			case "provide":
+				return p.parseProvideExpression()
+			}
+		}
 		// ( + 1 2 )
 		// ( ( a b) )
 		return p.parseCallExpression()
 	}
 }
 
+// (require spec ...), a spec is a file name string or a library such as racket/list
+func (p *Parser) parseRequireExpression() (Expression, error) {
+	exp := &RequireExpression{RequireToken: p.currentToken}
+	p.nextToken()
+	for p.currentToken.TokenType != lexer.TokenTypeRightParen {
+		if p.currentToken.TokenType == lexer.TokenTypeString {
+			exp.Files = append(exp.Files, p.currentToken.Content)
+		}
+		spec, err := p.parseModuleSpec()
+		if err != nil {
+			return nil, err
+		}
+		exp.Specs = append(exp.Specs, spec)
+	}
+	p.nextToken()
+	return exp, nil
+}
+
+// (provide spec ...)
+func (p *Parser) parseProvideExpression() (Expression, error) {
+	exp := &ProvideExpression{ProvideToken: p.currentToken}
+	p.nextToken()
+	for p.currentToken.TokenType != lexer.TokenTypeRightParen {
+		spec, err := p.parseModuleSpec()
+		if err != nil {
+			return nil, err
+		}
+		exp.Specs = append(exp.Specs, spec)
+	}
+	p.nextToken()
+	return exp, nil
+}
+
+// parseModuleSpec reads a spec of require or provide, e.g. (all-defined-out), and returns its rendering.
+func (p *Parser) parseModuleSpec() (string, error) {
+	var sb strings.Builder
+	depth := 0
+	for {
+		token := p.currentToken
+		switch token.TokenType {
+		case lexer.TokenTypeEOF, lexer.TokenTypeInvalid:
+			return "", NewParsingError(token, fmt.Sprintf("unexpected token: %s", token.TokenType))
+		case lexer.TokenTypeLeftParen:
+			depth++
+		case lexer.TokenTypeRightParen:
+			depth--
+		}
+		if sb.Len() > 0 && token.TokenType != lexer.TokenTypeRightParen && !strings.HasSuffix(sb.String(), "(") {
+			sb.WriteByte(' ')
+		}
+		switch token.TokenType {
+		case lexer.TokenTypeLeftParen:
+			sb.WriteByte('(')
+		case lexer.TokenTypeRightParen:
+			sb.WriteByte(')')
+		case lexer.TokenTypeString:
+			sb.WriteString(lexer.QuoteString(token.Content))
+		default:
+			sb.WriteString(token.Content)
+		}
+		p.nextToken()
+		if depth == 0 {
+			return sb.String(), nil
+		}
+	}
+}
+
+// (define-library (name ...) (export id ...) (import set ...) (begin exp ...))
+func (p *Parser) parseDefineLibraryExpression() (Expression, error) {
+	defineLibraryToken := p.currentToken
+	p.nextToken()
+
+	name, err := p.parseLibraryName()
+	if err != nil {
+		return nil, err
+	}
+
+	exp := &DefineLibraryExpression{DefineLibraryToken: defineLibraryToken, Name: name}
+	for !p.match(lexer.TokenTypeRightParen) {
+		if !p.match(lexer.TokenTypeLeftParen) {
+			return nil, NewParsingError(p.currentToken, "expected a library declaration")
+		}
+
+		switch {
+		case p.currentToken.TokenType == lexer.TokenTypeIdentifier && p.currentToken.Content == "export":
+			p.nextToken()
+			for !p.match(lexer.TokenTypeRightParen) {
+				export, err := p.parseLibraryExport()
+				if err != nil {
+					return nil, err
+				}
+				exp.Exports = append(exp.Exports, export)
+			}
+		case p.currentToken.TokenType == lexer.TokenTypeImport:
+			p.nextToken()
+			sets, err := p.parseImportSets()
+			if err != nil {
+				return nil, err
+			}
+			exp.Imports = append(exp.Imports, sets...)
+		case p.currentToken.TokenType == lexer.TokenTypeBegin:
+			p.nextToken()
+			for !p.match(lexer.TokenTypeRightParen) {
+				body, err := p.parseExpression()
+				if err != nil {
+					return nil, NewParsingError(p.currentToken, err.Error())
+				}
+				exp.Body = append(exp.Body, body)
+			}
+		default:
+			return nil, NewParsingError(p.currentToken, fmt.Sprintf("unsupported library declaration `%s`", p.currentToken.Content))
+		}
+	}
+
+	return exp, nil
+}
+
+func (p *Parser) parseLibraryExport() (LibraryExport, error) {
+	if !p.match(lexer.TokenTypeLeftParen) {
+		name, err := p.parseLibraryIdentifier()
+		return LibraryExport{Internal: name, External: name}, err
+	}
+
+	if p.currentToken.Content != "rename" {
+		return LibraryExport{}, NewParsingError(p.currentToken, "expected (rename internal external) in export")
+	}
+	p.nextToken()
+	export, err := p.parseRenamePair()
+	if err != nil {
+		return LibraryExport{}, err
+	}
+	if !p.match(lexer.TokenTypeRightParen) {
+		return LibraryExport{}, NewParsingError(p.currentToken, "expected ')' at the end of rename")
+	}
+	return export, nil
+}
+
+// (import set ...)
+func (p *Parser) parseImportExpression() (Expression, error) {
+	importToken := p.currentToken
+	p.nextToken()
+
+	sets, err := p.parseImportSets()
+	if err != nil {
+		return nil, err
+	}
+	return &ImportExpression{ImportToken: importToken, Sets: sets}, nil
+}
+
+// parseImportSets parses import sets up to and including the closing ')'.
+func (p *Parser) parseImportSets() ([]*ImportSet, error) {
+	sets := make([]*ImportSet, 0)
+	for !p.match(lexer.TokenTypeRightParen) {
+		set, err := p.parseImportSet()
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, set)
+	}
+	if len(sets) == 0 {
+		return nil, NewParsingError(p.prevToken, "expected at least one import set")
+	}
+	return sets, nil
+}
+
+var importModifiers = map[string]ImportModifier{
+	"only":   ImportOnly,
+	"except": ImportExcept,
+	"prefix": ImportPrefix,
+	"rename": ImportRename,
+}
+
+func (p *Parser) parseImportSet() (*ImportSet, error) {
+	token := p.currentToken
+	if token.TokenType != lexer.TokenTypeLeftParen {
+		return nil, NewParsingError(token, "expected an import set")
+	}
+	p.nextToken()
+
+	modifier, ok := importModifiers[p.currentToken.Content]
+	if !ok || p.currentToken.TokenType != lexer.TokenTypeIdentifier {
+		name, err := p.parseLibraryNameParts()
+		if err != nil {
+			return nil, err
+		}
+		return &ImportSet{Token: token, Modifier: ImportLibrary, Library: name}, nil
+	}
+	p.nextToken()
+
+	inner, err := p.parseImportSet()
+	if err != nil {
+		return nil, err
+	}
+	set := &ImportSet{Token: token, Modifier: modifier, Inner: inner}
+	for !p.match(lexer.TokenTypeRightParen) {
+		switch modifier {
+		case ImportOnly, ImportExcept:
+			name, err := p.parseLibraryIdentifier()
+			if err != nil {
+				return nil, err
+			}
+			set.Identifiers = append(set.Identifiers, name)
+		case ImportPrefix:
+			if set.Prefix != "" {
+				return nil, NewParsingError(p.currentToken, "expected a single prefix")
+			}
+			prefix, err := p.parseLibraryIdentifier()
+			if err != nil {
+				return nil, err
+			}
+			set.Prefix = prefix
+		case ImportRename:
+			if !p.match(lexer.TokenTypeLeftParen) {
+				return nil, NewParsingError(p.currentToken, "expected (from to) in rename")
+			}
+			rename, err := p.parseRenamePair()
+			if err != nil {
+				return nil, err
+			}
+			if !p.match(lexer.TokenTypeRightParen) {
+				return nil, NewParsingError(p.currentToken, "expected ')' at the end of rename")
+			}
+			set.Renames = append(set.Renames, rename)
+		}
+	}
+	if modifier == ImportPrefix && set.Prefix == "" {
+		return nil, NewParsingError(p.prevToken, "expected a prefix")
+	}
+	return set, nil
+}
+
+func (p *Parser) parseRenamePair() (LibraryExport, error) {
+	from, err := p.parseLibraryIdentifier()
+	if err != nil {
+		return LibraryExport{}, err
+	}
+	to, err := p.parseLibraryIdentifier()
+	if err != nil {
+		return LibraryExport{}, err
+	}
+	return LibraryExport{Internal: from, External: to}, nil
+}
+
+func (p *Parser) parseLibraryName() ([]string, error) {
+	if !p.match(lexer.TokenTypeLeftParen) {
+		return nil, NewParsingError(p.currentToken, "expected a library name")
+	}
+	return p.parseLibraryNameParts()
+}
+
+// parseLibraryNameParts parses the identifiers and integers of a library name up to and including the closing ')'.
+func (p *Parser) parseLibraryNameParts() ([]string, error) {
+	parts := make([]string, 0)
+	for !p.match(lexer.TokenTypeRightParen) {
+		if p.currentToken.TokenType == lexer.TokenTypeNumber {
+			parts = append(parts, p.currentToken.Content)
+			p.nextToken()
+			continue
+		}
+		part, err := p.parseLibraryIdentifier()
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return nil, NewParsingError(p.prevToken, "expected a non-empty library name")
+	}
+	return parts, nil
+}
+
+// parseLibraryIdentifier accepts keywords and operators too, so libraries can export e.g. `+`.
+func (p *Parser) parseLibraryIdentifier() (string, error) {
+	switch p.currentToken.TokenType {
+	case lexer.TokenTypeLeftParen, lexer.TokenTypeRightParen, lexer.TokenTypeEOF, lexer.TokenTypeInvalid,
+		lexer.TokenTypeNumber, lexer.TokenTypeString, lexer.TokenTypeQuote, lexer.TokenTypeDot, lexer.TokenTypeVectorPrefix, lexer.TokenTypeChar:
+		return "", NewParsingError(p.currentToken, fmt.Sprintf("expected an identifier, got token: %s", p.currentToken.TokenType))
+	}
+	name := p.currentToken.Content
+	p.nextToken()
+	return name, nil
+}
+
+func (p *Parser) parseAmbExpression() (Expression, error) {
+	ambToken := p.currentToken
+	p.nextToken()
+
+	choices := make([]Expression, 0)
+	for !p.match(lexer.TokenTypeRightParen) {
+		choice, err := p.parseExpression()
+		if err != nil {
+			return nil, NewParsingError(p.currentToken, err.Error())
+		}
+		choices = append(choices, choice)
+	}
+	return &AmbExpression{AmbToken: ambToken, Choices: choices}, nil
+}
+
 func (p *Parser) parseStreamExpression() (Expression, error) {
 	consStreamToken := p.currentToken
 	p.nextToken()
@@ -595,6 +1331,7 @@ func (p *Parser) parseStreamExpression() (Expression, error) {
 	}, nil
 }
 
+// parseDelayExpression parses delay and delay-force.
 func (p *Parser) parseDelayExpression() (Expression, error) {
 	delayToken := p.currentToken
 	p.nextToken()
@@ -604,9 +1341,9 @@ func (p *Parser) parseDelayExpression() (Expression, error) {
 		return nil, NewParsingError(p.currentToken, err.Error())
 	}
 	if !p.match(lexer.TokenTypeRightParen) {
-		return nil, NewParsingError(p.currentToken, "expected ')' at the end of delay expression")
+		return nil, NewParsingError(p.currentToken, fmt.Sprintf("expected ')' at the end of %s expression", delayToken.Content))
 	}
-	return &DelayExpression{Expression: exp, DelayToken: delayToken}, nil
+	return &DelayExpression{Expression: exp, DelayToken: delayToken, Force: delayToken.TokenType == lexer.TokenTypeDelayForce}, nil
 }
 
 func (p *Parser) parsePrimitiveProcedure() (Expression, error) {
@@ -615,6 +1352,13 @@ func (p *Parser) parsePrimitiveProcedure() (Expression, error) {
 	return exp, nil
 }
 
+// parseDatum parses a TokenTypeDatum token.
+func (p *Parser) parseDatum() Expression {
+	exp := &DatumExpression{DatumToken: p.currentToken, Value: p.currentToken.Datum}
+	p.nextToken()
+	return exp
+}
+
 func (p *Parser) parseIdentifier() (Expression, error) {
 	exp := &IdentifierExpression{Value: p.currentToken.Content, NameToken: p.currentToken}
 	p.nextToken()
@@ -641,6 +1385,12 @@ func (p *Parser) parseQuoteListExpression() (Expression, error) {
 				return nil, NewParsingError(p.currentToken, err.Error())
 			}
 			elements = append(elements, element)
+		case lexer.TokenTypeVectorPrefix:
+			element, err := p.parseVectorExpression()
+			if err != nil {
+				return nil, NewParsingError(p.currentToken, err.Error())
+			}
+			elements = append(elements, element)
 		case lexer.TokenTypeNumber:
 			element, err := p.parseNumber()
 			if err != nil {
@@ -653,6 +1403,14 @@ func (p *Parser) parseQuoteListExpression() (Expression, error) {
 				return nil, NewParsingError(p.currentToken, err.Error())
 			}
 			elements = append(elements, element)
+		case lexer.TokenTypeChar:
+			element, err := p.parseChar()
+			if err != nil {
+				return nil, NewParsingError(p.currentToken, err.Error())
+			}
+			elements = append(elements, element)
+		case lexer.TokenTypeDatum:
+			elements = append(elements, p.parseDatum())
 		case lexer.TokenTypeEOF:
 			return nil, NewParsingError(p.currentToken, fmt.Sprintf("unexpected token: %s", p.currentToken.TokenType))
 		case lexer.TokenTypeInvalid:
@@ -668,6 +1426,20 @@ func (p *Parser) parseQuoteListExpression() (Expression, error) {
 	return &ListExpression{LeftParenToken: firstToken, Elements: elements}, nil
 }
 
+// parseVectorExpression parses a vector literal, at its # token.
+func (p *Parser) parseVectorExpression() (Expression, error) {
+	prefixToken := p.currentToken
+	p.nextToken()
+	if !p.match(lexer.TokenTypeLeftParen) {
+		return nil, NewParsingError(p.currentToken, fmt.Sprintf("expected '(' after #, got token: %s", p.currentToken.TokenType))
+	}
+	list, err := p.parseQuoteListExpression()
+	if err != nil {
+		return nil, err
+	}
+	return &VectorExpression{PrefixToken: prefixToken, Elements: list.(*ListExpression).Elements}, nil
+}
+
 func (p *Parser) parseQuoteExpression() (Expression, error) {
 	// the single quote can be used to denote lists or symbols.
 	//	(define a 1)
@@ -701,13 +1473,17 @@ func (p *Parser) parseQuoteExpression() (Expression, error) {
 	case lexer.TokenTypeLeftParen:
 		p.nextToken()
 		return p.parseQuoteListExpression()
+	case lexer.TokenTypeVectorPrefix:
+		return p.parseVectorExpression()
 	case lexer.TokenTypeNumber:
 		return p.parseNumber()
 	case lexer.TokenTypeString:
 		return p.parseString()
+	case lexer.TokenTypeChar:
+		return p.parseChar()
 	case lexer.TokenTypeEOF:
 		return nil, NewParsingError(p.currentToken, fmt.Sprintf("unexpected token: %s", p.currentToken.TokenType))
-	case lexer.TokenTypeRightParen:
+	case lexer.TokenTypeRightParen, lexer.TokenTypeInvalid:
 		return nil, NewParsingError(p.currentToken, fmt.Sprintf("unexpected token: %s", p.currentToken.TokenType))
 	case lexer.TokenTypeQuote:
 		exp, err := p.parseQuoteExpression()
@@ -715,11 +1491,13 @@ func (p *Parser) parseQuoteExpression() (Expression, error) {
 			return nil, NewParsingError(p.currentToken, err.Error())
 		}
 
-		return &NestedSymbolExpression{quoteToken, exp}, nil
+		return &NestedSymbolExpression{QuoteToken: quoteToken, Value: exp}, nil
+	case lexer.TokenTypeDatum:
+		return p.parseDatum(), nil
 	default:
 		val := p.currentToken.Content
 		p.nextToken()
-		return &SymbolExpression{quoteToken, val}, nil
+		return &SymbolExpression{FirstToken: quoteToken, Value: val}, nil
 	}
 }
 
@@ -729,8 +1507,12 @@ func (p *Parser) parseExpression() (Expression, error) {
 		return p.parseNumber()
 	case lexer.TokenTypeString:
 		return p.parseString()
+	case lexer.TokenTypeChar:
+		return p.parseChar()
 	case lexer.TokenTypeLeftParen:
 		return p.parseGroupExpression()
+	case lexer.TokenTypeVectorPrefix:
+		return p.parseVectorExpression()
 	case lexer.TokenTypeEOF:
 		return nil, NewParsingError(p.currentToken, "EOF")
 	case lexer.TokenTypePlus:
@@ -767,6 +1549,8 @@ func (p *Parser) parseExpression() (Expression, error) {
 		return FalseLiteral, nil
 	case lexer.TokenTypeForce:
 		return p.parsePrimitiveProcedure()
+	case lexer.TokenTypeDatum:
+		return p.parseDatum(), nil
 
 	default:
 		return nil, NewParsingError(p.currentToken, fmt.Sprintf("unexpected token: %s", p.currentToken.TokenType))
@@ -1,6 +1,10 @@
 package parser
 
 import (
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -13,11 +17,17 @@ func TestParser_ParseNumber(t *testing.T) {
 	tests := []struct {
 		input          string
 		expectedString string
+		expectedValue  any
 	}{
-		{"123", "123"},
-		{"45.67", "45.67"},
-		{"-89", "-89"},
-		{"+9527", "+9527"},
+		{"123", "123", int64(123)},
+		{"45.67", "45.67", 45.67},
+		{"-89", "-89", int64(-89)},
+		{"+9527", "+9527", int64(9527)},
+		{"99999999999999999999", "99999999999999999999", new(big.Int).Mul(big.NewInt(9999999999), big.NewInt(10000000001))},
+		{"99999999999999999999.", "99999999999999999999.", 1e20},
+		{"6/3", "6/3", int64(2)},
+		{"#i1/2", "#i1/2", 0.5},
+		{"#x1F", "#x1F", int64(31)},
 	}
 	for _, tt := range tests {
 		text := tt.input
@@ -40,6 +50,10 @@ func TestParser_ParseNumber(t *testing.T) {
 		if numLiteral.String() != tt.expectedString {
 			t.Fatalf("expected string representation '%s', got %s", tt.expectedString, numLiteral.String())
 		}
+		// a *big.Int is compared by what it prints
+		if fmt.Sprintf("%T %v", numLiteral.Value, numLiteral.Value) != fmt.Sprintf("%T %v", tt.expectedValue, tt.expectedValue) {
+			t.Fatalf("expected value %#v, got %#v", tt.expectedValue, numLiteral.Value)
+		}
 	}
 }
 
@@ -182,6 +196,10 @@ func TestParser_ParseLetExpression(t *testing.T) {
 	}{
 		{"(let ((a 1)) (+ a 5))", "((lambda (a) (+ a 5)) 1)"},
 		{"(let ((a 1) (b 2)) (+ a b))", "((lambda (a b) (+ a b)) 1 2)"},
+		{"(letrec ((f (lambda () (g))) (g (lambda () 1))) (f))", "((lambda () (define (f) (g )) (define (g) 1) (f )) )"},
+		{"(let* ((a 1) (b (+ a 1))) (* a b))", "((lambda (a) ((lambda (b) (* a b)) (+ a 1))) 1)"},
+		{"(let* () 1 2)", "((lambda () 1 2) )"},
+		{"(letrec* ((a 1) (b (+ a 1))) b)", "((lambda () (define a 1) (define b (+ a 1)) b) )"},
 	}
 	for _, tt := range tests {
 		text := tt.input
@@ -238,6 +256,30 @@ func TestParser_ParseCondExpression(t *testing.T) {
 			t.Fatalf("expected string representation '%s', got %s", tt.expectedString, ifExpression.String())
 		}
 	}
+
+	// a clause with => or without a body binds the value of its test
+	arrowTests := []struct {
+		input          string
+		expectedString string
+	}{
+		{"(cond ((f a) => car) (else 1))", "((lambda (=>#0) (if =>#0 (car =>#0) 1)) (f a))"},
+		{"(cond (#f 1) ((f a)) (else 2))", "(if #f 1 ((lambda (=>#0) (if =>#0 =>#0 2)) (f a)))"},
+	}
+	for _, tt := range arrowTests {
+		program, err := New(lexer.New(strings.NewReader(tt.input))).Parse()
+		if err != nil {
+			t.Fatalf("input %s, unexpected error: %v", tt.input, err)
+		}
+		if got := program.Expressions[0].String(); got != tt.expectedString {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedString, got)
+		}
+	}
+	for _, input := range []string{"(cond (a =>))", "(cond (a => car cdr))"} {
+		_, err := New(lexer.New(strings.NewReader(input))).Parse()
+		if err == nil || !strings.Contains(err.Error(), "expected exactly one expression after => in cond clause") {
+			t.Fatalf("input %s, expected an error about =>, got %v", input, err)
+		}
+	}
 }
 
 func TestParser_ParseQuoteExpression(t *testing.T) {
@@ -252,6 +294,13 @@ func TestParser_ParseQuoteExpression(t *testing.T) {
 		{"'(1 2 3)", "'(1 2 3)"},
 		{"'\"hola\"", "\"hola\""},
 		{"''a", "''a"},
+		{"'(a (b \"c\") . d)", "'(a (b \"c\") . d)"},
+		{"'(.)", "'(.)"},
+		{"#(1 a \"b\")", "#(1 a \"b\")"},
+		{"'#(1 (a #(b)))", "#(1 (a #(b)))"},
+		{"'(a #() #(1))", "'(a #() #(1))"},
+		{`#\x41`, `#\A`},
+		{`'(#\( #\space . #\a)`, `'(#\( #\space . #\a)`},
 	}
 	for _, tt := range tests {
 		text := tt.input
@@ -274,6 +323,36 @@ func TestParser_ParseQuoteExpression(t *testing.T) {
 	}
 }
 
+func TestParser_PrintedProgramParses(t *testing.T) {
+	inputs := []string{
+		"(define (f a . rest) (if a rest))",
+		"(car '(x 'y))",
+		"(display '())",
+		"(vector-ref #(1 #(x) (y)) 0)",
+		`(list->string (list #\a #\) #\x0))`,
+	}
+	for _, input := range inputs {
+		program, err := New(lexer.New(strings.NewReader(input))).Parse()
+		if err != nil {
+			t.Fatalf("input %s, unexpected error: %v", input, err)
+		}
+		printed := program.Expressions[0].String()
+		reparsed, err := New(lexer.New(strings.NewReader(printed))).Parse()
+		if err != nil {
+			t.Fatalf("input %s, %s doesn't parse: %v", input, printed, err)
+		}
+		if reprinted := reparsed.Expressions[0].String(); reprinted != printed {
+			t.Fatalf("input %s, %s prints as %s", input, printed, reprinted)
+		}
+	}
+	if _, err := New(lexer.New(strings.NewReader(`'"unterminated`))).Parse(); err == nil {
+		t.Fatal("expected an error for a quoted unterminated string")
+	}
+	if _, err := New(lexer.New(strings.NewReader(`#(1 2`))).Parse(); err == nil {
+		t.Fatal("expected an error for an unterminated vector")
+	}
+}
+
 func TestParser_ParseSetExpression(t *testing.T) {
 	tests := []struct {
 		input          string
@@ -308,6 +387,7 @@ func TestParser_ParseDelayExpression(t *testing.T) {
 		expectedString string
 	}{
 		{"(delay (+ 1 2))", "(delay (+ 1 2))"},
+		{"(delay-force (f 1))", "(delay-force (f 1))"},
 	}
 	for _, tt := range tests {
 		text := tt.input
@@ -357,3 +437,473 @@ func TestParser_ParseStreamExpression(t *testing.T) {
 		}
 	}
 }
+
+func TestParser_ParseLibraryExpressions(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedString string
+	}{
+		{"(define-library (soup math 1) (export square (rename cube3 cube)) (import (scheme base)) (begin (define (square x) (* x x))))",
+			"(define-library (soup math 1) (export square (rename cube3 cube)) (import (scheme base)) (begin (define (square x) (* x x))))"},
+		{"(import (soup math))", "(import (soup math))"},
+		{"(import (prefix (only (soup math) square cube) m:) (rename (except (lib) a) (b c)))",
+			"(import (prefix (only (soup math) square cube) m:) (rename (except (lib) a) (b c)))"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(strings.NewReader(tt.input))
+		p := New(l)
+
+		program, err := p.Parse()
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(program.Expressions) != 1 {
+			t.Fatalf("expected 1 expression, got %d", len(program.Expressions))
+		}
+
+		exp := program.Expressions[0]
+		if exp.String() != tt.expectedString {
+			t.Fatalf("expected string representation '%s', got %s", tt.expectedString, exp.String())
+		}
+	}
+
+	errorInputs := []string{
+		"(import)",
+		"(import (prefix (lib)))",
+		"(define-library lib)",
+		"(define-library (lib) (provide a))",
+		"(import (only (lib) \"a\"))",
+	}
+	for _, input := range errorInputs {
+		if _, err := New(lexer.New(strings.NewReader(input))).Parse(); err == nil {
+			t.Fatalf("input %s, expected a parsing error", input)
+		}
+	}
+}
+
+func TestParser_ParseAll(t *testing.T) {
+	tests := []struct {
+		input               string
+		expectedExpressions []string
+		expectedErrorLines  []int
+	}{
+		{"(define x 1)\n(define)\n(+ x 1)", []string{"(define x 1)", "(+ x 1)"}, []int{2}},
+		{"(if)\n(define (f) (lambda))\n(f 1)", []string{"(f 1)"}, []int{1, 2}},
+		{")\n(car '(1))", []string{"(car '(1))"}, []int{1}},
+		{"(define x 1)\n(display x", []string{"(define x 1)"}, []int{2}},
+		{"(+ 1 2)", []string{"(+ 1 2)"}, nil},
+		{"(define (f) ())\n(f)\n()", []string{"(f )"}, []int{1, 3}},
+	}
+	for _, tt := range tests {
+		program, errs := New(lexer.New(strings.NewReader(tt.input))).ParseAll()
+		expressions := make([]string, len(program.Expressions))
+		for i, exp := range program.Expressions {
+			expressions[i] = exp.String()
+		}
+		if strings.Join(expressions, "\n") != strings.Join(tt.expectedExpressions, "\n") {
+			t.Fatalf("input %q, expected expressions %v, got %v", tt.input, tt.expectedExpressions, expressions)
+		}
+		lines := make([]int, len(errs))
+		for i, err := range errs {
+			lines[i] = err.Token.Line
+		}
+		if len(lines) != len(tt.expectedErrorLines) {
+			t.Fatalf("input %q, expected errors on lines %v, got %v", tt.input, tt.expectedErrorLines, errs)
+		}
+		for i := range lines {
+			if lines[i] != tt.expectedErrorLines[i] {
+				t.Fatalf("input %q, expected errors on lines %v, got %v", tt.input, tt.expectedErrorLines, lines)
+			}
+		}
+	}
+}
+
+func TestParser_Unclosed(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"(define (f x)\n  (+ x 1)\n", "unclosed '(' opened at 1:1"},
+		{"(define (f x)\n  (list (car x)\n    y", "unclosed '(' opened at 2:3"},
+		{"(list 1\n  '(a b", "unclosed '(' opened at 2:4"},
+		{"'", "unexpected token: EOF"},
+	}
+	for _, tt := range tests {
+		_, err := New(lexer.New(strings.NewReader(tt.input))).Parse()
+		var parsingError *ParsingError
+		if !errors.As(err, &parsingError) || parsingError.Message != tt.expectedMessage {
+			t.Fatalf("input %q, expected %q, got %v", tt.input, tt.expectedMessage, err)
+		}
+		// ParseAll reports it the same way
+		_, errs := New(lexer.New(strings.NewReader(tt.input))).ParseAll()
+		if len(errs) != 1 || errs[0].Message != tt.expectedMessage {
+			t.Fatalf("input %q, expected %q from ParseAll, got %v", tt.input, tt.expectedMessage, errs)
+		}
+	}
+}
+
+func TestParser_Guard(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedString string
+	}{
+		{"(guard (e ((symbol? e) 'sym) ((string? e) (display e) 'str) (else 0)) (raise 'x) 1)",
+			"(guard (e ((symbol? e) 'sym) ((string? e) (display e) 'str) (else 0)) (raise 'x) 1)"},
+		{"(guard (e (#t e)) (car '()))", "(guard (e (#t e)) (car '()))"},
+		{"(guard (e ((f e) => car) ((g e))) 1)", "(guard (e ((f e) => car) ((g e))) 1)"},
+	}
+	for _, tt := range tests {
+		program, err := New(lexer.New(strings.NewReader(tt.input))).Parse()
+		if err != nil {
+			t.Fatalf("input %s, unexpected error: %v", tt.input, err)
+		}
+		if got := program.Expressions[0].String(); got != tt.expectedString {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedString, got)
+		}
+	}
+
+	errorTests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"(guard e (#t 1))", "expected '(' after guard"},
+		{"(guard (e) 1)", "expected at least one guard clause"},
+		{"(guard (e (else)) 1)", "expected at least one expression in guard clause"},
+		{"(guard (e (#t =>)) 1)", "expected exactly one expression after => in guard clause"},
+		{"(guard (e (else 1) (#t 2)) 1)", "the else clause has to be the last clause of guard"},
+		{"(guard (e (#t 1)))", "expected at least one expression in guard body"},
+	}
+	for _, tt := range errorTests {
+		_, err := New(lexer.New(strings.NewReader(tt.input))).Parse()
+		if err == nil || !strings.Contains(err.Error(), tt.expectedMessage) {
+			t.Fatalf("input %s, expected %q, got %v", tt.input, tt.expectedMessage, err)
+		}
+	}
+}
+
+func TestParser_Parameterize(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedString string
+	}{
+		{"(parameterize ((p 1) ((make-p 0) (+ 1 2))) (display p) p)", "(parameterize ((p 1) ((make-p 0) (+ 1 2))) (display p) p)"},
+		{"(parameterize () 1)", "(parameterize () 1)"},
+	}
+	for _, tt := range tests {
+		program, err := New(lexer.New(strings.NewReader(tt.input))).Parse()
+		if err != nil {
+			t.Fatalf("input %s, unexpected error: %v", tt.input, err)
+		}
+		if got := program.Expressions[0].String(); got != tt.expectedString {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedString, got)
+		}
+	}
+
+	errorTests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"(parameterize p 1)", "expected '(' after parameterize"},
+		{"(parameterize (p 1) 1)", "expected '(' in parameterize binding"},
+		{"(parameterize ((p 1 2)) 1)", "expected ')' after parameterize binding"},
+		{"(parameterize ((p 1)))", "expected at least one expression in parameterize body"},
+	}
+	for _, tt := range errorTests {
+		_, err := New(lexer.New(strings.NewReader(tt.input))).Parse()
+		if err == nil || !strings.Contains(err.Error(), tt.expectedMessage) {
+			t.Fatalf("input %s, expected %q, got %v", tt.input, tt.expectedMessage, err)
+		}
+	}
+}
+
+func TestParser_Assert(t *testing.T) {
+	program, err := New(lexer.New(strings.NewReader("(assert (= (f 1) 2))"))).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := program.Expressions[0].String(); got != "(assert (= (f 1) 2))" {
+		t.Fatalf("expected (assert (= (f 1) 2)), got %s", got)
+	}
+
+	errorTests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"(assert)", "expected an expression after assert"},
+		{"(assert a b)", "expected ')' after the expression of assert"},
+	}
+	for _, tt := range errorTests {
+		_, err := New(lexer.New(strings.NewReader(tt.input))).Parse()
+		if err == nil || !strings.Contains(err.Error(), tt.expectedMessage) {
+			t.Fatalf("input %s, expected %q, got %v", tt.input, tt.expectedMessage, err)
+		}
+	}
+}
+
+func TestParser_EmptyCombination(t *testing.T) {
+	for _, input := range []string{"()", "(car ())", "(define (f) ())"} {
+		_, err := New(lexer.New(strings.NewReader(input))).Parse()
+		if err == nil || !strings.Contains(err.Error(), "empty combination") {
+			t.Fatalf("input %s, expected an empty combination error, got %v", input, err)
+		}
+	}
+	if _, err := New(lexer.New(strings.NewReader("(car '())"))).Parse(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestParser_ReservedWords(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"(define define 3)", "'define' is a reserved word, it can't be a variable name"},
+		{"(define (if x) x)", "'if' is a reserved word, it can't be a procedure name"},
+		{"(define (f lambda) lambda)", "'lambda' is a reserved word, it can't be a parameter name"},
+		{"(lambda (x else) x)", "'else' is a reserved word, it can't be a parameter name"},
+		{"(let ((begin 1)) begin)", "'begin' is a reserved word, it can't be bound by let"},
+		{"(letrec ((letrec 1)) 1)", "'letrec' is a reserved word, it can't be bound by letrec"},
+		{"(let* ((a 1) (define 2)) a)", "'define' is a reserved word, it can't be bound by let*"},
+		{"(letrec* ((+ 1)) +)", "'+' is a builtin, it can only be bound by letrec* when shadowing builtins is allowed"},
+		{"(set! cond 1)", "'cond' is a reserved word, it can't be assigned by set!"},
+		{"(guard (guard (#t 1)) 2)", "'guard' is a reserved word, it can't be bound by guard"},
+		{"(define parameterize 1)", "'parameterize' is a reserved word, it can't be a variable name"},
+		{"(lambda (delay-force) 1)", "'delay-force' is a reserved word, it can't be a parameter name"},
+		{"(define (assert x) x)", "'assert' is a reserved word, it can't be a procedure name"},
+		{"(lambda (not) (not 1))", "'not' is a builtin, it can only be a parameter name when shadowing builtins is allowed"},
+		{"(let ((+ 1)) +)", "'+' is a builtin, it can only be bound by let when shadowing builtins is allowed"},
+	}
+	for _, tt := range tests {
+		_, err := New(lexer.New(strings.NewReader(tt.input))).Parse()
+		if err == nil || err.Error() != tt.expectedMessage {
+			t.Fatalf("input %s, expected %q, got %v", tt.input, tt.expectedMessage, err)
+		}
+	}
+
+	// define and set! rebind a builtin, a parameter shadows one only when that's allowed
+	for _, input := range []string{"(define (not x) x)", "(set! + -)", "(define (or a b) a)", "(lambda (not) (not 1))", "(let ((+ 1) (force 2)) +)"} {
+		p := New(lexer.New(strings.NewReader(input)))
+		p.AllowShadowing()
+		if _, err := p.Parse(); err != nil {
+			t.Fatalf("input %s, unexpected error: %v", input, err)
+		}
+	}
+}
+
+func TestParser_Racket(t *testing.T) {
+	input := `#lang racket/base
+(require racket/list "helpers.rkt" (only-in "more.rkt" f))
+(provide square (all-defined-out))
+(define (square x) (cond [(< x 0) (square (- x))] [else (* x x)]))`
+	p := New(lexer.New(strings.NewReader(input)))
+	p.Racket()
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{
+		`(require racket/list "helpers.rkt" (only-in "more.rkt" f))`,
+		"(provide square (all-defined-out))",
+	}
+	for i, exp := range expected {
+		if got := program.Expressions[i].String(); got != exp {
+			t.Fatalf("expected %s, got %s", exp, got)
+		}
+	}
+	require := program.Expressions[0].(*RequireExpression)
+	if len(require.Files) != 1 || require.Files[0] != "helpers.rkt" {
+		t.Fatalf("expected the files [helpers.rkt], got %v", require.Files)
+	}
+	if _, ok := program.Expressions[2].(*DefineExpression); !ok {
+		t.Fatalf("expected a define, got %s", program.Expressions[2])
+	}
+
+	// require is an ordinary call outside of Racket mode
+	program, err = New(lexer.New(strings.NewReader("(require x)"))).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := program.Expressions[0].(*CallExpression); !ok {
+		t.Fatalf("expected a call, got %s", program.Expressions[0])
+	}
+}
+
+func TestParser_Macros(t *testing.T) {
+	swap := "(define-syntax swap! (syntax-rules () ((_ a b) (let ((tmp a)) (set! a b) (set! b tmp)))))\n"
+	tests := []struct {
+		input          string
+		expectedString string
+	}{
+		// the tmp the template introduces is renamed, the one the use passes is left alone
+		{swap + "(swap! tmp y)", "((lambda (tmp#1) (set! tmp y) (set! y tmp#1)) tmp)"},
+		{"(define-syntax my-or (syntax-rules () ((_) #f) ((_ e) e) ((_ e r ...) (let ((t e)) (if t t (my-or r ...))))))\n(my-or a b c)",
+			"((lambda (t#1) (if t#1 t#1 ((lambda (t#2) (if t#2 t#2 c)) b))) a)"},
+		{"(define-syntax pairs (syntax-rules () ((_ (a b) ...) (list (cons a b) ...))))\n(pairs (1 2) (3 4))", "(list#1 (cons#1 1 2) (cons#1 3 4))"},
+		{"(define-syntax flat (syntax-rules () ((_ (a ...) ...) '(a ... ...))))\n(flat (1 2) () (3))", "'(1 2 3)"},
+		{"(define-syntax rest (syntax-rules () ((_ a . r) 'r)))\n(rest 1 2 3)", "'(2 3)"},
+		{"(define-syntax kw (syntax-rules (=>) ((_ a => b) (b a)) ((_ a) a)))\n(kw 1 => f)", "(f 1)"},
+		{"(define-syntax three (syntax-rules ::: () ((_ a :::) (list a ::: '...))))\n(three 1 2)", "(list#1 1 2 '...)"},
+		{"(define-syntax ten (syntax-rules () ((_) 10)))\n(+ (ten) 1)", "(+ 10 1)"},
+	}
+	for _, tt := range tests {
+		program, err := New(lexer.New(strings.NewReader(tt.input))).Parse()
+		if err != nil {
+			t.Fatalf("input %s, unexpected error: %v", tt.input, err)
+		}
+		last := program.Expressions[len(program.Expressions)-1]
+		if last.String() != tt.expectedString {
+			t.Fatalf("input %s, expected %s, got %s", tt.input, tt.expectedString, last.String())
+		}
+	}
+
+	// the macros of an earlier program are expanded in the next one
+	macros := NewMacros()
+	program, err := New(lexer.New(strings.NewReader(swap))).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	define := program.Expressions[0].(*DefineSyntaxExpression)
+	macros.Define(define.Name, define.Rules)
+	p := New(lexer.New(strings.NewReader("(swap! x y)")))
+	p.UseMacros(macros)
+	program, err = p.Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := program.Expressions[0].String(); got != "((lambda (tmp#1) (set! x y) (set! y tmp#1)) x)" {
+		t.Fatalf("expected swap! to be expanded, got %s", got)
+	}
+}
+
+func TestParser_MacroErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"(define-syntax if (syntax-rules () ((_) 1)))", "'if' is a reserved word, it can't be a macro name"},
+		{"(define-syntax m (lambda (x) x))", "expected (syntax-rules (literal ...) (pattern template) ...) after define-syntax m"},
+		{"(define-syntax m (syntax-rules () ((_ a a) a)))", "pattern variable a is used twice in a pattern of m"},
+		{"(define-syntax m (syntax-rules () ((_ a ... b ...) a)))", "a list pattern can have only one ..."},
+		{"(define-syntax m (syntax-rules () ((_ a ...) a)))", "pattern variable a has to be followed by ... in the template of m"},
+		{"(define-syntax m (syntax-rules () ((_ a) (a ...))))", "... in the template of m doesn't follow a pattern variable matched under ..."},
+		{"(define-syntax m (syntax-rules () ((_ a) a))) (m)", "no syntax-rules pattern of m matches (m)"},
+		{"(define-syntax m (syntax-rules () ((_ a) (m a)))) (m 1)", "expansions of m nested more than 1000 deep, does it expand to itself?"},
+		{"(let-syntax (m (syntax-rules () ((_) 1))) (m))", "expected '(' in binding list"},
+		{"(letrec-syntax ((if (syntax-rules () ((_) 1)))) 1)", "'if' is a reserved word, it can't be a macro name"},
+		{"(let-syntax ((m (lambda (x) x))) 1)", "expected (syntax-rules (literal ...) (pattern template) ...) after let-syntax m"},
+		{"(let-syntax ((m (syntax-rules () ((_) 1)))))", "expected at least one expression in let-syntax body"},
+		{"(letrec-syntax ((m (syntax-rules () ((_ a) (m a))))) (m 1))", "expansions of m nested more than 1000 deep, does it expand to itself?"},
+	}
+	for _, tt := range tests {
+		_, err := New(lexer.New(strings.NewReader(tt.input))).Parse()
+		if err == nil || err.Error() != tt.expectedMessage {
+			t.Fatalf("input %s, expected %q, got %v", tt.input, tt.expectedMessage, err)
+		}
+	}
+}
+
+func TestParser_DefineMacro(t *testing.T) {
+	macros := NewMacros()
+	var operands []string
+	macros.SetExpander(func(name string, transformer Expression, ops []string) (string, error) {
+		operands = ops
+		switch name {
+		case "twice":
+			return fmt.Sprintf("(begin %s %s)", ops[0], ops[0]), nil
+		case "two":
+			return "(a) (b)", nil
+		}
+		return "", fmt.Errorf("%s failed", name)
+	})
+	p := New(lexer.New(strings.NewReader(`(define-macro (twice e) (list 'begin e e)) (twice (f 'x "s" #t)) (define-macro fails (lambda () 1))`)))
+	p.UseMacros(macros)
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"(define-macro (twice e) (list 'begin e e))", `(begin (f 'x "s" #t) (f 'x "s" #t))`, "(define-macro (fails) 1)"}
+	for i, exp := range expected {
+		if got := program.Expressions[i].String(); got != exp {
+			t.Fatalf("expected %s, got %s", exp, got)
+		}
+	}
+	if !reflect.DeepEqual(operands, []string{`(f 'x "s" #t)`}) {
+		t.Fatalf("expected the operand as written, got %q", operands)
+	}
+
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"(define-macro (not x) x)", "'not' is a builtin, it can't be a macro name"},
+		{"(define-macro (two) 1) (two)", "the expansion of two, (a) (b), is more than one form"},
+		{"(define-macro (m) 1) (m)", "expanding m: m failed"},
+		{"(define-macro (twice . e) 1) (twice 1 . 2)", "a use of twice can't be a dotted list"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(strings.NewReader(tt.input)))
+		p.UseMacros(macros)
+		_, err := p.Parse()
+		if err == nil || err.Error() != tt.expectedMessage {
+			t.Fatalf("input %s, expected %q, got %v", tt.input, tt.expectedMessage, err)
+		}
+	}
+
+	// without an evaluator there is nothing to call the transformer
+	_, err = New(lexer.New(strings.NewReader("(define-macro (m) 1) (m)"))).Parse()
+	if err == nil || !strings.Contains(err.Error(), "m is a define-macro") {
+		t.Fatalf("expected m not to be expandable, got %v", err)
+	}
+}
+
+func TestIntroducedName(t *testing.T) {
+	tests := []struct {
+		name       string
+		original   string
+		introduced bool
+	}{
+		{"tmp#3", "tmp", true},
+		{"loop#1#12", "loop#1", true},
+		{"tmp", "tmp", false},
+		{"a#b", "a#b", false},
+		{"#1", "#1", false},
+		{"x#", "x#", false},
+	}
+	for _, tt := range tests {
+		original, introduced := IntroducedName(tt.name)
+		if original != tt.original || introduced != tt.introduced {
+			t.Fatalf("IntroducedName(%q) = %q, %t, want %q, %t", tt.name, original, introduced, tt.original, tt.introduced)
+		}
+	}
+}
+
+func TestParser_Feed(t *testing.T) {
+	datum := struct{ name string }{"value"}
+	tokens := []lexer.Token{
+		{Content: "(", TokenType: lexer.TokenTypeLeftParen},
+		{Content: "f", TokenType: lexer.TokenTypeIdentifier},
+		{TokenType: lexer.TokenTypeDatum, Datum: &datum},
+		{Content: "'", TokenType: lexer.TokenTypeQuote},
+		{TokenType: lexer.TokenTypeDatum, Datum: &datum},
+		{Content: ")", TokenType: lexer.TokenTypeRightParen},
+	}
+	p := New(lexer.New(strings.NewReader("(g)")))
+	p.Feed(tokens)
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(program.Expressions) != 2 {
+		t.Fatalf("expected the fed form and then the lexer's, got %d expressions", len(program.Expressions))
+	}
+	call, ok := program.Expressions[0].(*CallExpression)
+	if !ok || len(call.Operands) != 2 {
+		t.Fatalf("expected a call of two operands, got %s", program.Expressions[0])
+	}
+	for _, operand := range call.Operands {
+		if exp, ok := operand.(*DatumExpression); !ok || exp.Value != &datum {
+			t.Fatalf("expected the datum itself, got %#v", operand)
+		}
+	}
+}